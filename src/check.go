@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/pkg/client"
+	"github.com/7alva7/hubproxy/src/utils"
+)
+
+// 诊断命令的退出码
+const (
+	checkExitOK     = 0
+	checkExitFailed = 1
+	checkExitUsage  = 2
+)
+
+// checkReport check命令的输出，--json时原样输出
+type checkReport struct {
+	Kind   string               `json:"kind"`
+	Target string               `json:"target"`
+	Access *client.AccessResult `json:"access,omitempty"`
+	URL    *client.URLProbe     `json:"url,omitempty"`
+	Image  *client.ImageProbe   `json:"image,omitempty"`
+	OK     bool                 `json:"ok"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// runCheck 实现 hubproxy check <链接或镜像>：经运行中的实例做访问检查并实际请求一次，返回退出码
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "", "实例地址，如 http://127.0.0.1:5000/hub，默认按配置文件的监听端口和basePath")
+	token := flags.String("token", "", "私有实例的访问令牌")
+	adminToken := flags.String("admin-token", "", "管理令牌，用于取得上游重定向链，默认读取配置文件")
+	asJSON := flags.Bool("json", false, "以JSON输出")
+	timeout := flags.Duration("timeout", 30*time.Second, "整个检查的超时")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "用法: hubproxy check [选项] <链接或镜像>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return checkExitUsage
+	}
+	// 允许选项写在目标之后
+	target := flags.Arg(0)
+	if flags.NArg() > 1 {
+		if err := flags.Parse(flags.Args()[1:]); err != nil || flags.NArg() > 0 {
+			flags.Usage()
+			return checkExitUsage
+		}
+	}
+	if target == "" {
+		flags.Usage()
+		return checkExitUsage
+	}
+
+	if err := config.LoadConfig(); err != nil {
+		fmt.Fprintf(stderr, "配置加载失败，使用默认配置: %v\n", err)
+	}
+	if *addr == "" {
+		*addr = defaultCheckAddr(config.GetConfig())
+	}
+	if *adminToken == "" {
+		if cfg := config.GetConfig(); cfg.Admin.Enabled {
+			*adminToken = cfg.Admin.Token
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(*addr, client.WithToken(*token), client.WithAdminToken(*adminToken))
+	report := checkTarget(ctx, c, target)
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printCheckReport(stdout, report)
+	}
+	if !report.OK {
+		return checkExitFailed
+	}
+	return checkExitOK
+}
+
+// defaultCheckAddr 按配置的监听端口和basePath拼出本机实例地址
+func defaultCheckAddr(cfg *config.AppConfig) string {
+	host := cfg.Server.Host
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(cfg.Server.Port)) + utils.BasePath(cfg)
+}
+
+// checkTarget 先按代理链接做访问检查，匹配到链接规则或带协议头时按链接处理，否则按镜像处理
+func checkTarget(ctx context.Context, c *client.Client, target string) *checkReport {
+	report := &checkReport{Kind: client.AccessKindURL, Target: target}
+	access, err := c.CheckAccess(ctx, client.AccessKindURL, target)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if access.Pattern == "" && !strings.Contains(target, "://") {
+		report.Kind = client.AccessKindImage
+		if access, err = c.CheckAccess(ctx, client.AccessKindImage, target); err != nil {
+			report.Error = err.Error()
+			return report
+		}
+	}
+	report.Access = access
+	if !access.Allowed {
+		report.Error = "访问被拒绝: " + access.Reason
+		return report
+	}
+
+	if report.Kind == client.AccessKindImage {
+		if report.Image, err = c.ProbeImage(ctx, target); err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		report.OK = true
+		return report
+	}
+
+	if report.URL, err = c.ProbeURL(ctx, target); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if report.URL.Status >= 400 {
+		report.Error = "代理返回 " + strconv.Itoa(report.URL.Status)
+		return report
+	}
+	report.OK = true
+	return report
+}
+
+// printCheckReport 以文本输出检查结果
+func printCheckReport(w io.Writer, report *checkReport) {
+	if report.Kind == client.AccessKindImage {
+		fmt.Fprintf(w, "镜像: %s\n", report.Target)
+	} else {
+		fmt.Fprintf(w, "链接: %s\n", report.Target)
+	}
+
+	if access := report.Access; access != nil {
+		if access.Allowed {
+			fmt.Fprintln(w, "访问控制: 允许")
+		} else {
+			fmt.Fprintf(w, "访问控制: 拒绝 (%s)\n", access.Reason)
+		}
+		if access.Pattern != "" {
+			fmt.Fprintf(w, "匹配规则: %s\n", access.Pattern)
+		}
+		if access.Upstream != "" {
+			fmt.Fprintf(w, "上游地址: %s\n", access.Upstream)
+		}
+	}
+
+	if probe := report.URL; probe != nil {
+		for _, hop := range probe.Redirects {
+			fmt.Fprintf(w, "重定向: %d %s %s\n", hop.Status, hop.Method, hop.URL)
+		}
+		for _, hop := range probe.Upstream {
+			fmt.Fprintf(w, "上游请求: %d %s %s\n", hop.Status, hop.Method, hop.URL)
+		}
+		if len(probe.Upstream) == 0 && probe.Hops > 0 {
+			fmt.Fprintf(w, "上游请求: %d 跳 (使用管理令牌可查看完整重定向链)\n", probe.Hops)
+		}
+		fmt.Fprintf(w, "状态: %d\n", probe.Status)
+		if probe.Size >= 0 {
+			fmt.Fprintf(w, "大小: %s\n", formatCheckSize(probe.Size))
+		} else {
+			fmt.Fprintln(w, "大小: 未知")
+		}
+		if probe.ContentType != "" {
+			fmt.Fprintf(w, "类型: %s\n", probe.ContentType)
+		}
+		cache := probe.Cache
+		if cache == "" {
+			cache = "未缓存"
+		}
+		fmt.Fprintf(w, "缓存: %s\n", cache)
+	}
+
+	if image := report.Image; image != nil {
+		fmt.Fprintf(w, "摘要: %s\n", image.Digest)
+		fmt.Fprintf(w, "类型: %s\n", image.MediaType)
+		for _, platform := range image.Platforms {
+			name := platform.Platform
+			if name == "" {
+				name = "(单平台)"
+			}
+			fmt.Fprintf(w, "平台: %s %s %s, %d 层\n", name, platform.Digest, formatCheckSize(platform.Size), len(platform.Layers))
+			for _, layer := range platform.Layers {
+				fmt.Fprintf(w, "  %s %s\n", layer.Digest, formatCheckSize(layer.Size))
+			}
+		}
+	}
+
+	if report.Error != "" {
+		fmt.Fprintf(w, "失败: %s\n", report.Error)
+	}
+}
+
+// formatCheckSize 以二进制单位格式化字节数，如 1.5 MiB
+func formatCheckSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
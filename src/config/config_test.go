@@ -1,41 +0,0 @@
-package config
-
-import (
-	"os"
-	"path/filepath"
-	"testing"
-)
-
-func TestLoadConfigUsesConfigPathAndEnvOverrides(t *testing.T) {
-	path := filepath.Join(t.TempDir(), "custom.toml")
-	data := []byte(`
-[server]
-host = "127.0.0.1"
-port = 5999
-
-[access]
-proxy = "socks5://127.0.0.1:1080"
-`)
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		t.Fatal(err)
-	}
-
-	t.Setenv("CONFIG_PATH", path)
-	t.Setenv("SERVER_PORT", "6001")
-	t.Setenv("ACCESS_PROXY", "")
-
-	if err := LoadConfig(); err != nil {
-		t.Fatal(err)
-	}
-
-	cfg := GetConfig()
-	if cfg.Server.Host != "127.0.0.1" {
-		t.Fatalf("Server.Host = %q", cfg.Server.Host)
-	}
-	if cfg.Server.Port != 6001 {
-		t.Fatalf("Server.Port = %d, want 6001", cfg.Server.Port)
-	}
-	if cfg.Access.Proxy != "" {
-		t.Fatalf("Access.Proxy = %q, want empty override", cfg.Access.Proxy)
-	}
-}
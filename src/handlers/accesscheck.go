@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// 访问检查的目标类别
+const (
+	AccessKindImage  = "image"
+	AccessKindGitHub = "github"
+	AccessKindURL    = "url"
+)
+
+// accessCheckResult /api/access 的响应，reason为拒绝时的错误码；
+// 检查链接时pattern为匹配的链接规则，upstream为改写后实际请求的上游地址
+type accessCheckResult struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Allowed  bool   `json:"allowed"`
+	Reason   string `json:"reason,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// handleAccessCheck 按黑白名单检查镜像、GitHub仓库或代理链接是否允许代理，image、github和url参数三选一
+func (h *Handlers) handleAccessCheck(c *gin.Context) {
+	image := strings.TrimSpace(c.Query("image"))
+	repo := strings.Trim(strings.TrimSpace(c.Query("github")), "/")
+	link := strings.TrimSpace(c.Query("url"))
+
+	given := 0
+	for _, value := range []string{image, repo, link} {
+		if value != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "需要image、github或url参数之一")
+		return
+	}
+
+	var result accessCheckResult
+	switch {
+	case link != "":
+		result = h.checkURLAccess(link)
+	case image != "":
+		if _, err := name.ParseReference(image); err != nil {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "镜像引用格式错误: "+err.Error())
+			return
+		}
+		result = accessCheckResult{Kind: AccessKindImage, Target: image}
+		result.Allowed, result.Reason = h.Access.CheckDockerAccess(image)
+	default:
+		owner, repoName, found := strings.Cut(repo, "/")
+		if !found || owner == "" || repoName == "" || strings.Contains(repoName, "/") {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "github参数应为owner/repo")
+			return
+		}
+		result = accessCheckResult{Kind: AccessKindGitHub, Target: repo}
+		result.Allowed, result.Reason = h.Access.CheckGitHubAccess([]string{owner, repoName})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// checkURLAccess 按代理链接的处理流程做一次演练：匹配链接规则、检查名单并改写上游地址，不请求上游
+func (h *Handlers) checkURLAccess(link string) accessCheckResult {
+	result := accessCheckResult{Kind: AccessKindURL, Target: link}
+	target, matchPath, err := githubproxy.NormalizeRequestURI("/" + strings.TrimLeft(link, "/"))
+	if err != nil {
+		result.Reason = utils.ErrCodeInvalidInput
+		return result
+	}
+	match := githubproxy.MatchURL(matchPath)
+	if match == nil {
+		result.Reason = utils.ErrCodeInvalidInput
+		return result
+	}
+	result.Pattern = match.Pattern()
+	result.Allowed, result.Reason = match.CheckAccess(h.Access)
+	if result.Allowed {
+		result.Upstream = match.Rewrite(target)
+	}
+	return result
+}
+
+// InitAccessCheckRoutes 注册访问检查路由
+func (h *Handlers) InitAccessCheckRoutes(router *gin.Engine) {
+	router.GET("/api/access", h.handleAccessCheck)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["blocked/repo", "library/badimage"]
+`)
+	h := newTestHandlers()
+	router := gin.New()
+	h.InitAccessCheckRoutes(router)
+
+	tests := []struct {
+		query   string
+		status  int
+		allowed bool
+		reason  string
+	}{
+		{"github=owner/repo", http.StatusOK, true, ""},
+		{"github=/blocked/repo/", http.StatusOK, false, utils.ErrCodeGitHubBlacklisted},
+		{"image=nginx:latest", http.StatusOK, true, ""},
+		{"image=badimage", http.StatusOK, false, utils.ErrCodeDockerBlacklisted},
+		{"github=owner", http.StatusBadRequest, false, ""},
+		{"image=Bad::Ref", http.StatusBadRequest, false, ""},
+		{"image=nginx&github=owner/repo", http.StatusBadRequest, false, ""},
+		{"url=https://github.com/owner/repo/releases/download/v1/app.tgz", http.StatusOK, true, ""},
+		{"url=github.com/blocked/repo/archive/main.zip", http.StatusOK, false, utils.ErrCodeGitHubBlacklisted},
+		{"url=https://example.com/file", http.StatusOK, false, utils.ErrCodeInvalidInput},
+		{"url=x&image=nginx", http.StatusBadRequest, false, ""},
+		{"", http.StatusBadRequest, false, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/access?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Fatalf("%q status %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var result accessCheckResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != tt.allowed || result.Reason != tt.reason {
+			t.Errorf("%q = %+v", tt.query, result)
+		}
+	}
+}
+
+func TestAccessCheckURLPattern(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, ``)
+	h := newTestHandlers()
+	router := gin.New()
+	h.InitAccessCheckRoutes(router)
+
+	query := url.Values{"url": {"https://github.com/owner/repo/blob/main/README.md?plain=1"}}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/access?"+query.Encode(), nil))
+	var result accessCheckResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Kind != AccessKindURL || !result.Allowed || result.Pattern != "github.com/{owner}/{repo}/{blob,raw}/..." ||
+		result.Upstream != "https://github.com/owner/repo/raw/main/README.md?plain=1" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestAccessCheckMixedCaseURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["someorg/myrepo", "BigCorp/*", "日本/*"]
+`)
+	h := newTestHandlers()
+	router := gin.New()
+	h.InitAccessCheckRoutes(router)
+
+	tests := []struct {
+		link     string
+		allowed  bool
+		upstream string
+	}{
+		{"github.com/SomeOrg/MyRepo/releases/download/v1/a.tgz", false, ""},
+		{"https://github.com/SOMEORG/myrepo/archive/main.zip", false, ""},
+		{"github.com/s%6FmeOrg/MyRepo/archive/main.zip", false, ""},
+		{"raw.githubusercontent.com/bigcorp/tool/main/install.sh", false, ""},
+		{"huggingface.co/BIGCORP/Model/resolve/main/config.json", false, ""},
+		{"github.com/%E6%97%A5%E6%9C%AC/repo/archive/main.zip", false, ""},
+		{"github.com/%FF%FE/repo/releases/download/v1/a.tgz", true, "https://github.com/%FF%FE/repo/releases/download/v1/a.tgz"},
+		{"github.com/Other/MyRepo/releases/download/v1/A.tgz", true, "https://github.com/Other/MyRepo/releases/download/v1/A.tgz"},
+		{"huggingface.co/Other/Model/resolve/main/x", true, "https://huggingface.co/Other/Model/resolve/main/x"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/access?url="+url.QueryEscape(tt.link), nil))
+		var result accessCheckResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %d %s", tt.link, w.Code, w.Body.String())
+		}
+		if result.Allowed != tt.allowed || result.Upstream != tt.upstream {
+			t.Errorf("%s = %+v", tt.link, result)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 请求动态类别
+const (
+	ActivityClassDocker   = "docker"
+	ActivityClassAuth     = "auth"
+	ActivityClassImageTar = "image-tar"
+	ActivityClassSearch   = "search"
+	ActivityClassGitHub   = "github"
+)
+
+const (
+	activityBufferSize        = 64
+	activityHeartbeatInterval = 15 * time.Second
+)
+
+// classifyActivity 识别请求的资源类别和目标(owner/repo或镜像名)，无需记录的请求返回空类别
+func classifyActivity(c *gin.Context) (string, string) {
+	path := c.Request.URL.Path
+
+	switch {
+	case path == "/api/events" || path == "/api/stats" || path == "/api/capabilities" || path == "/api/routes" || path == "/api/openapi.json" || path == "/api/access" || strings.HasPrefix(path, "/api/history") || path == "/metrics" || path == "/ready" || path == "/health" || path == "/health/summary" || path == "/status" || path == "/" || path == "/favicon.ico" || path == "/robots.txt" ||
+		strings.HasSuffix(path, ".html") || strings.HasPrefix(path, "/public/") ||
+		strings.HasPrefix(path, "/admin/"):
+		return "", ""
+	case strings.HasPrefix(path, "/v2/"):
+		pathWithoutV2 := strings.TrimPrefix(path, "/v2/")
+		imageName, _, _ := dockerproxy.ParseRegistryPath(pathWithoutV2)
+		return ActivityClassDocker, imageName
+	case path == "/token" || strings.HasPrefix(path, "/token/"):
+		return ActivityClassAuth, strings.TrimSuffix(strings.TrimPrefix(c.Query("scope"), "repository:"), ":pull")
+	case strings.HasPrefix(path, "/api/image/") || path == "/api/install-script":
+		return ActivityClassImageTar, strings.ReplaceAll(c.Param("image"), "_", "/")
+	case path == "/search":
+		return ActivityClassSearch, c.Query("q")
+	}
+
+	_, matchPath, err := githubproxy.NormalizeRequestURI(c.Request.URL.RequestURI())
+	if err != nil {
+		return "", ""
+	}
+	if matches := githubproxy.CheckURL(matchPath); len(matches) >= 2 {
+		return ActivityClassGitHub, matches[0] + "/" + strings.TrimSuffix(matches[1], ".git")
+	}
+	return "", ""
+}
+
+// ActivityMiddleware 记录已完成请求的耗时和流量统计，超过阈值的请求写入慢请求日志，并按采样比例推送到请求动态
+func (h *Handlers) ActivityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, traffic := utils.WithTrafficTag(c.Request.Context())
+		// 启用慢请求日志时记录上游各阶段耗时
+		threshold, samples := utils.SlowRequestSettings(h.Config.Get())
+		var timing *utils.RequestTiming
+		if threshold > 0 {
+			ctx, timing = utils.WithRequestTiming(ctx)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if status := c.Writer.Status(); utils.IsDenialStatus(status) {
+			h.Denials.Record(utils.DenialEvent{
+				Time:     start,
+				ClientIP: h.IPs.Identify(c.ClientIP(), false),
+				Method:   c.Request.Method,
+				Path:     c.Request.URL.Path,
+				Status:   status,
+				Code:     utils.ErrorCode(c),
+			})
+		}
+
+		class, target := classifyActivity(c)
+		outcome := utils.CacheOutcome(c)
+		if class == "" {
+			upstream := traffic.Finish(utils.TrafficRouteOther, outcome)
+			h.Stats.AddUpstreamBytes(utils.TrafficRouteOther, outcome, upstream)
+			h.Schedule.RecordRequest(c, upstream)
+			return
+		}
+
+		duration := time.Since(start)
+		size := int64(c.Writer.Size())
+		if size < 0 {
+			size = 0
+		}
+		h.Stats.Record(class, h.upstreamHostFor(c, class), c.Writer.Status(), duration, size)
+		upstream := traffic.Finish(class, outcome)
+		h.Stats.RecordTraffic(class, outcome, size, upstream)
+		h.Schedule.RecordRequest(c, upstream)
+		h.SlowRequests.Observe(timing, duration, threshold, samples, func(entry *utils.SlowRequestEntry) {
+			entry.Method = c.Request.Method
+			entry.Path = c.Request.URL.Path
+			entry.Route = class
+			entry.Target = target
+			entry.Status = c.Writer.Status()
+			entry.Bytes = size
+			entry.UpstreamBytes = upstream
+			entry.Cache = outcome
+			// 客户端拒绝被记录时不保存客户端信息
+			if !utils.DoNotTrack(c) {
+				entry.ClientIP = h.IPs.Identify(c.ClientIP(), false)
+				entry.UserAgent = c.Request.UserAgent()
+			}
+		})
+
+		// 客户端拒绝被记录时只计入不含客户端信息的汇总统计
+		if utils.DoNotTrack(c) {
+			return
+		}
+		h.recordUsage(c, class, target, size)
+		if class == ActivityClassGitHub {
+			h.recordGitHubHistory(c)
+		}
+
+		if !h.Activity.HasSubscribers() {
+			return
+		}
+		cfg := h.Config.Get()
+		if !cfg.UI.ActivityFeed {
+			return
+		}
+		if rate := cfg.UI.ActivitySampleRate; rate < 1 && rand.Float64() >= rate {
+			return
+		}
+
+		h.Activity.Publish(utils.ActivityEvent{
+			Time:       start,
+			ClientIP:   h.IPs.Identify(c.ClientIP(), cfg.UI.AnonymizeIP),
+			Class:      class,
+			Target:     target,
+			Status:     c.Writer.Status(),
+			Bytes:      size,
+			DurationMs: duration.Milliseconds(),
+		})
+	}
+}
+
+// recordUsage 记录用量排行，客户端IP始终脱敏
+func (h *Handlers) recordUsage(c *gin.Context, class, target string, size int64) {
+	h.Usage.Record(utils.UsageByIP, h.IPs.Identify(c.ClientIP(), true), size)
+	switch class {
+	case ActivityClassGitHub:
+		h.Usage.Record(utils.UsageByRepo, target, size)
+	case ActivityClassDocker, ActivityClassImageTar:
+		h.Usage.Record(utils.UsageByImage, target, size)
+	}
+}
+
+// handleActivityEvents 以SSE推送实时请求动态
+func (h *Handlers) handleActivityEvents(c *gin.Context) {
+	cfg := h.Config.Get()
+	if !cfg.UI.ActivityFeed {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeFeatureDisabled, "请求动态未启用")
+		return
+	}
+
+	events, unsubscribe, ok := h.Activity.Subscribe(activityBufferSize)
+	if !ok {
+		utils.RespondError(c, http.StatusServiceUnavailable, utils.ErrCodeTooManySubscribers)
+		return
+	}
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(activityHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("request", event)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
+
+// InitActivityRoutes 注册请求动态路由
+func (h *Handlers) InitActivityRoutes(router *gin.Engine) {
+	router.GET("/api/events", h.uiRouteGuard, h.handleActivityEvents)
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestClassifyActivity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		url    string
+		class  string
+		target string
+	}{
+		{"/v2/library/nginx/manifests/latest", ActivityClassDocker, "library/nginx"},
+		{"/token?scope=repository:library/nginx:pull", ActivityClassAuth, "library/nginx"},
+		{"/search?q=redis", ActivityClassSearch, "redis"},
+		{"/https://github.com/owner/repo/releases/download/v1/app.tar.gz", ActivityClassGitHub, "owner/repo"},
+		{"/github.com/owner/repo.git/info/refs", ActivityClassGitHub, "owner/repo"},
+		{"/api/events", "", ""},
+		{"/public/app.js", "", ""},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, tt.url, nil)
+		class, target := classifyActivity(c)
+		if class != tt.class || target != tt.target {
+			t.Fatalf("classifyActivity(%q) = %q %q, want %q %q", tt.url, class, target, tt.class, tt.target)
+		}
+	}
+}
+
+// captureStdout 捕获fn执行期间写入标准输出的日志
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestNoIPLoggingKeepsRawIPOutOfSinks(t *testing.T) {
+	loadTestConfig(t, `
+[admin]
+enabled = true
+token = "secret"
+
+[privacy]
+noIPLogging = true
+`)
+	h := newTestHandlers()
+	const rawIP = "198.51.100.7"
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ratelimit.Middleware(h.Limiter), h.ActivityMiddleware())
+	router.GET("/v2/*path", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	h.InitAdminRoutes(router)
+
+	events, unsubscribe, ok := h.Activity.Subscribe(4)
+	if !ok {
+		t.Fatal("subscribe failed")
+	}
+	defer unsubscribe()
+
+	logs := captureStdout(t, func() {
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil),
+			httptest.NewRequest(http.MethodDelete, "/admin/cache?type=token", nil),
+		} {
+			req.RemoteAddr = rawIP + ":40000"
+			req.Header.Set("X-Admin-Token", "secret")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+
+	if strings.Contains(logs, rawIP) || !strings.Contains(logs, "管理操作: anon-") {
+		t.Fatalf("logs leak raw IP or miss audit entry:\n%s", logs)
+	}
+	event := <-events
+	if strings.Contains(event.ClientIP, "198.51.100") {
+		t.Fatalf("activity event client IP = %q", event.ClientIP)
+	}
+	for _, entry := range h.Usage.Top(utils.UsageByIP, time.Hour, utils.UsageSortRequests, 100) {
+		if strings.Contains(entry.Key, "198.51.100") {
+			t.Fatalf("usage key %q contains raw IP", entry.Key)
+		}
+	}
+}
+
+func TestDoNotTrackSkipsActivity(t *testing.T) {
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(h.ActivityMiddleware())
+	router.GET("/v2/*path", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	events, unsubscribe, ok := h.Activity.Subscribe(4)
+	if !ok {
+		t.Fatal("subscribe failed")
+	}
+	defer unsubscribe()
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/redis/manifests/latest", nil)
+	req.Header.Set("Sec-GPC", "1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected activity event %+v", event)
+	default:
+	}
+}
+
+func TestSlowRequestLogAttributesUpstreamTTFB(t *testing.T) {
+	const upstreamDelay = 300 * time.Millisecond
+	loadTestConfig(t, "[log]\nslowRequestThreshold = \"200ms\"\n")
+	h := newTestHandlers()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(upstreamDelay)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("payload"))
+	}))
+	defer upstream.Close()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(h.ActivityMiddleware())
+	router.GET("/github.com/*path", func(c *gin.Context) {
+		h.GitHub.ProxyRequest(c, upstream.URL+"/file.bin?token=secret")
+	})
+
+	logs := captureStdout(t, func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/github.com/owner/repo/releases/download/v1/file.bin", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d", w.Code)
+		}
+	})
+
+	_, line, found := strings.Cut(logs, "慢请求: ")
+	if !found {
+		t.Fatalf("no slow request log:\n%s", logs)
+	}
+	line, _, _ = strings.Cut(line, "\n")
+	var entry utils.SlowRequestEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	if entry.Route != ActivityClassGitHub || entry.Target != "owner/repo" || entry.Status != http.StatusOK ||
+		entry.Bytes != 7 || entry.UpstreamBytes != 7 || entry.Upstreams != 1 || entry.Upstream != upstream.URL+"/file.bin" {
+		t.Fatalf("entry = %+v", entry)
+	}
+	// 时间花在等待上游首字节上，而不是连接或传输
+	phases := entry.Phases
+	if phases.TTFB < float64(upstreamDelay/time.Millisecond) || phases.Connect >= phases.TTFB || phases.Transfer >= phases.TTFB || phases.TLS != 0 {
+		t.Fatalf("phases = %+v", phases)
+	}
+	if slow := h.SlowRequests.Snapshot(h.Config.Get()).Slow; len(slow) == 0 || slow[0].Path != entry.Path {
+		t.Fatalf("slow request not kept: %+v", slow)
+	}
+}
+
+func TestCachedManifestTrafficAccounting(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	loadTestConfig(t, fmt.Sprintf("[registries.%q]\nupstream = %q\nenabled = true\n", host, host))
+	h := newTestHandlers()
+
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(host + "/org/app:v1")
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(h.ActivityMiddleware())
+	router.GET("/v2/*path", h.Docker.RegistryHandler)
+	serve := func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/"+host+"/org/app/manifests/v1", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+	traffic := func(outcome string) utils.TrafficStats {
+		return h.Stats.TrafficSnapshot()[ActivityClassDocker][outcome]
+	}
+
+	missBefore := traffic(utils.CacheMiss)
+	serve()
+	missAfter := traffic(utils.CacheMiss)
+	if missAfter.Requests != missBefore.Requests+1 || missAfter.UpstreamBytes <= missBefore.UpstreamBytes ||
+		missAfter.DownstreamBytes <= missBefore.DownstreamBytes {
+		t.Fatalf("miss traffic = %+v, before %+v", missAfter, missBefore)
+	}
+
+	hitBefore := traffic(utils.CacheHit)
+	serve()
+	hitAfter := traffic(utils.CacheHit)
+	if hitAfter.Requests != hitBefore.Requests+1 || hitAfter.DownstreamBytes <= hitBefore.DownstreamBytes {
+		t.Fatalf("hit traffic = %+v, before %+v", hitAfter, hitBefore)
+	}
+	if hitAfter.UpstreamBytes != hitBefore.UpstreamBytes || traffic(utils.CacheMiss).UpstreamBytes != missAfter.UpstreamBytes {
+		t.Fatalf("cache hit counted upstream bytes: hit %+v, miss %+v", hitAfter, traffic(utils.CacheMiss))
+	}
+}
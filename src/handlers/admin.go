@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// adminTokenFromRequest 从请求头或token查询参数中提取管理令牌，请求头优先
+func adminTokenFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if token := strings.TrimSpace(c.GetHeader("X-Admin-Token")); token != "" {
+		return token
+	}
+	return strings.TrimSpace(c.Query("token"))
+}
+
+// adminIdleReset 桶在一分钟内回满，超过该时间未访问的客户端与新客户端等价，可以直接删除
+const adminIdleReset = time.Minute
+
+// adminRateLimiter 按客户端限制管理接口的请求速率，各客户端的额度互不影响，配置的速率变化时重建
+type adminRateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	clients   map[string]*adminBucket
+	lastSweep time.Time
+}
+
+// adminBucket 单个客户端的限流桶
+type adminBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// allow 检查client的管理接口请求速率，perMinute小于等于0时不限制；超出时返回攒够一次请求额度还需等待的时长
+func (l *adminRateLimiter) allow(client string, perMinute int, now time.Time) (time.Duration, bool) {
+	if perMinute <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	if l.clients == nil || l.perMinute != perMinute {
+		l.perMinute = perMinute
+		l.clients = make(map[string]*adminBucket)
+	}
+	if now.Sub(l.lastSweep) > adminIdleReset {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > adminIdleReset {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	bucket, exists := l.clients[client]
+	if !exists {
+		bucket = &adminBucket{limiter: rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)}
+		l.clients[client] = bucket
+	}
+	bucket.lastSeen = now
+	l.mu.Unlock()
+
+	if !bucket.limiter.AllowN(now, 1) {
+		return utils.BucketRetryAfter(bucket.limiter, 1), false
+	}
+	return 0, true
+}
+
+// AdminAuthMiddleware 管理接口鉴权中间件，未启用时返回404；
+// 管理接口不占用公共限流额度，由requestsPerMinute按客户端IP单独限流，校验令牌前先计数以限制猜测，
+// 其他客户端耗尽自己的额度不影响管理员访问
+func (h *Handlers) AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := h.Config.Get()
+		if !cfg.Admin.Enabled || cfg.Admin.Token == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if wait, allowed := h.adminLimiter.allow(h.IPs.Client(c), cfg.Admin.RequestsPerMinute, time.Now()); !allowed {
+			utils.SetRetryAfter(c, wait)
+			utils.RespondError(c, http.StatusTooManyRequests, utils.ErrCodeRateLimited)
+			return
+		}
+
+		token := adminTokenFromRequest(c)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) != 1 {
+			utils.RespondError(c, http.StatusUnauthorized, utils.ErrCodeAdminTokenInvalid)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// InitAdminRoutes 注册管理接口路由
+func (h *Handlers) InitAdminRoutes(router *gin.Engine) {
+	adminAPI := router.Group("/admin", h.AdminAuthMiddleware())
+	{
+		adminAPI.GET("/overview", h.handleAdminOverview)
+		adminAPI.GET("/jobs", h.handleListTarJobs)
+		adminAPI.GET("/debounce", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Coalescers.Stats())
+		})
+		adminAPI.GET("/crawlers", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Stats.CrawlerStats())
+		})
+		adminAPI.GET("/ratelimit", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Limiter.Stats())
+		})
+		adminAPI.GET("/upstreams/tls", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"upstreams": h.Clients.UpstreamTLSSnapshot()})
+		})
+		adminAPI.GET("/slow-requests", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.SlowRequests.Snapshot(h.Config.Get()))
+		})
+		adminAPI.DELETE("/cache", h.handleFlushCache)
+		adminAPI.GET("/cache/stats", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Cache.Stats())
+		})
+		adminAPI.GET("/cache/hot", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Hot.Stats())
+		})
+		adminAPI.POST("/cache/purge", h.handlePurgeCache)
+		h.registerCacheTransferRoutes(adminAPI, h.Hot)
+		adminAPI.GET("/screening", h.handleScreeningStatus)
+		adminAPI.POST("/screening/flag", h.handleScreeningFlag)
+		adminAPI.DELETE("/screening/flag", h.handleScreeningUnflag)
+		adminAPI.POST("/prefetch", h.handlePrefetch)
+		adminAPI.GET("/prefetch/:jobid", h.handlePrefetchStatus)
+		adminAPI.GET("/tokens", h.handleListTokens)
+		adminAPI.POST("/tokens", h.handleCreateToken)
+		adminAPI.DELETE("/tokens/:name", h.handleRevokeToken)
+		adminAPI.GET("/state/export", h.handleStateExport)
+		adminAPI.GET("/usage/top", h.handleUsageTop)
+		adminAPI.POST("/state/import", h.handleStateImport)
+		adminAPI.POST("/reload", func(c *gin.Context) {
+			if err := utils.ReloadWithNotify(h.Reloader.Reload); err != nil {
+				utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "配置重载失败: "+err.Error())
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "reloaded", "changed": h.Reloader.LastReload().Changed})
+		})
+		adminAPI.GET("/reload", func(c *gin.Context) {
+			c.JSON(http.StatusOK, h.Reloader.LastReload())
+		})
+	}
+}
+
+// cacheFlushPrefixes 可按类别清除的缓存
+var cacheFlushPrefixes = map[string]string{
+	"all":       "",
+	"token":     utils.TokenCachePrefix,
+	"manifest":  utils.ManifestCachePrefix,
+	"negative":  utils.NegativeManifestCachePrefix,
+	"githubapi": utils.GitHubAPICachePrefix,
+	"blob":      utils.BlobHotCachePrefix,
+	"ghasset":   utils.GitHubAssetHotCachePrefix,
+}
+
+// handleFlushCache 按类别清除缓存，type参数可选 all/token/manifest/negative/githubapi/blob/ghasset，
+// 内存中的热点对象随同清除
+func (h *Handlers) handleFlushCache(c *gin.Context) {
+	cacheType := c.DefaultQuery("type", "all")
+	prefix, exists := cacheFlushPrefixes[cacheType]
+	if !exists {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "不支持的缓存类型: "+cacheType)
+		return
+	}
+
+	removed := h.Cache.Flush(prefix) + h.Hot.Flush(prefix)
+	fmt.Printf("管理操作: %s 清除缓存 type=%s，共 %d 项\n", h.IPs.Client(c), cacheType, removed)
+	c.JSON(http.StatusOK, gin.H{"type": cacheType, "removed": removed})
+}
+
+// handlePurgeCache 按类别、key通配符和写入时间批量清除缓存
+func (h *Handlers) handlePurgeCache(c *gin.Context) {
+	var req struct {
+		Type      string `json:"type"`
+		Pattern   string `json:"pattern"`
+		OlderThan string `json:"older_than"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = "all"
+	}
+	prefix, exists := cacheFlushPrefixes[req.Type]
+	if !exists {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "不支持的缓存类型: "+req.Type)
+		return
+	}
+
+	filter := utils.CachePurgeFilter{Prefix: prefix, Pattern: req.Pattern}
+	if req.OlderThan != "" {
+		olderThan, err := time.ParseDuration(req.OlderThan)
+		if err != nil || olderThan <= 0 {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "older_than参数无效")
+			return
+		}
+		filter.OlderThan = olderThan
+	}
+
+	result, err := h.Cache.Purge(filter)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
+		return
+	}
+	hot, _ := h.Hot.Purge(filter)
+	result.Entries += hot.Entries
+	result.Bytes += hot.Bytes
+
+	fmt.Printf("管理操作: %s 批量清除缓存 type=%s pattern=%q older_than=%s，共 %d 项 %d 字节\n",
+		h.IPs.Client(c), req.Type, req.Pattern, req.OlderThan, result.Entries, result.Bytes)
+	c.JSON(http.StatusOK, result)
+}
+
+// parseTokenExpiry 解析令牌过期时间，支持RFC3339和日期格式，日期格式在当天结束时过期
+func parseTokenExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// handleCreateToken 创建命名令牌，原始令牌只在响应中返回一次
+func (h *Handlers) handleCreateToken(c *gin.Context) {
+	var req struct {
+		Name           string `json:"name"`
+		MonthlyBytes   int64  `json:"monthly_bytes"`
+		HourlyRequests int    `json:"hourly_requests"`
+		ExpiresAt      string `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.MonthlyBytes < 0 || req.HourlyRequests < 0 {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "name不能为空，配额不能为负数")
+		return
+	}
+	expiresAt, err := parseTokenExpiry(req.ExpiresAt)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "expires_at参数无效")
+		return
+	}
+
+	store, err := h.Tokens.Get()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "加载令牌库失败: "+err.Error())
+		return
+	}
+	raw, view, err := store.Create(req.Name, req.MonthlyBytes, req.HourlyRequests, expiresAt)
+	if errors.Is(err, utils.ErrAPITokenExists) {
+		utils.RespondErrorMessage(c, http.StatusConflict, utils.ErrCodeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "创建令牌失败: "+err.Error())
+		return
+	}
+
+	fmt.Printf("管理操作: %s 创建令牌 %s\n", h.IPs.Client(c), req.Name)
+	c.JSON(http.StatusCreated, gin.H{"token": raw, "info": view})
+}
+
+// handleRevokeToken 按名称吊销令牌
+func (h *Handlers) handleRevokeToken(c *gin.Context) {
+	store, err := h.Tokens.Get()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "加载令牌库失败: "+err.Error())
+		return
+	}
+
+	name := c.Param("name")
+	err = store.Revoke(name)
+	if errors.Is(err, utils.ErrAPITokenNotFound) {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "吊销令牌失败: "+err.Error())
+		return
+	}
+
+	fmt.Printf("管理操作: %s 吊销令牌 %s\n", h.IPs.Client(c), name)
+	c.JSON(http.StatusOK, gin.H{"name": name, "status": "revoked"})
+}
+
+// handleListTokens 列出全部令牌及当月用量
+func (h *Handlers) handleListTokens(c *gin.Context) {
+	store, err := h.Tokens.Get()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "加载令牌库失败: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": store.List()})
+}
+
+// maxUsageTopLimit 用量排行单次返回的最大条数
+const maxUsageTopLimit = 100
+
+// handleUsageTop 返回按IP、仓库或镜像统计的用量排行
+func (h *Handlers) handleUsageTop(c *gin.Context) {
+	by := c.DefaultQuery("by", utils.UsageByIP)
+	if !h.Usage.IsDimension(by) {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "by参数仅支持 ip/repo/image")
+		return
+	}
+
+	sortBy := c.DefaultQuery("sort", utils.UsageSortBytes)
+	if sortBy != utils.UsageSortBytes && sortBy != utils.UsageSortRequests {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "sort参数仅支持 bytes/requests")
+		return
+	}
+
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+	if err != nil || window <= 0 || window > utils.StatsMaxWindow {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "window参数无效，最大为"+utils.StatsMaxWindow.String())
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "limit参数无效")
+		return
+	}
+	limit = min(limit, maxUsageTopLimit)
+
+	c.JSON(http.StatusOK, gin.H{
+		"by":      by,
+		"sort":    sortBy,
+		"window":  window.String(),
+		"entries": h.Usage.Top(by, window, sortBy, limit),
+	})
+}
+
+// maxStateImportSize 状态快照导入大小上限
+const maxStateImportSize = 256 << 20
+
+// handleStateExport 流式导出运行时状态，tokens=true时包含token缓存
+func (h *Handlers) handleStateExport(c *gin.Context) {
+	includeTokens := c.Query("tokens") == "true"
+
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", `attachment; filename="hubproxy-state.json"`)
+	c.Status(http.StatusOK)
+	if err := utils.WriteRuntimeState(c.Writer, h.Cache, h.Stats, includeTokens); err != nil {
+		fmt.Printf("导出运行时状态失败: %v\n", err)
+	}
+}
+
+// handleStateImport 导入运行时状态，mode参数可选 merge(默认)/replace，校验失败时不修改任何状态
+func (h *Handlers) handleStateImport(c *gin.Context) {
+	mode := c.DefaultQuery("mode", utils.StateImportMerge)
+	if mode != utils.StateImportMerge && mode != utils.StateImportReplace {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "不支持的导入方式: "+mode)
+		return
+	}
+
+	state, err := utils.ReadRuntimeState(http.MaxBytesReader(c.Writer, c.Request.Body, maxStateImportSize))
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
+		return
+	}
+	utils.ApplyRuntimeState(h.Cache, h.Stats, state, mode)
+
+	fmt.Printf("已导入运行时状态(%s)，导出时间: %s\n", mode, state.ExportedAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "imported",
+		"mode":        mode,
+		"version":     state.Version,
+		"token_cache": len(state.TokenCache),
+	})
+}
+
+// adminOverviewDenials 管理概览中返回的最近拒绝记录条数
+const adminOverviewDenials = 50
+
+// adminConfigSummary 管理概览中展示的配置摘要，不包含令牌、密码等敏感项
+func adminConfigSummary(cfg *config.AppConfig) gin.H {
+	registries := make([]string, 0, len(cfg.Registries))
+	for domain, mapping := range cfg.Registries {
+		if mapping.Enabled {
+			registries = append(registries, domain)
+		}
+	}
+	sort.Strings(registries)
+
+	return gin.H{
+		"host":                cfg.Server.Host,
+		"port":                cfg.Server.Port,
+		"timezone":            utils.DisplayLocation(cfg).String(),
+		"file_size":           cfg.Server.FileSize,
+		"frontend":            cfg.Server.EnableFrontend,
+		"ui":                  cfg.UI.Enabled,
+		"auth_mode":           cfg.Auth.Mode,
+		"rate_limit":          gin.H{"request_limit": cfg.RateLimit.RequestLimit, "period_hours": cfg.RateLimit.PeriodHours, "adaptive": cfg.RateLimit.AdaptiveEnabled},
+		"ip_whitelist":        len(cfg.Security.WhiteList),
+		"ip_blacklist":        len(cfg.Security.BlackList),
+		"access_whitelist":    len(cfg.Access.WhiteList),
+		"access_blacklist":    len(cfg.Access.BlackList),
+		"registries":          registries,
+		"hot_cache":           cfg.HotCache.Enabled,
+		"schedule":            cfg.Schedule.Enabled,
+		"max_concurrent_jobs": cfg.Download.MaxConcurrentJobs,
+	}
+}
+
+// handleAdminOverview 汇总配置摘要、统计、缓存、限流表占用、下载任务、最近拒绝的请求和最近一次配置重载结果，
+// 供 /admin/ 管理页面使用，window参数指定统计窗口，默认1h
+func (h *Handlers) handleAdminOverview(c *gin.Context) {
+	cfg := h.Config.Get()
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+	if err != nil || window <= 0 || window > utils.StatsMaxWindow {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "window参数无效，最大为"+utils.StatsMaxWindow.String())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"config":         adminConfigSummary(cfg),
+		"stats":          h.StatsSnapshot(window),
+		"cache":          h.Cache.Stats(),
+		"hot_cache":      h.Hot.Stats(),
+		"memory":         h.Memory.Stats(),
+		"rate_limit":     h.Limiter.Stats(),
+		"crawlers":       h.Stats.CrawlerStats(),
+		"jobs":           h.tarJobsSummary(),
+		"recent_denials": h.Denials.Recent(adminOverviewDenials),
+		"last_reload":    h.Reloader.LastReload(),
+	})
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 私有实例认证模式，为空表示不启用
+const (
+	AuthModeBasic = "basic"
+	AuthModeOIDC  = "oidc"
+	AuthModeToken = "token"
+)
+
+// basicAuthCacheTTL bcrypt校验结果的缓存时间，避免docker pull的每个请求都做一次bcrypt
+const (
+	basicAuthCacheTTL  = 5 * time.Minute
+	basicAuthCacheSize = 1024
+)
+
+// authTokenKey 认证通过后写入上下文的命名令牌
+const authTokenKey = "auth_token"
+
+// basicAuthCache bcrypt校验通过的凭据摘要及其过期时间
+type basicAuthCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// dummyHash 用户不存在时参与比较的bcrypt摘要，只在首次需要时生成
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// authEnabled 是否启用了私有实例认证
+func authEnabled(cfg *config.AppConfig) bool {
+	return cfg.Auth.Mode == AuthModeBasic || cfg.Auth.Mode == AuthModeOIDC || cfg.Auth.Mode == AuthModeToken
+}
+
+// authExempt 无需认证的路径：健康检查、token端点(自行校验)以及已有管理令牌保护的接口
+func authExempt(path string) bool {
+	return path == "/ready" || path == "/health" || path == "/robots.txt" ||
+		path == "/token" || strings.HasPrefix(path, "/token/") ||
+		strings.HasPrefix(path, "/admin/") ||
+		path == "/api/copy" || strings.HasPrefix(path, "/api/copy/")
+}
+
+// verifyBasicCredentials 校验用户名和bcrypt密码，用户不存在时同样执行一次bcrypt以避免时序差异
+func (h *Handlers) verifyBasicCredentials(cfg *config.AppConfig, username, password string) bool {
+	hash, exists := cfg.Auth.Users[username]
+
+	sum := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + hash))
+	cacheKey := hex.EncodeToString(sum[:])
+
+	h.basicAuth.mu.Lock()
+	expiresAt, cached := h.basicAuth.entries[cacheKey]
+	h.basicAuth.mu.Unlock()
+	if exists && cached && time.Now().Before(expiresAt) {
+		return true
+	}
+
+	if !exists {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("hubproxy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	h.basicAuth.mu.Lock()
+	if len(h.basicAuth.entries) >= basicAuthCacheSize {
+		h.basicAuth.entries = make(map[string]time.Time)
+	}
+	h.basicAuth.entries[cacheKey] = time.Now().Add(basicAuthCacheTTL)
+	h.basicAuth.mu.Unlock()
+	return true
+}
+
+// getOIDCVerifier 返回与当前配置匹配的OIDC校验器，配置变化时重建
+func (h *Handlers) getOIDCVerifier(cfg *config.AppConfig) *utils.OIDCVerifier {
+	oidc := cfg.Auth.OIDC
+	key := strings.Join([]string{oidc.Issuer, oidc.Audience, oidc.JWKSURL, oidc.JWKSCacheTTL}, "\x00")
+
+	h.oidcVerifierMu.Lock()
+	defer h.oidcVerifierMu.Unlock()
+
+	if h.oidcVerifier == nil || h.oidcVerifierKey != key {
+		ttl, err := time.ParseDuration(oidc.JWKSCacheTTL)
+		if err != nil {
+			ttl = time.Hour
+		}
+		h.oidcVerifier = utils.NewOIDCVerifier(h.Background.Context(), h.Clients, oidc.Issuer, oidc.Audience, oidc.JWKSURL, ttl)
+		h.oidcVerifierKey = key
+	}
+	return h.oidcVerifier
+}
+
+// bearerOrPassword 取出Bearer令牌；OIDC模式下也接受放在Basic密码中的令牌，便于docker login和git使用
+func bearerOrPassword(c *gin.Context) string {
+	auth := c.GetHeader("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// authenticate 校验请求凭据，返回用户名和令牌有效期(Basic模式为零值)
+func (h *Handlers) authenticate(c *gin.Context, cfg *config.AppConfig) (string, time.Time, bool) {
+	switch cfg.Auth.Mode {
+	case AuthModeBasic:
+		username, password, ok := c.Request.BasicAuth()
+		if !ok || !h.verifyBasicCredentials(cfg, username, password) {
+			return "", time.Time{}, false
+		}
+		return username, time.Time{}, true
+	case AuthModeOIDC:
+		token := bearerOrPassword(c)
+		if token == "" {
+			return "", time.Time{}, false
+		}
+		claims, err := h.getOIDCVerifier(cfg).Verify(token)
+		if err != nil {
+			fmt.Printf("OIDC令牌校验失败: %v\n", err)
+			return "", time.Time{}, false
+		}
+		return claims.Subject, claims.ExpiresAt, true
+	case AuthModeToken:
+		token := bearerOrPassword(c)
+		if token == "" {
+			return "", time.Time{}, false
+		}
+		store, err := h.Tokens.Get()
+		if err != nil {
+			fmt.Printf("加载令牌库失败: %v\n", err)
+			return "", time.Time{}, false
+		}
+		view, err := store.Lookup(token)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		return view.Name, view.ExpiresAt, true
+	}
+	return "", time.Time{}, true
+}
+
+// writeAuthChallenge 返回401和对应模式的WWW-Authenticate质询
+// OIDC模式使用Registry的Bearer token流程，docker login会携带凭据访问/token换取令牌
+func writeAuthChallenge(c *gin.Context, cfg *config.AppConfig) {
+	realm := cfg.Auth.Realm
+	if realm == "" {
+		realm = "hubproxy"
+	}
+
+	if cfg.Auth.Mode == AuthModeOIDC {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s"`,
+			utils.ExternalBaseURL(cfg, c.Request), realm))
+	} else {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+	}
+
+	if strings.HasPrefix(c.Request.URL.Path, "/v2/") {
+		c.Header("Docker-Distribution-API-Version", dockerproxy.DistributionAPIVersion)
+		utils.RespondRegistryError(c, http.StatusUnauthorized, "UNAUTHORIZED", utils.ErrCodeUnauthorized)
+		c.Abort()
+		return
+	}
+	utils.RespondError(c, http.StatusUnauthorized, utils.ErrCodeUnauthorized)
+}
+
+// ProxyAuthMiddleware 私有实例认证中间件，需注册在限流中间件之前
+// 认证通过后移除Authorization头，避免代理凭据被转发到上游
+func (h *Handlers) ProxyAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := h.Config.Get()
+		if !authEnabled(cfg) || authExempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		username, _, ok := h.authenticate(c, cfg)
+		if !ok {
+			writeAuthChallenge(c, cfg)
+			return
+		}
+		c.Set(utils.AuthUserKey, username)
+
+		if cfg.Auth.Mode == AuthModeToken {
+			h.enforceTokenLimits(c)
+			return
+		}
+
+		c.Request.Header.Del("Authorization")
+		c.Next()
+	}
+}
+
+// enforceTokenLimits 检查命名令牌的配额和每小时请求数，请求完成后累加流量
+// /api/me 只查询用量，不受限额影响也不计入
+func (h *Handlers) enforceTokenLimits(c *gin.Context) {
+	token := bearerOrPassword(c)
+	c.Request.Header.Del("Authorization")
+	if c.Request.URL.Path == "/api/me" {
+		c.Set(authTokenKey, token)
+		c.Next()
+		return
+	}
+
+	store, err := h.Tokens.Get()
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+		return
+	}
+	view, err := store.Admit(token)
+	if err != nil {
+		// 每小时请求数在下一个整点重置，月度流量配额在下月1日(UTC)重置
+		now := time.Now().UTC()
+		code := utils.ErrCodeRateLimited
+		resetAt := now.Truncate(time.Hour).Add(time.Hour)
+		if errors.Is(err, utils.ErrAPITokenQuota) {
+			code = utils.ErrCodeQuotaExceeded
+			resetAt = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		utils.SetRetryAfter(c, resetAt.Sub(now))
+		if strings.HasPrefix(c.Request.URL.Path, "/v2/") {
+			utils.RespondRegistryError(c, http.StatusTooManyRequests, "TOOMANYREQUESTS", code)
+			c.Abort()
+			return
+		}
+		utils.RespondError(c, http.StatusTooManyRequests, code)
+		return
+	}
+	for _, warning := range h.Tokens.QuotaWarnings(h.Clients.Metadata(), view, time.Now()) {
+		c.Writer.Header().Add(utils.QuotaWarningHeader, warning)
+	}
+
+	c.Next()
+	store.AddBytes(token, int64(c.Writer.Size()))
+}
+
+// handleMe 返回当前令牌的配额和当月用量
+func (h *Handlers) handleMe(c *gin.Context) {
+	cfg := h.Config.Get()
+	if cfg.Auth.Mode != AuthModeToken {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeFeatureDisabled, "未启用令牌认证")
+		return
+	}
+
+	store, err := h.Tokens.Get()
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+		return
+	}
+	view, err := store.Lookup(c.GetString(authTokenKey))
+	if err != nil {
+		utils.RespondError(c, http.StatusUnauthorized, utils.ErrCodeUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+// InitAuthRoutes 注册当前用户信息路由
+func (h *Handlers) InitAuthRoutes(router *gin.Engine) {
+	router.GET("/api/me", h.handleMe)
+}
+
+// HandleAuthToken 私有实例模式下的/token处理，返回true表示请求已处理完毕
+// Basic和token模式校验凭据后继续代理上游认证；OIDC模式直接将已验证的令牌作为Registry令牌返回
+func (h *Handlers) HandleAuthToken(c *gin.Context) bool {
+	cfg := h.Config.Get()
+	if !authEnabled(cfg) {
+		return false
+	}
+
+	username, expiresAt, ok := h.authenticate(c, cfg)
+	if !ok {
+		writeAuthChallenge(c, cfg)
+		return true
+	}
+	c.Set(utils.AuthUserKey, username)
+
+	if cfg.Auth.Mode != AuthModeOIDC {
+		c.Request.Header.Del("Authorization")
+		return false
+	}
+
+	token := bearerOrPassword(c)
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   max(int(expiresAt.Sub(now).Seconds()), 0),
+		"issued_at":    now.UTC().Format(time.RFC3339),
+	})
+	return true
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthTestRouter(t *testing.T, configBody string) (*gin.Engine, *Handlers) {
+	t.Helper()
+	loadTestConfig(t, configBody)
+	h := newTestHandlers()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(h.ProxyAuthMiddleware())
+	router.GET("/ready", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.Any("/token", h.Docker.AuthHandler)
+	router.Any("/v2/*path", h.Docker.RegistryHandler)
+	router.NoRoute(func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader("Authorization"))
+	})
+	return router, h
+}
+
+func authRequest(router http.Handler, path string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if setup != nil {
+		setup(req)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProxyAuthBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, _ := newAuthTestRouter(t, fmt.Sprintf(`
+[auth]
+mode = "basic"
+realm = "team"
+
+[auth.users]
+alice = %q
+`, hash))
+
+	if w := authRequest(router, "/ready", nil); w.Code != http.StatusOK {
+		t.Fatalf("/ready status = %d, want 200", w.Code)
+	}
+
+	w := authRequest(router, "/v2/", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("/v2/ status = %d, want 401", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, `Basic realm="team"`) {
+		t.Fatalf("challenge = %q", got)
+	}
+	if !strings.Contains(w.Body.String(), `"UNAUTHORIZED"`) {
+		t.Fatalf("registry error body = %s", w.Body.String())
+	}
+
+	w = authRequest(router, "/v2/", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") })
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password status = %d, want 401", w.Code)
+	}
+	w = authRequest(router, "/v2/", func(r *http.Request) { r.SetBasicAuth("mallory", "secret") })
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown user status = %d, want 401", w.Code)
+	}
+
+	w = authRequest(router, "/v2/", func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid credentials status = %d, want 200", w.Code)
+	}
+
+	w = authRequest(router, "/https://github.com/owner/repo", func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	if w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Fatalf("Authorization not stripped before proxying: %d %q", w.Code, w.Body.String())
+	}
+
+	w = authRequest(router, "/https://github.com/owner/repo", nil)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), utils.ErrCodeUnauthorized) {
+		t.Fatalf("unauthenticated proxy request = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyAuthDisabledByDefault(t *testing.T) {
+	router, _ := newAuthTestRouter(t, "")
+	if w := authRequest(router, "/v2/", nil); w.Code != http.StatusOK {
+		t.Fatalf("/v2/ status = %d, want 200", w.Code)
+	}
+}
+
+func TestProxyAuthOIDCTokenFlow(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	router, _ := newAuthTestRouter(t, fmt.Sprintf(`
+[auth]
+mode = "oidc"
+
+[auth.oidc]
+issuer = "https://issuer.example"
+audience = "hubproxy"
+jwksURL = %q
+`, jwks.URL))
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"iss": "https://issuer.example", "aud": "hubproxy", "sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	w := authRequest(router, "/v2/", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("/v2/ status = %d, want 401", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="http://example.com/token",service="hubproxy"` {
+		t.Fatalf("challenge = %q", got)
+	}
+
+	// docker login 使用用户名+密码(OIDC令牌)访问token端点
+	w = authRequest(router, "/token?service=hubproxy", func(r *http.Request) { r.SetBasicAuth("alice", "invalid") })
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid token status = %d, want 401", w.Code)
+	}
+	w = authRequest(router, "/token?service=hubproxy", func(r *http.Request) { r.SetBasicAuth("alice", token) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("/token status = %d, want 200; body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token != token || resp.ExpiresIn <= 0 {
+		t.Fatalf("unexpected token response: %+v", resp)
+	}
+
+	w = authRequest(router, "/v2/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+resp.Token) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("/v2/ with bearer status = %d, want 200", w.Code)
+	}
+}
+
+func TestProxyAuthTokenLimits(t *testing.T) {
+	storePath := filepath.ToSlash(filepath.Join(t.TempDir(), "tokens.json"))
+	router, h := newAuthTestRouter(t, `
+[auth]
+mode = "token"
+tokenStore = "`+storePath+`"
+`)
+	store, err := h.Tokens.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := store.Create("alice", 10, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := authRequest(router, "/v2/", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("/v2/ without token status = %d, want 401", w.Code)
+	}
+	if w := authRequest(router, "/v2/", func(r *http.Request) { r.SetBasicAuth("alice", "hp_wrong") }); w.Code != http.StatusUnauthorized {
+		t.Fatalf("/v2/ with wrong token status = %d, want 401", w.Code)
+	}
+
+	w := authRequest(router, "/github.com/a/b", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+raw) })
+	if w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Fatalf("first request status = %d, body = %q", w.Code, w.Body.String())
+	}
+
+	// 首个请求未产生流量，手动记入超过配额的字节数
+	store.AddBytes(raw, 10)
+	w = authRequest(router, "/v2/", func(r *http.Request) { r.SetBasicAuth("alice", raw) })
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "TOOMANYREQUESTS") {
+		t.Fatalf("over quota status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w = authRequest(router, "/github.com/a/b", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+raw) })
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), utils.ErrCodeQuotaExceeded) {
+		t.Fatalf("over quota status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// BodyClassGitReceivePack git push 的请求体类别，通常需要比其他请求更大的上限
+const BodyClassGitReceivePack = "git-receive-pack"
+
+// requestBodyClass 返回请求体上限使用的类别，非代理路由返回空
+func requestBodyClass(c *gin.Context) string {
+	class, _ := classifyActivity(c)
+	if class == ActivityClassGitHub && strings.HasSuffix(c.Request.URL.Path, "/git-receive-pack") {
+		return BodyClassGitReceivePack
+	}
+	return class
+}
+
+// requestBodyLimit 返回类别的请求体上限，未单独配置时使用server.maxRequestBodyBytes，0为不限制
+func requestBodyLimit(cfg *config.AppConfig, class string) int64 {
+	if limit, exists := cfg.Server.RequestBodyLimits[class]; exists {
+		return limit
+	}
+	return cfg.Server.MaxRequestBodyBytes
+}
+
+// BodyLimitMiddleware 限制代理路由的请求体大小，Content-Length超出时直接返回413，
+// 未声明长度的请求体在转发过程中超出时由处理器通过respondBodyTooLarge返回413
+func BodyLimitMiddleware(store *config.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody || c.Request.ContentLength == 0 {
+			c.Next()
+			return
+		}
+		class := requestBodyClass(c)
+		if class == "" {
+			c.Next()
+			return
+		}
+		limit := requestBodyLimit(store.Get(), class)
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		c.Set(utils.BodyLimitKey, limit)
+		if c.Request.ContentLength > limit {
+			respondBodyTooLarge(c)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// respondBodyTooLarge 按路由类别的错误格式返回413
+func respondBodyTooLarge(c *gin.Context) {
+	limit := c.GetInt64(utils.BodyLimitKey)
+	switch class, _ := classifyActivity(c); class {
+	case ActivityClassDocker, ActivityClassAuth:
+		utils.RespondRegistryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", utils.ErrCodeBodyTooLarge, limit)
+		c.Abort()
+	case ActivityClassGitHub:
+		utils.RespondProxyError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeBodyTooLarge, limit)
+	default:
+		utils.RespondError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeBodyTooLarge, limit)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimitStopsChunkedGitPush(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[server]
+maxRequestBodyBytes = 1024
+
+[server.requestBodyLimits]
+git-receive-pack = 2048
+`)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	router := gin.New()
+	router.Use(BodyLimitMiddleware(testStore()))
+	router.NoRoute(func(c *gin.Context) {
+		resp, err := http.Post(upstream.URL, "application/x-git-receive-pack-request", c.Request.Body)
+		if err != nil {
+			if utils.BodyTooLarge(err) {
+				respondBodyTooLarge(c)
+				return
+			}
+			t.Errorf("upstream error: %v", err)
+			return
+		}
+		resp.Body.Close()
+		c.Status(http.StatusOK)
+	})
+
+	push := func(size int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/github.com/owner/repo.git/git-receive-pack", strings.NewReader(strings.Repeat("x", size)))
+		// 不声明长度，只能在转发过程中发现超限
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// git push 使用单独的上限
+	if w := push(1500); w.Code != http.StatusOK {
+		t.Fatalf("push under class limit status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w := push(4096)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("X-Error-Code") != utils.ErrCodeBodyTooLarge {
+		t.Fatalf("oversized push status = %d, headers = %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Body.String(), "2048") {
+		t.Fatalf("body = %q, want limit in message", w.Body.String())
+	}
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 客户端容器运行时
+const (
+	RuntimeDocker     = "docker"
+	RuntimeContainerd = "containerd"
+	RuntimePodman     = "podman"
+)
+
+// bootstrapScriptTemplate 运行时镜像加速配置脚本模板，所有变量均经过shellQuote转义
+const bootstrapScriptTemplate = `#!/bin/sh
+# 由 HubProxy 生成的容器运行时镜像加速配置脚本
+# 运行时: {{.Runtime}}
+set -eu
+
+PROXY_URL={{shq .BaseURL}}
+DRY_RUN=0
+RESTART={{if .Restart}}1{{else}}0{{end}}
+
+usage() {
+	echo "用法: $0 [--dry-run] [--restart|--no-restart]"
+}
+
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--dry-run) DRY_RUN=1 ;;
+		--restart) RESTART=1 ;;
+		--no-restart) RESTART=0 ;;
+		-h|--help) usage; exit 0 ;;
+		*) echo "未知参数: $1" >&2; usage >&2; exit 2 ;;
+	esac
+	shift
+done
+
+SUDO=""
+if [ "$DRY_RUN" = 0 ] && [ "$(id -u)" != 0 ]; then
+	if command -v sudo >/dev/null 2>&1; then
+		SUDO="sudo"
+	else
+		echo "需要root权限运行" >&2
+		exit 1
+	fi
+fi
+
+run() {
+	if [ "$DRY_RUN" = 1 ]; then
+		echo "+ $*"
+	else
+		$SUDO "$@"
+	fi
+}
+
+# write_file 写入配置文件，已有文件先备份
+write_file() {
+	if [ "$DRY_RUN" = 1 ]; then
+		echo "+ 写入 $1:"
+		printf '%s\n' "$2"
+		return
+	fi
+	$SUDO mkdir -p "$(dirname "$1")"
+	if [ -f "$1" ]; then
+		$SUDO cp "$1" "$1.bak.$(date +%Y%m%d%H%M%S)"
+	fi
+	printf '%s\n' "$2" | $SUDO tee "$1" >/dev/null
+}
+{{if eq .Runtime "docker"}}
+DAEMON_JSON=/etc/docker/daemon.json
+MIRROR_JSON={{shq .MirrorJSON}}
+
+echo "==> 配置 Docker registry-mirrors: $PROXY_URL"
+if [ -s "$DAEMON_JSON" ]; then
+	if command -v jq >/dev/null 2>&1; then
+		CONFIG=$(jq --argjson m "$MIRROR_JSON" '.["registry-mirrors"] = ([$m] + ((.["registry-mirrors"] // []) - [$m]))' "$DAEMON_JSON")
+	elif command -v python3 >/dev/null 2>&1; then
+		CONFIG=$(python3 -c 'import json, sys
+path, mirror = sys.argv[1], json.loads(sys.argv[2])
+with open(path) as f:
+    data = json.load(f)
+data["registry-mirrors"] = [mirror] + [m for m in data.get("registry-mirrors", []) if m != mirror]
+print(json.dumps(data, indent=2, ensure_ascii=False))' "$DAEMON_JSON" "$MIRROR_JSON")
+	else
+		echo "$DAEMON_JSON 已存在，合并配置需要 jq 或 python3，请手动添加 registry-mirrors: $PROXY_URL" >&2
+		exit 1
+	fi
+else
+	CONFIG=$(printf '{\n  "registry-mirrors": [%s]\n}' "$MIRROR_JSON")
+fi
+write_file "$DAEMON_JSON" "$CONFIG"
+
+if [ "$RESTART" = 1 ]; then
+	echo "==> 重启 Docker"
+	run systemctl restart docker
+else
+	echo "==> 执行 systemctl restart docker 后配置生效"
+fi
+
+echo "==> 验证命令:"
+echo "  docker info | grep -A 1 'Registry Mirrors'"
+echo "  docker pull hello-world"
+{{- range .Registries}}
+echo {{shq (print "  docker pull " .PullPrefix "/<镜像>")}}
+{{- end}}
+{{else if eq .Runtime "containerd"}}
+CERTS_DIR=/etc/containerd/certs.d
+
+echo "==> 配置 containerd 镜像加速: $PROXY_URL"
+{{- range .Registries}}
+write_file "$CERTS_DIR/"{{shq .Name}}"/hosts.toml" {{shq .Config}}
+{{- end}}
+
+if ! grep -qs 'config_path' /etc/containerd/config.toml; then
+	echo "警告: /etc/containerd/config.toml 未设置 config_path，请在 registry 配置中设置 config_path = \"$CERTS_DIR\"" >&2
+fi
+
+if [ "$RESTART" = 1 ]; then
+	echo "==> 重启 containerd"
+	run systemctl restart containerd
+else
+	echo "==> 修改 config_path 后需执行 systemctl restart containerd，hosts.toml 无需重启即可生效"
+fi
+
+echo "==> 验证命令:"
+echo "  ctr images pull --hosts-dir $CERTS_DIR docker.io/library/hello-world:latest"
+echo "  crictl pull docker.io/library/hello-world:latest"
+{{else if eq .Runtime "podman"}}
+REGISTRIES_CONF=/etc/containers/registries.conf.d/hubproxy.conf
+
+echo "==> 配置 Podman 镜像加速: $PROXY_URL"
+write_file "$REGISTRIES_CONF" {{shq .PodmanConfig}}
+
+if [ "$RESTART" = 1 ]; then
+	echo "==> Podman 无守护进程，无需重启"
+fi
+
+echo "==> 验证命令:"
+echo "  podman pull docker.io/library/hello-world:latest"
+{{end -}}
+`
+
+var bootstrapScript = template.Must(template.New("bootstrap").Funcs(template.FuncMap{
+	"shq": shellQuote,
+}).Parse(bootstrapScriptTemplate))
+
+// bootstrapRegistry 需要配置加速的上游仓库
+type bootstrapRegistry struct {
+	Name       string
+	Upstream   string
+	Config     string
+	PullPrefix string
+}
+
+// bootstrapScriptData 脚本模板参数
+type bootstrapScriptData struct {
+	Runtime      string
+	BaseURL      string
+	Restart      bool
+	MirrorJSON   string
+	PodmanConfig string
+	Registries   []bootstrapRegistry
+}
+
+// tomlQuote 转义为TOML基本字符串，JSON字符串的转义规则是TOML的子集
+func tomlQuote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// bootstrapRegistries 返回Docker Hub和已启用的其他仓库，按名称排序
+func bootstrapRegistries(mappings map[string]config.RegistryMapping) []bootstrapRegistry {
+	registries := []bootstrapRegistry{{Name: "docker.io", Upstream: "registry-1.docker.io"}}
+	names := make([]string, 0, len(mappings))
+	for name, mapping := range mappings {
+		if mapping.Enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		registries = append(registries, bootstrapRegistry{Name: name, Upstream: mappings[name].Upstream})
+	}
+	return registries
+}
+
+// renderBootstrapScript 生成配置容器运行时使用本服务加速的脚本
+// containerd通过ns参数告知上游仓库，podman将上游仓库作为路径前缀，docker只支持Docker Hub的registry-mirrors
+func renderBootstrapScript(baseURL, runtime string, restart bool, mappings map[string]config.RegistryMapping) (string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("服务地址无效: %s", baseURL)
+	}
+
+	data := bootstrapScriptData{
+		Runtime:    runtime,
+		BaseURL:    baseURL,
+		Restart:    restart,
+		MirrorJSON: tomlQuote(baseURL),
+	}
+	registries := bootstrapRegistries(mappings)
+
+	switch runtime {
+	case RuntimeDocker:
+		// registry-mirrors只作用于Docker Hub，其他仓库给出带仓库前缀的拉取示例
+		if parsed.Path == "" {
+			for _, registry := range registries[1:] {
+				registry.PullPrefix = parsed.Host + "/" + registry.Name
+				data.Registries = append(data.Registries, registry)
+			}
+		}
+	case RuntimeContainerd:
+		for _, registry := range registries {
+			registry.Config = fmt.Sprintf("server = %s\n\n[host.%s]\n  capabilities = [\"pull\", \"resolve\"]",
+				tomlQuote("https://"+registry.Upstream), tomlQuote(baseURL))
+			data.Registries = append(data.Registries, registry)
+		}
+	case RuntimePodman:
+		// podman的镜像地址不含路径前缀，/v2/之前不能有basePath
+		if parsed.Path != "" {
+			return "", fmt.Errorf("podman不支持带路径前缀的镜像加速地址: %s", baseURL)
+		}
+		var b strings.Builder
+		for i, registry := range registries {
+			if i > 0 {
+				b.WriteString("\n\n")
+			}
+			location := parsed.Host
+			if registry.Name != "docker.io" {
+				location += "/" + registry.Name
+			}
+			fmt.Fprintf(&b, "[[registry]]\nprefix = %s\nlocation = %s\n\n[[registry.mirror]]\nlocation = %s",
+				tomlQuote(registry.Name), tomlQuote(registry.Name), tomlQuote(location))
+			if parsed.Scheme == "http" {
+				b.WriteString("\ninsecure = true")
+			}
+		}
+		data.PodmanConfig = b.String()
+	default:
+		return "", fmt.Errorf("不支持的运行时: %s", runtime)
+	}
+
+	var buf bytes.Buffer
+	if err := bootstrapScript.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// handleBootstrapScript 生成配置Docker、containerd或Podman使用本服务加速的脚本
+func (h *Handlers) handleBootstrapScript(c *gin.Context) {
+	cfg := h.Config.Get()
+	runtime := strings.ToLower(c.DefaultQuery("runtime", RuntimeDocker))
+	restart, _ := strconv.ParseBool(c.Query("restart"))
+
+	script, err := renderBootstrapScript(utils.ExternalBaseURL(cfg, c.Request), runtime, restart, cfg.Registries)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "生成脚本失败: "+err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", []byte(script))
+}
+
+// InitBootstrapRoutes 注册运行时配置脚本路由
+func (h *Handlers) InitBootstrapRoutes(router *gin.Engine) {
+	router.GET("/install.sh", h.handleBootstrapScript)
+}
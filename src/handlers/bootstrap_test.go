@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+var bootstrapTestRegistries = map[string]config.RegistryMapping{
+	"ghcr.io":   {Upstream: "ghcr.io", Enabled: true},
+	"quay.io":   {Upstream: "quay.io", Enabled: true},
+	"gcr.io":    {Upstream: "gcr.io", Enabled: false},
+	"evil'$(x)": {Upstream: "evil.example.com", Enabled: true},
+}
+
+func TestRenderBootstrapScriptGolden(t *testing.T) {
+	for _, runtime := range []string{RuntimeDocker, RuntimeContainerd, RuntimePodman} {
+		t.Run(runtime, func(t *testing.T) {
+			script, err := renderBootstrapScript("https://proxy.example.com/", runtime, runtime == RuntimeContainerd, bootstrapTestRegistries)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "bootstrap_"+runtime+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(script), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if script != string(want) {
+				t.Fatalf("script differs from %s, run go test -update to refresh:\n%s", golden, script)
+			}
+		})
+	}
+}
+
+func TestRenderBootstrapScriptDryRun(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	tests := []struct {
+		runtime string
+		baseURL string
+		want    string
+	}{
+		{RuntimeDocker, "https://proxy.example.com/hub'$(touch pwned)", `"registry-mirrors": ["https://proxy.example.com/hub'$(touch pwned)"]`},
+		{RuntimeContainerd, "http://10.0.0.1:5000", `[host."http://10.0.0.1:5000"]`},
+		{RuntimePodman, "http://10.0.0.1:5000", "insecure = true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.runtime, func(t *testing.T) {
+			script, err := renderBootstrapScript(tt.baseURL, tt.runtime, true, bootstrapTestRegistries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			path := filepath.Join(dir, "install.sh")
+			if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := exec.Command(sh, "-n", path).CombinedOutput(); err != nil {
+				t.Fatalf("sh -n failed: %v\n%s", err, out)
+			}
+
+			cmd := exec.Command(sh, path, "--dry-run")
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("dry run failed: %v\n%s", err, out)
+			}
+			if !strings.Contains(string(out), tt.want) {
+				t.Fatalf("dry run output missing %q:\n%s", tt.want, out)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+				t.Fatal("proxy URL was executed by the shell")
+			}
+		})
+	}
+}
+
+func TestRenderBootstrapScriptRejects(t *testing.T) {
+	if _, err := renderBootstrapScript("https://proxy.example.com/hub", RuntimePodman, false, nil); err == nil {
+		t.Fatal("podman with base path accepted")
+	}
+	if _, err := renderBootstrapScript("https://proxy.example.com", "lxc", false, nil); err == nil {
+		t.Fatal("unknown runtime accepted")
+	}
+}
@@ -0,0 +1,471 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/time/rate"
+)
+
+// 缓存拉取任务状态
+const (
+	CachePullRunning   = "running"
+	CachePullSucceeded = "succeeded"
+	CachePullFailed    = "failed"
+)
+
+const (
+	cachePullJobRetention      = 30 * time.Minute
+	cachePullDefaultConcurrent = 4
+	cachePullMaxConcurrent     = 16
+	// cachePullMaxErrors 任务状态中保留的失败原因条数
+	cachePullMaxErrors = 20
+	// 对端的管理接口同样限流，返回429时等待后重试
+	cachePullRetryDelay  = 2 * time.Second
+	cachePullMaxAttempts = 30
+)
+
+// CacheManifestBlob 缓存清单中的镜像层
+type CacheManifestBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// CacheManifest 内存缓存中的镜像层清单，按最近使用的顺序排列
+type CacheManifest struct {
+	Blobs []CacheManifestBlob `json:"blobs"`
+	Bytes int64               `json:"bytes"`
+}
+
+// buildCacheManifest 列出缓存中的所有镜像层
+func buildCacheManifest(cache *utils.HotCache) CacheManifest {
+	manifest := CacheManifest{Blobs: make([]CacheManifestBlob, 0)}
+	for _, entry := range cache.Entries(utils.BlobHotCachePrefix) {
+		manifest.Blobs = append(manifest.Blobs, CacheManifestBlob{
+			Digest: strings.TrimPrefix(entry.Key, utils.BlobHotCachePrefix),
+			Size:   entry.Size,
+		})
+		manifest.Bytes += entry.Size
+	}
+	return manifest
+}
+
+// cacheManifestHandler 返回缓存的镜像层清单，供其他实例拉取
+func cacheManifestHandler(cache *utils.HotCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildCacheManifest(cache))
+	}
+}
+
+// cacheBlobHandler 直接从内存缓存返回镜像层，不请求上游，不影响LRU顺序和命中统计
+func cacheBlobHandler(cache *utils.HotCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		digest := c.Param("digest")
+		data, contentType, ok := cache.Peek(utils.BuildBlobHotKey(digest))
+		if !ok {
+			utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeNotFound, "缓存中不存在该镜像层")
+			return
+		}
+		c.Header("Docker-Content-Digest", digest)
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
+
+// CachePullRequest 从其他实例拉取缓存的请求，token为对端的管理令牌，不会出现在日志和任务状态中
+type CachePullRequest struct {
+	Peer        string `json:"peer"`
+	Token       string `json:"token"`
+	Concurrency int    `json:"concurrency"`
+}
+
+// CachePullJob 缓存拉取任务，total为需要拉取的镜像层数，present为本地已有的数量，
+// skipped为超出本地单个对象或总容量上限而跳过的数量
+type CachePullJob struct {
+	ID            string    `json:"id"`
+	Peer          string    `json:"peer"`
+	Status        string    `json:"status"`
+	Total         int       `json:"total"`
+	Complete      int       `json:"complete"`
+	Failed        int       `json:"failed"`
+	Present       int       `json:"present"`
+	Skipped       int       `json:"skipped"`
+	TotalBytes    int64     `json:"total_bytes"`
+	CompleteBytes int64     `json:"complete_bytes"`
+	Errors        []string  `json:"errors,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	FinishedAt    time.Time `json:"finished_at,omitempty"`
+
+	done chan struct{}
+}
+
+// cachePullJobStore 缓存拉取任务表
+type cachePullJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*CachePullJob
+}
+
+func (s *cachePullJobStore) add(job *CachePullJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.jobs {
+		if !existing.FinishedAt.IsZero() && now.Sub(existing.FinishedAt) > cachePullJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// snapshot 返回任务状态副本
+func (s *cachePullJobStore) snapshot(id string) (CachePullJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return CachePullJob{}, false
+	}
+	copied := *job
+	copied.Errors = append([]string(nil), job.Errors...)
+	return copied, true
+}
+
+func (s *cachePullJobStore) get(id string) (*CachePullJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	return job, exists
+}
+
+func (s *cachePullJobStore) update(job *CachePullJob, fn func(job *CachePullJob)) {
+	s.mu.Lock()
+	fn(job)
+	s.mu.Unlock()
+}
+
+// cachePullBandwidth 所有拉取任务共用的带宽限制器，配置的速率变化时重建
+type cachePullBandwidth struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	current        *rate.Limiter
+}
+
+// limiter 返回按bytesPerSecond限速的带宽限制器，未限制带宽时返回nil
+func (b *cachePullBandwidth) limiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current == nil || b.bytesPerSecond != bytesPerSecond {
+		b.bytesPerSecond = bytesPerSecond
+		b.current = rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+	}
+	return b.current
+}
+
+// throttledReader 按限制器控制读取速度，单次读取不超过限制器的突发容量
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// fetchFromPeer 以管理令牌请求对端的管理接口，对端限流时等待后重试，只返回200响应
+func (h *Handlers) fetchFromPeer(ctx context.Context, peer, token, path string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := h.Clients.Global().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= cachePullMaxAttempts {
+			return nil, fmt.Errorf("对端返回 %d", resp.StatusCode)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cachePullRetryDelay):
+		}
+	}
+}
+
+// fetchCacheManifest 获取对端的镜像层清单
+func (h *Handlers) fetchCacheManifest(ctx context.Context, peer, token string) (*CacheManifest, error) {
+	resp, err := h.fetchFromPeer(ctx, peer, token, "/admin/cache/manifest")
+	if err != nil {
+		return nil, fmt.Errorf("获取缓存清单失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var manifest CacheManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("解析缓存清单失败: %w", err)
+	}
+	return &manifest, nil
+}
+
+// pullCacheBlob 从对端拉取单个镜像层，大小和digest校验通过后写入cache
+func (h *Handlers) pullCacheBlob(ctx context.Context, peer, token string, blob CacheManifestBlob, cache *utils.HotCache) error {
+	hash, err := v1.NewHash(blob.Digest)
+	if err != nil || hash.Algorithm != "sha256" {
+		return fmt.Errorf("不支持的digest: %s", blob.Digest)
+	}
+
+	resp, err := h.fetchFromPeer(ctx, peer, token, "/admin/cache/blob/"+blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if limiter := h.cachePullBandwidth.limiter(h.Config.Get().HotCache.PullBytesPerSecond); limiter != nil {
+		body = &throttledReader{ctx: ctx, reader: body, limiter: limiter}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, blob.Size))
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(buf, hasher), io.LimitReader(body, blob.Size+1)); err != nil {
+		return err
+	}
+	if int64(buf.Len()) != blob.Size {
+		return fmt.Errorf("大小不符: 清单为 %d，实际为 %d", blob.Size, buf.Len())
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hash.Hex {
+		return fmt.Errorf("digest校验失败: 实际为 sha256:%s", actual)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	cache.Store(utils.BuildBlobHotKey(blob.Digest), buf.Bytes(), contentType)
+	return nil
+}
+
+// selectCachePullBlobs 挑出本地缺少的镜像层，超过单个对象上限的跳过，
+// 清单按对端最近使用排序，拉取总量达到本地容量后其余跳过
+func selectCachePullBlobs(cfg *config.AppConfig, job *CachePullJob, manifest *CacheManifest, cache *utils.HotCache) []CacheManifestBlob {
+	budget := cfg.HotCache.MaxBytes
+	pending := make([]CacheManifestBlob, 0, len(manifest.Blobs))
+	for _, blob := range manifest.Blobs {
+		if _, _, ok := cache.Peek(utils.BuildBlobHotKey(blob.Digest)); ok {
+			job.Present++
+			continue
+		}
+		if blob.Size <= 0 || blob.Size > cfg.HotCache.MaxObjectBytes || blob.Size > budget {
+			job.Skipped++
+			continue
+		}
+		budget -= blob.Size
+		job.Total++
+		job.TotalBytes += blob.Size
+		pending = append(pending, blob)
+	}
+	return pending
+}
+
+// runCachePullJob 获取对端清单后以有限并发拉取本地缺少的镜像层，服务停止时取消
+func (h *Handlers) runCachePullJob(job *CachePullJob, req CachePullRequest, cache *utils.HotCache) {
+	defer close(job.done)
+	ctx, cancel := context.WithCancel(h.Background.Context())
+	defer cancel()
+
+	manifest, err := h.fetchCacheManifest(ctx, job.Peer, req.Token)
+	if err != nil {
+		h.cachePullJobs.update(job, func(job *CachePullJob) {
+			job.Status = CachePullFailed
+			job.Error = err.Error()
+			job.FinishedAt = time.Now()
+		})
+		fmt.Printf("缓存拉取任务 %s 失败: %v\n", job.ID, err)
+		return
+	}
+
+	cfg := h.Config.Get()
+	var pending []CacheManifestBlob
+	h.cachePullJobs.update(job, func(job *CachePullJob) {
+		pending = selectCachePullBlobs(cfg, job, manifest, cache)
+	})
+
+	semaphore := make(chan struct{}, req.Concurrency)
+	var wg sync.WaitGroup
+	for _, blob := range pending {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(blob CacheManifestBlob) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := h.pullCacheBlob(ctx, job.Peer, req.Token, blob, cache)
+			h.cachePullJobs.update(job, func(job *CachePullJob) {
+				if err != nil {
+					job.Failed++
+					if len(job.Errors) < cachePullMaxErrors {
+						job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", blob.Digest, err))
+					}
+					return
+				}
+				job.Complete++
+				job.CompleteBytes += blob.Size
+			})
+		}(blob)
+	}
+	wg.Wait()
+
+	h.cachePullJobs.update(job, func(job *CachePullJob) {
+		job.Status = CachePullSucceeded
+		if job.Failed > 0 {
+			job.Status = CachePullFailed
+			job.Error = fmt.Sprintf("%d 个镜像层拉取失败", job.Failed)
+		}
+		job.FinishedAt = time.Now()
+	})
+	snapshot, _ := h.cachePullJobs.snapshot(job.ID)
+	fmt.Printf("缓存拉取任务 %s 完成: 拉取 %d 个，失败 %d 个，本地已有 %d 个，跳过 %d 个\n",
+		job.ID, snapshot.Complete, snapshot.Failed, snapshot.Present, snapshot.Skipped)
+}
+
+// parseCachePeer 校验对端地址，只接受http和https，返回去掉末尾/的地址
+func parseCachePeer(peer string) (string, error) {
+	peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+	parsed, err := url.Parse(peer)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("peer必须是http或https地址")
+	}
+	return peer, nil
+}
+
+// cachePullHandler 创建从其他实例拉取缓存的任务，拉取的镜像层写入cache
+func (h *Handlers) cachePullHandler(cache *utils.HotCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.Config.Get().HotCache.Enabled {
+			utils.RespondErrorMessage(c, http.StatusConflict, utils.ErrCodeFeatureDisabled, "内存缓存未启用，无法拉取")
+			return
+		}
+
+		var req CachePullRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+			return
+		}
+		peer, err := parseCachePeer(req.Peer)
+		if err != nil {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
+			return
+		}
+		if req.Token = strings.TrimSpace(req.Token); req.Token == "" {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "token不能为空")
+			return
+		}
+		if req.Concurrency <= 0 {
+			req.Concurrency = cachePullDefaultConcurrent
+		}
+		req.Concurrency = min(req.Concurrency, cachePullMaxConcurrent)
+
+		job := &CachePullJob{
+			ID:        newJobID(),
+			Peer:      peer,
+			Status:    CachePullRunning,
+			CreatedAt: time.Now(),
+			done:      make(chan struct{}),
+		}
+		h.cachePullJobs.add(job)
+
+		fmt.Printf("管理操作: %s 创建缓存拉取任务 %s，对端 %s\n", h.IPs.Client(c), job.ID, peer)
+		go h.runCachePullJob(job, req, cache)
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":     job.ID,
+			"status_url": "/admin/cache/pull/" + job.ID,
+			"events_url": "/admin/cache/pull/" + job.ID + "/events",
+		})
+	}
+}
+
+// handleCachePullStatus 查询缓存拉取任务状态
+func (h *Handlers) handleCachePullStatus(c *gin.Context) {
+	job, exists := h.cachePullJobs.snapshot(c.Param("id"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handleCachePullEvents 以SSE推送缓存拉取进度，任务结束后发送done事件
+func (h *Handlers) handleCachePullEvents(c *gin.Context) {
+	job, exists := h.cachePullJobs.get(c.Param("id"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	lastProgress := -1
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-job.done:
+			snapshot, _ := h.cachePullJobs.snapshot(job.ID)
+			c.SSEvent("done", snapshot)
+			return false
+		case <-ticker.C:
+			snapshot, _ := h.cachePullJobs.snapshot(job.ID)
+			if progress := snapshot.Complete + snapshot.Failed; progress != lastProgress {
+				lastProgress = progress
+				c.SSEvent("progress", snapshot)
+			}
+			return true
+		}
+	})
+}
+
+// registerCacheTransferRoutes 注册实例间导出和导入缓存的接口，均作用于cache
+func (h *Handlers) registerCacheTransferRoutes(group *gin.RouterGroup, cache *utils.HotCache) {
+	group.GET("/cache/manifest", cacheManifestHandler(cache))
+	group.GET("/cache/blob/:digest", cacheBlobHandler(cache))
+	group.POST("/cache/pull", h.cachePullHandler(cache))
+	group.GET("/cache/pull/:id", h.handleCachePullStatus)
+	group.GET("/cache/pull/:id/events", h.handleCachePullEvents)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const cacheTransferConfig = `
+[admin]
+enabled = true
+token = "peer-token"
+requestsPerMinute = 0
+
+[hotCache]
+promoteAfter = 1
+`
+
+// newAdminInstance 启动只包含管理接口的实例，缓存接口作用于h的内存缓存
+func newAdminInstance(t *testing.T, h *Handlers) *httptest.Server {
+	t.Helper()
+	router := gin.New()
+	h.InitAdminRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// adminRequest 以管理令牌发送请求
+func adminRequest(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer peer-token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// waitCachePull 读取SSE直到done事件，返回最终的任务状态
+func waitCachePull(t *testing.T, baseURL string, resp *http.Response) CachePullJob {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("pull status %d", resp.StatusCode)
+	}
+	var created struct {
+		EventsURL string `json:"events_url"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+
+	events := adminRequest(t, http.MethodGet, baseURL+created.EventsURL, "")
+	defer events.Body.Close()
+	stream, _ := io.ReadAll(events.Body)
+	_, done, found := strings.Cut(string(stream), "event:done\ndata:")
+	if !found {
+		t.Fatalf("no done event in %q", stream)
+	}
+	var job CachePullJob
+	if err := json.Unmarshal([]byte(strings.SplitN(done, "\n", 2)[0]), &job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestCachePullBetweenInstances(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var upstreamRequests atomic.Int64
+	registryHandler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		registryHandler.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	loadTestConfig(t, cacheTransferConfig+fmt.Sprintf("\n[registries.%q]\nupstream = %q\nenabled = true\n", host, host))
+
+	layer, err := random.Layer(4096, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := name.NewRepository(host + "/org/app")
+	if err := remote.WriteLayer(repo, layer); err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := layer.Digest()
+	compressed, _ := layer.Compressed()
+	want, _ := io.ReadAll(compressed)
+
+	fetch := func(h *Handlers) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/"+host+"/org/app/blobs/"+digest.String(), nil)
+		h.Docker.RegistryHandler(c)
+		return w
+	}
+
+	// 第一个实例从上游拉取，镜像层进入它的内存缓存
+	peerHandlers := newTestHandlers()
+	if w := fetch(peerHandlers); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+		t.Fatalf("peer fetch status %d", w.Code)
+	}
+	peer := newAdminInstance(t, peerHandlers)
+
+	// 第二个实例使用独立的空缓存
+	localHandlers := newTestHandlers()
+	local := newAdminInstance(t, localHandlers)
+
+	manifestResp := adminRequest(t, http.MethodGet, peer.URL+"/admin/cache/manifest", "")
+	var manifest CacheManifest
+	json.NewDecoder(manifestResp.Body).Decode(&manifest)
+	manifestResp.Body.Close()
+	if len(manifest.Blobs) != 1 || manifest.Blobs[0].Digest != digest.String() || manifest.Blobs[0].Size != int64(len(want)) {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+
+	upstreamRequests.Store(0)
+	body := fmt.Sprintf(`{"peer": %q, "token": "peer-token"}`, peer.URL)
+	job := waitCachePull(t, local.URL, adminRequest(t, http.MethodPost, local.URL+"/admin/cache/pull", body))
+	if job.Status != CachePullSucceeded || job.Complete != 1 || job.CompleteBytes != int64(len(want)) {
+		t.Fatalf("job = %+v", job)
+	}
+
+	w := fetch(localHandlers)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+		t.Fatalf("local fetch status %d", w.Code)
+	}
+	if n := upstreamRequests.Load(); n != 0 {
+		t.Fatalf("upstream received %d requests", n)
+	}
+
+	// 再次拉取时本地已有，不重复传输
+	job = waitCachePull(t, local.URL, adminRequest(t, http.MethodPost, local.URL+"/admin/cache/pull", body))
+	if job.Status != CachePullSucceeded || job.Present != 1 || job.Total != 0 {
+		t.Fatalf("second job = %+v", job)
+	}
+}
+
+func TestCachePullVerifiesDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, cacheTransferConfig)
+	h := newTestHandlers()
+
+	blob := []byte(strings.Repeat("layer-data ", 64))
+	blobs := map[string][]byte{}
+	good := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	blobs[good] = blob
+	// 对端返回的内容与digest不符
+	bad := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other")))
+	blobs[bad] = bytes.ToUpper(blob)
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer peer-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/admin/cache/manifest" {
+			json.NewEncoder(w).Encode(CacheManifest{Blobs: []CacheManifestBlob{
+				{Digest: good, Size: int64(len(blob))},
+				{Digest: bad, Size: int64(len(blob))},
+				{Digest: "md5:abc", Size: 3},
+			}})
+			return
+		}
+		w.Write(blobs[strings.TrimPrefix(r.URL.Path, "/admin/cache/blob/")])
+	}))
+	defer peer.Close()
+
+	cache := utils.NewHotCache(h.Config)
+	job := &CachePullJob{ID: "cache-pull-test", Peer: peer.URL, Status: CachePullRunning, done: make(chan struct{})}
+	h.cachePullJobs.add(job)
+	h.runCachePullJob(job, CachePullRequest{Token: "peer-token", Concurrency: 2}, cache)
+
+	snapshot, _ := h.cachePullJobs.snapshot(job.ID)
+	if snapshot.Status != CachePullFailed || snapshot.Complete != 1 || snapshot.Failed != 2 || len(snapshot.Errors) != 2 {
+		t.Fatalf("job = %+v", snapshot)
+	}
+	if _, _, ok := cache.Peek(utils.BuildBlobHotKey(good)); !ok {
+		t.Fatal("verified blob not cached")
+	}
+	if _, _, ok := cache.Peek(utils.BuildBlobHotKey(bad)); ok {
+		t.Fatal("corrupted blob cached")
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// capabilityRegistry 已启用的Registry，upstream只给出主机名，内网上游不公开
+type capabilityRegistry struct {
+	Domain   string `json:"domain"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// capabilityFeatures 实例支持的功能
+type capabilityFeatures struct {
+	TarDownload   bool `json:"tar_download"`
+	BatchDownload bool `json:"batch_download"`
+	MultiArch     bool `json:"multi_arch"`
+	Copy          bool `json:"copy"` // 需要管理令牌
+	Push          bool `json:"push"`
+	Search        bool `json:"search"`
+	GitHubTree    bool `json:"github_tree"`
+	Signing       bool `json:"signing"`
+	History       bool `json:"history"`
+	ActivityFeed  bool `json:"activity_feed"`
+	AuthRequired  bool `json:"auth_required"`
+}
+
+// capabilityPattern 支持的URL形式，pattern中的路径已带basePath
+type capabilityPattern struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern"`
+}
+
+// capabilityLimits 调用方适用的文件大小上限，按认证身份的倍数计算，0表示不限制
+type capabilityLimits struct {
+	FileSize    int64 `json:"file_size"`
+	GitHub      int64 `json:"github"`
+	HuggingFace int64 `json:"huggingface"`
+	DockerBlob  int64 `json:"docker_blob"`
+	Tar         int64 `json:"tar"`
+	MaxImages   int   `json:"max_images"`
+}
+
+// capabilitiesInfo 能力描述中与调用方无关的部分
+type capabilitiesInfo struct {
+	BasePath    string               `json:"base_path"`
+	Registries  []capabilityRegistry `json:"registries"`
+	Features    capabilityFeatures   `json:"features"`
+	URLPatterns []capabilityPattern  `json:"url_patterns"`
+}
+
+// capabilitiesResponse /api/capabilities 的响应，sensitive固定为false：
+// 只包含客户端本就能观察到的信息，不含令牌、用户、名单、覆盖规则和内网主机
+type capabilitiesResponse struct {
+	Sensitive bool              `json:"sensitive"`
+	Limits    capabilityLimits  `json:"limits"`
+	RateLimit *ratelimit.Status `json:"rate_limit"`
+	capabilitiesInfo
+}
+
+// internalHostSuffixes 视为内网的主机名后缀
+var internalHostSuffixes = []string{".local", ".internal", ".lan", ".localdomain", ".svc", ".cluster.local"}
+
+// publicUpstreamHost 返回可公开的上游主机名；IP地址、单标签主机名和内网域名返回空
+func publicUpstreamHost(upstream string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(upstream, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if host == "" || net.ParseIP(strings.Trim(host, "[]")) != nil || !strings.Contains(host, ".") {
+		return ""
+	}
+	for _, suffix := range internalHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return ""
+		}
+	}
+	return host
+}
+
+// buildCapabilities 根据当前配置生成与调用方无关的能力描述
+func buildCapabilities(cfg *config.AppConfig) *capabilitiesInfo {
+	basePath := utils.BasePath(cfg)
+
+	domains := make([]string, 0, len(cfg.Registries))
+	for domain, mapping := range cfg.Registries {
+		if mapping.Enabled {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	registries := []capabilityRegistry{{Domain: "docker.io", Upstream: "registry-1.docker.io"}}
+	for _, domain := range domains {
+		registries = append(registries, capabilityRegistry{Domain: domain, Upstream: publicUpstreamHost(cfg.Registries[domain].Upstream)})
+	}
+
+	patterns := []capabilityPattern{
+		{"docker", basePath + "/v2/{image}/manifests/{reference}"},
+		{"docker", basePath + "/v2/{registry}/{image}/manifests/{reference}"},
+		{"github", basePath + "/https://github.com/{owner}/{repo}/releases/download/{tag}/{file}"},
+		{"github", basePath + "/https://github.com/{owner}/{repo}/archive/{ref}.tar.gz"},
+		{"github", basePath + "/https://raw.githubusercontent.com/{owner}/{repo}/{ref}/{path}"},
+		{"github", basePath + "/https://gist.githubusercontent.com/{owner}/{id}/raw/{file}"},
+		{"github", basePath + "/https://api.github.com/repos/{owner}/{repo}/{path}"},
+		{"huggingface", basePath + "/https://huggingface.co/{owner}/{repo}/resolve/{ref}/{file}"},
+		{"tar", basePath + "/api/image/download/{image}?platform={os}/{arch}"},
+	}
+	if cfg.UI.Enabled {
+		patterns = append(patterns, capabilityPattern{"search", basePath + "/search?q={query}"})
+	}
+	if cfg.Download.MaxImages > 1 {
+		patterns = append(patterns, capabilityPattern{"tar", basePath + "/api/image/batch"})
+	}
+
+	return &capabilitiesInfo{
+		BasePath:   basePath,
+		Registries: registries,
+		Features: capabilityFeatures{
+			TarDownload:   true,
+			BatchDownload: cfg.Download.MaxImages > 1,
+			MultiArch:     true,
+			Copy:          cfg.Admin.Enabled,
+			Push:          false,
+			Search:        cfg.UI.Enabled,
+			GitHubTree:    cfg.UI.Enabled,
+			Signing:       cfg.Signing.Enabled,
+			History:       cfg.History.Enabled,
+			ActivityFeed:  cfg.UI.Enabled && cfg.UI.ActivityFeed,
+			AuthRequired:  authEnabled(cfg),
+		},
+		URLPatterns: patterns,
+	}
+}
+
+// cachedCapabilities 返回缓存的能力描述，配置重载后重新生成
+func (h *Handlers) cachedCapabilities() *capabilitiesInfo {
+	cfg := h.Config.Get()
+	h.capabilitiesMu.Lock()
+	defer h.capabilitiesMu.Unlock()
+	if h.capabilitiesCached == nil {
+		h.capabilitiesCached = buildCapabilities(cfg)
+	}
+	return h.capabilitiesCached
+}
+
+// handleCapabilities 返回实例支持的功能、调用方适用的文件大小上限和当前限流余量，供客户端在请求前探测
+func (h *Handlers) handleCapabilities(c *gin.Context) {
+	cfg := h.Config.Get()
+	identity := c.GetString(utils.AuthUserKey)
+
+	fileSize := cfg.Server.FileSize
+	if fileSize < 0 {
+		fileSize = 0
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, capabilitiesResponse{
+		Sensitive: false,
+		Limits: capabilityLimits{
+			FileSize:    fileSize,
+			GitHub:      utils.SizeLimit(cfg, utils.SizeClassGitHub, "", identity),
+			HuggingFace: utils.SizeLimit(cfg, utils.SizeClassHuggingFace, "", identity),
+			DockerBlob:  utils.SizeLimit(cfg, utils.SizeClassDockerBlob, "", identity),
+			Tar:         utils.SizeLimit(cfg, utils.SizeClassTar, "", identity),
+			MaxImages:   cfg.Download.MaxImages,
+		},
+		RateLimit:        ratelimit.CallerStatus(c),
+		capabilitiesInfo: *h.cachedCapabilities(),
+	})
+}
+
+// InitCapabilitiesRoutes 注册能力探测路由
+func (h *Handlers) InitCapabilitiesRoutes(router *gin.Engine) {
+	router.GET("/api/capabilities", utils.APITimeoutMiddleware(h.Config, utils.APITimeoutStats), h.handleCapabilities)
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// githubTreeCacheTTL 目录列表的缓存时间，分支内容会变化，只做短时间缓存
+	githubTreeCacheTTL = 2 * time.Minute
+	// maxGitHubTreeResponse GitHub contents API 单个目录最多返回1000项
+	maxGitHubTreeResponse = 8 << 20
+	maxTreePageSize       = 1000
+)
+
+// githubAPIBase GitHub API地址
+var githubAPIBase = "https://api.github.com"
+
+// githubNamePattern GitHub用户名和仓库名允许的字符
+var githubNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validGitHubName 检查用户名或仓库名，拒绝 .、.. 和以.开头的名称，避免拼接出指向其他API的路径
+func validGitHubName(name string) bool {
+	return githubNamePattern.MatchString(name) && !strings.HasPrefix(name, ".")
+}
+
+// githubTreeToken 返回目录浏览使用的GitHub令牌，调用者未认证且未配置 github.tokenForAnonymous 时返回空
+func githubTreeToken(cfg *config.AppConfig, c *gin.Context) string {
+	if c.GetString(utils.AuthUserKey) == "" && !cfg.GitHub.TokenForAnonymous {
+		return ""
+	}
+	return cfg.GitHub.Token
+}
+
+// TreeEntry 目录中的一项，文件附带可直接使用的代理下载地址
+type TreeEntry struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// githubContent GitHub contents API 返回的目录项
+type githubContent struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
+// cleanTreePath 规范化目录路径，拒绝包含 . 或 .. 的路径
+func cleanTreePath(p string) (string, bool) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", true
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+	return p, true
+}
+
+// escapePathSegments 逐段转义路径，保留分隔符
+func escapePathSegments(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// rawProxyURL 返回文件经本服务代理的raw下载地址，ref为空时使用默认分支
+func rawProxyURL(baseURL, owner, repo, ref, filePath string) string {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return fmt.Sprintf("%s/https://raw.githubusercontent.com/%s/%s/%s/%s",
+		baseURL, owner, repo, escapePathSegments(ref), escapePathSegments(filePath))
+}
+
+// fetchGitHubTree 获取目录内容，token非空时携带令牌，返回GitHub的状态码以便映射错误
+func (h *Handlers) fetchGitHubTree(c *gin.Context, token, owner, repo, ref, dir string) ([]githubContent, int, error) {
+	target := fmt.Sprintf("%s/repos/%s/%s/contents/%s", githubAPIBase, owner, repo, escapePathSegments(dir))
+	if ref != "" {
+		target += "?ref=" + url.QueryEscape(ref)
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := h.Clients.Metadata().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer safeCloseResponseBody(resp.Body, "GitHub目录响应")
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxGitHubTreeResponse))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	// 路径指向文件时返回单个对象
+	var contents []githubContent
+	if err := json.Unmarshal(body, &contents); err != nil {
+		var file githubContent
+		if err := json.Unmarshal(body, &file); err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("解析GitHub响应失败: %v", err)
+		}
+		contents = []githubContent{file}
+	}
+	return contents, resp.StatusCode, nil
+}
+
+// handleGitHubTree 列出GitHub仓库目录，目录在前，按名称排序后分页返回
+func (h *Handlers) handleGitHubTree(c *gin.Context) {
+	cfg := h.Config.Get()
+	owner, repo := c.Param("owner"), strings.TrimSuffix(c.Param("repo"), ".git")
+	if !validGitHubName(owner) || !validGitHubName(repo) {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo)
+		return
+	}
+	dir, ok := cleanTreePath(c.Query("path"))
+	if !ok {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidPath)
+		return
+	}
+	if allowed, reason := h.Access.CheckGitHubAccess([]string{owner, repo}); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
+		return
+	}
+
+	ref := c.Query("ref")
+	// 携带令牌的列表可能包含私有仓库，不读写共享缓存
+	token := githubTreeToken(h.Config.Get(), c)
+	cacheKey := fmt.Sprintf("ghtree:%s/%s@%s:%s", owner, repo, ref, dir)
+	var entries []githubContent
+	if cached, ok := h.searchCache.Get(cacheKey); ok && token == "" && utils.CacheReadAllowed(cfg, c) {
+		entries = cached.([]githubContent)
+	} else {
+		contents, status, err := h.fetchGitHubTree(c, token, owner, repo, ref, dir)
+		if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+			utils.RespondError(c, http.StatusServiceUnavailable, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+			return
+		}
+		switch {
+		case err != nil:
+			utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, err)
+			return
+		case status == http.StatusNotFound:
+			utils.RespondError(c, http.StatusNotFound, utils.ErrCodeGitHubNotFound)
+			return
+		case status == http.StatusForbidden || status == http.StatusTooManyRequests:
+			// GitHub限额耗尽时返回403或429，按上游给出的重置时间告知客户端何时重试
+			if apiURL, parseErr := url.Parse(githubAPIBase); parseErr == nil {
+				if wait, ok := h.Clients.UpstreamThrottleRemaining(apiURL.Hostname()); ok {
+					c.Header("Retry-After", strconv.Itoa(max(int(wait.Seconds()), 1)))
+				}
+			}
+			utils.RespondError(c, status, utils.ErrCodeGitHubForbidden)
+			return
+		case status != http.StatusOK:
+			utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, fmt.Sprintf("HTTP %d", status))
+			return
+		}
+
+		sort.SliceStable(contents, func(i, j int) bool {
+			if (contents[i].Type == "dir") != (contents[j].Type == "dir") {
+				return contents[i].Type == "dir"
+			}
+			return contents[i].Name < contents[j].Name
+		})
+		if token == "" && utils.CacheWriteAllowed(cfg, c) {
+			h.searchCache.SetWithTTL(cacheKey, contents, githubTreeCacheTTL)
+		}
+		entries = contents
+	}
+
+	page, pageSize := parsePaginationParams(c, 100)
+	page = max(page, 1)
+	pageSize = min(max(pageSize, 1), maxTreePageSize)
+	start := min((page-1)*pageSize, len(entries))
+	end := min(start+pageSize, len(entries))
+
+	baseURL := utils.ExternalBaseURL(cfg, c.Request)
+	result := make([]TreeEntry, 0, end-start)
+	for _, item := range entries[start:end] {
+		entry := TreeEntry{Name: item.Name, Path: item.Path, Type: item.Type, Size: item.Size}
+		if item.Type == "file" {
+			entry.DownloadURL = rawProxyURL(baseURL, owner, repo, ref, item.Path)
+		}
+		result = append(result, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owner":     owner,
+		"repo":      repo,
+		"ref":       ref,
+		"path":      dir,
+		"entries":   result,
+		"total":     len(entries),
+		"page":      page,
+		"page_size": pageSize,
+		"has_more":  end < len(entries),
+	})
+}
+
+// InitGitHubTreeRoutes 注册GitHub目录浏览接口
+func (h *Handlers) InitGitHubTreeRoutes(router *gin.Engine) {
+	router.GET("/api/github/tree/:owner/:repo", h.uiRouteGuard, utils.APITimeoutMiddleware(h.Config, utils.APITimeoutGitHub), h.handleGitHubTree)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGitHubTree(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["baduser/*"]
+
+[github]
+token = "secret"
+`)
+	h := newTestHandlers()
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if auth := r.Header.Get("Authorization"); auth != "" && auth != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/owner/private/contents/":
+			// 私有仓库对匿名请求返回404
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`[{"name":"secret.txt","path":"secret.txt","type":"file","size":1}]`))
+		case "/repos/owner/repo/contents/docs":
+			if r.URL.Query().Get("ref") != "v1" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`[
+				{"name":"b.md","path":"docs/b.md","type":"file","size":2},
+				{"name":"sub","path":"docs/sub","type":"dir","size":0},
+				{"name":"a.md","path":"docs/a.md","type":"file","size":1}
+			]`))
+		case "/repos/owner/repo/contents/README.md":
+			w.Write([]byte(`{"name":"README.md","path":"README.md","type":"file","size":10}`))
+		case "/repos/owner/limited/contents/":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+	original := githubAPIBase
+	githubAPIBase = upstream.URL
+	t.Cleanup(func() { githubAPIBase = original })
+
+	router := gin.New()
+	// 模拟认证中间件，X-Test-User 非空时视为已认证
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set(utils.AuthUserKey, user)
+		}
+	})
+	h.InitGitHubTreeRoutes(router)
+	getAs := func(user, path string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "proxy.example.com"
+		req.Header.Set("X-Test-User", user)
+		router.ServeHTTP(w, req)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+	get := func(path string) (*httptest.ResponseRecorder, map[string]any) {
+		return getAs("", path)
+	}
+
+	// 目录在前，文件按名称排序，文件带代理下载地址
+	w, body := get("/api/github/tree/owner/repo?ref=v1&path=docs&page_size=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	entries := body["entries"].([]any)
+	if len(entries) != 2 || body["total"].(float64) != 3 || body["has_more"] != true {
+		t.Fatalf("unexpected page: %s", w.Body.String())
+	}
+	first, second := entries[0].(map[string]any), entries[1].(map[string]any)
+	if first["name"] != "sub" || first["download_url"] != nil || second["name"] != "a.md" {
+		t.Fatalf("unexpected order: %s", w.Body.String())
+	}
+	if got := second["download_url"]; got != "http://proxy.example.com/https://raw.githubusercontent.com/owner/repo/v1/docs/a.md" {
+		t.Fatalf("download_url = %v", got)
+	}
+
+	// 第二页来自缓存
+	w, body = get("/api/github/tree/owner/repo?ref=v1&path=docs&page=2&page_size=2")
+	if entries := body["entries"].([]any); len(entries) != 1 || body["has_more"] != false {
+		t.Fatalf("unexpected second page: %s", w.Body.String())
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls.Load())
+	}
+
+	// 路径指向文件时返回单项
+	w, body = get("/api/github/tree/owner/repo?path=README.md")
+	if entries := body["entries"].([]any); w.Code != http.StatusOK || len(entries) != 1 ||
+		!strings.HasSuffix(entries[0].(map[string]any)["download_url"].(string), "/owner/repo/HEAD/README.md") {
+		t.Fatalf("unexpected file listing: %s", w.Body.String())
+	}
+
+	tests := []struct {
+		path string
+		want int
+		code string
+	}{
+		{"/api/github/tree/owner/repo?path=missing", http.StatusNotFound, utils.ErrCodeGitHubNotFound},
+		{"/api/github/tree/owner/limited", http.StatusForbidden, utils.ErrCodeGitHubForbidden},
+		{"/api/github/tree/baduser/repo", http.StatusForbidden, utils.ErrCodeGitHubBlacklisted},
+		{"/api/github/tree/owner/repo?path=docs/../..", http.StatusBadRequest, utils.ErrCodeInvalidPath},
+		{"/api/github/tree/../repo", http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo},
+		{"/api/github/tree/owner/..", http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo},
+		{"/api/github/tree/owner/%2e%2e", http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo},
+		{"/api/github/tree/owner/.", http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo},
+		{"/api/github/tree/.hidden/repo", http.StatusBadRequest, utils.ErrCodeGitHubInvalidRepo},
+	}
+	for _, tt := range tests {
+		w, body := get(tt.path)
+		if w.Code != tt.want || body["code"] != tt.code {
+			t.Errorf("%s: status = %d, body = %s", tt.path, w.Code, w.Body.String())
+		}
+	}
+
+	// 令牌只用于已认证的调用者，携带令牌的列表不进入共享缓存
+	if w, _ := get("/api/github/tree/owner/private"); w.Code != http.StatusNotFound {
+		t.Fatalf("anonymous private listing: %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := getAs("alice", "/api/github/tree/owner/private"); w.Code != http.StatusOK {
+		t.Fatalf("authenticated private listing: %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := get("/api/github/tree/owner/private"); w.Code != http.StatusNotFound {
+		t.Fatalf("private listing leaked to anonymous caller: %d %s", w.Code, w.Body.String())
+	}
+	before := calls.Load()
+	getAs("alice", "/api/github/tree/owner/private")
+	if calls.Load() != before+1 {
+		t.Fatal("token-backed listing served from cache")
+	}
+}
+
+func TestGitHubTreeTokenForAnonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[github]
+token = "secret"
+tokenForAnonymous = true
+`)
+	h := newTestHandlers()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"name":"secret.txt","path":"secret.txt","type":"file","size":1}]`))
+	}))
+	defer upstream.Close()
+	original := githubAPIBase
+	githubAPIBase = upstream.URL
+	t.Cleanup(func() { githubAPIBase = original })
+
+	router := gin.New()
+	h.InitGitHubTreeRoutes(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/github/tree/owner/private", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("opt-in anonymous listing: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+)
+
+// Deps 处理器共用的组件，由server创建后注入
+type Deps struct {
+	// Components 统计、内存保护、调度、审计等与代理共用的状态
+	*utils.Components
+
+	Cache   *utils.UniversalCache
+	Hot     *utils.HotCache
+	Access  *accesscontrol.Controller
+	Clients *utils.HTTPClients
+	Limiter *ratelimit.Limiter
+	Docker  *dockerproxy.Proxy
+	GitHub  *githubproxy.Proxy
+	// Reloader 重新加载配置并通知各组件，管理接口和信号处理共用，并记录最近一次重载的结果
+	Reloader *config.Reloader
+}
+
+// Handlers 管理接口、镜像下载、搜索等路由的处理器
+type Handlers struct {
+	Deps
+
+	streamer *ImageStreamer
+
+	capabilitiesMu     sync.Mutex
+	capabilitiesCached *capabilitiesInfo
+
+	oidcVerifierMu  sync.Mutex
+	oidcVerifier    *utils.OIDCVerifier
+	oidcVerifierKey string
+
+	// basicAuth bcrypt校验通过的凭据摘要
+	basicAuth basicAuthCache
+	// adminLimiter /admin 下请求使用的限流器
+	adminLimiter adminRateLimiter
+
+	tarJobs         *TarJobLimiter
+	singleDebouncer *DownloadDebouncer
+	batchDebouncer  *DownloadDebouncer
+	singleTokens    *tokenStore[SingleDownloadRequest]
+	batchTokens     *tokenStore[BatchDownloadRequest]
+	artifactDigests *artifactDigestStore
+
+	copyJobs      *copyJobStore
+	prefetchJobs  *prefetchJobStore
+	cachePullJobs *cachePullJobStore
+	// cachePullBandwidth 所有缓存拉取任务共用的带宽限制
+	cachePullBandwidth cachePullBandwidth
+
+	// searchCache 搜索、标签、目录树和校验结果的缓存
+	searchCache *Cache
+	// searchFlight 合并同时进行的相同搜索，避免每次按键的请求都打到Docker Hub
+	searchFlight *utils.RequestCoalescer
+	// mountedRoutes 注册路由表接口时从gin路由表生成的分组
+	mountedRoutes []routeGroup
+}
+
+// New 使用注入的组件创建处理器，下载防抖窗口取自当前配置
+func New(deps Deps) *Handlers {
+	cfg := deps.Config.Get()
+	tarJobs := NewTarJobLimiter(deps.Config, deps.Memory)
+	return &Handlers{
+		Deps:            deps,
+		streamer:        NewImageStreamer(&ImageStreamerConfig{Transport: deps.Clients.Transport(), Jobs: tarJobs, Config: deps.Config}),
+		basicAuth:       basicAuthCache{entries: make(map[string]time.Time)},
+		tarJobs:         tarJobs,
+		singleDebouncer: NewDownloadDebouncer(utils.ParseDurationOr(cfg.Debounce.DownloadWindow, 5*time.Second)),
+		batchDebouncer:  NewDownloadDebouncer(utils.ParseDurationOr(cfg.Debounce.BatchDownloadWindow, 60*time.Second)),
+		singleTokens:    newTokenStore[SingleDownloadRequest](),
+		batchTokens:     newTokenStore[BatchDownloadRequest](),
+		artifactDigests: &artifactDigestStore{digests: make(map[string]artifactDigest)},
+		copyJobs:        &copyJobStore{jobs: make(map[string]*CopyJob)},
+		prefetchJobs:    &prefetchJobStore{jobs: make(map[string]*PrefetchJob)},
+		cachePullJobs:   &cachePullJobStore{jobs: make(map[string]*CachePullJob)},
+		searchCache:     newSearchCache(deps.Background.Context(), deps.Config),
+		searchFlight:    utils.NewRequestCoalescer(),
+	}
+}
+
+// Reloadables 返回配置重载后需要清除的处理器缓存
+func (h *Handlers) Reloadables() []config.Reloadable {
+	return []config.Reloadable{
+		config.ReloadFunc{Fn: func(_, _ *config.AppConfig) {
+			h.capabilitiesMu.Lock()
+			h.capabilitiesCached = nil
+			h.capabilitiesMu.Unlock()
+		}},
+		config.ReloadFunc{Sections: []string{"health"}, Fn: func(_, _ *config.AppConfig) {
+			h.Stats.InvalidateHealthSummary()
+		}},
+	}
+}
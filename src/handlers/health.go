@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// statusPageRow 状态页中的一行，按统计窗口顺序排列
+type statusPageRow struct {
+	Name    string
+	Windows []utils.AvailabilityStats
+}
+
+// statusPageData 状态页模板参数
+type statusPageData struct {
+	Summary   *utils.HealthSummary
+	Windows   []string
+	Routes    []statusPageRow
+	Upstreams []statusPageRow
+}
+
+// statusPageWindows 状态页展示的统计窗口
+var statusPageWindows = []string{"5m", "1h", "24h"}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(rate float64) float64 { return rate * 100 },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>HubProxy 服务状态</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:960px;margin:2em auto;padding:0 1em;color:#222}
+.status{padding:1em;border-radius:6px;font-size:1.2em;color:#fff}
+.ok{background:#2e7d32}.degraded{background:#ef6c00}.down{background:#c62828}
+table{border-collapse:collapse;width:100%;margin:1em 0}
+th,td{border-bottom:1px solid #ddd;padding:.4em;text-align:right}
+th:first-child,td:first-child{text-align:left}
+.muted{color:#888}
+</style>
+</head>
+<body>
+<h1>HubProxy 服务状态</h1>
+<div class="status {{.Summary.Status}}">
+{{- if eq .Summary.Status "ok"}}所有服务运行正常{{else if eq .Summary.Status "degraded"}}部分服务性能下降{{else}}服务不可用{{end -}}
+</div>
+{{- if .Summary.Reasons}}
+<ul>{{range .Summary.Reasons}}<li>{{.}}</li>{{end}}</ul>
+{{- end}}
+{{define "rows"}}{{range .}}
+<tr><td>{{.Name}}</td>{{range .Windows}}<td>{{if .Requests}}{{printf "%.2f" (percent .SuccessRate)}}% / {{printf "%.0f" .P95Ms}}ms{{else}}<span class="muted">无请求</span>{{end}}</td>{{end}}</tr>
+{{- end}}{{end}}
+<table>
+<tr><th>路由类别</th>{{range .Windows}}<th>{{.}} 成功率 / p95</th>{{end}}</tr>
+{{- template "rows" .Routes}}
+</table>
+{{- if .Upstreams}}
+<table>
+<tr><th>上游</th>{{range .Windows}}<th>{{.}} 成功率 / p95</th>{{end}}</tr>
+{{- template "rows" .Upstreams}}
+</table>
+{{- end}}
+<p class="muted">更新于 {{.Summary.Time}}，每30秒自动刷新</p>
+</body>
+</html>
+`))
+
+// availabilityRow 按统计窗口顺序展开一个维度
+func availabilityRow(name string, windows map[string]utils.AvailabilityStats) statusPageRow {
+	row := statusPageRow{Name: name}
+	for _, window := range statusPageWindows {
+		row.Windows = append(row.Windows, windows[window])
+	}
+	return row
+}
+
+// availabilityRows 按名称排序的各维度
+func availabilityRows(series map[string]map[string]utils.AvailabilityStats) []statusPageRow {
+	rows := make([]statusPageRow, 0, len(series))
+	for name, windows := range series {
+		rows = append(rows, availabilityRow(name, windows))
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+// handleHealthSummary 返回最近5分钟、1小时、24小时按路由类别和上游主机统计的成功率和p95耗时
+func (h *Handlers) handleHealthSummary(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Stats.HealthSummary(h.Config.Get(), h.Memory))
+}
+
+// handleStatusPage 渲染简单的服务状态页
+func (h *Handlers) handleStatusPage(c *gin.Context) {
+	cfg := h.Config.Get()
+	if !cfg.Health.StatusPage {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeEndpointNotFound)
+		return
+	}
+
+	summary := h.Stats.HealthSummary(cfg, h.Memory)
+	data := statusPageData{
+		Summary:   summary,
+		Windows:   statusPageWindows,
+		Routes:    append([]statusPageRow{availabilityRow("全部", summary.Overall)}, availabilityRows(summary.Routes)...),
+		Upstreams: availabilityRows(summary.Upstreams),
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	if err := statusPageTemplate.Execute(c.Writer, data); err != nil {
+		c.Error(err)
+	}
+}
+
+// InitHealthSummaryRoutes 注册可用性汇总和状态页路由
+func (h *Handlers) InitHealthSummaryRoutes(router *gin.Engine) {
+	router.Match([]string{http.MethodGet, http.MethodHead}, "/health/summary", h.handleHealthSummary)
+	router.Match([]string{http.MethodGet, http.MethodHead}, "/status", h.handleStatusPage)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100
+)
+
+// historyInitiator 返回下载发起者，已认证时为用户名或令牌名，否则为脱敏后的客户端IP
+func (h *Handlers) historyInitiator(c *gin.Context) string {
+	if user := c.GetString(utils.AuthUserKey); user != "" {
+		return user
+	}
+	return h.IPs.Identify(c.ClientIP(), true)
+}
+
+// recordDownloadHistory 异步记录一次已结束的下载，客户端拒绝被记录时跳过
+func (h *Handlers) recordDownloadHistory(c *gin.Context, kind, target string, err error) {
+	cfg := h.Config.Get()
+	if !cfg.History.Enabled || utils.DoNotTrack(c) {
+		return
+	}
+
+	status := utils.HistoryCompleted
+	switch {
+	case err != nil:
+		status = utils.HistoryFailed
+	case c.Request.Context().Err() != nil:
+		status = utils.HistoryInterrupted
+	}
+	size := int64(c.Writer.Size())
+	if size < 0 {
+		size = 0
+	}
+
+	h.History.Record(utils.HistoryEntry{
+		Time:      time.Now(),
+		Type:      kind,
+		Target:    target,
+		Size:      size,
+		Status:    status,
+		Initiator: h.historyInitiator(c),
+	})
+}
+
+// recordGitHubHistory 记录成功返回的GitHub文件下载，页面和API请求不计入
+func (h *Handlers) recordGitHubHistory(c *gin.Context) {
+	if c.Request.Method != http.MethodGet {
+		return
+	}
+	if status := c.Writer.Status(); status != http.StatusOK && status != http.StatusPartialContent {
+		return
+	}
+	target, _, err := githubproxy.NormalizeRequestURI(c.Request.URL.RequestURI())
+	if err != nil || !githubproxy.ImpliesRawFile(target) {
+		return
+	}
+	h.recordDownloadHistory(c, utils.HistoryTypeGitHub, target, nil)
+}
+
+// historyStore 返回下载历史库，未启用或加载失败时写入错误响应
+func (h *Handlers) historyStore(c *gin.Context) (*utils.HistoryStore, bool) {
+	cfg := h.Config.Get()
+	if !cfg.History.Enabled {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeFeatureDisabled, "下载历史未启用")
+		return nil, false
+	}
+	store, err := h.History.Store()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "加载下载历史失败: "+err.Error())
+		return nil, false
+	}
+	return store, true
+}
+
+// handleListHistory 按时间倒序分页返回下载历史
+func (h *Handlers) handleListHistory(c *gin.Context) {
+	kind := c.Query("type")
+	if kind != "" && kind != utils.HistoryTypeGitHub && kind != utils.HistoryTypeImageTar {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "type参数无效，可选值为github或image-tar")
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "page参数无效")
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultHistoryPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxHistoryPageSize {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "page_size参数无效，范围为1-"+strconv.Itoa(maxHistoryPageSize))
+		return
+	}
+
+	store, ok := h.historyStore(c)
+	if !ok {
+		return
+	}
+	items, total := store.List(kind, page, pageSize)
+	c.JSON(http.StatusOK, gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"has_more":  page*pageSize < total,
+	})
+}
+
+// handleDeleteHistory 删除一条下载历史
+func (h *Handlers) handleDeleteHistory(c *gin.Context) {
+	store, ok := h.historyStore(c)
+	if !ok {
+		return
+	}
+	if err := store.Delete(c.Param("id")); err != nil {
+		if errors.Is(err, utils.ErrHistoryNotFound) {
+			utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+			return
+		}
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "删除下载历史失败: "+err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// InitHistoryRoutes 注册下载历史路由
+func (h *Handlers) InitHistoryRoutes(router *gin.Engine) {
+	router.GET("/api/history", h.handleListHistory)
+	router.DELETE("/api/history/:id", h.handleDeleteHistory)
+}
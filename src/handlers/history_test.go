@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, fmt.Sprintf(`
+[history]
+enabled = true
+path = %q
+`, filepath.Join(t.TempDir(), "history.jsonl")))
+
+	h := newTestHandlers()
+	store, err := h.History.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i, kind := range []string{utils.HistoryTypeGitHub, utils.HistoryTypeImageTar, utils.HistoryTypeImageTar} {
+		entry, err := store.Add(utils.HistoryEntry{Time: time.Now(), Type: kind, Target: fmt.Sprintf("t%d", i), Status: utils.HistoryCompleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, entry.ID)
+	}
+
+	router := gin.New()
+	h.InitHistoryRoutes(router)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/history?type=image-tar&page=1&page_size=1")
+	var body struct {
+		Items   []utils.HistoryEntry `json:"items"`
+		Total   int                  `json:"total"`
+		HasMore bool                 `json:"has_more"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("list status %d: %s", w.Code, w.Body.String())
+	}
+	if body.Total != 2 || !body.HasMore || len(body.Items) != 1 || body.Items[0].Target != "t2" {
+		t.Fatalf("list body = %+v", body)
+	}
+
+	if w := do(http.MethodGet, "/api/history?type=npm"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid type status = %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/api/history/"+ids[0]); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/api/history/"+ids[0]); w.Code != http.StatusNotFound {
+		t.Fatalf("repeated delete status = %d", w.Code)
+	}
+	if _, total := store.List("", 1, 10); total != 2 {
+		t.Fatalf("total after delete = %d", total)
+	}
+
+	loadTestConfig(t, "[history]\nenabled = false\n")
+	h.Config.Set(config.GetConfig())
+	if w := do(http.MethodGet, "/api/history"); w.Code != http.StatusNotFound {
+		t.Fatalf("disabled status = %d", w.Code)
+	}
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CopyAuth 目标Registry认证信息，不会出现在日志和任务状态中
+type CopyAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// ImageCopyRequest 镜像复制请求
+type ImageCopyRequest struct {
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	TargetAuth *CopyAuth `json:"targetAuth"`
+	Platform   string    `json:"platform"`
+}
+
+// 复制任务状态
+const (
+	CopyStatusRunning   = "running"
+	CopyStatusSucceeded = "succeeded"
+	CopyStatusFailed    = "failed"
+	CopyStatusCancelled = "cancelled"
+)
+
+// copyJobRetention 已结束任务的保留时间
+const copyJobRetention = 10 * time.Minute
+
+// CopyJob 镜像复制任务
+type CopyJob struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Platform   string    `json:"platform,omitempty"`
+	Status     string    `json:"status"`
+	Total      int64     `json:"total"`
+	Complete   int64     `json:"complete"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// copyJobStore 复制任务表
+type copyJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*CopyJob
+}
+
+func (s *copyJobStore) add(job *CopyJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.jobs {
+		if !existing.FinishedAt.IsZero() && now.Sub(existing.FinishedAt) > copyJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// snapshot 返回任务状态副本
+func (s *copyJobStore) snapshot(id string) (CopyJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return CopyJob{}, false
+	}
+	return *job, true
+}
+
+func (s *copyJobStore) get(id string) (*CopyJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	return job, exists
+}
+
+func (s *copyJobStore) update(job *CopyJob, fn func(job *CopyJob)) {
+	s.mu.Lock()
+	fn(job)
+	s.mu.Unlock()
+}
+
+// targetAuthenticator 根据请求构建目标Registry认证
+func targetAuthenticator(auth *CopyAuth) authn.Authenticator {
+	if auth == nil || (auth.Username == "" && auth.Password == "" && auth.Token == "") {
+		return authn.Anonymous
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		RegistryToken: auth.Token,
+	})
+}
+
+// resolveCopySource 获取待复制的源镜像，多架构索引在未指定平台时整体复制以保持digest不变
+func (h *Handlers) resolveCopySource(ctx context.Context, source name.Reference, platform string) (remote.Taggable, error) {
+	sourceOptions := append(h.streamer.remoteOptions, remote.WithContext(ctx))
+
+	desc, err := remote.Get(source, sourceOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("获取源镜像失败: %w", err)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		return desc.Image()
+	}
+	if platform == "" {
+		return desc.ImageIndex()
+	}
+	return h.streamer.selectPlatformImage(desc, &StreamOptions{Platform: platform})
+}
+
+// runCopyJob 执行复制任务并更新进度
+func (h *Handlers) runCopyJob(ctx context.Context, job *CopyJob, source, target name.Reference, auth *CopyAuth) {
+	defer close(job.done)
+	defer job.cancel()
+
+	artifact, err := h.resolveCopySource(ctx, source, job.Platform)
+	if err == nil {
+		updates := make(chan v1.Update, 16)
+		progressDone := make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			for update := range updates {
+				h.copyJobs.update(job, func(job *CopyJob) {
+					job.Total = update.Total
+					job.Complete = update.Complete
+				})
+			}
+		}()
+
+		err = remote.Push(target, artifact,
+			remote.WithContext(ctx),
+			remote.WithAuth(targetAuthenticator(auth)),
+			remote.WithUserAgent("hubproxy/go-containerregistry"),
+			remote.WithTransport(h.Clients.Transport()),
+			remote.WithProgress(updates),
+		)
+		<-progressDone
+	}
+
+	h.copyJobs.update(job, func(job *CopyJob) {
+		job.FinishedAt = time.Now()
+		switch {
+		case err == nil:
+			job.Status = CopyStatusSucceeded
+		case ctx.Err() != nil:
+			job.Status = CopyStatusCancelled
+			job.Error = "任务已取消"
+		default:
+			job.Status = CopyStatusFailed
+			job.Error = err.Error()
+		}
+	})
+
+	if err != nil {
+		fmt.Printf("镜像复制失败 %s -> %s: %v\n", job.Source, job.Target, err)
+		return
+	}
+	fmt.Printf("镜像复制完成 %s -> %s\n", job.Source, job.Target)
+}
+
+// handleImageCopy 创建镜像复制任务
+func (h *Handlers) handleImageCopy(c *gin.Context) {
+	cfg := h.Config.Get()
+	var req ImageCopyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+		return
+	}
+
+	req.Source = strings.TrimSpace(req.Source)
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Source == "" || req.Target == "" {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "source和target不能为空")
+		return
+	}
+	var ok bool
+	if req.Source, ok = dockerproxy.NormalizeImageParam(cfg, c, req.Source); !ok {
+		return
+	}
+	if req.Target, ok = dockerproxy.NormalizeImageParam(cfg, c, req.Target); !ok {
+		return
+	}
+
+	source, err := name.ParseReference(req.Source)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "源镜像引用格式错误: "+err.Error())
+		return
+	}
+	target, err := name.ParseReference(req.Target)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "目标镜像引用格式错误: "+err.Error())
+		return
+	}
+
+	if allowed, reason := h.Access.CheckDockerAccess(req.Source); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
+		return
+	}
+
+	// 任务不随创建请求结束，服务停止时取消
+	ctx, cancel := context.WithCancel(h.Background.Context())
+	job := &CopyJob{
+		ID:        newJobID(),
+		Source:    source.String(),
+		Target:    target.String(),
+		Platform:  req.Platform,
+		Status:    CopyStatusRunning,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	h.copyJobs.add(job)
+
+	fmt.Printf("开始复制镜像 %s -> %s\n", job.Source, job.Target)
+	go h.runCopyJob(ctx, job, source, target, req.TargetAuth)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": "/api/copy/" + job.ID,
+		"events_url": "/api/copy/" + job.ID + "/events",
+	})
+}
+
+// handleImageCopyStatus 查询复制任务状态，?wait=30s 时等待任务结束或超时后返回当前进度
+func (h *Handlers) handleImageCopyStatus(c *gin.Context) {
+	wait, ok := parseJobWait(c)
+	if !ok {
+		return
+	}
+	running, exists := h.copyJobs.get(c.Param("id"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-running.done:
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+		}
+		timer.Stop()
+	}
+	job, _ := h.copyJobs.snapshot(running.ID)
+	c.JSON(http.StatusOK, job)
+}
+
+// handleImageCopyEvents 以SSE推送复制任务进度，任务结束后发送done事件
+func (h *Handlers) handleImageCopyEvents(c *gin.Context) {
+	job, exists := h.copyJobs.get(c.Param("id"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	var lastComplete int64 = -1
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-job.done:
+			snapshot, _ := h.copyJobs.snapshot(job.ID)
+			c.SSEvent("done", snapshot)
+			return false
+		case <-ticker.C:
+			snapshot, _ := h.copyJobs.snapshot(job.ID)
+			if snapshot.Complete != lastComplete {
+				lastComplete = snapshot.Complete
+				c.SSEvent("progress", snapshot)
+			}
+			return true
+		}
+	})
+}
+
+// handleImageCopyCancel 取消复制任务
+func (h *Handlers) handleImageCopyCancel(c *gin.Context) {
+	job, exists := h.copyJobs.get(c.Param("id"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+	job.cancel()
+	c.JSON(http.StatusOK, gin.H{"id": job.ID, "status": "cancelling"})
+}
+
+// InitImageCopyRoutes 注册镜像复制路由，需要管理令牌
+func (h *Handlers) InitImageCopyRoutes(router *gin.Engine) {
+	copyAPI := router.Group("/api/copy", h.AdminAuthMiddleware())
+	{
+		copyAPI.POST("", h.handleImageCopy)
+		copyAPI.GET("/:id", h.handleImageCopyStatus)
+		copyAPI.GET("/:id/events", h.handleImageCopyEvents)
+		copyAPI.DELETE("/:id", h.handleImageCopyCancel)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestRunCopyJobPreservesIndexDigest(t *testing.T) {
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+
+	sourceServer := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer sourceServer.Close()
+	targetServer := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer targetServer.Close()
+
+	sourceHost := strings.TrimPrefix(sourceServer.URL, "http://")
+	targetHost := strings.TrimPrefix(targetServer.URL, "http://")
+
+	index, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _ := name.ParseReference(sourceHost + "/org/app:v1")
+	target, _ := name.ParseReference(targetHost + "/project/app:v1")
+	if err := remote.WriteIndex(source, index); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &CopyJob{ID: "test", Status: CopyStatusRunning, cancel: cancel, done: make(chan struct{})}
+	h.copyJobs.add(job)
+	h.runCopyJob(ctx, job, source, target, nil)
+
+	snapshot, _ := h.copyJobs.snapshot("test")
+	if snapshot.Status != CopyStatusSucceeded {
+		t.Fatalf("status = %s, error = %s", snapshot.Status, snapshot.Error)
+	}
+
+	want, _ := index.Digest()
+	desc, err := remote.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != want {
+		t.Fatalf("target digest = %s, want %s", desc.Digest, want)
+	}
+}
+
+func TestTargetAuthenticatorAnonymousWhenEmpty(t *testing.T) {
+	if auth := targetAuthenticator(&CopyAuth{}); auth != authn.Anonymous {
+		t.Fatalf("empty auth = %v, want anonymous", auth)
+	}
+	cfg, err := targetAuthenticator(&CopyAuth{Username: "u", Password: "p"}).Authorization()
+	if err != nil || cfg.Username != "u" || cfg.Password != "p" {
+		t.Fatalf("basic auth config = %+v, %v", cfg, err)
+	}
+}
+
+func TestImageCopyStatusWait(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newTestHandlers()
+	job := &CopyJob{ID: "wait-test", Status: CopyStatusRunning, cancel: func() {}, done: make(chan struct{})}
+	h.copyJobs.add(job)
+
+	status := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/copy/wait-test"+query, nil)
+		c.Params = gin.Params{{Key: "id", Value: "wait-test"}}
+		h.handleImageCopyStatus(c)
+		return w
+	}
+
+	if w := status("?wait=1x"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid wait: %d", w.Code)
+	}
+	if w := status("?wait=20ms"); !strings.Contains(w.Body.String(), `"status":"running"`) {
+		t.Fatalf("timed out poll: %s", w.Body.String())
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		h.copyJobs.update(job, func(job *CopyJob) { job.Status = CopyStatusSucceeded })
+		close(job.done)
+	})
+	if w := status("?wait=10s"); !strings.Contains(w.Body.String(), `"status":"succeeded"`) {
+		t.Fatalf("long poll: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// preflightCacheTTL 预检结论的缓存时间。页面在表单每次变化时调用预检，短时间内重复的引用不再访问上游
+const preflightCacheTTL = 30 * time.Second
+
+// 预检结论的原因
+const (
+	preflightOK                  = "ok"
+	preflightDenied              = "denied"
+	preflightNotFound            = "not_found"
+	preflightUnauthorized        = "unauthorized"
+	preflightRateLimited         = "rate_limited"
+	preflightPlatformUnavailable = "platform_unavailable"
+	preflightUpstreamError       = "upstream_error"
+)
+
+// imagePreflight /api/image/preflight 的结论。resolvedDigest为引用当前指向的manifest或索引，
+// 多架构镜像的platformDigest和estimatedBytes对应选中的平台
+type imagePreflight struct {
+	Image          string   `json:"image"`
+	Exists         bool     `json:"exists"`
+	Allowed        bool     `json:"allowed"`
+	Reason         string   `json:"reason"`
+	Message        string   `json:"message,omitempty"`
+	ResolvedDigest string   `json:"resolvedDigest,omitempty"`
+	MediaType      string   `json:"mediaType,omitempty"`
+	Platform       string   `json:"platform,omitempty"`
+	PlatformDigest string   `json:"platformDigest,omitempty"`
+	EstimatedBytes int64    `json:"estimatedBytes,omitempty"`
+	Platforms      []string `json:"platforms,omitempty"`
+	RetryAfter     int      `json:"retryAfter,omitempty"`
+}
+
+// handleImagePreflight 在创建下载任务前检查镜像：名单检查、匿名令牌和manifest解析，
+// 多架构镜像再解析选中平台的manifest估算大小，不下载任何层。上游结果按引用和平台短时缓存
+func (h *Handlers) handleImagePreflight(c *gin.Context) {
+	cfg := h.Config.Get()
+	imageRef := strings.TrimSpace(c.Query("image"))
+	if imageRef == "" {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "缺少image参数")
+		return
+	}
+	platform := strings.TrimSpace(c.Query("platform"))
+
+	imageRef, ok := dockerproxy.NormalizeImageParam(cfg, c, imageRef)
+	if !ok {
+		return
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "镜像引用格式错误: "+err.Error())
+		return
+	}
+
+	verdict := &imagePreflight{Image: ref.Name()}
+	if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+		verdict.Reason = preflightDenied
+		verdict.Message = utils.Localize(c, reason)
+		c.JSON(http.StatusOK, verdict)
+		return
+	}
+	verdict.Allowed = true
+
+	cacheKey := utils.BuildCacheKey("preflight", ref.Name()+"|"+platform)
+	if utils.IsCacheEnabled(cfg) {
+		if item := h.Cache.Get(cacheKey); item != nil {
+			c.Header("X-Cache", "HIT")
+			c.Data(http.StatusOK, "application/json; charset=utf-8", item.Data)
+			return
+		}
+	}
+
+	if cacheable := h.resolvePreflight(c, ref, platform, verdict); cacheable && utils.IsCacheEnabled(cfg) {
+		if data, err := json.Marshal(verdict); err == nil {
+			h.Cache.Set(cacheKey, data, "application/json", nil, preflightCacheTTL)
+		}
+	}
+	c.JSON(http.StatusOK, verdict)
+}
+
+// resolvePreflight 经上游解析镜像并填写结论，返回结论是否可以缓存：限流和上游故障是暂时的，不缓存
+func (h *Handlers) resolvePreflight(c *gin.Context, ref name.Reference, platform string, verdict *imagePreflight) bool {
+	// 预检需要尽快返回，上游出错时不重试
+	options := append(append([]remote.Option(nil), h.streamer.remoteOptions...),
+		remote.WithContext(c.Request.Context()), remote.WithRetryBackoff(remote.Backoff{Steps: 1}))
+
+	desc, err := h.streamer.getImageDescriptor(ref, options)
+	if err != nil {
+		return h.preflightFailure(c, verdict, err)
+	}
+	verdict.Exists = true
+	verdict.ResolvedDigest = desc.Digest.String()
+	verdict.MediaType = string(desc.MediaType)
+
+	if desc.MediaType != types.OCIImageIndex && desc.MediaType != types.DockerManifestList {
+		img, err := desc.Image()
+		if err == nil {
+			verdict.EstimatedBytes, err = manifestSize(img)
+		}
+		if err != nil {
+			return h.preflightFailure(c, verdict, err)
+		}
+		verdict.Reason = preflightOK
+		return true
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return h.preflightFailure(c, verdict, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return h.preflightFailure(c, verdict, err)
+	}
+	verdict.Platforms = indexPlatforms(manifest)
+	selected, matched := selectPlatformDescriptor(manifest, platform)
+	if selected == nil || (platform != "" && !matched) {
+		verdict.Reason = preflightPlatformUnavailable
+		verdict.Message = "镜像不支持平台 " + platform
+		return true
+	}
+	if selected.Platform != nil {
+		verdict.Platform = platformString(selected.Platform)
+	}
+	verdict.PlatformDigest = selected.Digest.String()
+
+	img, err := index.Image(selected.Digest)
+	if err == nil {
+		verdict.EstimatedBytes, err = manifestSize(img)
+	}
+	if err != nil {
+		return h.preflightFailure(c, verdict, err)
+	}
+	verdict.Reason = preflightOK
+	return true
+}
+
+// preflightFailure 把上游错误映射为结论的原因，返回结论是否可以缓存
+func (h *Handlers) preflightFailure(c *gin.Context, verdict *imagePreflight, err error) bool {
+	if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+		verdict.Reason = preflightRateLimited
+		verdict.Message = utils.Localize(c, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+		verdict.RetryAfter = max(int(math.Ceil(budgetErr.RetryAfter.Seconds())), 1)
+		return false
+	}
+
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		verdict.Reason = preflightUpstreamError
+		verdict.Message = err.Error()
+		return false
+	}
+	switch terr.StatusCode {
+	case http.StatusNotFound:
+		verdict.Reason = preflightNotFound
+		verdict.Message = "镜像或标签不存在"
+		return true
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// Docker Hub对不存在的仓库同样返回401
+		verdict.Reason = preflightUnauthorized
+		verdict.Message = "上游拒绝匿名拉取，镜像可能是私有的或不存在"
+		return true
+	case http.StatusTooManyRequests:
+		verdict.Reason = preflightRateLimited
+		host := ""
+		if terr.Request != nil {
+			host = terr.Request.URL.Hostname()
+			if wait, ok := h.Clients.UpstreamThrottleRemaining(host); ok {
+				utils.SetRetryAfter(c, wait)
+				verdict.RetryAfter = max(int(math.Ceil(wait.Seconds())), 1)
+			}
+		}
+		verdict.Message = utils.Localize(c, utils.ErrCodeUpstreamThrottled, host)
+		return false
+	default:
+		verdict.Reason = preflightUpstreamError
+		verdict.Message = err.Error()
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestImagePreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["*/blocked"]
+`)
+	h := newTestHandlers()
+
+	var throttled atomic.Bool
+	upstream := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttled.Load() && strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	single, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	singleSize, _ := manifestSize(single)
+	ref, _ := name.ParseReference(host + "/org/app:v1")
+	if err := remote.Write(ref, single); err != nil {
+		t.Fatal(err)
+	}
+
+	amd64, _ := random.Image(512, 1)
+	arm64, _ := random.Image(2048, 3)
+	arm64Size, _ := manifestSize(arm64)
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}}},
+	)
+	indexDigest, _ := index.Digest()
+	arm64Digest, _ := arm64.Digest()
+	ref, _ = name.ParseReference(host + "/org/multi:v1")
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/image/preflight", h.handleImagePreflight)
+	preflight := func(image, platform string) imagePreflight {
+		t.Helper()
+		query := url.Values{"image": {image}}
+		if platform != "" {
+			query.Set("platform", platform)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/image/preflight?"+query.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", image, w.Code, w.Body.String())
+		}
+		var verdict imagePreflight
+		if err := json.Unmarshal(w.Body.Bytes(), &verdict); err != nil {
+			t.Fatal(err)
+		}
+		return verdict
+	}
+
+	if got := preflight(host+"/org/blocked:v1", ""); got.Allowed || got.Reason != preflightDenied || got.Message == "" {
+		t.Errorf("denied: %+v", got)
+	}
+	if got := preflight(host+"/org/missing:v1", ""); got.Exists || !got.Allowed || got.Reason != preflightNotFound {
+		t.Errorf("missing: %+v", got)
+	}
+	if got := preflight(host+"/org/app:v1", ""); !got.Exists || got.Reason != preflightOK || got.EstimatedBytes != singleSize || got.Platforms != nil {
+		t.Errorf("single: %+v, want %d bytes", got, singleSize)
+	}
+
+	got := preflight(host+"/org/multi:v1", "linux/arm64/v8")
+	if got.Reason != preflightOK || got.ResolvedDigest != indexDigest.String() || got.PlatformDigest != arm64Digest.String() ||
+		got.Platform != "linux/arm64/v8" || got.EstimatedBytes != arm64Size {
+		t.Errorf("multi-arch: %+v, want %s %d bytes", got, arm64Digest, arm64Size)
+	}
+	if strings.Join(got.Platforms, ",") != "linux/amd64,linux/arm64/v8" {
+		t.Errorf("platforms = %v", got.Platforms)
+	}
+	if got := preflight(host+"/org/multi:v1", "linux/s390x"); !got.Exists || got.Reason != preflightPlatformUnavailable || len(got.Platforms) != 2 {
+		t.Errorf("unavailable platform: %+v", got)
+	}
+
+	// 限流是暂时的，结论不缓存，上游恢复后重新解析
+	throttled.Store(true)
+	if got := preflight(host+"/org/other:v1", ""); got.Reason != preflightRateLimited || got.Exists || got.RetryAfter == 0 {
+		t.Errorf("throttled: %+v", got)
+	}
+	throttled.Store(false)
+	if got := preflight(host+"/org/other:v1", ""); got.Reason != preflightNotFound {
+		t.Errorf("after throttle: %+v", got)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"context"
 	"crypto/md5"
@@ -19,6 +20,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -26,8 +30,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
-	"hubproxy/config"
-	"hubproxy/utils"
+	"github.com/klauspost/compress/zstd"
 )
 
 // DebounceEntry 防抖条目
@@ -102,12 +105,12 @@ func generateContentFingerprint(images []string, platform string) string {
 }
 
 // getUserID 获取用户标识
-func getUserID(c *gin.Context) string {
+func (h *Handlers) getUserID(c *gin.Context) string {
 	if sessionID, err := c.Cookie("session_id"); err == nil && sessionID != "" {
 		return "session:" + sessionID
 	}
 
-	ip := c.ClientIP()
+	ip := h.IPs.Client(c)
 	userAgent := c.GetHeader("User-Agent")
 	if userAgent == "" {
 		userAgent = "unknown"
@@ -118,8 +121,9 @@ func getUserID(c *gin.Context) string {
 	return "ip:" + hex.EncodeToString(hash[:8])
 }
 
-func getClientIdentity(c *gin.Context) (string, string) {
-	ip := c.ClientIP()
+// getClientIdentity 获取客户端标识和User-Agent，启用noIPLogging时标识为IP摘要
+func (h *Handlers) getClientIdentity(c *gin.Context) (string, string) {
+	ip := h.IPs.Client(c)
 	userAgent := c.GetHeader("User-Agent")
 	if userAgent == "" {
 		userAgent = "unknown"
@@ -127,27 +131,18 @@ func getClientIdentity(c *gin.Context) (string, string) {
 	return ip, userAgent
 }
 
-var (
-	singleImageDebouncer *DownloadDebouncer
-	batchImageDebouncer  *DownloadDebouncer
-)
-
-// InitDebouncer 初始化防抖器
-func InitDebouncer() {
-	singleImageDebouncer = NewDownloadDebouncer(5 * time.Second)
-	batchImageDebouncer = NewDownloadDebouncer(60 * time.Second)
-}
-
 type BatchDownloadRequest struct {
 	Images              []string
 	Platform            string
 	UseCompressedLayers bool
+	Compression         string
 }
 
 type SingleDownloadRequest struct {
 	Image               string
 	Platform            string
 	UseCompressedLayers bool
+	Compression         string
 }
 
 type tokenEntry[T any] struct {
@@ -227,18 +222,23 @@ func (s *tokenStore[T]) cleanup(now time.Time) {
 	}
 }
 
-var batchDownloadTokens = newTokenStore[BatchDownloadRequest]()
-var singleDownloadTokens = newTokenStore[SingleDownloadRequest]()
-
 // ImageStreamer 镜像流式下载器
 type ImageStreamer struct {
 	concurrency   int
 	remoteOptions []remote.Option
+	jobs          *TarJobLimiter
+	config        *config.Store
 }
 
 // ImageStreamerConfig 下载器配置
 type ImageStreamerConfig struct {
 	Concurrency int
+	// Transport 访问上游Registry的传输层，为nil时使用默认传输层
+	Transport http.RoundTripper
+	// Jobs 累计下载任务已输出字节数的任务表，为nil时不统计
+	Jobs *TarJobLimiter
+	// Config 提供默认并发数和tar保活间隔的配置，为nil时使用默认并发数且不写入保活条目
+	Config *config.Store
 }
 
 // NewImageStreamer 创建镜像下载器
@@ -249,29 +249,110 @@ func NewImageStreamer(cfg *ImageStreamerConfig) *ImageStreamer {
 
 	concurrency := cfg.Concurrency
 	if concurrency <= 0 {
-		appCfg := config.GetConfig()
-		concurrency = appCfg.Download.MaxImages
+		if cfg.Config != nil {
+			concurrency = cfg.Config.Get().Download.MaxImages
+		}
 		if concurrency <= 0 {
 			concurrency = 10
 		}
 	}
 
-	remoteOptions := []remote.Option{
-		remote.WithAuth(authn.Anonymous),
-		remote.WithTransport(utils.GetGlobalHTTPClient().Transport),
+	remoteOptions := []remote.Option{remote.WithAuth(authn.Anonymous)}
+	if cfg.Transport != nil {
+		remoteOptions = append(remoteOptions, remote.WithTransport(cfg.Transport))
 	}
 
 	return &ImageStreamer{
 		concurrency:   concurrency,
 		remoteOptions: remoteOptions,
+		jobs:          cfg.Jobs,
+		config:        cfg.Config,
 	}
 }
 
-// StreamOptions 下载选项
+// keepaliveInterval 返回配置的tar保活间隔，未配置时为0
+func (is *ImageStreamer) keepaliveInterval() time.Duration {
+	if is.config == nil {
+		return 0
+	}
+	return utils.ParseTimeout(is.config.Get().Download.KeepaliveInterval, 0)
+}
+
+// StreamOptions 下载选项，JobID非空时向对应的下载任务累计已输出的字节数
 type StreamOptions struct {
 	Platform            string
-	Compression         bool
+	Compression         string
 	UseCompressedLayers bool
+	MaxBytes            int64
+	JobID               string
+
+	keepalive *tarKeepalive
+}
+
+// 离线镜像包外层压缩方式
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// parseCompression 解析压缩参数，空值视为不压缩
+func parseCompression(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd:
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("不支持的压缩方式: %s，可选值: none, gzip, zstd", value)
+	}
+}
+
+// tarFilename 根据压缩方式生成下载文件名
+func tarFilename(base, compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return base + ".tar.gz"
+	case CompressionZstd:
+		return base + ".tar.zst"
+	default:
+		return base + ".tar"
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressionWriter 创建流式压缩Writer，内存占用不随镜像大小增长
+func newCompressionWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("不支持的压缩方式: %s", compression)
+	}
+}
+
+// closeArchive 依次关闭tar和压缩层，写出两者的结尾；结尾写入失败时归档不完整，返回错误
+func closeArchive(tarWriter *tar.Writer, compressWriter io.Closer) error {
+	tarErr := tarWriter.Close()
+	compressErr := compressWriter.Close()
+	if tarErr != nil {
+		return fmt.Errorf("写入tar结尾失败: %w", tarErr)
+	}
+	if compressErr != nil {
+		return fmt.Errorf("写入压缩结尾失败: %w", compressErr)
+	}
+	return nil
 }
 
 // StreamImageToWriter 流式下载镜像到Writer
@@ -313,15 +394,19 @@ func (is *ImageStreamer) getImageDescriptorWithPlatform(ref name.Reference, opti
 	return remote.Get(ref, options...)
 }
 
-func setDownloadHeaders(c *gin.Context, filename string, compressed bool) {
-	c.Header("Content-Type", "application/octet-stream")
+func setDownloadHeaders(c *gin.Context, filename string, compression string) {
+	switch compression {
+	case CompressionGzip:
+		c.Header("Content-Type", "application/gzip")
+	case CompressionZstd:
+		c.Header("Content-Type", "application/zstd")
+	default:
+		c.Header("Content-Type", "application/octet-stream")
+	}
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	c.Header("Cache-Control", "no-store")
 	c.Header("Pragma", "no-cache")
 	c.Header("Expires", "0")
-	if compressed {
-		c.Header("Content-Encoding", "gzip")
-	}
 }
 
 // StreamImageToGin 流式响应到Gin
@@ -330,7 +415,7 @@ func (is *ImageStreamer) StreamImageToGin(ctx context.Context, imageRef string,
 		options = &StreamOptions{UseCompressedLayers: true}
 	}
 
-	filename := strings.ReplaceAll(imageRef, "/", "_") + ".tar"
+	filename := tarFilename(strings.ReplaceAll(imageRef, "/", "_"), options.Compression)
 	setDownloadHeaders(c, filename, options.Compression)
 
 	return is.StreamImageToWriter(ctx, imageRef, c.Writer, options)
@@ -357,20 +442,29 @@ func (is *ImageStreamer) streamSingleImage(ctx context.Context, desc *remote.Des
 }
 
 // streamImageLayers 处理镜像层
-func (is *ImageStreamer) streamImageLayers(ctx context.Context, img v1.Image, writer io.Writer, options *StreamOptions, imageRef string) error {
-	var finalWriter io.Writer = writer
-
-	if options.Compression {
-		gzWriter := gzip.NewWriter(writer)
-		defer gzWriter.Close()
-		finalWriter = gzWriter
+func (is *ImageStreamer) streamImageLayers(ctx context.Context, img v1.Image, writer io.Writer, options *StreamOptions, imageRef string) (err error) {
+	compressWriter, err := newCompressionWriter(utils.NewSizeLimitWriter(is.jobs.writer(writer, options.JobID), options.MaxBytes), options.Compression)
+	if err != nil {
+		return err
 	}
+	tarWriter := tar.NewWriter(compressWriter)
+	defer func() {
+		if closeErr := closeArchive(tarWriter, compressWriter); err == nil {
+			err = closeErr
+		}
+	}()
 
-	tarWriter := tar.NewWriter(finalWriter)
-	defer tarWriter.Close()
+	// manifest已解析，立即开始输出
+	options.keepalive = newTarKeepalive(is.keepaliveInterval(), tarWriter, compressWriter, writer)
+	if err := options.keepalive.start(); err != nil {
+		return err
+	}
 
-	configFile, err := img.ConfigFile()
-	if err != nil {
+	var configFile *v1.ConfigFile
+	if err := options.keepalive.wait(func() (err error) {
+		configFile, err = img.ConfigFile()
+		return err
+	}); err != nil {
 		return fmt.Errorf("获取镜像配置失败: %w", err)
 	}
 
@@ -413,6 +507,9 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 	if _, err := tarWriter.Write(configData); err != nil {
 		return err
 	}
+	if err := options.keepalive.flush(); err != nil {
+		return err
+	}
 
 	layerDigests := make([]string, len(layers))
 	for i, layer := range layers {
@@ -442,25 +539,17 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 
 			var layerSize int64
 			var layerReader io.ReadCloser
-
-			if options != nil && options.UseCompressedLayers {
-				layerSize, err = layer.Size()
-				if err != nil {
-					return err
-				}
-				layerReader, err = layer.Compressed()
-			} else {
-				layerSize, err = partial.UncompressedSize(layer)
-				if err != nil {
-					return err
-				}
-				layerReader, err = layer.Uncompressed()
+			err = options.keepalive.wait(func() error {
+				var err error
+				layerSize, layerReader, err = openTarLayer(layer, options)
+				return err
+			})
+			if layerReader != nil {
+				defer layerReader.Close()
 			}
-
 			if err != nil {
 				return err
 			}
-			defer layerReader.Close()
 
 			layerTarHeader := &tar.Header{
 				Name: layerDir + "/layer.tar",
@@ -550,6 +639,38 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 	return err
 }
 
+// openTarLayer 打开镜像层并等到上游返回第一个字节：层的tar头写入后就不能再插入保活条目
+func openTarLayer(layer v1.Layer, options *StreamOptions) (int64, io.ReadCloser, error) {
+	var size int64
+	var reader io.ReadCloser
+	var err error
+	if options != nil && options.UseCompressedLayers {
+		if size, err = layer.Size(); err != nil {
+			return 0, nil, err
+		}
+		reader, err = layer.Compressed()
+	} else {
+		// 未压缩的大小需要读取整个层才能得到
+		if size, err = partial.UncompressedSize(layer); err != nil {
+			return 0, nil, err
+		}
+		reader, err = layer.Uncompressed()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	if _, err := buffered.Peek(1); err != nil && err != io.EOF {
+		reader.Close()
+		return 0, nil, err
+	}
+	return size, struct {
+		io.Reader
+		io.Closer
+	}{buffered, reader}, nil
+}
+
 // processImageForBatch 处理镜像的公共逻辑
 func (is *ImageStreamer) processImageForBatch(ctx context.Context, img v1.Image, tarWriter *tar.Writer, imageRef string, options *StreamOptions) (map[string]interface{}, map[string]map[string]string, error) {
 	layers, err := img.Layers()
@@ -557,8 +678,11 @@ func (is *ImageStreamer) processImageForBatch(ctx context.Context, img v1.Image,
 		return nil, nil, fmt.Errorf("获取镜像层失败: %w", err)
 	}
 
-	configFile, err := img.ConfigFile()
-	if err != nil {
+	var configFile *v1.ConfigFile
+	if err := options.keepalive.wait(func() (err error) {
+		configFile, err = img.ConfigFile()
+		return err
+	}); err != nil {
 		return nil, nil, fmt.Errorf("获取镜像配置失败: %w", err)
 	}
 
@@ -583,10 +707,17 @@ func (is *ImageStreamer) streamSingleImageForBatch(ctx context.Context, tarWrite
 
 	contextOptions := append(is.remoteOptions, remote.WithContext(ctx))
 
-	desc, err := is.getImageDescriptorWithPlatform(ref, contextOptions, options.Platform)
-	if err != nil {
+	// 第一个镜像的manifest解析完成后开始输出，之后的镜像在解析期间保持输出
+	var desc *remote.Descriptor
+	if err := options.keepalive.wait(func() (err error) {
+		desc, err = is.getImageDescriptorWithPlatform(ref, contextOptions, options.Platform)
+		return err
+	}); err != nil {
 		return nil, nil, fmt.Errorf("获取镜像描述失败: %w", err)
 	}
+	if err := options.keepalive.start(); err != nil {
+		return nil, nil, err
+	}
 
 	var img v1.Image
 
@@ -611,6 +742,46 @@ func (is *ImageStreamer) streamSingleImageForBatch(ctx context.Context, tarWrite
 	return is.processImageForBatch(ctx, img, tarWriter, imageRef, options)
 }
 
+// EstimateImageSize 按manifest中声明的配置和层大小估算镜像包大小，不下载层内容
+func (is *ImageStreamer) EstimateImageSize(ctx context.Context, imageRef string, options *StreamOptions) (int64, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return 0, fmt.Errorf("解析镜像引用失败: %w", err)
+	}
+
+	contextOptions := append(is.remoteOptions, remote.WithContext(ctx))
+	desc, err := is.getImageDescriptorWithPlatform(ref, contextOptions, options.Platform)
+	if err != nil {
+		return 0, fmt.Errorf("获取镜像描述失败: %w", err)
+	}
+
+	var img v1.Image
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		img, err = is.selectPlatformImage(desc, options)
+	default:
+		img, err = desc.Image()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("获取镜像失败: %w", err)
+	}
+
+	return manifestSize(img)
+}
+
+// manifestSize 按manifest中声明的配置和层大小估算镜像包大小，只读取manifest
+func manifestSize(img v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, fmt.Errorf("获取镜像清单失败: %w", err)
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
 // selectPlatformImage 从多架构镜像中选择合适的平台镜像
 func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *StreamOptions) (v1.Image, error) {
 	index, err := desc.ImageIndex()
@@ -623,14 +794,29 @@ func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *S
 		return nil, fmt.Errorf("获取索引清单失败: %w", err)
 	}
 
-	var selectedDesc *v1.Descriptor
-	for _, m := range manifest.Manifests {
+	selectedDesc, _ := selectPlatformDescriptor(manifest, options.Platform)
+	if selectedDesc == nil {
+		return nil, fmt.Errorf("未找到合适的平台镜像")
+	}
+
+	img, err := index.Image(selectedDesc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("获取选中镜像失败: %w", err)
+	}
+
+	return img, nil
+}
+
+// selectPlatformDescriptor 从镜像索引中选择平台：指定platform(os/arch[/variant])时精确匹配，
+// 未指定时优先linux/amd64；都没有时退回第一个条目，此时matched为false
+func selectPlatformDescriptor(manifest *v1.IndexManifest, platform string) (selected *v1.Descriptor, matched bool) {
+	for i, m := range manifest.Manifests {
 		if m.Platform == nil {
 			continue
 		}
 
-		if options.Platform != "" {
-			platformParts := strings.Split(options.Platform, "/")
+		if platform != "" {
+			platformParts := strings.Split(platform, "/")
 			if len(platformParts) >= 2 {
 				targetOS := platformParts[0]
 				targetArch := platformParts[1]
@@ -642,37 +828,38 @@ func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *S
 				if m.Platform.OS == targetOS &&
 					m.Platform.Architecture == targetArch &&
 					m.Platform.Variant == targetVariant {
-					selectedDesc = &m
-					break
+					return &manifest.Manifests[i], true
 				}
 			}
 		} else if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
-			selectedDesc = &m
-			break
+			return &manifest.Manifests[i], true
 		}
 	}
 
-	if selectedDesc == nil && len(manifest.Manifests) > 0 {
-		selectedDesc = &manifest.Manifests[0]
-	}
-
-	if selectedDesc == nil {
-		return nil, fmt.Errorf("未找到合适的平台镜像")
+	if len(manifest.Manifests) > 0 {
+		return &manifest.Manifests[0], false
 	}
+	return nil, false
+}
 
-	img, err := index.Image(selectedDesc.Digest)
-	if err != nil {
-		return nil, fmt.Errorf("获取选中镜像失败: %w", err)
+// indexPlatforms 列出镜像索引中的平台，形如os/arch[/variant]
+func indexPlatforms(manifest *v1.IndexManifest) []string {
+	var platforms []string
+	for _, m := range manifest.Manifests {
+		if m.Platform != nil {
+			platforms = append(platforms, platformString(m.Platform))
+		}
 	}
-
-	return img, nil
+	return platforms
 }
 
-var globalImageStreamer *ImageStreamer
-
-// InitImageStreamer 初始化镜像下载器
-func InitImageStreamer() {
-	globalImageStreamer = NewImageStreamer(nil)
+// platformString 返回os/arch[/variant]形式的平台
+func platformString(p *v1.Platform) string {
+	platform := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		platform += "/" + p.Variant
+	}
+	return platform
 }
 
 // formatPlatformText 格式化平台文本
@@ -684,21 +871,25 @@ func formatPlatformText(platform string) string {
 }
 
 // InitImageTarRoutes 初始化镜像下载路由
-func InitImageTarRoutes(router *gin.Engine) {
+func (h *Handlers) InitImageTarRoutes(router *gin.Engine) {
 	imageAPI := router.Group("/api/image")
 	{
-		imageAPI.GET("/download/:image", handleDirectImageDownload)
-		imageAPI.GET("/info/:image", handleImageInfo)
-		imageAPI.GET("/batch", handleSimpleBatchDownload)
-		imageAPI.POST("/batch", handleSimpleBatchDownload)
-	}
+		imageAPI.GET("/download/:image", h.handleDirectImageDownload)
+		imageAPI.GET("/info/:image", h.handleImageInfo)
+		imageAPI.GET("/batch", h.handleSimpleBatchDownload)
+		imageAPI.POST("/batch", h.handleSimpleBatchDownload)
+		imageAPI.GET("/jobs/:id", h.handleTarJobStatus)
+		imageAPI.GET("/preflight", h.handleImagePreflight)
+	}
+	router.GET("/api/install-script", h.handleImageInstallScript)
 }
 
 // handleDirectImageDownload 处理单镜像下载
-func handleDirectImageDownload(c *gin.Context) {
+func (h *Handlers) handleDirectImageDownload(c *gin.Context) {
+	cfg := h.Config.Get()
 	imageParam := c.Param("image")
 	if imageParam == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少镜像参数"})
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "缺少镜像参数")
 		return
 	}
 
@@ -706,6 +897,11 @@ func handleDirectImageDownload(c *gin.Context) {
 	platform := c.Query("platform")
 	tag := c.DefaultQuery("tag", "")
 	useCompressed := c.DefaultQuery("compressed", "true") == "true"
+	compression, err := parseCompression(c.Query("compress"))
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
+		return
+	}
 
 	if tag != "" && !strings.Contains(imageRef, ":") && !strings.Contains(imageRef, "@") {
 		imageRef = imageRef + ":" + tag
@@ -713,35 +909,38 @@ func handleDirectImageDownload(c *gin.Context) {
 		imageRef = imageRef + ":latest"
 	}
 
+	imageRef, ok := dockerproxy.NormalizeImageParam(cfg, c, imageRef)
+	if !ok {
+		return
+	}
 	if _, err := name.ParseReference(imageRef); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像引用格式错误: " + err.Error()})
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "镜像引用格式错误: "+err.Error())
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+	if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
 		return
 	}
 
 	if c.Query("mode") == "prepare" {
-		userID := getUserID(c)
+		userID := h.getUserID(c)
 		contentKey := generateContentFingerprint([]string{imageRef}, platform)
 
-		if !singleImageDebouncer.ShouldAllow(userID, contentKey) {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "请求过于频繁，请稍后再试",
-				"retry_after": 5,
-			})
+		if !h.singleDebouncer.ShouldAllow(userID, contentKey) {
+			utils.SetRetryAfter(c, 5*time.Second)
+			utils.RespondErrorMessage(c, http.StatusTooManyRequests, utils.ErrCodeRateLimited, "请求过于频繁，请稍后再试")
 			return
 		}
 
-		ip, userAgent := getClientIdentity(c)
-		token, err := singleDownloadTokens.create(SingleDownloadRequest{
+		ip, userAgent := h.getClientIdentity(c)
+		token, err := h.singleTokens.create(SingleDownloadRequest{
 			Image:               imageRef,
 			Platform:            platform,
 			UseCompressedLayers: useCompressed,
+			Compression:         compression,
 		}, ip, userAgent)
 		if err != nil {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			utils.RespondErrorMessage(c, http.StatusTooManyRequests, utils.ErrCodeRateLimited, err.Error())
 			return
 		}
 
@@ -755,85 +954,129 @@ func handleDirectImageDownload(c *gin.Context) {
 
 	token := c.Query("token")
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少下载令牌"})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeDownloadTokenMissing)
 		return
 	}
 
-	ip, userAgent := getClientIdentity(c)
-	req, ok := singleDownloadTokens.consume(token, ip, userAgent)
+	ip, userAgent := h.getClientIdentity(c)
+	req, ok := h.singleTokens.consume(token, ip, userAgent)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效或过期的下载令牌"})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeDownloadTokenInvalid)
 		return
 	}
 	if req.Image != imageRef {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "下载令牌与镜像不匹配"})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeDownloadTokenMismatch)
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(req.Image); !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+	if allowed, reason := h.Access.CheckDockerAccess(req.Image); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
 		return
 	}
 
 	options := &StreamOptions{
 		Platform:            req.Platform,
-		Compression:         false,
+		Compression:         req.Compression,
 		UseCompressedLayers: req.UseCompressedLayers,
 	}
 
+	if !h.admitTarSize(c, []string{req.Image}, options) {
+		return
+	}
+
+	release, ok := h.acquireTarJob(c, []string{req.Image}, req.Platform)
+	if !ok {
+		return
+	}
+	defer release()
+	options.JobID = c.GetString(tarJobIDKey)
+
 	ctx := c.Request.Context()
 	log.Printf("下载镜像: %s (平台: %s)", req.Image, formatPlatformText(req.Platform))
 
-	if err := globalImageStreamer.StreamImageToGin(ctx, req.Image, c, options); err != nil {
+	finishDigest := h.trackArtifactDigest(c, []string{req.Image})
+	err = h.streamer.StreamImageToGin(ctx, req.Image, c, options)
+	finishDigest(err)
+	h.recordDownloadHistory(c, utils.HistoryTypeImageTar, req.Image, err)
+	if err != nil {
 		log.Printf("镜像下载失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "镜像下载失败: " + err.Error()})
+		// 已经开始输出tar流时无法再返回错误响应
+		if c.Writer.Written() {
+			return
+		}
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "镜像下载失败: "+err.Error())
 		return
 	}
 }
 
 // handleSimpleBatchDownload 处理批量下载
-func handleSimpleBatchDownload(c *gin.Context) {
+func (h *Handlers) handleSimpleBatchDownload(c *gin.Context) {
+	cfg := h.Config.Get()
 	if c.Request.Method == http.MethodGet {
 		token := c.Query("token")
 		if token == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少下载令牌"})
+			utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeDownloadTokenMissing)
 			return
 		}
 
-		ip, userAgent := getClientIdentity(c)
-		req, ok := batchDownloadTokens.consume(token, ip, userAgent)
+		ip, userAgent := h.getClientIdentity(c)
+		req, ok := h.batchTokens.consume(token, ip, userAgent)
 		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无效或过期的下载令牌"})
+			utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeDownloadTokenInvalid)
 			return
 		}
 
 		if len(req.Images) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "镜像列表不能为空"})
+			utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeEmptyImageList)
 			return
 		}
 
 		options := &StreamOptions{
 			Platform:            req.Platform,
-			Compression:         false,
+			Compression:         req.Compression,
 			UseCompressedLayers: req.UseCompressedLayers,
 		}
 
+		if !h.admitTarSize(c, req.Images, options) {
+			return
+		}
+
+		release, ok := h.acquireTarJob(c, req.Images, req.Platform)
+		if !ok {
+			return
+		}
+		defer release()
+		options.JobID = c.GetString(tarJobIDKey)
+
 		ctx := c.Request.Context()
 		log.Printf("批量下载 %d 个镜像 (平台: %s)", len(req.Images), formatPlatformText(req.Platform))
 
-		filename := fmt.Sprintf("batch_%d_images.tar", len(req.Images))
+		filename := tarFilename(fmt.Sprintf("batch_%d_images", len(req.Images)), options.Compression)
 
 		setDownloadHeaders(c, filename, options.Compression)
 
-		if err := globalImageStreamer.StreamMultipleImages(ctx, req.Images, c.Writer, options); err != nil {
+		finishDigest := h.trackArtifactDigest(c, req.Images)
+		err := h.streamer.StreamMultipleImages(ctx, req.Images, c.Writer, options)
+		finishDigest(err)
+		h.recordDownloadHistory(c, utils.HistoryTypeImageTar, strings.Join(req.Images, ","), err)
+		if err != nil {
 			log.Printf("批量镜像下载失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "批量镜像下载失败: " + err.Error()})
+			if c.Writer.Written() {
+				return
+			}
+			utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "批量镜像下载失败: "+err.Error())
 			return
 		}
 		return
 	}
 
 	if c.Query("mode") != "prepare" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "只支持prepare模式"})
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "只支持prepare模式")
+		return
+	}
+
+	compression, err := parseCompression(c.Query("compress"))
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
 		return
 	}
 
@@ -844,17 +1087,24 @@ func handleSimpleBatchDownload(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
 		return
 	}
 
 	if len(req.Images) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像列表不能为空"})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeEmptyImageList)
 		return
 	}
+	for i, imageRef := range req.Images {
+		normalized, ok := dockerproxy.NormalizeImageParam(cfg, c, imageRef)
+		if !ok {
+			return
+		}
+		req.Images[i] = normalized
+	}
 	for _, imageRef := range req.Images {
-		if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-			c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+			utils.RespondError(c, http.StatusForbidden, reason)
 			return
 		}
 	}
@@ -865,28 +1115,23 @@ func handleSimpleBatchDownload(c *gin.Context) {
 		}
 	}
 	for _, imageRef := range req.Images {
-		if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-			c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+			utils.RespondError(c, http.StatusForbidden, reason)
 			return
 		}
 	}
 
-	cfg := config.GetConfig()
 	if len(req.Images) > cfg.Download.MaxImages {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("镜像数量超过限制，最大允许: %d", cfg.Download.MaxImages),
-		})
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeTooManyImages, cfg.Download.MaxImages)
 		return
 	}
 
-	userID := getUserID(c)
+	userID := h.getUserID(c)
 	contentKey := generateContentFingerprint(req.Images, req.Platform)
 
-	if !batchImageDebouncer.ShouldAllow(userID, contentKey) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error":       "批量下载请求过于频繁，请稍后再试",
-			"retry_after": 60,
-		})
+	if !h.batchDebouncer.ShouldAllow(userID, contentKey) {
+		utils.SetRetryAfter(c, 60*time.Second)
+		utils.RespondErrorMessage(c, http.StatusTooManyRequests, utils.ErrCodeRateLimited, "批量下载请求过于频繁，请稍后再试")
 		return
 	}
 
@@ -899,22 +1144,24 @@ func handleSimpleBatchDownload(c *gin.Context) {
 		Images:              req.Images,
 		Platform:            req.Platform,
 		UseCompressedLayers: useCompressed,
+		Compression:         compression,
 	}
 
-	ip, userAgent := getClientIdentity(c)
-	token, err := batchDownloadTokens.create(batchReq, ip, userAgent)
+	ip, userAgent := h.getClientIdentity(c)
+	token, err := h.batchTokens.create(batchReq, ip, userAgent)
 	if err != nil {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		utils.RespondErrorMessage(c, http.StatusTooManyRequests, utils.ErrCodeRateLimited, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"download_url": fmt.Sprintf("/api/image/batch?token=%s", token)})
 }
 
 // handleImageInfo 处理镜像信息查询
-func handleImageInfo(c *gin.Context) {
+func (h *Handlers) handleImageInfo(c *gin.Context) {
+	cfg := h.Config.Get()
 	imageParam := c.Param("image")
 	if imageParam == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少镜像参数"})
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "缺少镜像参数")
 		return
 	}
 
@@ -925,22 +1172,26 @@ func handleImageInfo(c *gin.Context) {
 		imageRef = imageRef + ":" + tag
 	}
 
+	imageRef, ok := dockerproxy.NormalizeImageParam(cfg, c, imageRef)
+	if !ok {
+		return
+	}
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像引用格式错误: " + err.Error()})
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "镜像引用格式错误: "+err.Error())
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+	if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
 		return
 	}
 
 	ctx := c.Request.Context()
-	contextOptions := append(globalImageStreamer.remoteOptions, remote.WithContext(ctx))
+	contextOptions := append(h.streamer.remoteOptions, remote.WithContext(ctx))
 
-	desc, err := globalImageStreamer.getImageDescriptor(ref, contextOptions)
+	desc, err := h.streamer.getImageDescriptor(ref, contextOptions)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取镜像信息失败: " + err.Error()})
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "获取镜像信息失败: "+err.Error())
 		return
 	}
 
@@ -956,13 +1207,7 @@ func handleImageInfo(c *gin.Context) {
 		if err == nil {
 			manifest, err := index.IndexManifest()
 			if err == nil {
-				var platforms []string
-				for _, m := range manifest.Manifests {
-					if m.Platform != nil {
-						platforms = append(platforms, m.Platform.OS+"/"+m.Platform.Architecture)
-					}
-				}
-				info["platforms"] = platforms
+				info["platforms"] = indexPlatforms(manifest)
 				info["multiArch"] = true
 			}
 		}
@@ -974,20 +1219,22 @@ func handleImageInfo(c *gin.Context) {
 }
 
 // StreamMultipleImages 批量下载多个镜像
-func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []string, writer io.Writer, options *StreamOptions) error {
+func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []string, writer io.Writer, options *StreamOptions) (err error) {
 	if options == nil {
 		options = &StreamOptions{UseCompressedLayers: true}
 	}
 
-	var finalWriter io.Writer = writer
-	if options.Compression {
-		gzWriter := gzip.NewWriter(writer)
-		defer gzWriter.Close()
-		finalWriter = gzWriter
+	compressWriter, err := newCompressionWriter(utils.NewSizeLimitWriter(is.jobs.writer(writer, options.JobID), options.MaxBytes), options.Compression)
+	if err != nil {
+		return err
 	}
-
-	tarWriter := tar.NewWriter(finalWriter)
-	defer tarWriter.Close()
+	tarWriter := tar.NewWriter(compressWriter)
+	defer func() {
+		if closeErr := closeArchive(tarWriter, compressWriter); err == nil {
+			err = closeErr
+		}
+	}()
+	options.keepalive = newTarKeepalive(is.keepaliveInterval(), tarWriter, compressWriter, writer)
 
 	var allManifests []map[string]interface{}
 	var allRepositories = make(map[string]map[string]string)
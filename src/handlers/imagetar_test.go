@@ -1,8 +1,24 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestDownloadDebouncer(t *testing.T) {
@@ -58,3 +74,193 @@ func TestGenerateContentFingerprintStable(t *testing.T) {
 		t.Fatalf("unexpected fingerprints: %q %q %q", a, b, c)
 	}
 }
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", CompressionNone},
+		{"none", CompressionNone},
+		{"GZIP", CompressionGzip},
+		{"zstd", CompressionZstd},
+	}
+	for _, tt := range tests {
+		got, err := parseCompression(tt.value)
+		if err != nil || got != tt.want {
+			t.Fatalf("parseCompression(%q) = %q, %v; want %q", tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseCompression("bzip2"); err == nil {
+		t.Fatal("unknown compression accepted")
+	}
+}
+
+func TestTarFilename(t *testing.T) {
+	if got := tarFilename("nginx_latest", CompressionGzip); got != "nginx_latest.tar.gz" {
+		t.Fatalf("gzip filename = %q", got)
+	}
+	if got := tarFilename("nginx_latest", CompressionZstd); got != "nginx_latest.tar.zst" {
+		t.Fatalf("zstd filename = %q", got)
+	}
+	if got := tarFilename("nginx_latest", CompressionNone); got != "nginx_latest.tar" {
+		t.Fatalf("plain filename = %q", got)
+	}
+}
+
+func TestCompressionWriterRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("hubproxy layer data "), 4096)
+
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newCompressionWriter(&buf, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader = &buf
+			switch compression {
+			case CompressionGzip:
+				gz, err := gzip.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			case CompressionZstd:
+				zr, err := zstd.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				r = zr
+			}
+
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestImageInfoUppercaseRepositoryName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+
+	// 镜像下载等接口返回JSON错误并给出小写的名称
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/image/info/MyOrg_MyImage?tag=V1", nil)
+	c.Params = gin.Params{{Key: "image", Value: "MyOrg_MyImage"}}
+	h.handleImageInfo(c)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "myorg/myimage:V1") {
+		t.Fatalf("image info: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamImageGzipLoads(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := host + "/org/app:v1"
+	ref, _ := name.ParseReference(imageRef)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	streamer := NewImageStreamer(&ImageStreamerConfig{Concurrency: 1})
+	options := &StreamOptions{Compression: CompressionGzip, UseCompressedLayers: true}
+	if err := streamer.StreamImageToWriter(context.Background(), imageRef, &buf, options); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buf.Bytes()
+	loaded, err := tarball.Image(func() (io.ReadCloser, error) {
+		return gzip.NewReader(bytes.NewReader(archive))
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := image.ConfigName()
+	got, err := loaded.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("config digest = %s, want %s", got, want)
+	}
+	layers, err := loaded.Layers()
+	if err != nil || len(layers) != 2 {
+		t.Fatalf("layers = %d, err = %v", len(layers), err)
+	}
+	for _, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Fatalf("read layer: %v", err)
+		}
+		rc.Close()
+	}
+}
+
+func TestStreamImageReportsTrailerError(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := host + "/org/app:v1"
+	ref, _ := name.ParseReference(imageRef)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	// 内容可以写完，但gzip结尾写不下
+	var probe bytes.Buffer
+	streamer := NewImageStreamer(&ImageStreamerConfig{Concurrency: 1})
+	if err := streamer.StreamImageToWriter(context.Background(), imageRef, &probe, &StreamOptions{Compression: CompressionGzip, UseCompressedLayers: true}); err != nil {
+		t.Fatal(err)
+	}
+	writer := &failAfterWriter{limit: probe.Len() - 4}
+	err = streamer.StreamImageToWriter(context.Background(), imageRef, writer, &StreamOptions{Compression: CompressionGzip, UseCompressedLayers: true})
+	if err == nil {
+		t.Fatal("truncated archive reported as success")
+	}
+}
+
+// failAfterWriter 写入超过limit字节后返回错误
+type failAfterWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failAfterWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		return 0, io.ErrShortWrite
+	}
+	w.written += len(p)
+	return len(p), nil
+}
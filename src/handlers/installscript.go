@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// imageInstallScriptTemplate 离线镜像导入脚本模板，所有变量均经过shellQuote转义
+const imageInstallScriptTemplate = `#!/bin/sh
+# 由 HubProxy 生成的离线镜像导入脚本
+# 流程: 下载镜像tar包 -> 向代理获取sha256并校验 -> docker load -> 恢复原始镜像名
+# 无法获取期望的sha256时不导入镜像，可通过 --sha256 指定
+set -eu
+
+BASE_URL={{shq .BaseURL}}
+PREPARE_URL={{shq .PrepareURL}}
+IMAGE={{shq .Image}}
+OUTPUT={{shq .Filename}}
+EXPECTED_SHA256=""
+DRY_RUN=0
+
+usage() {
+	echo "用法: $0 [--dry-run] [--sha256 <hex>] [--output <file>]"
+}
+
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--dry-run) DRY_RUN=1 ;;
+		--sha256) shift; EXPECTED_SHA256="${1:-}" ;;
+		-o|--output) shift; OUTPUT="${1:-}" ;;
+		-h|--help) usage; exit 0 ;;
+		*) echo "未知参数: $1" >&2; usage >&2; exit 2 ;;
+	esac
+	shift
+done
+
+HEADERS=$(mktemp)
+trap 'rm -f "$HEADERS"' EXIT
+
+run() {
+	if [ "$DRY_RUN" = 1 ]; then
+		echo "+ $*"
+	else
+		"$@"
+	fi
+}
+
+sha256_of() {
+	if command -v sha256sum >/dev/null 2>&1; then
+		sha256sum "$1" | awk '{print $1}'
+	else
+		shasum -a 256 "$1" | awk '{print $1}'
+	fi
+}
+
+echo "==> 申请下载令牌: $IMAGE"
+if [ "$DRY_RUN" = 1 ]; then
+	echo "+ curl -fsSL $PREPARE_URL"
+	DOWNLOAD_PATH="/api/image/download/<token>"
+else
+	RESPONSE=$(curl -fsSL "$PREPARE_URL")
+	DOWNLOAD_PATH=$(printf '%s' "$RESPONSE" | sed -n 's/.*"download_url":"\([^"]*\)".*/\1/p' | sed 's/\\u0026/\&/g')
+	if [ -z "$DOWNLOAD_PATH" ]; then
+		echo "获取下载地址失败: $RESPONSE" >&2
+		exit 1
+	fi
+fi
+
+echo "==> 下载镜像包: $OUTPUT"
+run curl -fL -D "$HEADERS" -o "$OUTPUT" "$BASE_URL$DOWNLOAD_PATH"
+
+echo "==> 校验sha256"
+if [ "$DRY_RUN" = 1 ]; then
+	echo "+ curl -fsSL $BASE_URL/api/download/<任务ID>/checksum"
+	echo "+ sha256sum $OUTPUT"
+else
+	if [ -z "$EXPECTED_SHA256" ]; then
+		JOB_ID=$(tr -d '\r' < "$HEADERS" | awk 'tolower($1) == "x-job-id:" { id = $2 } END { print id }')
+		if [ -n "$JOB_ID" ]; then
+			CHECKSUM=$(curl -fsSL "$BASE_URL/api/download/$JOB_ID/checksum" || true)
+			EXPECTED_SHA256=$(printf '%s' "$CHECKSUM" | sed -n 's/.*"sha256":"\([0-9a-f]*\)".*/\1/p')
+		fi
+	fi
+	if [ -z "$EXPECTED_SHA256" ]; then
+		echo "无法从代理获取sha256，已停止导入；可通过 --sha256 指定期望值" >&2
+		exit 1
+	fi
+	ACTUAL_SHA256=$(sha256_of "$OUTPUT")
+	echo "sha256: $ACTUAL_SHA256"
+	if [ "$ACTUAL_SHA256" != "$EXPECTED_SHA256" ]; then
+		echo "sha256校验失败，期望: $EXPECTED_SHA256" >&2
+		exit 1
+	fi
+fi
+
+echo "==> 导入镜像"
+if [ "$DRY_RUN" = 1 ]; then
+	echo "+ docker load -i $OUTPUT"
+	LOADED="$IMAGE"
+else
+	LOADED=$(docker load -i "$OUTPUT" | sed -n 's/^Loaded image: //p' | head -n 1)
+fi
+
+if [ -n "$LOADED" ] && [ "$LOADED" != "$IMAGE" ]; then
+	run docker tag "$LOADED" "$IMAGE"
+fi
+
+echo "==> 完成: $IMAGE"
+`
+
+var imageInstallScript = template.Must(template.New("install-script").Funcs(template.FuncMap{
+	"shq": shellQuote,
+}).Parse(imageInstallScriptTemplate))
+
+// imageInstallScriptData 脚本模板参数
+type imageInstallScriptData struct {
+	BaseURL    string
+	PrepareURL string
+	Image      string
+	Filename   string
+}
+
+// shellQuote 将任意字符串转义为POSIX shell单引号字面量
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// renderImageInstallScript 生成离线镜像导入脚本
+func renderImageInstallScript(baseURL, imageRef, platform string) (string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	imageParam := strings.ReplaceAll(imageRef, "/", "_")
+
+	params := url.Values{}
+	params.Set("mode", "prepare")
+	if platform != "" {
+		params.Set("platform", platform)
+	}
+	prepareURL := baseURL + "/api/image/download/" + url.PathEscape(imageParam) + "?" + params.Encode()
+
+	var buf bytes.Buffer
+	err := imageInstallScript.Execute(&buf, imageInstallScriptData{
+		BaseURL:    baseURL,
+		PrepareURL: prepareURL,
+		Image:      imageRef,
+		Filename:   tarFilename(strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(imageRef), CompressionNone),
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// handleImageInstallScript 生成离线镜像导入脚本
+func (h *Handlers) handleImageInstallScript(c *gin.Context) {
+	cfg := h.Config.Get()
+	imageRef := strings.TrimSpace(c.Query("image"))
+	if imageRef == "" {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeMissingParameter, "缺少镜像参数")
+		return
+	}
+	if !strings.Contains(imageRef, ":") && !strings.Contains(imageRef, "@") {
+		imageRef = imageRef + ":latest"
+	}
+
+	imageRef, ok := dockerproxy.NormalizeImageParam(cfg, c, imageRef)
+	if !ok {
+		return
+	}
+	if _, err := name.ParseReference(imageRef); err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidReference, "镜像引用格式错误: "+err.Error())
+		return
+	}
+	if allowed, reason := h.Access.CheckDockerAccess(imageRef); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
+		return
+	}
+
+	script, err := renderImageInstallScript(utils.ExternalBaseURL(cfg, c.Request), imageRef, c.Query("platform"))
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "生成脚本失败: "+err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", []byte(script))
+}
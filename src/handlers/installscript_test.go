@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "更新golden文件")
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"nginx:latest": "'nginx:latest'",
+		"it's":         `'it'"'"'s'`,
+		"$(rm -rf /)":  "'$(rm -rf /)'",
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Fatalf("shellQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderImageInstallScriptGolden(t *testing.T) {
+	script, err := renderImageInstallScript("https://proxy.example.com/", "ghcr.io/org/app:v1", "linux/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "install_script.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script != string(want) {
+		t.Fatalf("script differs from %s, run go test -update to refresh:\n%s", golden, script)
+	}
+}
+
+func TestRenderImageInstallScriptSyntax(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	script, err := renderImageInstallScript("https://proxy.example.com", "evil/'$(touch pwned)':latest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "install.sh")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := exec.Command(sh, "-n", path).CombinedOutput(); err != nil {
+		t.Fatalf("sh -n failed: %v\n%s", err, out)
+	}
+
+	cmd := exec.Command(sh, path, "--dry-run")
+	cmd.Dir = t.TempDir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("dry run failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "+ docker load -i") {
+		t.Fatalf("dry run output missing docker load:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(cmd.Dir, "pwned")); err == nil {
+		t.Fatal("image reference was executed by the shell")
+	}
+}
+
+// TestImageInstallScriptVerifiesChecksum 使用真实curl执行脚本，docker由桩脚本代替，
+// 只有代理返回的sha256与下载内容一致时才导入镜像
+func TestImageInstallScriptVerifiesChecksum(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not available")
+	}
+
+	payload := []byte("image tarball")
+	sum := sha256.Sum256(payload)
+	digest := hex.EncodeToString(sum[:])
+
+	var checksum string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/image/download/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("mode") == "prepare" {
+			w.Write([]byte(`{"download_url":"/api/image/download/nginx?token=t"}`))
+			return
+		}
+		w.Header().Set("X-Job-ID", "job1")
+		w.Write(payload)
+	})
+	mux.HandleFunc("/api/download/job1/checksum", func(w http.ResponseWriter, r *http.Request) {
+		if checksum == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id":"job1","sha256":"` + checksum + `"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	script, err := renderImageInstallScript(server.URL, "nginx:latest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "install.sh")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	docker := "#!/bin/sh\ntouch \"$(dirname \"$0\")/loaded\"\necho \"Loaded image: nginx:latest\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(docker), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		args     []string
+		loaded   bool
+	}{
+		{name: "proxy checksum", checksum: digest, loaded: true},
+		{name: "mismatch", checksum: strings.Repeat("0", 64)},
+		{name: "checksum unavailable"},
+		{name: "explicit sha256", args: []string{"--sha256", digest}, loaded: true},
+	}
+	for _, tt := range tests {
+		checksum = tt.checksum
+		os.Remove(filepath.Join(bin, "loaded"))
+
+		cmd := exec.Command(sh, append([]string{path}, tt.args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		out, err := cmd.CombinedOutput()
+		_, statErr := os.Stat(filepath.Join(bin, "loaded"))
+		loaded := statErr == nil
+		if (err == nil) != tt.loaded || loaded != tt.loaded {
+			t.Fatalf("%s: err = %v, docker load = %v, want %v\n%s", tt.name, err, loaded, tt.loaded, out)
+		}
+	}
+}
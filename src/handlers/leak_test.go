@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// stallingServer 启动测试上游，匹配stall的请求阻塞到请求被取消，started在第一次阻塞时关闭；
+// 其余请求交给next处理
+func stallingServer(t *testing.T, next http.Handler, stall func(*http.Request) bool) (*httptest.Server, chan struct{}) {
+	t.Helper()
+	started := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if next == nil || stall(r) {
+			once.Do(func() { close(started) })
+			<-r.Context().Done()
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+	return server, started
+}
+
+// waitStarted 等待上游开始阻塞
+func waitStarted(t *testing.T, started chan struct{}) {
+	t.Helper()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not started")
+	}
+}
+
+// waitGoroutines 等待goroutine数量回到baseline，超时时输出所有goroutine的调用栈
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("goroutines = %d, baseline %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// pushLeakTestImage 向registry写入一个测试镜像，返回镜像引用
+func pushLeakTestImage(t *testing.T, host string) string {
+	t.Helper()
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := host + "/org/app:v1"
+	ref, _ := name.ParseReference(imageRef)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	return imageRef
+}
+
+func isBlobGet(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/")
+}
+
+func TestCancellationDoesNotLeakGoroutines(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 日志汇总goroutine在第一次输出日志时启动，之后常驻
+	utils.Logf(utils.LogWarn, "leak", "启动日志汇总")
+
+	t.Run("github proxy client disconnect", func(t *testing.T) {
+		loadTestConfig(t, "")
+		h := newTestHandlers()
+		baseline := runtime.NumGoroutine()
+
+		started := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+			close(started)
+			<-r.Context().Done()
+		}))
+		router := gin.New()
+		router.GET("/file.bin", func(c *gin.Context) {
+			h.GitHub.ProxyRequest(c, upstream.URL+"/file.bin")
+		})
+		proxy := httptest.NewServer(router)
+
+		// 代理已开始转发响应体后客户端断开
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+			}
+			cancel()
+		}()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/file.bin", nil)
+		if resp, err := proxy.Client().Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		proxy.Close()
+		upstream.Close()
+		waitGoroutines(t, baseline)
+	})
+
+	t.Run("tar stream cancelled mid-layer", func(t *testing.T) {
+		loadTestConfig(t, "[download]\nkeepaliveInterval = \"20ms\"\n")
+		h := newTestHandlers()
+		baseline := runtime.NumGoroutine()
+
+		upstream, started := stallingServer(t, registry.New(registry.Logger(log.New(io.Discard, "", 0))), isBlobGet)
+		imageRef := pushLeakTestImage(t, strings.TrimPrefix(upstream.URL, "http://"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() {
+			errc <- h.streamer.StreamImageToWriter(ctx, imageRef, io.Discard, &StreamOptions{Compression: CompressionGzip})
+		}()
+		waitStarted(t, started)
+		cancel()
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Fatal("stream finished despite cancellation")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream did not return after cancellation")
+		}
+
+		upstream.Close()
+		waitGoroutines(t, baseline)
+	})
+
+	t.Run("copy job cancelled", func(t *testing.T) {
+		loadTestConfig(t, "")
+		h := newTestHandlers()
+		baseline := runtime.NumGoroutine()
+
+		source, started := stallingServer(t, registry.New(registry.Logger(log.New(io.Discard, "", 0))), isBlobGet)
+		target := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+		sourceRef, _ := name.ParseReference(pushLeakTestImage(t, strings.TrimPrefix(source.URL, "http://")))
+		targetRef, _ := name.ParseReference(strings.TrimPrefix(target.URL, "http://") + "/project/app:v1")
+
+		ctx, cancel := context.WithCancel(h.Background.Context())
+		job := &CopyJob{ID: "leak-copy", Status: CopyStatusRunning, cancel: cancel, done: make(chan struct{})}
+		h.copyJobs.add(job)
+		go h.runCopyJob(ctx, job, sourceRef, targetRef, nil)
+		waitStarted(t, started)
+		job.cancel()
+		select {
+		case <-job.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("copy job did not finish after cancellation")
+		}
+		if snapshot, _ := h.copyJobs.snapshot(job.ID); snapshot.Status != CopyStatusCancelled {
+			t.Fatalf("status = %s, error = %s", snapshot.Status, snapshot.Error)
+		}
+
+		source.Close()
+		target.Close()
+		waitGoroutines(t, baseline)
+	})
+
+	t.Run("background jobs stopped on shutdown", func(t *testing.T) {
+		peer, peerStarted := stallingServer(t, nil, nil)
+		upstream, upstreamStarted := stallingServer(t, registry.New(registry.Logger(log.New(io.Discard, "", 0))), func(r *http.Request) bool {
+			return r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/manifests/")
+		})
+		host := strings.TrimPrefix(upstream.URL, "http://")
+		loadTestConfig(t, fmt.Sprintf("[registries.%q]\nupstream = %q\nenabled = true\n", host, host))
+		h := newTestHandlers()
+		imageRef := pushLeakTestImage(t, host)
+		baseline := runtime.NumGoroutine()
+
+		prefetch := &PrefetchJob{ID: "leak-prefetch", Status: PrefetchJobRunning, Items: []PrefetchItem{
+			{Kind: "image", Ref: imageRef, Status: PrefetchStatusPending},
+		}}
+		h.prefetchJobs.add(prefetch)
+		prefetchDone := make(chan struct{})
+		go func() {
+			defer close(prefetchDone)
+			h.runPrefetchJob(prefetch, 1)
+		}()
+
+		pull := &CachePullJob{ID: "leak-pull", Peer: peer.URL, Status: CachePullRunning, done: make(chan struct{})}
+		h.cachePullJobs.add(pull)
+		go h.runCachePullJob(pull, CachePullRequest{Peer: peer.URL, Token: "t", Concurrency: 1}, utils.NewHotCache(h.Config))
+
+		waitStarted(t, upstreamStarted)
+		waitStarted(t, peerStarted)
+		h.Background.Stop()
+		for _, done := range []chan struct{}{prefetchDone, pull.done} {
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("background job did not stop on shutdown")
+			}
+		}
+		if snapshot, _ := h.prefetchJobs.snapshot(prefetch.ID); snapshot.Items[0].Status != PrefetchStatusFailed {
+			t.Fatalf("prefetch item = %+v", snapshot.Items[0])
+		}
+		if snapshot, _ := h.cachePullJobs.snapshot(pull.ID); snapshot.Status != CachePullFailed {
+			t.Fatalf("cache pull status = %s", snapshot.Status)
+		}
+
+		peer.Close()
+		upstream.Close()
+		waitGoroutines(t, baseline)
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// apiParam 查询参数，路径参数由路由中的:name自动生成
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// apiOperation 一个/api接口的说明，路径使用gin路由的写法。
+// apiOperations 是 /api/openapi.json 的唯一来源，新增/api路由时需同时登记，否则测试失败
+type apiOperation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Query   []apiParam
+	// Body 请求体为JSON
+	Body bool
+	// Produces 成功响应的内容类型，为空表示application/json
+	Produces string
+	// Status 成功状态码，为0表示200
+	Status int
+	// Admin 需要管理令牌
+	Admin bool
+}
+
+// apiOperations 全部/api接口
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "meta", Summary: "本文档"},
+	{Method: http.MethodGet, Path: "/api/capabilities", Tag: "meta", Summary: "实例支持的功能、URL形式和调用方适用的限制"},
+	{Method: http.MethodGet, Path: "/api/routes", Tag: "meta", Summary: "已挂载的路由分组，format=nginx|caddy时返回反向代理配置",
+		Query: []apiParam{{Name: "format", Description: "json、nginx或caddy"}}},
+	{Method: http.MethodGet, Path: "/api/stats", Tag: "stats", Summary: "请求、流量和上游统计",
+		Query: []apiParam{{Name: "window", Description: "统计窗口，如1h，all或为空表示启动以来"}}},
+	{Method: http.MethodGet, Path: "/api/events", Tag: "stats", Summary: "实时请求动态，SSE事件名为request", Produces: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/api/access", Tag: "access", Summary: "按黑白名单检查镜像、GitHub仓库或代理链接是否允许代理",
+		Query: []apiParam{{Name: "image", Description: "镜像引用"}, {Name: "github", Description: "owner/repo"},
+			{Name: "url", Description: "代理链接，返回匹配的链接规则和上游地址"}}},
+	{Method: http.MethodGet, Path: "/api/me", Tag: "access", Summary: "当前令牌的配额和当月用量"},
+	{Method: http.MethodGet, Path: "/api/verify", Tag: "github", Summary: "由代理端计算GitHub文件的sha256/sha512",
+		Query: []apiParam{{Name: "url", Description: "GitHub文件链接", Required: true}, {Name: "expected", Description: "期望的摘要，不匹配时返回409"}}},
+	{Method: http.MethodGet, Path: "/api/github/tree/:owner/:repo", Tag: "github", Summary: "列出仓库目录",
+		Query: []apiParam{{Name: "path", Description: "目录路径"}, {Name: "ref", Description: "分支、标签或提交"}}},
+	{Method: http.MethodGet, Path: "/api/image/info/:image", Tag: "tar", Summary: "镜像摘要、大小和支持的平台，镜像名中的/写为_",
+		Query: []apiParam{{Name: "tag", Description: "标签，默认latest"}}},
+	{Method: http.MethodGet, Path: "/api/image/preflight", Tag: "tar", Summary: "下载前检查镜像能否拉取，返回是否存在、是否允许、原因、解析的摘要和估算大小",
+		Query: []apiParam{{Name: "image", Description: "镜像引用，如nginx:latest", Required: true}, {Name: "platform", Description: "平台，如linux/amd64"}}},
+	{Method: http.MethodGet, Path: "/api/image/download/:image", Tag: "tar", Summary: "mode=prepare时返回一次性下载链接，带token时流式下载镜像tar包",
+		Query: []apiParam{
+			{Name: "mode", Description: "prepare"}, {Name: "token", Description: "prepare返回的下载令牌"},
+			{Name: "tag", Description: "标签"}, {Name: "platform", Description: "平台，如linux/amd64"},
+			{Name: "compressed", Description: "是否使用压缩层，默认true"}, {Name: "compress", Description: "tar包压缩格式"},
+		}},
+	{Method: http.MethodPost, Path: "/api/image/batch", Tag: "tar", Summary: "准备批量下载，返回一次性下载链接", Body: true,
+		Query: []apiParam{{Name: "mode", Description: "固定为prepare", Required: true}, {Name: "compress", Description: "tar包压缩格式"}}},
+	{Method: http.MethodGet, Path: "/api/image/batch", Tag: "tar", Summary: "流式下载批量镜像tar包", Produces: "application/octet-stream",
+		Query: []apiParam{{Name: "token", Description: "prepare返回的下载令牌", Required: true}}},
+	{Method: http.MethodGet, Path: "/api/image/jobs/:id", Tag: "tar", Summary: "下载任务的状态和已输出字节数，任务ID取自下载响应的X-Job-ID头",
+		Query: []apiParam{{Name: "wait", Description: "等待任务结束的最长时间，如30s，最多60s"}}},
+	{Method: http.MethodGet, Path: "/api/install-script", Tag: "tar", Summary: "生成离线镜像导入脚本", Produces: "text/x-shellscript",
+		Query: []apiParam{{Name: "image", Description: "镜像引用", Required: true}, {Name: "platform", Description: "平台"}}},
+	{Method: http.MethodGet, Path: "/api/download/:id/checksum", Tag: "signing", Summary: "已完成下载任务制品的sha256，任务仍在传输时最多等待10秒"},
+	{Method: http.MethodGet, Path: "/api/download/:id/signature", Tag: "signing", Summary: "已完成下载任务制品sha256的分离签名"},
+	{Method: http.MethodGet, Path: "/api/public-key", Tag: "signing", Summary: "PEM格式的签名公钥", Produces: "application/x-pem-file"},
+	{Method: http.MethodGet, Path: "/api/verify-script", Tag: "signing", Summary: "离线校验签名的脚本", Produces: "text/x-shellscript"},
+	{Method: http.MethodGet, Path: "/api/history", Tag: "history", Summary: "按时间倒序分页返回下载历史",
+		Query: []apiParam{{Name: "type", Description: "github或image-tar"}, {Name: "page"}, {Name: "page_size"}}},
+	{Method: http.MethodDelete, Path: "/api/history/:id", Tag: "history", Summary: "删除一条下载历史", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/copy", Tag: "copy", Summary: "创建镜像复制任务", Body: true, Status: http.StatusAccepted, Admin: true},
+	{Method: http.MethodGet, Path: "/api/copy/:id", Tag: "copy", Summary: "复制任务状态", Admin: true,
+		Query: []apiParam{{Name: "wait", Description: "等待任务结束的最长时间，如30s，最多60s"}}},
+	{Method: http.MethodGet, Path: "/api/copy/:id/events", Tag: "copy", Summary: "复制任务进度，SSE事件名为progress和done", Produces: "text/event-stream", Admin: true},
+	{Method: http.MethodDelete, Path: "/api/copy/:id", Tag: "copy", Summary: "取消复制任务", Admin: true},
+}
+
+// openAPIPath 将gin路由的:name和*name参数转换为OpenAPI的{name}写法，同时返回路径参数名
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIOperation 生成一个接口的OpenAPI描述
+func openAPIOperation(op apiOperation) gin.H {
+	var parameters []gin.H
+	path, pathParams := openAPIPath(op.Path)
+	for _, name := range pathParams {
+		parameters = append(parameters, gin.H{"name": name, "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+	}
+	for _, param := range op.Query {
+		parameters = append(parameters, gin.H{"name": param.Name, "in": "query", "required": param.Required,
+			"description": param.Description, "schema": gin.H{"type": "string"}})
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := gin.H{"description": http.StatusText(status)}
+	if status != http.StatusNoContent {
+		produces := op.Produces
+		if produces == "" {
+			produces = "application/json"
+		}
+		schema := gin.H{"type": "string"}
+		if produces == "application/json" {
+			schema = gin.H{"type": "object"}
+		}
+		success["content"] = gin.H{produces: gin.H{"schema": schema}}
+	}
+
+	operation := gin.H{
+		"operationId": strings.ToLower(op.Method) + strings.NewReplacer("/", "_", ".", "_", "{", "", "}", "").Replace(path),
+		"tags":        []string{op.Tag},
+		"summary":     op.Summary,
+		"responses": gin.H{
+			strconv.Itoa(status): success,
+			"default":            gin.H{"description": "错误", "content": gin.H{"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/Error"}}}},
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if op.Body {
+		operation["requestBody"] = gin.H{"required": true, "content": gin.H{"application/json": gin.H{"schema": gin.H{"type": "object"}}}}
+	}
+	if op.Admin {
+		operation["security"] = []gin.H{{"adminToken": []string{}}}
+	}
+	return operation
+}
+
+// buildOpenAPIDocument 由apiOperations生成OpenAPI 3文档，servers按basePath给出
+func buildOpenAPIDocument(basePath, version string) gin.H {
+	paths := gin.H{}
+	for _, op := range apiOperations {
+		path, _ := openAPIPath(op.Path)
+		item, _ := paths[path].(gin.H)
+		if item == nil {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = openAPIOperation(op)
+	}
+
+	server := basePath
+	if server == "" {
+		server = "/"
+	}
+	return gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "hubproxy API", "version": version},
+		"servers": []gin.H{{"url": server}},
+		"paths":   paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{"adminToken": gin.H{"type": "http", "scheme": "bearer"}},
+			"schemas": gin.H{"Error": gin.H{
+				"type": "object",
+				"properties": gin.H{
+					"error": gin.H{"type": "string"},
+					"code":  gin.H{"type": "string"},
+				},
+			}},
+		},
+	}
+}
+
+// InitOpenAPIRoutes 注册OpenAPI文档路由
+func (h *Handlers) InitOpenAPIRoutes(router *gin.Engine, version string) {
+	router.GET("/api/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildOpenAPIDocument(utils.BasePath(h.Config.Get()), version))
+	})
+}
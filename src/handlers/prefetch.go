@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// 预热项状态
+const (
+	PrefetchStatusPending   = "pending"
+	PrefetchStatusSucceeded = "succeeded"
+	PrefetchStatusSkipped   = "skipped"
+	PrefetchStatusFailed    = "failed"
+)
+
+// 预热任务状态
+const (
+	PrefetchJobRunning  = "running"
+	PrefetchJobFinished = "finished"
+)
+
+const (
+	prefetchJobRetention      = 30 * time.Minute
+	prefetchMaxItems          = 500
+	prefetchDefaultConcurrent = 4
+	prefetchMaxConcurrent     = 16
+)
+
+// PrefetchRequest 预热请求，images为镜像引用，urls为GitHub文件地址
+type PrefetchRequest struct {
+	Images      []string `json:"images"`
+	URLs        []string `json:"urls"`
+	Concurrency int      `json:"concurrency"`
+}
+
+// PrefetchItem 单个预热项，Manifests和Blobs为本次从上游获取的manifest和写入热点缓存的镜像层数
+type PrefetchItem struct {
+	Kind      string `json:"kind"`
+	Ref       string `json:"ref"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	Manifests int    `json:"manifests,omitempty"`
+	Blobs     int    `json:"blobs,omitempty"`
+}
+
+// PrefetchJob 预热任务
+type PrefetchJob struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	Items      []PrefetchItem `json:"items"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt time.Time      `json:"finished_at,omitempty"`
+}
+
+// prefetchJobStore 预热任务表
+type prefetchJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*PrefetchJob
+}
+
+func (s *prefetchJobStore) add(job *PrefetchJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.jobs {
+		if !existing.FinishedAt.IsZero() && now.Sub(existing.FinishedAt) > prefetchJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// snapshot 返回任务状态副本
+func (s *prefetchJobStore) snapshot(id string) (PrefetchJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return PrefetchJob{}, false
+	}
+	copied := *job
+	copied.Items = append([]PrefetchItem(nil), job.Items...)
+	return copied, true
+}
+
+func (s *prefetchJobStore) update(job *PrefetchJob, fn func(job *PrefetchJob)) {
+	s.mu.Lock()
+	fn(job)
+	s.mu.Unlock()
+}
+
+// prefetchTarget 预热镜像解析结果，imageRef与代理请求使用的缓存key一致
+type prefetchTarget struct {
+	imageRef  string
+	reference string
+	options   []remote.Option
+}
+
+// splitImageReference 拆分镜像名与tag或digest，未指定时为latest
+func splitImageReference(image string) (string, string) {
+	if idx := strings.Index(image, "@"); idx > 0 {
+		return image[:idx], image[idx+1:]
+	}
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		return image[:idx], image[idx+1:]
+	}
+	return image, "latest"
+}
+
+// resolvePrefetchImage 按代理的路由规则解析镜像，拒绝时返回错误码
+func (h *Handlers) resolvePrefetchImage(image string) (*prefetchTarget, string) {
+	cfg := h.Config.Get()
+	image, err := accesscontrol.NormalizeDockerReference(cfg, image)
+	if err != nil {
+		return nil, utils.ErrCodeRepositoryUppercase
+	}
+	repo, reference := splitImageReference(strings.TrimPrefix(image, "docker.io/"))
+
+	for _, domain := range h.Docker.Domains() {
+		if !strings.HasPrefix(repo, domain+"/") {
+			continue
+		}
+		mapping, enabled := h.Docker.RegistryMapping(domain)
+		if !enabled {
+			return nil, utils.ErrCodeRegistryDisabled
+		}
+		if allowed, reason := h.Access.CheckDockerAccess(repo); !allowed {
+			return nil, reason
+		}
+		return &prefetchTarget{
+			imageRef:  mapping.Upstream + "/" + strings.TrimPrefix(repo, domain+"/"),
+			reference: reference,
+			options:   h.Docker.UpstreamOptions(mapping),
+		}, ""
+	}
+
+	if h.Docker == nil {
+		return nil, utils.ErrCodeRegistryNotConfigured
+	}
+	if !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	if allowed, reason := h.Access.CheckDockerAccess(repo); !allowed {
+		return nil, reason
+	}
+	return &prefetchTarget{
+		imageRef:  fmt.Sprintf("%s/%s", h.Docker.Registry().Name(), repo),
+		reference: reference,
+		options:   h.Docker.Options(),
+	}, ""
+}
+
+// manifestCached 检查manifest是否已在缓存中
+func (h *Handlers) manifestCached(imageRef, reference string) bool {
+	return h.Cache.Get(utils.BuildManifestCacheKey(imageRef, reference, "")) != nil
+}
+
+// prefetchManifest 返回manifest内容，未缓存时从上游获取并写入缓存，fetched表示本次请求了上游
+func (h *Handlers) prefetchManifest(ctx context.Context, target *prefetchTarget, reference string) (data []byte, mediaType types.MediaType, fetched bool, err error) {
+	if item := h.Cache.Get(utils.BuildManifestCacheKey(target.imageRef, reference, "")); item != nil {
+		return item.Data, types.MediaType(item.ContentType), false, nil
+	}
+	desc, err := h.Docker.FetchManifest(ctx, target.imageRef, reference, target.options)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return desc.Manifest, desc.MediaType, true, nil
+}
+
+// prefetchImage 预热镜像manifest，多架构索引同时预热各平台manifest；
+// 热点缓存启用时各平台的配置和镜像层一并写入，与代理请求共用按digest寻址的缓存
+func (h *Handlers) prefetchImage(ctx context.Context, item *PrefetchItem) {
+	target, reason := h.resolvePrefetchImage(item.Ref)
+	if target == nil {
+		item.Status, item.Reason = PrefetchStatusSkipped, reason
+		return
+	}
+
+	data, mediaType, fetched, err := h.prefetchManifest(ctx, target, target.reference)
+	if err != nil {
+		item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+		return
+	}
+	if fetched {
+		item.Manifests++
+	}
+
+	var images [][]byte
+	switch {
+	case mediaType.IsIndex():
+		index, err := v1.ParseIndexManifest(bytes.NewReader(data))
+		if err != nil {
+			item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+			return
+		}
+		for _, child := range index.Manifests {
+			childData, childType, fetched, err := h.prefetchManifest(ctx, target, child.Digest.String())
+			if err != nil {
+				item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+				return
+			}
+			if fetched {
+				item.Manifests++
+			}
+			if childType.IsImage() {
+				images = append(images, childData)
+			}
+		}
+	case mediaType.IsImage():
+		images = append(images, data)
+	}
+
+	if err := h.prefetchBlobs(ctx, target, images, item); err != nil {
+		item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+		return
+	}
+	if item.Manifests == 0 && item.Blobs == 0 {
+		item.Status, item.Reason = PrefetchStatusSkipped, "已缓存"
+		return
+	}
+	item.Status = PrefetchStatusSucceeded
+}
+
+// prefetchBlobs 将镜像的配置和各层写入热点缓存，已缓存和超过单个对象上限的跳过
+func (h *Handlers) prefetchBlobs(ctx context.Context, target *prefetchTarget, images [][]byte, item *PrefetchItem) error {
+	cfg := h.Config.Get().HotCache
+	if !cfg.Enabled {
+		return nil
+	}
+	seen := make(map[v1.Hash]bool)
+	for _, data := range images {
+		manifest, err := v1.ParseManifest(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		for _, desc := range append([]v1.Descriptor{manifest.Config}, manifest.Layers...) {
+			if seen[desc.Digest] || desc.Size <= 0 || desc.Size > cfg.MaxObjectBytes {
+				continue
+			}
+			seen[desc.Digest] = true
+			if _, _, ok := h.Hot.Peek(utils.BuildBlobHotKey(desc.Digest.String())); ok {
+				continue
+			}
+			if err := h.prefetchBlob(ctx, target, desc); err != nil {
+				return err
+			}
+			item.Blobs++
+		}
+	}
+	return nil
+}
+
+// prefetchBlob 下载一个镜像层写入热点缓存，读到结尾时内容已按digest校验
+func (h *Handlers) prefetchBlob(ctx context.Context, target *prefetchTarget, desc v1.Descriptor) error {
+	ref, err := name.NewDigest(target.imageRef + "@" + desc.Digest.String())
+	if err != nil {
+		return err
+	}
+	layer, err := remote.Layer(ref, append(append([]remote.Option(nil), target.options...), remote.WithContext(ctx))...)
+	if err != nil {
+		return err
+	}
+	reader, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, desc.Size+1))
+	if err != nil {
+		return fmt.Errorf("下载镜像层 %s 失败: %w", desc.Digest, err)
+	}
+	if int64(len(data)) != desc.Size {
+		return fmt.Errorf("镜像层 %s 大小不符: 清单为 %d，实际为 %d", desc.Digest, desc.Size, len(data))
+	}
+	h.Hot.Store(utils.BuildBlobHotKey(desc.Digest.String()), data, "application/octet-stream")
+	return nil
+}
+
+// resolvePrefetchURL 按代理的链接规则解析GitHub文件地址，返回与代理请求相同的上游地址，拒绝时返回原因。
+// 只有固定到commit SHA的链接会写入热点缓存
+func (h *Handlers) resolvePrefetchURL(u string) (string, string) {
+	target, matchPath, err := githubproxy.NormalizeRequestURI("/" + u)
+	if err != nil {
+		return "", utils.ErrCodeInvalidInput
+	}
+	match := githubproxy.MatchURL(matchPath)
+	if match == nil || match.Git() {
+		return "", utils.ErrCodeInvalidInput
+	}
+	if allowed, reason := match.CheckAccess(h.Access); !allowed {
+		return "", reason
+	}
+	target, _ = githubproxy.StripAccelParam(match.Rewrite(target))
+	if !githubproxy.IsImmutableURL(target) {
+		return "", "只缓存固定到commit SHA的链接"
+	}
+	return target, ""
+}
+
+// prefetchGitHubAsset 下载固定到commit SHA的GitHub文件写入热点缓存，之后的代理请求直接命中
+func (h *Handlers) prefetchGitHubAsset(ctx context.Context, item *PrefetchItem) {
+	target, reason := h.resolvePrefetchURL(item.Ref)
+	if target == "" {
+		item.Status, item.Reason = PrefetchStatusSkipped, reason
+		return
+	}
+	cfg := h.Config.Get().HotCache
+	if !cfg.Enabled {
+		item.Status, item.Reason = PrefetchStatusSkipped, "热点缓存未启用"
+		return
+	}
+	key := utils.BuildGitHubAssetHotKey(target)
+	if _, _, ok := h.Hot.Peek(key); ok {
+		item.Status, item.Reason = PrefetchStatusSkipped, "已缓存"
+		return
+	}
+	h.downloadGitHubAsset(ctx, item, key, target)
+}
+
+// downloadGitHubAsset 从upstream下载文件，完整且不超过单个对象上限时以key写入热点缓存
+func (h *Handlers) downloadGitHubAsset(ctx context.Context, item *PrefetchItem, key, upstream string) {
+	cfg := h.Config.Get().HotCache
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream, nil)
+	if err != nil {
+		item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+		return
+	}
+	// 与代理一样只缓存未压缩的内容
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := h.Clients.Global().Do(req)
+	if err != nil {
+		item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		item.Status, item.Reason = PrefetchStatusFailed, fmt.Sprintf("上游返回 %d", resp.StatusCode)
+		return
+	}
+	if resp.ContentLength > cfg.MaxObjectBytes {
+		item.Status, item.Reason = PrefetchStatusSkipped, "超过单个对象上限"
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxObjectBytes+1))
+	if err != nil {
+		item.Status, item.Reason = PrefetchStatusFailed, err.Error()
+		return
+	}
+	if int64(len(data)) > cfg.MaxObjectBytes {
+		item.Status, item.Reason = PrefetchStatusSkipped, "超过单个对象上限"
+		return
+	}
+	if resp.ContentLength > 0 && int64(len(data)) != resp.ContentLength {
+		item.Status, item.Reason = PrefetchStatusFailed, "响应不完整"
+		return
+	}
+	h.Hot.Store(key, data, resp.Header.Get("Content-Type"))
+	item.Status = PrefetchStatusSucceeded
+}
+
+// runPrefetchJob 以有限并发执行预热任务，上游流量计入后台流量和重任务预算，服务停止时取消
+func (h *Handlers) runPrefetchJob(job *PrefetchJob, concurrency int) {
+	ctx, cancel := context.WithCancel(h.Background.Context())
+	defer cancel()
+	ctx, traffic := utils.WithTrafficTag(ctx)
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	// 各项只由对应的goroutine修改，这里读取的是启动前的初始状态
+	for i, item := range job.Items {
+		if item.Status != PrefetchStatusPending {
+			continue
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, item PrefetchItem) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if item.Kind == "url" {
+				h.prefetchGitHubAsset(ctx, &item)
+			} else {
+				h.prefetchImage(ctx, &item)
+			}
+			h.prefetchJobs.update(job, func(job *PrefetchJob) {
+				job.Items[i] = item
+			})
+		}(i, item)
+	}
+	wg.Wait()
+
+	upstream := traffic.Finish(utils.TrafficRouteBackground, utils.CacheBypass)
+	h.Stats.AddUpstreamBytes(utils.TrafficRouteBackground, utils.CacheBypass, upstream)
+	h.Schedule.AddBytes(upstream)
+
+	h.prefetchJobs.update(job, func(job *PrefetchJob) {
+		job.Status = PrefetchJobFinished
+		job.FinishedAt = time.Now()
+	})
+	fmt.Printf("预热任务 %s 完成\n", job.ID)
+}
+
+// handlePrefetch 创建缓存预热任务
+func (h *Handlers) handlePrefetch(c *gin.Context) {
+	cfg := h.Config.Get()
+	if !utils.IsCacheEnabled(cfg) {
+		utils.RespondErrorMessage(c, http.StatusConflict, utils.ErrCodeFeatureDisabled, "缓存未启用，无法预热")
+		return
+	}
+
+	var req PrefetchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+		return
+	}
+
+	total := len(req.Images) + len(req.URLs)
+	if total == 0 || total > prefetchMaxItems {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, fmt.Sprintf("预热项数量需在1到%d之间", prefetchMaxItems))
+		return
+	}
+	if !h.requireHeavySchedule(c) {
+		return
+	}
+
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = prefetchDefaultConcurrent
+	}
+	concurrency = min(concurrency, prefetchMaxConcurrent)
+
+	job := &PrefetchJob{
+		ID:        newJobID(),
+		Status:    PrefetchJobRunning,
+		Items:     make([]PrefetchItem, 0, total),
+		CreatedAt: time.Now(),
+	}
+	for _, image := range req.Images {
+		job.Items = append(job.Items, PrefetchItem{Kind: "image", Ref: strings.TrimSpace(image), Status: PrefetchStatusPending})
+	}
+	for _, url := range req.URLs {
+		job.Items = append(job.Items, PrefetchItem{Kind: "url", Ref: strings.TrimSpace(url), Status: PrefetchStatusPending})
+	}
+	h.prefetchJobs.add(job)
+
+	fmt.Printf("管理操作: %s 创建预热任务 %s，共 %d 项\n", h.IPs.Client(c), job.ID, total)
+	go h.runPrefetchJob(job, concurrency)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": "/admin/prefetch/" + job.ID,
+	})
+}
+
+// handlePrefetchStatus 查询预热任务状态
+func (h *Handlers) handlePrefetchStatus(c *gin.Context) {
+	job, exists := h.prefetchJobs.snapshot(c.Param("jobid"))
+	if !exists {
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeJobNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestSplitImageReference(t *testing.T) {
+	for _, tt := range []struct {
+		image, repo, reference string
+	}{
+		{"nginx", "nginx", "latest"},
+		{"nginx:1.25", "nginx", "1.25"},
+		{"localhost:5000/app", "localhost:5000/app", "latest"},
+		{"ghcr.io/org/app@sha256:abc", "ghcr.io/org/app", "sha256:abc"},
+	} {
+		repo, reference := splitImageReference(tt.image)
+		if repo != tt.repo || reference != tt.reference {
+			t.Errorf("splitImageReference(%q) = %q, %q", tt.image, repo, reference)
+		}
+	}
+}
+
+func TestRunPrefetchJob(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	loadTestConfig(t, fmt.Sprintf(`
+[access]
+blackList = ["org/denied"]
+
+[registries."%s"]
+upstream = "%s"
+enabled = true
+`, host, host))
+	h := newTestHandlers()
+
+	index, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(host + "/org/app:v1")
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &PrefetchJob{ID: "prefetch-test", Status: PrefetchJobRunning, Items: []PrefetchItem{
+		{Kind: "image", Ref: host + "/org/app:v1", Status: PrefetchStatusPending},
+		{Kind: "image", Ref: host + "/org/denied:v1", Status: PrefetchStatusPending},
+		{Kind: "image", Ref: host + "/org/missing:v1", Status: PrefetchStatusPending},
+	}}
+	h.prefetchJobs.add(job)
+	h.runPrefetchJob(job, 2)
+
+	snapshot, _ := h.prefetchJobs.snapshot(job.ID)
+	if snapshot.Status != PrefetchJobFinished {
+		t.Fatalf("job status = %s", snapshot.Status)
+	}
+	// 两个平台各有配置和一层，均写入热点缓存
+	if item := snapshot.Items[0]; item.Status != PrefetchStatusSucceeded || item.Manifests != 3 || item.Blobs != 4 {
+		t.Fatalf("image item = %+v", item)
+	}
+	if item := snapshot.Items[1]; item.Status != PrefetchStatusSkipped || item.Reason != utils.ErrCodeDockerBlacklisted {
+		t.Fatalf("denied item = %+v", item)
+	}
+	if item := snapshot.Items[2]; item.Status != PrefetchStatusFailed {
+		t.Fatalf("missing item = %+v", item)
+	}
+
+	imageRef := host + "/org/app"
+	if !h.manifestCached(imageRef, "v1") {
+		t.Fatal("tag manifest not cached")
+	}
+	child, _ := index.IndexManifest()
+	image, _ := index.Image(child.Manifests[0].Digest)
+	layers, _ := image.Layers()
+	digest, _ := layers[0].Digest()
+	if _, _, ok := h.Hot.Peek(utils.BuildBlobHotKey(digest.String())); !ok {
+		t.Fatal("layer not in hot cache")
+	}
+
+	// 再次预热时已缓存的项直接跳过
+	again := &PrefetchJob{ID: "prefetch-again", Items: []PrefetchItem{
+		{Kind: "image", Ref: host + "/org/app:v1", Status: PrefetchStatusPending},
+	}}
+	h.prefetchJobs.add(again)
+	h.runPrefetchJob(again, 1)
+	snapshot, _ = h.prefetchJobs.snapshot(again.ID)
+	if item := snapshot.Items[0]; item.Status != PrefetchStatusSkipped {
+		t.Fatalf("cached item = %+v", item)
+	}
+}
+
+const testCommitSHA = "0123456789abcdef0123456789abcdef01234567"
+
+func TestResolvePrefetchURL(t *testing.T) {
+	loadTestConfig(t, `
+[access]
+blackList = ["denied/repo"]
+`)
+	h := newTestHandlers()
+	pinned := "https://raw.githubusercontent.com/o/r/" + testCommitSHA + "/install.sh"
+	tests := []struct {
+		url, target, reason string
+	}{
+		{url: pinned, target: pinned},
+		{url: "github.com/o/r/blob/" + testCommitSHA + "/README.md", target: "https://github.com/o/r/raw/" + testCommitSHA + "/README.md"},
+		{url: "https://raw.githubusercontent.com/o/r/main/install.sh", reason: "只缓存固定到commit SHA的链接"},
+		{url: "https://raw.githubusercontent.com/denied/repo/" + testCommitSHA + "/install.sh", reason: utils.ErrCodeGitHubBlacklisted},
+		{url: "https://example.com/file", reason: utils.ErrCodeInvalidInput},
+	}
+	for _, tt := range tests {
+		target, reason := h.resolvePrefetchURL(tt.url)
+		if target != tt.target || reason != tt.reason {
+			t.Errorf("resolvePrefetchURL(%q) = %q, %q", tt.url, target, reason)
+		}
+	}
+}
+
+func TestPrefetchGitHubAsset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[hotCache]
+enabled = true
+maxObjectBytes = 16
+`)
+	h := newTestHandlers()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer upstream.Close()
+
+	pinned := "https://raw.githubusercontent.com/o/r/" + testCommitSHA + "/file.txt"
+	key := utils.BuildGitHubAssetHotKey(pinned)
+	item := PrefetchItem{Kind: "url", Ref: pinned}
+	h.downloadGitHubAsset(context.Background(), &item, key, upstream.URL+"/pinned")
+	if item.Status != PrefetchStatusSucceeded {
+		t.Fatalf("item = %+v", item)
+	}
+
+	// 预热后代理请求直接命中热点缓存
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/"+pinned, nil)
+	h.GitHub.Handler(c)
+	if w.Body.String() != "pinned" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("cached response = %q %v", w.Body.String(), w.Header())
+	}
+
+	large := PrefetchItem{Kind: "url", Ref: pinned}
+	h.downloadGitHubAsset(context.Background(), &large, utils.BuildGitHubAssetHotKey("large"), upstream.URL+"/"+strings.Repeat("x", 32))
+	if large.Status != PrefetchStatusSkipped {
+		t.Fatalf("large item = %+v", large)
+	}
+	if _, _, ok := h.Hot.Peek(utils.BuildGitHubAssetHotKey("large")); ok {
+		t.Fatal("object above maxObjectBytes cached")
+	}
+}
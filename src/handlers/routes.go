@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 路由分组，按输出顺序排列；github为NoRoute处理的GitHub加速，不在gin路由表中
+var routeGroupNames = []string{"static", "v2", "token", "search", "api", "tar", "admin", "github"}
+
+// streamingRoutePrefixes 长时间传输文件或推送事件的路由，反向代理需要关闭缓冲
+var streamingRoutePrefixes = []string{"/v2/", "/api/image/download/", "/api/image/batch", "/api/events"}
+
+// routeEntry 一条路由，同一路径的多个方法合并
+type routeEntry struct {
+	Path      string   `json:"path"`
+	Methods   []string `json:"methods"`
+	Streaming bool     `json:"streaming"`
+}
+
+// routeGroup 一组路由，fallback表示匹配其余所有路径
+type routeGroup struct {
+	Name      string       `json:"name"`
+	Streaming bool         `json:"streaming"`
+	Fallback  bool         `json:"fallback,omitempty"`
+	Routes    []routeEntry `json:"routes"`
+}
+
+// routeGroupOf 按路径前缀归类路由
+func routeGroupOf(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v2/"):
+		return "v2"
+	case path == "/token" || strings.HasPrefix(path, "/token/"):
+		return "token"
+	case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return "admin"
+	case path == "/search" || strings.HasPrefix(path, "/tags/"):
+		return "search"
+	case strings.HasPrefix(path, "/api/image/") || path == "/api/install-script":
+		return "tar"
+	case strings.HasPrefix(path, "/api/") || path == "/metrics" || path == "/health" || strings.HasPrefix(path, "/health/") || path == "/ready":
+		return "api"
+	default:
+		return "static"
+	}
+}
+
+// routeStreams 判断路由是否长时间传输数据
+func routeStreams(path string) bool {
+	for _, prefix := range streamingRoutePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(path, "/events")
+}
+
+// buildRouteGroups 按gin路由表生成分组，最后追加NoRoute处理的GitHub加速
+func buildRouteGroups(routes gin.RoutesInfo) []routeGroup {
+	byPath := make(map[string]*routeEntry)
+	for _, route := range routes {
+		entry := byPath[route.Path]
+		if entry == nil {
+			entry = &routeEntry{Path: route.Path, Streaming: routeStreams(route.Path)}
+			byPath[route.Path] = entry
+		}
+		entry.Methods = append(entry.Methods, route.Method)
+	}
+
+	groups := make(map[string]*routeGroup)
+	for _, entry := range byPath {
+		sort.Strings(entry.Methods)
+		name := routeGroupOf(entry.Path)
+		group := groups[name]
+		if group == nil {
+			group = &routeGroup{Name: name}
+			groups[name] = group
+		}
+		group.Routes = append(group.Routes, *entry)
+		group.Streaming = group.Streaming || entry.Streaming
+	}
+	groups["github"] = &routeGroup{Name: "github", Streaming: true, Fallback: true, Routes: []routeEntry{
+		{Path: "/*url", Methods: []string{"*"}, Streaming: true},
+	}}
+
+	result := make([]routeGroup, 0, len(groups))
+	for _, name := range routeGroupNames {
+		if group := groups[name]; group != nil {
+			sort.Slice(group.Routes, func(i, j int) bool { return group.Routes[i].Path < group.Routes[j].Path })
+			result = append(result, *group)
+		}
+	}
+	return result
+}
+
+// proxyLocation 反向代理中的一个匹配位置，含参数的路由取参数之前的前缀
+type proxyLocation struct {
+	Path      string
+	Prefix    bool
+	Fallback  bool
+	Streaming bool
+}
+
+// groupLocations 返回分组需要的匹配位置，已被同组前缀覆盖的位置合并到前缀中
+func groupLocations(group routeGroup) []proxyLocation {
+	if group.Fallback {
+		return []proxyLocation{{Path: "/", Prefix: true, Fallback: true, Streaming: group.Streaming}}
+	}
+
+	var locations []proxyLocation
+	for _, route := range group.Routes {
+		location := proxyLocation{Path: route.Path, Streaming: route.Streaming}
+		if i := strings.IndexAny(route.Path, ":*"); i >= 0 {
+			location.Path, location.Prefix = route.Path[:i], true
+		}
+		locations = append(locations, location)
+	}
+
+	// 先处理前缀，较短的前缀在前，后续位置可直接合并到已有的前缀中
+	sort.SliceStable(locations, func(i, j int) bool {
+		if locations[i].Prefix != locations[j].Prefix {
+			return locations[i].Prefix
+		}
+		return locations[i].Path < locations[j].Path
+	})
+	merged := make([]proxyLocation, 0, len(locations))
+	for _, location := range locations {
+		covered := false
+		for i := range merged {
+			if merged[i].Prefix && strings.HasPrefix(location.Path, merged[i].Path) {
+				merged[i].Streaming = merged[i].Streaming || location.Streaming
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			merged = append(merged, location)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Path < merged[j].Path })
+	return merged
+}
+
+// proxyConfigGroup 反向代理配置模板中的一个分组
+type proxyConfigGroup struct {
+	Name      string
+	Streaming bool
+	Fallback  bool
+	Locations []proxyLocation
+}
+
+// proxyConfigData 反向代理配置模板参数
+type proxyConfigData struct {
+	Listen   string
+	BasePath string
+	Groups   []proxyConfigGroup
+}
+
+const nginxRoutesTemplate = `# hubproxy 路由，由 /api/routes?format=nginx 生成，粘贴到 server {} 块内
+# GitHub加速的链接路径中包含 https://，需要关闭合并斜杠
+merge_slashes off;
+proxy_http_version 1.1;
+proxy_set_header Host $host;
+proxy_set_header X-Real-IP $remote_addr;
+proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+proxy_set_header X-Forwarded-Proto $scheme;
+{{range .Groups}}
+# {{.Name}}{{if .Fallback}}：其余路径均由GitHub加速处理{{end}}
+{{range .Locations}}location {{if .Fallback}}{{else if .Prefix}}^~ {{else}}= {{end}}{{$.BasePath}}{{.Path}} {
+{{- if $.BasePath}}
+    rewrite ^{{$.BasePath}}(/.*)$ $1 break;
+{{- end}}
+    proxy_pass http://{{$.Listen}};
+{{- if .Streaming}}
+    proxy_buffering off;
+    proxy_request_buffering off;
+    proxy_read_timeout 1h;
+{{- end}}
+}
+{{end}}{{end -}}
+`
+
+const caddyRoutesTemplate = `# hubproxy 路由，由 /api/routes?format=caddy 生成，粘贴到站点块内
+{{range .Groups}}
+# {{.Name}}{{if .Fallback}}：其余路径均由GitHub加速处理{{end}}
+{{if .Fallback}}handle{{if $.BasePath}} {{$.BasePath}}/*{{end}} {{else}}@hubproxy_{{.Name}} path{{range .Locations}} {{$.BasePath}}{{.Path}}{{if .Prefix}}*{{end}}{{end}}
+handle @hubproxy_{{.Name}} {{end}}{
+{{- if $.BasePath}}
+	uri strip_prefix {{$.BasePath}}
+{{- end}}
+	reverse_proxy {{$.Listen}} {
+		header_up X-Real-IP {remote_host}
+{{- if .Streaming}}
+		flush_interval -1
+{{- end}}
+	}
+}
+{{end -}}
+`
+
+var proxyConfigTemplates = map[string]*template.Template{
+	"nginx": template.Must(template.New("nginx").Parse(nginxRoutesTemplate)),
+	"caddy": template.Must(template.New("caddy").Parse(caddyRoutesTemplate)),
+}
+
+// upstreamListenAddress 返回反向代理访问本服务的地址，监听所有地址时使用本机回环地址
+func upstreamListenAddress(cfg *config.AppConfig) string {
+	host := cfg.Server.Host
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::", "[::]":
+		host = "::1"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(cfg.Server.Port))
+}
+
+// renderProxyConfig 按分组生成nginx或caddy配置片段
+func renderProxyConfig(format, listen, basePath string, groups []routeGroup) (string, error) {
+	data := proxyConfigData{Listen: listen, BasePath: basePath}
+	for _, group := range groups {
+		data.Groups = append(data.Groups, proxyConfigGroup{
+			Name:      group.Name,
+			Streaming: group.Streaming,
+			Fallback:  group.Fallback,
+			Locations: groupLocations(group),
+		})
+	}
+	var b strings.Builder
+	if err := proxyConfigTemplates[format].Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// handleRoutes 返回已挂载的路由分组，format=nginx|caddy 时返回可直接粘贴的反向代理配置
+func (h *Handlers) handleRoutes(c *gin.Context) {
+	cfg := h.Config.Get()
+	format := c.Query("format")
+	if format == "" || format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"listen":    upstreamListenAddress(cfg),
+			"base_path": utils.BasePath(cfg),
+			"groups":    h.mountedRoutes,
+		})
+		return
+	}
+	if proxyConfigTemplates[format] == nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidInput)
+		return
+	}
+
+	text, err := renderProxyConfig(format, upstreamListenAddress(cfg), utils.BasePath(cfg), h.mountedRoutes)
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+}
+
+// InitRouteTableRoutes 注册路由表接口，需在其他路由都注册完成后调用，以便记录完整的路由表
+func (h *Handlers) InitRouteTableRoutes(router *gin.Engine) {
+	router.GET("/api/routes", h.handleRoutes)
+	h.mountedRoutes = buildRouteGroups(router.Routes())
+}
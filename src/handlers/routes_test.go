@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testRouteTable 覆盖各分组和前缀合并情况的路由表
+func testRouteTable() []routeGroup {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	noop := func(c *gin.Context) {}
+	router.GET("/", noop)
+	router.GET("/public/*filepath", noop)
+	router.GET("/install.sh", noop)
+	router.Any("/v2/*path", noop)
+	router.Any("/token", noop)
+	router.Any("/token/*path", noop)
+	router.GET("/search", noop)
+	router.GET("/tags/:namespace/:name", noop)
+	router.GET("/health", noop)
+	router.GET("/api/events", noop)
+	router.POST("/api/copy", noop)
+	router.GET("/api/copy/:id", noop)
+	router.GET("/api/copy/:id/events", noop)
+	router.GET("/api/image/download/:image", noop)
+	router.GET("/api/image/info/:image", noop)
+	router.GET("/api/install-script", noop)
+	router.GET("/admin/", noop)
+	router.POST("/admin/reload", noop)
+	router.GET("/admin/tokens", noop)
+	router.POST("/admin/tokens", noop)
+	router.DELETE("/admin/tokens/:name", noop)
+	return buildRouteGroups(router.Routes())
+}
+
+func TestBuildRouteGroups(t *testing.T) {
+	groups := testRouteTable()
+	var names []string
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	want := []string{"static", "v2", "token", "search", "api", "tar", "admin", "github"}
+	if len(names) != len(want) {
+		t.Fatalf("groups %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("groups %v, want %v", names, want)
+		}
+	}
+
+	admin := groups[6]
+	if admin.Streaming || len(admin.Routes) != 4 || admin.Routes[2].Path != "/admin/tokens" ||
+		len(admin.Routes[2].Methods) != 2 || admin.Routes[2].Methods[0] != http.MethodGet {
+		t.Fatalf("admin group %+v", admin)
+	}
+	if !groups[1].Streaming || groups[2].Streaming || !groups[7].Fallback {
+		t.Fatalf("streaming flags %+v", groups)
+	}
+
+	// /api/copy/:id/events 并入 /api/copy/ 前缀，前缀需关闭缓冲
+	locations := groupLocations(groups[4])
+	var copyPrefix *proxyLocation
+	for i := range locations {
+		if locations[i].Path == "/api/copy/" {
+			copyPrefix = &locations[i]
+		}
+	}
+	if len(locations) != 4 || copyPrefix == nil || !copyPrefix.Prefix || !copyPrefix.Streaming {
+		t.Fatalf("api locations %+v", locations)
+	}
+}
+
+func TestRenderProxyConfigGolden(t *testing.T) {
+	groups := testRouteTable()
+	for _, format := range []string{"nginx", "caddy"} {
+		for _, basePath := range []string{"", "/hub"} {
+			text, err := renderProxyConfig(format, "127.0.0.1:5000", basePath, groups)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name := "routes_" + format
+			if basePath != "" {
+				name += "_basepath"
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(text), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != string(want) {
+				t.Fatalf("%s config differs from %s, run go test -update to refresh:\n%s", format, golden, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// requireHeavySchedule 检查重任务的时段和预算，不允许时返回带下次允许时间的JSON错误
+func (h *Handlers) requireHeavySchedule(c *gin.Context) bool {
+	cfg := h.Config.Get()
+	err := h.Schedule.Allow(c)
+	if err == nil {
+		return true
+	}
+
+	var scheduleErr *utils.ScheduleError
+	if !errors.As(err, &scheduleErr) {
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+		return false
+	}
+	utils.SetRetryAfter(c, time.Until(scheduleErr.NextAllowed))
+	message := utils.Localize(c, scheduleErr.Code, utils.FormatScheduleTime(cfg, scheduleErr.NextAllowed))
+	utils.RespondErrorFields(c, http.StatusTooManyRequests, scheduleErr.Code, message, gin.H{
+		"next_allowed_at": scheduleErr.NextAllowed,
+	})
+	return false
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// handleScreeningStatus 返回屏蔽列表状态、标记和最近的判定
+func (h *Handlers) handleScreeningStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Screener.Status())
+}
+
+// handleScreeningFlag 标记仓库或文件哈希，立即屏蔽并清除相关缓存
+func (h *Handlers) handleScreeningFlag(c *gin.Context) {
+	if !h.Screener.Enabled() {
+		utils.RespondErrorMessage(c, http.StatusConflict, utils.ErrCodeFeatureDisabled, "内容筛查未启用，标记不会生效")
+		return
+	}
+	var req struct {
+		Target string `json:"target"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidRequestBody, err)
+		return
+	}
+
+	client := h.IPs.Client(c)
+	target, err := h.Screener.Flag(req.Target, req.Reason, client)
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, err.Error())
+		return
+	}
+	purged := utils.PurgeScreenedTarget(h.Cache, h.Hot, target)
+	h.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionFlag, Target: target, Rule: "admin", ClientIP: client})
+	fmt.Printf("管理操作: %s 标记 %s，清除缓存 %d 项\n", client, target, purged)
+	c.JSON(http.StatusOK, gin.H{"target": target, "purged": purged})
+}
+
+// handleScreeningUnflag 取消标记，target通过查询参数传入
+func (h *Handlers) handleScreeningUnflag(c *gin.Context) {
+	target := utils.NormalizeScreeningTarget(c.Query("target"))
+	if !h.Screener.Unflag(target) {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeNotFound, "标记不存在")
+		return
+	}
+	h.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionUnflag, Target: target, Rule: "admin", ClientIP: h.IPs.Client(c)})
+	c.JSON(http.StatusOK, gin.H{"target": target})
+}
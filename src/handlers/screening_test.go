@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const screeningTestConfig = `
+[admin]
+enabled = true
+token = "peer-token"
+requestsPerMinute = 0
+
+[security.screening]
+enabled = true
+`
+
+func TestScreeningFlagBlocksBlobAndPurgesCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, screeningTestConfig)
+	h := newTestHandlers()
+	t.Cleanup(func() { h.Hot.Flush("") })
+
+	blob := []byte("layer")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	h.Hot.Store(utils.BuildBlobHotKey(digest), blob, "application/octet-stream")
+
+	router := gin.New()
+	h.InitAdminRoutes(router)
+	req := httptest.NewRequest(http.MethodPost, "/admin/screening/flag", strings.NewReader(`{"target": "`+digest+`", "reason": "reported"}`))
+	req.Header.Set("Authorization", "Bearer peer-token")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Fatalf("flag status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/library/app/blobs/"+digest, nil)
+	h.Docker.RegistryHandler(c)
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != utils.ErrCodeContentScreened {
+		t.Fatalf("blob status %d, headers %v", w.Code, w.Header())
+	}
+	if recent := h.Screener.Status().Recent; len(recent) != 2 || recent[0].Rule != "flag:"+digest || recent[1].Rule != "admin" {
+		t.Fatalf("audit = %+v", recent)
+	}
+
+	// 未启用筛查时拒绝标记，避免误以为已生效
+	loadTestConfig(t, strings.Replace(screeningTestConfig, "[security.screening]\nenabled = true", "", 1))
+	h.Config.Set(config.GetConfig())
+	req = httptest.NewRequest(http.MethodPost, "/admin/screening/flag", strings.NewReader(`{"target": "evil/repo"}`))
+	req.Header.Set("Authorization", "Bearer peer-token")
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("disabled flag status %d", w.Code)
+	}
+}
@@ -3,16 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
 	"github.com/gin-gonic/gin"
-	"hubproxy/utils"
 )
 
 // SearchResult Docker Hub搜索结果
@@ -21,6 +24,8 @@ type SearchResult struct {
 	Next     string       `json:"next"`
 	Previous string       `json:"previous"`
 	Results  []Repository `json:"results"`
+	// Stale Docker Hub限流或故障时返回的过期缓存结果
+	Stale bool `json:"stale,omitempty"`
 }
 
 // Repository 仓库信息
@@ -75,6 +80,14 @@ type TagPageResult struct {
 type cacheEntry struct {
 	data      interface{}
 	expiresAt time.Time
+
+	// 带上游验证器的项过期后保留到retainUntil，用于条件请求重新验证；size为上游响应体大小
+	validators  utils.CacheValidators
+	size        int
+	retainUntil time.Time
+
+	// staleUntil 上游不可用时仍可作为过期结果返回的截止时间，见KeepStale
+	staleUntil time.Time
 }
 
 const (
@@ -83,18 +96,39 @@ const (
 	cacheTTL           = 30 * time.Minute
 )
 
+// dockerHubAPIBase Docker Hub Web API地址，测试时替换为本地服务
+var dockerHubAPIBase = "https://registry.hub.docker.com/v2"
+
 type Cache struct {
 	data    map[string]cacheEntry
 	mu      sync.RWMutex
 	maxSize int
+	config  *config.Store
 }
 
-var (
-	searchCache = &Cache{
+// newSearchCache 创建搜索缓存，重新验证保留时长取自store的当前配置，每5分钟清理一次过期条目，ctx结束时停止清理
+func newSearchCache(ctx context.Context, store *config.Store) *Cache {
+	cache := &Cache{
 		data:    make(map[string]cacheEntry),
 		maxSize: maxCacheSize,
+		config:  store,
 	}
-)
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		done := ctx.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				cache.Cleanup()
+			}
+		}
+	}()
+	return cache
+}
 
 func (c *Cache) Get(key string) (interface{}, bool) {
 	c.mu.RLock()
@@ -105,10 +139,12 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 		return nil, false
 	}
 
-	if time.Now().After(entry.expiresAt) {
-		c.mu.Lock()
-		delete(c.data, key)
-		c.mu.Unlock()
+	if now := time.Now(); now.After(entry.expiresAt) {
+		if !now.Before(entry.retainUntil) && !now.Before(entry.staleUntil) {
+			c.mu.Lock()
+			delete(c.data, key)
+			c.mu.Unlock()
+		}
 		return nil, false
 	}
 
@@ -120,45 +156,96 @@ func (c *Cache) Set(key string, data interface{}) {
 }
 
 func (c *Cache) SetWithTTL(key string, data interface{}, ttl time.Duration) {
+	c.SetValidated(key, data, ttl, utils.CacheValidators{}, 0)
+}
+
+// SetValidated 写入缓存项，带上游验证器的项过期后按revalidateFor配置继续保留，
+// 供GetForRevalidation使用；size为上游响应体大小，用于统计重新验证省下的流量
+func (c *Cache) SetValidated(key string, data interface{}, ttl time.Duration, validators utils.CacheValidators, size int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if len(c.data) >= c.maxSize {
-		c.cleanupExpiredLocked()
+		c.cleanupExpiredLocked(true)
 	}
 
+	expiresAt := time.Now().Add(ttl)
+	retainUntil := expiresAt
+	if !validators.Empty() {
+		if revalidateFor := utils.GetRevalidateFor(c.config.Get()); revalidateFor > 0 {
+			retainUntil = expiresAt.Add(revalidateFor)
+		}
+	}
 	c.data[key] = cacheEntry{
-		data:      data,
-		expiresAt: time.Now().Add(ttl),
+		data:        data,
+		expiresAt:   expiresAt,
+		validators:  validators,
+		size:        size,
+		retainUntil: retainUntil,
+	}
+}
+
+// GetForRevalidation 返回已过期但仍保留用于条件请求的缓存数据及其验证器
+func (c *Cache) GetForRevalidation(key string) (interface{}, utils.CacheValidators, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, exists := c.data[key]
+	if !exists || entry.validators.Empty() || !time.Now().Before(entry.retainUntil) {
+		return nil, utils.CacheValidators{}, false
+	}
+	return entry.data, entry.validators, true
+}
+
+// Renew 上游返回304时续期缓存项，返回缓存项大小，供调用方计入重新验证省下的流量
+func (c *Cache) Renew(key string, ttl time.Duration) (int, bool) {
+	c.mu.RLock()
+	entry, exists := c.data[key]
+	c.mu.RUnlock()
+	if !exists {
+		return 0, false
+	}
+	c.SetValidated(key, entry.data, ttl, entry.validators, entry.size)
+	return entry.size, true
+}
+
+// KeepStale 缓存项过期后继续保留staleFor，供上游不可用时通过GetStale返回
+func (c *Cache) KeepStale(key string, staleFor time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.data[key]; exists {
+		entry.staleUntil = entry.expiresAt.Add(staleFor)
+		c.data[key] = entry
 	}
 }
 
+// GetStale 返回缓存数据，包括已过期但仍在KeepStale保留期内的项
+func (c *Cache) GetStale(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, exists := c.data[key]
+	if !exists {
+		return nil, false
+	}
+	now := time.Now()
+	return entry.data, now.Before(entry.expiresAt) || now.Before(entry.staleUntil)
+}
+
 func (c *Cache) Cleanup() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.cleanupExpiredLocked()
+	c.cleanupExpiredLocked(false)
 }
 
-func (c *Cache) cleanupExpiredLocked() {
+// cleanupExpiredLocked 删除过期项，dropRetained为false时保留仍可重新验证的项
+func (c *Cache) cleanupExpiredLocked(dropRetained bool) {
 	now := time.Now()
 	for key, entry := range c.data {
-		if now.After(entry.expiresAt) {
+		if now.After(entry.expiresAt) && (dropRetained || !now.Before(entry.retainUntil) && !now.Before(entry.staleUntil)) {
 			delete(c.data, key)
 		}
 	}
 }
 
-func init() {
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			searchCache.Cleanup()
-		}
-	}()
-}
-
 // normalizeRepository 统一规范化仓库信息
 func normalizeRepository(repo *Repository) {
 	if repo.IsOfficial {
@@ -183,18 +270,166 @@ func normalizeRepository(repo *Repository) {
 	}
 }
 
+// searchStaleFor 搜索结果过期后，上游限流或故障时仍可返回的时长
+const searchStaleFor = 24 * time.Hour
+
+// maxSearchPageSize Docker Hub搜索接口允许的最大page_size
+const maxSearchPageSize = 100
+
+// searchParams 规范化后的搜索参数，Official映射为上游的is_official，MinStars在本地过滤
+type searchParams struct {
+	Query    string
+	Page     int
+	PageSize int
+	Official bool
+	MinStars int
+}
+
+// normalizeSearchQuery 去掉多余空白并转为小写，使大小写和空格不同的相同查询共用缓存
+func normalizeSearchQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// cacheAccess 请求能否读写搜索和标签缓存，按客户端的Cache-Control: no-cache/no-store决定
+type cacheAccess struct {
+	read  bool
+	write bool
+}
+
+// fullCacheAccess 不带绕过指令时的缓存许可
+var fullCacheAccess = cacheAccess{read: true, write: true}
+
+// requestCacheAccess 返回请求的缓存许可，要求绕过时响应带 X-Cache-Bypass: 1
+func (h *Handlers) requestCacheAccess(c *gin.Context) cacheAccess {
+	cfg := h.Config.Get()
+	return cacheAccess{read: utils.CacheReadAllowed(cfg, c), write: utils.CacheWriteAllowed(cfg, c)}
+}
+
+// cacheKey 上游结果的缓存key，MinStars和黑白名单在读取缓存后过滤，不参与key
+func (p searchParams) cacheKey() string {
+	key := fmt.Sprintf("search:%s:%d:%d", p.Query, p.Page, p.PageSize)
+	if p.Official {
+		key += ":official"
+	}
+	return key
+}
+
+// searchUpstreamError Docker Hub返回的非200状态
+type searchUpstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *searchUpstreamError) Error() string {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return "请求过于频繁，请稍后重试"
+	case e.StatusCode == http.StatusNotFound:
+		return "未找到相关镜像"
+	case e.StatusCode >= http.StatusInternalServerError:
+		return "docker hub 服务暂时不可用，请稍后重试"
+	default:
+		return fmt.Sprintf("请求失败: 状态码=%d, 响应=%s", e.StatusCode, e.Body)
+	}
+}
+
+// unavailable 上游限流或故障，此时可返回过期的缓存结果
+func (e *searchUpstreamError) unavailable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
 // searchDockerHub 搜索镜像
-func searchDockerHub(ctx context.Context, query string, page, pageSize int) (*SearchResult, error) {
-	return searchDockerHubWithDepth(ctx, query, page, pageSize, 0)
+func (h *Handlers) searchDockerHub(ctx context.Context, query string, page, pageSize int) (*SearchResult, error) {
+	return h.searchDockerHubWithDepth(ctx, searchParams{Query: query, Page: page, PageSize: pageSize}, 0, fullCacheAccess)
+}
+
+// searchWithFallback 合并相同的并发搜索，上游返回429或5xx时使用过期的缓存结果并标记stale。
+// 要求绕过缓存的请求不与普通请求合并，也不使用过期结果
+func (h *Handlers) searchWithFallback(ctx context.Context, params searchParams, access cacheAccess) (*SearchResult, error) {
+	flightKey := fmt.Sprintf("%s|%t|%t", params.cacheKey(), access.read, access.write)
+	result, _, err := h.searchFlight.Do(flightKey, 0, 0, func() (interface{}, error) {
+		return h.searchDockerHubWithDepth(ctx, params, 0, access)
+	})
+	// 合并的请求随发起者取消时，本请求仍未取消则自行查询
+	if err != nil && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		result, err = h.searchDockerHubWithDepth(ctx, params, 0, access)
+	}
+	if err == nil {
+		return result.(*SearchResult), nil
+	}
+
+	var upstreamErr *searchUpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.unavailable() && access.read {
+		if cached, ok := h.searchCache.GetStale(params.cacheKey()); ok {
+			stale := *cached.(*SearchResult)
+			stale.Stale = true
+			return &stale, nil
+		}
+	}
+	return nil, err
+}
+
+// filterSearchResult 按official、minStars和黑白名单过滤，返回副本，不修改缓存中的结果
+func (h *Handlers) filterSearchResult(result *SearchResult, params searchParams) *SearchResult {
+	filtered := *result
+	filtered.Results = make([]Repository, 0, len(result.Results))
+	for _, repo := range result.Results {
+		if params.Official && !repo.IsOfficial || repo.StarCount < params.MinStars {
+			continue
+		}
+		image := repo.Name
+		if !strings.Contains(image, "/") {
+			image = repo.Namespace + "/" + image
+		}
+		if allowed, _ := h.Access.CheckDockerAccess(image); !allowed {
+			continue
+		}
+		filtered.Results = append(filtered.Results, repo)
+	}
+	return &filtered
 }
 
-func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize int, depth int) (*SearchResult, error) {
+// parseSearchParams 解析搜索参数，page_size也可写作pageSize
+func parseSearchParams(c *gin.Context) (searchParams, error) {
+	params := searchParams{Query: normalizeSearchQuery(c.Query("q"))}
+	if params.Query == "" {
+		return params, fmt.Errorf("搜索关键词不能为空")
+	}
+
+	params.Page, params.PageSize = parsePaginationParams(c, 25)
+	if ps := c.Query("pageSize"); ps != "" {
+		if _, err := fmt.Sscanf(ps, "%d", &params.PageSize); err != nil {
+			return params, fmt.Errorf("pageSize参数无效: %s", ps)
+		}
+	}
+	params.Page = max(params.Page, 1)
+	params.PageSize = min(max(params.PageSize, 1), maxSearchPageSize)
+
+	if official := c.Query("official"); official != "" {
+		value, err := strconv.ParseBool(official)
+		if err != nil {
+			return params, fmt.Errorf("official参数无效: %s", official)
+		}
+		params.Official = value
+	}
+	if minStars := c.Query("minStars"); minStars != "" {
+		value, err := strconv.Atoi(minStars)
+		if err != nil || value < 0 {
+			return params, fmt.Errorf("minStars参数无效: %s", minStars)
+		}
+		params.MinStars = value
+	}
+	return params, nil
+}
+
+func (h *Handlers) searchDockerHubWithDepth(ctx context.Context, search searchParams, depth int, access cacheAccess) (*SearchResult, error) {
 	if depth > 1 {
 		return nil, fmt.Errorf("搜索请求过于复杂，请尝试更具体的关键词")
 	}
-	cacheKey := fmt.Sprintf("search:%s:%d:%d", query, page, pageSize)
+	query, page, pageSize := search.Query, search.Page, search.PageSize
+	cacheKey := search.cacheKey()
 
-	if cached, ok := searchCache.Get(cacheKey); ok {
+	if cached, ok := h.searchCache.Get(cacheKey); ok && access.read {
 		return cached.(*SearchResult), nil
 	}
 
@@ -209,7 +444,7 @@ func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize
 		}
 	}
 
-	baseURL := "https://registry.hub.docker.com/v2"
+	baseURL := dockerHubAPIBase
 	var fullURL string
 	var params url.Values
 
@@ -226,13 +461,30 @@ func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize
 			"page":      {fmt.Sprintf("%d", page)},
 			"page_size": {fmt.Sprintf("%d", pageSize)},
 		}
+		if search.Official {
+			params.Set("is_official", "true")
+		}
 	}
 
 	fullURL = fullURL + "?" + params.Encode()
 
-	resp, err := utils.GetSearchHTTPClient().Get(fullURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("请求Docker Hub API失败: %v", err)
+		return nil, fmt.Errorf("请求Docker Hub API失败: %w", err)
+	}
+	// 缓存过期但仍保留时带上验证器，内容未变化时上游返回304，直接续期原结果
+	var cachedResult interface{}
+	var validators utils.CacheValidators
+	var revalidating bool
+	if access.read {
+		cachedResult, validators, revalidating = h.searchCache.GetForRevalidation(cacheKey)
+	}
+	if revalidating {
+		validators.Apply(req)
+	}
+	resp, err := h.Clients.Search().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求Docker Hub API失败: %w", err)
 	}
 	defer safeCloseResponseBody(resp.Body, "搜索响应体")
 
@@ -241,20 +493,19 @@ func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize
 		return nil, fmt.Errorf("读取响应失败: %v", err)
 	}
 
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		if size, ok := h.searchCache.Renew(cacheKey, cacheTTL); ok {
+			h.Cache.RecordRevalidation(cacheKey, size)
+		}
+		h.searchCache.KeepStale(cacheKey, searchStaleFor)
+		return cachedResult.(*SearchResult), nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		switch resp.StatusCode {
-		case http.StatusTooManyRequests:
-			return nil, fmt.Errorf("请求过于频繁，请稍后重试")
-		case http.StatusNotFound:
-			if isUserRepo && namespace != "" {
-				return searchDockerHubWithDepth(ctx, repoName, page, pageSize, depth+1)
-			}
-			return nil, fmt.Errorf("未找到相关镜像")
-		case http.StatusBadGateway, http.StatusServiceUnavailable:
-			return nil, fmt.Errorf("docker hub 服务暂时不可用，请稍后重试")
-		default:
-			return nil, fmt.Errorf("请求失败: 状态码=%d, 响应=%s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusNotFound && isUserRepo && namespace != "" {
+			return h.searchDockerHubWithDepth(ctx, searchParams{Query: repoName, Page: page, PageSize: pageSize, Official: search.Official}, depth+1, access)
 		}
+		return nil, &searchUpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var result *SearchResult
@@ -285,7 +536,7 @@ func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize
 		}
 
 		if len(result.Results) == 0 {
-			return searchDockerHubWithDepth(ctx, repoName, page, pageSize, depth+1)
+			return h.searchDockerHubWithDepth(ctx, searchParams{Query: repoName, Page: page, PageSize: pageSize, Official: search.Official}, depth+1, access)
 		}
 
 		result.Count = len(result.Results)
@@ -311,7 +562,10 @@ func searchDockerHubWithDepth(ctx context.Context, query string, page, pageSize
 		}
 	}
 
-	searchCache.Set(cacheKey, result)
+	if access.write {
+		h.searchCache.SetValidated(cacheKey, result, cacheTTL, utils.ValidatorsFromHeader(resp.Header), len(body))
+		h.searchCache.KeepStale(cacheKey, searchStaleFor)
+	}
 	return result, nil
 }
 
@@ -331,7 +585,7 @@ func isRetryableError(err error) bool {
 }
 
 // getRepositoryTags 获取仓库标签信息
-func getRepositoryTags(ctx context.Context, namespace, name string, page, pageSize int) ([]TagInfo, bool, error) {
+func (h *Handlers) getRepositoryTags(ctx context.Context, namespace, name string, page, pageSize int, access cacheAccess) ([]TagInfo, bool, error) {
 	if namespace == "" || name == "" {
 		return nil, false, fmt.Errorf("无效输入：命名空间和名称不能为空")
 	}
@@ -344,12 +598,12 @@ func getRepositoryTags(ctx context.Context, namespace, name string, page, pageSi
 	}
 
 	cacheKey := fmt.Sprintf("tags:%s:%s:page_%d", namespace, name, page)
-	if cached, ok := searchCache.Get(cacheKey); ok {
+	if cached, ok := h.searchCache.Get(cacheKey); ok && access.read {
 		result := cached.(TagPageResult)
 		return result.Tags, result.HasMore, nil
 	}
 
-	baseURL := fmt.Sprintf("https://registry.hub.docker.com/v2/repositories/%s/%s/tags", namespace, name)
+	baseURL := fmt.Sprintf("%s/repositories/%s/%s/tags", dockerHubAPIBase, namespace, name)
 	params := url.Values{}
 	params.Set("page", fmt.Sprintf("%d", page))
 	params.Set("page_size", fmt.Sprintf("%d", pageSize))
@@ -357,39 +611,71 @@ func getRepositoryTags(ctx context.Context, namespace, name string, page, pageSi
 
 	fullURL := baseURL + "?" + params.Encode()
 
-	pageResult, err := fetchTagPage(ctx, fullURL, 3)
+	var cached interface{}
+	var validators utils.CacheValidators
+	var revalidating bool
+	if access.read {
+		cached, validators, revalidating = h.searchCache.GetForRevalidation(cacheKey)
+	}
+	pageResult, err := h.fetchTagPage(ctx, fullURL, 3, validators)
 	if err != nil {
 		return nil, false, fmt.Errorf("获取标签失败: %v", err)
 	}
+	if revalidating && pageResult.notModified {
+		if size, ok := h.searchCache.Renew(cacheKey, 30*time.Minute); ok {
+			h.Cache.RecordRevalidation(cacheKey, size)
+		}
+		result := cached.(TagPageResult)
+		return result.Tags, result.HasMore, nil
+	}
 
 	hasMore := pageResult.Next != ""
 
 	result := TagPageResult{Tags: pageResult.Results, HasMore: hasMore}
-	searchCache.SetWithTTL(cacheKey, result, 30*time.Minute)
+	if access.write {
+		h.searchCache.SetValidated(cacheKey, result, 30*time.Minute, pageResult.validators, pageResult.size)
+	}
 
 	return pageResult.Results, hasMore, nil
 }
 
-func fetchTagPage(ctx context.Context, url string, maxRetries int) (*struct {
+// tagPage Docker Hub标签分页响应，notModified表示上游对条件请求返回了304
+type tagPage struct {
 	Count    int       `json:"count"`
 	Next     string    `json:"next"`
 	Previous string    `json:"previous"`
 	Results  []TagInfo `json:"results"`
-}, error) {
+
+	validators  utils.CacheValidators
+	size        int
+	notModified bool
+}
+
+// fetchTagPage 获取一页标签，validators非空时发送条件请求
+func (h *Handlers) fetchTagPage(ctx context.Context, url string, maxRetries int, validators utils.CacheValidators) (*tagPage, error) {
 	var lastErr error
 
 	for retry := 0; retry < maxRetries; retry++ {
 		if retry > 0 {
-			time.Sleep(time.Duration(retry) * 500 * time.Millisecond)
+			select {
+			case <-time.After(time.Duration(retry) * 500 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 		}
 
-		resp, err := utils.GetSearchHTTPClient().Get(url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("发送请求失败: %w", err)
+		}
+		validators.Apply(req)
+		resp, err := h.Clients.Search().Do(req)
 		if err != nil {
 			lastErr = err
 			if isRetryableError(err) && retry < maxRetries-1 {
 				continue
 			}
-			return nil, fmt.Errorf("发送请求失败: %v", err)
+			return nil, fmt.Errorf("发送请求失败: %w", err)
 		}
 
 		body, err := func() ([]byte, error) {
@@ -405,8 +691,14 @@ func fetchTagPage(ctx context.Context, url string, maxRetries int) (*struct {
 			return nil, fmt.Errorf("读取响应失败: %v", err)
 		}
 
+		if resp.StatusCode == http.StatusNotModified && !validators.Empty() {
+			return &tagPage{notModified: true}, nil
+		}
 		if resp.StatusCode != http.StatusOK {
 			lastErr = fmt.Errorf("状态码=%d, 响应=%s", resp.StatusCode, string(body))
+			if resp.StatusCode == http.StatusTooManyRequests {
+				lastErr = &searchUpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+			}
 			if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 {
 				return nil, fmt.Errorf("请求失败: %v", lastErr)
 			}
@@ -416,12 +708,7 @@ func fetchTagPage(ctx context.Context, url string, maxRetries int) (*struct {
 			return nil, fmt.Errorf("请求失败: %v", lastErr)
 		}
 
-		var result struct {
-			Count    int       `json:"count"`
-			Next     string    `json:"next"`
-			Previous string    `json:"previous"`
-			Results  []TagInfo `json:"results"`
-		}
+		result := tagPage{validators: utils.ValidatorsFromHeader(resp.Header), size: len(body)}
 		if err := json.Unmarshal(body, &result); err != nil {
 			lastErr = err
 			if retry < maxRetries-1 {
@@ -463,30 +750,49 @@ func safeCloseResponseBody(body io.ReadCloser, context string) {
 }
 
 func sendErrorResponse(c *gin.Context, message string) {
-	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, message)
+}
+
+// respondSearchError 返回搜索和标签查询的错误：出站预算不足返回503，Docker Hub限流返回429，均带Retry-After
+func (h *Handlers) respondSearchError(c *gin.Context, err error) {
+	if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+		utils.RespondError(c, http.StatusServiceUnavailable, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+		return
+	}
+	var upstreamErr *searchUpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusTooManyRequests {
+		host := "registry.hub.docker.com"
+		if u, parseErr := url.Parse(dockerHubAPIBase); parseErr == nil {
+			host = u.Hostname()
+		}
+		if wait, ok := h.Clients.UpstreamThrottleRemaining(host); ok {
+			utils.SetRetryAfter(c, wait)
+		}
+		utils.RespondError(c, http.StatusTooManyRequests, utils.ErrCodeUpstreamThrottled, host)
+		return
+	}
+	sendErrorResponse(c, err.Error())
 }
 
 // RegisterSearchRoute 注册搜索相关路由
-func RegisterSearchRoute(r *gin.Engine) {
-	r.GET("/search", func(c *gin.Context) {
-		query := c.Query("q")
-		if query == "" {
-			sendErrorResponse(c, "搜索关键词不能为空")
+func (h *Handlers) RegisterSearchRoute(r *gin.Engine) {
+	r.GET("/search", h.uiRouteGuard, utils.APITimeoutMiddleware(h.Config, utils.APITimeoutSearch), func(c *gin.Context) {
+		params, err := parseSearchParams(c)
+		if err != nil {
+			sendErrorResponse(c, err.Error())
 			return
 		}
 
-		page, pageSize := parsePaginationParams(c, 25)
-
-		result, err := searchDockerHub(c.Request.Context(), query, page, pageSize)
+		result, err := h.searchWithFallback(c.Request.Context(), params, h.requestCacheAccess(c))
 		if err != nil {
-			sendErrorResponse(c, err.Error())
+			h.respondSearchError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		c.JSON(http.StatusOK, h.filterSearchResult(result, params))
 	})
 
-	r.GET("/tags/:namespace/:name", func(c *gin.Context) {
+	r.GET("/tags/:namespace/:name", h.uiRouteGuard, utils.APITimeoutMiddleware(h.Config, utils.APITimeoutSearch), func(c *gin.Context) {
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 
@@ -497,9 +803,9 @@ func RegisterSearchRoute(r *gin.Engine) {
 
 		page, pageSize := parsePaginationParams(c, 100)
 
-		tags, hasMore, err := getRepositoryTags(c.Request.Context(), namespace, name, page, pageSize)
+		tags, hasMore, err := h.getRepositoryTags(c.Request.Context(), namespace, name, page, pageSize, h.requestCacheAccess(c))
 		if err != nil {
-			sendErrorResponse(c, err.Error())
+			h.respondSearchError(c, err)
 			return
 		}
 
@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/7alva7/hubproxy/src/utils"
 	"github.com/gin-gonic/gin"
 )
 
@@ -43,3 +50,299 @@ func TestSearchCacheExpires(t *testing.T) {
 		t.Fatalf("expired cache returned: %#v", got)
 	}
 }
+
+func TestDockerHubRevalidation(t *testing.T) {
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+
+	var version, full, notModified atomic.Int64
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := strconv.FormatInt(version.Load(), 10)
+		etag := `"` + r.URL.Path + "-" + v + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/tags") {
+			w.Write([]byte(`{"count":1,"results":[{"name":"1.` + v + `"}]}`))
+			return
+		}
+		w.Write([]byte(`{"count":1,"results":[{"repo_name":"nginx` + v + `","is_official":true}]}`))
+	}))
+	defer server.Close()
+	previous := dockerHubAPIBase
+	dockerHubAPIBase = server.URL + "/v2"
+	t.Cleanup(func() { dockerHubAPIBase = previous })
+
+	searchKey := "search:nginx:1:25"
+	tagsKey := "tags:library:nginx:page_1"
+	expire := func(key string) {
+		h.searchCache.mu.Lock()
+		defer h.searchCache.mu.Unlock()
+		entry, ok := h.searchCache.data[key]
+		if !ok {
+			t.Fatalf("%s not cached", key)
+		}
+		entry.expiresAt = time.Now().Add(-time.Second)
+		h.searchCache.data[key] = entry
+	}
+
+	search := func() string {
+		result, err := h.searchDockerHub(context.Background(), "nginx", 1, 25)
+		if err != nil || len(result.Results) != 1 {
+			t.Fatalf("search: %v %+v", err, result)
+		}
+		return result.Results[0].Name
+	}
+	tags := func() string {
+		result, _, err := h.getRepositoryTags(context.Background(), "library", "nginx", 1, 100, fullCacheAccess)
+		if err != nil || len(result) != 1 {
+			t.Fatalf("tags: %v %+v", err, result)
+		}
+		return result[0].Name
+	}
+
+	before := h.Cache.Stats()
+	if search() != "library/nginx1" || tags() != "1.1" || full.Load() != 2 {
+		t.Fatalf("initial fetch: %d upstream responses", full.Load())
+	}
+
+	// 未变化：条件请求返回304，沿用缓存结果
+	expire(searchKey)
+	expire(tagsKey)
+	if search() != "library/nginx1" || tags() != "1.1" {
+		t.Fatal("revalidated results differ")
+	}
+	if full.Load() != 2 || notModified.Load() != 2 {
+		t.Fatalf("upstream full %d, not modified %d", full.Load(), notModified.Load())
+	}
+	after := h.Cache.Stats()
+	for _, category := range []string{"search", "tags"} {
+		if after[category].Revalidated != before[category].Revalidated+1 || after[category].RevalidationSavedBytes <= before[category].RevalidationSavedBytes {
+			t.Fatalf("%s stats %+v -> %+v", category, before[category], after[category])
+		}
+	}
+	if _, ok := h.searchCache.Get(searchKey); !ok {
+		t.Fatal("search entry not renewed")
+	}
+
+	// 内容变化：上游返回200，替换缓存结果
+	version.Store(2)
+	expire(searchKey)
+	expire(tagsKey)
+	if search() != "library/nginx2" || tags() != "1.2" || full.Load() != 4 {
+		t.Fatalf("changed results not fetched: %d upstream responses", full.Load())
+	}
+}
+
+func TestSearchTimeoutCancelsUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[apiTimeouts]\nsearch = \"100ms\"\n")
+	h := newTestHandlers()
+
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	previous := dockerHubAPIBase
+	dockerHubAPIBase = server.URL + "/v2"
+	t.Cleanup(func() { dockerHubAPIBase = previous })
+
+	router := gin.New()
+	h.RegisterSearchRoute(router)
+
+	baseline := runtime.NumGoroutine()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?q=slow-timeout-test", nil))
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), utils.ErrCodeRequestTimeout) {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request not cancelled")
+	}
+	server.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("goroutines after timeout: %d, baseline %d", n, baseline)
+	}
+}
+
+func TestSearchRouteCacheFiltersAndStale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["evil/*"]
+`)
+	h := newTestHandlers()
+
+	var calls atomic.Int64
+	var status atomic.Int64
+	status.Store(http.StatusOK)
+	var lastQuery atomic.Value
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		lastQuery.Store(r.URL.RawQuery)
+		if r.URL.Query().Get("query") == "slowhub" {
+			<-release
+		}
+		if code := int(status.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(`{"count":3,"results":[
+			{"repo_name":"redis","is_official":true,"star_count":12000},
+			{"repo_name":"someone/redis-tools","repo_owner":"someone","star_count":3},
+			{"repo_name":"evil/redis","repo_owner":"evil","star_count":500}]}`))
+	}))
+	defer server.Close()
+	previous := dockerHubAPIBase
+	dockerHubAPIBase = server.URL + "/v2"
+	t.Cleanup(func() { dockerHubAPIBase = previous })
+
+	router := gin.New()
+	h.RegisterSearchRoute(router)
+	search := func(query string) (int, SearchResult) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		var result SearchResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+	names := func(result SearchResult) string {
+		var out []string
+		for _, repo := range result.Results {
+			out = append(out, repo.Namespace+"/"+repo.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	// 黑名单命名空间被过滤；大小写和空白不同的相同查询命中缓存
+	if code, result := search("q=Redis&page_size=10"); code != http.StatusOK || names(result) != "library/library/redis,someone/redis-tools" {
+		t.Fatalf("search = %d %q", code, names(result))
+	}
+	if code, result := search("q=+redis+&pageSize=10&minStars=100"); code != http.StatusOK || names(result) != "library/library/redis" || calls.Load() != 1 {
+		t.Fatalf("cached search = %d %q, upstream calls %d", code, names(result), calls.Load())
+	}
+
+	// official映射为上游参数并单独缓存
+	if code, _ := search("q=redis&official=true&page=2&pageSize=500"); code != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("official search = %d, upstream calls %d", code, calls.Load())
+	}
+	if got := lastQuery.Load().(string); !strings.Contains(got, "is_official=true") || !strings.Contains(got, "page=2") || !strings.Contains(got, "page_size=100") {
+		t.Fatalf("upstream query = %s", got)
+	}
+	for _, bad := range []string{"q=redis&minStars=-1", "q=redis&official=maybe", "q=+"} {
+		if code, _ := search(bad); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", bad, code)
+		}
+	}
+
+	// 上游限流时返回过期结果并标记stale
+	h.searchCache.mu.Lock()
+	entry := h.searchCache.data["search:redis:1:10"]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	h.searchCache.data["search:redis:1:10"] = entry
+	h.searchCache.mu.Unlock()
+	status.Store(http.StatusTooManyRequests)
+	if code, result := search("q=redis&page_size=10"); code != http.StatusOK || !result.Stale || names(result) != "library/library/redis,someone/redis-tools" {
+		t.Fatalf("stale search = %d %+v", code, result)
+	}
+	// 没有可用的缓存时转告上游限流，并建议客户端何时重试
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?q=uncached", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("uncached search during 429: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// 相同的并发搜索只请求一次上游
+	status.Store(http.StatusOK)
+	before := calls.Load()
+	done := make(chan int, 5)
+	for range 5 {
+		go func() {
+			code, _ := search("q=slowhub")
+			done <- code
+		}()
+	}
+	for calls.Load() == before {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for range 5 {
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("concurrent search status %d", code)
+		}
+	}
+	if got := calls.Load() - before; got != 1 {
+		t.Fatalf("concurrent identical searches hit upstream %d times", got)
+	}
+}
+
+func TestSearchAndTagsHonorCacheBypass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/tags") {
+			w.Write([]byte(`{"count":1,"results":[{"name":"1.0"}]}`))
+			return
+		}
+		w.Write([]byte(`{"count":1,"results":[{"repo_name":"bypass","is_official":true}]}`))
+	}))
+	defer server.Close()
+	previous := dockerHubAPIBase
+	dockerHubAPIBase = server.URL + "/v2"
+	t.Cleanup(func() { dockerHubAPIBase = previous })
+
+	router := gin.New()
+	h.RegisterSearchRoute(router)
+	get := func(path, cacheControl string, wantCalls int64) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || calls.Load() != wantCalls {
+			t.Fatalf("%s (%s): status %d, upstream calls %d, want %d", path, cacheControl, w.Code, calls.Load(), wantCalls)
+		}
+		if bypass := w.Header().Get("X-Cache-Bypass") == "1"; bypass != (cacheControl != "") {
+			t.Fatalf("%s (%s): X-Cache-Bypass = %q", path, cacheControl, w.Header().Get("X-Cache-Bypass"))
+		}
+	}
+
+	for _, path := range []string{"/search?q=bypass", "/tags/library/bypass"} {
+		start := calls.Load()
+		get(path, "", start+1)
+		get(path, "", start+1)
+		// no-cache跳过读取，新结果仍写入缓存
+		get(path, "no-cache", start+2)
+		get(path, "", start+2)
+	}
+
+	// no-store既不读取也不写入
+	start := calls.Load()
+	get("/search?q=nostore", "no-store", start+1)
+	get("/search?q=nostore", "", start+2)
+	get("/search?q=nostore", "", start+2)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// artifactDigestTTL 已完成下载任务摘要的保留时间，超时后无法再获取校验和与签名
+const artifactDigestTTL = 24 * time.Hour
+
+// artifactChecksumWait 查询校验和时任务仍在传输的最长等待时间
+const artifactChecksumWait = 10 * time.Second
+
+// artifactVerifyScript 离线校验制品签名的脚本，只依赖openssl
+const artifactVerifyScript = `#!/bin/sh
+# 校验 HubProxy 生成制品的Ed25519签名，只依赖 openssl(1.1.1及以上)
+# 签名文件可以是 /api/download/<任务ID>/signature 的JSON响应，也可以只包含base64签名
+set -eu
+
+if [ $# -ne 3 ]; then
+	echo "用法: $0 <制品文件> <签名文件> <公钥PEM文件>" >&2
+	exit 2
+fi
+ARTIFACT=$1
+SIGNATURE=$2
+PUBKEY=$3
+
+if ! command -v openssl >/dev/null 2>&1; then
+	echo "需要 openssl" >&2
+	exit 1
+fi
+
+TMP=$(mktemp -d)
+trap 'rm -rf "$TMP"' EXIT
+
+DIGEST=$(openssl dgst -sha256 -r "$ARTIFACT" | cut -d' ' -f1)
+printf '%s' "$DIGEST" > "$TMP/digest"
+
+SIG=$(tr -d '\r\n' < "$SIGNATURE")
+case "$SIG" in
+	*'"signature"'*) SIG=$(printf '%s' "$SIG" | sed 's/.*"signature" *: *"\([^"]*\)".*/\1/') ;;
+esac
+printf '%s' "$SIG" | openssl base64 -d -A > "$TMP/sig"
+
+if openssl pkeyutl -verify -pubin -inkey "$PUBKEY" -rawin -in "$TMP/digest" -sigfile "$TMP/sig" >/dev/null 2>&1; then
+	echo "签名有效，sha256: $DIGEST"
+else
+	echo "签名无效，sha256: $DIGEST" >&2
+	exit 1
+fi
+`
+
+// artifactDigest 已完成下载任务的制品摘要
+type artifactDigest struct {
+	images      []string
+	sha256      string
+	completedAt time.Time
+}
+
+// artifactDigestStore 按任务ID保存制品摘要
+type artifactDigestStore struct {
+	mu      sync.Mutex
+	digests map[string]artifactDigest
+}
+
+func (s *artifactDigestStore) put(id string, digest artifactDigest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, existing := range s.digests {
+		if digest.completedAt.Sub(existing.completedAt) > artifactDigestTTL {
+			delete(s.digests, key)
+		}
+	}
+	s.digests[id] = digest
+}
+
+func (s *artifactDigestStore) get(id string) (artifactDigest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest, exists := s.digests[id]
+	if !exists || time.Since(digest.completedAt) > artifactDigestTTL {
+		return artifactDigest{}, false
+	}
+	return digest, true
+}
+
+// trackArtifactDigest 对下载任务的响应计算sha256，供校验和与签名接口使用。
+// 返回的函数在流式传输结束后调用，只有完整传输的制品才会记录摘要
+func (h *Handlers) trackArtifactDigest(c *gin.Context, images []string) func(err error) {
+	jobID := c.GetString(tarJobIDKey)
+	if jobID == "" {
+		return func(error) {}
+	}
+
+	writer := utils.NewHashingResponseWriter(c.Writer)
+	c.Writer = writer
+	return func(err error) {
+		if err != nil || c.Request.Context().Err() != nil {
+			return
+		}
+		h.artifactDigests.put(jobID, artifactDigest{
+			images:      images,
+			sha256:      writer.Sum(),
+			completedAt: time.Now(),
+		})
+	}
+}
+
+// artifactSigner 返回签名器，未启用签名时写入404响应
+func (h *Handlers) artifactSigner(c *gin.Context) (*utils.ArtifactSigner, bool) {
+	signer, err := h.Signing.Signer()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeNotFound, err.Error())
+		return nil, false
+	}
+	return signer, true
+}
+
+// handleArtifactChecksum 返回已完成下载任务制品的sha256，不依赖签名配置。
+// 客户端收完响应时任务可能尚未登记摘要，此时等待任务结束后再查询
+func (h *Handlers) handleArtifactChecksum(c *gin.Context) {
+	id := c.Param("id")
+	digest, exists := h.artifactDigests.get(id)
+	if !exists {
+		h.tarJobs.Wait(c.Request.Context(), id, artifactChecksumWait)
+		digest, exists = h.artifactDigests.get(id)
+	}
+	if !exists {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeJobNotFound, "下载任务不存在、未完成或已过期")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":     id,
+		"images": digest.images,
+		"sha256": digest.sha256,
+	})
+}
+
+// handleArtifactSignature 返回已完成下载任务制品sha256的分离签名
+func (h *Handlers) handleArtifactSignature(c *gin.Context) {
+	signer, ok := h.artifactSigner(c)
+	if !ok {
+		return
+	}
+	digest, exists := h.artifactDigests.get(c.Param("id"))
+	if !exists {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeJobNotFound, "下载任务不存在、未完成或已过期")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        c.Param("id"),
+		"images":    digest.images,
+		"sha256":    digest.sha256,
+		"algorithm": "ed25519",
+		"signature": base64.StdEncoding.EncodeToString(signer.SignDigest(digest.sha256)),
+	})
+}
+
+// handlePublicKey 以PEM格式返回签名公钥
+func (h *Handlers) handlePublicKey(c *gin.Context) {
+	signer, ok := h.artifactSigner(c)
+	if !ok {
+		return
+	}
+	data, err := signer.PublicKeyPEM()
+	if err != nil {
+		utils.RespondErrorMessage(c, http.StatusInternalServerError, utils.ErrCodeInternal, "导出公钥失败: "+err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pem-file", data)
+}
+
+// handleVerifyScript 返回离线校验签名的脚本
+func (h *Handlers) handleVerifyScript(c *gin.Context) {
+	if _, ok := h.artifactSigner(c); !ok {
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\"hubproxy-verify.sh\"")
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", []byte(artifactVerifyScript))
+}
+
+// InitSigningRoutes 注册制品校验和与签名路由
+func (h *Handlers) InitSigningRoutes(router *gin.Engine) {
+	router.GET("/api/download/:id/checksum", h.handleArtifactChecksum)
+	router.GET("/api/download/:id/signature", h.handleArtifactSignature)
+	router.GET("/api/public-key", h.handlePublicKey)
+	router.GET("/api/verify-script", h.handleVerifyScript)
+}
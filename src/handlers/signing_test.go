@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signingTestRouter 按当前配置加载签名密钥，模拟一个离线镜像下载任务，响应体为payload
+func signingTestRouter(t *testing.T, payload []byte) *gin.Engine {
+	t.Helper()
+	h := newTestHandlers()
+	if err := h.Signing.Load(); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/job", func(c *gin.Context) {
+		release, ok := h.acquireTarJob(c, []string{"nginx:latest"}, "")
+		if !ok {
+			return
+		}
+		defer release()
+		finish := h.trackArtifactDigest(c, []string{"nginx:latest"})
+		c.Writer.Write(payload)
+		finish(nil)
+	})
+	h.InitSigningRoutes(router)
+	return router
+}
+
+func writeSigningKey(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestArtifactSignatureRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, fmt.Sprintf("[signing]\nenabled = true\nkeyFile = %q\n", writeSigningKey(t)))
+
+	payload := []byte("fake image tar contents")
+	router := signingTestRouter(t, payload)
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/job")
+	jobID := w.Header().Get("X-Job-ID")
+	if w.Code != http.StatusOK || jobID == "" {
+		t.Fatalf("job status %d, id %q", w.Code, jobID)
+	}
+
+	w = get("/api/download/" + jobID + "/signature")
+	if w.Code != http.StatusOK {
+		t.Fatalf("signature status %d: %s", w.Code, w.Body.String())
+	}
+	signatureJSON := w.Body.Bytes()
+	var body struct {
+		SHA256    string `json:"sha256"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(signatureJSON, &body); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(payload)
+	if body.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("sha256 = %s, want digest of streamed artifact", body.SHA256)
+	}
+
+	w = get("/api/public-key")
+	block, _ := pem.Decode(w.Body.Bytes())
+	if w.Code != http.StatusOK || block == nil {
+		t.Fatalf("public key status %d: %s", w.Code, w.Body.String())
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(body.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(parsed.(ed25519.PublicKey), []byte(body.SHA256), signature) {
+		t.Fatal("signature does not verify with published key")
+	}
+	publicKeyPEM := w.Body.Bytes()
+
+	if w := get("/api/download/unknown/signature"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown job status = %d", w.Code)
+	}
+
+	// 使用下发的校验脚本离线验证
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not available")
+	}
+	w = get("/api/verify-script")
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify script status = %d", w.Code)
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"verify.sh":     w.Body.Bytes(),
+		"image.tar":     payload,
+		"tampered.tar":  append([]byte("x"), payload...),
+		"image.sig":     signatureJSON,
+		"image.sig.b64": []byte(body.Signature + "\n"),
+		"public.pem":    publicKeyPEM,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sig := range []string{"image.sig", "image.sig.b64"} {
+		if out, err := exec.Command("sh", filepath.Join(dir, "verify.sh"), filepath.Join(dir, "image.tar"), filepath.Join(dir, sig), filepath.Join(dir, "public.pem")).CombinedOutput(); err != nil {
+			t.Fatalf("verify %s failed: %v\n%s", sig, err, out)
+		}
+	}
+	if out, err := exec.Command("sh", filepath.Join(dir, "verify.sh"), filepath.Join(dir, "tampered.tar"), filepath.Join(dir, "image.sig"), filepath.Join(dir, "public.pem")).CombinedOutput(); err == nil {
+		t.Fatalf("tampered artifact verified:\n%s", out)
+	}
+}
+
+func TestArtifactSigningDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[signing]\nenabled = false\n")
+
+	router := signingTestRouter(t, []byte("data"))
+	for _, target := range []string{"/api/public-key", "/api/verify-script", "/api/download/x/signature"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s status = %d, want 404", target, w.Code)
+		}
+	}
+
+	// 校验和不依赖签名配置
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/job", nil))
+	jobID := w.Header().Get("X-Job-ID")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/download/"+jobID+"/checksum", nil))
+	sum := sha256.Sum256([]byte("data"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), hex.EncodeToString(sum[:])) {
+		t.Fatalf("checksum = %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// admitTarSize 排队前按manifest估算镜像包大小，超出tar类别上限时返回413；
+// 通过时把上限写入options，实际传输超出时中断下载。无法估算时交给下载过程判断
+func (h *Handlers) admitTarSize(c *gin.Context, images []string, options *StreamOptions) bool {
+	cfg := h.Config.Get()
+	limit := dockerproxy.ImageSizeLimit(cfg, c, utils.SizeClassTar, images...)
+	options.MaxBytes = limit
+	if limit <= 0 {
+		return true
+	}
+
+	var total int64
+	for _, image := range images {
+		size, err := h.streamer.EstimateImageSize(c.Request.Context(), image, options)
+		if err != nil {
+			fmt.Printf("估算镜像 %s 大小失败: %v\n", image, err)
+			return true
+		}
+		total += size
+	}
+	if total > limit {
+		utils.RespondError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, utils.SizeLimitMB(limit))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushRandomImage 向测试Registry推送随机镜像，返回镜像名（不含tag）
+func pushRandomImage(t testing.TB) (string, *httptest.Server) {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+
+	image, err := random.Image(2048, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	return imageRef, server
+}
+
+func TestTarSizeAdmission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+	imageRef, _ := pushRandomImage(t)
+
+	admit := func() (*httptest.ResponseRecorder, *StreamOptions, bool) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/image/download/x", nil)
+		options := &StreamOptions{UseCompressedLayers: true}
+		return w, options, h.admitTarSize(c, []string{imageRef + ":v1"}, options)
+	}
+
+	if _, options, ok := admit(); !ok || options.MaxBytes != 0 {
+		t.Fatalf("tar unlimited by default: ok %v, max %d", ok, options.MaxBytes)
+	}
+
+	loadTestConfig(t, "[limits]\ntar = 1024\n")
+	h.Config.Set(config.GetConfig())
+	w, _, ok := admit()
+	if ok || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized tar admitted: %d %s", w.Code, w.Body.String())
+	}
+
+	loadTestConfig(t, fmt.Sprintf("[limits]\ntar = 1024\n\n[limits.overrides]\n\"%s\" = 1048576\n", imageRef))
+	h.Config.Set(config.GetConfig())
+	if _, options, ok := admit(); !ok || options.MaxBytes != 1048576 {
+		t.Fatalf("override not applied: ok %v, max %d", ok, options.MaxBytes)
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamHostFor 返回请求实际访问的上游主机，无法确定时返回空
+func (h *Handlers) upstreamHostFor(c *gin.Context, class string) string {
+	switch class {
+	case ActivityClassDocker:
+		if domain, exists := c.Get("target_registry_domain"); exists {
+			return domain.(string)
+		}
+		if h.Docker != nil {
+			return h.Docker.Registry().RegistryStr()
+		}
+	case ActivityClassAuth:
+		if domain, exists := c.Get("target_registry_domain"); exists {
+			if mapping, found := h.Docker.RegistryMapping(domain.(string)); found {
+				return mapping.AuthHost
+			}
+		}
+		return "auth.docker.io"
+	case ActivityClassSearch:
+		return "registry.hub.docker.com"
+	case ActivityClassGitHub:
+		target, _, err := githubproxy.NormalizeRequestURI(c.Request.URL.RequestURI())
+		if err != nil {
+			return ""
+		}
+		if parsed, err := url.Parse(target); err == nil {
+			return parsed.Hostname()
+		}
+	}
+	return ""
+}
+
+// handleStats 返回各路由类别和上游主机的耗时、吞吐量分位数
+func (h *Handlers) handleStats(c *gin.Context) {
+	var window time.Duration
+	if value := c.Query("window"); value != "" && value != "all" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > utils.StatsMaxWindow {
+			utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "window参数无效，最大为"+utils.StatsMaxWindow.String())
+			return
+		}
+		window = parsed
+	}
+
+	c.JSON(http.StatusOK, h.StatsSnapshot(window))
+}
+
+// handleMetrics 以Prometheus文本格式输出按路由类别和缓存结果统计的流量计数、token获取方式计数、上游重定向次数、内存缓冲占用、缓存重新验证、配置重载结果、上游出站预算、上游阶段耗时和共享网络限流计数
+func (h *Handlers) handleMetrics(c *gin.Context) {
+	traffic := h.Stats.TrafficSnapshot()
+	routes := make([]string, 0, len(traffic))
+	for route := range traffic {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(utils.TrafficStats) uint64
+	}{
+		{"hubproxy_requests_total", "已完成的请求数", func(s utils.TrafficStats) uint64 { return s.Requests }},
+		{"hubproxy_downstream_bytes_total", "返回给客户端的字节数", func(s utils.TrafficStats) uint64 { return s.DownstreamBytes }},
+		{"hubproxy_upstream_bytes_total", "从上游读取的字节数", func(s utils.TrafficStats) uint64 { return s.UpstreamBytes }},
+	}
+
+	var b strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, route := range routes {
+			outcomes := make([]string, 0, len(traffic[route]))
+			for outcome := range traffic[route] {
+				outcomes = append(outcomes, outcome)
+			}
+			sort.Strings(outcomes)
+			for _, outcome := range outcomes {
+				fmt.Fprintf(&b, "%s{route=%q,cache=%q} %d\n", metric.name, route, outcome, metric.value(traffic[route][outcome]))
+			}
+		}
+	}
+
+	tokens := h.Stats.TokenSnapshot()
+	b.WriteString("# HELP hubproxy_token_fetches_total 从上游获取认证token的次数\n# TYPE hubproxy_token_fetches_total counter\n")
+	fmt.Fprintf(&b, "hubproxy_token_fetches_total{mode=%q} %d\n", utils.TokenFetchSync, tokens.SyncFetches)
+	fmt.Fprintf(&b, "hubproxy_token_fetches_total{mode=%q} %d\n", utils.TokenFetchRefreshAhead, tokens.RefreshAhead)
+	fmt.Fprintf(&b, "hubproxy_token_fetches_total{mode=%q} %d\n", utils.TokenFetchPrefetch, tokens.Prefetches)
+	fmt.Fprintf(&b, "# HELP hubproxy_token_refresh_failures_total 后台刷新token失败的次数\n# TYPE hubproxy_token_refresh_failures_total counter\nhubproxy_token_refresh_failures_total %d\n", tokens.RefreshFailed)
+	fmt.Fprintf(&b, "# HELP hubproxy_token_refresh_saves_total 命中后台刷新的token、免于同步获取的次数\n# TYPE hubproxy_token_refresh_saves_total counter\nhubproxy_token_refresh_saves_total %d\n", tokens.RefreshedSaves)
+
+	redirects := h.Stats.RedirectSnapshot()
+	hosts := make([]string, 0, len(redirects))
+	for host := range redirects {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	b.WriteString("# HELP hubproxy_upstream_redirects_total 上游返回并被跟随的重定向次数\n# TYPE hubproxy_upstream_redirects_total counter\n")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "hubproxy_upstream_redirects_total{host=%q} %d\n", host, redirects[host])
+	}
+
+	fmt.Fprintf(&b, "# HELP hubproxy_ratelimit_adaptive_factor 自适应限流作用于普通IP限额的当前倍数\n# TYPE hubproxy_ratelimit_adaptive_factor gauge\nhubproxy_ratelimit_adaptive_factor %g\n", h.Adaptive.Factor())
+	if adaptive := h.Adaptive.Snapshot(); adaptive != nil {
+		fmt.Fprintf(&b, "# HELP hubproxy_egress_load_mbps 自适应限流窗口内的平均出站流量(MB/s)\n# TYPE hubproxy_egress_load_mbps gauge\nhubproxy_egress_load_mbps %g\n", adaptive.LoadMBps)
+	}
+
+	if shared := h.Limiter.SharedNetworkStats(); len(shared) > 0 {
+		networks := make([]string, 0, len(shared))
+		for network := range shared {
+			networks = append(networks, network)
+		}
+		sort.Strings(networks)
+		b.WriteString("# HELP hubproxy_ratelimit_shared_requests_total 共享网络按共享桶(pooled)或用户子key(user)计数的请求数\n# TYPE hubproxy_ratelimit_shared_requests_total counter\n")
+		for _, network := range networks {
+			fmt.Fprintf(&b, "hubproxy_ratelimit_shared_requests_total{network=%q,bucket=\"pooled\"} %d\n", network, shared[network].Pooled)
+			fmt.Fprintf(&b, "hubproxy_ratelimit_shared_requests_total{network=%q,bucket=\"user\"} %d\n", network, shared[network].Users)
+		}
+		b.WriteString("# HELP hubproxy_ratelimit_shared_limited_total 共享网络被限流的请求数\n# TYPE hubproxy_ratelimit_shared_limited_total counter\n")
+		for _, network := range networks {
+			fmt.Fprintf(&b, "hubproxy_ratelimit_shared_limited_total{network=%q} %d\n", network, shared[network].Limited)
+		}
+		b.WriteString("# HELP hubproxy_ratelimit_shared_spoofed_total 转发链不可信、声称来自共享网络而按普通IP限流的请求数\n# TYPE hubproxy_ratelimit_shared_spoofed_total counter\n")
+		for _, network := range networks {
+			fmt.Fprintf(&b, "hubproxy_ratelimit_shared_spoofed_total{network=%q} %d\n", network, shared[network].Spoofed)
+		}
+	}
+
+	memory := h.Memory.Stats()
+	fmt.Fprintf(&b, "# HELP hubproxy_memory_buffered_bytes 在途缓冲和热点缓存估算占用的字节数\n# TYPE hubproxy_memory_buffered_bytes gauge\nhubproxy_memory_buffered_bytes %d\n", memory.Buffered+memory.HotCache)
+	fmt.Fprintf(&b, "# HELP hubproxy_memory_ceiling_bytes 允许缓冲的字节数上限，0表示未设置内存上限\n# TYPE hubproxy_memory_ceiling_bytes gauge\nhubproxy_memory_ceiling_bytes %d\n", memory.Ceiling)
+	fmt.Fprintf(&b, "# HELP hubproxy_memory_degraded_total 因内存紧张退化为流式转发或排队的操作数\n# TYPE hubproxy_memory_degraded_total counter\nhubproxy_memory_degraded_total %d\n", memory.Degraded)
+
+	cacheStats := h.Cache.Stats()
+	categories := make([]string, 0, len(cacheStats))
+	for category := range cacheStats {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	b.WriteString("# HELP hubproxy_cache_revalidations_total 缓存过期后经条件请求确认未变化(304)而续期的次数\n# TYPE hubproxy_cache_revalidations_total counter\n")
+	for _, category := range categories {
+		fmt.Fprintf(&b, "hubproxy_cache_revalidations_total{category=%q} %d\n", category, cacheStats[category].Revalidated)
+	}
+	b.WriteString("# HELP hubproxy_cache_revalidation_saved_bytes_total 重新验证免于从上游重新传输的字节数\n# TYPE hubproxy_cache_revalidation_saved_bytes_total counter\n")
+	for _, category := range categories {
+		fmt.Fprintf(&b, "hubproxy_cache_revalidation_saved_bytes_total{category=%q} %d\n", category, cacheStats[category].RevalidationSavedBytes)
+	}
+
+	reload := h.Reloader.LastReload()
+	b.WriteString("# HELP hubproxy_config_reloads_total 配置重载次数，failure为校验失败而保留原配置的次数\n# TYPE hubproxy_config_reloads_total counter\n")
+	fmt.Fprintf(&b, "hubproxy_config_reloads_total{result=\"success\"} %d\nhubproxy_config_reloads_total{result=\"failure\"} %d\n", reload.Succeeded, reload.Failed)
+	lastSuccess := 1
+	if !reload.At.IsZero() && !reload.OK {
+		lastSuccess = 0
+	}
+	fmt.Fprintf(&b, "# HELP hubproxy_config_last_reload_success 最近一次配置重载是否成功，未重载过时为1\n# TYPE hubproxy_config_last_reload_success gauge\nhubproxy_config_last_reload_success %d\n", lastSuccess)
+
+	budgets := h.Clients.UpstreamBudgetSnapshot()
+	budgetMetrics := []struct {
+		name   string
+		help   string
+		kind   string
+		format func(utils.UpstreamBudgetStats) string
+	}{
+		{"hubproxy_upstream_budget_utilization", "上游出站预算利用率(0-1)，取速率令牌和并发名额中较高者", "gauge",
+			func(s utils.UpstreamBudgetStats) string { return fmt.Sprintf("%g", s.Utilization) }},
+		{"hubproxy_upstream_budget_rate", "当前生效的每秒请求数上限，冷却期内降低，0表示不限制", "gauge",
+			func(s utils.UpstreamBudgetStats) string { return fmt.Sprintf("%g", s.RequestsPerSecond) }},
+		{"hubproxy_upstream_in_flight", "正在进行的上游请求数", "gauge",
+			func(s utils.UpstreamBudgetStats) string { return fmt.Sprintf("%d", s.InFlight) }},
+		{"hubproxy_upstream_budget_rejected_total", "超出出站预算被拒绝的上游请求数", "counter",
+			func(s utils.UpstreamBudgetStats) string { return fmt.Sprintf("%d", s.Rejected) }},
+		{"hubproxy_upstream_throttled_total", "上游返回限流响应的次数", "counter",
+			func(s utils.UpstreamBudgetStats) string { return fmt.Sprintf("%d", s.Throttled) }},
+	}
+	for _, metric := range budgetMetrics {
+		if len(budgets) == 0 {
+			break
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, budget := range budgets {
+			fmt.Fprintf(&b, "%s{host=%q} %s\n", metric.name, budget.Host, metric.format(budget))
+		}
+	}
+
+	b.WriteString("# HELP hubproxy_upstream_phase_seconds 上游请求各阶段耗时，dns、connect、tls、ttfb按每次上游请求记录，transfer按客户端请求记录，启用慢请求日志时统计\n# TYPE hubproxy_upstream_phase_seconds histogram\n")
+	for _, h := range utils.UpstreamPhaseHistograms() {
+		for i, bound := range h.Bounds {
+			fmt.Fprintf(&b, "hubproxy_upstream_phase_seconds_bucket{phase=%q,le=\"%g\"} %d\n", h.Phase, bound, h.Buckets[i])
+		}
+		fmt.Fprintf(&b, "hubproxy_upstream_phase_seconds_bucket{phase=%q,le=\"+Inf\"} %d\n", h.Phase, h.Count)
+		fmt.Fprintf(&b, "hubproxy_upstream_phase_seconds_sum{phase=%q} %g\nhubproxy_upstream_phase_seconds_count{phase=%q} %d\n", h.Phase, h.Sum, h.Phase, h.Count)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// InitStatsRoutes 注册统计路由
+func (h *Handlers) InitStatsRoutes(router *gin.Engine) {
+	router.GET("/api/stats", utils.APITimeoutMiddleware(h.Config, utils.APITimeoutStats), h.handleStats)
+}
+
+// InitMetricsRoutes 注册Prometheus指标路由，配置了admin.listen时只挂载在管理端口
+func (h *Handlers) InitMetricsRoutes(router *gin.Engine) {
+	router.GET("/metrics", utils.APITimeoutMiddleware(h.Config, utils.APITimeoutStats), h.handleMetrics)
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// TarJob 离线镜像下载任务
+type TarJob struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	Images    []string  `json:"images"`
+	Platform  string    `json:"platform"`
+	Queued    bool      `json:"queued"`
+	CreatedAt time.Time `json:"created_at"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	Bytes     int64     `json:"bytes"`
+
+	done chan struct{}
+}
+
+// JobLimitError 任务并发超限错误
+type JobLimitError struct {
+	Reason      string
+	QueueLength int
+	RetryAfter  int
+}
+
+func (e *JobLimitError) Error() string {
+	return e.Reason
+}
+
+type jobWaiter struct {
+	job   *TarJob
+	ready chan struct{}
+}
+
+// TarJobLimiter 离线镜像下载任务并发控制器
+type TarJobLimiter struct {
+	config *config.Store
+	memory *utils.MemoryGuard
+
+	mu     sync.Mutex
+	active map[string]*TarJob
+	perIP  map[string]int
+	queue  []*jobWaiter
+}
+
+// NewTarJobLimiter 创建任务并发控制器，并发上限取自store的当前配置，memory内存紧张时新任务进入队列
+func NewTarJobLimiter(store *config.Store, memory *utils.MemoryGuard) *TarJobLimiter {
+	return &TarJobLimiter{
+		config: store,
+		memory: memory,
+		active: make(map[string]*TarJob),
+		perIP:  make(map[string]int),
+	}
+}
+
+// jobRetryAfterSeconds 任务超限时建议的重试间隔
+const jobRetryAfterSeconds = 30
+
+// memoryRecheckInterval 因内存紧张排队的任务重新检查内存占用的间隔
+var memoryRecheckInterval = time.Second
+
+// tarJobIDKey 请求上下文中记录当前下载任务ID的key
+const tarJobIDKey = "tar_job_id"
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Acquire 申请任务槽位，全局已满或内存紧张时进入FIFO队列等待，返回的release必须调用
+func (l *TarJobLimiter) Acquire(ctx context.Context, job *TarJob) (func(), error) {
+	cfg := l.config.Get()
+	maxJobs := cfg.Download.MaxConcurrentJobs
+	maxPerIP := cfg.Download.MaxJobsPerIP
+	queueSize := cfg.Download.QueueSize
+
+	if job.ID == "" {
+		job.ID = newJobID()
+	}
+	job.CreatedAt = time.Now()
+	job.done = make(chan struct{})
+
+	l.mu.Lock()
+	if maxPerIP > 0 && l.perIP[job.IP] >= maxPerIP {
+		l.mu.Unlock()
+		return nil, &JobLimitError{
+			Reason:     fmt.Sprintf("单个IP最多同时进行 %d 个下载任务", maxPerIP),
+			RetryAfter: jobRetryAfterSeconds,
+		}
+	}
+
+	underPressure := l.memory.UnderPressure()
+	if !underPressure && (maxJobs <= 0 || (len(l.active) < maxJobs && len(l.queue) == 0)) {
+		job.StartedAt = job.CreatedAt
+		l.active[job.ID] = job
+		l.perIP[job.IP]++
+		l.mu.Unlock()
+		return l.releaseFunc(job), nil
+	}
+
+	if len(l.queue) >= queueSize {
+		queueLength := len(l.queue)
+		l.mu.Unlock()
+		return nil, &JobLimitError{
+			Reason:      "下载任务过多，请稍后再试",
+			QueueLength: queueLength,
+			RetryAfter:  jobRetryAfterSeconds,
+		}
+	}
+
+	if underPressure {
+		l.memory.RecordDegraded()
+	}
+	job.Queued = true
+	waiter := &jobWaiter{job: job, ready: make(chan struct{})}
+	l.queue = append(l.queue, waiter)
+	l.perIP[job.IP]++
+	l.mu.Unlock()
+
+	// 只有任务结束时才会唤醒队列，内存占用回落后需要定期检查，否则没有运行中任务时队列不会前进
+	recheck := time.NewTicker(memoryRecheckInterval)
+	defer recheck.Stop()
+	for {
+		select {
+		case <-waiter.ready:
+			return l.releaseFunc(job), nil
+		case <-recheck.C:
+			l.mu.Lock()
+			l.promoteLocked()
+			l.mu.Unlock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			for i, w := range l.queue {
+				if w == waiter {
+					l.queue = append(l.queue[:i], l.queue[i+1:]...)
+					l.decrementIPLocked(job.IP)
+					l.mu.Unlock()
+					return nil, ctx.Err()
+				}
+			}
+			l.mu.Unlock()
+			// 取消与出队同时发生，槽位已分配，需要归还
+			l.release(job)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *TarJobLimiter) releaseFunc(job *TarJob) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(job) })
+	}
+}
+
+// release 归还槽位并唤醒队首等待的任务
+func (l *TarJobLimiter) release(job *TarJob) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.active, job.ID)
+	l.decrementIPLocked(job.IP)
+	l.promoteLocked()
+	close(job.done)
+}
+
+// promoteLocked 在有空闲槽位且内存不紧张时按FIFO唤醒排队的任务
+func (l *TarJobLimiter) promoteLocked() {
+	cfg := l.config.Get()
+	maxJobs := cfg.Download.MaxConcurrentJobs
+	for len(l.queue) > 0 && (maxJobs <= 0 || len(l.active) < maxJobs) && !l.memory.UnderPressure() {
+		next := l.queue[0]
+		l.queue = l.queue[1:]
+		next.job.Queued = false
+		next.job.StartedAt = time.Now()
+		l.active[next.job.ID] = next.job
+		close(next.ready)
+	}
+}
+
+func (l *TarJobLimiter) decrementIPLocked(ip string) {
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+// findLocked 按ID查找运行中或排队中的任务
+func (l *TarJobLimiter) findLocked(id string) *TarJob {
+	if job, exists := l.active[id]; exists {
+		return job
+	}
+	for _, w := range l.queue {
+		if w.job.ID == id {
+			return w.job
+		}
+	}
+	return nil
+}
+
+// addBytes 累计任务已输出的字节数
+func (l *TarJobLimiter) addBytes(id string, n int64) {
+	l.mu.Lock()
+	if job := l.findLocked(id); job != nil {
+		job.Bytes += n
+	}
+	l.mu.Unlock()
+}
+
+// Wait 等待任务结束，最多等待timeout，返回任务快照及是否已结束；任务不存在时ok为false
+func (l *TarJobLimiter) Wait(ctx context.Context, id string, timeout time.Duration) (snapshot TarJob, finished, ok bool) {
+	l.mu.Lock()
+	job := l.findLocked(id)
+	l.mu.Unlock()
+	if job == nil {
+		return TarJob{}, false, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-job.done:
+		finished = true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return *job, finished, true
+}
+
+// Snapshot 返回当前运行中和排队中的任务
+func (l *TarJobLimiter) Snapshot() (active []TarJob, queued []TarJob) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active = make([]TarJob, 0, len(l.active))
+	for _, job := range l.active {
+		active = append(active, *job)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].StartedAt.Before(active[j].StartedAt)
+	})
+
+	queued = make([]TarJob, 0, len(l.queue))
+	for _, w := range l.queue {
+		queued = append(queued, *w.job)
+	}
+	return active, queued
+}
+
+// acquireTarJob 为下载请求申请任务槽位，失败时已写入响应
+func (h *Handlers) acquireTarJob(c *gin.Context, images []string, platform string) (func(), bool) {
+	if !h.requireHeavySchedule(c) {
+		return nil, false
+	}
+	ip, _ := h.getClientIdentity(c)
+	job := &TarJob{
+		IP:       ip,
+		Images:   images,
+		Platform: platform,
+	}
+	release, err := h.tarJobs.Acquire(c.Request.Context(), job)
+	if err != nil {
+		if limitErr, ok := err.(*JobLimitError); ok {
+			utils.SetRetryAfter(c, time.Duration(limitErr.RetryAfter)*time.Second)
+			utils.RespondErrorFields(c, http.StatusTooManyRequests, utils.ErrCodeJobLimitExceeded, limitErr.Reason, gin.H{
+				"queue_length": limitErr.QueueLength,
+			})
+		}
+		return nil, false
+	}
+	c.Set(tarJobIDKey, job.ID)
+	c.Header("X-Job-ID", job.ID)
+	return release, true
+}
+
+// tarJobProgressWriter 累计下载任务已输出到客户端的字节数
+type tarJobProgressWriter struct {
+	io.Writer
+	jobs *TarJobLimiter
+	id   string
+}
+
+func (w *tarJobProgressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.jobs.addBytes(w.id, int64(n))
+	return n, err
+}
+
+// writer 为下载任务统计输出字节数，没有任务表或任务ID时原样返回
+func (l *TarJobLimiter) writer(w io.Writer, id string) io.Writer {
+	if l == nil || id == "" {
+		return w
+	}
+	return &tarJobProgressWriter{Writer: w, jobs: l, id: id}
+}
+
+// maxJobWait 任务状态接口wait参数的上限
+const maxJobWait = 60 * time.Second
+
+// parseJobWait 解析任务状态接口的wait参数(如 30s)，超过上限时按上限处理，格式错误时已返回400
+func parseJobWait(c *gin.Context) (time.Duration, bool) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, true
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		utils.RespondErrorMessage(c, http.StatusBadRequest, utils.ErrCodeInvalidParameter, "wait参数应为时长，如 30s")
+		return 0, false
+	}
+	return min(wait, maxJobWait), true
+}
+
+// handleTarJobStatus 查询下载任务的进度，?wait=30s 时等待任务结束或超时后返回，
+// 供不便处理SSE的客户端轮询；任务ID由下载响应的X-Job-ID头给出，结果不含客户端IP
+func (h *Handlers) handleTarJobStatus(c *gin.Context) {
+	wait, ok := parseJobWait(c)
+	if !ok {
+		return
+	}
+	job, finished, exists := h.tarJobs.Wait(c.Request.Context(), c.Param("id"), wait)
+	if !exists {
+		utils.RespondErrorMessage(c, http.StatusNotFound, utils.ErrCodeJobNotFound, "任务不存在或已结束")
+		return
+	}
+	status := "running"
+	switch {
+	case finished:
+		status = "finished"
+	case job.Queued:
+		status = "queued"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":         job.ID,
+		"status":     status,
+		"images":     job.Images,
+		"platform":   job.Platform,
+		"bytes":      job.Bytes,
+		"created_at": job.CreatedAt,
+		"started_at": job.StartedAt,
+	})
+}
+
+// handleListTarJobs 查看当前下载任务表
+func (h *Handlers) handleListTarJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, h.tarJobsSummary())
+}
+
+// tarJobsSummary 返回进行中和排队的下载任务及任务数上限
+func (h *Handlers) tarJobsSummary() gin.H {
+	cfg := h.Config.Get()
+	active, queued := h.tarJobs.Snapshot()
+	return gin.H{
+		"active":              active,
+		"queued":              queued,
+		"max_concurrent_jobs": cfg.Download.MaxConcurrentJobs,
+		"max_jobs_per_ip":     cfg.Download.MaxJobsPerIP,
+		"queue_size":          cfg.Download.QueueSize,
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func loadTestConfig(t testing.TB, body string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStore 返回持有当前全局配置的store，供测试构造组件
+func testStore() *config.Store {
+	return config.NewStore(config.GetConfig())
+}
+
+// newTestHandlers 按当前配置创建使用独立缓存、上游客户端和代理的处理器
+func newTestHandlers() *Handlers {
+	shared := utils.NewComponents(config.GetConfig())
+	cache, hot, access, clients := utils.NewUniversalCache(shared.Background.Context(), shared.Config), utils.NewHotCache(shared.Config), accesscontrol.New(shared.Config), utils.NewHTTPClients(shared.Config, shared.Stats)
+	docker, err := dockerproxy.New(cache, hot, access, clients, shared)
+	if err != nil {
+		panic(err)
+	}
+	limiter := ratelimit.New(shared.Config, shared.Adaptive, shared.IPs, shared.Stats)
+	reloader := config.NewReloader(shared.Config)
+	h := New(Deps{
+		Components: shared,
+		Cache:      cache,
+		Hot:        hot,
+		Access:     access,
+		Clients:    clients,
+		Limiter:    limiter,
+		Docker:     docker,
+		GitHub:     githubproxy.New(cache, hot, access, clients, shared),
+		Reloader:   reloader,
+	})
+	docker.AuthToken = h.HandleAuthToken
+	reloader.Register(docker, limiter)
+	reloader.Register(h.Reloadables()...)
+	return h
+}
+
+func TestTarJobLimiterPerIP(t *testing.T) {
+	loadTestConfig(t, `
+[download]
+maxConcurrentJobs = 10
+maxJobsPerIP = 1
+`)
+	store := testStore()
+	limiter := NewTarJobLimiter(store, utils.NewMemoryGuard(store))
+
+	release, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limitErr *JobLimitError
+	if _, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"}); !errors.As(err, &limitErr) {
+		t.Fatalf("second job for same IP err = %v, want JobLimitError", err)
+	}
+	if other, err := limiter.Acquire(context.Background(), &TarJob{IP: "2.2.2.2"}); err != nil {
+		t.Fatalf("other IP rejected: %v", err)
+	} else {
+		other()
+	}
+
+	release()
+	release()
+	if again, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"}); err != nil {
+		t.Fatalf("slot not returned after release: %v", err)
+	} else {
+		again()
+	}
+}
+
+func TestTarJobLimiterQueue(t *testing.T) {
+	loadTestConfig(t, `
+[download]
+maxConcurrentJobs = 1
+maxJobsPerIP = 0
+queueSize = 1
+`)
+	store := testStore()
+	limiter := NewTarJobLimiter(store, utils.NewMemoryGuard(store))
+
+	release, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		next, err := limiter.Acquire(context.Background(), &TarJob{IP: "2.2.2.2"})
+		if err != nil {
+			t.Errorf("queued job failed: %v", err)
+			return
+		}
+		acquired <- next
+	}()
+
+	waitFor(t, func() bool {
+		_, queued := limiter.Snapshot()
+		return len(queued) == 1
+	})
+
+	var limitErr *JobLimitError
+	if _, err := limiter.Acquire(context.Background(), &TarJob{IP: "3.3.3.3"}); !errors.As(err, &limitErr) {
+		t.Fatalf("job beyond queue err = %v, want JobLimitError", err)
+	}
+
+	release()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("queued job not started after release")
+	}
+
+	active, queued := limiter.Snapshot()
+	if len(active) != 0 || len(queued) != 0 {
+		t.Fatalf("jobs left behind: active=%d queued=%d", len(active), len(queued))
+	}
+}
+
+func TestTarJobLimiterCancelWhileQueued(t *testing.T) {
+	loadTestConfig(t, `
+[download]
+maxConcurrentJobs = 1
+maxJobsPerIP = 1
+queueSize = 5
+`)
+	store := testStore()
+	limiter := NewTarJobLimiter(store, utils.NewMemoryGuard(store))
+
+	release, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := limiter.Acquire(ctx, &TarJob{IP: "2.2.2.2"})
+		done <- err
+	}()
+
+	waitFor(t, func() bool {
+		_, queued := limiter.Snapshot()
+		return len(queued) == 1
+	})
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled wait err = %v", err)
+	}
+	if _, queued := limiter.Snapshot(); len(queued) != 0 {
+		t.Fatalf("cancelled waiter still queued: %d", len(queued))
+	}
+	limiter.mu.Lock()
+	count := limiter.perIP["2.2.2.2"]
+	limiter.mu.Unlock()
+	if count != 0 {
+		t.Fatalf("per-IP count not released after cancel: %d", count)
+	}
+}
+
+func TestTarJobLimiterQueuesUnderMemoryPressure(t *testing.T) {
+	loadTestConfig(t, `
+[memory]
+limitBytes = 4194304
+bufferRatio = 0.5
+
+[download]
+maxConcurrentJobs = 5
+queueSize = 5
+`)
+	h := newTestHandlers()
+	saved := memoryRecheckInterval
+	memoryRecheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { memoryRecheckInterval = saved })
+	h.Hot.Flush("")
+	limiter := NewTarJobLimiter(h.Config, h.Memory)
+
+	hold, ok := h.Memory.Reserve(2 << 20)
+	if !ok {
+		t.Fatal("reservation up to the ceiling was rejected")
+	}
+	defer hold()
+	degraded := h.Memory.Stats().Degraded
+
+	done := make(chan error, 1)
+	go func() {
+		release, err := limiter.Acquire(context.Background(), &TarJob{IP: "1.1.1.1"})
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	waitFor(t, func() bool {
+		_, queued := limiter.Snapshot()
+		return len(queued) == 1
+	})
+	if got := h.Memory.Stats().Degraded; got != degraded+1 {
+		t.Fatalf("degraded = %d, want %d", got, degraded+1)
+	}
+
+	// 没有运行中的任务，内存回落后队列仍应前进
+	hold()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued job not started after memory pressure eased")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestTarJobRejectedOutsideSchedule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 允许时段为两小时后开始的一小时
+	start := time.Now().UTC().Add(2 * time.Hour)
+	loadTestConfig(t, fmt.Sprintf(`
+[schedule]
+enabled = true
+timezone = "UTC"
+quietHours = ["%s-%s"]
+`, start.Format("15:04"), start.Add(time.Hour).Format("15:04")))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/image/download/nginx", nil)
+	if _, ok := newTestHandlers().acquireTarJob(c, []string{"nginx"}, ""); ok {
+		t.Fatal("tar job allowed outside schedule")
+	}
+
+	var body struct {
+		Code          string    `json:"code"`
+		NextAllowedAt time.Time `json:"next_allowed_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTooManyRequests || body.Code != utils.ErrCodeOutsideSchedule || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if want := start.Truncate(time.Minute); !body.NextAllowedAt.Equal(want) {
+		t.Fatalf("next_allowed_at = %s, want %s", body.NextAllowedAt, want)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"time"
+)
+
+// tarKeepaliveRecords 保活条目的PAX记录，comment为标准字段，解包时没有任何效果
+var tarKeepaliveRecords = map[string]string{"comment": "hubproxy keepalive"}
+
+// tarKeepalive 在等待上游(manifest、配置、层的响应)时按download.keepaliveInterval向tar流写入PAX全局扩展头，
+// 并刷新压缩层和HTTP响应。docker load、ctr import和tar解包都会忽略全局扩展头，
+// curl等客户端则能持续收到数据，不会在首个镜像层返回前误以为代理已无响应
+type tarKeepalive struct {
+	tw       *tar.Writer
+	writers  []io.Writer
+	interval time.Duration
+	started  bool
+}
+
+// newTarKeepalive 创建每隔interval写入保活条目的写入器，writers为需要逐层刷新的压缩写入器和响应
+func newTarKeepalive(interval time.Duration, tw *tar.Writer, writers ...io.Writer) *tarKeepalive {
+	return &tarKeepalive{
+		tw:       tw,
+		writers:  writers,
+		interval: interval,
+	}
+}
+
+// start manifest解析完成后立即写入第一个保活条目，之后的等待才会继续写入
+func (k *tarKeepalive) start() error {
+	if k == nil || k.interval <= 0 || k.started {
+		return nil
+	}
+	k.started = true
+	return k.emit()
+}
+
+// emit 写入一个保活条目并刷新到客户端
+func (k *tarKeepalive) emit() error {
+	err := k.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: tarKeepaliveRecords})
+	if err != nil {
+		return err
+	}
+	return k.flush()
+}
+
+// flush 把已写入的条目刷新到客户端，写完配置等小条目后调用，避免停留在压缩和响应缓冲区中
+func (k *tarKeepalive) flush() error {
+	if k == nil || !k.started {
+		return nil
+	}
+	for _, w := range k.writers {
+		switch f := w.(type) {
+		case interface{ Flush() error }:
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		case http.Flusher:
+			f.Flush()
+		}
+	}
+	return nil
+}
+
+// wait 执行fn，fn超过interval仍未完成时每隔interval写入一次保活条目。fn在单独的goroutine中执行，不能写入tar流；
+// 写入失败时等待fn结束(请求取消后fn随之返回)再返回写入错误
+func (k *tarKeepalive) wait(fn func() error) error {
+	if k == nil || k.interval <= 0 || !k.started {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if err := k.emit(); err != nil {
+				if fnErr := <-done; fnErr != nil {
+					return fnErr
+				}
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// firstWriteRecorder 记录第一次写入的时间
+type firstWriteRecorder struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	first time.Time
+}
+
+func (w *firstWriteRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.first.IsZero() && len(p) > 0 {
+		w.first = time.Now()
+	}
+	return w.buf.Write(p)
+}
+
+func TestTarStreamFirstByteWithSlowUpstream(t *testing.T) {
+	const blobDelay = 400 * time.Millisecond
+	loadTestConfig(t, "[download]\nkeepaliveInterval = \"50ms\"\n")
+	h := newTestHandlers()
+
+	// manifest立即返回，配置和层的响应都要等待blobDelay
+	upstream := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/") {
+			time.Sleep(blobDelay)
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app:v1"
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(imageRef)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		out := &firstWriteRecorder{}
+		start := time.Now()
+		err := h.streamer.StreamImageToWriter(context.Background(), imageRef, out, &StreamOptions{UseCompressedLayers: true, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttfb := out.first.Sub(start); ttfb >= blobDelay {
+			t.Fatalf("%s: first byte after %v, upstream blobs take %v", compression, ttfb, blobDelay)
+		}
+
+		var r io.Reader = &out.buf
+		if compression == CompressionGzip {
+			if r, err = gzip.NewReader(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keepalives, files := 0, map[string]bool{}
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", compression, err)
+			}
+			if header.Typeflag == tar.TypeXGlobalHeader {
+				keepalives++
+				continue
+			}
+			files[header.Name] = true
+		}
+		if keepalives < 3 || !files["manifest.json"] || !files["repositories"] || len(files) != 7 {
+			t.Fatalf("%s: %d keepalives, files %v", compression, keepalives, files)
+		}
+	}
+}
+
+func TestTarJobStatusWait(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	h := newTestHandlers()
+	job := &TarJob{IP: "1.1.1.1", Images: []string{"nginx:latest"}}
+	release, err := h.tarJobs.Acquire(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	h.tarJobs.writer(io.Discard, job.ID).Write(make([]byte, 1500))
+
+	status := func(query string) *httptest.ResponseRecorder {
+		router := gin.New()
+		h.InitImageTarRoutes(router)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/image/jobs/"+query, nil))
+		return w
+	}
+
+	if w := status(job.ID); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"running"`) ||
+		!strings.Contains(w.Body.String(), `"bytes":1500`) || strings.Contains(w.Body.String(), "1.1.1.1") {
+		t.Fatalf("status: %d %s", w.Code, w.Body.String())
+	}
+	if w := status(job.ID + "?wait=soon"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid wait: %d", w.Code)
+	}
+	if w := status("missing"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing job: %d", w.Code)
+	}
+
+	time.AfterFunc(50*time.Millisecond, release)
+	start := time.Now()
+	w := status(job.ID + "?wait=10s")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"finished"`) || time.Since(start) > 5*time.Second {
+		t.Fatalf("long poll: %d %s after %v", w.Code, w.Body.String(), time.Since(start))
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiRouteGuard 无界面模式(ui.enabled = false)下页面使用的接口按未挂载处理，返回404。
+// 每次请求时读取配置，热重载后立即生效
+func (h *Handlers) uiRouteGuard(c *gin.Context) {
+	if !h.Config.Get().UI.Enabled {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Next()
+}
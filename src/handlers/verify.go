@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// verifyCacheTTL 校验结果按URL和ETag缓存，内容不变时结果不变
+const verifyCacheTTL = 24 * time.Hour
+
+// VerifyResult 文件校验结果
+type VerifyResult struct {
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+// normalizeVerifyURL 补全协议头，http统一改为https
+func normalizeVerifyURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "https://"):
+		return raw
+	case strings.HasPrefix(raw, "http://"):
+		return "https://" + strings.TrimPrefix(raw, "http://")
+	default:
+		return "https://" + strings.TrimLeft(raw, "/")
+	}
+}
+
+// parseExpectedDigest 解析期望的摘要，支持 sha256:hex、sha512:hex 或按长度识别的裸hex
+func parseExpectedDigest(expected string) (string, string, bool) {
+	algorithm, digest, found := strings.Cut(strings.ToLower(strings.TrimSpace(expected)), ":")
+	if !found {
+		digest = algorithm
+		switch len(digest) {
+		case sha256.Size * 2:
+			algorithm = "sha256"
+		case sha512.Size * 2:
+			algorithm = "sha512"
+		default:
+			return "", "", false
+		}
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", "", false
+	}
+	switch {
+	case algorithm == "sha256" && len(digest) == sha256.Size*2:
+	case algorithm == "sha512" && len(digest) == sha512.Size*2:
+	default:
+		return "", "", false
+	}
+	return algorithm, digest, true
+}
+
+// hashVerifyBody 流式计算摘要，超过大小上限时第二个返回值为true
+func hashVerifyBody(body io.Reader, limit int64) (*VerifyResult, bool, error) {
+	sha256Hash, sha512Hash := sha256.New(), sha512.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, sha512Hash), io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if size > limit {
+		return nil, true, nil
+	}
+	return &VerifyResult{
+		Size:   size,
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		SHA512: hex.EncodeToString(sha512Hash.Sum(nil)),
+	}, false, nil
+}
+
+// handleVerify 代理端下载GitHub文件并计算摘要，只返回校验结果不返回文件内容
+func (h *Handlers) handleVerify(c *gin.Context) {
+	target := normalizeVerifyURL(c.Query("url"))
+	match := githubproxy.MatchURL(target)
+	if match == nil || c.Query("url") == "" {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidInput)
+		return
+	}
+	if allowed, reason := match.CheckAccess(h.Access); !allowed {
+		utils.RespondError(c, http.StatusForbidden, reason)
+		return
+	}
+
+	var algorithm, expected string
+	if raw := c.Query("expected"); raw != "" {
+		var ok bool
+		if algorithm, expected, ok = parseExpectedDigest(raw); !ok {
+			utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidDigest)
+			return
+		}
+	}
+
+	h.verifyRemoteFile(c, target, algorithm, expected)
+}
+
+// verifyRemoteFile 下载文件计算摘要并与期望值比较，expected为空时只返回摘要
+func (h *Handlers) verifyRemoteFile(c *gin.Context, target, algorithm, expected string) {
+	cfg := h.Config.Get()
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeInvalidInput)
+		return
+	}
+	resp, err := h.Clients.Global().Do(req)
+	if err != nil {
+		if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+			utils.RespondError(c, http.StatusServiceUnavailable, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+			return
+		}
+		utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, err)
+		return
+	}
+	defer safeCloseResponseBody(resp.Body, "校验文件响应")
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		utils.RespondError(c, http.StatusNotFound, utils.ErrCodeGitHubNotFound)
+		return
+	case resp.StatusCode != http.StatusOK:
+		utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, fmt.Sprintf("HTTP %d", resp.StatusCode))
+		return
+	}
+
+	// 内容未变化时直接返回缓存结果，不再读取响应体
+	etag := resp.Header.Get("ETag")
+	cacheKey := "verify:" + target + "@" + etag
+	var result *VerifyResult
+	if cached, ok := h.searchCache.Get(cacheKey); ok && etag != "" && utils.CacheReadAllowed(cfg, c) {
+		result = cached.(*VerifyResult)
+		utils.SetCacheOutcome(c, utils.CacheHit)
+	} else {
+		limit := githubproxy.SizeLimit(c, target, cfg)
+		if limit <= 0 {
+			limit = math.MaxInt64 - 1
+		}
+		if resp.ContentLength > limit {
+			utils.RespondError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, limit/(1024*1024))
+			return
+		}
+		var tooLarge bool
+		result, tooLarge, err = hashVerifyBody(resp.Body, limit)
+		switch {
+		case err != nil:
+			utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, err)
+			return
+		case tooLarge:
+			utils.RespondError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, limit/(1024*1024))
+			return
+		}
+		result.URL, result.ETag = target, etag
+		if etag != "" && utils.CacheWriteAllowed(cfg, c) {
+			h.searchCache.SetWithTTL(cacheKey, result, verifyCacheTTL)
+		}
+		utils.SetCacheOutcome(c, utils.CacheMiss)
+	}
+
+	if expected == "" {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	actual := result.SHA256
+	if algorithm == "sha512" {
+		actual = result.SHA512
+	}
+	status := http.StatusOK
+	if actual != expected {
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{
+		"url":       result.URL,
+		"size":      result.Size,
+		"sha256":    result.SHA256,
+		"sha512":    result.SHA512,
+		"etag":      result.ETag,
+		"algorithm": algorithm,
+		"expected":  expected,
+		"match":     actual == expected,
+	})
+}
+
+// InitVerifyRoutes 注册文件校验接口
+func (h *Handlers) InitVerifyRoutes(router *gin.Engine) {
+	router.GET("/api/verify", utils.APITimeoutMiddleware(h.Config, utils.APITimeoutVerify), h.handleVerify)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseExpectedDigest(t *testing.T) {
+	sha256Hex := strings.Repeat("ab", 32)
+	sha512Hex := strings.Repeat("cd", 64)
+	tests := []struct {
+		input     string
+		algorithm string
+		ok        bool
+	}{
+		{sha256Hex, "sha256", true},
+		{"SHA256:" + strings.ToUpper(sha256Hex), "sha256", true},
+		{sha512Hex, "sha512", true},
+		{"sha512:" + sha512Hex, "sha512", true},
+		{"sha512:" + sha256Hex, "", false},
+		{"md5:" + sha256Hex, "", false},
+		{strings.Repeat("zz", 32), "", false},
+		{"abc", "", false},
+	}
+	for _, tt := range tests {
+		algorithm, _, ok := parseExpectedDigest(tt.input)
+		if ok != tt.ok || algorithm != tt.algorithm {
+			t.Errorf("parseExpectedDigest(%q) = %q, %v", tt.input, algorithm, ok)
+		}
+	}
+}
+
+func TestVerifyRemoteFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[server]
+fileSize = 16
+`)
+	h := newTestHandlers()
+
+	const content = "release-asset"
+	sum := sha256.Sum256([]byte(content))
+	digest := hex.EncodeToString(sum[:])
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/asset":
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(content))
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 64)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	var outcome string
+	verify := func(path, algorithm, expected string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/verify", nil)
+		h.verifyRemoteFile(c, upstream.URL+path, algorithm, expected)
+		outcome = utils.CacheOutcome(c)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	w, body := verify("/asset", "", "")
+	if w.Code != http.StatusOK || body["sha256"] != digest || body["size"].(float64) != float64(len(content)) || body["etag"] != `"v1"` {
+		t.Fatalf("unexpected result: %s", w.Body.String())
+	}
+	if outcome != utils.CacheMiss {
+		t.Fatalf("first verify outcome = %s, want miss", outcome)
+	}
+
+	// 期望值匹配时返回200，不匹配时返回409
+	if w, body = verify("/asset", "sha256", digest); w.Code != http.StatusOK || body["match"] != true || outcome != utils.CacheHit {
+		t.Fatalf("matching digest: status = %d, outcome = %s, body = %s", w.Code, outcome, w.Body.String())
+	}
+	if w, body = verify("/asset", "sha256", strings.Repeat("0", 64)); w.Code != http.StatusConflict || body["match"] != false {
+		t.Fatalf("mismatched digest: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	if w, _ = verify("/large", "", ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large file: status = %d", w.Code)
+	}
+	if w, _ = verify("/missing", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("missing file: status = %d", w.Code)
+	}
+}
+
+func TestVerifyRejectsInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[access]
+blackList = ["baduser/*"]
+`)
+	h := newTestHandlers()
+
+	router := gin.New()
+	h.InitVerifyRoutes(router)
+	tests := []struct {
+		query string
+		want  int
+		code  string
+	}{
+		{"url=", http.StatusBadRequest, utils.ErrCodeInvalidInput},
+		{"url=example.com/file.tar.gz", http.StatusBadRequest, utils.ErrCodeInvalidInput},
+		{"url=github.com/baduser/repo/releases/download/v1/a.tar.gz", http.StatusForbidden, utils.ErrCodeGitHubBlacklisted},
+		{"url=github.com/owner/repo/releases/download/v1/a.tar.gz&expected=abc", http.StatusBadRequest, utils.ErrCodeInvalidDigest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/verify?"+tt.query, nil))
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tt.want || body["code"] != tt.code {
+			t.Errorf("%s: status = %d, body = %s", tt.query, w.Code, w.Body.String())
+		}
+	}
+}
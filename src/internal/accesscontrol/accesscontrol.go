@@ -0,0 +1,304 @@
+// Package accesscontrol 按access配置的黑白名单检查镜像和GitHub仓库是否允许代理
+package accesscontrol
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+)
+
+// ResourceType 资源类型
+type ResourceType string
+
+const (
+	ResourceTypeGitHub ResourceType = "github"
+	ResourceTypeDocker ResourceType = "docker"
+)
+
+// Controller 统一访问控制器，名单在每次检查时从store读取，热重载后立即生效
+type Controller struct {
+	config *config.Store
+}
+
+// New 创建按store中的名单检查的访问控制器
+func New(store *config.Store) *Controller {
+	return &Controller{config: store}
+}
+
+// DockerHubRegistry Docker Hub的规范registry名称
+const DockerHubRegistry = "docker.io"
+
+// DockerImageInfo Docker镜像信息
+// Registry为镜像所在的registry，未指定时为docker.io；Namespace为仓库路径中除最后一段外的部分，
+// 多级路径(ghcr.io/org/team/app)时为 org/team；FullName为不含registry的完整仓库路径
+type DockerImageInfo struct {
+	Registry   string
+	Namespace  string
+	Repository string
+	Tag        string
+	Digest     string
+	FullName   string
+}
+
+// QualifiedName 返回带registry的仓库路径
+func (info DockerImageInfo) QualifiedName() string {
+	return info.Registry + "/" + info.FullName
+}
+
+// isRegistryHost 判断镜像引用的第一段是否为registry主机名
+func isRegistryHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// normalizeRegistry 将Docker Hub的各种别名统一为docker.io
+func normalizeRegistry(registry string) string {
+	switch registry = strings.ToLower(registry); registry {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DockerHubRegistry
+	}
+	return registry
+}
+
+// ParseDockerImage 解析Docker镜像名称，只有Docker Hub的单段名称会补全library/
+func (ac *Controller) ParseDockerImage(image string) DockerImageInfo {
+	image = strings.TrimPrefix(image, "docker://")
+
+	var digest string
+	if idx := strings.Index(image, "@"); idx != -1 {
+		digest = image[idx+1:]
+		image = image[:idx]
+	}
+
+	var tag string
+	if idx := strings.LastIndex(image, ":"); idx != -1 {
+		part := image[idx+1:]
+		if !strings.Contains(part, "/") {
+			tag = part
+			image = image[:idx]
+		}
+	}
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+
+	registry := DockerHubRegistry
+	if first, rest, found := strings.Cut(image, "/"); found && isRegistryHost(first) {
+		registry = normalizeRegistry(first)
+		image = rest
+	}
+	if registry == DockerHubRegistry && !strings.Contains(image, "/") {
+		image = "library/" + image
+	}
+
+	var namespace, repository string
+	if idx := strings.LastIndex(image, "/"); idx != -1 {
+		namespace, repository = image[:idx], image[idx+1:]
+	} else {
+		repository = image
+	}
+
+	return DockerImageInfo{
+		Registry:   registry,
+		Namespace:  namespace,
+		Repository: repository,
+		Tag:        tag,
+		Digest:     digest,
+		FullName:   image,
+	}
+}
+
+// RepositoryNameError 镜像仓库名称包含大写字母且未开启proxy.docker.autoLowercase，Suggested为小写后的引用
+type RepositoryNameError struct {
+	Name      string
+	Suggested string
+}
+
+func (e *RepositoryNameError) Error() string {
+	return fmt.Sprintf("仓库名称必须为小写: %s，请改用 %s", e.Name, e.Suggested)
+}
+
+// lowerASCII 只转换ASCII大写字母，其他字符保持原样，由引用解析报告非法字符
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// NormalizeDockerReference 处理镜像引用中的大写字母：registry主机名直接转为小写，tag和digest保持原样；
+// 仓库名称含大写时按cfg的proxy.docker.autoLowercase转为小写，未开启时返回RepositoryNameError
+func NormalizeDockerReference(cfg *config.AppConfig, image string) (string, error) {
+	name, suffix := image, ""
+	if idx := strings.Index(name, "@"); idx != -1 {
+		name, suffix = name[:idx], name[idx:]
+	}
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx+1:], "/") {
+		name, suffix = name[:idx], name[idx:]+suffix
+	}
+
+	host, repo := "", name
+	if first, rest, found := strings.Cut(name, "/"); found && isRegistryHost(first) {
+		host, repo = strings.ToLower(first)+"/", rest
+	}
+	if lowered := lowerASCII(repo); lowered != repo {
+		if !cfg.Proxy.Docker.AutoLowercase {
+			return "", &RepositoryNameError{Name: image, Suggested: host + lowered + suffix}
+		}
+		repo = lowered
+	}
+	return host + repo + suffix, nil
+}
+
+// FoldRepoName 返回用于名单匹配的仓库名称：先解码百分号编码(非法编码时保持原样)，再转为小写，
+// 非法的UTF-8替换为U+FFFD，编码过的名称与原样的名称按同一条规则匹配
+func FoldRepoName(name string) string {
+	if decoded, err := url.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	return strings.ToLower(strings.ToValidUTF8(strings.TrimSpace(name), "\uFFFD"))
+}
+
+// CheckDockerAccess 检查Docker镜像访问权限，拒绝时返回错误码
+func (ac *Controller) CheckDockerAccess(image string) (allowed bool, reason string) {
+	cfg := ac.config.Get()
+
+	imageInfo := ac.ParseDockerImage(image)
+
+	if len(cfg.Access.WhiteList) > 0 {
+		if !ac.matchImageInList(imageInfo, cfg.Access.WhiteList) {
+			return false, utils.ErrCodeDockerNotWhitelisted
+		}
+	}
+
+	if len(cfg.Access.BlackList) > 0 {
+		if ac.matchImageInList(imageInfo, cfg.Access.BlackList) {
+			return false, utils.ErrCodeDockerBlacklisted
+		}
+	}
+
+	return true, ""
+}
+
+// CheckGitHubAccess 检查GitHub仓库访问权限，拒绝时返回错误码
+func (ac *Controller) CheckGitHubAccess(matches []string) (allowed bool, reason string) {
+	if len(matches) < 2 {
+		return false, utils.ErrCodeGitHubInvalidRepo
+	}
+
+	cfg := ac.config.Get()
+
+	if len(cfg.Access.WhiteList) > 0 && !ac.checkList(matches, cfg.Access.WhiteList) {
+		return false, utils.ErrCodeGitHubNotWhitelisted
+	}
+
+	if len(cfg.Access.BlackList) > 0 && ac.checkList(matches, cfg.Access.BlackList) {
+		return false, utils.ErrCodeGitHubBlacklisted
+	}
+
+	return true, ""
+}
+
+// matchImageInList 检查Docker镜像是否在指定列表中
+// 以registry主机名开头的规则(ghcr.io/org/*)只匹配该registry的镜像，其余规则匹配任意registry
+func (ac *Controller) matchImageInList(imageInfo DockerImageInfo, list []string) bool {
+	fullName := strings.ToLower(imageInfo.FullName)
+	qualifiedName := strings.ToLower(imageInfo.QualifiedName())
+	namespace := strings.ToLower(imageInfo.Namespace)
+	repository := strings.ToLower(imageInfo.Repository)
+
+	for _, item := range list {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		if first, rest, found := strings.Cut(item, "/"); found && isRegistryHost(first) {
+			item = normalizeRegistry(first) + "/" + rest
+			if matchImagePattern(qualifiedName, item) {
+				return true
+			}
+			continue
+		}
+
+		if matchImagePattern(fullName, item) {
+			return true
+		}
+
+		if namespace != "" && (item == namespace || item == namespace+"/*") {
+			return true
+		}
+
+		if strings.HasPrefix(item, "*/") {
+			repoPattern := strings.TrimPrefix(item, "*/")
+			if strings.HasSuffix(repoPattern, "*") {
+				if strings.HasPrefix(repository, strings.TrimSuffix(repoPattern, "*")) {
+					return true
+				}
+			} else if repository == repoPattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchImagePattern 按完全匹配、*结尾的前缀匹配或路径前缀匹配检查镜像名
+func matchImagePattern(name, item string) bool {
+	if name == item || strings.HasPrefix(name, item+"/") {
+		return true
+	}
+	if prefix, found := strings.CutSuffix(item, "*"); found && strings.HasPrefix(name, prefix) {
+		return true
+	}
+	return false
+}
+
+// checkList GitHub仓库检查逻辑
+func (ac *Controller) checkList(matches, list []string) bool {
+	if len(matches) < 2 {
+		return false
+	}
+
+	username := FoldRepoName(matches[0])
+	repoName := strings.TrimSuffix(FoldRepoName(matches[1]), ".git")
+	fullRepo := username + "/" + repoName
+
+	for _, item := range list {
+		item = FoldRepoName(item)
+		if item == "" {
+			continue
+		}
+
+		if fullRepo == item {
+			return true
+		}
+
+		if item == username || item == username+"/*" {
+			return true
+		}
+
+		if strings.HasSuffix(item, "*") {
+			prefix := strings.TrimSuffix(item, "*")
+			if strings.HasPrefix(fullRepo, prefix) {
+				return true
+			}
+		}
+
+		if strings.HasPrefix(fullRepo, item+"/") {
+			return true
+		}
+
+		if strings.HasPrefix(item, "*/") {
+			p := item[2:]
+			if p == repoName || (strings.HasSuffix(p, "*") && strings.HasPrefix(repoName, p[:len(p)-1])) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package accesscontrol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestParseDockerImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		registry   string
+		namespace  string
+		repository string
+		tag        string
+		digest     string
+		fullName   string
+	}{
+		{"official", "nginx", "docker.io", "library", "nginx", "latest", "", "library/nginx"},
+		{"tagged", "redis:7", "docker.io", "library", "redis", "7", "", "library/redis"},
+		{"namespaced", "user/app:v1", "docker.io", "user", "app", "v1", "", "user/app"},
+		{"docker scheme", "docker://alpine:3.20", "docker.io", "library", "alpine", "3.20", "", "library/alpine"},
+		{"explicit hub", "docker.io/nginx", "docker.io", "library", "nginx", "latest", "", "library/nginx"},
+		{"hub alias", "registry-1.docker.io/library/nginx", "docker.io", "library", "nginx", "latest", "", "library/nginx"},
+		{"registry", "ghcr.io/user/app:v2", "ghcr.io", "user", "app", "v2", "", "user/app"},
+		{"single segment registry", "registry.k8s.io/pause:3.9", "registry.k8s.io", "", "pause", "3.9", "", "pause"},
+		{"nested path", "ghcr.io/org/team/app:1.0", "ghcr.io", "org/team", "app", "1.0", "", "org/team/app"},
+		{"registry port", "localhost:5000/app", "localhost:5000", "", "app", "latest", "", "app"},
+		{"localhost", "localhost/team/app:dev", "localhost", "team", "app", "dev", "", "team/app"},
+		{"digest", "nginx@sha256:abc123", "docker.io", "library", "nginx", "", "sha256:abc123", "library/nginx"},
+		{"tag and digest", "quay.io/org/app:v1@sha256:abc123", "quay.io", "org", "app", "v1", "sha256:abc123", "org/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := New(config.NewStore(config.GetConfig())).ParseDockerImage(tt.image)
+			want := DockerImageInfo{
+				Registry:   tt.registry,
+				Namespace:  tt.namespace,
+				Repository: tt.repository,
+				Tag:        tt.tag,
+				Digest:     tt.digest,
+				FullName:   tt.fullName,
+			}
+			if got != want {
+				t.Fatalf("ParseDockerImage(%q) = %#v, want %#v", tt.image, got, want)
+			}
+		})
+	}
+}
+
+func TestMatchImageInListWithRegistry(t *testing.T) {
+	tests := []struct {
+		image string
+		list  []string
+		want  bool
+	}{
+		{"registry.k8s.io/pause", []string{"registry.k8s.io/*"}, true},
+		{"registry.k8s.io/pause", []string{"library/*"}, false},
+		{"ghcr.io/org/team/app", []string{"ghcr.io/org/*"}, true},
+		{"ghcr.io/org/team/app", []string{"ghcr.io/org"}, true},
+		{"ghcr.io/org/team/app", []string{"quay.io/org/*"}, false},
+		{"ghcr.io/org/team/app", []string{"org/*"}, true},
+		{"ghcr.io/org/team/app", []string{"org/team"}, true},
+		{"ghcr.io/org/team/app", []string{"*/app"}, true},
+		{"nginx", []string{"docker.io/library/*"}, true},
+		{"nginx", []string{"index.docker.io/library/nginx"}, true},
+		{"ghcr.io/library/nginx", []string{"docker.io/library/*"}, false},
+		{"localhost:5000/app", []string{"localhost:5000/app"}, true},
+	}
+
+	for _, tt := range tests {
+		info := New(config.NewStore(config.GetConfig())).ParseDockerImage(tt.image)
+		if got := New(config.NewStore(config.GetConfig())).matchImageInList(info, tt.list); got != tt.want {
+			t.Errorf("matchImageInList(%q, %v) = %v, want %v", tt.image, tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestDockerAccessLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[access]
+whiteList = ["library/*", "good/*"]
+blackList = ["good/bad"]
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if allowed, reason := New(config.NewStore(config.GetConfig())).CheckDockerAccess("nginx"); !allowed {
+		t.Fatalf("nginx denied: %s", reason)
+	}
+	if allowed, _ := New(config.NewStore(config.GetConfig())).CheckDockerAccess("good/bad:latest"); allowed {
+		t.Fatal("blacklisted image allowed")
+	}
+	if allowed, _ := New(config.NewStore(config.GetConfig())).CheckDockerAccess("other/app"); allowed {
+		t.Fatal("image outside whitelist allowed")
+	}
+}
+
+func TestGitHubAccessLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[access]
+whiteList = ["allowed/*"]
+blackList = ["allowed/blocked"]
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if allowed, reason := New(config.NewStore(config.GetConfig())).CheckGitHubAccess([]string{"allowed", "repo"}); !allowed {
+		t.Fatalf("allowed/repo denied: %s", reason)
+	}
+	if allowed, _ := New(config.NewStore(config.GetConfig())).CheckGitHubAccess([]string{"allowed", "blocked"}); allowed {
+		t.Fatal("blacklisted repo allowed")
+	}
+	if allowed, _ := New(config.NewStore(config.GetConfig())).CheckGitHubAccess([]string{"other", "repo"}); allowed {
+		t.Fatal("repo outside whitelist allowed")
+	}
+}
+
+func TestNormalizeDockerReference(t *testing.T) {
+	tests := []struct {
+		image     string
+		lowered   string
+		suggested string
+	}{
+		{"nginx", "nginx", ""},
+		{"MyOrg/MyImage:Tag", "myorg/myimage:Tag", "myorg/myimage:Tag"},
+		{"GHCR.io/org/app:v1", "ghcr.io/org/app:v1", ""},
+		{"Localhost:5000/App", "localhost:5000/app", "localhost:5000/app"},
+		{"quay.io/Org/App:V1@sha256:abc", "quay.io/org/app:V1@sha256:abc", "quay.io/org/app:V1@sha256:abc"},
+		{"org/Äpp", "org/Äpp", ""},
+	}
+	for _, autoLowercase := range []bool{false, true} {
+		loadTestConfig(t, fmt.Sprintf("[proxy.docker]\nautoLowercase = %v\n", autoLowercase))
+		for _, tt := range tests {
+			got, err := NormalizeDockerReference(config.GetConfig(), tt.image)
+			var nameErr *RepositoryNameError
+			switch {
+			case tt.suggested == "" || autoLowercase:
+				if err != nil || got != tt.lowered {
+					t.Errorf("autoLowercase=%v %q = %q, %v", autoLowercase, tt.image, got, err)
+				}
+			case !errors.As(err, &nameErr) || nameErr.Suggested != tt.suggested:
+				t.Errorf("autoLowercase=%v %q error = %v", autoLowercase, tt.image, err)
+			}
+		}
+	}
+}
+
+func TestGitHubAccessFoldsCaseAndEncoding(t *testing.T) {
+	loadTestConfig(t, `
+[access]
+blackList = ["SomeOrg/MyRepo", "badorg/*", "ünïcode/*"]
+`)
+	tests := []struct {
+		owner, repo string
+		allowed     bool
+	}{
+		{"someorg", "myrepo", false},
+		{"SOMEORG", "MyRepo.git", false},
+		{"BadOrg", "anything", false},
+		{"b%61dorg", "repo", false},
+		{"%C3%BCn%C3%AFcode", "repo", false},
+		{"ÜNÏCODE", "repo", false},
+		{"owner", "%zz", true},
+		{"%FF%FE", "repo", true},
+		{"SomeOrg", "OtherRepo", true},
+	}
+	for _, tt := range tests {
+		if allowed, _ := New(config.NewStore(config.GetConfig())).CheckGitHubAccess([]string{tt.owner, tt.repo}); allowed != tt.allowed {
+			t.Errorf("%s/%s allowed = %v, want %v", tt.owner, tt.repo, allowed, tt.allowed)
+		}
+	}
+}
+
+func loadTestConfig(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
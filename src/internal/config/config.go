@@ -0,0 +1,1543 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	// 内置时区数据库，精简镜像中没有/usr/share/zoneinfo时时区配置仍然可用
+	_ "time/tzdata"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// RegistryMapping Registry映射配置
+type RegistryMapping struct {
+	Upstream        string   `toml:"upstream"`
+	AuthHost        string   `toml:"authHost"`
+	AuthType        string   `toml:"authType"`
+	Challenge       string   `toml:"challenge"`
+	Enabled         bool     `toml:"enabled"`
+	RedirectHosts   []string `toml:"redirectHosts"`
+	BackendCacheTTL string   `toml:"backendCacheTTL"`
+}
+
+// HeaderRules 上游请求头改写规则，依次执行remove、set、add
+type HeaderRules struct {
+	Set            map[string]string `toml:"set"`
+	Add            map[string]string `toml:"add"`
+	Remove         []string          `toml:"remove"`
+	AllowSensitive bool              `toml:"allowSensitive"`
+}
+
+// UpstreamLimit 单个上游主机的出站请求限制，0使用默认值，负数表示不限制
+type UpstreamLimit struct {
+	RequestsPerSecond float64 `toml:"requestsPerSecond"`
+	Burst             int     `toml:"burst"`
+	MaxConcurrent     int     `toml:"maxConcurrent"`
+}
+
+// AppConfig 应用配置结构体
+type AppConfig struct {
+	Server struct {
+		Host                string           `toml:"host"`
+		Port                int              `toml:"port"`
+		FileSize            int64            `toml:"fileSize"`
+		EnableH2C           bool             `toml:"enableH2C"`
+		EnableFrontend      bool             `toml:"enableFrontend"`
+		TrustedProxies      []string         `toml:"trustedProxies"`
+		Language            string           `toml:"language"`
+		NegotiateLanguage   bool             `toml:"negotiateLanguage"`
+		MaxRequestBodyBytes int64            `toml:"maxRequestBodyBytes"`
+		RequestBodyLimits   map[string]int64 `toml:"requestBodyLimits"`
+		ReadHeaderTimeout   string           `toml:"readHeaderTimeout"`
+		ReadTimeout         string           `toml:"readTimeout"`
+		BasePath            string           `toml:"basePath"`
+		Timezone            string           `toml:"timezone"`
+		StrictConfig        bool             `toml:"strictConfig"`
+	} `toml:"server"`
+
+	RateLimit struct {
+		RequestLimit          int               `toml:"requestLimit"`
+		PeriodHours           float64           `toml:"periodHours"`
+		WhitelistRequestLimit int               `toml:"whitelistRequestLimit"`
+		WhitelistPeriodHours  float64           `toml:"whitelistPeriodHours"`
+		MaxEntries            int               `toml:"maxEntries"`
+		AdaptiveEnabled       bool              `toml:"adaptiveEnabled"`
+		AdaptiveWindow        string            `toml:"adaptiveWindow"`
+		AdaptiveThresholdMBps float64           `toml:"adaptiveThresholdMBps"`
+		AdaptiveFactor        float64           `toml:"adaptiveFactor"`
+		AdaptiveRecoverRatio  float64           `toml:"adaptiveRecoverRatio"`
+		QuotaWarnPercent      int               `toml:"quotaWarnPercent"`
+		QuotaWarnWebhook      string            `toml:"quotaWarnWebhook"`
+		ExemptPaths           []string          `toml:"exemptPaths"`
+		PathClasses           map[string]string `toml:"pathClasses"`
+		Classes               map[string]struct {
+			RequestLimit int     `toml:"requestLimit"`
+			PeriodHours  float64 `toml:"periodHours"`
+		} `toml:"classes"`
+		SharedNetworks map[string]struct {
+			CIDRs      []string `toml:"cidrs"`
+			Multiplier float64  `toml:"multiplier"`
+			UserHeader string   `toml:"userHeader"`
+		} `toml:"sharedNetworks"`
+	} `toml:"rateLimit"`
+
+	Security struct {
+		WhiteList      []string `toml:"whiteList"`
+		BlackList      []string `toml:"blackList"`
+		InfraAllowList []string `toml:"infraAllowList"`
+		InfraPaths     []string `toml:"infraPaths"`
+		Referer        struct {
+			Enabled    bool     `toml:"enabled"`
+			Allowed    []string `toml:"allowed"`
+			AllowEmpty bool     `toml:"allowEmpty"`
+		} `toml:"referer"`
+		Crawlers struct {
+			Enabled      bool     `toml:"enabled"`
+			Action       string   `toml:"action"`
+			RequestLimit int      `toml:"requestLimit"`
+			PeriodHours  float64  `toml:"periodHours"`
+			Patterns     []string `toml:"patterns"`
+		} `toml:"crawlers"`
+		Screening struct {
+			Enabled         bool   `toml:"enabled"`
+			FeedURL         string `toml:"feedURL"`
+			FeedRefresh     string `toml:"feedRefresh"`
+			NewRepoWindow   string `toml:"newRepoWindow"`
+			NewRepoMaxBytes int64  `toml:"newRepoMaxBytes"`
+		} `toml:"screening"`
+	} `toml:"security"`
+
+	Robots struct {
+		Enabled bool   `toml:"enabled"`
+		Content string `toml:"content"`
+	} `toml:"robots"`
+
+	CORS struct {
+		Enabled        bool     `toml:"enabled"`
+		AllowedOrigins []string `toml:"allowedOrigins"`
+		AllowedMethods []string `toml:"allowedMethods"`
+		AllowedHeaders []string `toml:"allowedHeaders"`
+		MaxAge         int      `toml:"maxAge"`
+	} `toml:"cors"`
+
+	Access struct {
+		WhiteList []string `toml:"whiteList"`
+		BlackList []string `toml:"blackList"`
+		Proxy     string   `toml:"proxy"`
+	} `toml:"access"`
+
+	GitHub struct {
+		Token                 string `toml:"token"`
+		TokenForAnonymous     bool   `toml:"tokenForAnonymous"`
+		HTMLPassthrough       bool   `toml:"htmlPassthrough"`
+		APICache              bool   `toml:"apiCache"`
+		APICacheTTL           string `toml:"apiCacheTTL"`
+		APIRateLimitFallback  bool   `toml:"apiRateLimitFallback"`
+		APIStaleFor           string `toml:"apiStaleFor"`
+		ArchiveConvert        bool   `toml:"archiveConvert"`
+		ArchiveConvertMaxSize int64  `toml:"archiveConvertMaxSize"`
+	} `toml:"github"`
+
+	Download struct {
+		MaxImages         int    `toml:"maxImages"`
+		MaxConcurrentJobs int    `toml:"maxConcurrentJobs"`
+		MaxJobsPerIP      int    `toml:"maxJobsPerIP"`
+		QueueSize         int    `toml:"queueSize"`
+		KeepaliveInterval string `toml:"keepaliveInterval"`
+	} `toml:"download"`
+
+	Registries map[string]RegistryMapping `toml:"registries"`
+
+	Upstream struct {
+		Timeouts struct {
+			Metadata       string `toml:"metadata"`
+			Connect        string `toml:"connect"`
+			TLSHandshake   string `toml:"tlsHandshake"`
+			ResponseHeader string `toml:"responseHeader"`
+			IdleProgress   string `toml:"idleProgress"`
+		} `toml:"timeouts"`
+		Headers struct {
+			Set            map[string]string      `toml:"set"`
+			Add            map[string]string      `toml:"add"`
+			Remove         []string               `toml:"remove"`
+			AllowSensitive bool                   `toml:"allowSensitive"`
+			Hosts          map[string]HeaderRules `toml:"hosts"`
+		} `toml:"headers"`
+		Limits struct {
+			Enabled           bool                     `toml:"enabled"`
+			RequestsPerSecond float64                  `toml:"requestsPerSecond"`
+			Burst             int                      `toml:"burst"`
+			MaxConcurrent     int                      `toml:"maxConcurrent"`
+			QueueTimeout      string                   `toml:"queueTimeout"`
+			Cooldown          string                   `toml:"cooldown"`
+			Hosts             map[string]UpstreamLimit `toml:"hosts"`
+		} `toml:"limits"`
+		DNS struct {
+			DoHURL      string            `toml:"dohURL"`
+			Bootstrap   string            `toml:"bootstrap"`
+			NegativeTTL string            `toml:"negativeTTL"`
+			Hosts       map[string]string `toml:"hosts"`
+		} `toml:"dns"`
+		TLS struct {
+			CABundle string `toml:"caBundle"`
+		} `toml:"tls"`
+		ResponseHeaders struct {
+			MaxBytes       int64 `toml:"maxBytes"`
+			MaxValueLength int   `toml:"maxValueLength"`
+			MaxCount       int   `toml:"maxCount"`
+		} `toml:"responseHeaders"`
+	} `toml:"upstream"`
+
+	APITimeouts struct {
+		Search string `toml:"search"`
+		Stats  string `toml:"stats"`
+		GitHub string `toml:"github"`
+		Verify string `toml:"verify"`
+	} `toml:"apiTimeouts"`
+
+	Proxy struct {
+		AccelConnections    int   `toml:"accelConnections"`
+		AccelMaxConnections int   `toml:"accelMaxConnections"`
+		AccelMinSize        int64 `toml:"accelMinSize"`
+		AccelChunkSize      int64 `toml:"accelChunkSize"`
+		MaxRedirects        int   `toml:"maxRedirects"`
+		ResponseHeaders     struct {
+			Strip []string          `toml:"strip"`
+			Keep  []string          `toml:"keep"`
+			Set   map[string]string `toml:"set"`
+		} `toml:"responseHeaders"`
+		Methods struct {
+			GitHub      []string `toml:"github"`
+			API         []string `toml:"api"`
+			AllowWrites bool     `toml:"allowWrites"`
+			Git         []string `toml:"git"`
+			HuggingFace []string `toml:"huggingface"`
+		} `toml:"methods"`
+		Docker struct {
+			AutoLowercase bool `toml:"autoLowercase"`
+		} `toml:"docker"`
+	} `toml:"proxy"`
+
+	TokenCache struct {
+		Enabled         bool     `toml:"enabled"`
+		DefaultTTL      string   `toml:"defaultTTL"`
+		RefreshAhead    float64  `toml:"refreshAhead"`
+		HotRepositories []string `toml:"hotRepositories"`
+		RevalidateFor   string   `toml:"revalidateFor"`
+	} `toml:"tokenCache"`
+
+	DockerCache struct {
+		NegativeTTL          string `toml:"negativeTTL"`
+		NegativeDigestTTL    string `toml:"negativeDigestTTL"`
+		StaleWhileRevalidate string `toml:"staleWhileRevalidate"`
+		StaleIfError         string `toml:"staleIfError"`
+	} `toml:"dockerCache"`
+
+	Cache struct {
+		HonorClientDirectives bool `toml:"honorClientDirectives"`
+	} `toml:"cache"`
+
+	Admin struct {
+		Enabled           bool   `toml:"enabled"`
+		Token             string `toml:"token"`
+		RequestsPerMinute int    `toml:"requestsPerMinute"`
+		Listen            string `toml:"listen"`
+	} `toml:"admin"`
+
+	Auth struct {
+		Mode       string            `toml:"mode"`
+		Realm      string            `toml:"realm"`
+		Users      map[string]string `toml:"users"`
+		TokenStore string            `toml:"tokenStore"`
+		OIDC       struct {
+			Issuer       string `toml:"issuer"`
+			Audience     string `toml:"audience"`
+			JWKSURL      string `toml:"jwksURL"`
+			JWKSCacheTTL string `toml:"jwksCacheTTL"`
+		} `toml:"oidc"`
+	} `toml:"auth"`
+
+	UI struct {
+		Enabled            bool    `toml:"enabled"`
+		HeadlessMessage    string  `toml:"headlessMessage"`
+		ActivityFeed       bool    `toml:"activityFeed"`
+		AnonymizeIP        bool    `toml:"anonymizeIP"`
+		ActivitySampleRate float64 `toml:"activitySampleRate"`
+	} `toml:"ui"`
+
+	Privacy struct {
+		NoIPLogging   bool `toml:"noIPLogging"`
+		RetentionDays int  `toml:"retentionDays"`
+	} `toml:"privacy"`
+
+	Debounce struct {
+		Enabled             bool     `toml:"enabled"`
+		Window              string   `toml:"window"`
+		MaxWaiters          int      `toml:"maxWaiters"`
+		Classes             []string `toml:"classes"`
+		DownloadWindow      string   `toml:"downloadWindow"`
+		BatchDownloadWindow string   `toml:"batchDownloadWindow"`
+	} `toml:"debounce"`
+
+	Schedule struct {
+		Enabled          bool     `toml:"enabled"`
+		Timezone         string   `toml:"timezone"`
+		QuietHours       []string `toml:"quietHours"`
+		DailyBudgetBytes int64    `toml:"dailyBudgetBytes"`
+		ResetHour        int      `toml:"resetHour"`
+	} `toml:"schedule"`
+
+	History struct {
+		Enabled       bool   `toml:"enabled"`
+		Path          string `toml:"path"`
+		RetentionDays int    `toml:"retentionDays"`
+	} `toml:"history"`
+
+	Signing struct {
+		Enabled bool   `toml:"enabled"`
+		Key     string `toml:"key"`
+		KeyFile string `toml:"keyFile"`
+	} `toml:"signing"`
+
+	Limits struct {
+		GitHub                  int64              `toml:"github"`
+		DockerBlob              int64              `toml:"dockerBlob"`
+		Tar                     int64              `toml:"tar"`
+		HuggingFace             int64              `toml:"huggingface"`
+		AuthenticatedMultiplier float64            `toml:"authenticatedMultiplier"`
+		Multipliers             map[string]float64 `toml:"multipliers"`
+		Overrides               map[string]int64   `toml:"overrides"`
+	} `toml:"limits"`
+
+	HotCache struct {
+		Enabled            bool  `toml:"enabled"`
+		MaxBytes           int64 `toml:"maxBytes"`
+		MaxObjectBytes     int64 `toml:"maxObjectBytes"`
+		PromoteAfter       int   `toml:"promoteAfter"`
+		PullBytesPerSecond int64 `toml:"pullBytesPerSecond"`
+	} `toml:"hotCache"`
+
+	Memory struct {
+		LimitBytes  int64   `toml:"limitBytes"`
+		BufferRatio float64 `toml:"bufferRatio"`
+	} `toml:"memory"`
+
+	Health struct {
+		DegradedSuccessRate float64 `toml:"degradedSuccessRate"`
+		DownSuccessRate     float64 `toml:"downSuccessRate"`
+		DegradedP95Ms       float64 `toml:"degradedP95Ms"`
+		MinSamples          int     `toml:"minSamples"`
+		StatusPage          bool    `toml:"statusPage"`
+	} `toml:"health"`
+
+	V2 struct {
+		Challenge string `toml:"challenge"`
+		Service   string `toml:"service"`
+	} `toml:"v2"`
+
+	Log struct {
+		DedupWindow          string `toml:"dedupWindow"`
+		DedupThreshold       int    `toml:"dedupThreshold"`
+		SlowRequestThreshold string `toml:"slowRequestThreshold"`
+		SlowRequestSamples   int    `toml:"slowRequestSamples"`
+	} `toml:"log"`
+}
+
+var (
+	appConfig     *AppConfig
+	appConfigLock sync.RWMutex
+
+	cachedConfig     *AppConfig
+	configCacheTime  time.Time
+	configCacheTTL   = 5 * time.Second
+	configCacheMutex sync.RWMutex
+)
+
+// DefaultConfig 返回默认配置
+func DefaultConfig() *AppConfig {
+	return &AppConfig{
+		Server: struct {
+			Host                string           `toml:"host"`
+			Port                int              `toml:"port"`
+			FileSize            int64            `toml:"fileSize"`
+			EnableH2C           bool             `toml:"enableH2C"`
+			EnableFrontend      bool             `toml:"enableFrontend"`
+			TrustedProxies      []string         `toml:"trustedProxies"`
+			Language            string           `toml:"language"`
+			NegotiateLanguage   bool             `toml:"negotiateLanguage"`
+			MaxRequestBodyBytes int64            `toml:"maxRequestBodyBytes"`
+			RequestBodyLimits   map[string]int64 `toml:"requestBodyLimits"`
+			ReadHeaderTimeout   string           `toml:"readHeaderTimeout"`
+			ReadTimeout         string           `toml:"readTimeout"`
+			BasePath            string           `toml:"basePath"`
+			Timezone            string           `toml:"timezone"`
+			StrictConfig        bool             `toml:"strictConfig"`
+		}{
+			Host:                "0.0.0.0",
+			Port:                5000,
+			FileSize:            2 * 1024 * 1024 * 1024,
+			EnableH2C:           false,
+			EnableFrontend:      true,
+			TrustedProxies:      []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+			Language:            "zh",
+			NegotiateLanguage:   false,
+			MaxRequestBodyBytes: 32 * 1024 * 1024,
+			RequestBodyLimits: map[string]int64{
+				"git-receive-pack": 1024 * 1024 * 1024,
+			},
+			ReadHeaderTimeout: "10s",
+			ReadTimeout:       "60s",
+			BasePath:          "",
+			Timezone:          "",
+		},
+		RateLimit: struct {
+			RequestLimit          int               `toml:"requestLimit"`
+			PeriodHours           float64           `toml:"periodHours"`
+			WhitelistRequestLimit int               `toml:"whitelistRequestLimit"`
+			WhitelistPeriodHours  float64           `toml:"whitelistPeriodHours"`
+			MaxEntries            int               `toml:"maxEntries"`
+			AdaptiveEnabled       bool              `toml:"adaptiveEnabled"`
+			AdaptiveWindow        string            `toml:"adaptiveWindow"`
+			AdaptiveThresholdMBps float64           `toml:"adaptiveThresholdMBps"`
+			AdaptiveFactor        float64           `toml:"adaptiveFactor"`
+			AdaptiveRecoverRatio  float64           `toml:"adaptiveRecoverRatio"`
+			QuotaWarnPercent      int               `toml:"quotaWarnPercent"`
+			QuotaWarnWebhook      string            `toml:"quotaWarnWebhook"`
+			ExemptPaths           []string          `toml:"exemptPaths"`
+			PathClasses           map[string]string `toml:"pathClasses"`
+			Classes               map[string]struct {
+				RequestLimit int     `toml:"requestLimit"`
+				PeriodHours  float64 `toml:"periodHours"`
+			} `toml:"classes"`
+			SharedNetworks map[string]struct {
+				CIDRs      []string `toml:"cidrs"`
+				Multiplier float64  `toml:"multiplier"`
+				UserHeader string   `toml:"userHeader"`
+			} `toml:"sharedNetworks"`
+		}{
+			RequestLimit:          500,
+			PeriodHours:           3.0,
+			WhitelistRequestLimit: 0,
+			WhitelistPeriodHours:  0,
+			MaxEntries:            10000,
+			AdaptiveEnabled:       false,
+			AdaptiveWindow:        "5m",
+			AdaptiveThresholdMBps: 100,
+			AdaptiveFactor:        0.5,
+			AdaptiveRecoverRatio:  0.8,
+			QuotaWarnPercent:      80,
+			QuotaWarnWebhook:      "",
+			ExemptPaths:           []string{"/", "/favicon.ico", "/images.html", "/search.html", "/api/events", "/robots.txt", "/health", "/public/*"},
+			PathClasses:           map[string]string{},
+			Classes: map[string]struct {
+				RequestLimit int     `toml:"requestLimit"`
+				PeriodHours  float64 `toml:"periodHours"`
+			}{},
+			SharedNetworks: map[string]struct {
+				CIDRs      []string `toml:"cidrs"`
+				Multiplier float64  `toml:"multiplier"`
+				UserHeader string   `toml:"userHeader"`
+			}{},
+		},
+		Security: struct {
+			WhiteList      []string `toml:"whiteList"`
+			BlackList      []string `toml:"blackList"`
+			InfraAllowList []string `toml:"infraAllowList"`
+			InfraPaths     []string `toml:"infraPaths"`
+			Referer        struct {
+				Enabled    bool     `toml:"enabled"`
+				Allowed    []string `toml:"allowed"`
+				AllowEmpty bool     `toml:"allowEmpty"`
+			} `toml:"referer"`
+			Crawlers struct {
+				Enabled      bool     `toml:"enabled"`
+				Action       string   `toml:"action"`
+				RequestLimit int      `toml:"requestLimit"`
+				PeriodHours  float64  `toml:"periodHours"`
+				Patterns     []string `toml:"patterns"`
+			} `toml:"crawlers"`
+			Screening struct {
+				Enabled         bool   `toml:"enabled"`
+				FeedURL         string `toml:"feedURL"`
+				FeedRefresh     string `toml:"feedRefresh"`
+				NewRepoWindow   string `toml:"newRepoWindow"`
+				NewRepoMaxBytes int64  `toml:"newRepoMaxBytes"`
+			} `toml:"screening"`
+		}{
+			WhiteList:      []string{},
+			BlackList:      []string{},
+			InfraAllowList: []string{},
+			InfraPaths:     []string{"/health", "/ready", "/metrics", "/status"},
+			Referer: struct {
+				Enabled    bool     `toml:"enabled"`
+				Allowed    []string `toml:"allowed"`
+				AllowEmpty bool     `toml:"allowEmpty"`
+			}{
+				Enabled:    false,
+				Allowed:    []string{},
+				AllowEmpty: true,
+			},
+			Crawlers: struct {
+				Enabled      bool     `toml:"enabled"`
+				Action       string   `toml:"action"`
+				RequestLimit int      `toml:"requestLimit"`
+				PeriodHours  float64  `toml:"periodHours"`
+				Patterns     []string `toml:"patterns"`
+			}{
+				Enabled:      true,
+				Action:       "limit",
+				RequestLimit: 60,
+				PeriodHours:  1,
+				Patterns: []string{
+					"Googlebot", "bingbot", "Baiduspider", "YandexBot", "DuckDuckBot", "Slurp", "Sogou", "360Spider",
+					"Bytespider", "PetalBot", "Applebot", "Amazonbot", "SemrushBot", "AhrefsBot", "MJ12bot", "DotBot",
+					"DataForSeoBot", "GPTBot", "ChatGPT-User", "OAI-SearchBot", "ClaudeBot", "anthropic-ai", "CCBot",
+					"PerplexityBot", "Google-Extended", "meta-externalagent", "facebookexternalhit", "Diffbot",
+					"ImagesiftBot", "cohere-ai", "YouBot", "Timpibot",
+				},
+			},
+			Screening: struct {
+				Enabled         bool   `toml:"enabled"`
+				FeedURL         string `toml:"feedURL"`
+				FeedRefresh     string `toml:"feedRefresh"`
+				NewRepoWindow   string `toml:"newRepoWindow"`
+				NewRepoMaxBytes int64  `toml:"newRepoMaxBytes"`
+			}{
+				Enabled:       false,
+				FeedRefresh:   "1h",
+				NewRepoWindow: "24h",
+			},
+		},
+		Robots: struct {
+			Enabled bool   `toml:"enabled"`
+			Content string `toml:"content"`
+		}{
+			Enabled: true,
+			Content: "User-agent: *\nAllow: /$\nDisallow: /\n",
+		},
+		CORS: struct {
+			Enabled        bool     `toml:"enabled"`
+			AllowedOrigins []string `toml:"allowedOrigins"`
+			AllowedMethods []string `toml:"allowedMethods"`
+			AllowedHeaders []string `toml:"allowedHeaders"`
+			MaxAge         int      `toml:"maxAge"`
+		}{
+			Enabled:        false,
+			AllowedOrigins: []string{},
+			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization"},
+			MaxAge:         600,
+		},
+		Access: struct {
+			WhiteList []string `toml:"whiteList"`
+			BlackList []string `toml:"blackList"`
+			Proxy     string   `toml:"proxy"`
+		}{
+			WhiteList: []string{},
+			BlackList: []string{},
+			Proxy:     "",
+		},
+		GitHub: struct {
+			Token                 string `toml:"token"`
+			TokenForAnonymous     bool   `toml:"tokenForAnonymous"`
+			HTMLPassthrough       bool   `toml:"htmlPassthrough"`
+			APICache              bool   `toml:"apiCache"`
+			APICacheTTL           string `toml:"apiCacheTTL"`
+			APIRateLimitFallback  bool   `toml:"apiRateLimitFallback"`
+			APIStaleFor           string `toml:"apiStaleFor"`
+			ArchiveConvert        bool   `toml:"archiveConvert"`
+			ArchiveConvertMaxSize int64  `toml:"archiveConvertMaxSize"`
+		}{
+			Token:                 "",
+			TokenForAnonymous:     false,
+			HTMLPassthrough:       false,
+			APICache:              true,
+			APICacheTTL:           "60s",
+			APIRateLimitFallback:  true,
+			APIStaleFor:           "24h",
+			ArchiveConvert:        true,
+			ArchiveConvertMaxSize: 512 * 1024 * 1024,
+		},
+		Download: struct {
+			MaxImages         int    `toml:"maxImages"`
+			MaxConcurrentJobs int    `toml:"maxConcurrentJobs"`
+			MaxJobsPerIP      int    `toml:"maxJobsPerIP"`
+			QueueSize         int    `toml:"queueSize"`
+			KeepaliveInterval string `toml:"keepaliveInterval"`
+		}{
+			MaxImages:         10,
+			MaxConcurrentJobs: 10,
+			MaxJobsPerIP:      2,
+			QueueSize:         10,
+			KeepaliveInterval: "2s",
+		},
+		Registries: map[string]RegistryMapping{
+			"ghcr.io": {
+				Upstream: "ghcr.io",
+				AuthHost: "ghcr.io/token",
+				AuthType: "github",
+				Enabled:  true,
+			},
+			"gcr.io": {
+				Upstream: "gcr.io",
+				AuthHost: "gcr.io/v2/token",
+				AuthType: "google",
+				Enabled:  true,
+			},
+			"quay.io": {
+				Upstream: "quay.io",
+				AuthHost: "quay.io/v2/auth",
+				AuthType: "quay",
+				Enabled:  true,
+			},
+			"registry.k8s.io": {
+				Upstream: "registry.k8s.io",
+				AuthHost: "registry.k8s.io",
+				AuthType: "anonymous",
+				Enabled:  true,
+				RedirectHosts: []string{
+					"*.s3.dualstack.*.amazonaws.com", "*.s3.*.amazonaws.com", "*.pkg.dev",
+					"storage.googleapis.com", "*.storage.googleapis.com", "*.fastly.net",
+				},
+				BackendCacheTTL: "10m",
+			},
+		},
+		Upstream: struct {
+			Timeouts struct {
+				Metadata       string `toml:"metadata"`
+				Connect        string `toml:"connect"`
+				TLSHandshake   string `toml:"tlsHandshake"`
+				ResponseHeader string `toml:"responseHeader"`
+				IdleProgress   string `toml:"idleProgress"`
+			} `toml:"timeouts"`
+			Headers struct {
+				Set            map[string]string      `toml:"set"`
+				Add            map[string]string      `toml:"add"`
+				Remove         []string               `toml:"remove"`
+				AllowSensitive bool                   `toml:"allowSensitive"`
+				Hosts          map[string]HeaderRules `toml:"hosts"`
+			} `toml:"headers"`
+			Limits struct {
+				Enabled           bool                     `toml:"enabled"`
+				RequestsPerSecond float64                  `toml:"requestsPerSecond"`
+				Burst             int                      `toml:"burst"`
+				MaxConcurrent     int                      `toml:"maxConcurrent"`
+				QueueTimeout      string                   `toml:"queueTimeout"`
+				Cooldown          string                   `toml:"cooldown"`
+				Hosts             map[string]UpstreamLimit `toml:"hosts"`
+			} `toml:"limits"`
+			DNS struct {
+				DoHURL      string            `toml:"dohURL"`
+				Bootstrap   string            `toml:"bootstrap"`
+				NegativeTTL string            `toml:"negativeTTL"`
+				Hosts       map[string]string `toml:"hosts"`
+			} `toml:"dns"`
+			TLS struct {
+				CABundle string `toml:"caBundle"`
+			} `toml:"tls"`
+			ResponseHeaders struct {
+				MaxBytes       int64 `toml:"maxBytes"`
+				MaxValueLength int   `toml:"maxValueLength"`
+				MaxCount       int   `toml:"maxCount"`
+			} `toml:"responseHeaders"`
+		}{
+			Timeouts: struct {
+				Metadata       string `toml:"metadata"`
+				Connect        string `toml:"connect"`
+				TLSHandshake   string `toml:"tlsHandshake"`
+				ResponseHeader string `toml:"responseHeader"`
+				IdleProgress   string `toml:"idleProgress"`
+			}{
+				Metadata:       "15s",
+				Connect:        "10s",
+				TLSHandshake:   "10s",
+				ResponseHeader: "60s",
+				IdleProgress:   "60s",
+			},
+			Headers: struct {
+				Set            map[string]string      `toml:"set"`
+				Add            map[string]string      `toml:"add"`
+				Remove         []string               `toml:"remove"`
+				AllowSensitive bool                   `toml:"allowSensitive"`
+				Hosts          map[string]HeaderRules `toml:"hosts"`
+			}{
+				Set:    map[string]string{},
+				Add:    map[string]string{},
+				Remove: []string{},
+				Hosts:  map[string]HeaderRules{},
+			},
+			Limits: struct {
+				Enabled           bool                     `toml:"enabled"`
+				RequestsPerSecond float64                  `toml:"requestsPerSecond"`
+				Burst             int                      `toml:"burst"`
+				MaxConcurrent     int                      `toml:"maxConcurrent"`
+				QueueTimeout      string                   `toml:"queueTimeout"`
+				Cooldown          string                   `toml:"cooldown"`
+				Hosts             map[string]UpstreamLimit `toml:"hosts"`
+			}{
+				Enabled:           true,
+				RequestsPerSecond: 20,
+				Burst:             40,
+				MaxConcurrent:     64,
+				QueueTimeout:      "3s",
+				Cooldown:          "60s",
+				Hosts: map[string]UpstreamLimit{
+					"api.github.com": {RequestsPerSecond: 5, Burst: 10},
+				},
+			},
+			DNS: struct {
+				DoHURL      string            `toml:"dohURL"`
+				Bootstrap   string            `toml:"bootstrap"`
+				NegativeTTL string            `toml:"negativeTTL"`
+				Hosts       map[string]string `toml:"hosts"`
+			}{
+				NegativeTTL: "30s",
+			},
+			TLS: struct {
+				CABundle string `toml:"caBundle"`
+			}{
+				CABundle: "",
+			},
+			ResponseHeaders: struct {
+				MaxBytes       int64 `toml:"maxBytes"`
+				MaxValueLength int   `toml:"maxValueLength"`
+				MaxCount       int   `toml:"maxCount"`
+			}{
+				MaxBytes:       1 << 20,
+				MaxValueLength: 8192,
+				MaxCount:       200,
+			},
+		},
+		APITimeouts: struct {
+			Search string `toml:"search"`
+			Stats  string `toml:"stats"`
+			GitHub string `toml:"github"`
+			Verify string `toml:"verify"`
+		}{
+			Search: "10s",
+			Stats:  "5s",
+			GitHub: "15s",
+			Verify: "120s",
+		},
+		TokenCache: struct {
+			Enabled         bool     `toml:"enabled"`
+			DefaultTTL      string   `toml:"defaultTTL"`
+			RefreshAhead    float64  `toml:"refreshAhead"`
+			HotRepositories []string `toml:"hotRepositories"`
+			RevalidateFor   string   `toml:"revalidateFor"`
+		}{
+			Enabled:         true,
+			DefaultTTL:      "20m",
+			RefreshAhead:    0.2,
+			HotRepositories: []string{},
+			RevalidateFor:   "24h",
+		},
+		DockerCache: struct {
+			NegativeTTL          string `toml:"negativeTTL"`
+			NegativeDigestTTL    string `toml:"negativeDigestTTL"`
+			StaleWhileRevalidate string `toml:"staleWhileRevalidate"`
+			StaleIfError         string `toml:"staleIfError"`
+		}{
+			NegativeTTL:          "20s",
+			NegativeDigestTTL:    "5m",
+			StaleWhileRevalidate: "10m",
+			StaleIfError:         "1h",
+		},
+		Cache: struct {
+			HonorClientDirectives bool `toml:"honorClientDirectives"`
+		}{
+			HonorClientDirectives: true,
+		},
+		Admin: struct {
+			Enabled           bool   `toml:"enabled"`
+			Token             string `toml:"token"`
+			RequestsPerMinute int    `toml:"requestsPerMinute"`
+			Listen            string `toml:"listen"`
+		}{
+			Enabled:           false,
+			Token:             "",
+			RequestsPerMinute: 60,
+		},
+		Auth: struct {
+			Mode       string            `toml:"mode"`
+			Realm      string            `toml:"realm"`
+			Users      map[string]string `toml:"users"`
+			TokenStore string            `toml:"tokenStore"`
+			OIDC       struct {
+				Issuer       string `toml:"issuer"`
+				Audience     string `toml:"audience"`
+				JWKSURL      string `toml:"jwksURL"`
+				JWKSCacheTTL string `toml:"jwksCacheTTL"`
+			} `toml:"oidc"`
+		}{
+			Mode:       "",
+			Realm:      "hubproxy",
+			Users:      map[string]string{},
+			TokenStore: "tokens.json",
+			OIDC: struct {
+				Issuer       string `toml:"issuer"`
+				Audience     string `toml:"audience"`
+				JWKSURL      string `toml:"jwksURL"`
+				JWKSCacheTTL string `toml:"jwksCacheTTL"`
+			}{
+				JWKSCacheTTL: "1h",
+			},
+		},
+		UI: struct {
+			Enabled            bool    `toml:"enabled"`
+			HeadlessMessage    string  `toml:"headlessMessage"`
+			ActivityFeed       bool    `toml:"activityFeed"`
+			AnonymizeIP        bool    `toml:"anonymizeIP"`
+			ActivitySampleRate float64 `toml:"activitySampleRate"`
+		}{
+			Enabled:            true,
+			ActivityFeed:       true,
+			AnonymizeIP:        true,
+			ActivitySampleRate: 1,
+		},
+		Privacy: struct {
+			NoIPLogging   bool `toml:"noIPLogging"`
+			RetentionDays int  `toml:"retentionDays"`
+		}{
+			NoIPLogging:   false,
+			RetentionDays: 0,
+		},
+		Debounce: struct {
+			Enabled             bool     `toml:"enabled"`
+			Window              string   `toml:"window"`
+			MaxWaiters          int      `toml:"maxWaiters"`
+			Classes             []string `toml:"classes"`
+			DownloadWindow      string   `toml:"downloadWindow"`
+			BatchDownloadWindow string   `toml:"batchDownloadWindow"`
+		}{
+			Enabled:             true,
+			Window:              "2s",
+			MaxWaiters:          100,
+			Classes:             []string{"token", "manifestHead", "tags"},
+			DownloadWindow:      "5s",
+			BatchDownloadWindow: "60s",
+		},
+		Schedule: struct {
+			Enabled          bool     `toml:"enabled"`
+			Timezone         string   `toml:"timezone"`
+			QuietHours       []string `toml:"quietHours"`
+			DailyBudgetBytes int64    `toml:"dailyBudgetBytes"`
+			ResetHour        int      `toml:"resetHour"`
+		}{
+			Enabled:          false,
+			Timezone:         "",
+			QuietHours:       []string{},
+			DailyBudgetBytes: 0,
+			ResetHour:        0,
+		},
+		History: struct {
+			Enabled       bool   `toml:"enabled"`
+			Path          string `toml:"path"`
+			RetentionDays int    `toml:"retentionDays"`
+		}{
+			Enabled:       false,
+			Path:          "history.jsonl",
+			RetentionDays: 30,
+		},
+		Signing: struct {
+			Enabled bool   `toml:"enabled"`
+			Key     string `toml:"key"`
+			KeyFile string `toml:"keyFile"`
+		}{
+			Enabled: false,
+			Key:     "",
+			KeyFile: "",
+		},
+		Limits: struct {
+			GitHub                  int64              `toml:"github"`
+			DockerBlob              int64              `toml:"dockerBlob"`
+			Tar                     int64              `toml:"tar"`
+			HuggingFace             int64              `toml:"huggingface"`
+			AuthenticatedMultiplier float64            `toml:"authenticatedMultiplier"`
+			Multipliers             map[string]float64 `toml:"multipliers"`
+			Overrides               map[string]int64   `toml:"overrides"`
+		}{
+			GitHub:                  0,
+			DockerBlob:              -1,
+			Tar:                     -1,
+			HuggingFace:             0,
+			AuthenticatedMultiplier: 1,
+			Multipliers:             map[string]float64{},
+			Overrides:               map[string]int64{},
+		},
+		HotCache: struct {
+			Enabled            bool  `toml:"enabled"`
+			MaxBytes           int64 `toml:"maxBytes"`
+			MaxObjectBytes     int64 `toml:"maxObjectBytes"`
+			PromoteAfter       int   `toml:"promoteAfter"`
+			PullBytesPerSecond int64 `toml:"pullBytesPerSecond"`
+		}{
+			Enabled:            true,
+			MaxBytes:           256 * 1024 * 1024,
+			MaxObjectBytes:     4 * 1024 * 1024,
+			PromoteAfter:       2,
+			PullBytesPerSecond: 50 * 1024 * 1024,
+		},
+		Memory: struct {
+			LimitBytes  int64   `toml:"limitBytes"`
+			BufferRatio float64 `toml:"bufferRatio"`
+		}{
+			LimitBytes:  0,
+			BufferRatio: 0.5,
+		},
+		Health: struct {
+			DegradedSuccessRate float64 `toml:"degradedSuccessRate"`
+			DownSuccessRate     float64 `toml:"downSuccessRate"`
+			DegradedP95Ms       float64 `toml:"degradedP95Ms"`
+			MinSamples          int     `toml:"minSamples"`
+			StatusPage          bool    `toml:"statusPage"`
+		}{
+			DegradedSuccessRate: 0.98,
+			DownSuccessRate:     0.8,
+			DegradedP95Ms:       0,
+			MinSamples:          20,
+			StatusPage:          true,
+		},
+		V2: struct {
+			Challenge string `toml:"challenge"`
+			Service   string `toml:"service"`
+		}{
+			Challenge: "anonymous",
+			Service:   "hubproxy",
+		},
+		Log: struct {
+			DedupWindow          string `toml:"dedupWindow"`
+			DedupThreshold       int    `toml:"dedupThreshold"`
+			SlowRequestThreshold string `toml:"slowRequestThreshold"`
+			SlowRequestSamples   int    `toml:"slowRequestSamples"`
+		}{
+			DedupWindow:          "1m",
+			DedupThreshold:       1,
+			SlowRequestThreshold: "10s",
+			SlowRequestSamples:   20,
+		},
+		Proxy: struct {
+			AccelConnections    int   `toml:"accelConnections"`
+			AccelMaxConnections int   `toml:"accelMaxConnections"`
+			AccelMinSize        int64 `toml:"accelMinSize"`
+			AccelChunkSize      int64 `toml:"accelChunkSize"`
+			MaxRedirects        int   `toml:"maxRedirects"`
+			ResponseHeaders     struct {
+				Strip []string          `toml:"strip"`
+				Keep  []string          `toml:"keep"`
+				Set   map[string]string `toml:"set"`
+			} `toml:"responseHeaders"`
+			Methods struct {
+				GitHub      []string `toml:"github"`
+				API         []string `toml:"api"`
+				AllowWrites bool     `toml:"allowWrites"`
+				Git         []string `toml:"git"`
+				HuggingFace []string `toml:"huggingface"`
+			} `toml:"methods"`
+			Docker struct {
+				AutoLowercase bool `toml:"autoLowercase"`
+			} `toml:"docker"`
+		}{
+			AccelConnections:    0,
+			AccelMaxConnections: 8,
+			AccelMinSize:        32 * 1024 * 1024,
+			AccelChunkSize:      4 * 1024 * 1024,
+			MaxRedirects:        10,
+			ResponseHeaders: struct {
+				Strip []string          `toml:"strip"`
+				Keep  []string          `toml:"keep"`
+				Set   map[string]string `toml:"set"`
+			}{
+				Strip: []string{"Content-Security-Policy", "Referrer-Policy", "Strict-Transport-Security"},
+				Keep:  []string{},
+				Set:   map[string]string{},
+			},
+			Methods: struct {
+				GitHub      []string `toml:"github"`
+				API         []string `toml:"api"`
+				AllowWrites bool     `toml:"allowWrites"`
+				Git         []string `toml:"git"`
+				HuggingFace []string `toml:"huggingface"`
+			}{
+				GitHub:      []string{"GET", "HEAD"},
+				API:         []string{"GET", "HEAD"},
+				Git:         []string{"GET", "POST"},
+				HuggingFace: []string{"GET", "HEAD"},
+			},
+		},
+	}
+}
+
+// GetConfig 安全地获取LoadConfig加载的配置副本，server.New未传入配置时以它创建服务；
+// 已创建的服务读取各自的Store，不受之后的加载影响
+func GetConfig() *AppConfig {
+	configCacheMutex.RLock()
+	if cachedConfig != nil && time.Since(configCacheTime) < configCacheTTL {
+		config := cachedConfig
+		configCacheMutex.RUnlock()
+		return config
+	}
+	configCacheMutex.RUnlock()
+
+	configCacheMutex.Lock()
+	defer configCacheMutex.Unlock()
+
+	if cachedConfig != nil && time.Since(configCacheTime) < configCacheTTL {
+		return cachedConfig
+	}
+
+	appConfigLock.RLock()
+	if appConfig == nil {
+		appConfigLock.RUnlock()
+		defaultCfg := DefaultConfig()
+		cachedConfig = defaultCfg
+		configCacheTime = time.Now()
+		return defaultCfg
+	}
+
+	configCopy := *appConfig
+	configCopy.Server.TrustedProxies = append([]string(nil), appConfig.Server.TrustedProxies...)
+	configCopy.RateLimit.ExemptPaths = append([]string(nil), appConfig.RateLimit.ExemptPaths...)
+	configCopy.Security.WhiteList = append([]string(nil), appConfig.Security.WhiteList...)
+	configCopy.Security.BlackList = append([]string(nil), appConfig.Security.BlackList...)
+	configCopy.Security.Referer.Allowed = append([]string(nil), appConfig.Security.Referer.Allowed...)
+	configCopy.Security.Crawlers.Patterns = append([]string(nil), appConfig.Security.Crawlers.Patterns...)
+	configCopy.CORS.AllowedOrigins = append([]string(nil), appConfig.CORS.AllowedOrigins...)
+	configCopy.CORS.AllowedMethods = append([]string(nil), appConfig.CORS.AllowedMethods...)
+	configCopy.CORS.AllowedHeaders = append([]string(nil), appConfig.CORS.AllowedHeaders...)
+	configCopy.Access.WhiteList = append([]string(nil), appConfig.Access.WhiteList...)
+	configCopy.Access.BlackList = append([]string(nil), appConfig.Access.BlackList...)
+	configCopy.TokenCache.HotRepositories = append([]string(nil), appConfig.TokenCache.HotRepositories...)
+	configCopy.Debounce.Classes = append([]string(nil), appConfig.Debounce.Classes...)
+	configCopy.Schedule.QuietHours = append([]string(nil), appConfig.Schedule.QuietHours...)
+	configCopy.Upstream.Headers.Remove = append([]string(nil), appConfig.Upstream.Headers.Remove...)
+	configCopy.Proxy.ResponseHeaders.Strip = append([]string(nil), appConfig.Proxy.ResponseHeaders.Strip...)
+	configCopy.Proxy.ResponseHeaders.Keep = append([]string(nil), appConfig.Proxy.ResponseHeaders.Keep...)
+	configCopy.Proxy.Methods.GitHub = append([]string(nil), appConfig.Proxy.Methods.GitHub...)
+	configCopy.Proxy.Methods.API = append([]string(nil), appConfig.Proxy.Methods.API...)
+	configCopy.Proxy.Methods.Git = append([]string(nil), appConfig.Proxy.Methods.Git...)
+	configCopy.Proxy.Methods.HuggingFace = append([]string(nil), appConfig.Proxy.Methods.HuggingFace...)
+	appConfigLock.RUnlock()
+
+	cachedConfig = &configCopy
+	configCacheTime = time.Now()
+
+	return cachedConfig
+}
+
+// setConfig 安全地设置配置
+func setConfig(cfg *AppConfig) {
+	appConfigLock.Lock()
+	defer appConfigLock.Unlock()
+	appConfig = cfg
+
+	configCacheMutex.Lock()
+	cachedConfig = nil
+	configCacheMutex.Unlock()
+}
+
+// Apply 使用调用方传入的配置替换LoadConfig加载的配置，不影响已创建的服务
+func Apply(cfg *AppConfig) {
+	setConfig(cfg)
+}
+
+// Store 一个服务实例的当前配置，热重载时由该服务的Reloader整体替换，
+// 同一进程中的多个服务各自持有，互不影响。Get返回的配置只读，不得修改
+type Store struct {
+	current atomic.Pointer[AppConfig]
+}
+
+// NewStore 创建持有cfg的Store，cfg为nil时使用默认配置
+func NewStore(cfg *AppConfig) *Store {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	s := &Store{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Get 返回当前配置
+func (s *Store) Get() *AppConfig {
+	return s.current.Load()
+}
+
+// Set 替换当前配置
+func (s *Store) Set(cfg *AppConfig) {
+	s.current.Store(cfg)
+}
+
+func configFilePath() string {
+	if path := strings.TrimSpace(os.Getenv("CONFIG_PATH")); path != "" {
+		return path
+	}
+	return "config.toml"
+}
+
+// LoadConfig 读取并校验配置文件，全部通过后才替换当前配置
+func LoadConfig() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	setConfig(cfg)
+	return nil
+}
+
+// readConfig 读取配置文件并叠加环境变量，返回完整校验通过的新配置，不修改当前配置
+func readConfig() (*AppConfig, error) {
+	cfg := DefaultConfig()
+	path := configFilePath()
+
+	if data, err := os.ReadFile(path); err == nil {
+		if err := toml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	} else {
+		fmt.Printf("未找到配置文件 %s，使用默认配置\n", path)
+	}
+
+	overrideFromEnv(cfg)
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("配置文件 %s 无效: %w", path, err)
+	}
+	issues := CheckConsistency(cfg)
+	for _, issue := range issues {
+		fmt.Printf("配置检查: %s\n", issue)
+	}
+	if cfg.Server.StrictConfig && len(issues) > 0 {
+		return nil, fmt.Errorf("配置文件 %s 存在 %d 处冲突，已开启server.strictConfig，拒绝应用", path, len(issues))
+	}
+	return cfg, nil
+}
+
+// checkPathPattern 检查限流路径规则：以/开头的精确路径，以*结尾的前缀，或path.Match风格的通配符
+func checkPathPattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("路径 %q 必须以/开头", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("路径 %q 无效: %v", pattern, err)
+	}
+	return nil
+}
+
+// validateConfig 检查无法在使用时安全回退的配置项
+func validateConfig(cfg *AppConfig) error {
+	zones := []struct{ key, name string }{
+		{"server.timezone", cfg.Server.Timezone},
+		{"schedule.timezone", cfg.Schedule.Timezone},
+	}
+	for _, zone := range zones {
+		if _, err := LoadTimezone(zone.name); err != nil {
+			return fmt.Errorf("%s = %q 不是有效的IANA时区名称(如 UTC、Asia/Shanghai、America/New_York)", zone.key, zone.name)
+		}
+	}
+
+	if h := cfg.Upstream.ResponseHeaders; h.MaxBytes < 4096 || h.MaxValueLength <= 0 || h.MaxCount <= 0 {
+		return fmt.Errorf("upstream.responseHeaders 无效: maxBytes 至少为4096，maxValueLength 和 maxCount 必须大于0")
+	}
+
+	methodLists := []struct {
+		key     string
+		methods []string
+	}{
+		{"proxy.methods.github", cfg.Proxy.Methods.GitHub},
+		{"proxy.methods.api", cfg.Proxy.Methods.API},
+		{"proxy.methods.git", cfg.Proxy.Methods.Git},
+		{"proxy.methods.huggingface", cfg.Proxy.Methods.HuggingFace},
+	}
+	for _, list := range methodLists {
+		if len(list.methods) == 0 {
+			return fmt.Errorf("%s 不能为空，至少允许GET", list.key)
+		}
+		for _, method := range list.methods {
+			if method == "" || strings.IndexFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+				return fmt.Errorf("%s 中的 %q 不是有效的HTTP方法，应为大写，如 GET、HEAD、POST", list.key, method)
+			}
+		}
+	}
+
+	if bundle := cfg.Upstream.TLS.CABundle; bundle != "" {
+		data, err := os.ReadFile(bundle)
+		if err != nil {
+			return fmt.Errorf("upstream.tls.caBundle 无法读取: %v", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return fmt.Errorf("upstream.tls.caBundle 中没有有效的PEM证书: %s", bundle)
+		}
+	}
+	if cfg.Memory.LimitBytes < 0 {
+		return fmt.Errorf("memory.limitBytes = %d 无效，0为沿用GOMEMLIMIT", cfg.Memory.LimitBytes)
+	}
+	if cfg.Memory.BufferRatio <= 0 || cfg.Memory.BufferRatio > 1 {
+		return fmt.Errorf("memory.bufferRatio = %g 无效，应大于0且不超过1", cfg.Memory.BufferRatio)
+	}
+	for _, pattern := range cfg.RateLimit.ExemptPaths {
+		if err := checkPathPattern(pattern); err != nil {
+			return fmt.Errorf("rateLimit.exemptPaths: %w", err)
+		}
+	}
+	for pattern, class := range cfg.RateLimit.PathClasses {
+		if err := checkPathPattern(pattern); err != nil {
+			return fmt.Errorf("rateLimit.pathClasses: %w", err)
+		}
+		if _, exists := cfg.RateLimit.Classes[class]; !exists {
+			return fmt.Errorf("rateLimit.pathClasses: %s 指向未定义的限流类别 %q", pattern, class)
+		}
+	}
+	for name, class := range cfg.RateLimit.Classes {
+		if class.RequestLimit <= 0 || class.PeriodHours <= 0 {
+			return fmt.Errorf("rateLimit.classes.%s: requestLimit和periodHours必须大于0", name)
+		}
+	}
+	for name, network := range cfg.RateLimit.SharedNetworks {
+		if len(network.CIDRs) == 0 {
+			return fmt.Errorf("rateLimit.sharedNetworks.%s: cidrs不能为空", name)
+		}
+		for _, cidr := range network.CIDRs {
+			if !validIPOrCIDR(cidr) {
+				return fmt.Errorf("rateLimit.sharedNetworks.%s: 无效的IP或CIDR %q", name, cidr)
+			}
+		}
+		if network.Multiplier < 1 {
+			return fmt.Errorf("rateLimit.sharedNetworks.%s: multiplier = %g 无效，应不小于1", name, network.Multiplier)
+		}
+		if strings.ContainsAny(network.UserHeader, " :\t") {
+			return fmt.Errorf("rateLimit.sharedNetworks.%s: userHeader = %q 不是有效的请求头名称", name, network.UserHeader)
+		}
+	}
+	if cfg.RateLimit.QuotaWarnPercent < 0 || cfg.RateLimit.QuotaWarnPercent > 100 {
+		return fmt.Errorf("rateLimit.quotaWarnPercent = %d 无效，应在0到100之间，0为不提示", cfg.RateLimit.QuotaWarnPercent)
+	}
+	if !validV2Challenge(cfg.V2.Challenge) {
+		return fmt.Errorf("v2.challenge = %q 无效，可选值: anonymous、token", cfg.V2.Challenge)
+	}
+	for domain, mapping := range cfg.Registries {
+		if !validV2Challenge(mapping.Challenge) {
+			return fmt.Errorf("registries.%q.challenge = %q 无效，可选值: anonymous、token，为空时使用v2.challenge", domain, mapping.Challenge)
+		}
+		for _, host := range mapping.RedirectHosts {
+			if _, err := path.Match(host, ""); err != nil || host == "" {
+				return fmt.Errorf("registries.%q.redirectHosts 中的 %q 无效", domain, host)
+			}
+		}
+		if ttl := mapping.BackendCacheTTL; ttl != "" {
+			if d, err := time.ParseDuration(ttl); err != nil || d < 0 {
+				return fmt.Errorf("registries.%q.backendCacheTTL = %q 无效，应为时长，如 10m，0为不缓存", domain, ttl)
+			}
+		}
+	}
+	if interval := cfg.Download.KeepaliveInterval; interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < 0 {
+			return fmt.Errorf("download.keepaliveInterval = %q 无效，应为时长，如 2s，0为关闭", interval)
+		}
+	}
+	if threshold := cfg.Log.SlowRequestThreshold; threshold != "" {
+		if d, err := time.ParseDuration(threshold); err != nil || d < 0 {
+			return fmt.Errorf("log.slowRequestThreshold = %q 无效，应为时长，如 10s，0为关闭", threshold)
+		}
+	}
+	if cfg.Log.SlowRequestSamples < 0 {
+		return fmt.Errorf("log.slowRequestSamples 不能为负数")
+	}
+	return nil
+}
+
+// /v2/ 基础端点的应答方式
+const (
+	V2ChallengeAnonymous = "anonymous"
+	V2ChallengeToken     = "token"
+)
+
+// validV2Challenge 判断challenge取值是否有效，为空表示使用默认值
+func validV2Challenge(challenge string) bool {
+	switch strings.ToLower(strings.TrimSpace(challenge)) {
+	case "", V2ChallengeAnonymous, V2ChallengeToken:
+		return true
+	}
+	return false
+}
+
+// validIPOrCIDR 判断是否为单个IP或CIDR网段
+func validIPOrCIDR(value string) bool {
+	value = strings.TrimSpace(value)
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(value)
+	return err == nil
+}
+
+// LoadTimezone 按IANA名称加载时区，为空时使用系统本地时区
+func LoadTimezone(name string) (*time.Location, error) {
+	if name = strings.TrimSpace(name); name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Reloadable 配置重载后需要更新自身状态的组件，由持有组件的Server注册到其Reloader
+type Reloadable interface {
+	// ReloadSections 返回关注的顶层配置段(如 registries、proxy)，为空时任一配置段变化都会通知
+	ReloadSections() []string
+	// Reload 新配置生效后调用，old为重载前的配置
+	Reload(old, updated *AppConfig)
+}
+
+// ReloadFunc 以单个函数实现Reloadable
+type ReloadFunc struct {
+	Sections []string
+	Fn       func(old, updated *AppConfig)
+}
+
+// ReloadSections 返回关注的顶层配置段
+func (f ReloadFunc) ReloadSections() []string { return f.Sections }
+
+// Reload 调用Fn
+func (f ReloadFunc) Reload(old, updated *AppConfig) { f.Fn(old, updated) }
+
+// Reloader 重载配置到所属服务的Store，并按注册顺序通知受影响的组件
+type Reloader struct {
+	store      *Store
+	mu         sync.Mutex
+	components []Reloadable
+
+	statusMu sync.Mutex
+	status   ReloadStatus
+}
+
+// NewReloader 创建重载到store的Reloader
+func NewReloader(store *Store) *Reloader {
+	return &Reloader{store: store}
+}
+
+// Register 注册配置重载后需要更新的组件
+func (r *Reloader) Register(components ...Reloadable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components = append(r.components, components...)
+}
+
+// Reload 重新读取配置文件，完整校验通过后一次性替换Store中的配置，再通知关注变化配置段的组件；
+// 读取或校验失败时保留原配置，不通知任何组件，失败原因记录在LastReload中
+func (r *Reloader) Reload() error {
+	oldCfg := r.store.Get()
+	newCfg, err := readConfig()
+	if err != nil {
+		r.record(err, nil)
+		return err
+	}
+	changed := ChangedSections(oldCfg, newCfg)
+	r.store.Set(newCfg)
+	r.record(nil, changed)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	components := make([]Reloadable, 0, len(r.components))
+	for _, component := range r.components {
+		if affected(component.ReloadSections(), changed) {
+			components = append(components, component)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, component := range components {
+		component.Reload(oldCfg, newCfg)
+	}
+	return nil
+}
+
+// LastReload 返回该Reloader最近一次重载的结果
+func (r *Reloader) LastReload() ReloadStatus {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return r.status.clone()
+}
+
+func (r *Reloader) record(err error, changed []string) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	r.status = r.status.next(err, changed)
+}
+
+// affected 判断组件关注的配置段是否在changed中，sections为空时任一配置段变化都算
+func affected(sections, changed []string) bool {
+	if len(changed) == 0 {
+		return false
+	}
+	if len(sections) == 0 {
+		return true
+	}
+	for _, section := range sections {
+		if slices.Contains(changed, section) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	lastReload   ReloadStatus
+	lastReloadMu sync.Mutex
+)
+
+// ReloadStatus 最近一次配置重载的时间和结果，启动后未重载过时At为零值。
+// Changed为该次重载实际变化的顶层配置段，Succeeded和Failed为启动以来重载成功和被拒绝的次数
+type ReloadStatus struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Changed   []string  `json:"changed,omitempty"`
+	Succeeded uint64    `json:"succeeded"`
+	Failed    uint64    `json:"failed"`
+}
+
+// LastReload 返回最近一次ReloadConfig的结果
+func LastReload() ReloadStatus {
+	lastReloadMu.Lock()
+	defer lastReloadMu.Unlock()
+	return lastReload.clone()
+}
+
+func recordReload(err error, changed []string) {
+	lastReloadMu.Lock()
+	defer lastReloadMu.Unlock()
+	lastReload = lastReload.next(err, changed)
+}
+
+func (s ReloadStatus) clone() ReloadStatus {
+	s.Changed = append([]string(nil), s.Changed...)
+	return s
+}
+
+// next 返回记录一次重载结果后的状态，累计次数在s的基础上增加
+func (s ReloadStatus) next(err error, changed []string) ReloadStatus {
+	status := ReloadStatus{At: time.Now(), OK: err == nil, Succeeded: s.Succeeded, Failed: s.Failed}
+	if err != nil {
+		status.Error = err.Error()
+		status.Failed++
+	} else {
+		status.Changed = changed
+		status.Succeeded++
+	}
+	return status
+}
+
+// ReloadConfig 重新读取配置文件，完整校验通过后一次性替换全局配置，不通知任何组件；
+// 读取或校验失败时保留原配置，失败原因记录在LastReload中
+func ReloadConfig() error {
+	newCfg, err := readConfig()
+	if err != nil {
+		recordReload(err, nil)
+		return err
+	}
+	changed := ChangedSections(GetConfig(), newCfg)
+	setConfig(newCfg)
+	recordReload(nil, changed)
+	return nil
+}
+
+// overrideFromEnv 从环境变量覆盖配置
+func overrideFromEnv(cfg *AppConfig) {
+	if val := os.Getenv("SERVER_HOST"); val != "" {
+		cfg.Server.Host = val
+	}
+	if val := os.Getenv("SERVER_PORT"); val != "" {
+		if port, err := strconv.Atoi(val); err == nil && port > 0 {
+			cfg.Server.Port = port
+		}
+	}
+	if val := os.Getenv("ENABLE_H2C"); val != "" {
+		if enable, err := strconv.ParseBool(val); err == nil {
+			cfg.Server.EnableH2C = enable
+		}
+	}
+	if val := os.Getenv("ENABLE_FRONTEND"); val != "" {
+		if enable, err := strconv.ParseBool(val); err == nil {
+			cfg.Server.EnableFrontend = enable
+		}
+	}
+	if val := os.Getenv("SERVER_LANGUAGE"); val != "" {
+		cfg.Server.Language = val
+	}
+	if val := os.Getenv("MAX_FILE_SIZE"); val != "" {
+		if size, err := strconv.ParseInt(val, 10, 64); err == nil && size > 0 {
+			cfg.Server.FileSize = size
+		}
+	}
+
+	if val := os.Getenv("RATE_LIMIT"); val != "" {
+		if limit, err := strconv.Atoi(val); err == nil && limit > 0 {
+			cfg.RateLimit.RequestLimit = limit
+		}
+	}
+	if val := os.Getenv("RATE_PERIOD_HOURS"); val != "" {
+		if period, err := strconv.ParseFloat(val, 64); err == nil && period > 0 {
+			cfg.RateLimit.PeriodHours = period
+		}
+	}
+
+	if val := os.Getenv("IP_WHITELIST"); val != "" {
+		cfg.Security.WhiteList = append(cfg.Security.WhiteList, strings.Split(val, ",")...)
+	}
+	if val := os.Getenv("IP_BLACKLIST"); val != "" {
+		cfg.Security.BlackList = append(cfg.Security.BlackList, strings.Split(val, ",")...)
+	}
+
+	if val, ok := os.LookupEnv("ACCESS_PROXY"); ok {
+		cfg.Access.Proxy = strings.TrimSpace(val)
+	}
+
+	if val := os.Getenv("GITHUB_TOKEN"); val != "" {
+		cfg.GitHub.Token = val
+	}
+
+	if val := os.Getenv("MAX_IMAGES"); val != "" {
+		if maxImages, err := strconv.Atoi(val); err == nil && maxImages > 0 {
+			cfg.Download.MaxImages = maxImages
+		}
+	}
+	if val := os.Getenv("MAX_CONCURRENT_JOBS"); val != "" {
+		if maxJobs, err := strconv.Atoi(val); err == nil && maxJobs >= 0 {
+			cfg.Download.MaxConcurrentJobs = maxJobs
+		}
+	}
+	if val := os.Getenv("MAX_JOBS_PER_IP"); val != "" {
+		if maxJobs, err := strconv.Atoi(val); err == nil && maxJobs >= 0 {
+			cfg.Download.MaxJobsPerIP = maxJobs
+		}
+	}
+
+	if val := os.Getenv("ADMIN_TOKEN"); val != "" {
+		cfg.Admin.Token = val
+		cfg.Admin.Enabled = true
+	}
+
+	if val := os.Getenv("AUTH_MODE"); val != "" {
+		cfg.Auth.Mode = strings.ToLower(strings.TrimSpace(val))
+	}
+}
+
+// CreateDefaultConfigFile 创建默认配置文件
+func CreateDefaultConfigFile() error {
+	cfg := DefaultConfig()
+
+	data, err := toml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("序列化默认配置失败: %v", err)
+	}
+
+	return os.WriteFile("config.toml", data, 0644)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigUsesConfigPathAndEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.toml")
+	data := []byte(`
+[server]
+host = "127.0.0.1"
+port = 5999
+
+[access]
+proxy = "socks5://127.0.0.1:1080"
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_PATH", path)
+	t.Setenv("SERVER_PORT", "6001")
+	t.Setenv("ACCESS_PROXY", "")
+
+	if err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := GetConfig()
+	if cfg.Server.Host != "127.0.0.1" {
+		t.Fatalf("Server.Host = %q", cfg.Server.Host)
+	}
+	if cfg.Server.Port != 6001 {
+		t.Fatalf("Server.Port = %d, want 6001", cfg.Server.Port)
+	}
+	if cfg.Access.Proxy != "" {
+		t.Fatalf("Access.Proxy = %q, want empty override", cfg.Access.Proxy)
+	}
+}
+
+func TestLoadConfigRejectsInvalidTimezone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+
+	if err := os.WriteFile(path, []byte("[server]\ntimezone = \"America/New_York\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{
+		"[server]\ntimezone = \"Mars/Olympus_Mons\"\n",
+		"[schedule]\ntimezone = \"Beijing\"\n",
+	} {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := ReloadConfig()
+		if err == nil || !strings.Contains(err.Error(), "timezone") || !strings.Contains(err.Error(), "IANA") {
+			t.Fatalf("invalid zone %q: err = %v", body, err)
+		}
+		if status := LastReload(); status.OK || status.Error != err.Error() {
+			t.Fatalf("last reload = %+v", status)
+		}
+	}
+	// 重载失败时保留原配置
+	if zone := GetConfig().Server.Timezone; zone != "America/New_York" {
+		t.Fatalf("timezone after failed reload = %q", zone)
+	}
+}
+
+func TestLoadConfigRejectsInvalidV2Challenge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+
+	for body, key := range map[string]string{
+		"[v2]\nchallenge = \"basic\"\n": "v2.challenge",
+		"[registries.\"ghcr.io\"]\nupstream = \"ghcr.io\"\nchallenge = \"bearer\"\nenabled = true\n": `registries."ghcr.io".challenge`,
+	} {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadConfig(); err == nil || !strings.Contains(err.Error(), key) {
+			t.Fatalf("%q: err = %v", body, err)
+		}
+	}
+}
+
+func TestLoadConfigRejectsInvalidRateLimitPaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+
+	for body, key := range map[string]string{
+		"[rateLimit]\nexemptPaths = [\"public/*\"]\n":                                                                 "rateLimit.exemptPaths",
+		"[rateLimit]\nexemptPaths = [\"/public/[\"]\n":                                                                "rateLimit.exemptPaths",
+		"[rateLimit.pathClasses]\n\"/api/*\" = \"api\"\n":                                                             "rateLimit.pathClasses",
+		"[rateLimit.classes.api]\nrequestLimit = 10\n":                                                                "rateLimit.classes.api",
+		"[rateLimit.pathClasses]\n\"/api/*\" = \"api\"\n[rateLimit.classes.api]\nrequestLimit = 0\nperiodHours = 1\n": "rateLimit.classes.api",
+		"[rateLimit.sharedNetworks.campus]\nmultiplier = 10\n":                                                        "rateLimit.sharedNetworks.campus",
+		"[rateLimit.sharedNetworks.campus]\ncidrs = [\"203.0.113.0/33\"]\nmultiplier = 10\n":                          "rateLimit.sharedNetworks.campus",
+		"[rateLimit.sharedNetworks.campus]\ncidrs = [\"203.0.113.0/24\"]\nmultiplier = 0.5\n":                         "rateLimit.sharedNetworks.campus",
+		"[proxy.methods]\ngithub = []\n":                                                                              "proxy.methods.github",
+		"[proxy.methods]\napi = [\"get\"]\n":                                                                          "proxy.methods.api",
+	} {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadConfig(); err == nil || !strings.Contains(err.Error(), key) {
+			t.Fatalf("%q: err = %v", body, err)
+		}
+	}
+}
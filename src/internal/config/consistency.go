@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 配置一致性问题代码，取值固定，可用于日志检索和文档对照
+const (
+	IssueRegistryMissingUpstream   = "REGISTRY_MISSING_UPSTREAM"
+	IssueRegistryDuplicateUpstream = "REGISTRY_DUPLICATE_UPSTREAM"
+	IssueRegistryShadowsDockerHub  = "REGISTRY_SHADOWS_DOCKER_HUB"
+	IssueRegistryShadowsNamespace  = "REGISTRY_SHADOWS_NAMESPACE"
+	IssueRegistryPrefixOverlap     = "REGISTRY_PREFIX_OVERLAP"
+	IssueRegistryAuthUnsupported   = "REGISTRY_AUTH_UNSUPPORTED"
+	IssueBasePathInvalid           = "BASEPATH_INVALID"
+	IssueBasePathShadowsRoute      = "BASEPATH_SHADOWS_ROUTE"
+)
+
+// ConfigIssue 一条配置一致性问题
+type ConfigIssue struct {
+	Code    string `json:"code"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s", i.Code, i.Key, i.Message)
+}
+
+// dockerHubDomains Docker Hub的域名，未带域名的镜像默认由Docker Hub处理
+var dockerHubDomains = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// reservedRouteSegments 内置路由的第一段路径，basePath不能与之相同，否则未去掉前缀的请求会被内置路由处理
+var reservedRouteSegments = map[string]bool{
+	"v2": true, "token": true, "api": true, "admin": true, "public": true, "search": true, "tags": true,
+	"health": true, "ready": true, "status": true, "metrics": true, "install.sh": true, "robots.txt": true,
+}
+
+// registryAuthHosts 各认证类型适用的上游主机，主机为该域名或其子域名时适用
+var registryAuthHosts = map[string][]string{
+	"github": {"ghcr.io"},
+	"google": {"gcr.io", "pkg.dev"},
+	"quay":   {"quay.io"},
+}
+
+// registryHost 取上游地址中的主机名，忽略协议、端口和路径
+func registryHost(upstream string) string {
+	upstream = strings.TrimSpace(strings.ToLower(upstream))
+	if !strings.Contains(upstream, "://") {
+		upstream = "https://" + upstream
+	}
+	parsed, err := url.Parse(upstream)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// hostMatches 判断host是否为domain或其子域名
+func hostMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// checkRegistries 检查Registry之间以及与内置Docker Hub处理的冲突
+func checkRegistries(cfg *AppConfig) []ConfigIssue {
+	var issues []ConfigIssue
+	domains := make([]string, 0, len(cfg.Registries))
+	for domain, mapping := range cfg.Registries {
+		if mapping.Enabled {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+
+	upstreams := make(map[string]string)
+	for _, domain := range domains {
+		mapping := cfg.Registries[domain]
+		key := fmt.Sprintf("registries.%q", domain)
+		host := registryHost(mapping.Upstream)
+
+		if host == "" {
+			issues = append(issues, ConfigIssue{IssueRegistryMissingUpstream, key, "已启用但未配置有效的upstream"})
+		} else if other, exists := upstreams[host]; exists {
+			issues = append(issues, ConfigIssue{IssueRegistryDuplicateUpstream, key,
+				fmt.Sprintf("上游 %s 已由 registries.%q 使用，同一镜像会以两个地址分别缓存和限流", host, other)})
+		} else {
+			upstreams[host] = domain
+		}
+
+		name, _, _ := strings.Cut(domain, "/")
+		switch {
+		case dockerHubDomains[name]:
+			issues = append(issues, ConfigIssue{IssueRegistryShadowsDockerHub, key,
+				"只对带 " + domain + "/ 前缀的镜像生效，未带域名的镜像(如 nginx)仍直接请求Docker Hub"})
+		case !strings.ContainsAny(name, ".:") && name != "localhost":
+			issues = append(issues, ConfigIssue{IssueRegistryShadowsNamespace, key,
+				fmt.Sprintf("不是域名，会截获Docker Hub上 %s/ 命名空间下的所有镜像", name)})
+		}
+
+		for _, other := range domains {
+			if other != domain && strings.HasPrefix(domain, other+"/") {
+				issues = append(issues, ConfigIssue{IssueRegistryPrefixOverlap, key,
+					fmt.Sprintf("与 registries.%q 前缀重叠，按最长前缀匹配，%s/ 下其余镜像仍由后者处理", other, other)})
+			}
+		}
+
+		switch authType := strings.ToLower(strings.TrimSpace(mapping.AuthType)); authType {
+		case "", "anonymous":
+		case "github", "google", "quay":
+			supported := false
+			for _, domain := range registryAuthHosts[authType] {
+				supported = supported || hostMatches(host, domain)
+			}
+			if host != "" && !supported {
+				issues = append(issues, ConfigIssue{IssueRegistryAuthUnsupported, key,
+					fmt.Sprintf("authType %q 只适用于 %s，不适用于上游 %s", authType, strings.Join(registryAuthHosts[authType], "、"), host)})
+			}
+		default:
+			issues = append(issues, ConfigIssue{IssueRegistryAuthUnsupported, key,
+				fmt.Sprintf("未知的authType %q，可选值: anonymous、github、google、quay", mapping.AuthType)})
+		}
+	}
+	return issues
+}
+
+// checkBasePath 检查server.basePath的格式以及是否与内置路由冲突
+func checkBasePath(cfg *AppConfig) []ConfigIssue {
+	basePath := strings.Trim(strings.TrimSpace(cfg.Server.BasePath), "/")
+	if basePath == "" {
+		return nil
+	}
+	const key = "server.basePath"
+	if strings.ContainsAny(basePath, "?#%\\ \t") || strings.Contains(basePath, "//") {
+		return []ConfigIssue{{IssueBasePathInvalid, key, fmt.Sprintf("%q 应为 /hub 形式的路径，不能包含查询参数、空白或连续的/", cfg.Server.BasePath)}}
+	}
+	first, _, _ := strings.Cut(basePath, "/")
+	if reservedRouteSegments[strings.ToLower(first)] || net.ParseIP(first) != nil || strings.Contains(first, ".") {
+		return []ConfigIssue{{IssueBasePathShadowsRoute, key,
+			fmt.Sprintf("/%s 与内置路由或GitHub加速链接冲突，反向代理未去掉前缀时请求会被错误处理", first)}}
+	}
+	return nil
+}
+
+// CheckConsistency 检查Registry、basePath等配置之间的冲突，返回的问题按配置项排序
+func CheckConsistency(cfg *AppConfig) []ConfigIssue {
+	issues := append(checkRegistries(cfg), checkBasePath(cfg)...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Key != issues[j].Key {
+			return issues[i].Key < issues[j].Key
+		}
+		return issues[i].Code < issues[j].Code
+	})
+	return issues
+}
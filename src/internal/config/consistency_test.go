@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	if issues := CheckConsistency(DefaultConfig()); len(issues) != 0 {
+		t.Fatalf("default config issues = %v", issues)
+	}
+
+	registry := func(upstream, authType string) RegistryMapping {
+		return RegistryMapping{Upstream: upstream, AuthHost: upstream + "/token", AuthType: authType, Enabled: true}
+	}
+	tests := []struct {
+		name       string
+		registries map[string]RegistryMapping
+		basePath   string
+		want       map[string]string
+	}{
+		{
+			name:       "missing upstream",
+			registries: map[string]RegistryMapping{"example.com": registry("", "anonymous")},
+			want:       map[string]string{`registries."example.com"`: IssueRegistryMissingUpstream},
+		},
+		{
+			name: "duplicate upstream",
+			registries: map[string]RegistryMapping{
+				"ghcr.io":        registry("ghcr.io", "github"),
+				"ghcr.mirror.cn": registry("https://GHCR.io:443", "github"),
+			},
+			want: map[string]string{`registries."ghcr.mirror.cn"`: IssueRegistryDuplicateUpstream},
+		},
+		{
+			name:       "shadows docker hub",
+			registries: map[string]RegistryMapping{"docker.io": registry("mirror.example.com", "anonymous")},
+			want:       map[string]string{`registries."docker.io"`: IssueRegistryShadowsDockerHub},
+		},
+		{
+			name:       "shadows namespace",
+			registries: map[string]RegistryMapping{"library": registry("mirror.example.com", "anonymous")},
+			want:       map[string]string{`registries."library"`: IssueRegistryShadowsNamespace},
+		},
+		{
+			name: "prefix overlap",
+			registries: map[string]RegistryMapping{
+				"quay.io":        registry("quay.io", "quay"),
+				"quay.io/coreos": registry("mirror.example.com", "anonymous"),
+			},
+			want: map[string]string{`registries."quay.io/coreos"`: IssueRegistryPrefixOverlap},
+		},
+		{
+			name:       "auth type for another host",
+			registries: map[string]RegistryMapping{"harbor.example.com": registry("harbor.example.com", "github")},
+			want:       map[string]string{`registries."harbor.example.com"`: IssueRegistryAuthUnsupported},
+		},
+		{
+			name:       "unknown auth type",
+			registries: map[string]RegistryMapping{"harbor.example.com": registry("harbor.example.com", "oauth")},
+			want:       map[string]string{`registries."harbor.example.com"`: IssueRegistryAuthUnsupported},
+		},
+		{
+			name:       "google auth on artifact registry",
+			registries: map[string]RegistryMapping{"us-docker.pkg.dev": registry("us-docker.pkg.dev", "google")},
+			want:       map[string]string{},
+		},
+		{
+			name:     "invalid base path",
+			basePath: "/hub?x=1",
+			want:     map[string]string{"server.basePath": IssueBasePathInvalid},
+		},
+		{
+			name:     "base path shadows route",
+			basePath: "/api/",
+			want:     map[string]string{"server.basePath": IssueBasePathShadowsRoute},
+		},
+		{
+			name:     "base path shadows github host",
+			basePath: "/github.com",
+			want:     map[string]string{"server.basePath": IssueBasePathShadowsRoute},
+		},
+		{
+			name:     "valid base path",
+			basePath: "/hub/v1",
+			want:     map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Server.BasePath = tt.basePath
+			if tt.registries != nil {
+				cfg.Registries = tt.registries
+			}
+			issues := CheckConsistency(cfg)
+			got := make(map[string]string)
+			for _, issue := range issues {
+				if _, exists := got[issue.Key]; exists {
+					t.Fatalf("multiple issues for %s: %v", issue.Key, issues)
+				}
+				got[issue.Key] = issue.Code
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("issues = %v, want %v", issues, tt.want)
+			}
+			for key, code := range tt.want {
+				if got[key] != code {
+					t.Fatalf("issues = %v, want %s for %s", issues, code, key)
+				}
+			}
+		})
+	}
+
+	// 未启用的Registry不参与检查
+	cfg := DefaultConfig()
+	cfg.Registries["library"] = RegistryMapping{Upstream: "ghcr.io", AuthType: "oauth"}
+	if issues := CheckConsistency(cfg); len(issues) != 0 {
+		t.Fatalf("disabled registry issues = %v", issues)
+	}
+}
+
+func TestLoadConfigStrictConsistency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+
+	conflicting := `
+[registries."mirror.example.com"]
+upstream = "ghcr.io"
+authHost = "ghcr.io/token"
+authType = "github"
+enabled = true
+`
+	// 默认只打印问题，配置照常生效
+	if err := os.WriteFile(path, []byte(conflicting), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetConfig().Registries["mirror.example.com"]; !ok {
+		t.Fatal("non-strict config was not applied")
+	}
+
+	if err := os.WriteFile(path, []byte("[server]\nstrictConfig = true\nport = 5999\n"+conflicting), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := ReloadConfig()
+	if err == nil || !strings.Contains(err.Error(), "strictConfig") {
+		t.Fatalf("strict reload err = %v", err)
+	}
+	if GetConfig().Server.Port == 5999 {
+		t.Fatal("strict config with conflicts was applied")
+	}
+}
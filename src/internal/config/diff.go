@@ -0,0 +1,62 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ChangedSections 比较两份配置，按定义顺序返回内容不同的顶层配置段名称(toml中的表名，如 server、registries)
+func ChangedSections(oldCfg, newCfg *AppConfig) []string {
+	oldValue := reflect.ValueOf(oldCfg).Elem()
+	newValue := reflect.ValueOf(newCfg).Elem()
+	fields := oldValue.Type()
+
+	var changed []string
+	for i := 0; i < fields.NumField(); i++ {
+		if valuesEqual(oldValue.Field(i), newValue.Field(i)) {
+			continue
+		}
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("toml"), ",")
+		if name == "" {
+			name = fields.Field(i).Name
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
+
+// valuesEqual 逐项比较配置值，空切片和空map与nil视为相同，GetConfig返回的副本中二者可能不一致
+func valuesEqual(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !valuesEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !valuesEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for _, key := range a.MapKeys() {
+			other := b.MapIndex(key)
+			if !other.IsValid() || !valuesEqual(a.MapIndex(key), other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+}
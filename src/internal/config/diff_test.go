@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChangedSections(t *testing.T) {
+	oldCfg := DefaultConfig()
+	if changed := ChangedSections(oldCfg, DefaultConfig()); len(changed) != 0 {
+		t.Fatalf("identical configs changed = %v", changed)
+	}
+
+	// GetConfig返回的副本中空切片为nil，不应视为变化
+	setConfig(DefaultConfig())
+	if changed := ChangedSections(GetConfig(), DefaultConfig()); len(changed) != 0 {
+		t.Fatalf("copied config changed = %v", changed)
+	}
+
+	newCfg := DefaultConfig()
+	newCfg.RateLimit.RequestLimit++
+	newCfg.Security.Crawlers.Patterns = append(newCfg.Security.Crawlers.Patterns, "examplebot")
+	newCfg.Registries["ghcr.io"] = RegistryMapping{Upstream: "mirror.example.com", Enabled: true}
+	want := []string{"rateLimit", "security", "registries"}
+	if changed := ChangedSections(oldCfg, newCfg); !reflect.DeepEqual(changed, want) {
+		t.Fatalf("changed = %v, want %v", changed, want)
+	}
+}
+
+func TestReloaderNotifiesAffectedComponents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[server]\nport = 5001\n")
+	if err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	reloader := NewReloader(NewStore(GetConfig()))
+	reloader.Register(
+		ReloadFunc{Sections: []string{"registries"}, Fn: func(_, _ *AppConfig) { calls = append(calls, "registries") }},
+		ReloadFunc{Sections: []string{"rateLimit", "security"}, Fn: func(_, _ *AppConfig) { calls = append(calls, "limits") }},
+		ReloadFunc{Fn: func(oldCfg, newCfg *AppConfig) {
+			calls = append(calls, "any")
+			if oldCfg.RateLimit.RequestLimit == newCfg.RateLimit.RequestLimit {
+				t.Error("component received identical configs")
+			}
+		}},
+	)
+
+	// 内容未变化时不通知任何组件
+	before := reloader.LastReload()
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if status := reloader.LastReload(); len(calls) != 0 || !status.OK || len(status.Changed) != 0 || status.Succeeded != before.Succeeded+1 {
+		t.Fatalf("unchanged reload: calls %v, status %+v", calls, status)
+	}
+
+	write("[server]\nport = 5001\n[rateLimit]\nrequestLimit = 42\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if status := reloader.LastReload(); !reflect.DeepEqual(calls, []string{"limits", "any"}) || !reflect.DeepEqual(status.Changed, []string{"rateLimit"}) {
+		t.Fatalf("partial reload: calls %v, status %+v", calls, status)
+	}
+}
+
+func TestReloadConfigRejectsInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+	if err := os.WriteFile(path, []byte("[server]\nport = 5002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	store := NewStore(GetConfig())
+	reloader := NewReloader(store)
+	reloader.Register(ReloadFunc{Fn: func(_, _ *AppConfig) { called = true }})
+
+	// server段有效而schedule段无效时整份配置都不生效
+	before := reloader.LastReload()
+	body := "[server]\nport = 5003\n[rateLimit]\nrequestLimit = 7\n[schedule]\ntimezone = \"Nowhere/Invalid\"\n"
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := reloader.Reload()
+	if err == nil || !strings.Contains(err.Error(), "schedule.timezone") {
+		t.Fatalf("err = %v", err)
+	}
+	cfg := store.Get()
+	if cfg.Server.Port != 5002 || cfg.RateLimit.RequestLimit == 7 || called {
+		t.Fatalf("rejected reload applied: port %d, requestLimit %d, component notified %v", cfg.Server.Port, cfg.RateLimit.RequestLimit, called)
+	}
+	status := reloader.LastReload()
+	if status.OK || status.Error != err.Error() || status.Failed != before.Failed+1 || status.Succeeded != before.Succeeded || len(status.Changed) != 0 {
+		t.Fatalf("status = %+v, before %+v", status, before)
+	}
+}
+
+func TestReloaderUpdatesOnlyItsStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("CONFIG_PATH", path)
+	if err := os.WriteFile(path, []byte("[rateLimit]\nrequestLimit = 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := NewStore(nil), NewStore(nil)
+	before := GetConfig().RateLimit.RequestLimit
+	if err := NewReloader(first).Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Get().RateLimit.RequestLimit != 42 {
+		t.Fatalf("reloaded store requestLimit = %d", first.Get().RateLimit.RequestLimit)
+	}
+	if second.Get().RateLimit.RequestLimit == 42 || GetConfig().RateLimit.RequestLimit != before {
+		t.Fatal("reload leaked into another store or the package config")
+	}
+}
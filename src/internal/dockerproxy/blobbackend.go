@@ -0,0 +1,107 @@
+package dockerproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// errBlobDigestMismatch 从后端读取的blob内容与digest不符
+var errBlobDigestMismatch = errors.New("blob内容与digest不符")
+
+// digestCheckReader 读到声明的最后一个字节时校验sha256，不符时不返回最后一段数据，
+// 客户端收到的内容少于Content-Length，不会把错误的内容当作完整的blob
+type digestCheckReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	want   string
+	remain int64
+}
+
+func (d *digestCheckReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.remain -= int64(n)
+	if n > 0 && d.remain <= 0 {
+		if d.remain < 0 || "sha256:"+hex.EncodeToString(d.hash.Sum(nil)) != d.want {
+			return 0, errBlobDigestMismatch
+		}
+	}
+	return n, err
+}
+
+// serveCachedBlobBackend 上游Registry的blob最近重定向到的后端地址仍在缓存中时直接请求后端，跳过重定向。
+// 客户端的Range原样转发；完整下载时按digest校验。后端请求失败时删除缓存并返回false，由调用方照常回源
+func (p *Proxy) serveCachedBlobBackend(c *gin.Context, imageRef, digest, target string) bool {
+	if c.Request.Method != http.MethodGet {
+		return false
+	}
+	host := imageRefHost(imageRef)
+	backend, ok := p.clients.RegistryBlobBackend(host, digest)
+	if !ok || !strings.HasPrefix(digest, "sha256:") {
+		return false
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, backend, nil)
+	if err != nil {
+		p.clients.ForgetRegistryBlobBackend(host, digest)
+		return false
+	}
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := p.clients.Global().Do(req)
+	if err != nil {
+		p.clients.ForgetRegistryBlobBackend(host, digest)
+		utils.Logf(utils.LogDebug, "backend "+host, "缓存的blob后端请求失败，改为经 %s 重新获取: %v", host, err)
+		return false
+	}
+	partial := resp.StatusCode == http.StatusPartialContent && rangeHeader != ""
+	if !partial && (resp.StatusCode != http.StatusOK || resp.ContentLength < 0) {
+		resp.Body.Close()
+		p.clients.ForgetRegistryBlobBackend(host, digest)
+		utils.Logf(utils.LogDebug, "backend "+host, "缓存的blob后端返回 %d，改为经 %s 重新获取", resp.StatusCode, host)
+		return false
+	}
+	defer resp.Body.Close()
+
+	limit := ImageSizeLimit(p.shared.Config.Get(), c, utils.SizeClassDockerBlob, target)
+	if !partial && limit > 0 && resp.ContentLength > limit {
+		utils.RespondRegistryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", utils.ErrCodeFileTooLarge, utils.SizeLimitMB(limit))
+		return true
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("Accept-Ranges", "bytes")
+	if resp.ContentLength >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	var body io.Reader = utils.NewSizeLimitReader(resp.Body, limit)
+	if partial {
+		c.Header("Content-Range", resp.Header.Get("Content-Range"))
+	} else {
+		body = &digestCheckReader{r: body, hash: sha256.New(), want: digest, remain: resp.ContentLength}
+	}
+
+	c.Status(resp.StatusCode)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		if errors.Is(err, errBlobDigestMismatch) {
+			p.clients.ForgetRegistryBlobBackend(host, digest)
+			utils.Logf(utils.LogError, "backend "+host, "%s 的blob后端返回的内容与 %s 不符，已中断传输", host, digest)
+			return true
+		}
+		fmt.Printf("复制layer内容失败: %v\n", err)
+	}
+	return true
+}
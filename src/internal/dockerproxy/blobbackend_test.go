@@ -0,0 +1,127 @@
+package dockerproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegistryK8sRedirectChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	layer := []byte("pause layer for registry.k8s.io redirect test")
+	sum := sha256.Sum256(layer)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	// 存储后端: 不接受Authorization，按Range返回部分内容
+	var backendHits atomic.Int32
+	var corrupt atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits.Add(1)
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("<Error><Code>InvalidArgument</Code><Message>Only one auth mechanism allowed</Message></Error>"))
+			return
+		}
+		content := layer
+		if corrupt.Load() {
+			content = []byte(strings.ToUpper(string(layer)))
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(content)))
+	}))
+	defer backend.Close()
+	backendURL := strings.Replace(backend.URL, "127.0.0.1", "localhost", 1)
+
+	// 按registry.k8s.io录制的响应: /v2/ 匿名可用，blob返回307到地区存储后端
+	var registryBlobHits atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/v2/pause/blobs/"):
+			registryBlobHits.Add(1)
+			location := backendURL + "/containers/images/" + strings.TrimPrefix(r.URL.Path, "/v2/pause/blobs/")
+			if strings.HasSuffix(r.URL.Path, "sha256:0000000000000000000000000000000000000000000000000000000000000000") {
+				location = "http://untrusted.example/containers/images/x"
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			w.Write([]byte(`<a href="` + location + `">Temporary Redirect</a>.`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	upstream := strings.TrimPrefix(registry.URL, "http://")
+
+	loadTestConfig(t, `
+[registries."registry.k8s.io"]
+upstream = "`+upstream+`"
+authHost = "`+upstream+`"
+authType = "anonymous"
+enabled = true
+redirectHosts = ["localhost"]
+backendCacheTTL = "1m"
+`)
+	p := newTestProxy()
+	mapping := config.GetConfig().Registries["registry.k8s.io"]
+	imageRef := upstream + "/pause"
+
+	fetch := func(digest, rangeHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/pause/blobs/"+digest+"?ns=registry.k8s.io", nil)
+		if rangeHeader != "" {
+			c.Request.Header.Set("Range", rangeHeader)
+		}
+		p.handleUpstreamBlobRequest(c, imageRef, digest, "registry.k8s.io/pause", mapping)
+		return w
+	}
+
+	// 首次拉取经registry重定向到后端，后端地址被记住
+	if w := fetch(digest, ""); w.Code != http.StatusOK || w.Body.String() != string(layer) || w.Header().Get("Docker-Content-Digest") != digest {
+		t.Fatalf("first pull: status %d, body %q", w.Code, w.Body.String())
+	}
+	if _, ok := p.clients.RegistryBlobBackend(upstream, digest); !ok {
+		t.Fatal("backend not remembered")
+	}
+
+	// 再次拉取直接请求后端，Range原样转发
+	hits := registryBlobHits.Load()
+	w := fetch(digest, "bytes=6-10")
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(layer[6:11]) || w.Header().Get("Content-Range") != "bytes 6-10/"+strconv.Itoa(len(layer)) {
+		t.Fatalf("range pull: status %d, body %q, Content-Range %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+	if w := fetch(digest, ""); w.Code != http.StatusOK || w.Body.String() != string(layer) {
+		t.Fatalf("repeat pull: status %d, body %q", w.Code, w.Body.String())
+	}
+	if registryBlobHits.Load() != hits {
+		t.Fatalf("repeat pulls went through the registry redirect: %d -> %d", hits, registryBlobHits.Load())
+	}
+
+	// 后端内容与digest不符时不返回完整内容，并放弃缓存的地址
+	corrupt.Store(true)
+	if w := fetch(digest, ""); w.Body.Len() >= len(layer) {
+		t.Fatalf("corrupt backend: %d bytes relayed", w.Body.Len())
+	}
+	if _, ok := p.clients.RegistryBlobBackend(upstream, digest); ok {
+		t.Fatal("backend kept after digest mismatch")
+	}
+	corrupt.Store(false)
+
+	// 重定向到redirectHosts之外的主机被拒绝
+	if w := fetch("sha256:0000000000000000000000000000000000000000000000000000000000000000", ""); w.Code == http.StatusOK {
+		t.Fatalf("untrusted redirect: status %d", w.Code)
+	}
+}
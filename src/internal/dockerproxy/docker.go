@@ -0,0 +1,1149 @@
+// Package dockerproxy 代理Docker Hub和已配置的其他Registry，包括manifest、镜像层、标签列表和认证令牌
+package dockerproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Proxy Docker Registry代理，缓存、访问控制、上游HTTP客户端和共享状态由调用方创建后注入
+type Proxy struct {
+	registry name.Registry
+	options  []remote.Option
+
+	cache   *utils.UniversalCache
+	hot     *utils.HotCache
+	access  *accesscontrol.Controller
+	clients *utils.HTTPClients
+	shared  *utils.Components
+
+	// registries Registry路由与认证表，配置重载时整体替换
+	registries atomic.Pointer[registryTable]
+	// refreshedTokens 后台刷新写入的缓存项，首次命中时计入节省的同步获取
+	refreshedTokens sync.Map
+
+	// AuthToken 处理/token请求中代理自身的命名令牌和登录，已处理时返回true；为nil时全部转发上游
+	AuthToken func(c *gin.Context) bool
+}
+
+// New 创建Docker代理，cache保存manifest、令牌和负缓存，hot保存小体积的镜像层，
+// shared提供请求合并、统计和内容筛查
+func New(cache *utils.UniversalCache, hot *utils.HotCache, access *accesscontrol.Controller, clients *utils.HTTPClients, shared *utils.Components) (*Proxy, error) {
+	registry, err := name.NewRegistry("registry-1.docker.io")
+	if err != nil {
+		return nil, fmt.Errorf("创建Docker registry失败: %w", err)
+	}
+
+	p := &Proxy{
+		registry: registry,
+		cache:    cache,
+		hot:      hot,
+		access:   access,
+		clients:  clients,
+		shared:   shared,
+	}
+	p.options = []remote.Option{
+		remote.WithAuth(authn.Anonymous),
+		remote.WithUserAgent("hubproxy/go-containerregistry"),
+		remote.WithTransport(clients.Transport()),
+	}
+	p.reloadRegistries(shared.Config.Get())
+	return p, nil
+}
+
+// Registry 返回Docker Hub的Registry地址
+func (p *Proxy) Registry() name.Registry {
+	return p.registry
+}
+
+// Options 返回访问Docker Hub使用的选项
+func (p *Proxy) Options() []remote.Option {
+	return p.options
+}
+
+// registryTable Registry路由与认证表
+type registryTable struct {
+	mappings map[string]config.RegistryMapping
+	domains  []string
+}
+
+// buildRegistryTable 根据配置构建Registry表，域名按长度降序以优先匹配更长的前缀
+func buildRegistryTable(registries map[string]config.RegistryMapping) *registryTable {
+	table := &registryTable{
+		mappings: make(map[string]config.RegistryMapping, len(registries)),
+		domains:  make([]string, 0, len(registries)),
+	}
+	for domain, mapping := range registries {
+		table.mappings[domain] = mapping
+		table.domains = append(table.domains, domain)
+	}
+	sort.Slice(table.domains, func(i, j int) bool {
+		if len(table.domains[i]) != len(table.domains[j]) {
+			return len(table.domains[i]) > len(table.domains[j])
+		}
+		return table.domains[i] < table.domains[j]
+	})
+	return table
+}
+
+// reloadRegistries 根据配置重建Registry表，新请求立即生效
+func (p *Proxy) reloadRegistries(cfg *config.AppConfig) {
+	table := buildRegistryTable(cfg.Registries)
+	p.registries.Store(table)
+
+	enabled := 0
+	for _, mapping := range table.mappings {
+		if mapping.Enabled {
+			enabled++
+		}
+	}
+	fmt.Printf("Registry配置已加载: 共 %d 个，启用 %d 个\n", len(table.mappings), enabled)
+}
+
+// ReloadSections 实现config.Reloadable
+func (p *Proxy) ReloadSections() []string {
+	return []string{"registries"}
+}
+
+// Reload 实现config.Reloadable，重建Registry表
+func (p *Proxy) Reload(_, updated *config.AppConfig) {
+	p.reloadRegistries(updated)
+}
+
+// Domains 返回已配置的Registry域名，按匹配优先级排列
+func (p *Proxy) Domains() []string {
+	return p.registries.Load().domains
+}
+
+// detectRegistryDomain 检测Registry域名并返回域名和剩余路径
+func (p *Proxy) detectRegistryDomain(c *gin.Context, path string) (string, string) {
+	table := p.registries.Load()
+
+	// 兼容Containerd的ns参数
+	if ns := c.Query("ns"); ns != "" {
+		if _, exists := table.mappings[ns]; exists {
+			return ns, path
+		}
+	}
+
+	for _, domain := range table.domains {
+		if strings.HasPrefix(path, domain+"/") {
+			remainingPath := strings.TrimPrefix(path, domain+"/")
+			return domain, remainingPath
+		}
+	}
+
+	return "", path
+}
+
+// isRegistryEnabled 检查Registry是否启用
+func (p *Proxy) isRegistryEnabled(domain string) bool {
+	mapping, exists := p.registries.Load().mappings[domain]
+	return exists && mapping.Enabled
+}
+
+// RegistryMapping 获取已启用的Registry映射配置
+func (p *Proxy) RegistryMapping(domain string) (config.RegistryMapping, bool) {
+	mapping, exists := p.registries.Load().mappings[domain]
+	return mapping, exists && mapping.Enabled
+}
+
+// RegistryHandler 标准Docker Registry API v2代理
+func (p *Proxy) RegistryHandler(c *gin.Context) {
+	path := c.Request.URL.Path
+	// manifest和blob的响应头由代理生成，只需补充set中的固定值
+	p.clients.ApplyResponseHeaderPolicy(c.Writer.Header())
+	stripAnonymousToken(c)
+
+	if path == "/v2/" {
+		p.handleV2Base(c)
+		return
+	}
+
+	if strings.HasPrefix(path, "/v2/") {
+		p.handleRegistryRequest(c, path)
+	} else {
+		utils.RespondRegistryError(c, http.StatusNotFound, "UNSUPPORTED", utils.ErrCodeRegistryV2Only)
+	}
+}
+
+// handleRegistryRequest 处理Registry请求
+func (p *Proxy) handleRegistryRequest(c *gin.Context, path string) {
+	pathWithoutV2 := strings.TrimPrefix(path, "/v2/")
+
+	if registryDomain, remainingPath := p.detectRegistryDomain(c, pathWithoutV2); registryDomain != "" {
+		if !p.isRegistryEnabled(registryDomain) {
+			utils.RespondRegistryError(c, http.StatusForbidden, "DENIED", utils.ErrCodeRegistryDisabled, registryDomain)
+			return
+		}
+		c.Set("target_registry_domain", registryDomain)
+		c.Set("target_path", remainingPath)
+
+		p.handleMultiRegistryRequest(c, registryDomain, remainingPath)
+		return
+	}
+
+	imageName, apiType, reference := ParseRegistryPath(pathWithoutV2)
+	if imageName == "" || apiType == "" {
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_INVALID", utils.ErrCodeInvalidPath)
+		return
+	}
+	imageName, ok := p.normalizeRegistryImageName(c, imageName)
+	if !ok {
+		return
+	}
+
+	if !strings.Contains(imageName, "/") {
+		imageName = "library/" + imageName
+	}
+
+	if allowed, reason := p.access.CheckDockerAccess(imageName); !allowed {
+		fmt.Printf("Docker镜像 %s 访问被拒绝: %s\n", imageName, reason)
+		utils.RespondRegistryError(c, http.StatusForbidden, "DENIED", utils.ErrCodeImageAccessDenied)
+		return
+	}
+
+	imageRef := fmt.Sprintf("%s/%s", p.registry.Name(), imageName)
+
+	switch apiType {
+	case "manifests":
+		p.handleManifestRequest(c, imageRef, reference)
+	case "blobs":
+		p.handleBlobRequest(c, imageRef, reference, imageName)
+	case "tags":
+		p.handleTagsRequest(c, imageRef)
+	default:
+		utils.RespondRegistryError(c, http.StatusNotFound, "UNSUPPORTED", utils.ErrCodeEndpointNotFound)
+	}
+}
+
+// writeRegistryError 按Registry API规范返回错误
+func writeRegistryError(c *gin.Context, status int, code, message string) {
+	c.Data(status, "application/json", utils.RegistryErrorBody(code, message))
+}
+
+// respondRegistryLimitError 上游出站预算不足时按Registry API规范返回503，上游返回429时返回429，
+// 并按上游要求的等待时长设置Retry-After，返回是否已处理
+func (p *Proxy) respondRegistryLimitError(c *gin.Context, err error) bool {
+	if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+		utils.RespondRegistryError(c, http.StatusServiceUnavailable, "TOOMANYREQUESTS", utils.ErrCodeUpstreamBudget, budgetErr.Host)
+		return true
+	}
+	var terr *transport.Error
+	if !errors.As(err, &terr) || terr.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	host := ""
+	if terr.Request != nil {
+		host = terr.Request.URL.Hostname()
+		if wait, ok := p.clients.UpstreamThrottleRemaining(host); ok {
+			utils.SetRetryAfter(c, wait)
+		}
+	}
+	utils.RespondRegistryError(c, http.StatusTooManyRequests, "TOOMANYREQUESTS", utils.ErrCodeUpstreamThrottled, host)
+	return true
+}
+
+// respondBodyTooLarge 认证请求体在转发过程中超出上限时按Registry格式返回413
+func respondBodyTooLarge(c *gin.Context) {
+	utils.RespondRegistryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", utils.ErrCodeBodyTooLarge, c.GetInt64(utils.BodyLimitKey))
+	c.Abort()
+}
+
+// imageRefHost 返回镜像引用中的Registry主机，作为合并重复日志的关键字段
+func imageRefHost(imageRef string) string {
+	host, _, _ := strings.Cut(imageRef, "/")
+	return host
+}
+
+// manifestNotFoundCode 判断上游错误是否为manifest或仓库不存在，返回对应的Registry错误码
+func manifestNotFoundCode(err error) (string, bool) {
+	var terr *transport.Error
+	if !errors.As(err, &terr) || terr.StatusCode != http.StatusNotFound {
+		return "", false
+	}
+	for _, diagnostic := range terr.Errors {
+		if diagnostic.Code == transport.NameUnknownErrorCode {
+			return string(transport.NameUnknownErrorCode), true
+		}
+	}
+	return string(transport.ManifestUnknownErrorCode), true
+}
+
+// negativeCacheUsable 携带上游凭据的请求不使用不存在缓存，私有镜像匿名访问时可能返回404。
+// 代理自身的认证头和本地签发的匿名令牌在此之前已被去掉，不影响判断
+func (p *Proxy) negativeCacheUsable(c *gin.Context) bool {
+	return utils.IsCacheEnabled(p.shared.Config.Get()) && c.GetHeader("Authorization") == ""
+}
+
+// serveNegativeManifest 命中manifest不存在缓存时直接返回，不访问上游
+func (p *Proxy) serveNegativeManifest(c *gin.Context, imageRef, reference string) bool {
+	cfg := p.shared.Config.Get()
+	if !p.negativeCacheUsable(c) || !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	cachedItem := p.cache.Get(utils.BuildNegativeManifestCacheKey(imageRef, reference))
+	if cachedItem == nil {
+		return false
+	}
+	utils.SetCacheOutcome(c, utils.CacheHit)
+	c.Data(http.StatusNotFound, cachedItem.ContentType, cachedItem.Data)
+	return true
+}
+
+// respondManifestError 返回manifest获取失败的响应，上游确认不存在时写入不存在缓存
+func (p *Proxy) respondManifestError(c *gin.Context, imageRef, reference string, err error) {
+	cfg := p.shared.Config.Get()
+	if p.respondRegistryLimitError(c, err) {
+		return
+	}
+	code, notFound := manifestNotFoundCode(err)
+	if !notFound {
+		utils.RespondRegistryError(c, http.StatusNotFound, "MANIFEST_UNKNOWN", utils.ErrCodeManifestNotFound)
+		return
+	}
+
+	body := utils.RegistryErrorBody(code, fmt.Sprintf("manifest %s:%s not found", imageRef, reference))
+	if p.negativeCacheUsable(c) && utils.CacheWriteAllowed(cfg, c) {
+		if ttl := utils.GetNegativeManifestTTL(cfg, reference); ttl > 0 {
+			p.cache.Set(utils.BuildNegativeManifestCacheKey(imageRef, reference), body, "application/json", nil, ttl)
+		}
+	}
+	c.Data(http.StatusNotFound, "application/json", body)
+}
+
+// normalizeRegistryImageName 按proxy.docker.autoLowercase处理Registry API路径中的大写仓库名，
+// 拒绝时已按Registry API规范返回400
+func (p *Proxy) normalizeRegistryImageName(c *gin.Context, imageName string) (string, bool) {
+	normalized, err := accesscontrol.NormalizeDockerReference(p.shared.Config.Get(), imageName)
+	var nameErr *accesscontrol.RepositoryNameError
+	if errors.As(err, &nameErr) {
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_INVALID", utils.ErrCodeRepositoryUppercase, nameErr.Name, nameErr.Suggested)
+		return "", false
+	}
+	return normalized, true
+}
+
+// NormalizeImageParam 按cfg的proxy.docker.autoLowercase处理镜像下载等接口参数中的大写仓库名，拒绝时已返回400
+func NormalizeImageParam(cfg *config.AppConfig, c *gin.Context, image string) (string, bool) {
+	normalized, err := accesscontrol.NormalizeDockerReference(cfg, image)
+	var nameErr *accesscontrol.RepositoryNameError
+	if errors.As(err, &nameErr) {
+		utils.RespondError(c, http.StatusBadRequest, utils.ErrCodeRepositoryUppercase, nameErr.Name, nameErr.Suggested)
+		return "", false
+	}
+	return normalized, true
+}
+
+// ParseRegistryPath 解析Registry路径
+func ParseRegistryPath(path string) (imageName, apiType, reference string) {
+	if idx := strings.Index(path, "/manifests/"); idx != -1 {
+		imageName = path[:idx]
+		apiType = "manifests"
+		reference = path[idx+len("/manifests/"):]
+		return
+	}
+
+	if idx := strings.Index(path, "/blobs/"); idx != -1 {
+		imageName = path[:idx]
+		apiType = "blobs"
+		reference = path[idx+len("/blobs/"):]
+		return
+	}
+
+	if idx := strings.Index(path, "/tags/list"); idx != -1 {
+		imageName = path[:idx]
+		apiType = "tags"
+		reference = "list"
+		return
+	}
+
+	return "", "", ""
+}
+
+// handleManifestRequest 处理manifest请求
+func (p *Proxy) handleManifestRequest(c *gin.Context, imageRef, reference string) {
+	cfg := p.shared.Config.Get()
+	withClientAccept(c)
+	if utils.IsCacheEnabled(cfg) && c.Request.Method == http.MethodGet && p.serveCachedManifest(c, imageRef, reference, p.options) {
+		return
+	}
+
+	if p.serveNegativeManifest(c, imageRef, reference) {
+		return
+	}
+
+	ref, err := parseManifestReference(imageRef, reference)
+	if err != nil {
+		fmt.Printf("解析镜像引用失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "TAG_INVALID", utils.ErrCodeInvalidReference)
+		return
+	}
+
+	if c.Request.Method == http.MethodHead {
+		result, _, err := p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassManifestHead, manifestHeadKey(c.Request.Context(), ref), func() (interface{}, error) {
+			options, cancel := p.withMetadataTimeout(c.Request.Context(), p.options)
+			defer cancel()
+			return remote.Head(ref, options...)
+		})
+		if err != nil {
+			utils.Logf(utils.LogError, imageRefHost(imageRef), "HEAD请求失败: %v", err)
+			p.respondManifestError(c, imageRef, reference, err)
+			return
+		}
+		desc := result.(*v1.Descriptor)
+
+		c.Header("Content-Type", string(desc.MediaType))
+		c.Header("Docker-Content-Digest", desc.Digest.String())
+		c.Header("Content-Length", fmt.Sprintf("%d", desc.Size))
+		c.Status(http.StatusOK)
+	} else {
+		if utils.IsCacheEnabled(cfg) {
+			if p.serveRevalidatedManifest(c, imageRef, reference, p.options) {
+				return
+			}
+			utils.SetCacheOutcome(c, utils.CacheMiss)
+		}
+		options, cancel := p.withMetadataTimeout(c.Request.Context(), p.options)
+		defer cancel()
+		desc, err := remote.Get(ref, options...)
+		if err != nil {
+			utils.Logf(utils.LogError, imageRefHost(imageRef), "GET请求失败: %v", err)
+			if p.serveStaleManifestOnError(c, imageRef, reference, err) {
+				return
+			}
+			p.respondManifestError(c, imageRef, reference, err)
+			return
+		}
+
+		headers := manifestHeaders(desc)
+		if utils.IsCacheEnabled(cfg) && utils.CacheWriteAllowed(cfg, c) {
+			p.cacheManifest(c.Request.Context(), imageRef, reference, desc, headers)
+		}
+
+		c.Header("Content-Type", string(desc.MediaType))
+		for key, value := range headers {
+			c.Header(key, value)
+		}
+
+		c.Data(http.StatusOK, string(desc.MediaType), desc.Manifest)
+	}
+}
+
+// manifestHeaders 返回manifest响应需要的头部
+func manifestHeaders(desc *remote.Descriptor) map[string]string {
+	return map[string]string{
+		"Docker-Content-Digest": desc.Digest.String(),
+		"Content-Length":        fmt.Sprintf("%d", len(desc.Manifest)),
+	}
+}
+
+// withClientAccept 把客户端的Accept头记录到请求上下文，上游manifest请求原样转发，缓存key按Accept区分
+func withClientAccept(c *gin.Context) {
+	c.Request = c.Request.WithContext(utils.WithManifestAccept(c.Request.Context(), c.Request.Header.Values("Accept")))
+}
+
+// manifestCacheKey 返回manifest缓存key，包含上下文中客户端Accept的规范化形式
+func manifestCacheKey(ctx context.Context, imageRef, reference string) string {
+	return utils.BuildManifestCacheKey(imageRef, reference, utils.ManifestAcceptKey(ctx))
+}
+
+// manifestHeadKey 返回HEAD请求的合并key，Accept不同的请求可能得到不同格式的manifest，不能合并
+func manifestHeadKey(ctx context.Context, ref name.Reference) string {
+	if accept := utils.ManifestAcceptKey(ctx); accept != "" {
+		return ref.String() + "|" + accept
+	}
+	return ref.String()
+}
+
+// cacheManifest 将manifest写入缓存，key与代理请求一致，过期后按stale配置保留。
+// 上游返回的媒体类型不在客户端Accept范围内时不写入，避免之后把这份内容当作协商结果返回
+func (p *Proxy) cacheManifest(ctx context.Context, imageRef, reference string, desc *remote.Descriptor, headers map[string]string) {
+	cfg := p.shared.Config.Get()
+	if !utils.ManifestTypeAccepted(ctx, string(desc.MediaType)) {
+		utils.Logf(utils.LogWarn, imageRefHost(imageRef), "上游返回的manifest类型 %s 不在客户端Accept范围内，不写入缓存 %s:%s", desc.MediaType, imageRef, reference)
+		return
+	}
+	cacheKey := manifestCacheKey(ctx, imageRef, reference)
+	ttl := utils.GetManifestTTL(cfg, reference)
+	staleFor := max(utils.GetStaleWhileRevalidate(cfg), utils.GetStaleIfError(cfg))
+	p.cache.SetWithStale(cacheKey, desc.Manifest, string(desc.MediaType), headers, ttl, staleFor)
+}
+
+// parseManifestReference 根据tag或digest构建镜像引用
+func parseManifestReference(imageRef, reference string) (name.Reference, error) {
+	if strings.HasPrefix(reference, "sha256:") {
+		return name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference))
+	}
+	return name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference))
+}
+
+// fetchAndCacheManifest 从上游拉取manifest并写入缓存
+func (p *Proxy) FetchManifest(ctx context.Context, imageRef, reference string, options []remote.Option) (*remote.Descriptor, error) {
+	ref, err := parseManifestReference(imageRef, reference)
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像引用失败: %w", err)
+	}
+
+	options, cancel := p.withMetadataTimeout(ctx, options)
+	defer cancel()
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	p.cacheManifest(ctx, imageRef, reference, desc, manifestHeaders(desc))
+	return desc, nil
+}
+
+// revalidateManifest 按tag缓存的manifest过期后，先用HEAD获取上游当前digest，与缓存的
+// Docker-Content-Digest一致时续期缓存并返回，省去重新下载manifest；不一致或HEAD失败时返回nil，
+// 由调用方照常拉取并替换缓存。digest引用的内容不可变，不需要重新验证
+func (p *Proxy) revalidateManifest(ctx context.Context, imageRef, reference string, options []remote.Option) *utils.CachedItem {
+	cfg := p.shared.Config.Get()
+	if strings.HasPrefix(reference, "sha256:") {
+		return nil
+	}
+	cacheKey := manifestCacheKey(ctx, imageRef, reference)
+	item := p.cache.GetForRevalidation(cacheKey)
+	if item == nil || item.Headers["Docker-Content-Digest"] == "" {
+		return nil
+	}
+	ref, err := parseManifestReference(imageRef, reference)
+	if err != nil {
+		return nil
+	}
+
+	result, _, err := p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassManifestHead, manifestHeadKey(ctx, ref), func() (interface{}, error) {
+		options, cancel := p.withMetadataTimeout(ctx, options)
+		defer cancel()
+		return remote.Head(ref, options...)
+	})
+	if err != nil {
+		utils.Logf(utils.LogError, imageRefHost(imageRef), "重新验证manifest失败 %s:%s: %v", imageRef, reference, err)
+		return nil
+	}
+	desc := result.(*v1.Descriptor)
+	if desc.Digest.String() != item.Headers["Docker-Content-Digest"] || string(desc.MediaType) != item.ContentType {
+		return nil
+	}
+	return p.cache.Renew(cacheKey, item, nil, utils.GetManifestTTL(cfg, reference))
+}
+
+// serveRevalidatedManifest 缓存的manifest经上游确认未变化时直接返回
+func (p *Proxy) serveRevalidatedManifest(c *gin.Context, imageRef, reference string, options []remote.Option) bool {
+	cfg := p.shared.Config.Get()
+	if !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	item := p.revalidateManifest(c.Request.Context(), imageRef, reference, options)
+	if item == nil {
+		return false
+	}
+	c.Header("X-Cache", "REVALIDATED")
+	utils.SetCacheOutcome(c, utils.CacheHit)
+	utils.WriteCachedResponse(c, item)
+	return true
+}
+
+// serveCachedManifest 返回缓存的manifest。过期但仍在stale-while-revalidate时间内时
+// 直接返回旧数据，同时由单个后台goroutine刷新
+func (p *Proxy) serveCachedManifest(c *gin.Context, imageRef, reference string, options []remote.Option) bool {
+	cfg := p.shared.Config.Get()
+	if !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	cacheKey := manifestCacheKey(c.Request.Context(), imageRef, reference)
+	item, stale := p.cache.GetStale(cacheKey)
+	if item == nil {
+		return false
+	}
+	if !stale {
+		utils.SetCacheOutcome(c, utils.CacheHit)
+		utils.WriteCachedResponse(c, item)
+		return true
+	}
+	if time.Since(item.ExpiresAt) > utils.GetStaleWhileRevalidate(cfg) {
+		return false
+	}
+
+	// 后台刷新的上游流量计入本次返回过期数据的请求
+	ctx := c.Request.Context()
+	p.cache.RefreshInBackground(cacheKey, func() {
+		if p.revalidateManifest(ctx, imageRef, reference, options) != nil {
+			return
+		}
+		if _, err := p.FetchManifest(ctx, imageRef, reference, options); err != nil {
+			utils.Logf(utils.LogError, imageRefHost(imageRef), "后台刷新manifest失败 %s:%s: %v", imageRef, reference, err)
+		}
+	})
+	utils.WriteStaleResponse(c, item, `110 - "Response is Stale"`)
+	return true
+}
+
+// upstreamUnavailable 判断是否为上游5xx或连接失败，4xx等明确结果不使用过期数据
+func upstreamUnavailable(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// serveStaleManifestOnError 上游不可用时，在stale-if-error时间内返回过期的manifest
+func (p *Proxy) serveStaleManifestOnError(c *gin.Context, imageRef, reference string, err error) bool {
+	cfg := p.shared.Config.Get()
+	if !utils.IsCacheEnabled(cfg) || !upstreamUnavailable(err) || !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	item, stale := p.cache.GetStale(manifestCacheKey(c.Request.Context(), imageRef, reference))
+	if item == nil || !stale || time.Since(item.ExpiresAt) > utils.GetStaleIfError(cfg) {
+		return false
+	}
+
+	fmt.Printf("上游不可用，返回过期manifest %s:%s\n", imageRef, reference)
+	utils.WriteStaleResponse(c, item, `111 - "Revalidation Failed"`)
+	return true
+}
+
+// handleBlobRequest 处理blob请求，target为大小限制覆盖规则匹配用的镜像名
+func (p *Proxy) handleBlobRequest(c *gin.Context, imageRef, digest, target string) {
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest))
+	if err != nil {
+		fmt.Printf("解析digest引用失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "DIGEST_INVALID", utils.ErrCodeInvalidDigest)
+		return
+	}
+	if p.screenBlobDigest(c, digest) || p.serveHotBlob(c, digest, target) {
+		return
+	}
+
+	options := append(append([]remote.Option(nil), p.options...), remote.WithContext(c.Request.Context()))
+	layer, err := remote.Layer(digestRef, options...)
+	if err != nil {
+		utils.Logf(utils.LogError, imageRefHost(imageRef), "获取layer失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusNotFound, "BLOB_UNKNOWN", utils.ErrCodeLayerNotFound)
+		}
+		return
+	}
+
+	p.writeBlob(c, layer, digest, target)
+}
+
+// writeBlob 按dockerBlob类别的大小限制返回layer内容，声明的大小超出时返回413，
+// 实际传输超出时中断连接
+func (p *Proxy) writeBlob(c *gin.Context, layer v1.Layer, digest, target string) {
+	size, err := layer.Size()
+	if err != nil {
+		utils.Logf(utils.LogError, target, "获取layer大小失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusInternalServerError, "UNKNOWN", utils.ErrCodeLayerReadFailed)
+		}
+		return
+	}
+
+	limit := ImageSizeLimit(p.shared.Config.Get(), c, utils.SizeClassDockerBlob, target)
+	if limit > 0 && size > limit {
+		utils.RespondRegistryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", utils.ErrCodeFileTooLarge, utils.SizeLimitMB(limit))
+		return
+	}
+
+	reader, err := layer.Compressed()
+	if err != nil {
+		utils.Logf(utils.LogError, target, "获取layer内容失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusInternalServerError, "UNKNOWN", utils.ErrCodeLayerReadFailed)
+		}
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", fmt.Sprintf("%d", size))
+	c.Header("Docker-Content-Digest", digest)
+
+	// 多次请求的小层在传输的同时保存副本，完整读取后（digest已由读取过程校验）写入内存缓存
+	var body io.Reader = utils.NewSizeLimitReader(reader, limit)
+	hotKey := utils.BuildBlobHotKey(digest)
+	var hotBuf *bytes.Buffer
+	cfg := p.shared.Config.Get()
+	if cfg.HotCache.Enabled && utils.CacheWriteAllowed(cfg, c) && p.hot.Admit(hotKey, size) {
+		hotBuf = bytes.NewBuffer(make([]byte, 0, size))
+		body = io.TeeReader(body, hotBuf)
+	}
+	if cfg.HotCache.Enabled {
+		utils.SetCacheOutcome(c, utils.CacheMiss)
+	}
+
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		fmt.Printf("复制layer内容失败: %v\n", err)
+		return
+	}
+	if hotBuf != nil && int64(hotBuf.Len()) == size {
+		p.hot.Store(hotKey, hotBuf.Bytes(), "application/octet-stream")
+	}
+}
+
+// serveHotBlob 镜像层在内存缓存中时直接返回，不再请求上游。
+// 缓存数据由所有读取者共享，每个请求只创建自己的Reader
+func (p *Proxy) serveHotBlob(c *gin.Context, digest, target string) bool {
+	cfg := p.shared.Config.Get()
+	if !cfg.HotCache.Enabled || !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	reader, contentType, ok := p.hot.NewReader(utils.BuildBlobHotKey(digest))
+	if !ok {
+		return false
+	}
+	if limit := ImageSizeLimit(p.shared.Config.Get(), c, utils.SizeClassDockerBlob, target); limit > 0 && reader.Size() > limit {
+		utils.RespondRegistryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", utils.ErrCodeFileTooLarge, utils.SizeLimitMB(limit))
+		return true
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", fmt.Sprintf("%d", reader.Size()))
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("X-Cache", "HIT")
+	utils.SetCacheOutcome(c, utils.CacheHit)
+
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		fmt.Printf("复制layer内容失败: %v\n", err)
+	}
+	return true
+}
+
+// handleTagsRequest 处理tags列表请求
+func (p *Proxy) handleTagsRequest(c *gin.Context, imageRef string) {
+	cfg := p.shared.Config.Get()
+	repo, err := name.NewRepository(imageRef)
+	if err != nil {
+		fmt.Printf("解析repository失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_INVALID", utils.ErrCodeInvalidRepository)
+		return
+	}
+
+	result, _, err := p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassTags, repo.String(), func() (interface{}, error) {
+		options, cancel := p.withMetadataTimeout(c.Request.Context(), p.options)
+		defer cancel()
+		return remote.List(repo, options...)
+	})
+	if err != nil {
+		utils.Logf(utils.LogError, imageRefHost(imageRef), "获取tags失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusNotFound, "NAME_UNKNOWN", utils.ErrCodeTagsNotFound)
+		}
+		return
+	}
+	tags := result.([]string)
+
+	response := map[string]interface{}{
+		"name": strings.TrimPrefix(imageRef, p.registry.Name()+"/"),
+		"tags": tags,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AuthHandler Docker认证代理
+func (p *Proxy) AuthHandler(c *gin.Context) {
+	cfg := p.shared.Config.Get()
+	p.clients.ApplyResponseHeaderPolicy(c.Writer.Header())
+	if (p.AuthToken != nil && p.AuthToken(c)) || p.handleAnonymousToken(c) {
+		return
+	}
+
+	// 携带凭据的请求获取的token属于该用户，不写入共享缓存，避免提供给相同scope的匿名请求或随状态导出持久化
+	if utils.IsTokenCacheEnabled(cfg) && c.GetHeader("Authorization") == "" {
+		p.proxyDockerAuthWithCache(c)
+	} else {
+		p.proxyDockerAuthOriginal(c)
+	}
+}
+
+// proxyDockerAuthWithCache 带缓存的认证代理，只处理不携带凭据的请求
+func (p *Proxy) proxyDockerAuthWithCache(c *gin.Context) {
+	cacheKey := utils.BuildTokenCacheKey(c.Request.URL.RawQuery)
+
+	if item := p.cache.Get(cacheKey); item != nil && len(item.Data) > 0 {
+		if p.refreshedTokens.CompareAndDelete(cacheKey, item) {
+			p.shared.Stats.RecordTokenRefreshedSave()
+		}
+		if c.Request.Method == http.MethodGet && tokenNeedsRefresh(item, tokenRefreshFraction(p.shared.Config.Get()), time.Now()) {
+			p.refreshTokenInBackground(cacheKey, p.authUpstreamURL(c), utils.TokenFetchRefreshAhead)
+		}
+		utils.SetCacheOutcome(c, utils.CacheHit)
+		utils.WriteTokenResponse(c, string(item.Data))
+		return
+	}
+	utils.SetCacheOutcome(c, utils.CacheMiss)
+	p.shared.Stats.RecordTokenFetch(utils.TokenFetchSync)
+
+	recorder := &ResponseRecorder{
+		ResponseWriter: c.Writer,
+		statusCode:     200,
+	}
+	c.Writer = recorder
+
+	p.proxyDockerAuthOriginal(c)
+
+	if recorder.statusCode == 200 && len(recorder.body) > 0 {
+		ttl := utils.ExtractTTLFromResponse(recorder.body)
+		p.cache.SetToken(cacheKey, string(recorder.body), ttl)
+	}
+
+	c.Writer = recorder.ResponseWriter
+	c.Data(recorder.statusCode, "application/json", recorder.body)
+}
+
+// ResponseRecorder HTTP响应记录器
+type ResponseRecorder struct {
+	gin.ResponseWriter
+	statusCode int
+	body       []byte
+}
+
+func (r *ResponseRecorder) WriteHeader(code int) {
+	r.statusCode = code
+}
+
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+	r.body = append(r.body, data...)
+	return len(data), nil
+}
+
+// dockerHubAuthBase Docker Hub认证服务地址
+var dockerHubAuthBase = "https://auth.docker.io"
+
+// authUpstreamURL 返回认证请求对应的上游地址
+func (p *Proxy) authUpstreamURL(c *gin.Context) string {
+	authURL := dockerHubAuthBase + c.Request.URL.Path
+	if targetDomain, exists := c.Get("target_registry_domain"); exists {
+		if mapping, found := p.RegistryMapping(targetDomain.(string)); found {
+			authURL = "https://" + mapping.AuthHost + c.Request.URL.Path
+		}
+	}
+
+	if c.Request.URL.RawQuery != "" {
+		authURL += "?" + c.Request.URL.RawQuery
+	}
+	return authURL
+}
+
+func (p *Proxy) proxyDockerAuthOriginal(c *gin.Context) {
+	cfg := p.shared.Config.Get()
+	authURL := p.authUpstreamURL(c)
+
+	client := p.clients.Metadata()
+
+	// 合并请求时上游请求不随发起者断开而取消，只保留上下文中的流量统计
+	req, err := http.NewRequestWithContext(
+		context.WithoutCancel(c.Request.Context()),
+		c.Request.Method,
+		authURL,
+		c.Request.Body,
+	)
+	if err != nil {
+		utils.RespondRegistryError(c, http.StatusInternalServerError, "UNKNOWN", utils.ErrCodeInternal)
+		return
+	}
+
+	for key, values := range c.Request.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	fetch := func() (interface{}, error) {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxAuthResponseSize))
+		if err != nil {
+			return nil, err
+		}
+		return &upstreamResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body}, nil
+	}
+
+	var result interface{}
+	if c.Request.Method == http.MethodGet {
+		coalesceKey := utils.BuildCacheKey(authURL, c.Request.Header.Get("Authorization"))
+		result, _, err = p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassToken, coalesceKey, fetch)
+	} else {
+		result, err = fetch()
+	}
+	if err != nil {
+		if utils.BodyTooLarge(err) {
+			respondBodyTooLarge(c)
+			return
+		}
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusBadGateway, "UNKNOWN", utils.ErrCodeAuthFailed)
+		}
+		return
+	}
+	resp := result.(*upstreamResponse)
+
+	proxyHost := c.Request.Host
+	if proxyHost == "" {
+		host := cfg.Server.Host
+		if addr, err := netip.ParseAddr(host); host == "" || err == nil && addr.IsUnspecified() {
+			host = "localhost"
+		}
+		proxyHost = net.JoinHostPort(host, strconv.Itoa(cfg.Server.Port))
+	}
+
+	header := c.Writer.Header()
+	utils.CopyResponseHeaders(cfg, header, resp.Header, req.URL.Host)
+	for i, value := range header.Values("Www-Authenticate") {
+		header["Www-Authenticate"][i] = rewriteAuthHeader(value, proxyHost)
+	}
+	p.clients.ApplyResponseHeaderPolicy(c.Writer.Header())
+
+	c.Status(resp.StatusCode)
+	if _, err := c.Writer.Write(resp.Body); err != nil {
+		fmt.Printf("复制认证响应失败: %v\n", err)
+	}
+}
+
+// maxAuthResponseSize 认证响应体大小上限
+const maxAuthResponseSize = 1 << 20
+
+// upstreamResponse 可在合并请求间共享的上游响应
+type upstreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// rewriteAuthHeader 重写认证头
+func rewriteAuthHeader(authHeader, proxyHost string) string {
+	authHeader = strings.ReplaceAll(authHeader, "https://auth.docker.io", "http://"+proxyHost)
+	authHeader = strings.ReplaceAll(authHeader, "https://ghcr.io", "http://"+proxyHost)
+	authHeader = strings.ReplaceAll(authHeader, "https://gcr.io", "http://"+proxyHost)
+	authHeader = strings.ReplaceAll(authHeader, "https://quay.io", "http://"+proxyHost)
+
+	return authHeader
+}
+
+// handleMultiRegistryRequest 处理多Registry请求
+func (p *Proxy) handleMultiRegistryRequest(c *gin.Context, registryDomain, remainingPath string) {
+	mapping, exists := p.RegistryMapping(registryDomain)
+	if !exists {
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_UNKNOWN", utils.ErrCodeRegistryNotConfigured)
+		return
+	}
+
+	imageName, apiType, reference := ParseRegistryPath(remainingPath)
+	if imageName == "" || apiType == "" {
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_INVALID", utils.ErrCodeInvalidPath)
+		return
+	}
+	imageName, ok := p.normalizeRegistryImageName(c, imageName)
+	if !ok {
+		return
+	}
+
+	fullImageName := registryDomain + "/" + imageName
+	if allowed, reason := p.access.CheckDockerAccess(fullImageName); !allowed {
+		fmt.Printf("镜像 %s 访问被拒绝: %s\n", fullImageName, reason)
+		utils.RespondRegistryError(c, http.StatusForbidden, "DENIED", utils.ErrCodeImageAccessDenied)
+		return
+	}
+
+	upstreamImageRef := fmt.Sprintf("%s/%s", mapping.Upstream, imageName)
+
+	switch apiType {
+	case "manifests":
+		p.handleUpstreamManifestRequest(c, upstreamImageRef, reference, mapping)
+	case "blobs":
+		p.handleUpstreamBlobRequest(c, upstreamImageRef, reference, fullImageName, mapping)
+	case "tags":
+		p.handleUpstreamTagsRequest(c, upstreamImageRef, mapping)
+	default:
+		utils.RespondRegistryError(c, http.StatusNotFound, "UNSUPPORTED", utils.ErrCodeEndpointNotFound)
+	}
+}
+
+// handleUpstreamManifestRequest 处理上游Registry的manifest请求
+func (p *Proxy) handleUpstreamManifestRequest(c *gin.Context, imageRef, reference string, mapping config.RegistryMapping) {
+	cfg := p.shared.Config.Get()
+	withClientAccept(c)
+	options := p.UpstreamOptions(mapping)
+	if utils.IsCacheEnabled(cfg) && c.Request.Method == http.MethodGet && p.serveCachedManifest(c, imageRef, reference, options) {
+		return
+	}
+
+	if p.serveNegativeManifest(c, imageRef, reference) {
+		return
+	}
+
+	ref, err := parseManifestReference(imageRef, reference)
+	if err != nil {
+		fmt.Printf("解析镜像引用失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "TAG_INVALID", utils.ErrCodeInvalidReference)
+		return
+	}
+
+	if c.Request.Method == http.MethodHead {
+		result, _, err := p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassManifestHead, manifestHeadKey(c.Request.Context(), ref), func() (interface{}, error) {
+			options, cancel := p.withMetadataTimeout(c.Request.Context(), options)
+			defer cancel()
+			return remote.Head(ref, options...)
+		})
+		if err != nil {
+			utils.Logf(utils.LogError, imageRefHost(imageRef), "HEAD请求失败: %v", err)
+			p.respondManifestError(c, imageRef, reference, err)
+			return
+		}
+		desc := result.(*v1.Descriptor)
+
+		c.Header("Content-Type", string(desc.MediaType))
+		c.Header("Docker-Content-Digest", desc.Digest.String())
+		c.Header("Content-Length", fmt.Sprintf("%d", desc.Size))
+		c.Status(http.StatusOK)
+	} else {
+		if utils.IsCacheEnabled(cfg) {
+			if p.serveRevalidatedManifest(c, imageRef, reference, options) {
+				return
+			}
+			utils.SetCacheOutcome(c, utils.CacheMiss)
+		}
+		options, cancel := p.withMetadataTimeout(c.Request.Context(), options)
+		defer cancel()
+		desc, err := remote.Get(ref, options...)
+		if err != nil {
+			utils.Logf(utils.LogError, imageRefHost(imageRef), "GET请求失败: %v", err)
+			if p.serveStaleManifestOnError(c, imageRef, reference, err) {
+				return
+			}
+			p.respondManifestError(c, imageRef, reference, err)
+			return
+		}
+
+		headers := manifestHeaders(desc)
+		if utils.IsCacheEnabled(cfg) && utils.CacheWriteAllowed(cfg, c) {
+			p.cacheManifest(c.Request.Context(), imageRef, reference, desc, headers)
+		}
+
+		c.Header("Content-Type", string(desc.MediaType))
+		for key, value := range headers {
+			c.Header(key, value)
+		}
+
+		c.Data(http.StatusOK, string(desc.MediaType), desc.Manifest)
+	}
+}
+
+// handleUpstreamBlobRequest 处理上游Registry的blob请求
+func (p *Proxy) handleUpstreamBlobRequest(c *gin.Context, imageRef, digest, target string, mapping config.RegistryMapping) {
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest))
+	if err != nil {
+		fmt.Printf("解析digest引用失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "DIGEST_INVALID", utils.ErrCodeInvalidDigest)
+		return
+	}
+	if p.screenBlobDigest(c, digest) || p.serveHotBlob(c, digest, target) || p.serveCachedBlobBackend(c, imageRef, digest, target) {
+		return
+	}
+
+	options := append(p.UpstreamOptions(mapping), remote.WithContext(c.Request.Context()))
+	layer, err := remote.Layer(digestRef, options...)
+	if err != nil {
+		utils.Logf(utils.LogError, imageRefHost(imageRef), "获取layer失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusNotFound, "BLOB_UNKNOWN", utils.ErrCodeLayerNotFound)
+		}
+		return
+	}
+
+	p.writeBlob(c, layer, digest, target)
+}
+
+// handleUpstreamTagsRequest 处理上游Registry的tags请求
+func (p *Proxy) handleUpstreamTagsRequest(c *gin.Context, imageRef string, mapping config.RegistryMapping) {
+	cfg := p.shared.Config.Get()
+	repo, err := name.NewRepository(imageRef)
+	if err != nil {
+		fmt.Printf("解析repository失败: %v\n", err)
+		utils.RespondRegistryError(c, http.StatusBadRequest, "NAME_INVALID", utils.ErrCodeInvalidRepository)
+		return
+	}
+
+	options := p.UpstreamOptions(mapping)
+	result, _, err := p.shared.Coalescers.Coalesce(cfg, utils.CoalesceClassTags, repo.String(), func() (interface{}, error) {
+		options, cancel := p.withMetadataTimeout(c.Request.Context(), options)
+		defer cancel()
+		return remote.List(repo, options...)
+	})
+	if err != nil {
+		utils.Logf(utils.LogError, imageRefHost(imageRef), "获取tags失败: %v", err)
+		if !p.respondRegistryLimitError(c, err) {
+			utils.RespondRegistryError(c, http.StatusNotFound, "NAME_UNKNOWN", utils.ErrCodeTagsNotFound)
+		}
+		return
+	}
+	tags := result.([]string)
+
+	response := map[string]interface{}{
+		"name": strings.TrimPrefix(imageRef, mapping.Upstream+"/"),
+		"tags": tags,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// withMetadataTimeout 为manifest、tags等元数据请求附加总超时
+// 保留parent中的值用于流量统计，但不随客户端断开取消，合并请求的其他等待者仍需要结果；服务停止时取消
+func (p *Proxy) withMetadataTimeout(parent context.Context, options []remote.Option) ([]remote.Option, context.CancelFunc) {
+	ctx, cancel := p.clients.MetadataContext(context.WithoutCancel(parent))
+	stop := context.AfterFunc(p.shared.Background.Context(), cancel)
+	return append(append([]remote.Option(nil), options...), remote.WithContext(ctx)), func() {
+		stop()
+		cancel()
+	}
+}
+
+// UpstreamOptions 创建访问上游Registry的选项
+func (p *Proxy) UpstreamOptions(mapping config.RegistryMapping) []remote.Option {
+	options := []remote.Option{
+		remote.WithAuth(authn.Anonymous),
+		remote.WithUserAgent("hubproxy/go-containerregistry"),
+		remote.WithTransport(p.clients.Transport()),
+	}
+
+	// 预留将来不同Registry的差异化认证逻辑扩展点
+	switch mapping.AuthType {
+	case "github":
+	case "google":
+	case "quay":
+	}
+
+	return options
+}
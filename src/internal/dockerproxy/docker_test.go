@@ -0,0 +1,857 @@
+package dockerproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestParseRegistryPath(t *testing.T) {
+	tests := []struct {
+		path      string
+		image     string
+		apiType   string
+		reference string
+	}{
+		{"library/nginx/manifests/latest", "library/nginx", "manifests", "latest"},
+		{"library/nginx/blobs/sha256:abc", "library/nginx", "blobs", "sha256:abc"},
+		{"library/nginx/tags/list", "library/nginx", "tags", "list"},
+	}
+
+	for _, tt := range tests {
+		image, apiType, reference := ParseRegistryPath(tt.path)
+		if image != tt.image || apiType != tt.apiType || reference != tt.reference {
+			t.Fatalf("ParseRegistryPath(%q) = %q %q %q", tt.path, image, apiType, reference)
+		}
+	}
+}
+
+func TestParseRegistryPathInvalid(t *testing.T) {
+	image, apiType, reference := ParseRegistryPath("library/nginx/unknown/latest")
+	if image != "" || apiType != "" || reference != "" {
+		t.Fatalf("invalid path parsed as %q %q %q", image, apiType, reference)
+	}
+}
+
+func TestRegistryConfigHotReload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const enabledConfig = `
+[registries."quay.io"]
+upstream = "quay.io"
+authHost = "quay.io"
+authType = "quay"
+enabled = true
+`
+	const disabledConfig = `
+[registries."quay.io"]
+upstream = "quay.io"
+authHost = "auth.quay.example"
+authType = "quay"
+enabled = false
+`
+	loadTestConfig(t, enabledConfig)
+	p := newTestProxy()
+	reloader := config.NewReloader(p.shared.Config)
+	reloader.Register(p)
+
+	mapping, ok := p.RegistryMapping("quay.io")
+	if !ok || mapping.AuthHost != "quay.io" {
+		t.Fatalf("quay.io mapping = %+v, %v", mapping, ok)
+	}
+
+	if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte(disabledConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := p.RegistryMapping("quay.io"); ok {
+		t.Fatal("quay.io still enabled after reload")
+	}
+	if got := p.registries.Load().mappings["quay.io"].AuthHost; got != "auth.quay.example" {
+		t.Fatalf("authHost after reload = %q", got)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/quay.io/org/app/manifests/latest", nil)
+	p.RegistryHandler(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+	var body struct {
+		Errors []struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Errors) != 1 || body.Errors[0].Code != "DENIED" {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
+
+func TestRegistryResponseHeaderPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	reloader := config.NewReloader(p.shared.Config)
+	reloader.Register(p.clients)
+	t.Cleanup(func() { config.Apply(config.DefaultConfig()) })
+
+	reloaded := "[proxy.responseHeaders]\nset = { X-Proxied-By = \"hubproxy\" }\n"
+	if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte(reloaded), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Docker接口的响应同样补充set中的固定值
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	p.RegistryHandler(c)
+	if w.Header().Get("X-Proxied-By") != "hubproxy" {
+		t.Fatalf("registry headers = %v", w.Header())
+	}
+}
+
+func TestRegistryTablePrefersLongestDomain(t *testing.T) {
+	table := buildRegistryTable(map[string]config.RegistryMapping{
+		"gcr.io":    {Enabled: true},
+		"us.gcr.io": {Enabled: true},
+		"k8s.io":    {Enabled: true},
+	})
+	if table.domains[0] != "us.gcr.io" {
+		t.Fatalf("domains = %v", table.domains)
+	}
+}
+
+func TestNegativeManifestCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[dockerCache]
+negativeTTL = "30s"
+`)
+	p := newTestProxy()
+	t.Cleanup(func() {
+		p.cache.Flush(utils.NegativeManifestCachePrefix)
+	})
+
+	imageRef := "registry-1.docker.io/library/nginxx"
+	notFound := &transport.Error{
+		StatusCode: http.StatusNotFound,
+		Errors:     []transport.Diagnostic{{Code: transport.NameUnknownErrorCode}},
+	}
+
+	newContext := func(auth string) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/library/nginxx/manifests/latest", nil)
+		if auth != "" {
+			c.Request.Header.Set("Authorization", auth)
+		}
+		return c, w
+	}
+
+	c, w := newContext("Bearer private")
+	p.respondManifestError(c, imageRef, "latest", notFound)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	if c, _ := newContext(""); p.serveNegativeManifest(c, imageRef, "latest") {
+		t.Fatal("authenticated 404 was cached")
+	}
+
+	c, _ = newContext("")
+	p.respondManifestError(c, imageRef, "latest", notFound)
+
+	c, w = newContext("")
+	if !p.serveNegativeManifest(c, imageRef, "latest") {
+		t.Fatal("anonymous 404 not served from cache")
+	}
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NAME_UNKNOWN") {
+		t.Fatalf("cached response = %d %s", w.Code, w.Body.String())
+	}
+	if c, _ := newContext("Bearer private"); p.serveNegativeManifest(c, imageRef, "latest") {
+		t.Fatal("cache not bypassed for request with credentials")
+	}
+
+	c, _ = newContext("")
+	p.respondManifestError(c, imageRef, "v2", errors.New("connection reset"))
+	if c, _ := newContext(""); p.serveNegativeManifest(c, imageRef, "v2") {
+		t.Fatal("non-404 error was cached")
+	}
+}
+
+func TestNegativeManifestCacheTokenMode(t *testing.T) {
+	var manifestGets atomic.Int32
+	upstream := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			manifestGets.Add(1)
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	router := newV2BaseRouter(t, fmt.Sprintf(`
+[v2]
+challenge = "token"
+
+[dockerCache]
+negativeTTL = "30s"
+
+[registries."fake.test"]
+upstream = %q
+enabled = true
+`, strings.TrimPrefix(server.URL, "http://")))
+	p := newTestProxy()
+	t.Cleanup(func() { p.cache.Flush(utils.NegativeManifestCachePrefix) })
+
+	w := v2BaseRequest(router, http.MethodGet, "/token?service=hubproxy", nil)
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil || issued.Token == "" {
+		t.Fatalf("token response = %d %s", w.Code, w.Body.String())
+	}
+	auth := http.Header{"Authorization": {"Bearer " + issued.Token}}
+
+	if w := v2BaseRequest(router, http.MethodGet, "/v2/", auth); w.Code != http.StatusOK {
+		t.Fatalf("/v2/ with issued token = %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		w := v2BaseRequest(router, http.MethodGet, "/v2/fake.test/org/missing/manifests/latest", auth)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("request %d status = %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	if got := manifestGets.Load(); got != 1 {
+		t.Fatalf("upstream manifest requests = %d, want 1", got)
+	}
+
+	// 非本代理签发的令牌视为上游凭据，不使用不存在缓存
+	forged := http.Header{"Authorization": {"Bearer " + anonymousTokenPrefix + "9999999999.nonce.00"}}
+	v2BaseRequest(router, http.MethodGet, "/v2/fake.test/org/missing/manifests/latest", forged)
+	if got := manifestGets.Load(); got != 2 {
+		t.Fatalf("upstream manifest requests with foreign token = %d, want 2", got)
+	}
+}
+
+func TestManifestUpstreamThrottled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	// 上游Registry的429不应被报告为manifest不存在，Retry-After取传输层记录的上游等待时长
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+	resp, err := p.clients.Global().Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	c, w := newManifestContext()
+	throttled := &transport.Error{StatusCode: http.StatusTooManyRequests, Request: resp.Request}
+	p.respondManifestError(c, "registry-1.docker.io/org/app", "v1", throttled)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "TOOMANYREQUESTS") {
+		t.Fatalf("response = %d %s", w.Code, w.Body.String())
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter < 119 || retryAfter > 120 {
+		t.Fatalf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), `"retry_jitter"`) {
+		t.Fatalf("body without retry detail: %s", w.Body.String())
+	}
+}
+
+func newManifestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/org/app/manifests/v1", nil)
+	return c, w
+}
+
+func TestStaleWhileRevalidateManifest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[dockerCache]
+staleWhileRevalidate = "10m"
+`)
+	p := newTestProxy()
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := image.RawManifest()
+
+	cacheKey := utils.BuildManifestCacheKey(imageRef, "v1", "")
+	t.Cleanup(func() { p.cache.Flush(cacheKey) })
+	p.cache.SetWithStale(cacheKey, []byte("stale"), "application/json", nil, -time.Minute, time.Hour)
+
+	c, w := newManifestContext()
+	if !p.serveCachedManifest(c, imageRef, "v1", p.UpstreamOptions(config.RegistryMapping{})) {
+		t.Fatal("stale manifest not served")
+	}
+	if w.Body.String() != "stale" || w.Header().Get("X-Cache") != "STALE" || !strings.HasPrefix(w.Header().Get("Warning"), "110") {
+		t.Fatalf("stale response = %q %v", w.Body.String(), w.Header())
+	}
+	if w.Header().Get("Age") == "" {
+		t.Fatal("Age header missing")
+	}
+
+	// 后台刷新完成后缓存恢复为最新数据
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if item := p.cache.Get(cacheKey); item != nil {
+			if string(item.Data) != string(want) {
+				t.Fatalf("refreshed manifest mismatch")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("manifest not refreshed in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 超出stale-while-revalidate时间的旧数据不直接返回
+	p.cache.SetWithStale(cacheKey, []byte("stale"), "application/json", nil, -20*time.Minute, time.Hour)
+	if c, _ := newManifestContext(); p.serveCachedManifest(c, imageRef, "v1", nil) {
+		t.Fatal("manifest beyond stale-while-revalidate window served")
+	}
+}
+
+func TestStaleIfErrorManifest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[dockerCache]
+staleIfError = "30m"
+`)
+	p := newTestProxy()
+
+	imageRef := "registry.example.com/org/app"
+	cacheKey := utils.BuildManifestCacheKey(imageRef, "v1", "")
+	t.Cleanup(func() { p.cache.Flush(cacheKey) })
+	p.cache.SetWithStale(cacheKey, []byte("stale"), "application/json", nil, -time.Minute, time.Hour)
+
+	unavailable := &transport.Error{StatusCode: http.StatusServiceUnavailable}
+	c, w := newManifestContext()
+	if !p.serveStaleManifestOnError(c, imageRef, "v1", unavailable) {
+		t.Fatal("stale manifest not served on 503")
+	}
+	if w.Body.String() != "stale" || !strings.HasPrefix(w.Header().Get("Warning"), "111") {
+		t.Fatalf("stale-if-error response = %q %v", w.Body.String(), w.Header())
+	}
+
+	if c, _ := newManifestContext(); !p.serveStaleManifestOnError(c, imageRef, "v1", errors.New("connection refused")) {
+		t.Fatal("stale manifest not served on connection failure")
+	}
+
+	notFound := &transport.Error{StatusCode: http.StatusNotFound}
+	if c, _ := newManifestContext(); p.serveStaleManifestOnError(c, imageRef, "v1", notFound) {
+		t.Fatal("stale manifest served on 404")
+	}
+
+	p.cache.SetWithStale(cacheKey, []byte("stale"), "application/json", nil, -time.Hour, 2*time.Hour)
+	if c, _ := newManifestContext(); p.serveStaleManifestOnError(c, imageRef, "v1", unavailable) {
+		t.Fatal("manifest beyond stale-if-error window served")
+	}
+}
+
+// fetchUpstreamBlob 通过上游blob处理器获取第一个镜像层
+func fetchUpstreamBlob(p *Proxy, imageRef, digest string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/org/app/blobs/"+digest, nil)
+	p.handleUpstreamBlobRequest(c, imageRef, digest, imageRef, config.RegistryMapping{})
+	return w
+}
+
+// firstLayerDigest 返回测试镜像第一层的digest和内容
+func firstLayerDigest(t testing.TB, imageRef string) (string, []byte) {
+	t.Helper()
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	image, err := remote.Image(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, _ := image.Layers()
+	digest, _ := layers[0].Digest()
+	reader, _ := layers[0].Compressed()
+	defer reader.Close()
+	data, _ := io.ReadAll(reader)
+	return digest.String(), data
+}
+
+func TestDockerBlobHotCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[hotCache]\npromoteAfter = 2\n")
+	p := newTestProxy()
+	t.Cleanup(func() { p.hot.Flush("") })
+	imageRef, server := pushRandomImage(t)
+	digest, want := firstLayerDigest(t, imageRef)
+
+	for i := 0; i < 2; i++ {
+		if w := fetchUpstreamBlob(p, imageRef, digest); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "" {
+			t.Fatalf("request %d: status %d, X-Cache %q", i+1, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+
+	// 第二次请求后提升到内存，上游不可用时仍可返回
+	server.Close()
+	before := p.hot.Stats().MemoryHits
+	w := fetchUpstreamBlob(p, imageRef, digest)
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" || string(w.Body.Bytes()) != string(want) {
+		t.Fatalf("memory hit: status %d, X-Cache %q, %d bytes", w.Code, w.Header().Get("X-Cache"), w.Body.Len())
+	}
+	if w.Header().Get("Docker-Content-Digest") != digest || w.Header().Get("Content-Length") != strconv.Itoa(len(want)) {
+		t.Fatalf("memory hit headers = %v", w.Header())
+	}
+	if p.hot.Stats().MemoryHits != before+1 {
+		t.Fatal("memory hit not counted")
+	}
+
+	// 管理接口清除缓存时热点对象随同清除
+	if removed := p.hot.Flush(utils.BlobHotCachePrefix); removed != 1 {
+		t.Fatalf("flush removed %d", removed)
+	}
+	if w := fetchUpstreamBlob(p, imageRef, digest); w.Code == http.StatusOK {
+		t.Fatal("flushed blob still served")
+	}
+}
+
+// BenchmarkHotBlob 对比小镜像层在内存命中和回源时的延迟
+func BenchmarkHotBlob(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(b, "[hotCache]\npromoteAfter = 1\n")
+	p := newTestProxy()
+	b.Cleanup(func() { p.hot.Flush("") })
+	imageRef, _ := pushRandomImage(b)
+	digest, _ := firstLayerDigest(b, imageRef)
+
+	b.Run("memory", func(b *testing.B) {
+		fetchUpstreamBlob(p, imageRef, digest)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if w := fetchUpstreamBlob(p, imageRef, digest); w.Header().Get("X-Cache") != "HIT" {
+				b.Fatal("not served from memory")
+			}
+		}
+	})
+	b.Run("upstream", func(b *testing.B) {
+		p.hot.Flush("")
+		loadTestConfig(b, "[hotCache]\nenabled = false\n")
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if w := fetchUpstreamBlob(p, imageRef, digest); w.Code != http.StatusOK {
+				b.Fatalf("status %d", w.Code)
+			}
+		}
+	})
+}
+
+func TestManifestRevalidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 关闭请求合并，避免合并窗口内复用上一次HEAD的结果
+	loadTestConfig(t, "[debounce]\nenabled = false\n")
+	p := newTestProxy()
+
+	var heads, gets atomic.Int64
+	upstream := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			switch r.Method {
+			case http.MethodHead:
+				heads.Add(1)
+			case http.MethodGet:
+				gets.Add(1)
+			}
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	push := func() (string, []byte) {
+		image, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, image); err != nil {
+			t.Fatal(err)
+		}
+		digest, _ := image.Digest()
+		manifest, _ := image.RawManifest()
+		return digest.String(), manifest
+	}
+
+	cacheKey := utils.BuildManifestCacheKey(imageRef, "v1", "")
+	t.Cleanup(func() { p.cache.Flush(cacheKey) })
+	expire := func() {
+		item := p.cache.Get(cacheKey)
+		if item == nil {
+			t.Fatal("manifest not cached")
+		}
+		item.ExpiresAt = time.Now().Add(-time.Minute)
+		item.StaleUntil = item.ExpiresAt
+	}
+	fetch := func() (*httptest.ResponseRecorder, int64, int64) {
+		headsBefore, getsBefore := heads.Load(), gets.Load()
+		c, w := newManifestContext()
+		p.handleUpstreamManifestRequest(c, imageRef, "v1", config.RegistryMapping{})
+		return w, heads.Load() - headsBefore, gets.Load() - getsBefore
+	}
+
+	digest, manifest := push()
+	if w, _, g := fetch(); w.Code != http.StatusOK || w.Body.String() != string(manifest) || g != 1 {
+		t.Fatalf("initial fetch: status %d, upstream GETs %d", w.Code, g)
+	}
+
+	// 上游未变化：只发送HEAD，续期缓存
+	before := p.cache.Stats()["manifest"]
+	expire()
+	w, h, g := fetch()
+	if h != 1 || g != 0 || w.Header().Get("X-Cache") != "REVALIDATED" {
+		t.Fatalf("revalidation: HEADs %d, GETs %d, X-Cache %q", h, g, w.Header().Get("X-Cache"))
+	}
+	if w.Body.String() != string(manifest) || w.Header().Get("Docker-Content-Digest") != digest {
+		t.Fatalf("revalidated manifest differs: digest %s", w.Header().Get("Docker-Content-Digest"))
+	}
+	after := p.cache.Stats()["manifest"]
+	if after.Revalidated != before.Revalidated+1 || after.RevalidationSavedBytes != before.RevalidationSavedBytes+uint64(len(manifest)) {
+		t.Fatalf("revalidation stats %+v -> %+v", before, after)
+	}
+	if p.cache.Get(cacheKey) == nil {
+		t.Fatal("cache entry not renewed")
+	}
+
+	// tag指向新镜像：digest不一致，重新拉取并替换缓存
+	newDigest, newManifest := push()
+	expire()
+	w, h, g = fetch()
+	if h != 1 || g != 1 || w.Header().Get("X-Cache") == "REVALIDATED" {
+		t.Fatalf("changed tag: HEADs %d, GETs %d, X-Cache %q", h, g, w.Header().Get("X-Cache"))
+	}
+	if w.Body.String() != string(newManifest) || w.Header().Get("Docker-Content-Digest") != newDigest {
+		t.Fatalf("changed tag served digest %s, want %s", w.Header().Get("Docker-Content-Digest"), newDigest)
+	}
+	if item := p.cache.Get(cacheKey); item == nil || item.Headers["Docker-Content-Digest"] != newDigest {
+		t.Fatal("cache not replaced with new manifest")
+	}
+}
+
+func TestManifestClientCacheDirectives(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[debounce]\nenabled = false\n")
+	p := newTestProxy()
+
+	var gets atomic.Int64
+	upstream := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	push := func() string {
+		image, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, image); err != nil {
+			t.Fatal(err)
+		}
+		digest, _ := image.Digest()
+		return digest.String()
+	}
+	cacheKey := utils.BuildManifestCacheKey(imageRef, "v1", "")
+	t.Cleanup(func() { p.cache.Flush(cacheKey) })
+	cachedDigest := func() string {
+		if item := p.cache.Get(cacheKey); item != nil {
+			return item.Headers["Docker-Content-Digest"]
+		}
+		return ""
+	}
+	fetch := func(header, value string) (*httptest.ResponseRecorder, int64) {
+		before := gets.Load()
+		c, w := newManifestContext()
+		if header != "" {
+			c.Request.Header.Set(header, value)
+		}
+		p.handleUpstreamManifestRequest(c, imageRef, "v1", config.RegistryMapping{})
+		return w, gets.Load() - before
+	}
+
+	first := push()
+	fetch("", "")
+	second := push()
+	if w, g := fetch("", ""); g != 0 || w.Header().Get("Docker-Content-Digest") != first || w.Header().Get("X-Cache-Bypass") != "" {
+		t.Fatalf("cached read: GETs %d, digest %s", g, w.Header().Get("Docker-Content-Digest"))
+	}
+
+	// no-cache：跳过缓存读取，新响应写入缓存
+	w, g := fetch("Cache-Control", "no-cache")
+	if g != 1 || w.Header().Get("Docker-Content-Digest") != second || w.Header().Get("X-Cache-Bypass") != "1" {
+		t.Fatalf("no-cache: GETs %d, digest %s, bypass %q", g, w.Header().Get("Docker-Content-Digest"), w.Header().Get("X-Cache-Bypass"))
+	}
+	if cachedDigest() != second {
+		t.Fatal("no-cache response not written to cache")
+	}
+
+	// no-store：既不读取也不写入
+	third := push()
+	w, g = fetch("Cache-Control", "no-store")
+	if g != 1 || w.Header().Get("Docker-Content-Digest") != third || w.Header().Get("X-Cache-Bypass") != "1" {
+		t.Fatalf("no-store: GETs %d, digest %s", g, w.Header().Get("Docker-Content-Digest"))
+	}
+	if cachedDigest() != second {
+		t.Fatal("no-store response written to cache")
+	}
+	if w, g = fetch("Pragma", "no-cache"); g != 1 || w.Header().Get("Docker-Content-Digest") != third {
+		t.Fatalf("Pragma: no-cache: GETs %d", g)
+	}
+
+	// 关闭后忽略客户端指令
+	loadTestConfig(t, "[debounce]\nenabled = false\n\n[cache]\nhonorClientDirectives = false\n")
+	p.shared.Config.Set(config.GetConfig())
+	push()
+	if w, g = fetch("Cache-Control", "no-cache, no-store"); g != 0 || w.Header().Get("Docker-Content-Digest") != third || w.Header().Get("X-Cache-Bypass") != "" {
+		t.Fatalf("directives honored while disabled: GETs %d, digest %s", g, w.Header().Get("Docker-Content-Digest"))
+	}
+}
+
+// negotiatingRegistry 按Accept协商manifest格式的测试上游：同一tag有docker-v2 manifest list和OCI index两种变体，
+// 返回Accept中最先列出的可用变体，没有可用变体时返回manifest list；received记录收到的Accept头
+func negotiatingRegistry(t *testing.T) (*httptest.Server, map[string][]byte, func() []string) {
+	t.Helper()
+	variants := map[string][]byte{}
+	for _, mediaType := range []string{"application/vnd.docker.distribution.manifest.list.v2+json", "application/vnd.oci.image.index.v1+json"} {
+		variants[mediaType] = []byte(`{"schemaVersion":2,"mediaType":"` + mediaType + `","manifests":[]}`)
+	}
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mu.Lock()
+		received = r.Header.Values("Accept")
+		mu.Unlock()
+		mediaType := "application/vnd.docker.distribution.manifest.list.v2+json"
+	choose:
+		for _, value := range r.Header.Values("Accept") {
+			for _, part := range strings.Split(value, ",") {
+				candidate := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+				if _, ok := variants[candidate]; ok {
+					mediaType = candidate
+					break choose
+				}
+			}
+		}
+		body := variants[mediaType]
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(body)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, variants, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+func TestManifestContentNegotiation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[debounce]\nenabled = false\n")
+	p := newTestProxy()
+	t.Cleanup(func() { p.cache.Flush(utils.ManifestCachePrefix) })
+
+	const (
+		dockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+		dockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+		ociIndex       = "application/vnd.oci.image.index.v1+json"
+	)
+	server, variants, received := negotiatingRegistry(t)
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+	digestOf := func(mediaType string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(variants[mediaType]))
+	}
+	fetch := func(accept ...string) *httptest.ResponseRecorder {
+		c, w := newManifestContext()
+		for _, value := range accept {
+			c.Request.Header.Add("Accept", value)
+		}
+		p.handleUpstreamManifestRequest(c, imageRef, "v1", config.RegistryMapping{})
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		accept []string
+		want   string
+	}{
+		{"docker-v2", []string{dockerManifest + ", " + dockerList}, dockerList},
+		{"oci", []string{ociIndex, "application/vnd.oci.image.manifest.v1+json"}, ociIndex},
+		{"mixed", []string{dockerList + ";q=0.9, " + ociIndex + ";q=0.5", dockerManifest}, dockerList},
+	}
+	for _, tt := range tests {
+		w := fetch(tt.accept...)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != tt.want || w.Header().Get("Docker-Content-Digest") != digestOf(tt.want) {
+			t.Fatalf("%s: status %d, Content-Type %q, digest %s", tt.name, w.Code, w.Header().Get("Content-Type"), w.Header().Get("Docker-Content-Digest"))
+		}
+		if got := received(); strings.Join(got, "\n") != strings.Join(tt.accept, "\n") {
+			t.Fatalf("%s: upstream Accept = %q, want %q", tt.name, got, tt.accept)
+		}
+		if w.Body.String() != string(variants[tt.want]) {
+			t.Fatalf("%s: body = %s", tt.name, w.Body.String())
+		}
+	}
+
+	// 每个Accept集合各有一个缓存项，重复请求得到各自的变体
+	for _, tt := range tests {
+		ctx := utils.WithManifestAccept(context.Background(), tt.accept)
+		item := p.cache.Get(manifestCacheKey(ctx, imageRef, "v1"))
+		if item == nil || item.ContentType != tt.want || item.Headers["Docker-Content-Digest"] != digestOf(tt.want) {
+			t.Fatalf("%s: cache entry = %+v", tt.name, item)
+		}
+		if w := fetch(tt.accept...); w.Header().Get("Content-Type") != tt.want || w.Header().Get("Docker-Content-Digest") != digestOf(tt.want) {
+			t.Fatalf("%s: cached Content-Type %q", tt.name, w.Header().Get("Content-Type"))
+		}
+	}
+	if p.cache.Get(utils.BuildManifestCacheKey(imageRef, "v1", "")) != nil {
+		t.Fatal("negotiated manifest stored under default key")
+	}
+
+	// 大小写、顺序和参数不同的同一Accept集合共用缓存项
+	before := received()
+	if w := fetch("application/vnd.oci.image.manifest.v1+json;q=0.8", strings.ToUpper(ociIndex)); w.Header().Get("Content-Type") != ociIndex {
+		t.Fatalf("normalized accept: Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	if strings.Join(received(), "\n") != strings.Join(before, "\n") {
+		t.Fatal("normalized accept set not served from cache")
+	}
+
+	// 上游返回的类型不在Accept范围内：原样返回，不写入缓存
+	w := fetch(dockerManifest)
+	if w.Header().Get("Content-Type") != dockerList || w.Header().Get("Docker-Content-Digest") != digestOf(dockerList) {
+		t.Fatalf("mismatch: Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	ctx := utils.WithManifestAccept(context.Background(), []string{dockerManifest})
+	if p.cache.Get(manifestCacheKey(ctx, imageRef, "v1")) != nil {
+		t.Fatal("manifest outside client Accept was cached")
+	}
+}
+
+func TestRegistryUppercaseRepositoryNames(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := newTestProxy()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, path, nil)
+		p.RegistryHandler(c)
+		return w
+	}
+
+	loadTestConfig(t, `
+[access]
+blackList = ["myorg/myimage", "ghcr.io/org/app"]
+`)
+	for path, suggested := range map[string]string{
+		"/v2/MyOrg/MyImage/manifests/Latest":   "myorg/myimage",
+		"/v2/ghcr.io/Org/App/blobs/sha256:abc": "org/app",
+	} {
+		w := serve(path)
+		if w.Code != http.StatusBadRequest || w.Header().Get("X-Error-Code") != utils.ErrCodeRepositoryUppercase ||
+			!strings.Contains(w.Body.String(), "NAME_INVALID") || !strings.Contains(w.Body.String(), "请改用 "+suggested+`"`) {
+			t.Fatalf("%s: status %d, body %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	// 开启autoLowercase后按小写名称匹配名单
+	loadTestConfig(t, `
+[access]
+blackList = ["myorg/myimage", "ghcr.io/org/app"]
+
+[proxy.docker]
+autoLowercase = true
+`)
+	p.shared.Config.Set(config.GetConfig())
+	for _, path := range []string{"/v2/MyOrg/MyImage/manifests/Latest", "/v2/ghcr.io/Org/App/blobs/sha256:abc"} {
+		if w := serve(path); w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != utils.ErrCodeImageAccessDenied {
+			t.Fatalf("%s: status %d, body %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func loadTestConfig(t testing.TB, body string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestProxy 按当前配置创建使用独立缓存和上游客户端的代理
+func newTestProxy() *Proxy {
+	shared := utils.NewComponents(config.GetConfig())
+	p, err := New(utils.NewUniversalCache(shared.Background.Context(), shared.Config), utils.NewHotCache(shared.Config), accesscontrol.New(shared.Config), utils.NewHTTPClients(shared.Config, shared.Stats), shared)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
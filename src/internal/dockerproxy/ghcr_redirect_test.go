@@ -0,0 +1,188 @@
+package dockerproxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// recordedResponse 录制的上游响应
+type recordedResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// ghcrBlobFixture 录制的ghcr.io blob拉取流程：认证质询、token、重定向到存储服务、存储服务的响应头
+type ghcrBlobFixture struct {
+	Challenge recordedResponse `json:"challenge"`
+	Token     recordedResponse `json:"token"`
+	Redirect  recordedResponse `json:"redirect"`
+	Blob      recordedResponse `json:"blob"`
+}
+
+// ghcrRedirectUpstream 按录制的流程模拟ghcr.io和它重定向到的存储服务，记录存储服务收到的请求头
+type ghcrRedirectUpstream struct {
+	registryHost string
+	digest       string
+	blob         []byte
+
+	mu             sync.Mutex
+	registryAuth   []string
+	storageAuth    []string
+	storageRequest int
+}
+
+func newGHCRRedirectUpstream(t *testing.T, blob []byte, corrupt bool) *ghcrRedirectUpstream {
+	t.Helper()
+	data, err := os.ReadFile("testdata/ghcr_blob_redirect.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture ghcrBlobFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &ghcrRedirectUpstream{digest: fmt.Sprintf("sha256:%x", sha256.Sum256(blob)), blob: blob}
+	served := blob
+	if corrupt {
+		served = bytes.ToUpper(blob)
+	}
+
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.storageAuth = append(u.storageAuth, r.Header.Get("Authorization"))
+		u.storageRequest++
+		u.mu.Unlock()
+		if r.URL.Query().Get("sig") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for key, value := range fixture.Blob.Headers {
+			w.Header().Set(key, value)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(served))
+	}))
+	t.Cleanup(storage.Close)
+
+	var registry *httptest.Server
+	write := func(w http.ResponseWriter, recorded recordedResponse) {
+		for key, value := range recorded.Headers {
+			value = strings.ReplaceAll(value, "{registry}", "http://"+u.registryHost)
+			value = strings.ReplaceAll(value, "{storage}", storage.URL)
+			value = strings.ReplaceAll(value, "{digest}", u.digest)
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(recorded.Status)
+		w.Write([]byte(recorded.Body))
+	}
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			write(w, fixture.Token)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		u.mu.Lock()
+		u.registryAuth = append(u.registryAuth, auth)
+		u.mu.Unlock()
+		switch {
+		case !strings.HasPrefix(auth, "Bearer "):
+			write(w, fixture.Challenge)
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/owner/app/blobs/"+u.digest:
+			write(w, fixture.Redirect)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(registry.Close)
+
+	// 通过localhost访问registry、127.0.0.1访问存储服务，二者主机名不同
+	u.registryHost = strings.Replace(registry.URL, "http://127.0.0.1", "localhost", 1)
+	return u
+}
+
+func (u *ghcrRedirectUpstream) fetch(p *Proxy) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/ghcr.io/owner/app/blobs/"+u.digest, nil)
+	imageRef := u.registryHost + "/owner/app"
+	p.handleUpstreamBlobRequest(c, imageRef, u.digest, "ghcr.io/owner/app", config.RegistryMapping{AuthType: "github"})
+	return w
+}
+
+func TestGHCRBlobRedirectDropsAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	blob := make([]byte, 4096)
+	rand.Read(blob)
+
+	for _, tt := range []struct {
+		name   string
+		config string
+	}{
+		{"registry token", ""},
+		// 通配规则为所有上游添加的凭据同样不发往存储服务
+		{"configured credential", "[upstream.headers.hosts.\"*\"]\nallowSensitive = true\nset = { Authorization = \"Bearer configured\" }\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, tt.config)
+			p := newTestProxy()
+			upstream := newGHCRRedirectUpstream(t, blob, false)
+
+			w := upstream.fetch(p)
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), blob) || w.Header().Get("Docker-Content-Digest") != upstream.digest {
+				t.Fatalf("status %d, %d bytes, headers %v", w.Code, w.Body.Len(), w.Header())
+			}
+			sawToken := false
+			for _, auth := range upstream.registryAuth {
+				sawToken = sawToken || strings.HasPrefix(auth, "Bearer ")
+			}
+			if !sawToken || upstream.storageRequest == 0 {
+				t.Fatalf("registry auth %q, storage requests %d", upstream.registryAuth, upstream.storageRequest)
+			}
+			for _, auth := range upstream.storageAuth {
+				if auth != "" {
+					t.Fatalf("storage received Authorization %q", auth)
+				}
+			}
+		})
+	}
+}
+
+func TestGHCRBlobRedirectVerifiesDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[hotCache]\npromoteAfter = 1\n")
+	p := newTestProxy()
+	t.Cleanup(func() { p.hot.Flush("") })
+	blob := []byte(strings.Repeat("layer-data ", 64))
+
+	// 存储服务返回的内容与digest不符时不写入内存缓存
+	upstream := newGHCRRedirectUpstream(t, blob, true)
+	upstream.fetch(p)
+	if _, _, ok := p.hot.NewReader(utils.BuildBlobHotKey(upstream.digest)); ok {
+		t.Fatal("corrupted blob cached")
+	}
+
+	upstream = newGHCRRedirectUpstream(t, blob, false)
+	if w := upstream.fetch(p); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), blob) {
+		t.Fatalf("status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if _, _, ok := p.hot.NewReader(utils.BuildBlobHotKey(upstream.digest)); !ok {
+		t.Fatal("verified blob not cached")
+	}
+}
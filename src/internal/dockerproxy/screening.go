@@ -0,0 +1,22 @@
+package dockerproxy
+
+import (
+	"net/http"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// screenBlobDigest 镜像层的digest命中屏蔽的文件哈希时返回403，返回true表示已拒绝
+func (p *Proxy) screenBlobDigest(c *gin.Context, digest string) bool {
+	if !p.shared.Screener.Enabled() {
+		return false
+	}
+	rule := p.shared.Screener.Check(digest)
+	if rule == "" {
+		return false
+	}
+	p.shared.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionBlock, Target: digest, Rule: rule, ClientIP: p.shared.IPs.Client(c)})
+	utils.RespondRegistryError(c, http.StatusForbidden, "DENIED", utils.ErrCodeContentScreened)
+	return true
+}
@@ -0,0 +1,33 @@
+package dockerproxy
+
+import (
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// imageSizeTarget 返回覆盖规则匹配用的镜像名：Docker Hub镜像为library/nginx形式，
+// 其他仓库带上仓库域名，如ghcr.io/owner/image
+func imageSizeTarget(image string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return image
+	}
+	if ref.Context().RegistryStr() == name.DefaultRegistry {
+		return ref.Context().RepositoryStr()
+	}
+	return ref.Context().Name()
+}
+
+// ImageSizeLimit 按cfg返回镜像相关类别的文件大小上限，多个镜像时取最严格的一个，0为不限制
+func ImageSizeLimit(cfg *config.AppConfig, c *gin.Context, class string, images ...string) int64 {
+	identity := c.GetString(utils.AuthUserKey)
+	var limit int64
+	for _, image := range images {
+		if current := utils.SizeLimit(cfg, class, imageSizeTarget(image), identity); current > 0 && (limit == 0 || current < limit) {
+			limit = current
+		}
+	}
+	return limit
+}
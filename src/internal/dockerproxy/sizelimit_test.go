@@ -0,0 +1,85 @@
+package dockerproxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestImageSizeTarget(t *testing.T) {
+	tests := map[string]string{
+		"nginx:latest":  "library/nginx",
+		"library/nginx": "library/nginx",
+		"ghcr.io/org/app@sha256:" + strings.Repeat("a", 64): "ghcr.io/org/app",
+		"127.0.0.1:5000/org/app:v1":                         "127.0.0.1:5000/org/app",
+	}
+	for image, want := range tests {
+		if got := imageSizeTarget(image); got != want {
+			t.Errorf("imageSizeTarget(%s) = %s, want %s", image, got, want)
+		}
+	}
+}
+
+// pushRandomImage 向测试Registry推送随机镜像，返回镜像名（不含tag）
+func pushRandomImage(t testing.TB) (string, *httptest.Server) {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/org/app"
+
+	image, err := random.Image(2048, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	return imageRef, server
+}
+
+func TestDockerBlobSizeLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	imageRef, _ := pushRandomImage(t)
+
+	ref, _ := name.ParseReference(imageRef + ":v1")
+	image, err := remote.Image(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, _ := image.Layers()
+	digest, _ := layers[0].Digest()
+	size, _ := layers[0].Size()
+
+	fetch := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/org/app/blobs/"+digest.String(), nil)
+		p.handleUpstreamBlobRequest(c, imageRef, digest.String(), imageRef, config.RegistryMapping{})
+		return w
+	}
+
+	if w := fetch(); w.Code != http.StatusOK || int64(w.Body.Len()) != size {
+		t.Fatalf("unlimited blob: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	loadTestConfig(t, fmt.Sprintf("[limits.overrides]\n\"dockerBlob:%s\" = %d\n", imageRef, size-1))
+	p.shared.Config.Set(config.GetConfig())
+	w := fetch()
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "SIZE_INVALID") {
+		t.Fatalf("limited blob: status %d, body %q", w.Code, w.Body.String())
+	}
+}
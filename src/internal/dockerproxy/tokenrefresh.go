@@ -0,0 +1,158 @@
+package dockerproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+)
+
+// tokenWarmupInterval 检查热门仓库token是否需要刷新的间隔
+const tokenWarmupInterval = time.Minute
+
+// maxRefreshAhead 提前刷新比例上限，避免每次命中都触发刷新
+const maxRefreshAhead = 0.9
+
+// tokenRefreshFraction 剩余有效期低于TTL的cfg中比例时提前刷新，0表示关闭
+func tokenRefreshFraction(cfg *config.AppConfig) float64 {
+	fraction := cfg.TokenCache.RefreshAhead
+	if fraction <= 0 {
+		return 0
+	}
+	return min(fraction, maxRefreshAhead)
+}
+
+// tokenNeedsRefresh token剩余有效期是否已低于TTL的fraction
+func tokenNeedsRefresh(item *utils.CachedItem, fraction float64, now time.Time) bool {
+	if fraction <= 0 {
+		return false
+	}
+	ttl := item.ExpiresAt.Sub(item.StoredAt)
+	return item.ExpiresAt.Sub(now) < time.Duration(float64(ttl)*fraction)
+}
+
+// fetchAnonymousToken 不带客户端凭据从上游获取token，只接受包含token的成功响应
+func (p *Proxy) fetchAnonymousToken(authURL string) ([]byte, error) {
+	ctx, cancel := p.clients.MetadataContext(p.shared.Background.Context())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.clients.Metadata().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("上游返回状态码 %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAuthResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || (token.Token == "" && token.AccessToken == "") {
+		return nil, fmt.Errorf("上游响应中没有token")
+	}
+	return body, nil
+}
+
+// authURLHost 返回认证地址的主机，作为合并重复日志的关键字段
+func authURLHost(authURL string) string {
+	if u, err := url.Parse(authURL); err == nil {
+		return u.Host
+	}
+	return authURL
+}
+
+// refreshTokenInBackground 在后台获取token并写入缓存，同一key同时只有一个刷新。
+// 刷新失败时保留原有缓存，仍在有效期内的token继续使用
+func (p *Proxy) refreshTokenInBackground(cacheKey, authURL, mode string) {
+	p.cache.RefreshInBackground(cacheKey, func() {
+		body, err := p.fetchAnonymousToken(authURL)
+		if err != nil {
+			p.shared.Stats.RecordTokenRefreshFailed()
+			utils.Logf(utils.LogError, authURLHost(authURL), "后台刷新token失败: %v", err)
+			return
+		}
+		p.shared.Stats.RecordTokenFetch(mode)
+		p.cache.SetToken(cacheKey, string(body), utils.ExtractTTLFromResponse(body))
+		if item := p.cache.Get(cacheKey); item != nil {
+			p.refreshedTokens.Store(cacheKey, item)
+		}
+	})
+}
+
+// hotTokenQuery 返回热门仓库的token请求参数，与docker客户端经代理请求时的参数一致。
+// 认证代理只对接Docker Hub，其他registry的仓库返回false
+func (p *Proxy) hotTokenQuery(repository string) (string, bool) {
+	info := p.access.ParseDockerImage(repository)
+	if info.Registry != accesscontrol.DockerHubRegistry {
+		return "", false
+	}
+	return url.Values{
+		"scope":   {"repository:" + info.FullName + ":pull"},
+		"service": {"registry.docker.io"},
+	}.Encode(), true
+}
+
+// warmHotTokens 预取热门仓库的token，已缓存的token按提前刷新比例续期
+func (p *Proxy) warmHotTokens() {
+	cfg := p.shared.Config.Get()
+	if !cfg.TokenCache.Enabled {
+		return
+	}
+
+	fraction := tokenRefreshFraction(cfg)
+	now := time.Now()
+	for _, repository := range cfg.TokenCache.HotRepositories {
+		query, ok := p.hotTokenQuery(repository)
+		if !ok {
+			continue
+		}
+		cacheKey := utils.BuildTokenCacheKey(query)
+		authURL := dockerHubAuthBase + "/token?" + query
+		switch item := p.cache.Get(cacheKey); {
+		case item == nil:
+			p.refreshTokenInBackground(cacheKey, authURL, utils.TokenFetchPrefetch)
+		case tokenNeedsRefresh(item, fraction, now):
+			p.refreshTokenInBackground(cacheKey, authURL, utils.TokenFetchRefreshAhead)
+		}
+	}
+}
+
+// StartTokenWarmup 启动时预取tokenCache.hotRepositories的token，并定期刷新保持有效，服务停止时退出
+func (p *Proxy) StartTokenWarmup() {
+	for _, repository := range p.shared.Config.Get().TokenCache.HotRepositories {
+		if _, ok := p.hotTokenQuery(repository); !ok {
+			fmt.Printf("警告: 热门仓库 %s 不是Docker Hub镜像，不预取token\n", repository)
+		}
+	}
+
+	ctx := p.shared.Background.Context()
+	go func() {
+		p.warmHotTokens()
+		ticker := time.NewTicker(tokenWarmupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.warmHotTokens()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,146 @@
+package dockerproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenRefreshAhead(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[tokenCache]
+enabled = true
+refreshAhead = 0.9
+hotRepositories = ["nginx", "ghcr.io/org/app"]
+`)
+	p := newTestProxy()
+
+	var issued, failing atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"token":"fresh-%d","expires_in":3600}`, issued.Add(1))
+	}))
+	defer server.Close()
+	original := dockerHubAuthBase
+	dockerHubAuthBase = server.URL
+	t.Cleanup(func() {
+		dockerHubAuthBase = original
+		p.cache.Flush(utils.TokenCachePrefix)
+	})
+
+	router := gin.New()
+	router.GET("/token", p.AuthHandler)
+	get := func(query string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token?"+query, nil))
+		return w.Body.String()
+	}
+
+	// 热门仓库启动时预取，非Docker Hub仓库跳过
+	before := p.shared.Stats.TokenSnapshot()
+	p.warmHotTokens()
+	nginxKey := utils.BuildTokenCacheKey("service=registry.docker.io&scope=repository:library/nginx:pull")
+	waitFor(t, func() bool { return p.cache.Get(nginxKey) != nil })
+	if got := p.shared.Stats.TokenSnapshot(); got.Prefetches != before.Prefetches+1 {
+		t.Fatalf("prefetches = %d, want %d", got.Prefetches, before.Prefetches+1)
+	}
+
+	// 临近过期的token直接返回，同时在后台刷新
+	const query = "scope=repository%3Alibrary%2Fredis%3Apull&service=registry.docker.io"
+	redisKey := utils.BuildTokenCacheKey(query)
+	p.cache.SetToken(redisKey, `{"token":"old"}`, time.Second)
+	time.Sleep(150 * time.Millisecond)
+
+	before = p.shared.Stats.TokenSnapshot()
+	if body := get(query); body != `{"token":"old"}` {
+		t.Fatalf("body = %s, want cached token", body)
+	}
+	waitFor(t, func() bool {
+		_, refreshed := p.refreshedTokens.Load(redisKey)
+		return refreshed
+	})
+
+	if body := get(query); body == `{"token":"old"}` {
+		t.Fatal("refreshed token not served")
+	}
+	got := p.shared.Stats.TokenSnapshot()
+	if got.RefreshAhead != before.RefreshAhead+1 || got.RefreshedSaves != before.RefreshedSaves+1 || got.SyncFetches != before.SyncFetches {
+		t.Fatalf("token stats = %+v, before %+v", got, before)
+	}
+
+	// 刷新失败时保留仍然有效的token
+	failing.Store(1)
+	p.cache.SetToken(redisKey, `{"token":"still-valid"}`, time.Second)
+	time.Sleep(150 * time.Millisecond)
+	get(query)
+	waitFor(t, func() bool { return p.shared.Stats.TokenSnapshot().RefreshFailed == before.RefreshFailed+1 })
+	if body := get(query); body != `{"token":"still-valid"}` {
+		t.Fatalf("body = %s, want still-valid token", body)
+	}
+}
+
+func TestTokenCacheSkipsCredentialedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[tokenCache]
+enabled = true
+`)
+	p := newTestProxy()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.Write([]byte(`{"token":"private","expires_in":3600}`))
+			return
+		}
+		w.Write([]byte(`{"token":"anonymous","expires_in":3600}`))
+	}))
+	defer server.Close()
+	original := dockerHubAuthBase
+	dockerHubAuthBase = server.URL
+	t.Cleanup(func() {
+		dockerHubAuthBase = original
+		p.cache.Flush(utils.TokenCachePrefix)
+	})
+
+	router := gin.New()
+	router.GET("/token", p.AuthHandler)
+	get := func(authorization string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/token?service=registry.docker.io&scope=repository:org/private:pull", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if body := get("Basic dXNlcjpwYXNz"); !strings.Contains(body, "private") {
+		t.Fatalf("credentialed body = %s", body)
+	}
+	if body := get(""); !strings.Contains(body, "anonymous") {
+		t.Fatalf("anonymous request served %s", body)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
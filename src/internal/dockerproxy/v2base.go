@@ -0,0 +1,142 @@
+package dockerproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DistributionAPIVersion /v2/ 响应中标明的Registry API版本，docker、podman和containerd据此确认服务端为v2 Registry
+const DistributionAPIVersion = "registry/2.0"
+
+// anonymousTokenTTL 本地签发的匿名令牌有效期
+const anonymousTokenTTL = 5 * time.Minute
+
+// anonymousTokenPrefix 本地签发的匿名令牌的前缀，格式为 hpanon.<过期时间>.<随机串>.<签名>
+const anonymousTokenPrefix = "hpanon."
+
+// anonymousTokenFlagKey 请求上下文中记录携带了有效匿名令牌的key
+const anonymousTokenFlagKey = "anonymous_token"
+
+// anonymousTokenKey 签名匿名令牌的密钥，进程启动时随机生成；重启后旧令牌按普通凭据处理，客户端会重新获取
+var anonymousTokenKey = []byte(rand.Text())
+
+// anonymousTokenSignature 返回令牌正文的HMAC签名
+func anonymousTokenSignature(payload string) string {
+	mac := hmac.New(sha256.New, anonymousTokenKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueAnonymousToken 签发带过期时间和签名的匿名令牌
+func issueAnonymousToken(expiresAt time.Time) string {
+	payload := anonymousTokenPrefix + strconv.FormatInt(expiresAt.Unix(), 10) + "." + rand.Text()
+	return payload + "." + anonymousTokenSignature(payload)
+}
+
+// parseAnonymousToken 判断令牌是否由本代理签发，返回签名是否有效以及是否仍在有效期内
+func parseAnonymousToken(token string, now time.Time) (issued, valid bool) {
+	if !strings.HasPrefix(token, anonymousTokenPrefix) {
+		return false, false
+	}
+	idx := strings.LastIndexByte(token, '.')
+	payload, signature := token[:idx], token[idx+1:]
+	if !hmac.Equal([]byte(signature), []byte(anonymousTokenSignature(payload))) {
+		return false, false
+	}
+	expires, err := strconv.ParseInt(strings.Split(strings.TrimPrefix(payload, anonymousTokenPrefix), ".")[0], 10, 64)
+	return true, err == nil && now.Unix() < expires
+}
+
+// stripAnonymousToken 去掉本代理签发的匿名令牌：它只用于完成/v2/握手，不是上游凭据，
+// 去掉后请求与未带凭据的请求一样使用不存在缓存等匿名路径
+func stripAnonymousToken(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return
+	}
+	issued, valid := parseAnonymousToken(strings.TrimSpace(token), time.Now())
+	if !issued {
+		return
+	}
+	c.Request.Header.Del("Authorization")
+	c.Set(anonymousTokenFlagKey, valid)
+}
+
+// v2Challenge 返回/v2/请求适用的应答方式，带ns参数时优先使用对应Registry的设置
+func (p *Proxy) v2Challenge(c *gin.Context, cfg *config.AppConfig) string {
+	challenge := cfg.V2.Challenge
+	if ns := c.Query("ns"); ns != "" {
+		if mapping, ok := p.RegistryMapping(ns); ok && strings.TrimSpace(mapping.Challenge) != "" {
+			challenge = mapping.Challenge
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(challenge))
+}
+
+// v2Service 返回质询中的service，与上游无关，保证同一配置下响应固定
+func v2Service(cfg *config.AppConfig) string {
+	if service := strings.TrimSpace(cfg.V2.Service); service != "" {
+		return service
+	}
+	return "hubproxy"
+}
+
+// handleV2Base 应答 /v2/ 基础端点。anonymous时返回200；token时未携带凭据的请求返回401，
+// 质询的realm指向本代理的/token，从不透出上游的认证地址
+func (p *Proxy) handleV2Base(c *gin.Context) {
+	cfg := p.shared.Config.Get()
+	c.Header("Docker-Distribution-API-Version", DistributionAPIVersion)
+
+	// 匿名令牌过期时返回401，客户端按质询重新获取
+	authenticated := c.GetString(utils.AuthUserKey) != "" || c.GetHeader("Authorization") != "" || c.GetBool(anonymousTokenFlagKey)
+	if p.v2Challenge(c, cfg) == config.V2ChallengeToken && !authenticated {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s"`,
+			utils.ExternalBaseURL(cfg, c.Request), v2Service(cfg)))
+		utils.RespondRegistryError(c, http.StatusUnauthorized, "UNAUTHORIZED", utils.ErrCodeUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// handleAnonymousToken 为/v2/质询中的service签发匿名令牌。
+// 代理始终以自身身份访问上游，客户端携带的令牌只用于完成握手，因此无需请求上游认证服务。
+// 令牌带签名，之后的请求据此识别并去掉，不会被当作上游凭据
+func (p *Proxy) handleAnonymousToken(c *gin.Context) bool {
+	cfg := p.shared.Config.Get()
+	if c.Query("service") != v2Service(cfg) || !v2TokenChallengeConfigured(cfg) {
+		return false
+	}
+
+	token := issueAnonymousToken(time.Now().Add(anonymousTokenTTL))
+	c.JSON(http.StatusOK, gin.H{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   int(anonymousTokenTTL.Seconds()),
+		"issued_at":    time.Now().UTC().Format(time.RFC3339),
+	})
+	return true
+}
+
+// v2TokenChallengeConfigured 判断默认设置或任一Registry是否使用token质询
+func v2TokenChallengeConfigured(cfg *config.AppConfig) bool {
+	if strings.EqualFold(strings.TrimSpace(cfg.V2.Challenge), config.V2ChallengeToken) {
+		return true
+	}
+	for _, mapping := range cfg.Registries {
+		if mapping.Enabled && strings.EqualFold(strings.TrimSpace(mapping.Challenge), config.V2ChallengeToken) {
+			return true
+		}
+	}
+	return false
+}
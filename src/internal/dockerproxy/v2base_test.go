@@ -0,0 +1,118 @@
+package dockerproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// v2BaseRequest 经由代理路由请求path，remoteAddr为受信任代理地址时才采用X-Forwarded-*
+func v2BaseRequest(router *gin.Engine, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Host = "mirror.internal:5000"
+	req.RemoteAddr = "127.0.0.1:40000"
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func newV2BaseRouter(t *testing.T, body string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, body)
+	p := newTestProxy()
+
+	router := gin.New()
+	router.Any("/token", p.AuthHandler)
+	router.Any("/v2/*path", p.RegistryHandler)
+	return router
+}
+
+func TestV2BaseAnonymous(t *testing.T) {
+	router := newV2BaseRouter(t, ``)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := v2BaseRequest(router, method, "/v2/", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s /v2/ status = %d", method, w.Code)
+		}
+		if got := w.Header().Values("Docker-Distribution-API-Version"); len(got) != 1 || got[0] != "registry/2.0" {
+			t.Errorf("%s Docker-Distribution-API-Version = %q", method, got)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != "" {
+			t.Errorf("%s WWW-Authenticate = %q", method, got)
+		}
+	}
+	if body := v2BaseRequest(router, http.MethodGet, "/v2/", nil).Body.String(); body != "{}" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestV2BaseTokenChallenge(t *testing.T) {
+	router := newV2BaseRouter(t, `
+[server]
+basePath = "/mirror"
+
+[v2]
+challenge = "token"
+service = "hub.example"
+
+[registries."quay.io"]
+upstream = "quay.io"
+authHost = "quay.io/v2/auth"
+authType = "quay"
+challenge = "anonymous"
+enabled = true
+`)
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		status int
+		auth   string
+	}{
+		{"podman", "/v2/", nil, http.StatusUnauthorized,
+			`Bearer realm="http://mirror.internal:5000/mirror/token",service="hub.example"`},
+		{"forwarded", "/v2/", http.Header{"X-Forwarded-Host": {"hub.example.com"}, "X-Forwarded-Proto": {"https"}}, http.StatusUnauthorized,
+			`Bearer realm="https://hub.example.com/mirror/token",service="hub.example"`},
+		{"containerd ns", "/v2/?ns=ghcr.io", nil, http.StatusUnauthorized,
+			`Bearer realm="http://mirror.internal:5000/mirror/token",service="hub.example"`},
+		{"registry override", "/v2/?ns=quay.io", nil, http.StatusOK, ""},
+		{"bearer", "/v2/", http.Header{"Authorization": {"Bearer abc"}}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := v2BaseRequest(router, http.MethodGet, tt.path, tt.header)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d", tt.name, w.Code)
+		}
+		if got := w.Header().Values("WWW-Authenticate"); tt.auth != "" && (len(got) != 1 || got[0] != tt.auth) || tt.auth == "" && len(got) != 0 {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, got)
+		}
+		if got := w.Header().Get("Docker-Distribution-API-Version"); got != "registry/2.0" {
+			t.Errorf("%s: Docker-Distribution-API-Version = %q", tt.name, got)
+		}
+		if tt.status == http.StatusUnauthorized && !strings.Contains(w.Body.String(), `"code":"UNAUTHORIZED"`) {
+			t.Errorf("%s: body = %s", tt.name, w.Body.String())
+		}
+	}
+
+	w := v2BaseRequest(router, http.MethodGet, "/token?service=hub.example&scope=repository:library/nginx:pull", nil)
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("token status %d: %s", w.Code, w.Body.String())
+	}
+	if token.Token == "" || token.AccessToken != token.Token || token.ExpiresIn <= 0 {
+		t.Errorf("token = %+v", token)
+	}
+}
@@ -0,0 +1,112 @@
+package githubproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// archiveConvertKey 请求上下文中标记需要将源码包转换为zip的key
+	archiveConvertKey = "archive_convert"
+	// archiveConvertHeader 响应头，值为zip表示已转换，skipped或disabled表示原样返回tar.gz
+	archiveConvertHeader = "X-Archive-Convert"
+	// archiveConvertWarningHeader 转换结束后以trailer发送，说明链接被写为普通文件等无法完整表示的情况
+	archiveConvertWarningHeader = "X-Archive-Convert-Warning"
+)
+
+// isGitHubTarball 判断链接是否为仓库源码的tar.gz包，如 github.com/owner/repo/archive/refs/heads/main.tar.gz
+func isGitHubTarball(target string) bool {
+	targetPath := target
+	if parsed, err := url.Parse(target); err == nil {
+		targetPath = parsed.Path
+	}
+	return strings.Contains(targetPath, "/archive/") && strings.HasSuffix(strings.ToLower(targetPath), ".tar.gz")
+}
+
+// parseArchiveConvert 移除查询串中的convert参数并标记本次请求需要转换格式，
+// 参数值不支持或链接不是源码tar.gz包时返回错误响应并返回false
+func parseArchiveConvert(c *gin.Context, target string, cfg *config.AppConfig) (string, bool) {
+	target, value, found := stripQueryParam(target, "convert")
+	if !found {
+		return target, true
+	}
+	if value != "zip" || !isGitHubTarball(target) {
+		utils.RespondProxyError(c, http.StatusBadRequest, utils.ErrCodeInvalidInput)
+		return target, false
+	}
+	if !cfg.GitHub.ArchiveConvert {
+		c.Header(archiveConvertHeader, "disabled")
+		return target, true
+	}
+	c.Set(archiveConvertKey, true)
+	return target, true
+}
+
+// archiveConvertRequested 判断本次请求是否要求转换源码包格式
+func archiveConvertRequested(c *gin.Context) bool {
+	return c.GetBool(archiveConvertKey)
+}
+
+// shouldConvertArchive 判断上游响应是否转换为zip，重定向和错误响应原样转发，
+// 上游声明的大小超过archiveConvertMaxSize时不转换并设置skipped响应头
+func shouldConvertArchive(c *gin.Context, resp *http.Response, cfg *config.AppConfig) bool {
+	if !archiveConvertRequested(c) || resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != "" {
+		return false
+	}
+	if maxSize := cfg.GitHub.ArchiveConvertMaxSize; maxSize > 0 && resp.ContentLength > maxSize {
+		c.Header(archiveConvertHeader, "skipped")
+		return false
+	}
+	return true
+}
+
+// prepareZipHeaders 将tar.gz的响应头改为zip，转换后长度未知，去掉长度、校验和断点续传相关的头
+func prepareZipHeaders(header http.Header, upstreamPath string) {
+	for _, key := range []string{"Content-Length", "Content-Encoding", "Content-Range", "Content-MD5", "Accept-Ranges", "ETag"} {
+		header.Del(key)
+	}
+	header.Set("Content-Type", "application/zip")
+	if disposition := header.Get("Content-Disposition"); strings.Contains(strings.ToLower(disposition), ".tar.gz") {
+		index := strings.LastIndex(strings.ToLower(disposition), ".tar.gz")
+		header.Set("Content-Disposition", disposition[:index]+".zip"+disposition[index+len(".tar.gz"):])
+	} else {
+		name := strings.TrimSuffix(path.Base(upstreamPath), ".tar.gz")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", name))
+	}
+	header.Set(archiveConvertHeader, "zip")
+}
+
+// streamArchiveAsZip 边读取上游tar.gz边向客户端写出zip，sizeLimit限制解压后的大小
+func streamArchiveAsZip(c *gin.Context, resp *http.Response, sizeLimit int64) {
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+
+	c.Writer.Header().Set("Trailer", archiveConvertWarningHeader)
+	result, err := utils.ConvertTarGzToZip(c.Writer, resp.Body, sizeLimit)
+	if err != nil {
+		fmt.Printf("源码包 %s 转换为zip失败: %v\n", resp.Request.URL.Path, err)
+		return
+	}
+
+	var warnings []string
+	if len(result.Links) > 0 {
+		warnings = append(warnings, fmt.Sprintf("links-as-files=%d", len(result.Links)))
+		fmt.Printf("源码包 %s 中的 %d 个链接已转换为普通文件: %s\n", resp.Request.URL.Path, len(result.Links), strings.Join(result.Links, ", "))
+	}
+	if len(result.Skipped) > 0 {
+		warnings = append(warnings, fmt.Sprintf("skipped=%d", len(result.Skipped)))
+		fmt.Printf("源码包 %s 中的 %d 个条目无法写入zip: %s\n", resp.Request.URL.Path, len(result.Skipped), strings.Join(result.Skipped, ", "))
+	}
+	if len(warnings) > 0 {
+		c.Writer.Header().Set(archiveConvertWarningHeader, strings.Join(warnings, "; "))
+	}
+}
@@ -0,0 +1,147 @@
+package githubproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// buildTestTarball 生成包含一个文件和一个符号链接的源码包
+func buildTestTarball(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "repo-main/", Mode: 0755},
+		{Typeflag: tar.TypeReg, Name: "repo-main/main.go", Mode: 0644, Size: 12},
+		{Typeflag: tar.TypeSymlink, Name: "repo-main/link.go", Linkname: "main.go", Mode: 0777},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("package main"))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestProxyGitHubArchiveConvert(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	tarball := buildTestTarball(t)
+	var ranges []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/owner/repo/archive/refs/heads/main.tar.gz":
+			http.Redirect(w, r, "/codeload/owner/repo/tar.gz/refs/heads/main", http.StatusFound)
+		case "/codeload/owner/repo/tar.gz/refs/heads/main":
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Header().Set("Content-Disposition", "attachment; filename=repo-main.tar.gz")
+			w.Header().Set("Content-Length", strconv.Itoa(len(tarball)))
+			w.Header().Set("ETag", `"abc"`)
+			w.Write(tarball)
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := func(query string) (*http.Response, []byte) {
+		router := gin.New()
+		router.Any("/*path", func(c *gin.Context) {
+			target, ok := parseArchiveConvert(c, upstream.URL+c.Param("path")+query, p.shared.Config.Get())
+			if ok {
+				p.proxyGitHubWithRedirect(c, target, 0)
+			}
+		})
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/owner/repo/archive/refs/heads/main.tar.gz", nil)
+		req.Header.Set("Range", "bytes=100-")
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := proxy("?convert=zip")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" ||
+		resp.Header.Get("Content-Disposition") != "attachment; filename=repo-main.zip" ||
+		resp.Header.Get("X-Archive-Convert") != "zip" || resp.Header.Get("ETag") != "" || resp.ContentLength != -1 {
+		t.Fatalf("status %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	if got := resp.Trailer.Get("X-Archive-Convert-Warning"); got != "links-as-files=1" {
+		t.Fatalf("warning trailer = %q", got)
+	}
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("upstream Range headers = %q", ranges)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, file := range zr.File {
+		rc, _ := file.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[file.Name] = string(data)
+	}
+	if len(contents) != 3 || contents["repo-main/main.go"] != "package main" || contents["repo-main/link.go"] != "main.go" {
+		t.Fatalf("zip contents = %v", contents)
+	}
+
+	// 不支持的格式返回400，不请求上游
+	ranges = nil
+	if resp, _ := proxy("?convert=rar"); resp.StatusCode != http.StatusBadRequest || len(ranges) != 0 {
+		t.Fatalf("unsupported format: status %d", resp.StatusCode)
+	}
+
+	// 超过转换上限时原样返回tar.gz
+	loadTestConfig(t, "[github]\narchiveConvertMaxSize = 16\n")
+	p.shared.Config.Set(config.GetConfig())
+	resp, body = proxy("?convert=zip")
+	if resp.Header.Get("X-Archive-Convert") != "skipped" || !bytes.Equal(body, tarball) {
+		t.Fatalf("oversized archive: headers %v, %d bytes", resp.Header, len(body))
+	}
+
+	loadTestConfig(t, "[github]\narchiveConvert = false\n")
+	p.shared.Config.Set(config.GetConfig())
+	if resp, _ = proxy("?convert=zip"); resp.Header.Get("X-Archive-Convert") != "disabled" || resp.Header.Get("Content-Type") != "application/x-gzip" {
+		t.Fatalf("disabled conversion: headers %v", resp.Header)
+	}
+}
+
+func TestIsGitHubTarball(t *testing.T) {
+	for target, want := range map[string]bool{
+		"https://github.com/owner/repo/archive/refs/heads/main.tar.gz":  true,
+		"https://github.com/owner/repo/archive/v1.0.0.TAR.GZ":           true,
+		"https://github.com/owner/repo/archive/refs/heads/main.zip":     false,
+		"https://github.com/owner/repo/releases/download/v1/app.tar.gz": false,
+	} {
+		if got := isGitHubTarball(target); got != want {
+			t.Errorf("isGitHubTarball(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
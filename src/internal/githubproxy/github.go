@@ -0,0 +1,564 @@
+// Package githubproxy 代理GitHub、Hugging Face等允许的上游链接，包括文件下载、API请求和git smart HTTP
+package githubproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Proxy GitHub代理，缓存、访问控制、上游HTTP客户端和共享状态由调用方创建后注入
+type Proxy struct {
+	cache   *utils.UniversalCache
+	hot     *utils.HotCache
+	access  *accesscontrol.Controller
+	clients *utils.HTTPClients
+	shared  *utils.Components
+}
+
+// New 创建GitHub代理，cache保存API响应，hot保存固定到commit SHA的小文件，
+// shared提供统计、内存保护、重任务调度和内容筛查
+func New(cache *utils.UniversalCache, hot *utils.HotCache, access *accesscontrol.Controller, clients *utils.HTTPClients, shared *utils.Components) *Proxy {
+	return &Proxy{cache: cache, hot: hot, access: access, clients: clients, shared: shared}
+}
+
+// 全局变量：被阻止的内容类型
+var blockedContentTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"text/xml":              true,
+	"application/xml":       true,
+}
+
+// rawFileExps 指向具体文件的GitHub链接，上游对这类链接返回HTML时通常是路径或ref写错了。
+// 目标主机已经过CheckGitHubURL校验，仓库内的文件链接只按路径判断
+var rawFileExps = []*regexp.Regexp{
+	regexp.MustCompile(`^(?:https?://)?[^/]+/[^/]+/[^/]+/(?:releases/download|archive|blob|raw)/`),
+	regexp.MustCompile(`^(?:https?://)?(?:raw|gist|objects|release-assets)\.githubusercontent\.com/`),
+	regexp.MustCompile(`^(?:https?://)?codeload\.github\.com/`),
+}
+
+// ImpliesRawFile 判断链接是否指向具体文件而非网页
+func ImpliesRawFile(u string) bool {
+	for _, exp := range rawFileExps {
+		if exp.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTMLContentType 判断是否为HTML网页
+func isHTMLContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// respondHTMLErrorPage 文件链接返回HTML页面时不转发页面内容，保留上游的错误状态码返回结构化错误，
+// 避免 curl | bash 执行HTML
+func respondHTMLErrorPage(c *gin.Context, upstreamStatus int) {
+	status := upstreamStatus
+	if status < http.StatusBadRequest {
+		status = http.StatusBadGateway
+	}
+	c.Header("X-Upstream-Status", strconv.Itoa(upstreamStatus))
+	utils.RespondUpstreamError(c, status, upstreamStatus, utils.ErrCodeGitHubHTMLPage, upstreamStatus)
+}
+
+// respondBodyTooLarge 未声明长度的请求体在转发过程中超出上限时返回413
+func respondBodyTooLarge(c *gin.Context) {
+	utils.RespondProxyError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeBodyTooLarge, c.GetInt64(utils.BodyLimitKey))
+}
+
+// unsatisfiedRangeExp 416响应中表示文件总长度的Content-Range
+var unsatisfiedRangeExp = regexp.MustCompile(`^bytes \*/\d+$`)
+
+// respondRangeNotSatisfiable 转发上游的416响应：保留Content-Range: bytes */N供curl -C -等客户端
+// 判断文件已下载完整，丢弃上游的HTML/XML错误页，返回空响应体
+func respondRangeNotSatisfiable(c *gin.Context, resp *http.Response) {
+	if contentRange := resp.Header.Get("Content-Range"); unsatisfiedRangeExp.MatchString(contentRange) {
+		c.Header("Content-Range", contentRange)
+	}
+	for _, key := range []string{"Accept-Ranges", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(key); value != "" {
+			c.Header(key, value)
+		}
+	}
+	c.Header("Content-Length", "0")
+	c.Status(http.StatusRequestedRangeNotSatisfiable)
+	c.Writer.WriteHeaderNow()
+}
+
+// peekEmptyBody 判断上游响应体是否为空。长度未知时预读一个字节，非空时返回拼接回该字节的Reader
+func peekEmptyBody(c *gin.Context, resp *http.Response, body io.Reader) (io.Reader, bool) {
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return body, true
+	case c.Request.Method == http.MethodHead || resp.ContentLength > 0:
+		return body, false
+	case resp.ContentLength == 0:
+		return body, true
+	}
+	var first [1]byte
+	n, err := io.ReadFull(body, first[:])
+	if n == 0 && err == io.EOF {
+		return body, true
+	}
+	return io.MultiReader(bytes.NewReader(first[:n]), body), false
+}
+
+// writeEmptyResponse 结束空响应体的请求，显式声明长度为0避免分块传输让客户端继续等待；
+// 204不允许携带Content-Length
+func writeEmptyResponse(c *gin.Context) {
+	header := c.Writer.Header()
+	header.Del("Transfer-Encoding")
+	header.Del("Content-Encoding")
+	if c.Writer.Status() == http.StatusNoContent {
+		header.Del("Content-Length")
+	} else {
+		header.Set("Content-Length", "0")
+	}
+	c.Writer.WriteHeaderNow()
+}
+
+// NormalizeRequestURI 将请求URI还原为上游地址，自动补全协议头。
+// 路径保持原始编码，查询串原样保留；matchPath不含查询串，用于规则匹配
+func NormalizeRequestURI(requestURI string) (string, string, error) {
+	parsed, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := strings.TrimLeft(parsed.EscapedPath(), "/")
+	switch {
+	case strings.HasPrefix(path, "https://"):
+	case strings.HasPrefix(path, "http://"):
+		path = "https://" + strings.TrimPrefix(path, "http://")
+	case strings.HasPrefix(path, "https:/"):
+		path = "https://" + strings.TrimPrefix(path, "https:/")
+	case strings.HasPrefix(path, "http:/"):
+		path = "https://" + strings.TrimPrefix(path, "http:/")
+	default:
+		path = "https://" + path
+	}
+
+	target := path
+	if parsed.RawQuery != "" {
+		target += "?" + parsed.RawQuery
+	}
+	return target, path, nil
+}
+
+// Handler GitHub代理处理器
+func (p *Proxy) Handler(c *gin.Context) {
+	cfg := p.shared.Config.Get()
+	rawPath, matchPath, err := NormalizeRequestURI(c.Request.URL.RequestURI())
+	if err != nil {
+		utils.RespondProxyError(c, http.StatusForbidden, utils.ErrCodeInvalidInput)
+		return
+	}
+
+	match := MatchURL(matchPath)
+	if match == nil {
+		utils.RespondProxyError(c, http.StatusForbidden, utils.ErrCodeInvalidInput)
+		return
+	}
+	// 方法检查在访问控制和上游请求之前，避免经公共代理向上游发送写请求
+	if !checkMethod(c, match, cfg) {
+		return
+	}
+	if allowed, reason := match.CheckAccess(p.access); !allowed {
+		var repoPath string
+		if matches := match.captures; len(matches) >= 2 {
+			username := matches[0]
+			repoName := strings.TrimSuffix(matches[1], ".git")
+			repoPath = username + "/" + repoName
+		}
+		fmt.Printf("GitHub仓库 %s 访问被拒绝: %s\n", repoPath, reason)
+		utils.RespondProxyError(c, http.StatusForbidden, reason)
+		return
+	}
+
+	// 按链接形式改写上游地址，如blob链接转换为raw链接
+	rawPath = match.Rewrite(rawPath)
+
+	// git客户端的请求不解析accel、debug等代理参数，查询串原样转发
+	if match.route.git {
+		finishScreening, ok := p.screenGitHubRequest(c, match, rawPath)
+		if !ok {
+			return
+		}
+		p.proxyGitSmartHTTP(c, rawPath)
+		finishScreening()
+		return
+	}
+
+	rawPath, connections := StripAccelParam(rawPath)
+	if connections > 0 {
+		c.Set("accel_connections", connections)
+	}
+	rawPath = stripDebugParam(c, rawPath, cfg)
+	rawPath, ok := parseArchiveConvert(c, rawPath, cfg)
+	if !ok {
+		return
+	}
+	finishScreening, ok := p.screenGitHubRequest(c, match, rawPath)
+	if !ok {
+		return
+	}
+	markImmutableGitHubAsset(c, rawPath)
+
+	p.ProxyRequest(c, rawPath)
+	finishScreening()
+}
+
+// stripDebugParam 移除查询串中的debug参数，无论是否有权限都不转发给上游、不进入缓存键；仅管理员的debug=1开启重定向调试
+func stripDebugParam(c *gin.Context, target string, cfg *config.AppConfig) string {
+	target, value, found := stripQueryParam(target, "debug")
+	if found && value == "1" && redirectDebugAllowed(c, cfg) {
+		c.Set(redirectDebugKey, true)
+	}
+	return target
+}
+
+// StripAccelParam 移除查询串中的accel参数并返回其值，其余参数保持原顺序和编码
+func StripAccelParam(target string) (string, int) {
+	target, value, found := stripQueryParam(target, "accel")
+	if !found {
+		return target, 0
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return target, n
+	}
+	return target, 0
+}
+
+// stripQueryParam 移除查询串中的key参数并返回最后一次出现的值，其余参数保持原顺序和编码
+func stripQueryParam(target, key string) (string, string, bool) {
+	base, rawQuery, found := strings.Cut(target, "?")
+	if !found {
+		return target, "", false
+	}
+
+	var value string
+	removed := false
+	kept := make([]string, 0)
+	for _, part := range strings.Split(rawQuery, "&") {
+		name, v, _ := strings.Cut(part, "=")
+		if name != key {
+			kept = append(kept, part)
+			continue
+		}
+		value, removed = v, true
+	}
+
+	if len(kept) == 0 {
+		return base, value, removed
+	}
+	return base + "?" + strings.Join(kept, "&"), value, removed
+}
+
+// accelConnections 返回本次下载的并发连接数，?accel=N 优先于配置，不超过配置的上限
+func accelConnections(c *gin.Context, cfg *config.AppConfig) int {
+	if cfg.Proxy.AccelChunkSize <= 0 {
+		return 0
+	}
+	connections := cfg.Proxy.AccelConnections
+	if value, exists := c.Get("accel_connections"); exists {
+		connections = value.(int)
+	}
+	return min(connections, cfg.Proxy.AccelMaxConnections)
+}
+
+// ProxyRequest 代理GitHub请求
+func (p *Proxy) ProxyRequest(c *gin.Context, u string) {
+	p.proxyGitHubWithRedirect(c, u, 0)
+}
+
+// gitSmartHTTPKey 请求上下文中标记git smart HTTP请求的key
+const gitSmartHTTPKey = "git_smart_http"
+
+// proxyGitSmartHTTP 代理git smart HTTP请求：请求体、Content-Type和上游响应原样转发，
+// 不做脚本改写、HTML拦截和多连接加速
+func (p *Proxy) proxyGitSmartHTTP(c *gin.Context, u string) {
+	c.Set(gitSmartHTTPKey, true)
+	p.proxyGitHubWithRedirect(c, u, 0)
+}
+
+// copyGitHubResponseHeaders 处理重定向并复制上游响应头和状态码，
+// 非GitHub地址的重定向由代理内部跟随，此时返回false且不写入任何响应头
+func (p *Proxy) copyGitHubResponseHeaders(c *gin.Context, resp *http.Response, redirectCount int) bool {
+	cfg := p.shared.Config.Get()
+	location := resp.Header.Get("Location")
+	if location != "" && CheckURL(location) == nil {
+		p.shared.Stats.RecordRedirect(resp.Request.URL.Host)
+		p.proxyGitHubWithRedirect(c, location, redirectCount+1)
+		return false
+	}
+
+	utils.CopyResponseHeaders(cfg, c.Writer.Header(), resp.Header, resp.Request.URL.Host)
+	if location != "" {
+		// 指向GitHub的重定向改写为经本服务访问的地址，包含外部访问地址和server.basePath
+		c.Writer.Header().Set("Location", utils.ExternalBaseURL(cfg, c.Request)+"/"+location)
+	}
+	c.Status(resp.StatusCode)
+	return true
+}
+
+// proxyGitHubWithRedirect 带重定向的GitHub代理请求
+func (p *Proxy) proxyGitHubWithRedirect(c *gin.Context, u string, redirectCount int) {
+	cfg := p.shared.Config.Get()
+	if redirectCount == 0 {
+		// 管理令牌只用于代理端的调试授权，无论是否启用调试都不转发给上游
+		c.Request.Header.Del("X-Admin-Token")
+		if p.serveImmutableGitHubAsset(c, SizeLimit(c, u, cfg)) {
+			return
+		}
+	}
+	ctx, err := trackGitHubRedirect(c, u, redirectCount, cfg)
+	var redirectErr *utils.RedirectError
+	if errors.As(err, &redirectErr) {
+		respondRedirectError(c, redirectErr)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, u, c.Request.Body)
+	if err != nil {
+		utils.RespondProxyError(c, http.StatusInternalServerError, utils.ErrCodeUpstream, err)
+		return
+	}
+
+	// 复制请求头
+	for key, values := range c.Request.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Del("Host")
+	// 保留客户端声明的请求体长度，避免git-upload-pack等POST请求改为分块传输
+	req.ContentLength = c.Request.ContentLength
+	// 转换格式需要完整的源码包，不转发断点续传的范围请求
+	if archiveConvertRequested(c) {
+		req.Header.Del("Range")
+		req.Header.Del("If-Range")
+	}
+
+	// 脚本和yum源定义需要在代理端改写内容，只接受可解压的gzip或未压缩响应
+	scriptPath := strings.ToLower(u)
+	if parsed, err := url.Parse(u); err == nil {
+		scriptPath = strings.ToLower(parsed.Path)
+	}
+	gitSmartHTTP := c.GetBool(gitSmartHTTPKey)
+	isScript := !gitSmartHTTP && (strings.HasSuffix(scriptPath, ".sh") || strings.HasSuffix(scriptPath, ".ps1") || strings.HasSuffix(scriptPath, ".repo"))
+	if isScript {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	// API请求属于元数据类，使用带总超时的客户端；release等文件下载不限总时长
+	client := p.clients.Global()
+	if isGitHubAPI(u) {
+		client = p.clients.Metadata()
+	}
+
+	// 可缓存的API请求由HTTP客户端协商压缩，缓存中只保存解压后的内容
+	// 客户端要求绕过缓存时不读取缓存和过期副本，no-store时也不写入
+	apiCacheKey := githubAPICacheKey(c, u, cfg)
+	readCache := apiCacheKey != "" && utils.CacheReadAllowed(cfg, c)
+	if apiCacheKey != "" && !utils.CacheWriteAllowed(cfg, c) {
+		apiCacheKey = ""
+	}
+	if readCache && p.serveCachedGitHubAPI(c, apiCacheKey) {
+		return
+	}
+	var revalidating *utils.CachedItem
+	if apiCacheKey != "" {
+		req.Header.Del("Accept-Encoding")
+	}
+	if readCache {
+		if revalidating = p.cache.GetForRevalidation(apiCacheKey); revalidating != nil {
+			revalidating.Validators().Apply(req)
+		}
+	}
+
+	resp, err := client.Do(req)
+	recordRedirectHops(c, req, resp)
+	if err != nil {
+		if errors.As(err, &redirectErr) {
+			respondRedirectError(c, redirectErr)
+			return
+		}
+		if utils.BodyTooLarge(err) {
+			respondBodyTooLarge(c)
+			return
+		}
+		if budgetErr := utils.RetryUpstreamBudget(c, err); budgetErr != nil {
+			utils.RespondProxyError(c, http.StatusServiceUnavailable, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+			return
+		}
+		utils.RespondProxyError(c, http.StatusInternalServerError, utils.ErrCodeUpstream, err)
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Printf("关闭响应体失败: %v\n", err)
+		}
+	}()
+
+	if revalidating != nil && resp.StatusCode == http.StatusNotModified {
+		p.serveRevalidatedGitHubAPI(c, apiCacheKey, revalidating, resp)
+		return
+	}
+	staleKey := apiCacheKey
+	if !readCache {
+		staleKey = ""
+	}
+	if p.handleGitHubAPIRateLimit(c, u, staleKey, resp) {
+		return
+	}
+	// 原样转发的上游限流响应也带上Retry-After，避免客户端立即重试
+	utils.PropagateUpstreamRetryAfter(c, resp)
+
+	// 续传已完整下载的文件时上游返回416，只转发Content-Range，不转发错误页
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		respondRangeNotSatisfiable(c, resp)
+		return
+	}
+
+	// 检查并处理被阻止的内容类型
+	if c.Request.Method == "GET" && !gitSmartHTTP {
+		contentType := resp.Header.Get("Content-Type")
+		if isHTMLContentType(contentType) && ImpliesRawFile(u) {
+			if !cfg.GitHub.HTMLPassthrough {
+				respondHTMLErrorPage(c, resp.StatusCode)
+				return
+			}
+		} else if blockedContentTypes[strings.ToLower(strings.Split(contentType, ";")[0])] {
+			utils.RespondUpstreamError(c, http.StatusForbidden, resp.StatusCode, utils.ErrCodeContentTypeBlocked)
+			return
+		}
+	}
+
+	// 检查文件大小限制，未声明长度的响应在转发过程中超出时中断
+	sizeLimit := SizeLimit(c, u, cfg)
+	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" && sizeLimit > 0 {
+		if size, err := strconv.ParseInt(contentLength, 10, 64); err == nil && size > sizeLimit {
+			utils.RespondProxyError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, utils.SizeLimitMB(sizeLimit))
+			return
+		}
+	}
+
+	// 按[proxy.responseHeaders]清理和补充响应头，默认删除上游的安全策略头；
+	// git客户端按Content-Type判断协议版本，不受规则影响
+	upstreamContentType := resp.Header.Values("Content-Type")
+	p.clients.ApplyResponseHeaderPolicy(resp.Header)
+	if gitSmartHTTP {
+		resp.Header["Content-Type"] = upstreamContentType
+	}
+
+	realHost := utils.ExternalBaseURL(cfg, c.Request)
+
+	// 处理.sh和.ps1文件的智能处理
+	if isScript {
+		isGzipCompressed := resp.Header.Get("Content-Encoding") == "gzip"
+		// 改写需要把脚本整体读入内存，内存紧张时放弃改写，原样流式转发
+		result := utils.ShellResult{Body: resp.Body, Size: -1}
+		if release, ok := p.shared.Memory.Reserve(scriptBufferEstimate(resp, isGzipCompressed)); ok {
+			defer release()
+			result = utils.ProcessShellResponse(resp.Body, isGzipCompressed, realHost)
+		} else {
+			fmt.Printf("内存紧张，脚本原样转发: %s\n", resp.Request.URL)
+		}
+		body := result.Body
+
+		switch {
+		case result.Rewritten:
+			// 内容已解压并改写，原始的编码和长度不再适用
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Set("Content-Length", strconv.FormatInt(result.Size, 10))
+		case isGzipCompressed && !utils.AcceptsEncoding(c.GetHeader("Accept-Encoding"), "gzip"):
+			// 原样转发时仍是上游的gzip字节流，客户端不接受gzip则流式解压，长度未知改为分块传输
+			gzReader, err := gzip.NewReader(body)
+			if err != nil {
+				utils.RespondProxyError(c, http.StatusBadGateway, utils.ErrCodeScriptProcessing, err)
+				return
+			}
+			defer gzReader.Close()
+			body = gzReader
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+		}
+		// 其余情况原样转发上游字节流，保留上游的Content-Encoding和Content-Length
+
+		if !p.copyGitHubResponseHeaders(c, resp, redirectCount) {
+			return
+		}
+
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			fmt.Printf("转发脚本内容失败: %v\n", err)
+		}
+	} else {
+		convertArchive := shouldConvertArchive(c, resp, cfg)
+		if convertArchive {
+			prepareZipHeaders(resp.Header, resp.Request.URL.Path)
+		}
+		if !p.copyGitHubResponseHeaders(c, resp, redirectCount) {
+			return
+		}
+		setImmutableCacheControl(c, resp)
+		if convertArchive {
+			streamArchiveAsZip(c, resp, sizeLimit)
+			return
+		}
+
+		body, empty := peekEmptyBody(c, resp, utils.NewSizeLimitReader(resp.Body, sizeLimit))
+		if empty {
+			writeEmptyResponse(c)
+			return
+		}
+
+		// 大文件按配置拆分为多个Range请求并发下载，否则直接流式转发
+		// 多连接加速属于重任务，不在允许时段、预算用完或内存紧张时退回普通下载
+		connections := accelConnections(c, cfg)
+		accelerate := !gitSmartHTTP && connections > 1 && utils.AccelEligible(req, resp, cfg.Proxy.AccelMinSize) && p.shared.Schedule.Allow(c) == nil
+		if accelerate {
+			release, ok := p.shared.Memory.Reserve(int64(connections) * cfg.Proxy.AccelChunkSize)
+			if ok {
+				defer release()
+			}
+			accelerate = ok
+		}
+		if accelerate {
+			reader := utils.NewParallelRangeReader(client, req.WithContext(c.Request.Context()), resp, connections, cfg.Proxy.AccelChunkSize)
+			defer reader.Close()
+			body = reader
+		} else {
+			body = p.cacheGitHubAPIResponse(apiCacheKey, resp, body)
+			body = p.cacheImmutableGitHubAsset(c, resp, body)
+		}
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			fmt.Printf("转发响应体失败: %v\n", err)
+		}
+	}
+}
+
+// scriptBufferEstimate 估算改写脚本时的内存占用：原始内容、解压内容和改写结果各一份，
+// 压缩或长度未知时按脚本大小上限估算
+func scriptBufferEstimate(resp *http.Response, isGzipCompressed bool) int64 {
+	size := int64(utils.MaxShellSize)
+	if !isGzipCompressed && resp.ContentLength >= 0 {
+		size = min(resp.ContentLength, size)
+	}
+	return 3 * size
+}
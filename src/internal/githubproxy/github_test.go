@@ -0,0 +1,1215 @@
+package githubproxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckGitHubURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		user string
+		repo string
+	}{
+		{"release", "https://github.com/user/repo/releases/download/v1/file.tar.gz", "user", "repo"},
+		{"raw", "https://raw.githubusercontent.com/user/repo/main/file.sh", "user", "repo"},
+		{"api", "https://api.github.com/repos/user/repo/releases/latest", "user", "repo"},
+		{"huggingface", "https://huggingface.co/user/model/resolve/main/file", "user", "model/resolve/main/file"},
+		{"docker apt repo", "https://download.docker.com/linux/ubuntu/dists/noble/InRelease", "linux", "ubuntu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CheckURL(tt.url)
+			if len(got) < 2 || got[0] != tt.user || got[1] != tt.repo {
+				t.Fatalf("CheckURL(%q) = %#v", tt.url, got)
+			}
+		})
+	}
+}
+
+func TestCheckGitHubURLRejectsOtherHosts(t *testing.T) {
+	if got := CheckURL("https://example.com/user/repo/file"); got != nil {
+		t.Fatalf("unexpected match: %#v", got)
+	}
+}
+
+func TestProxyGitHubPreservesMultiValuedHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Add("Link", `<https://example.com/1>; rel="next"`)
+		w.Header().Add("Link", `<https://example.com/9>; rel="last"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("payload"))
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/file.bin", nil)
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/file.bin", 0)
+
+	if got := w.Header().Values("Set-Cookie"); len(got) != 2 {
+		t.Fatalf("Set-Cookie = %v", got)
+	}
+	if got := w.Header().Values("Link"); len(got) != 2 {
+		t.Fatalf("Link = %v", got)
+	}
+	if got := w.Header().Values("Content-Type"); len(got) != 1 {
+		t.Fatalf("Content-Type = %v", got)
+	}
+	if w.Body.String() != "payload" {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+}
+
+func TestProxyGitHubFollowsRedirectWithoutLeakingHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			w.Header().Set("Set-Cookie", "redirect=1")
+			w.Header().Set("Location", upstream.URL+"/final")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Header().Set("Set-Cookie", "final=1")
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/start", nil)
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/start", 0)
+
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Fatalf("response = %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Values("Set-Cookie"); len(got) != 1 || got[0] != "final=1" {
+		t.Fatalf("Set-Cookie = %v", got)
+	}
+}
+
+func TestProxyGitHubRewritesLocationUnderBasePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[server]
+basePath = "/hub"
+`)
+	p := newTestProxy()
+
+	target := "https://github.com/user/repo/releases/download/v1/app.tar.gz"
+	// HTTP客户端不跟随300响应，指向GitHub的Location交给客户端经代理访问
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMultipleChoices)
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/hub/start", nil)
+	c.Request.Host = "proxy.example"
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/start", 0)
+
+	if want := "http://proxy.example/hub/" + target; w.Code != http.StatusMultipleChoices || w.Header().Get("Location") != want {
+		t.Fatalf("response = %d, Location %q, want %q", w.Code, w.Header().Get("Location"), want)
+	}
+}
+
+func TestProxyGitHubPassesGzipEncodedBodyThrough(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(bytes.Repeat([]byte("tarball-content"), 100))
+	gz.Close()
+	payload := compressed.Bytes()
+
+	var upstreamAcceptEncoding []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAcceptEncoding = append(upstreamAcceptEncoding, r.Header.Get("Accept-Encoding"))
+		if r.Header.Get("Accept-Encoding") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.Write(payload)
+			return
+		}
+		w.Write([]byte("identity"))
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/app.tar.gz", nil)
+	c.Request.Header.Set("Accept-Encoding", "gzip")
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/app.tar.gz", 0)
+
+	if !bytes.Equal(w.Body.Bytes(), payload) {
+		t.Fatal("gzip-encoded body was modified")
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != strconv.Itoa(len(payload)) {
+		t.Fatalf("headers = %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/app.tar.gz", nil)
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/app.tar.gz", 0)
+
+	if w.Body.String() != "identity" || upstreamAcceptEncoding[1] != "" {
+		t.Fatalf("client without Accept-Encoding got %q, upstream saw %q", w.Body.String(), upstreamAcceptEncoding[1])
+	}
+}
+
+func TestProxyGitHubDecompressesScriptsConsistently(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	script := "#!/bin/sh\necho hello\n"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("script request Accept-Encoding = %q", r.Header.Get("Accept-Encoding"))
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(script))
+		gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/install.sh", nil)
+	c.Request.Header.Set("Accept-Encoding", "br")
+	p.proxyGitHubWithRedirect(c, upstream.URL+"/install.sh", 0)
+
+	if w.Body.String() != script {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Length") != strconv.Itoa(len(script)) {
+		t.Fatalf("headers = %v", w.Header())
+	}
+}
+
+func TestProxyGitHubScriptFallbackKeepsEncodingConsistent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	// 超过大小限制的脚本无法改写，只能原样转发
+	script := bytes.Repeat([]byte("echo https://github.com/u/r\n"), utils.MaxShellSize/27+1024)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(script)
+	gz.Close()
+	payload := compressed.Bytes()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Write(payload)
+	}))
+	defer upstream.Close()
+
+	fetch := func(acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/install.sh", nil)
+		if acceptEncoding != "" {
+			c.Request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/install.sh", 0)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+		return w
+	}
+
+	w := fetch("gzip, deflate")
+	if !bytes.Equal(w.Body.Bytes(), payload) {
+		t.Fatal("gzip client did not receive the original encoded stream")
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != strconv.Itoa(len(payload)) {
+		t.Fatalf("gzip client headers = %v", w.Header())
+	}
+
+	for _, acceptEncoding := range []string{"", "gzip;q=0, br"} {
+		w = fetch(acceptEncoding)
+		if !bytes.Equal(w.Body.Bytes(), script) {
+			t.Fatalf("Accept-Encoding %q: body is not the decoded script", acceptEncoding)
+		}
+		if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Length") != "" {
+			t.Fatalf("Accept-Encoding %q: headers = %v", acceptEncoding, w.Header())
+		}
+	}
+}
+
+func TestProxyGitHubScriptStreamsUnderMemoryPressure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := newTestProxy()
+
+	script := []byte("curl -fsSL https://github.com/u/r/install.sh | sh\n")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(script)
+	gz.Close()
+	payload := compressed.Bytes()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Write(payload)
+	}))
+	defer upstream.Close()
+
+	fetch := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/install.sh", nil)
+		c.Request.Header.Set("Accept-Encoding", "gzip")
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/install.sh", 0)
+		return w
+	}
+
+	// 压缩脚本按大小上限估算占用，超过2MB的缓冲上限，放弃改写原样转发
+	loadTestConfig(t, `
+[memory]
+limitBytes = 4194304
+bufferRatio = 0.5
+`)
+	p.shared.Config.Set(config.GetConfig())
+	p.hot.Flush("")
+	degraded := p.shared.Memory.Stats().Degraded
+	w := fetch()
+	if !bytes.Equal(w.Body.Bytes(), payload) || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("under pressure: headers = %v", w.Header())
+	}
+	if got := p.shared.Memory.Stats().Degraded; got != degraded+1 {
+		t.Fatalf("degraded = %d, want %d", got, degraded+1)
+	}
+
+	loadTestConfig(t, `
+[memory]
+limitBytes = 1073741824
+`)
+	p.shared.Config.Set(config.GetConfig())
+	w = fetch()
+	if w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), "/https://github.com/u/r/install.sh") {
+		t.Fatalf("script not rewritten: %q", w.Body.String())
+	}
+	if buffered := p.shared.Memory.Stats().Buffered; buffered != 0 {
+		t.Fatalf("reservation not released: %d", buffered)
+	}
+}
+
+func TestNormalizeGitHubRequestURI(t *testing.T) {
+	tests := []struct {
+		uri       string
+		target    string
+		matchPath string
+	}{
+		{
+			"/https://github.com/owner/repo/releases/download/v1/app.tar.gz",
+			"https://github.com/owner/repo/releases/download/v1/app.tar.gz",
+			"https://github.com/owner/repo/releases/download/v1/app.tar.gz",
+		},
+		{
+			"/http://github.com/owner/repo/archive/main.zip",
+			"https://github.com/owner/repo/archive/main.zip",
+			"https://github.com/owner/repo/archive/main.zip",
+		},
+		{
+			"/https:/github.com/owner/repo/archive/main.zip",
+			"https://github.com/owner/repo/archive/main.zip",
+			"https://github.com/owner/repo/archive/main.zip",
+		},
+		{
+			"/cdn-lfs.hf.co/repos/ab/cd/file?X-Amz-Signature=a%2Fb%3D&X-Amz-Credential=k%2F2024%2Fus-east-1&next=/x?y",
+			"https://cdn-lfs.hf.co/repos/ab/cd/file?X-Amz-Signature=a%2Fb%3D&X-Amz-Credential=k%2F2024%2Fus-east-1&next=/x?y",
+			"https://cdn-lfs.hf.co/repos/ab/cd/file",
+		},
+		{
+			"/api.github.com/repos/owner/repo/contents/dir%2Ffile.txt?ref=main&per_page=1&per_page=2",
+			"https://api.github.com/repos/owner/repo/contents/dir%2Ffile.txt?ref=main&per_page=1&per_page=2",
+			"https://api.github.com/repos/owner/repo/contents/dir%2Ffile.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		target, matchPath, err := NormalizeRequestURI(tt.uri)
+		if err != nil {
+			t.Fatalf("NormalizeRequestURI(%q) error: %v", tt.uri, err)
+		}
+		if target != tt.target || matchPath != tt.matchPath {
+			t.Fatalf("NormalizeRequestURI(%q) = %q, %q", tt.uri, target, matchPath)
+		}
+	}
+}
+
+func TestGitHubProxyHandlerMatchesPathOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := newTestProxy()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/https://example.com/file?u=https://github.com/owner/repo/releases/x", nil)
+	p.Handler(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403 for non-GitHub path with GitHub URL in query", w.Code)
+	}
+}
+
+func TestStripAccelParam(t *testing.T) {
+	for _, tt := range []struct {
+		in, out     string
+		connections int
+	}{
+		{"https://github.com/a/b/releases/download/v1/f.zip", "https://github.com/a/b/releases/download/v1/f.zip", 0},
+		{"https://github.com/a/b/f.zip?accel=4", "https://github.com/a/b/f.zip", 4},
+		{"https://x.com/f?X-Sig=a%2Fb&accel=2&y=1", "https://x.com/f?X-Sig=a%2Fb&y=1", 2},
+		{"https://x.com/f?accel=bad&y=1", "https://x.com/f?y=1", 0},
+	} {
+		out, connections := StripAccelParam(tt.in)
+		if out != tt.out || connections != tt.connections {
+			t.Errorf("StripAccelParam(%q) = %q, %d", tt.in, out, connections)
+		}
+	}
+}
+
+func TestProxyGitHubAcceleratedDownloadMatchesPlain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[proxy]
+accelMinSize = 1024
+accelChunkSize = 65536
+`)
+	p := newTestProxy()
+
+	payload := make([]byte, 700*1024+3)
+	rand.New(rand.NewSource(2)).Read(payload)
+	var ranged atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranged.Store(true)
+		}
+		http.ServeContent(w, r, "file.bin", time.Unix(1700000000, 0), bytes.NewReader(payload))
+	}))
+	defer upstream.Close()
+
+	download := func(connections int) []byte {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/file.bin", nil)
+		if connections > 0 {
+			c.Set("accel_connections", connections)
+		}
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/file.bin", 0)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+		return w.Body.Bytes()
+	}
+
+	plain := download(0)
+	if ranged.Load() {
+		t.Fatal("plain download used range requests")
+	}
+	accelerated := download(4)
+	if !ranged.Load() {
+		t.Fatal("accelerated download made no range requests")
+	}
+	if sha256.Sum256(plain) != sha256.Sum256(payload) || sha256.Sum256(accelerated) != sha256.Sum256(payload) {
+		t.Fatal("downloaded content mismatch")
+	}
+}
+
+func TestImpliesRawFile(t *testing.T) {
+	tests := map[string]bool{
+		"https://github.com/user/repo/releases/download/v1.0/app.tar.gz": true,
+		"https://github.com/user/repo/raw/main/install.sh":               true,
+		"https://github.com/user/repo/archive/refs/heads/main.zip":       true,
+		"https://raw.githubusercontent.com/user/repo/main/install.sh":    true,
+		"https://gist.githubusercontent.com/user/abc/raw/file.txt":       true,
+		"https://codeload.github.com/user/repo/tar.gz/refs/heads/main":   true,
+		"https://github.com/user/repo":                                   false,
+		"https://github.com/user/repo/info/refs?service=git-upload-pack": false,
+		"https://gist.github.com/user/abc":                               false,
+	}
+	for u, want := range tests {
+		if got := ImpliesRawFile(u); got != want {
+			t.Errorf("ImpliesRawFile(%q) = %v, want %v", u, got, want)
+		}
+	}
+}
+
+func TestProxyGitHubHTMLErrorPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte("<!DOCTYPE html><html><body>Page not found</body></html>"))
+	}))
+	defer upstream.Close()
+
+	proxy := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		if accept != "" {
+			c.Request.Header.Set("Accept", accept)
+		}
+		p.proxyGitHubWithRedirect(c, upstream.URL+path, 0)
+		return w
+	}
+
+	// 文件链接返回的错误页不转发，状态码与上游一致
+	w := proxy("/user/repo/raw/main/missing.sh?status=404", "*/*")
+	if w.Code != http.StatusNotFound || bytes.Contains(w.Body.Bytes(), []byte("<html")) {
+		t.Fatalf("404 response = %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Error-Code") != utils.ErrCodeGitHubHTMLPage || w.Header().Get("X-Upstream-Status") != "404" {
+		t.Fatalf("headers = %v", w.Header())
+	}
+
+	w = proxy("/user/repo/releases/download/v1/app.tar.gz?status=500", "application/json")
+	if w.Code != http.StatusInternalServerError || !bytes.Contains(w.Body.Bytes(), []byte(`"code":"`+utils.ErrCodeGitHubHTMLPage+`"`)) {
+		t.Fatalf("500 response = %d %q", w.Code, w.Body.String())
+	}
+
+	// 返回200的HTML同样不是文件，按网关错误处理
+	if w = proxy("/user/repo/blob/main/README.md?status=200", ""); w.Code != http.StatusBadGateway || w.Header().Get("X-Upstream-Status") != "200" {
+		t.Fatalf("200 html response = %d %v", w.Code, w.Header())
+	}
+
+	loadTestConfig(t, `
+[github]
+htmlPassthrough = true
+`)
+	p.shared.Config.Set(config.GetConfig())
+	if w = proxy("/user/repo/raw/main/missing.sh?status=404", ""); w.Code != http.StatusNotFound || !bytes.Contains(w.Body.Bytes(), []byte("Page not found")) {
+		t.Fatalf("passthrough response = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyGitHubRedirectChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user/repo/releases/download/v1/app.tar.gz":
+			http.Redirect(w, r, "/cdn/app.tar.gz?X-Amz-Signature=secret&X-Amz-Credential=cred", http.StatusFound)
+		case "/cdn/app.tar.gz":
+			http.Redirect(w, r, "/final/app.tar.gz", http.StatusTemporaryRedirect)
+		default:
+			w.Write([]byte("file"))
+		}
+	}))
+	defer upstream.Close()
+
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	proxy := func(debug bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		c.Set(redirectDebugKey, debug)
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/user/repo/releases/download/v1/app.tar.gz", 0)
+		return w
+	}
+
+	w := proxy(false)
+	if w.Code != http.StatusOK || w.Header().Get("X-Proxy-Hops") != "3" {
+		t.Fatalf("status %d, hops %q", w.Code, w.Header().Get("X-Proxy-Hops"))
+	}
+	if w.Header().Get("X-Proxy-Redirect-Chain") != "" {
+		t.Fatal("redirect chain exposed without debug mode")
+	}
+
+	w = proxy(true)
+	var chain []RedirectHop
+	if err := json.Unmarshal([]byte(w.Header().Get("X-Proxy-Redirect-Chain")), &chain); err != nil {
+		t.Fatalf("chain header %q: %v", w.Header().Get("X-Proxy-Redirect-Chain"), err)
+	}
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	want := []RedirectHop{
+		{http.MethodGet, host + "/user/repo/releases/download/v1/app.tar.gz", http.StatusFound},
+		{http.MethodGet, host + "/cdn/app.tar.gz?X-Amz-Signature&X-Amz-Credential", http.StatusTemporaryRedirect},
+		{http.MethodGet, host + "/final/app.tar.gz", http.StatusOK},
+	}
+	if !reflect.DeepEqual(chain, want) {
+		t.Fatalf("chain = %+v, want %+v", chain, want)
+	}
+}
+
+func TestRedirectDebugRequiresAdminToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[admin]
+enabled = true
+token = "secret"
+`)
+
+	for _, tt := range []struct {
+		token string
+		want  bool
+	}{
+		{"secret", true},
+		{"wrong", false},
+		{"", false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		if tt.token != "" {
+			c.Request.Header.Set("X-Admin-Token", tt.token)
+		}
+		if got := redirectDebugAllowed(c, config.GetConfig()); got != tt.want {
+			t.Errorf("redirectDebugAllowed(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestStripDebugParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[admin]
+enabled = true
+token = "secret"
+`)
+
+	for _, tt := range []struct {
+		in, token string
+		out       string
+		debug     bool
+	}{
+		{"https://github.com/a/b/f.zip?debug=1", "secret", "https://github.com/a/b/f.zip", true},
+		{"https://github.com/a/b/f.zip?debug=1&y=1", "", "https://github.com/a/b/f.zip?y=1", false},
+		{"https://github.com/a/b/f.zip?debug=0", "secret", "https://github.com/a/b/f.zip", false},
+		{"https://github.com/a/b/f.zip?y=1", "secret", "https://github.com/a/b/f.zip?y=1", false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		if tt.token != "" {
+			c.Request.Header.Set("X-Admin-Token", tt.token)
+		}
+		out := stripDebugParam(c, tt.in, config.GetConfig())
+		if out != tt.out || c.GetBool(redirectDebugKey) != tt.debug {
+			t.Errorf("stripDebugParam(%q, %q) = %q, debug %v", tt.in, tt.token, out, c.GetBool(redirectDebugKey))
+		}
+	}
+}
+
+func TestProxyGitHubStripsAdminToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var received atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Store(r.Header.Get("X-Admin-Token"))
+		w.Write([]byte("file"))
+	}))
+	defer upstream.Close()
+
+	loadTestConfig(t, `
+[admin]
+enabled = true
+token = "secret"
+`)
+	p := newTestProxy()
+
+	// 未使用?debug=1的普通请求同样不能把管理令牌转发给上游
+	resp, body := proxyGitHubOverHTTP(t, p, upstream.URL+"/user/repo/releases/download/v1/app.tar.gz", http.Header{"X-Admin-Token": {"secret"}})
+	if resp.StatusCode != http.StatusOK || string(body) != "file" {
+		t.Fatalf("status %d, body %q", resp.StatusCode, body)
+	}
+	if token, _ := received.Load().(string); token != "" {
+		t.Fatalf("upstream received X-Admin-Token %q", token)
+	}
+}
+
+// proxyGitHubOverHTTP 通过真实HTTP服务转发，用于检查分块传输等只在连接上可见的行为
+func proxyGitHubOverHTTP(t *testing.T, p *Proxy, target string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	router := gin.New()
+	router.Any("/*path", func(c *gin.Context) {
+		p.proxyGitHubWithRedirect(c, target, 0)
+	})
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/file", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestProxyGitHubRangeNotSatisfiable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	for _, contentType := range []string{"text/html; charset=utf-8", "application/xml"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "bytes=12345-" {
+				t.Errorf("Range = %q", r.Header.Get("Range"))
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Range", "bytes */12345")
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			w.Write([]byte("<Error><Code>InvalidRange</Code></Error>"))
+		}))
+
+		resp, body := proxyGitHubOverHTTP(t, p, upstream.URL+"/owner/repo/releases/download/v1/app.tar.gz",
+			http.Header{"Range": {"bytes=12345-"}})
+		upstream.Close()
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || len(body) != 0 {
+			t.Fatalf("%s: status %d, body %q", contentType, resp.StatusCode, body)
+		}
+		if resp.Header.Get("Content-Range") != "bytes */12345" || resp.Header.Get("ETag") != `"abc"` {
+			t.Fatalf("%s: headers = %v", contentType, resp.Header)
+		}
+		if resp.ContentLength != 0 || len(resp.TransferEncoding) != 0 {
+			t.Fatalf("%s: Content-Length %d, Transfer-Encoding %v", contentType, resp.ContentLength, resp.TransferEncoding)
+		}
+	}
+}
+
+func TestProxyGitHubZeroLengthBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		switch r.URL.Path {
+		case "/owner/repo/releases/download/v1/empty":
+			w.Header().Set("Content-Length", "0")
+		case "/owner/repo/releases/download/v1/chunked":
+			// 先发送响应头再结束，上游使用分块传输且没有任何数据块
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		case "/owner/repo/releases/download/v1/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"empty", http.StatusOK},
+		{"chunked", http.StatusOK},
+		{"nocontent", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		resp, body := proxyGitHubOverHTTP(t, p, upstream.URL+"/owner/repo/releases/download/v1/"+tt.path, nil)
+		if resp.StatusCode != tt.status || len(body) != 0 || len(resp.TransferEncoding) != 0 {
+			t.Fatalf("%s: status %d, body %q, Transfer-Encoding %v", tt.path, resp.StatusCode, body, resp.TransferEncoding)
+		}
+		if tt.status == http.StatusOK && resp.Header.Get("Content-Length") != "0" {
+			t.Fatalf("%s: Content-Length = %q", tt.path, resp.Header.Get("Content-Length"))
+		}
+
+		// 长度由处理器显式声明，不依赖net/http在未写入数据时自动补全，中间层提前刷新响应头时同样有效
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/file", nil)
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/owner/repo/releases/download/v1/"+tt.path, 0)
+		want := "0"
+		if tt.status == http.StatusNoContent {
+			want = ""
+		}
+		if got := w.Header().Get("Content-Length"); got != want || w.Code != tt.status {
+			t.Fatalf("%s: handler status %d, Content-Length %q", tt.path, w.Code, got)
+		}
+	}
+}
+
+func TestProxyGitHubRedirectLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch {
+		case r.URL.Path == "/loop/a":
+			http.Redirect(w, r, "/loop/b", http.StatusFound)
+		case r.URL.Path == "/loop/b":
+			http.Redirect(w, r, "/loop/a?X-Amz-Signature=secret", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/step/"):
+			step, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/step/"))
+			if last, _ := strconv.Atoi(r.URL.Query().Get("last")); last > 0 && step >= last {
+				w.Write([]byte("done"))
+				return
+			}
+			http.Redirect(w, r, "/step/"+strconv.Itoa(step+1)+"?"+r.URL.RawQuery, http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	proxy := func(path string) *httptest.ResponseRecorder {
+		hits.Store(0)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		p.proxyGitHubWithRedirect(c, upstream.URL+path, 0)
+		return w
+	}
+
+	// 两个地址互相重定向，再次访问a时立即中止
+	before := p.shared.Stats.RedirectSnapshot()[host]
+	w := proxy("/loop/a?X-Amz-Signature=secret")
+	if w.Code != http.StatusLoopDetected || hits.Load() != 2 {
+		t.Fatalf("loop: status %d, upstream hits %d", w.Code, hits.Load())
+	}
+	if w.Header().Get("X-Error-Code") != utils.ErrCodeRedirectLoop || !strings.Contains(w.Body.String(), host+"/loop/a?X-Amz-Signature") {
+		t.Fatalf("loop body = %q", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Fatal("signed query value leaked")
+	}
+	if after := p.shared.Stats.RedirectSnapshot()[host]; after != before+2 {
+		t.Fatalf("redirects counted %d, want %d", after, before+2)
+	}
+
+	// 各不相同的重定向超过默认上限10次
+	w = proxy("/step/0")
+	if w.Code != http.StatusLoopDetected || w.Header().Get("X-Error-Code") != utils.ErrCodeTooManyRedirects || hits.Load() != 11 {
+		t.Fatalf("ladder: status %d, code %q, upstream hits %d", w.Code, w.Header().Get("X-Error-Code"), hits.Load())
+	}
+	if w := proxy("/step/0?last=10"); w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Fatalf("ladder within limit: status %d", w.Code)
+	}
+
+	loadTestConfig(t, "[proxy]\nmaxRedirects = 3\n")
+	p.shared.Config.Set(config.GetConfig())
+	if w := proxy("/step/0?last=4"); w.Code != http.StatusLoopDetected || hits.Load() != 4 {
+		t.Fatalf("configured limit: status %d, upstream hits %d", w.Code, hits.Load())
+	}
+	if w := proxy("/step/0?last=3"); w.Code != http.StatusOK {
+		t.Fatalf("configured limit allows 3 redirects: status %d", w.Code)
+	}
+}
+
+func TestGitHubProxyErrorSchema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/user/repo/raw/main/missing.sh":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Length", strconv.Itoa(2*1024*1024))
+			w.Write(make([]byte, 2*1024*1024))
+		}
+	}))
+	defer upstream.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	loadTestConfig(t, `
+[access]
+blackList = ["blocked/repo"]
+
+[limits]
+github = 1048576
+`)
+	p := newTestProxy()
+
+	handler := func(path string) func(c *gin.Context) {
+		return func(c *gin.Context) {
+			c.Request = httptest.NewRequest(http.MethodGet, path, nil)
+			p.Handler(c)
+		}
+	}
+	proxy := func(target string) func(c *gin.Context) {
+		return func(c *gin.Context) { p.proxyGitHubWithRedirect(c, target, 0) }
+	}
+	tests := []struct {
+		name           string
+		serve          func(c *gin.Context)
+		status         int
+		code           string
+		upstreamStatus int
+	}{
+		{"invalid input", handler("/https://example.com/file"), http.StatusForbidden, utils.ErrCodeInvalidInput, 0},
+		{"denied", handler("/https://github.com/blocked/repo/releases/download/v1/app.bin"), http.StatusForbidden, utils.ErrCodeGitHubBlacklisted, 0},
+		{"too large", proxy(upstream.URL + "/owner/repo/releases/download/v1/app.bin"), http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, 0},
+		{"redirect loop", proxy(upstream.URL + "/loop"), http.StatusLoopDetected, utils.ErrCodeRedirectLoop, 0},
+		{"upstream failure", proxy(closed.URL + "/owner/repo/raw/main/a.txt"), http.StatusInternalServerError, utils.ErrCodeUpstream, 0},
+		{"upstream error page", proxy(upstream.URL + "/user/repo/raw/main/missing.sh"), http.StatusNotFound, utils.ErrCodeGitHubHTMLPage, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		tt.serve(c)
+
+		if w.Code != tt.status || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("%s: status %d, Content-Type %q", tt.name, w.Code, w.Header().Get("Content-Type"))
+			continue
+		}
+		decoder := json.NewDecoder(w.Body)
+		decoder.DisallowUnknownFields()
+		var body utils.ProxyError
+		if err := decoder.Decode(&body); err != nil {
+			t.Errorf("%s: decode: %v", tt.name, err)
+			continue
+		}
+		if body.Code != tt.code || body.Message == "" || body.UpstreamStatus != tt.upstreamStatus {
+			t.Errorf("%s: body %+v", tt.name, body)
+		}
+		if body.RequestID == "" || body.RequestID != w.Header().Get(utils.RequestIDHeader) || w.Header().Get("X-Error-Code") != tt.code {
+			t.Errorf("%s: request id %q, headers %v", tt.name, body.RequestID, w.Header())
+		}
+	}
+}
+
+func TestGitHubProxyErrorNegotiation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	respond := func(accept, requestID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/https://example.com/file", nil)
+		c.Request.Header.Set("Accept", accept)
+		c.Request.Header.Set(utils.RequestIDHeader, requestID)
+		p.Handler(c)
+		return w
+	}
+
+	w := respond("text/plain", "build-42")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || w.Body.String() != utils.Message(utils.LangZh, utils.ErrCodeInvalidInput) {
+		t.Fatalf("text/plain: %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if w.Header().Get(utils.RequestIDHeader) != "build-42" || w.Header().Get("X-Error-Code") != utils.ErrCodeInvalidInput {
+		t.Fatalf("text/plain headers = %v", w.Header())
+	}
+
+	// curl默认的*/*和同时接受JSON的客户端得到JSON，不合法的请求ID被替换
+	for _, accept := range []string{"*/*", "text/plain, application/json"} {
+		w = respond(accept, "bad id\n")
+		var body utils.ProxyError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != utils.ErrCodeInvalidInput {
+			t.Fatalf("Accept %q: %q", accept, w.Body.String())
+		}
+		if body.RequestID == "" || strings.Contains(body.RequestID, " ") {
+			t.Fatalf("Accept %q: request id %q", accept, body.RequestID)
+		}
+	}
+}
+
+func TestGitHubResponseHeaderPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+	reloader := config.NewReloader(p.shared.Config)
+	reloader.Register(p.clients)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		w.Header().Add("Set-Cookie", "_gh_sess=abc")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	proxy := func() http.Header {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/file.txt", 0)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("status %d, body %q", w.Code, w.Body.String())
+		}
+		return w.Header()
+	}
+
+	// 默认只删除三个安全策略头
+	t.Cleanup(func() { config.Apply(config.DefaultConfig()) })
+	header := proxy()
+	if header.Get("Content-Security-Policy") != "" || header.Get("Referrer-Policy") != "" ||
+		header.Get("Strict-Transport-Security") != "" || header.Get("Set-Cookie") != "_gh_sess=abc" {
+		t.Fatalf("default headers = %v", header)
+	}
+
+	// 热重载后立即生效
+	path := os.Getenv("CONFIG_PATH")
+	reloaded := "[proxy.responseHeaders]\nstrip = [\"Set-Cookie\", \"Content-Security-Policy\"]\nkeep = [\"content-security-policy\"]\nset = { X-Proxied-By = \"hubproxy\" }\n"
+	if err := os.WriteFile(path, []byte(reloaded), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	header = proxy()
+	if header.Get("Set-Cookie") != "" || header.Get("Content-Security-Policy") == "" ||
+		header.Get("Strict-Transport-Security") == "" || header.Get("X-Proxied-By") != "hubproxy" {
+		t.Fatalf("reloaded headers = %v", header)
+	}
+}
+
+// gitFixture 在临时目录创建包含两个提交的裸仓库 owner/repo.git，返回仓库根目录和最新提交
+func gitFixture(t *testing.T, gitPath string) (string, string) {
+	t.Helper()
+	run := func(dir string, args ...string) string {
+		cmd := exec.Command(gitPath, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	work := t.TempDir()
+	run(work, "init", "-q", "-b", "main")
+	for i, content := range []string{"first\n", "second\n"} {
+		if err := os.WriteFile(filepath.Join(work, "README"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(work, "add", "README")
+		run(work, "commit", "-q", "-m", strconv.Itoa(i))
+	}
+
+	root := t.TempDir()
+	run(root, "clone", "-q", "--bare", work, filepath.Join(root, "owner", "repo.git"))
+	return root, run(work, "rev-parse", "HEAD")
+}
+
+func TestGitSmartHTTPShallowClone(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not installed")
+	}
+	gin.SetMode(gin.TestMode)
+	// 响应头规则不影响git协议的Content-Type
+	loadTestConfig(t, "[proxy.responseHeaders]\nstrip = [\"Content-Type\"]\n")
+	p := newTestProxy()
+	t.Cleanup(func() { config.Apply(config.DefaultConfig()) })
+
+	root, head := gitFixture(t, gitPath)
+	var upstreamURIs []string
+	var mu sync.Mutex
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamURIs = append(upstreamURIs, r.Method+" "+r.URL.RequestURI())
+		mu.Unlock()
+		backend.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	// 与GitHubProxyHandler相同的解析和改写，上游主机替换为本地仓库服务
+	router := gin.New()
+	router.Any("/*path", func(c *gin.Context) {
+		rawPath, matchPath, err := NormalizeRequestURI(c.Request.URL.RequestURI())
+		match := MatchURL(matchPath)
+		if err != nil || match == nil || !match.route.git {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		p.proxyGitSmartHTTP(c, strings.Replace(match.Rewrite(rawPath), "https://github.com", upstream.URL, 1))
+	})
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	dest := filepath.Join(t.TempDir(), "clone")
+	cmd := exec.Command(gitPath, "clone", "-q", "--depth", "1", proxy.URL+"/github.com/owner/repo", dest)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+t.TempDir(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v\n%s", err, out)
+	}
+
+	out, err := exec.Command(gitPath, "-C", dest, "rev-list", "--count", "HEAD").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Fatalf("shallow clone has %q commits: %v", out, err)
+	}
+	out, _ = exec.Command(gitPath, "-C", dest, "rev-parse", "HEAD").Output()
+	if strings.TrimSpace(string(out)) != head {
+		t.Fatalf("HEAD = %q, want %s", out, head)
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "README"))
+	if err != nil || string(content) != "second\n" {
+		t.Fatalf("README = %q, %v", content, err)
+	}
+
+	// 上游收到补全.git后缀的地址，service参数原样保留
+	mu.Lock()
+	defer mu.Unlock()
+	if len(upstreamURIs) < 2 || upstreamURIs[0] != "GET /owner/repo.git/info/refs?service=git-upload-pack" ||
+		upstreamURIs[len(upstreamURIs)-1] != "POST /owner/repo.git/git-upload-pack" {
+		t.Fatalf("upstream requests = %v", upstreamURIs)
+	}
+}
+
+func TestProxyGitHubBoundsUpstreamHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[upstream.responseHeaders]
+maxBytes = 65536
+maxValueLength = 1024
+maxCount = 50
+`)
+	p := newTestProxy()
+
+	// 原始TCP服务端，可以写出net/http服务端不允许的响应头
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				var head strings.Builder
+				head.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: 7\r\n")
+				switch req.URL.Path {
+				case "/many":
+					for i := range 200 {
+						fmt.Fprintf(&head, "X-Extra-%03d: %d\r\n", i, i)
+					}
+					head.WriteString("X-Long: " + strings.Repeat("a", 4096) + "\r\n")
+				case "/huge":
+					for i := range 100 {
+						fmt.Fprintf(&head, "X-Huge-%03d: %s\r\n", i, strings.Repeat("b", 1000))
+					}
+				case "/invalid":
+					head.WriteString("X-Bad: a\x01b\r\n")
+				}
+				head.WriteString("\r\npayload")
+				conn.Write([]byte(head.String()))
+			}()
+		}
+	}()
+
+	proxy := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, path, nil)
+		p.proxyGitHubWithRedirect(c, "http://"+listener.Addr().String()+path, 0)
+		return w
+	}
+
+	// 条数和单值长度超限的部分被丢弃，内容相关的头优先保留
+	w := proxy("/many")
+	if w.Code != http.StatusOK || w.Body.String() != "payload" {
+		t.Fatalf("many: status %d, body %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/octet-stream" || w.Header().Get("X-Long") != "" {
+		t.Fatalf("many: headers %v", w.Header())
+	}
+	extra := 0
+	for key := range w.Header() {
+		if strings.HasPrefix(key, "X-Extra-") {
+			extra++
+		}
+	}
+	if extra == 0 || extra > 50 {
+		t.Fatalf("many: relayed %d extra headers", extra)
+	}
+
+	// 超出总大小上限或含非法字节的响应不转发，按上游错误返回结构化错误
+	for _, path := range []string{"/huge", "/invalid"} {
+		w := proxy(path)
+		if w.Code < 500 || w.Header().Get("X-Error-Code") != utils.ErrCodeUpstream ||
+			strings.Contains(w.Body.String(), "payload") || w.Header().Get("X-Huge-000") != "" {
+			t.Fatalf("%s: status %d, body %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func loadTestConfig(t testing.TB, body string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestProxy 按当前配置创建使用独立缓存和上游客户端的代理
+func newTestProxy() *Proxy {
+	shared := utils.NewComponents(config.GetConfig())
+	return New(utils.NewUniversalCache(shared.Background.Context(), shared.Config), utils.NewHotCache(shared.Config), accesscontrol.New(shared.Config), utils.NewHTTPClients(shared.Config, shared.Stats), shared)
+}
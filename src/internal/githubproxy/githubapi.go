@@ -0,0 +1,208 @@
+package githubproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// githubAPIHost GitHub API主机，测试时替换为本地服务
+var githubAPIHost = "api.github.com"
+
+// maxGitHubAPICacheBody 可缓存的API响应体上限，超过时照常转发但不缓存
+const maxGitHubAPICacheBody = 1 << 20
+
+// githubAPICachedHeaders 随缓存保存的上游响应头
+var githubAPICachedHeaders = []string{"Cache-Control", "ETag", "Last-Modified", "Link"}
+
+// isGitHubAPI 判断地址是否指向GitHub API
+func isGitHubAPI(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && parsed.Host == githubAPIHost
+}
+
+// githubAPICacheKey 返回API请求的缓存key，按URL和Authorization的摘要区分，不同凭据的响应互不复用；
+// 未启用缓存、非GET、条件请求和Range请求返回空
+func githubAPICacheKey(c *gin.Context, u string, cfg *config.AppConfig) string {
+	if !cfg.GitHub.APICache || c.Request.Method != http.MethodGet || !isGitHubAPI(u) {
+		return ""
+	}
+	if c.GetHeader("Range") != "" || c.GetHeader("If-None-Match") != "" || c.GetHeader("If-Modified-Since") != "" {
+		return ""
+	}
+	auth := "anonymous"
+	if authorization := c.GetHeader("Authorization"); authorization != "" {
+		sum := sha256.Sum256([]byte(authorization))
+		auth = "authorized:" + hex.EncodeToString(sum[:])
+	}
+	return utils.BuildCacheKey(strings.TrimSuffix(utils.GitHubAPICachePrefix, ":"), auth+" "+u)
+}
+
+// githubAPICacheTTL 按上游Cache-Control计算缓存时间，未声明时使用cfg中的默认值，private或禁止缓存的响应返回0
+func githubAPICacheTTL(header http.Header, cfg *config.AppConfig) time.Duration {
+	ttl := utils.ParseDurationOr(cfg.GitHub.APICacheTTL, 0)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				ttl = time.Duration(max(seconds, 0)) * time.Second
+			}
+		}
+	}
+	return ttl
+}
+
+// githubAPICacheReader 转发响应体的同时保存副本，读到结尾且未超过上限时写入缓存
+type githubAPICacheReader struct {
+	body     io.Reader
+	buf      bytes.Buffer
+	overflow bool
+	done     func(data []byte)
+}
+
+func (r *githubAPICacheReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if !r.overflow {
+		if r.buf.Len()+n > maxGitHubAPICacheBody {
+			r.overflow = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !r.overflow && r.done != nil {
+		r.done(r.buf.Bytes())
+		r.done = nil
+	}
+	return n, err
+}
+
+// cacheGitHubAPIResponse 为可缓存的200响应包装响应体，转发完成后写入缓存
+func (p *Proxy) cacheGitHubAPIResponse(cacheKey string, resp *http.Response, body io.Reader) io.Reader {
+	if cacheKey == "" || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return body
+	}
+	cfg := p.shared.Config.Get()
+	ttl := githubAPICacheTTL(resp.Header, cfg)
+	if ttl <= 0 {
+		return body
+	}
+
+	headers := make(map[string]string)
+	for _, key := range githubAPICachedHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			headers[key] = value
+		}
+	}
+	var staleFor time.Duration
+	if cfg.GitHub.APIRateLimitFallback {
+		staleFor = utils.ParseDurationOr(cfg.GitHub.APIStaleFor, 0)
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	return &githubAPICacheReader{body: body, done: func(data []byte) {
+		p.cache.SetWithStale(cacheKey, bytes.Clone(data), contentType, headers, ttl, staleFor)
+	}}
+}
+
+// serveCachedGitHubAPI 命中未过期缓存时直接返回
+func (p *Proxy) serveCachedGitHubAPI(c *gin.Context, cacheKey string) bool {
+	if cacheKey == "" {
+		return false
+	}
+	item := p.cache.Get(cacheKey)
+	if item == nil {
+		return false
+	}
+	c.Header("Age", strconv.Itoa(int(time.Since(item.StoredAt).Seconds())))
+	c.Header("X-Cache", "HIT")
+	utils.SetCacheOutcome(c, utils.CacheHit)
+	utils.WriteCachedResponse(c, item)
+	return true
+}
+
+// serveRevalidatedGitHubAPI 上游对条件请求返回304时续期缓存并返回缓存内容，
+// 304响应不计入GitHub API限额；上游随304返回的Cache-Control等头会覆盖缓存中的旧值
+func (p *Proxy) serveRevalidatedGitHubAPI(c *gin.Context, cacheKey string, item *utils.CachedItem, resp *http.Response) {
+	updated := make(map[string]string)
+	for _, key := range githubAPICachedHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			updated[key] = value
+		}
+	}
+	cfg := p.shared.Config.Get()
+	ttl := githubAPICacheTTL(resp.Header, cfg)
+	if resp.Header.Get("Cache-Control") == "" {
+		ttl = githubAPICacheTTL(http.Header{"Cache-Control": {item.Headers["Cache-Control"]}}, cfg)
+	}
+	if ttl > 0 {
+		item = p.cache.Renew(cacheKey, item, updated, ttl)
+	} else {
+		p.cache.RecordRevalidation(cacheKey, len(item.Data))
+	}
+
+	c.Header("X-Cache", "REVALIDATED")
+	utils.SetCacheOutcome(c, utils.CacheHit)
+	utils.WriteCachedResponse(c, item)
+}
+
+// githubRateLimitReset 返回GitHub限流响应的限额重置时间，非限流响应或无法确定时返回false
+func githubRateLimitReset(resp *http.Response) (time.Time, bool) {
+	if !utils.UpstreamThrottled(resp) {
+		return time.Time{}, false
+	}
+	if value := resp.Header.Get("X-RateLimit-Reset"); value != "" {
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil && epoch > 0 {
+			return time.Unix(epoch, 0), true
+		}
+	}
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Now().Add(time.Duration(seconds) * time.Second), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// handleGitHubAPIRateLimit 处理GitHub API限流响应：有缓存时返回过期副本，
+// 否则返回带重置时间的错误；非限流响应或未启用时返回false，由调用方照常转发
+func (p *Proxy) handleGitHubAPIRateLimit(c *gin.Context, u, cacheKey string, resp *http.Response) bool {
+	if !p.shared.Config.Get().GitHub.APIRateLimitFallback || !isGitHubAPI(u) {
+		return false
+	}
+	reset, limited := githubRateLimitReset(resp)
+	if !limited {
+		return false
+	}
+
+	if cacheKey != "" {
+		if item, _ := p.cache.GetStale(cacheKey); item != nil {
+			utils.WriteStaleResponse(c, item, `110 - "Response is Stale"`)
+			return true
+		}
+	}
+
+	retryAfter := max(int(time.Until(reset).Seconds()), 1)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	resetAt := reset.UTC().Format(time.RFC3339)
+	utils.RespondErrorFields(c, resp.StatusCode, utils.ErrCodeGitHubRateLimit, utils.Localize(c, utils.ErrCodeGitHubRateLimit, resetAt), gin.H{
+		"reset_at":     resetAt,
+		"retry_after":  retryAfter,
+		"retry_jitter": utils.RetryJitter(retryAfter),
+	})
+	return true
+}
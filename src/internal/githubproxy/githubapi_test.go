@@ -0,0 +1,290 @@
+package githubproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// githubAPITestServer 按录制的GitHub API响应回放，rateLimited为true时返回限额耗尽的403
+func githubAPITestServer(t *testing.T, p *Proxy, rateLimited *atomic.Bool, reset time.Time) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	release, err := os.ReadFile("testdata/github_api_release_latest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited, err := os.ReadFile("testdata/github_api_rate_limited.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if rateLimited.Load() {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(limited)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		if r.Header.Get("Authorization") != "" {
+			w.Header().Set("Cache-Control", "private, max-age=60, s-maxage=60")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=60, s-maxage=60")
+		}
+		w.Header().Set("ETag", `W/"3f1c2a"`)
+		w.Write(release)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := githubAPIHost
+	githubAPIHost = strings.TrimPrefix(server.URL, "http://")
+	t.Cleanup(func() { githubAPIHost = previous })
+	p.cache.Flush(utils.GitHubAPICachePrefix)
+	return server, &hits
+}
+
+func proxyGitHubAPI(p *Proxy, u, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+	c.Request.Header.Set("Accept-Encoding", "gzip")
+	if authorization != "" {
+		c.Request.Header.Set("Authorization", authorization)
+	}
+	p.proxyGitHubWithRedirect(c, u, 0)
+	return w
+}
+
+func TestGitHubAPICache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	var rateLimited atomic.Bool
+	server, hits := githubAPITestServer(t, p, &rateLimited, time.Now().Add(time.Hour))
+	latest := server.URL + "/repos/sky22333/hubproxy/releases/latest"
+
+	first := proxyGitHubAPI(p, latest, "")
+	second := proxyGitHubAPI(p, latest, "")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK || hits.Load() != 1 {
+		t.Fatalf("status %d/%d, upstream hits %d", first.Code, second.Code, hits.Load())
+	}
+	if second.Header().Get("X-Cache") != "HIT" || !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Fatalf("cached response differs: %v", second.Header())
+	}
+	if second.Header().Get("ETag") != `W/"3f1c2a"` || !strings.HasPrefix(second.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("cached headers = %v", second.Header())
+	}
+
+	// 携带令牌的请求使用单独的key，上游标记为private的响应不缓存
+	proxyGitHubAPI(p, latest, "Bearer ghp_test")
+	proxyGitHubAPI(p, latest, "Bearer ghp_test")
+	if hits.Load() != 3 {
+		t.Fatalf("upstream hits = %d, authorized private responses must not be cached", hits.Load())
+	}
+
+	loadTestConfig(t, "[github]\napiCache = false\n")
+	p.shared.Config.Set(config.GetConfig())
+	proxyGitHubAPI(p, server.URL+"/repos/sky22333/hubproxy/tags", "")
+	proxyGitHubAPI(p, server.URL+"/repos/sky22333/hubproxy/tags", "")
+	if hits.Load() != 5 {
+		t.Fatalf("upstream hits = %d with cache disabled", hits.Load())
+	}
+}
+
+func TestGitHubAPICacheSeparatesCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	// 上游即使把携带令牌的响应标记为可缓存，也不能把一个令牌的结果返回给另一个令牌
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte(`{"viewer":"` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer server.Close()
+	previous := githubAPIHost
+	githubAPIHost = strings.TrimPrefix(server.URL, "http://")
+	t.Cleanup(func() { githubAPIHost = previous })
+	p.cache.Flush(utils.GitHubAPICachePrefix)
+	user := server.URL + "/user/repos"
+
+	alice := proxyGitHubAPI(p, user, "Bearer alice")
+	bogus := proxyGitHubAPI(p, user, "Bearer bogus")
+	if strings.Contains(bogus.Body.String(), "alice") || bogus.Header().Get("X-Cache") == "HIT" {
+		t.Fatalf("response for another token was replayed: %s", bogus.Body.String())
+	}
+	again := proxyGitHubAPI(p, user, "Bearer alice")
+	if again.Header().Get("X-Cache") != "HIT" || again.Body.String() != alice.Body.String() {
+		t.Fatalf("same token not served from cache: %v %s", again.Header(), again.Body.String())
+	}
+	if anonymous := proxyGitHubAPI(p, user, ""); strings.Contains(anonymous.Body.String(), "alice") {
+		t.Fatalf("authorized response replayed to anonymous caller: %s", anonymous.Body.String())
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("upstream hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestGitHubAPIRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	var rateLimited atomic.Bool
+	reset := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	server, _ := githubAPITestServer(t, p, &rateLimited, reset)
+	latest := server.URL + "/repos/sky22333/hubproxy/releases/latest"
+
+	fresh := proxyGitHubAPI(p, latest, "")
+	item := p.cache.Get(githubAPICacheKeyForTest(latest))
+	if fresh.Code != http.StatusOK || item == nil {
+		t.Fatalf("status %d, cached %v", fresh.Code, item != nil)
+	}
+	item.ExpiresAt = time.Now().Add(-time.Second)
+	rateLimited.Store(true)
+
+	// 限流时返回过期的缓存副本
+	w := proxyGitHubAPI(p, latest, "")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" || w.Header().Get("Warning") == "" {
+		t.Fatalf("stale response = %d %v", w.Code, w.Header())
+	}
+	if !bytes.Equal(w.Body.Bytes(), fresh.Body.Bytes()) {
+		t.Fatal("stale body differs from cached response")
+	}
+
+	// 没有缓存时返回带重置时间的错误
+	w = proxyGitHubAPI(p, server.URL+"/repos/sky22333/hubproxy/releases", "")
+	var body struct {
+		Code    string `json:"code"`
+		ResetAt string `json:"reset_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusForbidden {
+		t.Fatalf("rate limit response = %d %s", w.Code, w.Body.String())
+	}
+	if body.Code != utils.ErrCodeGitHubRateLimit || body.ResetAt != reset.UTC().Format(time.RFC3339) {
+		t.Fatalf("rate limit body = %+v", body)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter < 1700 || retryAfter > 1800 {
+		t.Fatalf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+
+	// 关闭后原样转发上游的限流响应
+	loadTestConfig(t, "[github]\napiRateLimitFallback = false\n")
+	p.shared.Config.Set(config.GetConfig())
+	w = proxyGitHubAPI(p, latest, "")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "API rate limit exceeded") {
+		t.Fatalf("passthrough response = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func githubAPICacheKeyForTest(u string) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+	return githubAPICacheKey(c, u, config.GetConfig())
+}
+
+func TestGitHubAPICacheTTL(t *testing.T) {
+	loadTestConfig(t, "[github]\napiCacheTTL = \"30s\"\n")
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"public, max-age=60, s-maxage=60", 60 * time.Second},
+		{"private, max-age=60", 0},
+		{"no-store", 0},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("Cache-Control", tt.cacheControl)
+		if got := githubAPICacheTTL(header, config.GetConfig()); got != tt.want {
+			t.Errorf("githubAPICacheTTL(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubAPIRevalidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	var version, full, notModified atomic.Int64
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := `{"tag_name":"v` + strconv.FormatInt(version.Load(), 10) + `.0.0"}`
+		etag := `"v` + strconv.FormatInt(version.Load(), 10) + `"`
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(current))
+	}))
+	defer server.Close()
+	previous := githubAPIHost
+	githubAPIHost = strings.TrimPrefix(server.URL, "http://")
+	t.Cleanup(func() { githubAPIHost = previous })
+	p.cache.Flush(utils.GitHubAPICachePrefix)
+
+	latest := server.URL + "/repos/sky22333/hubproxy/releases/latest"
+	cacheKey := utils.BuildCacheKey(strings.TrimSuffix(utils.GitHubAPICachePrefix, ":"), "anonymous "+latest)
+	expire := func() {
+		item := p.cache.Get(cacheKey)
+		if item == nil {
+			t.Fatal("API response not cached")
+		}
+		item.ExpiresAt = time.Now().Add(-time.Second)
+		item.StaleUntil = item.ExpiresAt
+	}
+
+	if w := proxyGitHubAPI(p, latest, ""); w.Body.String() != `{"tag_name":"v1.0.0"}` {
+		t.Fatalf("initial body %q", w.Body.String())
+	}
+
+	// 未变化：上游返回304，缓存续期且内容不变
+	expire()
+	w := proxyGitHubAPI(p, latest, "")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "REVALIDATED" || w.Body.String() != `{"tag_name":"v1.0.0"}` {
+		t.Fatalf("revalidated response: %d %q %q", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Fatalf("upstream full %d, not modified %d", full.Load(), notModified.Load())
+	}
+	if w := proxyGitHubAPI(p, latest, ""); w.Header().Get("X-Cache") != "HIT" || full.Load()+notModified.Load() != 2 {
+		t.Fatalf("renewed entry not served from cache: %q", w.Header().Get("X-Cache"))
+	}
+
+	// 内容变化：上游返回200，替换缓存
+	version.Store(2)
+	expire()
+	if w := proxyGitHubAPI(p, latest, ""); w.Body.String() != `{"tag_name":"v2.0.0"}` || w.Header().Get("X-Cache") == "REVALIDATED" {
+		t.Fatalf("changed response: %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if item := p.cache.Get(cacheKey); item == nil || string(item.Data) != `{"tag_name":"v2.0.0"}` {
+		t.Fatal("cache not replaced with changed response")
+	}
+}
@@ -0,0 +1,145 @@
+package githubproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// immutableAssetKey 请求上下文中记录固定到commit SHA的链接在热点缓存中的key
+const immutableAssetKey = "github_immutable_asset"
+
+// immutableCacheControl 固定到commit SHA的源码包和文件内容不会再变化，允许下游CDN和浏览器长期缓存
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// githubRefExps 源码包和文件链接中ref所在的位置，第一个分组为ref。
+// blob链接转发前已改写为raw链接，codeload为archive链接重定向后的地址
+var githubRefExps = []*regexp.Regexp{
+	regexp.MustCompile(`^(?:https?://)?github\.com/[^/]+/[^/]+/archive/([^/?#]+?)\.(?:tar\.gz|zip)(?:[?#]|$)`),
+	regexp.MustCompile(`^(?:https?://)?github\.com/[^/]+/[^/]+/(?:blob|raw)/([^/?#]+)/`),
+	regexp.MustCompile(`^(?:https?://)?raw\.github(?:usercontent)?\.com/[^/]+/[^/]+/([^/?#]+)/`),
+	regexp.MustCompile(`^(?:https?://)?codeload\.github\.com/[^/]+/[^/]+/(?:legacy\.)?(?:tar\.gz|zip)/([^/?#]+)(?:[?#]|$)`),
+}
+
+// commitSHAExp 完整的40位commit SHA
+var commitSHAExp = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// githubRef 返回源码包或文件链接中的ref，不是这类链接时返回空字符串
+func githubRef(u string) string {
+	for _, exp := range githubRefExps {
+		if matches := exp.FindStringSubmatch(u); matches != nil {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// isImmutableGitHubURL 判断链接是否固定到完整的commit SHA。分支和tag会移动，
+// 短SHA随仓库增长可能变得有歧义，都按普通链接处理
+func IsImmutableURL(u string) bool {
+	return commitSHAExp.MatchString(githubRef(u))
+}
+
+// markImmutableGitHubAsset 链接固定到commit SHA时记录其热点缓存key，
+// 之后的响应附带长期缓存头，多次请求的小文件写入热点缓存，只按容量淘汰
+func markImmutableGitHubAsset(c *gin.Context, u string) {
+	if IsImmutableURL(u) {
+		c.Set(immutableAssetKey, utils.BuildGitHubAssetHotKey(u))
+	}
+}
+
+// immutableAssetCacheable 判断本次请求能否读写固定链接的热点缓存：只处理完整的GET请求，
+// 范围请求和转换格式的源码包内容与原文件不同
+func immutableAssetCacheable(c *gin.Context, cfg *config.AppConfig) bool {
+	return c.GetString(immutableAssetKey) != "" && c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" &&
+		!archiveConvertRequested(c) && cfg.HotCache.Enabled
+}
+
+// serveImmutableGitHubAsset 固定链接的内容在热点缓存中时直接返回，不再请求上游
+func (p *Proxy) serveImmutableGitHubAsset(c *gin.Context, sizeLimit int64) bool {
+	cfg := p.shared.Config.Get()
+	if !immutableAssetCacheable(c, cfg) || !utils.CacheReadAllowed(cfg, c) {
+		return false
+	}
+	reader, contentType, ok := p.hot.NewReader(c.GetString(immutableAssetKey))
+	if !ok {
+		return false
+	}
+	if sizeLimit > 0 && reader.Size() > sizeLimit {
+		utils.RespondProxyError(c, http.StatusRequestEntityTooLarge, utils.ErrCodeFileTooLarge, utils.SizeLimitMB(sizeLimit))
+		return true
+	}
+
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Header("Content-Length", strconv.FormatInt(reader.Size(), 10))
+	c.Header("Cache-Control", immutableCacheControl)
+	c.Header("X-Cache", "HIT")
+	utils.SetCacheOutcome(c, utils.CacheHit)
+
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		utils.Logf(utils.LogWarn, "github immutable", "转发缓存内容失败: %v", err)
+	}
+	return true
+}
+
+// setImmutableCacheControl 固定链接的成功响应替换上游的缓存头，允许下游长期缓存
+func setImmutableCacheControl(c *gin.Context, resp *http.Response) {
+	if c.GetString(immutableAssetKey) == "" {
+		return
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		c.Writer.Header().Set("Cache-Control", immutableCacheControl)
+		c.Writer.Header().Del("Expires")
+	}
+}
+
+// cacheImmutableGitHubAsset 固定链接的完整响应长度已知、未压缩且已被请求多次时，转发的同时保存副本，
+// 读到结尾且长度一致时写入热点缓存
+func (p *Proxy) cacheImmutableGitHubAsset(c *gin.Context, resp *http.Response, body io.Reader) io.Reader {
+	cfg := p.shared.Config.Get()
+	if !immutableAssetCacheable(c, cfg) || !utils.CacheWriteAllowed(cfg, c) || resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Content-Encoding") != "" || resp.ContentLength <= 0 {
+		return body
+	}
+	key := c.GetString(immutableAssetKey)
+	if !p.hot.Admit(key, resp.ContentLength) {
+		return body
+	}
+	utils.SetCacheOutcome(c, utils.CacheMiss)
+	return &immutableAssetReader{body: body, size: resp.ContentLength, buf: bytes.NewBuffer(make([]byte, 0, resp.ContentLength)), done: func(data []byte) {
+		p.hot.Store(key, data, resp.Header.Get("Content-Type"))
+	}}
+}
+
+// immutableAssetReader 转发响应体的同时保存副本，读到结尾且长度与声明一致时调用done
+type immutableAssetReader struct {
+	body io.Reader
+	size int64
+	read int64
+	buf  *bytes.Buffer
+	done func(data []byte)
+}
+
+func (r *immutableAssetReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.read += int64(n)
+	if r.read <= r.size {
+		r.buf.Write(p[:n])
+	}
+	if err == io.EOF && r.done != nil {
+		if r.read == r.size {
+			r.done(r.buf.Bytes())
+		}
+		r.done = nil
+	}
+	return n, err
+}
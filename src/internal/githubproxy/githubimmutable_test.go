@@ -0,0 +1,110 @@
+package githubproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testCommitSHA = "0123456789abcdef0123456789abcdef01234567"
+
+func TestIsImmutableGitHubURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://github.com/o/r/archive/" + testCommitSHA + ".tar.gz", true},
+		{"https://github.com/o/r/archive/" + testCommitSHA + ".zip", true},
+		{"github.com/o/r/blob/" + testCommitSHA + "/README.md", true},
+		{"https://github.com/o/r/raw/" + testCommitSHA + "/dir/file.txt", true},
+		{"https://raw.githubusercontent.com/o/r/" + testCommitSHA + "/install.sh", true},
+		{"https://codeload.github.com/o/r/tar.gz/" + testCommitSHA, true},
+		{"https://codeload.github.com/o/r/legacy.zip/" + testCommitSHA, true},
+		{"https://github.com/o/r/archive/0123456.tar.gz", false},
+		{"https://github.com/o/r/archive/refs/heads/main.tar.gz", false},
+		{"https://github.com/o/r/archive/v1.2.3.tar.gz", false},
+		{"https://raw.githubusercontent.com/o/r/main/install.sh", false},
+		{"https://raw.githubusercontent.com/o/r/refs/heads/" + testCommitSHA + "/install.sh", false},
+		{"https://github.com/o/r/releases/download/v1.0/app.tar.gz", false},
+		{"https://codeload.github.com/o/r/tar.gz/refs/tags/v1.0", false},
+	}
+	for _, tt := range tests {
+		if got := IsImmutableURL(tt.url); got != tt.want {
+			t.Errorf("IsImmutableURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestProxyGitHubImmutableAsset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[hotCache]
+enabled = true
+promoteAfter = 2
+`)
+	p := newTestProxy()
+	p.hot.Flush("")
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("pinned content\n"))
+	}))
+	defer upstream.Close()
+
+	fetch := func(clientURL string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		markImmutableGitHubAsset(c, clientURL)
+		p.ProxyRequest(c, upstream.URL+"/file.txt")
+		if w.Code != http.StatusOK || w.Body.String() != "pinned content\n" {
+			t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	// 分支链接保留上游的缓存头，也不进入热点缓存
+	branch := "https://raw.githubusercontent.com/o/r/main/file.txt"
+	for i := 0; i < 3; i++ {
+		w := fetch(branch)
+		if got := w.Header().Get("Cache-Control"); got != "max-age=300" {
+			t.Fatalf("branch Cache-Control = %q", got)
+		}
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("branch upstream hits = %d, want 3", hits.Load())
+	}
+
+	pinned := "https://raw.githubusercontent.com/o/r/" + testCommitSHA + "/file.txt"
+	for i := 0; i < 2; i++ {
+		w := fetch(pinned)
+		if got := w.Header().Get("Cache-Control"); got != immutableCacheControl {
+			t.Fatalf("pinned Cache-Control = %q", got)
+		}
+		if w.Header().Get("Expires") != "" {
+			t.Fatalf("pinned response kept Expires: %q", w.Header().Get("Expires"))
+		}
+	}
+	if hits.Load() != 5 {
+		t.Fatalf("upstream hits = %d, want 5", hits.Load())
+	}
+
+	// 请求达到promoteAfter次后写入热点缓存，之后不再请求上游
+	w := fetch(pinned)
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Fatalf("cached response headers = %v", w.Header())
+	}
+	if w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+	if hits.Load() != 5 {
+		t.Fatalf("cached request reached upstream: hits = %d", hits.Load())
+	}
+}
@@ -0,0 +1,57 @@
+package githubproxy
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 代理链接的请求方法策略类别，对应 [proxy.methods] 中的配置项
+const (
+	methodClassGitHub      = "github"
+	methodClassAPI         = "api"
+	methodClassGit         = "git"
+	methodClassHuggingFace = "huggingface"
+)
+
+// apiWriteMethods proxy.methods.allowWrites 开启时api.github.com额外允许的写方法
+var apiWriteMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// allowedMethods 返回链接所属类别允许的请求方法，每次按当前配置计算，热重载后立即生效
+func (m *Match) allowedMethods(cfg *config.AppConfig) []string {
+	methods := cfg.Proxy.Methods
+	switch m.route.methodClass {
+	case methodClassAPI:
+		if !methods.AllowWrites {
+			return methods.API
+		}
+		allowed := slices.Clone(methods.API)
+		for _, method := range apiWriteMethods {
+			if !slices.Contains(allowed, method) {
+				allowed = append(allowed, method)
+			}
+		}
+		return allowed
+	case methodClassGit:
+		return methods.Git
+	case methodClassHuggingFace:
+		return methods.HuggingFace
+	default:
+		return methods.GitHub
+	}
+}
+
+// checkMethod 检查请求方法是否为链接所属类别允许的方法，不允许时返回405和Allow头，返回是否放行
+func checkMethod(c *gin.Context, match *Match, cfg *config.AppConfig) bool {
+	allowed := match.allowedMethods(cfg)
+	if slices.Contains(allowed, c.Request.Method) {
+		return true
+	}
+	c.Header("Allow", strings.Join(allowed, ", "))
+	utils.RespondProxyError(c, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, c.Request.Method)
+	return false
+}
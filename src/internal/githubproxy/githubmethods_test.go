@@ -0,0 +1,98 @@
+package githubproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGitHubMethodPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	check := func(method, target string) (int, string) {
+		match := MatchURL(target)
+		if match == nil {
+			t.Fatalf("%s: no match", target)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/"+target, nil)
+		if checkMethod(c, match, config.GetConfig()) {
+			return http.StatusOK, ""
+		}
+		return w.Code, w.Header().Get("Allow")
+	}
+
+	const (
+		release = "https://github.com/owner/repo/releases/download/v1/app.tar.gz"
+		raw     = "https://raw.githubusercontent.com/owner/repo/main/install.sh"
+		api     = "https://api.github.com/repos/owner/repo/releases/latest"
+		clone   = "https://github.com/owner/repo.git/git-upload-pack"
+		lfs     = "https://github.com/owner/repo.git/info/lfs/objects/batch"
+		hf      = "https://huggingface.co/owner/model/resolve/main/model.bin"
+	)
+
+	loadTestConfig(t, "")
+	tests := []struct {
+		method, target string
+		want           int
+		allow          string
+	}{
+		{http.MethodGet, release, http.StatusOK, ""},
+		{http.MethodHead, raw, http.StatusOK, ""},
+		{http.MethodPost, release, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, api, http.StatusOK, ""},
+		{http.MethodDelete, api, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, clone, http.StatusOK, ""},
+		{http.MethodPost, lfs, http.StatusOK, ""},
+		{http.MethodPut, clone, http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodHead, hf, http.StatusOK, ""},
+		{http.MethodPost, hf, http.StatusMethodNotAllowed, "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		if code, allow := check(tt.method, tt.target); code != tt.want || allow != tt.allow {
+			t.Errorf("default %s %s = %d, Allow %q; want %d, %q", tt.method, tt.target, code, allow, tt.want, tt.allow)
+		}
+	}
+
+	// 配置重新加载后立即按新的策略检查
+	loadTestConfig(t, `
+[proxy.methods]
+github = ["GET"]
+allowWrites = true
+huggingface = ["GET", "HEAD", "POST"]
+`)
+	tests = []struct {
+		method, target string
+		want           int
+		allow          string
+	}{
+		{http.MethodHead, release, http.StatusMethodNotAllowed, "GET"},
+		{http.MethodDelete, api, http.StatusOK, ""},
+		{http.MethodPatch, api, http.StatusOK, ""},
+		{http.MethodOptions, api, http.StatusMethodNotAllowed, "GET, HEAD, POST, PUT, PATCH, DELETE"},
+		{http.MethodPost, hf, http.StatusOK, ""},
+		{http.MethodPost, clone, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		if code, allow := check(tt.method, tt.target); code != tt.want || allow != tt.allow {
+			t.Errorf("override %s %s = %d, Allow %q; want %d, %q", tt.method, tt.target, code, allow, tt.want, tt.allow)
+		}
+	}
+}
+
+func TestGitHubProxyHandlerRejectsMethodBeforeUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "")
+	p := newTestProxy()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/https://api.github.com/repos/owner/repo/contents/README.md", nil)
+	p.Handler(c)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" || w.Header().Get("X-Error-Code") != "METHOD_NOT_ALLOWED" {
+		t.Fatalf("PUT to api.github.com = %d, Allow %q, X-Error-Code %q", w.Code, w.Header().Get("Allow"), w.Header().Get("X-Error-Code"))
+	}
+}
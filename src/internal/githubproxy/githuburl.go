@@ -0,0 +1,334 @@
+package githubproxy
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+)
+
+// githubRoute 上游主机下的一种链接形式
+type githubRoute struct {
+	// match 检查主机后的路径(不含开头的/)，返回捕获的字段，不匹配时返回nil
+	match func(host, path string) []string
+	// rewrite 可选，转发前改写上游地址
+	rewrite func(target string) string
+	// git 为git smart HTTP协议的请求，请求体和Content-Type原样转发，不做脚本改写等内容处理
+	git bool
+	// pattern 主机后的路径形式，访问检查时返回匹配的规则
+	pattern string
+	// methodClass 适用的请求方法策略类别，为空时按文件下载(methodClassGitHub)处理
+	methodClass string
+}
+
+// githubHost 一个上游主机允许代理的链接形式，按顺序匹配，取第一个匹配的形式
+type githubHost struct {
+	routes []githubRoute
+	// access 访问控制适配器，按捕获的字段检查名单
+	access func(ac *accesscontrol.Controller, matches []string) (bool, string)
+}
+
+// Match 解析后的代理链接
+type Match struct {
+	host     *githubHost
+	name     string
+	route    *githubRoute
+	captures []string
+}
+
+// checkGitHubListAccess 按owner/repo检查仓库黑白名单
+func checkGitHubListAccess(ac *accesscontrol.Controller, matches []string) (bool, string) {
+	return ac.CheckGitHubAccess(matches)
+}
+
+// githubHosts 允许代理的上游主机，按主机名分派，避免逐条尝试所有规则
+var githubHosts = map[string]*githubHost{}
+
+// registerGitHubHost 注册上游主机的链接规则，多个主机可共用同一组规则
+func registerGitHubHost(host *githubHost, names ...string) {
+	if host.access == nil {
+		host.access = checkGitHubListAccess
+	}
+	for _, name := range names {
+		githubHosts[name] = host
+	}
+}
+
+func init() {
+	registerGitHubHost(&githubHost{routes: []githubRoute{
+		{match: repoSubpathMatcher("releases/", "archive/"), pattern: "{owner}/{repo}/{releases,archive}/..."},
+		{match: repoSubpathMatcher("blob/", "raw/"), rewrite: blobToRaw, pattern: "{owner}/{repo}/{blob,raw}/..."},
+		{match: matchGitSmartHTTP, rewrite: gitSmartHTTPUpstream, git: true, methodClass: methodClassGit,
+			pattern: "{owner}/{repo}[.git]/{info/refs,git-upload-pack,git-receive-pack}"},
+		// info/lfs等其余git相关路径按普通链接转发，LFS批量接口使用POST
+		{match: repoSubpathMatcher("info", "git-"), methodClass: methodClassGit, pattern: "{owner}/{repo}/{info,git-}..."},
+	}}, "github.com")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchRawFile, pattern: "{owner}/{repo}/{ref}/{path}"}}},
+		"raw.githubusercontent.com", "raw.github.com")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchGist, pattern: "{owner}/{id}/..."}}},
+		"gist.githubusercontent.com", "gist.github.com")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchGitHubAPI, methodClass: methodClassAPI, pattern: "repos/{owner}/{repo}/..."}}}, "api.github.com")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchHuggingFace, methodClass: methodClassHuggingFace, pattern: "[spaces/]{owner}/{path}"}}}, "huggingface.co")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchHuggingFaceLFS, methodClass: methodClassHuggingFace, pattern: "[spaces/]{owner}/{repo}[/{file}]"}}}, "cdn-lfs.hf.co")
+	registerGitHubHost(&githubHost{routes: []githubRoute{
+		{match: matchDockerArchive, pattern: "{channel}/...{.tgz,.zip}"},
+		{match: matchDockerLinuxRepo, pattern: "linux/{distro}/{path}"},
+	}}, "download.docker.com")
+	registerGitHubHost(&githubHost{routes: []githubRoute{{match: matchGitHubAssets, pattern: "{dir}/{file}"}}},
+		"github.githubassets.com", "opengraph.githubassets.com")
+}
+
+// MatchURL 解析一次链接并按主机分派到对应规则，协议头可省略，不匹配时返回nil
+func MatchURL(u string) *Match {
+	rest := u
+	if after, ok := strings.CutPrefix(u, "https://"); ok {
+		rest = after
+	} else if after, ok := strings.CutPrefix(u, "http://"); ok {
+		rest = after
+	}
+	name, path, found := strings.Cut(rest, "/")
+	if !found {
+		return nil
+	}
+	host := githubHosts[name]
+	if host == nil {
+		return nil
+	}
+	for i := range host.routes {
+		if captures := host.routes[i].match(name, path); captures != nil {
+			return &Match{host: host, name: name, route: &host.routes[i], captures: captures}
+		}
+	}
+	return nil
+}
+
+// CheckURL 检查URL是否匹配GitHub模式，返回捕获的owner、repo等字段
+func CheckURL(u string) []string {
+	if m := MatchURL(u); m != nil {
+		return m.captures
+	}
+	return nil
+}
+
+// Pattern 返回匹配的主机和路径形式，如 github.com/{owner}/{repo}/{releases,archive}/...
+func (m *Match) Pattern() string {
+	return m.name + "/" + m.route.pattern
+}
+
+// CheckAccess 按主机的访问控制规则检查链接
+func (m *Match) CheckAccess(access *accesscontrol.Controller) (bool, string) {
+	return m.host.access(access, m.captures)
+}
+
+// Rewrite 按链接形式改写转发的上游地址
+func (m *Match) Rewrite(target string) string {
+	if m.route.rewrite == nil {
+		return target
+	}
+	return m.route.rewrite(target)
+}
+
+// gitSmartHTTPPaths git客户端在仓库地址后访问的smart HTTP路径
+var gitSmartHTTPPaths = []string{"info/refs", "git-upload-pack", "git-receive-pack"}
+
+// matchGitSmartHTTP 匹配 owner/repo[.git]/info/refs 及 git-upload-pack、git-receive-pack，
+// 与其他仓库链接一样捕获原始的repo，由访问控制等使用方去掉.git后缀
+func matchGitSmartHTTP(_, path string) []string {
+	owner, repo, rest, ok := splitRepoPath(path)
+	if !ok || !slices.Contains(gitSmartHTTPPaths, rest) {
+		return nil
+	}
+	return []string{owner, repo}
+}
+
+// gitSmartHTTPUpstream 为不带.git的克隆地址补全仓库名后缀，service等查询参数原样保留
+func gitSmartHTTPUpstream(target string) string {
+	base, query, hasQuery := strings.Cut(target, "?")
+	for _, suffix := range gitSmartHTTPPaths {
+		repo, found := strings.CutSuffix(base, "/"+suffix)
+		if !found {
+			continue
+		}
+		if !strings.HasSuffix(repo, ".git") {
+			base = repo + ".git/" + suffix
+		}
+		break
+	}
+	if hasQuery {
+		return base + "?" + query
+	}
+	return base
+}
+
+// blobToRaw 将blob链接转换为raw链接
+func blobToRaw(target string) string {
+	return strings.Replace(target, "/blob/", "/raw/", 1)
+}
+
+// splitRepoPath 拆出路径开头的两段非空路径，rest为第二个/之后的部分
+func splitRepoPath(path string) (owner, repo, rest string, ok bool) {
+	owner, after, found := strings.Cut(path, "/")
+	if !found || owner == "" {
+		return "", "", "", false
+	}
+	repo, rest, found = strings.Cut(after, "/")
+	if !found || repo == "" {
+		return "", "", "", false
+	}
+	return owner, repo, rest, true
+}
+
+// lineLength 返回第一个换行符之前的长度，与正则中 . 不匹配换行的语义一致
+func lineLength(s string) int {
+	if n := strings.IndexByte(s, '\n'); n >= 0 {
+		return n
+	}
+	return len(s)
+}
+
+// repoSubpathMatcher 匹配 owner/repo/<前缀>... 形式的仓库链接
+func repoSubpathMatcher(prefixes ...string) func(host, path string) []string {
+	return func(_, path string) []string {
+		owner, repo, rest, ok := splitRepoPath(path)
+		if !ok {
+			return nil
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(rest, prefix) {
+				return []string{owner, repo}
+			}
+		}
+		return nil
+	}
+}
+
+// matchRawFile 匹配 owner/repo/<ref>/<文件路径>，ref和文件路径都不能为空
+func matchRawFile(_, path string) []string {
+	owner, repo, rest, ok := splitRepoPath(path)
+	if !ok {
+		return nil
+	}
+	// ref和文件路径都不能为空，且只在第一行内判断：去掉首尾各一个字符后仍需包含/
+	line := rest[:lineLength(rest)]
+	if len(line) < 3 || !strings.Contains(line[1:len(line)-1], "/") {
+		return nil
+	}
+	return []string{owner, repo}
+}
+
+// matchGist 匹配 owner/<gist ID>...
+func matchGist(_, path string) []string {
+	owner, after, found := strings.Cut(path, "/")
+	if !found || owner == "" {
+		return nil
+	}
+	id, _, _ := strings.Cut(after, "/")
+	if id == "" {
+		return nil
+	}
+	return []string{owner, id}
+}
+
+// matchGitHubAPI 匹配 repos/owner/repo/...
+func matchGitHubAPI(_, path string) []string {
+	rest, found := strings.CutPrefix(path, "repos/")
+	if !found {
+		return nil
+	}
+	owner, repo, _, ok := splitRepoPath(rest)
+	if !ok {
+		return nil
+	}
+	return []string{owner, repo}
+}
+
+// matchHuggingFacePath 匹配 owner/<其余路径>，其余路径只取第一行且不能为空
+func matchHuggingFacePath(path string) []string {
+	owner, rest, found := strings.Cut(path, "/")
+	if !found || owner == "" {
+		return nil
+	}
+	rest = rest[:lineLength(rest)]
+	if rest == "" {
+		return nil
+	}
+	return []string{owner, rest}
+}
+
+// matchHuggingFace 匹配 [spaces/]owner/<其余路径>，第二个字段为repo及之后的完整路径
+func matchHuggingFace(_, path string) []string {
+	if space, found := strings.CutPrefix(path, "spaces/"); found {
+		if matches := matchHuggingFacePath(space); matches != nil {
+			return matches
+		}
+	}
+	return matchHuggingFacePath(path)
+}
+
+// matchHuggingFaceLFSPath 匹配 owner/repo[/文件路径]，第三个字段为文件路径，不存在时为空
+func matchHuggingFaceLFSPath(path string) []string {
+	owner, after, found := strings.Cut(path, "/")
+	if !found || owner == "" {
+		return nil
+	}
+	repo, file, _ := strings.Cut(after, "/")
+	if repo == "" {
+		return nil
+	}
+	return []string{owner, repo, file[:lineLength(file)]}
+}
+
+// matchHuggingFaceLFS 匹配 [spaces/]owner/repo[/文件路径]
+func matchHuggingFaceLFS(_, path string) []string {
+	if space, found := strings.CutPrefix(path, "spaces/"); found {
+		if matches := matchHuggingFaceLFSPath(space); matches != nil {
+			return matches
+		}
+	}
+	return matchHuggingFaceLFSPath(path)
+}
+
+// matchDockerArchive 匹配 <渠道>/....tgz 或 .zip 形式的静态二进制包，第二个字段为扩展名
+func matchDockerArchive(_, path string) []string {
+	channel, rest, found := strings.Cut(path, "/")
+	if !found || channel == "" {
+		return nil
+	}
+	line := rest[:lineLength(rest)]
+	tgz, zip := strings.LastIndex(line, ".tgz"), strings.LastIndex(line, ".zip")
+	switch {
+	case tgz < 0 && zip < 0:
+		return nil
+	case tgz > zip:
+		return []string{channel, "tgz"}
+	default:
+		return []string{channel, "zip"}
+	}
+}
+
+// matchDockerLinuxRepo 匹配 linux/<发行版>/... 形式的软件源
+func matchDockerLinuxRepo(_, path string) []string {
+	rest, found := strings.CutPrefix(path, "linux/")
+	if !found {
+		return nil
+	}
+	distro, file, found := strings.Cut(rest, "/")
+	if !found || distro == "" || lineLength(file) == 0 {
+		return nil
+	}
+	return []string{"linux", distro}
+}
+
+// matchGitHubAssets 匹配 <目录>/<文件>，第一个字段为子域名(github或opengraph)
+func matchGitHubAssets(host, path string) []string {
+	dir, file, found := strings.Cut(path, "/")
+	if !found || dir == "" || lineLength(file) == 0 {
+		return nil
+	}
+	return []string{strings.TrimSuffix(host, ".githubassets.com"), dir}
+}
+
+// Git 判断链接是否为git smart HTTP请求
+func (m *Match) Git() bool {
+	return m.route.git
+}
@@ -0,0 +1,247 @@
+package githubproxy
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// legacyGitHubExps 改为按主机分派之前逐条扫描的正则，作为兼容性测试的基准
+var legacyGitHubExps = []*regexp.Regexp{
+	regexp.MustCompile(`^(?:https?://)?github\.com/([^/]+)/([^/]+)/(?:releases|archive)/.*`),
+	regexp.MustCompile(`^(?:https?://)?github\.com/([^/]+)/([^/]+)/(?:blob|raw)/.*`),
+	regexp.MustCompile(`^(?:https?://)?github\.com/([^/]+)/([^/]+)/(?:info|git-).*`),
+	regexp.MustCompile(`^(?:https?://)?raw\.github(?:usercontent|)\.com/([^/]+)/([^/]+)/.+?/.+`),
+	regexp.MustCompile(`^(?:https?://)?gist\.(?:githubusercontent|github)\.com/([^/]+)/([^/]+).*`),
+	regexp.MustCompile(`^(?:https?://)?api\.github\.com/repos/([^/]+)/([^/]+)/.*`),
+	regexp.MustCompile(`^(?:https?://)?huggingface\.co(?:/spaces)?/([^/]+)/(.+)`),
+	regexp.MustCompile(`^(?:https?://)?cdn-lfs\.hf\.co(?:/spaces)?/([^/]+)/([^/]+)(?:/(.*))?`),
+	regexp.MustCompile(`^(?:https?://)?download\.docker\.com/([^/]+)/.*\.(tgz|zip)`),
+	regexp.MustCompile(`^(?:https?://)?download\.docker\.com/(linux)/([^/]+)/.+`),
+	regexp.MustCompile(`^(?:https?://)?(github|opengraph)\.githubassets\.com/([^/]+)/.+?`),
+}
+
+func legacyCheckGitHubURL(u string) []string {
+	for _, exp := range legacyGitHubExps {
+		if matches := exp.FindStringSubmatch(u); matches != nil {
+			return matches[1:]
+		}
+	}
+	return nil
+}
+
+// githubURLCorpus 旧规则接受和拒绝的各类链接
+var githubURLCorpus = []string{
+	// github.com
+	"https://github.com/user/repo/releases/download/v1/file.tar.gz",
+	"https://github.com/user/repo/releases/",
+	"https://github.com/user/repo/releases",
+	"https://github.com/user/repo/archive/refs/tags/v1.0.tar.gz",
+	"https://github.com/user/repo/archive/main.zip",
+	"https://github.com/user/repo/blob/main/install.sh",
+	"https://github.com/user/repo/raw/main/install.sh",
+	"https://github.com/user/repo/raw/",
+	"https://github.com/user/repo.git/info/refs?service=git-upload-pack",
+	"https://github.com/user/repo.git/info/refs",
+	"https://github.com/user/repo.git/git-upload-pack",
+	"https://github.com/user/repo/information",
+	"https://github.com/user/repo/git-",
+	"https://github.com/user/repo/tree/main",
+	"https://github.com/user/repo/issues/1",
+	"https://github.com/user/repo",
+	"https://github.com/user/repo/",
+	"https://github.com/user",
+	"https://github.com//repo/releases/x",
+	"https://github.com/user//releases/x",
+	"https://github.com/",
+	"https://github.com",
+	"github.com/user/repo/releases/download/v1/a",
+	"http://github.com/user/repo/releases/download/v1/a",
+	"https://GitHub.com/user/repo/releases/download/v1/a",
+	"https://github.com:443/user/repo/releases/download/v1/a",
+	"https://https://github.com/user/repo/releases/x",
+	"ftp://github.com/user/repo/releases/x",
+	"https://github.com/user/repo/releases/download/v1/a?token=1",
+	"https://github.com/us\ner/repo/releases/x",
+	"https://github.com/user/repo/blob/main/a\nb",
+	// raw.githubusercontent.com
+	"https://raw.githubusercontent.com/user/repo/main/file.sh",
+	"https://raw.githubusercontent.com/user/repo/refs/heads/main/dir/file.sh",
+	"https://raw.githubusercontent.com/user/repo/main/",
+	"https://raw.githubusercontent.com/user/repo/main",
+	"https://raw.githubusercontent.com/user/repo//file",
+	"https://raw.githubusercontent.com/user/repo/a/b",
+	"https://raw.githubusercontent.com/user/repo/a//",
+	"https://raw.githubusercontent.com/user/repo///",
+	"https://raw.githubusercontent.com/user/repo/ab/",
+	"https://raw.githubusercontent.com/user/repo/a\n/b",
+	"https://raw.githubusercontent.com/user/repo/a/\nb",
+	"https://raw.githubusercontent.com/user/repo/ab/c\n",
+	"https://raw.github.com/user/repo/main/file.sh",
+	"https://raw.githubusercontent.com/user/repo",
+	// gist
+	"https://gist.githubusercontent.com/user/abc123/raw/file.sh",
+	"https://gist.githubusercontent.com/user/abc123",
+	"https://gist.github.com/user/abc123",
+	"https://gist.github.com/user/",
+	"https://gist.github.com/user",
+	"https://gist.github.com//abc",
+	// api.github.com
+	"https://api.github.com/repos/user/repo/releases/latest",
+	"https://api.github.com/repos/user/repo/",
+	"https://api.github.com/repos/user/repo",
+	"https://api.github.com/users/user",
+	"https://api.github.com/repos//repo/x",
+	// huggingface.co
+	"https://huggingface.co/user/model/resolve/main/file",
+	"https://huggingface.co/user/model",
+	"https://huggingface.co/user/",
+	"https://huggingface.co/user",
+	"https://huggingface.co/spaces/user/app/resolve/main/app.py",
+	"https://huggingface.co/spaces/user/app",
+	"https://huggingface.co/spaces/user/",
+	"https://huggingface.co/spaces/user",
+	"https://huggingface.co/spaces/",
+	"https://huggingface.co/spaces//x",
+	"https://huggingface.co/datasets/user/data/resolve/main/train.parquet",
+	"https://huggingface.co/user/model/a\nb",
+	"https://huggingface.co/user/\nmodel",
+	"https://huggingface.co/spaces/user/\nx",
+	// cdn-lfs.hf.co
+	"https://cdn-lfs.hf.co/repos/ab/cd/0123456789abcdef",
+	"https://cdn-lfs.hf.co/user/repo",
+	"https://cdn-lfs.hf.co/user/repo/",
+	"https://cdn-lfs.hf.co/user/repo/a\nb",
+	"https://cdn-lfs.hf.co/user/re\npo/x",
+	"https://cdn-lfs.hf.co/user/",
+	"https://cdn-lfs.hf.co/spaces/user/repo/file",
+	"https://cdn-lfs.hf.co/spaces/user",
+	"https://cdn-lfs.hf.co/spaces/user/",
+	// download.docker.com
+	"https://download.docker.com/linux/static/stable/x86_64/docker-27.0.3.tgz",
+	"https://download.docker.com/mac/static/stable/aarch64/docker-27.0.3.tgz",
+	"https://download.docker.com/win/static/stable/x86_64/docker-27.0.3.zip",
+	"https://download.docker.com/win/static/a.zip/b.tgz",
+	"https://download.docker.com/win/static/a.tgz/b.zip",
+	"https://download.docker.com/win/a.tgz\nb.zip",
+	"https://download.docker.com/win/\n.zip",
+	"https://download.docker.com/win/.tgz",
+	"https://download.docker.com/win/tgz",
+	"https://download.docker.com/linux/ubuntu/dists/noble/InRelease",
+	"https://download.docker.com/linux/ubuntu/gpg",
+	"https://download.docker.com/linux/ubuntu/",
+	"https://download.docker.com/linux/ubuntu/\n",
+	"https://download.docker.com/linux/ubuntu",
+	"https://download.docker.com/linux/centos/docker-ce.repo",
+	"https://download.docker.com/linux//x",
+	"https://download.docker.com//x.tgz",
+	// githubassets.com
+	"https://github.githubassets.com/assets/app.js",
+	"https://opengraph.githubassets.com/abc/user/repo",
+	"https://github.githubassets.com/assets/",
+	"https://github.githubassets.com/assets/\n",
+	"https://avatars.githubassets.com/assets/app.js",
+	// 其他主机
+	"https://example.com/user/repo/file",
+	"https://objects.githubusercontent.com/release-assets/1",
+	"https://codeload.github.com/user/repo/tar.gz/main",
+	"https://hf.co/user/model",
+	"",
+	"/",
+	"https://",
+	"github.com",
+}
+
+func TestCheckGitHubURLCompatibility(t *testing.T) {
+	for _, u := range githubURLCorpus {
+		got, want := CheckURL(u), legacyCheckGitHubURL(u)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("CheckURL(%q) = %#v, legacy %#v", u, got, want)
+		}
+	}
+}
+
+func TestGitHubURLRewrite(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/user/repo/blob/main/install.sh":              "https://github.com/user/repo/raw/main/install.sh",
+		"https://github.com/user/repo/raw/main/blob/x":                   "https://github.com/user/repo/raw/main/raw/x",
+		"https://github.com/user/repo/releases/download/v1/blob/file":    "https://github.com/user/repo/releases/download/v1/blob/file",
+		"https://raw.githubusercontent.com/user/repo/main/blob/file.txt": "https://raw.githubusercontent.com/user/repo/main/blob/file.txt",
+	}
+	for u, want := range tests {
+		match := MatchURL(u)
+		if match == nil {
+			t.Fatalf("%s not matched", u)
+		}
+		// 旧实现只在blob规则匹配时改写
+		legacy := u
+		if legacyGitHubExps[1].MatchString(u) {
+			legacy = strings.Replace(u, "/blob/", "/raw/", 1)
+		}
+		if got := match.Rewrite(u); got != want || got != legacy {
+			t.Errorf("rewrite(%s) = %s, want %s, legacy %s", u, got, want, legacy)
+		}
+	}
+}
+
+func FuzzCheckGitHubURLCompatibility(f *testing.F) {
+	for _, u := range githubURLCorpus {
+		f.Add(u)
+	}
+	f.Fuzz(func(t *testing.T, u string) {
+		got, want := CheckURL(u), legacyCheckGitHubURL(u)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("CheckURL(%q) = %#v, legacy %#v", u, got, want)
+		}
+	})
+}
+
+func benchmarkGitHubURLMatch(b *testing.B, check func(string) []string) {
+	urls := []string{
+		"https://github.com/user/repo/releases/download/v1/file.tar.gz",
+		"https://raw.githubusercontent.com/user/repo/main/file.sh",
+		"https://huggingface.co/user/model/resolve/main/model.safetensors",
+		"https://github.githubassets.com/assets/app.js",
+		"https://example.com/user/repo/file",
+	}
+	b.ReportAllocs()
+	for n := 0; b.Loop(); n++ {
+		check(urls[n%len(urls)])
+	}
+}
+
+func BenchmarkCheckGitHubURL(b *testing.B) {
+	benchmarkGitHubURLMatch(b, CheckURL)
+}
+
+func BenchmarkCheckGitHubURLLegacy(b *testing.B) {
+	benchmarkGitHubURLMatch(b, legacyCheckGitHubURL)
+}
+
+func TestGitSmartHTTPUpstream(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/user/repo/info/refs?service=git-upload-pack":       "https://github.com/user/repo.git/info/refs?service=git-upload-pack",
+		"https://github.com/user/repo.git/info/refs?service=git-upload-pack":   "https://github.com/user/repo.git/info/refs?service=git-upload-pack",
+		"https://github.com/user/repo/git-upload-pack":                         "https://github.com/user/repo.git/git-upload-pack",
+		"https://github.com/user/repo/git-receive-pack":                        "https://github.com/user/repo.git/git-receive-pack",
+		"https://github.com/user/repo/info/refs?service=git-upload-pack&x=%2F": "https://github.com/user/repo.git/info/refs?service=git-upload-pack&x=%2F",
+	}
+	for u, want := range tests {
+		base, _, _ := strings.Cut(u, "?")
+		match := MatchURL(base)
+		if match == nil || !match.route.git {
+			t.Fatalf("%s not matched as git smart HTTP", u)
+		}
+		if got := match.Rewrite(u); got != want {
+			t.Errorf("rewrite(%s) = %s, want %s", u, got, want)
+		}
+	}
+
+	// info/lfs等路径仍按普通链接转发
+	for _, u := range []string{"https://github.com/user/repo.git/info/lfs/objects/batch", "https://github.com/user/repo/info/refs/extra"} {
+		if match := MatchURL(u); match == nil || match.route.git {
+			t.Errorf("%s matched as git smart HTTP", u)
+		}
+	}
+}
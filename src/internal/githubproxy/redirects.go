@@ -0,0 +1,113 @@
+package githubproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// redirectChainKey 请求上下文中记录上游重定向链的key
+	redirectChainKey = "redirect_chain"
+	// redirectDebugKey 请求上下文中标记返回完整重定向链的key
+	redirectDebugKey = "redirect_debug"
+	// redirectTrackerKey 请求上下文中记录已访问上游地址的key
+	redirectTrackerKey = "redirect_tracker"
+)
+
+// RedirectHop 代理访问上游时经过的一跳
+type RedirectHop struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// upstreamHops 还原一次上游请求经过的各跳，包括HTTP客户端内部跟随的重定向
+func upstreamHops(req *http.Request, resp *http.Response) []RedirectHop {
+	if resp == nil {
+		return []RedirectHop{{Method: req.Method, URL: utils.RedactURL(req.URL)}}
+	}
+
+	var hops []RedirectHop
+	for r := resp; r != nil && r.Request != nil; r = r.Request.Response {
+		hops = append(hops, RedirectHop{Method: r.Request.Method, URL: utils.RedactURL(r.Request.URL), Status: r.StatusCode})
+	}
+	for i, j := 0, len(hops)-1; i < j; i, j = i+1, j-1 {
+		hops[i], hops[j] = hops[j], hops[i]
+	}
+	return hops
+}
+
+// recordRedirectHops 将上游请求的各跳追加到重定向链，并更新X-Proxy-Hops响应头；
+// 调试模式下同时返回完整链路并写入日志
+func recordRedirectHops(c *gin.Context, req *http.Request, resp *http.Response) {
+	chain := append(redirectChain(c), upstreamHops(req, resp)...)
+	c.Set(redirectChainKey, chain)
+	c.Header("X-Proxy-Hops", strconv.Itoa(len(chain)))
+
+	if !c.GetBool(redirectDebugKey) {
+		return
+	}
+	data, err := json.Marshal(chain)
+	if err != nil {
+		return
+	}
+	c.Header("X-Proxy-Redirect-Chain", string(data))
+	fmt.Printf("上游重定向链 %s: %s\n", c.Request.URL.Path, data)
+}
+
+// redirectChain 返回请求已记录的重定向链
+func redirectChain(c *gin.Context) []RedirectHop {
+	if value, exists := c.Get(redirectChainKey); exists {
+		return value.([]RedirectHop)
+	}
+	return nil
+}
+
+// redirectDebugAllowed 检查X-Admin-Token是否为有效的管理令牌，
+// 该请求头由proxyGitHubWithRedirect在转发前移除
+func redirectDebugAllowed(c *gin.Context, cfg *config.AppConfig) bool {
+	token := strings.TrimSpace(c.GetHeader("X-Admin-Token"))
+	if !cfg.Admin.Enabled || cfg.Admin.Token == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) == 1
+}
+
+// trackGitHubRedirect 返回携带重定向跟踪器的上游请求context。redirectCount为0时开始新的请求链，
+// 大于0表示代理自行跟随的重定向，与HTTP客户端内部跟随的重定向共用计数和已访问地址
+func trackGitHubRedirect(c *gin.Context, u string, redirectCount int, cfg *config.AppConfig) (context.Context, error) {
+	ctx := c.Request.Context()
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ctx, err
+	}
+
+	value, exists := c.Get(redirectTrackerKey)
+	if !exists || redirectCount == 0 {
+		tracker := utils.NewRedirectTracker(parsed, utils.MaxRedirects(cfg))
+		c.Set(redirectTrackerKey, tracker)
+		return utils.WithRedirectTracker(ctx, tracker), nil
+	}
+	tracker := value.(*utils.RedirectTracker)
+	return utils.WithRedirectTracker(ctx, tracker), tracker.Visit(parsed)
+}
+
+// respondRedirectError 重定向循环或超出次数上限时返回508，错误信息中的地址已去除查询参数的取值
+func respondRedirectError(c *gin.Context, err *utils.RedirectError) {
+	fmt.Printf("上游重定向中止 %s: %v\n", c.Request.URL.Path, err)
+	if err.Loop {
+		utils.RespondProxyError(c, http.StatusLoopDetected, utils.ErrCodeRedirectLoop, err.URL)
+		return
+	}
+	utils.RespondProxyError(c, http.StatusLoopDetected, utils.ErrCodeTooManyRedirects)
+}
@@ -0,0 +1,63 @@
+package githubproxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// screeningRepo 返回链接所属的owner/repo(已解码百分号编码并转为小写)，无法确定时返回空串
+func screeningRepo(match *Match) string {
+	if len(match.captures) < 2 {
+		return ""
+	}
+	return accesscontrol.FoldRepoName(match.captures[0]) + "/" + strings.TrimSuffix(accesscontrol.FoldRepoName(match.captures[1]), ".git")
+}
+
+// screenGitHubRequest 转发前按屏蔽列表、手动标记、命中哈希的链接和新仓库预算检查请求，
+// 拒绝时返回false；通过时返回的函数须在转发结束后调用，用于累计新仓库的下载量和检查内容哈希
+func (p *Proxy) screenGitHubRequest(c *gin.Context, match *Match, target string) (func(), bool) {
+	if !p.shared.Screener.Enabled() {
+		return func() {}, true
+	}
+	repo := screeningRepo(match)
+	client := p.shared.IPs.Client(c)
+
+	rule := p.shared.Screener.CheckURL(target)
+	if rule == "" && repo != "" {
+		rule = p.shared.Screener.Check(repo)
+	}
+	if rule != "" {
+		p.shared.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionBlock, Target: target, Rule: rule, ClientIP: client})
+		utils.RespondProxyError(c, http.StatusForbidden, utils.ErrCodeContentScreened)
+		return nil, false
+	}
+	if rule = p.shared.Screener.CheckNewRepo(repo, time.Now()); rule != "" {
+		p.shared.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionThrottle, Target: repo, Rule: rule, ClientIP: client})
+		utils.SetRetryAfter(c, p.shared.Screener.NewRepoRetryAfter(repo, time.Now()))
+		utils.RespondProxyError(c, http.StatusTooManyRequests, utils.ErrCodeNewRepoBudget)
+		return nil, false
+	}
+
+	// 范围请求只有部分内容，哈希无意义
+	var writer *utils.HashingResponseWriter
+	if c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" && p.shared.Screener.HasHashRules() {
+		writer = utils.NewHashingResponseWriter(c.Writer)
+		c.Writer = writer
+	}
+	return func() {
+		p.shared.Screener.AddRepoBytes(repo, int64(c.Writer.Size()))
+		if writer == nil || c.Writer.Status() != http.StatusOK {
+			return
+		}
+		// 内容已经发出，之后对该链接和所属仓库的请求在转发前拒绝
+		if rule := p.shared.Screener.RecordContentHash(target, repo, writer.Sum()); rule != "" {
+			p.shared.Screener.Audit(utils.ScreeningEvent{Action: utils.ScreeningActionHashHit, Target: target, Rule: rule, ClientIP: client})
+			utils.PurgeScreenedTarget(p.cache, p.hot, repo)
+		}
+	}, true
+}
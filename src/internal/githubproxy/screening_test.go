@@ -0,0 +1,74 @@
+package githubproxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const screeningTestConfig = `
+[security.screening]
+enabled = true
+`
+
+// screenTestRequest 对链接执行转发前的筛查，通过时写出body并执行转发后的检查
+func screenTestRequest(t *testing.T, p *Proxy, target string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/"+target, nil)
+	match := MatchURL(target)
+	if match == nil {
+		t.Fatalf("%s not matched", target)
+	}
+	finish, ok := p.screenGitHubRequest(c, match, target)
+	if !ok {
+		return w
+	}
+	c.Status(http.StatusOK)
+	c.Writer.Write(body)
+	finish()
+	return w
+}
+
+func TestScreeningBlocksRepoAfterHashMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, screeningTestConfig)
+	p := newTestProxy()
+
+	payload := []byte("malicious payload")
+	p.shared.Screener.SetFeed(&utils.ScreeningFeed{
+		Repos:  map[string]bool{"known/bad": true},
+		Hashes: map[string]bool{fmt.Sprintf("sha256:%x", sha256.Sum256(payload)): true},
+	})
+
+	if w := screenTestRequest(t, p, "https://github.com/known/bad/releases/download/v1/a.zip", nil); w.Code != http.StatusForbidden ||
+		!strings.Contains(w.Body.String(), utils.ErrCodeContentScreened) {
+		t.Fatalf("feed repo status %d: %s", w.Code, w.Body.String())
+	}
+
+	// 第一次下载时内容已经发出，命中哈希后该链接和所属仓库随即被屏蔽
+	first := "https://github.com/evil/dropper/releases/download/v1/setup.exe"
+	if w := screenTestRequest(t, p, first, payload); w.Code != http.StatusOK {
+		t.Fatalf("first download status %d", w.Code)
+	}
+	for _, target := range []string{first, "https://raw.githubusercontent.com/evil/dropper/main/install.sh"} {
+		if w := screenTestRequest(t, p, target, nil); w.Code != http.StatusForbidden {
+			t.Fatalf("%s status %d", target, w.Code)
+		}
+	}
+
+	status := p.shared.Screener.Status()
+	if len(status.Recent) != 4 || status.Recent[2].Action != utils.ScreeningActionHashHit || !strings.HasPrefix(status.Recent[2].Rule, "feed:sha256:") {
+		t.Fatalf("audit = %+v", status.Recent)
+	}
+	if w := screenTestRequest(t, p, "https://github.com/other/repo/releases/download/v1/a.zip", []byte("clean")); w.Code != http.StatusOK {
+		t.Fatalf("clean repo status %d", w.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package githubproxy
+
+import (
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// sizeLimitKey 请求上下文中记录文件大小上限的key，代理内部跟随重定向时沿用首次计算的结果
+const sizeLimitKey = "file_size_limit"
+
+// githubSizeTarget 返回GitHub或Hugging Face地址的类别和owner/repo，无法识别时归为github类
+func githubSizeTarget(u string) (string, string) {
+	class := utils.SizeClassGitHub
+	if strings.Contains(u, "huggingface.co/") || strings.Contains(u, "hf.co/") {
+		class = utils.SizeClassHuggingFace
+	}
+	matches := CheckURL(u)
+	if len(matches) < 2 {
+		return class, ""
+	}
+	repo, _, _ := strings.Cut(matches[1], "/")
+	return class, matches[0] + "/" + strings.TrimSuffix(repo, ".git")
+}
+
+// SizeLimit 按cfg返回GitHub代理请求的文件大小上限，0为不限制
+func SizeLimit(c *gin.Context, u string, cfg *config.AppConfig) int64 {
+	if limit, exists := c.Get(sizeLimitKey); exists {
+		return limit.(int64)
+	}
+	class, target := githubSizeTarget(u)
+	limit := utils.SizeLimit(cfg, class, target, c.GetString(utils.AuthUserKey))
+	c.Set(sizeLimitKey, limit)
+	return limit
+}
@@ -0,0 +1,65 @@
+package githubproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGitHubSizeTarget(t *testing.T) {
+	tests := []struct {
+		url, class, target string
+	}{
+		{"https://github.com/torvalds/linux/archive/refs/tags/v6.0.tar.gz", utils.SizeClassGitHub, "torvalds/linux"},
+		{"https://github.com/owner/repo.git/info/refs", utils.SizeClassGitHub, "owner/repo"},
+		{"https://huggingface.co/meta-llama/Llama-3/resolve/main/model.safetensors", utils.SizeClassHuggingFace, "meta-llama/Llama-3"},
+		{"https://objects.githubusercontent.com/release-assets/1", utils.SizeClassGitHub, ""},
+	}
+	for _, tt := range tests {
+		if class, target := githubSizeTarget(tt.url); class != tt.class || target != tt.target {
+			t.Errorf("githubSizeTarget(%s) = %s %s, want %s %s", tt.url, class, target, tt.class, tt.target)
+		}
+	}
+}
+
+func TestGitHubProxyFileSizeLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[limits]\ngithub = 2097152\n\n[limits.multipliers]\nci = 2\n")
+	p := newTestProxy()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(3*1024*1024))
+		}
+		w.Write(make([]byte, 3*1024*1024))
+	}))
+	defer upstream.Close()
+
+	proxy := func(user, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/x", nil)
+		if user != "" {
+			c.Set(utils.AuthUserKey, user)
+		}
+		p.proxyGitHubWithRedirect(c, upstream.URL+"/owner/repo/releases/download/v1/app.bin"+query, 0)
+		return w
+	}
+
+	if w := proxy("", ""); w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "2 MB") {
+		t.Fatalf("status %d, body %.64q", w.Code, w.Body.String())
+	}
+	if w := proxy("ci", ""); w.Code != http.StatusOK || w.Body.Len() != 3*1024*1024 {
+		t.Fatalf("multiplied limit: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	// 未声明长度的响应在转发到上限时中断
+	if w := proxy("", "?chunked=1"); w.Body.Len() != 2*1024*1024 {
+		t.Fatalf("chunked response forwarded %d bytes", w.Body.Len())
+	}
+}
@@ -0,0 +1,651 @@
+// Package ratelimit 按客户端IP、共享网络和路径类别限流，并检查IP黑名单和基础设施放行名单
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const (
+	CleanupInterval = 20 * time.Minute
+	MaxIPCacheSize  = 10000
+
+	// evictionGrace 最近该时间内访问过的限流器不会因超出容量被淘汰
+	evictionGrace = 5 * time.Minute
+	// evictionMinInterval 新建条目触发淘汰的最小间隔，避免攻击期间每个请求都排序整个表
+	evictionMinInterval = 10 * time.Second
+)
+
+// Scaler 提供作用于普通IP限额的自适应倍数，如 utils.AdaptiveLimit
+type Scaler interface {
+	Factor() float64
+}
+
+// Limiter IP限流器，计数和限流表都属于实例本身，由Server创建并注入中间件
+type Limiter struct {
+	config         *config.Store
+	ips            map[string]*rateLimiterEntry
+	mu             *sync.RWMutex
+	limits         atomic.Pointer[limits]
+	crawlerLimiter *Limiter                      // 爬虫使用的独立限流器
+	crawlerPattern atomic.Pointer[regexp.Regexp] // 爬虫UA匹配规则，nil表示未配置任何规则
+	maxEntries     int                           // 限流表容量上限，修改时需持有写锁
+	lastEviction   time.Time
+	pathRules      atomic.Pointer[pathRules]
+	sharedNetworks atomic.Pointer[sharedNetworks]
+	adaptive       Scaler
+	identity       *utils.IPIdentifier  // 生成限流表key和日志中的客户端标识
+	stats          *utils.StatsRegistry // 记录爬虫拦截计数
+
+	whitelistBypassed atomic.Int64
+	whitelistLimited  atomic.Int64
+	evicted           atomic.Int64
+	infraBypassed     atomic.Int64
+	// sharedStats 按网络名称保存的共享网络计数，配置重载后保留
+	sharedStats sync.Map
+}
+
+// limits 默认限额、黑白名单和基础设施放行规则，热重载时整体替换
+type limits struct {
+	r                rate.Limit
+	b                int
+	whitelist        []*net.IPNet
+	blacklist        []*net.IPNet
+	whitelistLimiter *rate.Limiter   // 全局共享的白名单限流器，未配置白名单限流时使用
+	whitelistRate    rate.Limit      // 白名单IP的独立限流速率
+	whitelistBurst   int             // 为0时白名单IP不限流
+	infraAllow       []*net.IPNet    // 访问基础设施路径时优先于黑名单放行的监控来源
+	infraPaths       map[string]bool // 适用infraAllow的路径，如 /metrics、/ready
+}
+
+// newLimits 创建每周期允许requestLimit个请求、不含黑白名单的限额
+func newLimits(requestLimit int, periodHours float64) *limits {
+	return &limits{
+		r:                rate.Limit(float64(requestLimit) / (periodHours * 3600)),
+		b:                requestLimit,
+		whitelistLimiter: rate.NewLimiter(rate.Inf, requestLimit),
+	}
+}
+
+// compileLimits 按配置编译默认限额、白名单限额、黑白名单和基础设施放行规则
+func compileLimits(cfg *config.AppConfig) *limits {
+	l := newLimits(cfg.RateLimit.RequestLimit, cfg.RateLimit.PeriodHours)
+	l.whitelist = parseCIDRList(cfg.Security.WhiteList, "白名单")
+	l.blacklist = parseCIDRList(cfg.Security.BlackList, "黑名单")
+	l.infraAllow = parseCIDRList(cfg.Security.InfraAllowList, "基础设施放行")
+	l.infraPaths = make(map[string]bool, len(cfg.Security.InfraPaths))
+	for _, path := range cfg.Security.InfraPaths {
+		if path = strings.TrimSpace(path); path != "" {
+			l.infraPaths["/"+strings.TrimLeft(path, "/")] = true
+		}
+	}
+	if rl := cfg.RateLimit; rl.WhitelistRequestLimit > 0 && rl.WhitelistPeriodHours > 0 {
+		l.whitelistRate = rate.Limit(float64(rl.WhitelistRequestLimit) / (rl.WhitelistPeriodHours * 3600))
+		l.whitelistBurst = rl.WhitelistRequestLimit
+	}
+	return l
+}
+
+// whitelistKeyPrefix 白名单IP在限流表中的key前缀，与普通IP的桶互不影响
+const whitelistKeyPrefix = "whitelist:"
+
+// authKeyPrefix 启用自适应限流时已认证请求在限流表中的key前缀，不受自适应倍数影响
+const authKeyPrefix = "auth:"
+
+// rateLimiterKey 限流中间件放行后写入上下文的限流器，供查询调用方剩余额度
+const rateLimiterKey = "rate_limiter"
+
+// Stats 白名单限流、基础设施放行和限流表淘汰计数，以及限流表当前的条目数和容量
+type Stats struct {
+	WhitelistBypassed int64 `json:"whitelist_bypassed"`
+	WhitelistLimited  int64 `json:"whitelist_limited"`
+	InfraBypassed     int64 `json:"infra_bypassed"`
+	Evicted           int64 `json:"evicted"`
+	Entries           int   `json:"entries"`
+	CrawlerEntries    int   `json:"crawler_entries"`
+	MaxEntries        int   `json:"max_entries"`
+
+	SharedNetworks map[string]SharedNetworkStats `json:"shared_networks,omitempty"`
+}
+
+// Stats 获取白名单放行、被限流的请求数、因容量淘汰的限流器数、限流表占用和各共享网络的计数
+func (i *Limiter) Stats() Stats {
+	stats := Stats{
+		WhitelistBypassed: i.whitelistBypassed.Load(),
+		WhitelistLimited:  i.whitelistLimited.Load(),
+		InfraBypassed:     i.infraBypassed.Load(),
+		Evicted:           i.evicted.Load(),
+		SharedNetworks:    i.SharedNetworkStats(),
+	}
+	stats.Entries, stats.MaxEntries = i.occupancy()
+	if i.crawlerLimiter != nil {
+		stats.Evicted += i.crawlerLimiter.evicted.Load()
+		stats.CrawlerEntries = i.crawlerLimiter.size()
+	}
+	return stats
+}
+
+// Status 调用方所在限流桶的状态，Unlimited为true时其余字段无意义
+type Status struct {
+	Unlimited    bool `json:"unlimited"`
+	Limit        int  `json:"limit"`
+	Remaining    int  `json:"remaining"`
+	ResetSeconds int  `json:"reset_seconds"` // 桶回满所需的秒数
+}
+
+// CallerStatus 返回当前请求所在限流桶放行本次请求后的状态，请求未经过限流中间件时返回nil
+func CallerStatus(c *gin.Context) *Status {
+	value, exists := c.Get(rateLimiterKey)
+	if !exists {
+		return nil
+	}
+	return bucketStatus(value.(*rate.Limiter))
+}
+
+// bucketStatus 返回限流桶当前的额度状态
+func bucketStatus(limiter *rate.Limiter) *Status {
+	if limiter.Limit() == rate.Inf {
+		return &Status{Unlimited: true}
+	}
+
+	tokens := limiter.Tokens()
+	status := &Status{Limit: limiter.Burst(), Remaining: max(int(tokens), 0)}
+	if missing := float64(limiter.Burst()) - tokens; missing > 0 && limiter.Limit() > 0 {
+		status.ResetSeconds = int(math.Ceil(missing / float64(limiter.Limit())))
+	}
+	return status
+}
+
+// setRateLimitHeaders 限流中间件放行后附加 RateLimit-Limit、RateLimit-Remaining 和 RateLimit-Reset 头，
+// 值与 /api/capabilities 的rate_limit一致，不限流的桶不附加
+func setRateLimitHeaders(c *gin.Context, limiter *rate.Limiter) {
+	status := bucketStatus(limiter)
+	if status.Unlimited {
+		return
+	}
+	header := c.Writer.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(status.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(status.ResetSeconds))
+}
+
+// size 返回限流表当前的条目数
+func (i *Limiter) size() int {
+	entries, _ := i.occupancy()
+	return entries
+}
+
+// occupancy 返回限流表当前的条目数和容量上限
+func (i *Limiter) occupancy() (int, int) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.ips), i.maxEntries
+}
+
+// rateLimiterEntry 限流器条目
+type rateLimiterEntry struct {
+	limiter    *rate.Limiter
+	lastAccess time.Time
+	scale      float64 // 当前生效的自适应限流倍数
+}
+
+// New 按store中的配置创建限流器，adaptive为nil时普通IP的限额不随负载缩放；
+// identity为服务共用的客户端标识生成器，为nil时使用独立的盐值；爬虫拦截计入stats，为nil时使用独立的统计
+func New(store *config.Store, adaptive Scaler, identity *utils.IPIdentifier, stats *utils.StatsRegistry) *Limiter {
+	cfg := store.Get()
+
+	limiter := newLimiter(store, cfg.RateLimit.RequestLimit, cfg.RateLimit.PeriodHours)
+	limiter.limits.Store(compileLimits(cfg))
+	limiter.maxEntries = maxEntries(cfg)
+	limiter.adaptive = adaptive
+	if identity != nil {
+		limiter.identity = identity
+	}
+	limiter.stats = stats
+	if stats == nil {
+		limiter.stats = utils.NewStatsRegistry()
+	}
+	limiter.crawlerPattern.Store(utils.CompileCrawlerPatterns(cfg.Security.Crawlers.Patterns))
+	limiter.crawlerLimiter = newLimiter(store, cfg.Security.Crawlers.RequestLimit, cfg.Security.Crawlers.PeriodHours)
+	limiter.crawlerLimiter.maxEntries = limiter.maxEntries
+	limiter.crawlerLimiter.identity = limiter.identity
+	limiter.reloadPathRules(cfg)
+	limiter.reloadSharedNetworks(cfg)
+	return limiter
+}
+
+// isCrawler 判断User-Agent是否属于已知爬虫
+func (i *Limiter) isCrawler(userAgent string) bool {
+	pattern := i.crawlerPattern.Load()
+	return pattern != nil && userAgent != "" && pattern.MatchString(userAgent)
+}
+
+// maxEntries 返回配置的限流表容量，未配置时为MaxIPCacheSize
+func maxEntries(cfg *config.AppConfig) int {
+	if cfg.RateLimit.MaxEntries > 0 {
+		return cfg.RateLimit.MaxEntries
+	}
+	return MaxIPCacheSize
+}
+
+// ReloadSections 限额和黑白名单位于rateLimit和security段，路径规则受ui.enabled影响，
+// 共享网络的转发链按server.trustedProxies验证
+func (i *Limiter) ReloadSections() []string {
+	return []string{"rateLimit", "security", "server", "ui"}
+}
+
+// Reload 按新配置替换限额、黑白名单、路径规则和共享网络。
+// 默认、白名单、类别或共享网络的限额变化时清空限流表，各客户端按新限额重新计数
+func (i *Limiter) Reload(old, updated *config.AppConfig) {
+	i.reloadLimits(compileLimits(updated), maxEntries(updated), bucketLimitsChanged(old, updated))
+
+	i.crawlerPattern.Store(utils.CompileCrawlerPatterns(updated.Security.Crawlers.Patterns))
+	if i.crawlerLimiter != nil {
+		crawlers, oldCrawlers := updated.Security.Crawlers, old.Security.Crawlers
+		i.crawlerLimiter.reloadLimits(newLimits(crawlers.RequestLimit, crawlers.PeriodHours), maxEntries(updated),
+			crawlers.RequestLimit != oldCrawlers.RequestLimit || crawlers.PeriodHours != oldCrawlers.PeriodHours)
+	}
+
+	i.reloadPathRules(updated)
+	i.reloadSharedNetworks(updated)
+}
+
+// reloadLimits 在写锁内替换限额和容量上限，reset为true时清空限流表
+func (i *Limiter) reloadLimits(l *limits, capacity int, reset bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.limits.Store(l)
+	i.maxEntries = capacity
+	if reset {
+		clear(i.ips)
+	}
+	i.evictLocked(time.Now())
+}
+
+// bucketLimitsChanged 判断已创建的限流桶使用的限额是否变化
+func bucketLimitsChanged(old, updated *config.AppConfig) bool {
+	a, b := old.RateLimit, updated.RateLimit
+	return a.RequestLimit != b.RequestLimit || a.PeriodHours != b.PeriodHours ||
+		a.WhitelistRequestLimit != b.WhitelistRequestLimit || a.WhitelistPeriodHours != b.WhitelistPeriodHours ||
+		!reflect.DeepEqual(a.Classes, b.Classes) || !reflect.DeepEqual(a.SharedNetworks, b.SharedNetworks)
+}
+
+// factor 返回当前的自适应限流倍数
+func (i *Limiter) factor() float64 {
+	if i.adaptive == nil {
+		return 1
+	}
+	return i.adaptive.Factor()
+}
+
+// parseCIDRList 解析IP或CIDR列表，单个IP按/32处理，无效项打印警告后跳过
+func parseCIDRList(items []string, label string) []*net.IPNet {
+	list := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			if !strings.Contains(item, "/") {
+				item = item + "/32"
+			}
+			_, ipnet, err := net.ParseCIDR(item)
+			if err == nil {
+				list = append(list, ipnet)
+			} else {
+				fmt.Printf("警告: 无效的%sIP格式: %s\n", label, item)
+			}
+		}
+	}
+	return list
+}
+
+// newLimiter 创建按IP限流的限流器，每周期允许requestLimit个请求，保留时间等设置从store读取
+func newLimiter(store *config.Store, requestLimit int, periodHours float64) *Limiter {
+	limiter := &Limiter{
+		config:     store,
+		ips:        make(map[string]*rateLimiterEntry),
+		mu:         &sync.RWMutex{},
+		maxEntries: MaxIPCacheSize,
+		identity:   utils.NewIPIdentifier(store),
+	}
+	limiter.limits.Store(newLimits(requestLimit, periodHours))
+
+	go limiter.cleanupRoutine()
+
+	return limiter
+}
+
+// cleanupRoutine 定期清理过期的限流器
+func (i *Limiter) cleanupRoutine() {
+	ticker := time.NewTicker(CleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		i.cleanup(time.Now())
+	}
+}
+
+// cleanup 删除超过保留时间未访问的限流器，仍超出容量时按最近访问时间淘汰
+func (i *Limiter) cleanup(now time.Time) {
+	retention := utils.PerIPRetention(i.config.Get(), 2*time.Hour)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for key, entry := range i.ips {
+		if now.Sub(entry.lastAccess) > retention {
+			delete(i.ips, key)
+		}
+	}
+	i.evictLocked(now)
+}
+
+// evictLocked 超出容量时淘汰最久未访问的限流器，直到降到容量的90%。
+// 最近evictionGrace内访问过的限流器不会被淘汰，避免攻击期间活跃用户的限额被重置。调用方需持有写锁
+func (i *Limiter) evictLocked(now time.Time) {
+	if len(i.ips) <= i.maxEntries {
+		return
+	}
+	i.lastEviction = now
+	highWater := i.maxEntries * 9 / 10
+
+	type candidate struct {
+		key        string
+		lastAccess time.Time
+	}
+	candidates := make([]candidate, 0, len(i.ips))
+	for key, entry := range i.ips {
+		if now.Sub(entry.lastAccess) > evictionGrace {
+			candidates = append(candidates, candidate{key, entry.lastAccess})
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].lastAccess.Before(candidates[b].lastAccess)
+	})
+
+	evicted := 0
+	for _, c := range candidates {
+		if len(i.ips) <= highWater {
+			break
+		}
+		delete(i.ips, c.key)
+		evicted++
+	}
+	if evicted > 0 {
+		i.evicted.Add(int64(evicted))
+		fmt.Printf("限流表超出容量，已淘汰 %d 个最久未访问的条目，剩余 %d\n", evicted, len(i.ips))
+	}
+}
+
+// normalizeIPForRateLimit 标准化IP地址用于限流，IPv6按/64网段合并
+func normalizeIPForRateLimit(ipStr string) string {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return ipStr
+	}
+	addr = addr.WithZone("").Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
+
+// GetLimiter 获取指定IP的限流器
+func (i *Limiter) GetLimiter(ip string) (*rate.Limiter, bool) {
+	return i.limiterFor(ip, false, nil)
+}
+
+// limiterFor 获取指定IP的限流器，普通IP的限额按自适应限流倍数缩放，
+// 启用自适应限流时已认证请求使用独立的不缩放的桶。shared不为nil时改用共享网络的桶，白名单仍然优先
+func (i *Limiter) limiterFor(ip string, authenticated bool, shared *sharedCaller) (*rate.Limiter, bool) {
+	cleanIP := utils.ExtractIPFromAddress(ip)
+	l := i.limits.Load()
+
+	if utils.IPInCIDRList(cleanIP, l.blacklist) {
+		return nil, false
+	}
+
+	whitelisted := utils.IPInCIDRList(cleanIP, l.whitelist)
+	if whitelisted && l.whitelistBurst == 0 {
+		return l.whitelistLimiter, true
+	}
+
+	// 启用noIPLogging时限流表以进程内密钥下的IP摘要为key，不保存原始IP，计数也不随记录用盐值的轮换重置
+	key := i.identity.LimiterKey(normalizeIPForRateLimit(cleanIP))
+	if whitelisted {
+		return i.entryLimiter(whitelistKeyPrefix+key, l.whitelistRate, l.whitelistBurst, 1), true
+	}
+	if shared != nil {
+		i.countSharedRequest(shared)
+		scale := i.factor()
+		if authenticated && i.config.Get().RateLimit.AdaptiveEnabled {
+			scale = 1
+		}
+		return i.entryLimiter(shared.key, l.r*rate.Limit(shared.scale), int(float64(l.b)*shared.scale), scale), true
+	}
+	if authenticated && i.config.Get().RateLimit.AdaptiveEnabled {
+		return i.entryLimiter(authKeyPrefix+key, l.r, l.b, 1), true
+	}
+
+	return i.entryLimiter(key, l.r, l.b, i.factor()), true
+}
+
+// entryLimiter 获取或创建key对应的限流器，并刷新最近访问时间，scale变化时按倍数调整已有限流器的速率和容量
+func (i *Limiter) entryLimiter(key string, r rate.Limit, b int, scale float64) *rate.Limiter {
+	now := time.Now()
+
+	i.mu.RLock()
+	_, exists := i.ips[key]
+	i.mu.RUnlock()
+
+	if exists {
+		i.mu.Lock()
+		if entry, stillExists := i.ips[key]; stillExists {
+			entry.lastAccess = now
+			entry.rescale(r, b, scale)
+			i.mu.Unlock()
+			return entry.limiter
+		}
+		i.mu.Unlock()
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if entry, exists := i.ips[key]; exists {
+		entry.lastAccess = now
+		entry.rescale(r, b, scale)
+		return entry.limiter
+	}
+
+	entry := &rateLimiterEntry{
+		limiter:    rate.NewLimiter(r, b),
+		lastAccess: now,
+		scale:      1,
+	}
+	entry.rescale(r, b, scale)
+	i.ips[key] = entry
+	if len(i.ips) > i.maxEntries && now.Sub(i.lastEviction) > evictionMinInterval {
+		i.evictLocked(now)
+	}
+	return entry.limiter
+}
+
+// rescale 按倍数调整限流器的速率和容量，容量至少为1；缩小容量后多余的令牌在下次取用时丢弃。调用方需持有写锁
+func (e *rateLimiterEntry) rescale(r rate.Limit, b int, scale float64) {
+	if scale == e.scale {
+		return
+	}
+	e.scale = scale
+	e.limiter.SetLimit(r * rate.Limit(scale))
+	e.limiter.SetBurst(max(int(float64(b)*scale), 1))
+}
+
+// infraBypass 判断是否为来自infraAllowList的基础设施路径请求，这类请求不受黑名单和限流影响。
+// ip须为按受信任代理验证过的地址，客户端自行填写的X-Forwarded-For不能借此绕过黑名单
+func (i *Limiter) infraBypass(path, ip string) bool {
+	l := i.limits.Load()
+	return len(l.infraAllow) > 0 && l.infraPaths[path] && utils.IPInCIDRList(ip, l.infraAllow)
+}
+
+// verifiedClientIP 按server.trustedProxies确定可信的客户端IP，规则同共享网络的判断
+func (i *Limiter) verifiedClientIP(r *http.Request) string {
+	if networks := i.sharedNetworks.Load(); networks != nil {
+		return networks.verifiedClientIP(r)
+	}
+	return utils.ExtractIPFromAddress(r.RemoteAddr)
+}
+
+// blocked 判断IP是否命中黑名单
+func (i *Limiter) blocked(ip string) bool {
+	return utils.IPInCIDRList(utils.ExtractIPFromAddress(ip), i.limits.Load().blacklist)
+}
+
+// BlacklistMiddleware 只检查IP黑名单，不计入限流，用于自带限流器的管理端口
+func BlacklistMiddleware(limiter *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter.blocked(requestIP(c)) {
+			utils.RespondError(c, 403, utils.ErrCodeIPBlocked)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestIP 按转发头取请求的客户端地址，用于黑名单和限流计数
+func requestIP(c *gin.Context) string {
+	if forwarded := c.GetHeader("X-Forwarded-For"); forwarded != "" {
+		ips := strings.Split(forwarded, ",")
+		return strings.TrimSpace(ips[0])
+	} else if realIP := c.GetHeader("X-Real-IP"); realIP != "" {
+		return realIP
+	} else if remoteIP := c.GetHeader("X-Original-Forwarded-For"); remoteIP != "" {
+		ips := strings.Split(remoteIP, ",")
+		return strings.TrimSpace(ips[0])
+	}
+	return c.ClientIP()
+}
+
+// Middleware 速率限制中间件，/admin 下的请求只检查黑名单，限流由管理接口自己的限流器控制，
+// rateLimit.exemptPaths 中的路径不限流，rateLimit.pathClasses 中的路径使用对应类别的限额，
+// 来自 rateLimit.sharedNetworks 的请求按共享桶或用户子key计数。
+// 判定顺序: 基础设施放行(infraAllowList，仅infraPaths) > 黑名单 > 白名单 > 限流
+func Middleware(limiter *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			if limiter.blocked(requestIP(c)) {
+				utils.RespondError(c, 403, utils.ErrCodeIPBlocked)
+				return
+			}
+			c.Next()
+			return
+		}
+		rule, matched := limiter.matchPath(path)
+		if matched && rule.class == nil {
+			c.Next()
+			return
+		}
+
+		ip := requestIP(c)
+		cleanIP := utils.ExtractIPFromAddress(ip)
+
+		normalizedIP := normalizeIPForRateLimit(cleanIP)
+		// 同一客户端的请求IP日志按log.dedupWindow限速，避免高频请求刷屏
+		if limiter.identity.LoggingDisabled() {
+			utils.Logf(utils.LogDebug, normalizedIP, "请求IP: %s", limiter.identity.Identify(normalizedIP, false))
+		} else if cleanIP != normalizedIP {
+			utils.Logf(utils.LogDebug, cleanIP, "请求IP: %s (提纯后: %s, 限流段: %s), X-Forwarded-For: %s, X-Real-IP: %s",
+				ip, cleanIP, normalizedIP,
+				c.GetHeader("X-Forwarded-For"),
+				c.GetHeader("X-Real-IP"))
+		} else {
+			utils.Logf(utils.LogDebug, cleanIP, "请求IP: %s (提纯后: %s), X-Forwarded-For: %s, X-Real-IP: %s",
+				ip, cleanIP,
+				c.GetHeader("X-Forwarded-For"),
+				c.GetHeader("X-Real-IP"))
+		}
+
+		if verifiedIP := limiter.verifiedClientIP(c.Request); limiter.infraBypass(path, verifiedIP) {
+			limiter.infraBypassed.Add(1)
+			utils.Logf(utils.LogInfo, verifiedIP+" "+path, "基础设施放行: %s %s 命中 security.infraAllowList，跳过黑名单和限流", limiter.identity.Identify(verifiedIP, false), path)
+			c.Next()
+			return
+		}
+
+		shared := limiter.sharedCaller(c, cleanIP)
+		ipLimiter, allowed := limiter.limiterFor(cleanIP, c.GetString(utils.AuthUserKey) != "", shared)
+
+		if !allowed {
+			utils.RespondError(c, 403, utils.ErrCodeIPBlocked)
+			return
+		}
+
+		l := limiter.limits.Load()
+		whitelisted := utils.IPInCIDRList(cleanIP, l.whitelist)
+
+		// 已知爬虫使用更严格的独立限流或直接拒绝，白名单IP不受影响
+		if crawlers := limiter.config.Get().Security.Crawlers; crawlers.Enabled && limiter.crawlerLimiter != nil &&
+			!whitelisted && limiter.isCrawler(c.GetHeader("User-Agent")) {
+			if crawlers.Action == utils.CrawlerActionBlock {
+				limiter.stats.RecordCrawlerBlocked()
+				utils.RespondError(c, 403, utils.ErrCodeCrawlerBlocked)
+				return
+			}
+			crawlerLimiter, _ := limiter.crawlerLimiter.GetLimiter(cleanIP)
+			if !crawlerLimiter.Allow() {
+				limiter.stats.RecordCrawlerLimited()
+				utils.SetRetryAfter(c, utils.BucketRetryAfter(crawlerLimiter, 1))
+				utils.RespondError(c, 429, utils.ErrCodeRateLimited)
+				return
+			}
+			c.Set(rateLimiterKey, crawlerLimiter)
+			utils.AddBucketQuotaWarning(c, crawlerLimiter)
+			setRateLimitHeaders(c, crawlerLimiter)
+			c.Next()
+			return
+		}
+
+		// 命中类别规则的路径使用类别的独立限额，白名单IP仍按白名单处理
+		if matched && !whitelisted {
+			ipLimiter = limiter.classLimiter(rule.class, cleanIP, shared)
+		}
+
+		// 要求绕过缓存的请求会穿透到上游，按更高的次数计入限流
+		cost := 1
+		if utils.CacheBypassRequested(limiter.config.Get(), c.Request) && ipLimiter.Burst() >= utils.CacheBypassCost {
+			cost = utils.CacheBypassCost
+		}
+		if !ipLimiter.AllowN(time.Now(), cost) {
+			if whitelisted {
+				limiter.whitelistLimited.Add(1)
+			} else if shared != nil {
+				limiter.sharedCounters(shared.network).limited.Add(1)
+			}
+			utils.SetRetryAfter(c, utils.BucketRetryAfter(ipLimiter, cost))
+			utils.RespondError(c, 429, utils.ErrCodeRateLimited)
+			return
+		}
+		if whitelisted && l.whitelistBurst == 0 {
+			limiter.whitelistBypassed.Add(1)
+		}
+
+		c.Set(rateLimiterKey, ipLimiter)
+		utils.AddBucketQuotaWarning(c, ipLimiter)
+		setRateLimitHeaders(c, ipLimiter)
+		c.Next()
+	}
+}
@@ -0,0 +1,694 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func loadTestConfig(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStore 返回持有当前全局配置的store，供测试构造限流器
+func testStore() *config.Store {
+	return config.NewStore(config.GetConfig())
+}
+
+func TestNormalizeIPv6ForRateLimit(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.168.1.2", "192.168.1.2"},
+		{"2001:db8::1", "2001:db8::/64"},
+		{"2001:db8:0:0:ffff::1", "2001:db8::/64"},
+		{"2001:db8:0:1::1", "2001:db8:0:1::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := normalizeIPForRateLimit(tt.ip); got != tt.want {
+			t.Errorf("normalizeIPForRateLimit(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	// 同一/64网段、IPv4映射地址和对应IPv4地址共享同一限流器
+	limiter := New(testStore(), nil, nil, nil)
+	for _, pair := range [][2]string{
+		{"[2001:db8::1]:1000", "[2001:db8::2%eth0]:2000"},
+		{"[::ffff:192.0.2.1]:1000", "192.0.2.1:2000"},
+	} {
+		first, _ := limiter.GetLimiter(pair[0])
+		second, _ := limiter.GetLimiter(pair[1])
+		if first != second {
+			t.Errorf("%s and %s use different limiters", pair[0], pair[1])
+		}
+	}
+}
+
+func whitelistTestRequest(router http.Handler) int {
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+	req.RemoteAddr = "203.0.113.9:40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func newWhitelistTestRouter(t *testing.T, configBody string) (http.Handler, *Limiter) {
+	t.Helper()
+	loadTestConfig(t, configBody)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	router.GET("/v2/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router, limiter
+}
+
+func TestWhitelistBypassUsesSharedLimiter(t *testing.T) {
+	router, limiter := newWhitelistTestRouter(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+
+[security]
+whiteList = ["203.0.113.0/24"]
+`)
+	before := limiter.Stats()
+	for range 5 {
+		if code := whitelistTestRequest(router); code != http.StatusOK {
+			t.Fatalf("whitelisted request status = %d, want 200", code)
+		}
+	}
+	after := limiter.Stats()
+	if after.WhitelistBypassed-before.WhitelistBypassed != 5 || after.WhitelistLimited != before.WhitelistLimited {
+		t.Fatalf("stats before %+v after %+v", before, after)
+	}
+
+	bare := newLimiter(testStore(), 1, 1)
+	bare.limits.Load().whitelist = limiter.limits.Load().whitelist
+	first, _ := bare.GetLimiter("203.0.113.9")
+	second, _ := bare.GetLimiter("203.0.113.10")
+	if first != second || first != bare.limits.Load().whitelistLimiter || len(bare.ips) != 0 {
+		t.Fatal("whitelisted IPs should share one unlimited limiter without map entries")
+	}
+}
+
+func TestWhitelistElevatedLimit(t *testing.T) {
+	router, limiter := newWhitelistTestRouter(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+whitelistRequestLimit = 3
+whitelistPeriodHours = 1
+
+[security]
+whiteList = ["203.0.113.0/24"]
+`)
+	before := limiter.Stats()
+	for n := range 3 {
+		if code := whitelistTestRequest(router); code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", n+1, code)
+		}
+	}
+	if code := whitelistTestRequest(router); code != http.StatusTooManyRequests {
+		t.Fatalf("request over whitelist limit status = %d, want 429", code)
+	}
+	after := limiter.Stats()
+	if after.WhitelistLimited-before.WhitelistLimited != 1 || after.WhitelistBypassed != before.WhitelistBypassed {
+		t.Fatalf("stats before %+v after %+v", before, after)
+	}
+}
+
+func TestLimiterHotReloadLimitsAndLists(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+`)
+	limiter := New(testStore(), nil, nil, nil)
+	reloader := config.NewReloader(limiter.config)
+	reloader.Register(limiter)
+
+	router := gin.New()
+	router.Use(Middleware(limiter))
+	router.GET("/x", func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/x", nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if request("192.0.2.10") != http.StatusOK || request("192.0.2.10") != http.StatusTooManyRequests {
+		t.Fatal("initial limit not applied")
+	}
+
+	if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte(`
+[rateLimit]
+requestLimit = 3
+periodHours = 1
+
+[security]
+blackList = ["192.0.2.66"]
+whiteList = ["192.0.2.77"]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 限额变化后限流表清空，已受限的客户端按新限额重新计数
+	for n := range 3 {
+		if code := request("192.0.2.10"); code != http.StatusOK {
+			t.Fatalf("request %d after reload: status %d", n+1, code)
+		}
+	}
+	if code := request("192.0.2.10"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over reloaded limit: status %d", code)
+	}
+	if code := request("192.0.2.66"); code != http.StatusForbidden {
+		t.Fatalf("reloaded blacklist: status %d", code)
+	}
+	for range 5 {
+		if code := request("192.0.2.77"); code != http.StatusOK {
+			t.Fatalf("reloaded whitelist: status %d", code)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	router, _ := newWhitelistTestRouter(t, `
+[rateLimit]
+requestLimit = 3
+periodHours = 1
+
+[security]
+whiteList = ["203.0.113.0/24"]
+`)
+	request := func(ip string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	header := request("192.0.2.30")
+	if header.Get("RateLimit-Limit") != "3" || header.Get("RateLimit-Remaining") != "2" {
+		t.Fatalf("headers = %v", header)
+	}
+	// 每小时3个请求，回满一个请求约1200秒
+	if reset, _ := strconv.Atoi(header.Get("RateLimit-Reset")); reset < 1100 || reset > 1200 {
+		t.Fatalf("RateLimit-Reset = %q", header.Get("RateLimit-Reset"))
+	}
+	if header = request("192.0.2.30"); header.Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("second request remaining = %q", header.Get("RateLimit-Remaining"))
+	}
+
+	// 不限流的白名单IP不附加
+	if header = request("203.0.113.9"); header.Get("RateLimit-Limit") != "" {
+		t.Fatalf("whitelisted headers = %v", header)
+	}
+}
+
+func TestInfraAllowListPrecedence(t *testing.T) {
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+
+[security]
+whiteList = ["198.51.100.0/24", "203.0.113.0/24"]
+blackList = ["203.0.113.0/24"]
+infraAllowList = ["203.0.113.5"]
+infraPaths = ["/metrics", "status"]
+`)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	for _, path := range []string{"/metrics", "/status", "/ready", "/v2/*path"} {
+		router.GET(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	request := func(ip, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	before := limiter.Stats()
+	tests := []struct {
+		name string
+		ip   string
+		path string
+		want int
+	}{
+		// 基础设施放行优先于黑名单和限流，只作用于infraPaths
+		{"infra allow", "203.0.113.5", "/metrics", http.StatusOK},
+		{"infra allow repeated", "203.0.113.5", "/metrics", http.StatusOK},
+		{"infra path without slash", "203.0.113.5", "/status", http.StatusOK},
+		{"not an infra path", "203.0.113.5", "/ready", http.StatusForbidden},
+		{"proxy traffic", "203.0.113.5", "/v2/library/nginx/manifests/latest", http.StatusForbidden},
+		// 黑名单优先于白名单
+		{"blacklist over whitelist", "203.0.113.6", "/metrics", http.StatusForbidden},
+		// 白名单优先于限流
+		{"whitelist", "198.51.100.7", "/metrics", http.StatusOK},
+		{"whitelist repeated", "198.51.100.7", "/metrics", http.StatusOK},
+		{"limiter", "192.0.2.1", "/metrics", http.StatusOK},
+		{"limiter exhausted", "192.0.2.1", "/metrics", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := request(tt.ip, tt.path); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := limiter.Stats().InfraBypassed - before.InfraBypassed; got != 3 {
+		t.Errorf("infra bypassed = %d, want 3", got)
+	}
+
+	// 被限流时按令牌桶回填一个请求的时间建议重试，每小时1个请求即约1小时
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "192.0.2.1:40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); w.Code != http.StatusTooManyRequests || retryAfter < 3500 || retryAfter > 3600 {
+		t.Errorf("limited: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestInfraAllowListRejectsSpoofedForwarding(t *testing.T) {
+	loadTestConfig(t, `
+[server]
+trustedProxies = ["10.0.0.0/8"]
+
+[security]
+blackList = ["203.0.113.0/24"]
+infraAllowList = ["203.0.113.5"]
+infraPaths = ["/metrics"]
+`)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	router.GET("/metrics", func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(remoteAddr, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	before := limiter.Stats().InfraBypassed
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"direct", "203.0.113.5:40000", "", http.StatusOK},
+		{"via trusted proxy", "10.0.0.2:40000", "203.0.113.5", http.StatusOK},
+		// 黑名单IP直连时伪造转发头冒充监控源，不放行
+		{"spoofed direct", "203.0.113.6:40000", "203.0.113.5", http.StatusForbidden},
+		// 经受信任代理转发时，客户端自行填写的X-Forwarded-For前缀同样不被采信
+		{"spoofed via trusted proxy", "10.0.0.2:40000", "203.0.113.5, 203.0.113.6", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := request(tt.remoteAddr, tt.forwarded); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := limiter.Stats().InfraBypassed - before; got != 2 {
+		t.Errorf("infra bypassed = %d, want 2", got)
+	}
+}
+
+func TestLimiterEvictionKeepsRecentBuckets(t *testing.T) {
+	loadTestConfig(t, "")
+	limiter := newLimiter(testStore(), 5, 1)
+	limiter.maxEntries = 100
+
+	active, _ := limiter.GetLimiter("198.51.100.1")
+	for range 5 {
+		active.Allow()
+	}
+
+	// 大量一次性IP涌入，模拟它们已有一段时间未访问
+	for n := range 1000 {
+		limiter.GetLimiter(fmt.Sprintf("10.%d.%d.1", n/256, n%256))
+	}
+	before := limiter.Stats().Evicted
+	now := time.Now()
+	limiter.mu.Lock()
+	for key, entry := range limiter.ips {
+		if key != "198.51.100.1" {
+			entry.lastAccess = now.Add(-time.Hour)
+		}
+	}
+	limiter.mu.Unlock()
+
+	limiter.cleanup(now)
+
+	limiter.mu.RLock()
+	size := len(limiter.ips)
+	_, survived := limiter.ips["198.51.100.1"]
+	limiter.mu.RUnlock()
+	if size > 90 || !survived {
+		t.Fatalf("size = %d, active survived = %v", size, survived)
+	}
+	if evicted := limiter.Stats().Evicted - before; evicted == 0 {
+		t.Fatal("evictions not counted")
+	}
+
+	same, _ := limiter.GetLimiter("198.51.100.1")
+	if same != active || same.Allow() {
+		t.Fatal("active bucket was reset")
+	}
+}
+
+func TestLimiterEvictionSparesRecentlyTouched(t *testing.T) {
+	loadTestConfig(t, "")
+	limiter := newLimiter(testStore(), 5, 1)
+	limiter.maxEntries = 10
+
+	for n := range 50 {
+		limiter.GetLimiter(fmt.Sprintf("10.0.0.%d", n))
+	}
+	limiter.cleanup(time.Now())
+
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	if len(limiter.ips) != 50 {
+		t.Fatalf("recently touched entries evicted, %d left", len(limiter.ips))
+	}
+}
+
+func crawlerRequest(router http.Handler, ua string) int {
+	req := httptest.NewRequest(http.MethodGet, "/https://github.com/o/r", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	req.Header.Set("User-Agent", ua)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func newCrawlerTestRouter(t *testing.T, body string) (*gin.Engine, *utils.StatsRegistry) {
+	t.Helper()
+	loadTestConfig(t, body)
+	stats := utils.NewStatsRegistry()
+	limiter := New(testStore(), nil, nil, stats)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(limiter))
+	router.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router, stats
+}
+
+func TestRateLimitMiddlewareCrawlers(t *testing.T) {
+	router, stats := newCrawlerTestRouter(t, `
+[security.crawlers]
+enabled = true
+action = "limit"
+requestLimit = 2
+periodHours = 1
+patterns = ["BadBot"]
+`)
+
+	for i := 0; i < 2; i++ {
+		if code := crawlerRequest(router, "BadBot/1.0"); code != http.StatusOK {
+			t.Fatalf("crawler request %d status = %d, want 200", i, code)
+		}
+	}
+	if code := crawlerRequest(router, "BadBot/1.0"); code != http.StatusTooManyRequests {
+		t.Fatalf("crawler over limit status = %d, want 429", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := crawlerRequest(router, "git/2.43.0"); code != http.StatusOK {
+			t.Fatalf("normal client status = %d, want 200", code)
+		}
+	}
+	if got := stats.CrawlerStats().Limited; got != 1 {
+		t.Fatalf("limited count = %d, want 1", got)
+	}
+
+	router, stats = newCrawlerTestRouter(t, `
+[security.crawlers]
+action = "block"
+patterns = ["BadBot"]
+`)
+	if code := crawlerRequest(router, "badbot/2.0"); code != http.StatusForbidden {
+		t.Fatalf("blocked crawler status = %d, want 403", code)
+	}
+	if got := stats.CrawlerStats().Blocked; got != 1 {
+		t.Fatalf("blocked count = %d, want 1", got)
+	}
+}
+
+func TestCrawlerPatternsHotReload(t *testing.T) {
+	loadTestConfig(t, `
+[security.crawlers]
+patterns = ["FirstBot"]
+`)
+	limiter := New(testStore(), nil, nil, nil)
+	reloader := config.NewReloader(limiter.config)
+	reloader.Register(limiter)
+	if !limiter.isCrawler("FirstBot/1.0") || limiter.isCrawler("SecondBot/1.0") {
+		t.Fatal("initial patterns not applied")
+	}
+
+	// 只改写配置文件，由重载比较出security段变化后更新规则
+	if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte("[security.crawlers]\npatterns = [\"SecondBot\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if limiter.isCrawler("FirstBot/1.0") || !limiter.isCrawler("SecondBot/1.0") {
+		t.Fatal("patterns not reloaded")
+	}
+}
+
+// testScale 测试中手动设置的自适应限流倍数
+type testScale struct {
+	factor float64
+}
+
+func (s *testScale) Factor() float64 { return s.factor }
+
+func TestAdaptiveLimitScalesAnonymousIPs(t *testing.T) {
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 4
+periodHours = 1
+adaptiveEnabled = true
+`)
+	gin.SetMode(gin.TestMode)
+	scale := &testScale{factor: 0.5}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set(utils.AuthUserKey, user)
+		}
+	})
+	router.Use(Middleware(New(testStore(), scale, nil, nil)))
+	router.GET("/v2/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	allowed := func(remoteAddr, user string) int {
+		n := 0
+		for range 10 {
+			req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("X-Test-User", user)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := allowed("198.51.100.1:1000", ""); n != 2 {
+		t.Fatalf("anonymous requests allowed under load = %d, want 2", n)
+	}
+	if n := allowed("198.51.100.1:1000", "ci"); n != 4 {
+		t.Fatalf("authenticated requests allowed under load = %d, want 4", n)
+	}
+
+	// 已有限流器在负载回落后恢复原容量
+	limiter := New(testStore(), scale, nil, nil)
+	bucket, _ := limiter.GetLimiter("198.51.100.2")
+	if bucket.Burst() != 2 {
+		t.Fatalf("burst under load = %d", bucket.Burst())
+	}
+	scale.factor = 1
+	if bucket, _ = limiter.GetLimiter("198.51.100.2"); bucket.Burst() != 4 {
+		t.Fatalf("burst after recovery = %d", bucket.Burst())
+	}
+}
+
+func TestCacheBypassRateLimitCost(t *testing.T) {
+	loadTestConfig(t, "[rateLimit]\nrequestLimit = 4\nperiodHours = 1\n")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	router.GET("/v2/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	allowed := func(remoteAddr, cacheControl string) int {
+		n := 0
+		for range 4 {
+			req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("Cache-Control", cacheControl)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+	if n := allowed("198.51.100.10:1000", "no-cache"); n != 2 {
+		t.Fatalf("bypass requests allowed = %d, want 2", n)
+	}
+	if n := allowed("198.51.100.11:1000", ""); n != 4 {
+		t.Fatalf("normal requests allowed = %d, want 4", n)
+	}
+}
+
+func TestRateLimiterUsesHashedKeys(t *testing.T) {
+	loadTestConfig(t, `
+[privacy]
+noIPLogging = true
+retentionDays = 1
+`)
+
+	limiter := newLimiter(testStore(), 2, 1)
+	first, _ := limiter.GetLimiter("203.0.113.7")
+	second, _ := limiter.GetLimiter("203.0.113.7")
+	if first != second {
+		t.Fatal("same IP got different limiters")
+	}
+	if !first.Allow() || !first.Allow() || first.Allow() {
+		t.Fatal("limiter on hashed key does not enforce burst")
+	}
+
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	for key := range limiter.ips {
+		if strings.Contains(key, "203.0.113") {
+			t.Fatalf("limiter key %q contains raw IP", key)
+		}
+	}
+}
+
+// budgetTestRouter 按客户端IP限流，并经上游HTTP客户端请求上游
+func budgetTestRouter(clients *utils.HTTPClients, upstreamURL string) http.Handler {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(New(testStore(), nil, nil, nil)))
+	router.GET("/fetch", func(c *gin.Context) {
+		resp, err := clients.Global().Get(upstreamURL)
+		if err != nil {
+			if budgetErr, exceeded := utils.UpstreamBudgetExceeded(err); exceeded {
+				utils.RespondError(c, http.StatusServiceUnavailable, utils.ErrCodeUpstreamBudget, budgetErr.Host)
+				return
+			}
+			utils.RespondError(c, http.StatusBadGateway, utils.ErrCodeUpstream, err)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		c.Status(resp.StatusCode)
+	})
+	return router
+}
+
+func budgetTestRequest(router http.Handler, ip string) int {
+	req := httptest.NewRequest(http.MethodGet, "/fetch", nil)
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestUpstreamBudgetIndependentOfIPLimit(t *testing.T) {
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 2
+periodHours = 1
+
+[upstream.limits]
+enabled = true
+requestsPerSecond = 0.01
+burst = 3
+queueTimeout = "10ms"
+`)
+	clients := utils.NewHTTPClients(testStore(), utils.NewStatsRegistry())
+	router := budgetTestRouter(clients, upstream.URL)
+
+	// 客户端A被按IP限流时不消耗上游预算
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := budgetTestRequest(router, "203.0.113.1"); code != want {
+			t.Fatalf("client A request %d status = %d, want %d", i, code, want)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("upstream hits = %d, want 2", hits.Load())
+	}
+
+	// 客户端B仍有IP配额，但上游预算由所有客户端共享
+	if code := budgetTestRequest(router, "203.0.113.2"); code != http.StatusOK {
+		t.Fatalf("client B first status = %d, want 200", code)
+	}
+	if code := budgetTestRequest(router, "203.0.113.2"); code != http.StatusServiceUnavailable {
+		t.Fatalf("client B second status = %d, want 503", code)
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("upstream hits = %d, want 3", hits.Load())
+	}
+
+	stats := clients.UpstreamBudgetSnapshot()
+	if len(stats) != 1 || stats[0].Allowed != 3 || stats[0].Rejected != 1 || stats[0].Utilization < 0.99 {
+		t.Fatalf("budget stats = %+v", stats)
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// pathClass 命名限流类别，按IP使用独立的限流桶
+type pathClass struct {
+	name string
+	r    rate.Limit
+	b    int
+}
+
+// pathRule 一条限流路径规则，class为nil时表示豁免限流
+type pathRule struct {
+	pattern string
+	prefix  string // 以*结尾且不含其他通配符的规则按前缀匹配
+	class   *pathClass
+}
+
+// pathRules 编译后的rateLimit.exemptPaths和rateLimit.pathClasses：精确路径查表，
+// 其余规则按模式长度从长到短依次匹配，同一模式的类别规则优先于豁免
+type pathRules struct {
+	exact    map[string]pathRule
+	patterns []pathRule
+}
+
+// uiExemptPaths 内嵌页面和页面使用的接口，无界面模式下这些路径不再提供，配置中的豁免随之失效
+var uiExemptPaths = map[string]bool{
+	"/": true, "/favicon.ico": true, "/images.html": true, "/search.html": true, "/browse.html": true,
+	"/public/*": true, "/search": true, "/tags/*": true, "/api/events": true, "/api/github/tree/*": true,
+}
+
+// compilePathRules 编译限流路径规则，配置已由validateConfig校验
+func compilePathRules(cfg *config.AppConfig) *pathRules {
+	classes := make(map[string]*pathClass, len(cfg.RateLimit.Classes))
+	for name, class := range cfg.RateLimit.Classes {
+		classes[name] = &pathClass{
+			name: name,
+			r:    rate.Limit(float64(class.RequestLimit) / (class.PeriodHours * 3600)),
+			b:    class.RequestLimit,
+		}
+	}
+
+	rules := &pathRules{exact: make(map[string]pathRule)}
+	byPattern := make(map[string]pathRule)
+	for _, pattern := range cfg.RateLimit.ExemptPaths {
+		if !cfg.UI.Enabled && uiExemptPaths[pattern] {
+			continue
+		}
+		byPattern[pattern] = pathRule{pattern: pattern}
+	}
+	for pattern, name := range cfg.RateLimit.PathClasses {
+		if class := classes[name]; class != nil {
+			byPattern[pattern] = pathRule{pattern: pattern, class: class}
+		}
+	}
+	for pattern, rule := range byPattern {
+		switch head := strings.TrimSuffix(pattern, "*"); {
+		case !strings.ContainsAny(pattern, "*?["):
+			rules.exact[pattern] = rule
+		case head != pattern && !strings.ContainsAny(head, "*?["):
+			rule.prefix = head
+			rules.patterns = append(rules.patterns, rule)
+		default:
+			rules.patterns = append(rules.patterns, rule)
+		}
+	}
+	sort.Slice(rules.patterns, func(i, j int) bool {
+		a, b := rules.patterns[i].pattern, rules.patterns[j].pattern
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return rules
+}
+
+// match 返回路径命中的规则：精确路径优先，其次是最长的前缀或通配符规则
+func (r *pathRules) match(requestPath string) (pathRule, bool) {
+	if rule, ok := r.exact[requestPath]; ok {
+		return rule, true
+	}
+	for _, rule := range r.patterns {
+		if rule.prefix != "" {
+			if strings.HasPrefix(requestPath, rule.prefix) {
+				return rule, true
+			}
+			continue
+		}
+		if matched, _ := path.Match(rule.pattern, requestPath); matched {
+			return rule, true
+		}
+	}
+	return pathRule{}, false
+}
+
+// reloadPathRules 按cfg重新编译限流路径规则
+func (i *Limiter) reloadPathRules(cfg *config.AppConfig) {
+	i.pathRules.Store(compilePathRules(cfg))
+}
+
+// matchPath 返回请求路径命中的豁免或类别规则
+func (i *Limiter) matchPath(requestPath string) (pathRule, bool) {
+	rules := i.pathRules.Load()
+	if rules == nil {
+		return pathRule{}, false
+	}
+	return rules.match(requestPath)
+}
+
+// classLimiter 返回IP在命名限流类别中的限流桶，与默认限额互不影响。
+// 来自共享网络的请求按共享桶或用户子key区分，共享桶的类别限额同样乘以multiplier
+func (i *Limiter) classLimiter(class *pathClass, cleanIP string, shared *sharedCaller) *rate.Limiter {
+	if shared != nil {
+		return i.entryLimiter("class:"+class.name+":"+shared.key, class.r*rate.Limit(shared.scale), int(float64(class.b)*shared.scale), 1)
+	}
+	key := "class:" + class.name + ":" + i.identity.LimiterKey(normalizeIPForRateLimit(cleanIP))
+	return i.entryLimiter(key, class.r, class.b, 1)
+}
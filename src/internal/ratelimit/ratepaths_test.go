@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPathRulesMatch(t *testing.T) {
+	loadTestConfig(t, `
+[rateLimit]
+exemptPaths = ["/", "/health", "/public/*", "/assets/*.css", "/docs/*", "/api/v?/ping"]
+
+[rateLimit.pathClasses]
+"/public/uploads/*" = "uploads"
+"/docs/*" = "docs"
+"/search.html" = "docs"
+
+[rateLimit.classes.uploads]
+requestLimit = 10
+periodHours = 1
+
+[rateLimit.classes.docs]
+requestLimit = 100
+periodHours = 24
+`)
+	rules := compilePathRules(config.GetConfig())
+
+	tests := []struct {
+		path    string
+		matched bool
+		class   string
+	}{
+		{"/", true, ""},
+		{"/health", true, ""},
+		{"/healthz", false, ""},
+		{"/index.html", false, ""},
+		// 以*结尾的规则按前缀匹配，包括更深的子路径
+		{"/public/app.js", true, ""},
+		{"/public/js/vendor/app.js", true, ""},
+		{"/public", false, ""},
+		// 其他通配符按path.Match匹配，*不跨越/
+		{"/assets/site.css", true, ""},
+		{"/assets/css/site.css", false, ""},
+		{"/api/v1/ping", true, ""},
+		{"/api/v10/ping", false, ""},
+		// 更长的前缀优先
+		{"/public/uploads/a.bin", true, "uploads"},
+		// 同一模式同时豁免和指定类别时类别优先
+		{"/docs/index.html", true, "docs"},
+		{"/search.html", true, "docs"},
+	}
+	for _, tt := range tests {
+		rule, matched := rules.match(tt.path)
+		class := ""
+		if rule.class != nil {
+			class = rule.class.name
+		}
+		if matched != tt.matched || class != tt.class {
+			t.Errorf("match(%q) = %v, %q; want %v, %q", tt.path, matched, class, tt.matched, tt.class)
+		}
+	}
+}
+
+func TestPathRulesHeadless(t *testing.T) {
+	loadTestConfig(t, `
+[ui]
+enabled = false
+
+[rateLimit]
+exemptPaths = ["/", "/favicon.ico", "/search.html", "/api/events", "/health", "/public/*"]
+`)
+	rules := compilePathRules(config.GetConfig())
+
+	// 无界面模式下页面和页面接口不再豁免，健康检查不受影响
+	for _, path := range []string{"/", "/favicon.ico", "/search.html", "/api/events", "/public/app.js"} {
+		if _, matched := rules.match(path); matched {
+			t.Errorf("%s still exempt in headless mode", path)
+		}
+	}
+	if _, matched := rules.match("/health"); !matched {
+		t.Error("/health lost its exemption")
+	}
+}
+
+func TestRateLimitPathClasses(t *testing.T) {
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+exemptPaths = ["/static/*"]
+
+[rateLimit.pathClasses]
+"/api/*" = "api"
+
+[rateLimit.classes.api]
+requestLimit = 2
+periodHours = 1
+`)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	router.GET("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.44:40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"default bucket", "/v2/", http.StatusOK},
+		{"default bucket exhausted", "/v2/", http.StatusTooManyRequests},
+		// 类别使用独立的桶，不受默认桶耗尽影响
+		{"class bucket", "/api/a", http.StatusOK},
+		{"class bucket second", "/api/b", http.StatusOK},
+		{"class bucket exhausted", "/api/c", http.StatusTooManyRequests},
+		{"exempt", "/static/app.js", http.StatusOK},
+		// 配置了exemptPaths后不再使用默认的豁免列表
+		{"default exemption replaced", "/favicon.ico", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := request(tt.path); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPathRulesHotReload(t *testing.T) {
+	loadTestConfig(t, `
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+exemptPaths = ["/a/*"]
+`)
+	gin.SetMode(gin.TestMode)
+	// 只有注册到Reloader的限流器随配置更新，其他实例保留创建时的规则
+	reloaded, unregistered := New(testStore(), nil, nil, nil), New(testStore(), nil, nil, nil)
+	reloader := config.NewReloader(reloaded.config)
+	reloader.Register(reloaded)
+
+	if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte("[rateLimit]\nrequestLimit = 1\nperiodHours = 1\nexemptPaths = [\"/b/*\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(limiter *Limiter, path string) int {
+		router := gin.New()
+		router.Use(Middleware(limiter))
+		router.GET("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.45:40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := request(reloaded, "/b/file"); code != http.StatusOK {
+			t.Fatalf("reloaded exempt path request %d: status %d", i, code)
+		}
+	}
+	if request(reloaded, "/a/file") != http.StatusOK || request(reloaded, "/a/file") != http.StatusTooManyRequests {
+		t.Fatal("old exempt path still exempt after reload")
+	}
+	if request(unregistered, "/b/file") != http.StatusOK || request(unregistered, "/b/file") != http.StatusTooManyRequests {
+		t.Fatal("unregistered limiter picked up reloaded rules")
+	}
+}
@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// sharedKeyPrefix 共享网络在限流表中的key前缀，与按IP的桶互不影响
+const sharedKeyPrefix = "shared:"
+
+// maxSharedUserLength 用户头取值的长度上限，超出时按共享桶计数
+const maxSharedUserLength = 256
+
+// sharedNetwork 编译后的rateLimit.sharedNetworks条目
+type sharedNetwork struct {
+	name       string
+	cidrs      []*net.IPNet
+	multiplier float64
+	userHeader string
+}
+
+// sharedNetworks 编译后的共享网络列表和判断转发链使用的受信任代理
+type sharedNetworks struct {
+	list    []*sharedNetwork
+	trusted []*net.IPNet
+}
+
+// sharedCaller 请求在共享网络中使用的限流桶：带用户头时为该用户的子key，按普通IP的限额计数；
+// 否则为整个网络共用的桶，限额乘以multiplier
+type sharedCaller struct {
+	network string
+	key     string
+	scale   float64
+	user    bool
+}
+
+// sharedNetworkCounters 单个共享网络的请求计数
+type sharedNetworkCounters struct {
+	pooled  atomic.Int64
+	users   atomic.Int64
+	limited atomic.Int64
+	spoofed atomic.Int64
+}
+
+// SharedNetworkStats 共享网络按共享桶和用户子key计数的请求数、被限流的请求数，
+// 以及转发链不可信、声称来自该网络而被按普通IP限流的请求数
+type SharedNetworkStats struct {
+	Pooled  int64 `json:"pooled"`
+	Users   int64 `json:"users"`
+	Limited int64 `json:"limited"`
+	Spoofed int64 `json:"spoofed"`
+}
+
+// sharedCounters 返回共享网络的计数器，不存在时创建
+func (i *Limiter) sharedCounters(name string) *sharedNetworkCounters {
+	if counters, ok := i.sharedStats.Load(name); ok {
+		return counters.(*sharedNetworkCounters)
+	}
+	counters, _ := i.sharedStats.LoadOrStore(name, &sharedNetworkCounters{})
+	return counters.(*sharedNetworkCounters)
+}
+
+// SharedNetworkStats 返回各共享网络的请求计数，未配置共享网络且没有计数时返回nil
+func (i *Limiter) SharedNetworkStats() map[string]SharedNetworkStats {
+	var stats map[string]SharedNetworkStats
+	i.sharedStats.Range(func(key, value any) bool {
+		if stats == nil {
+			stats = make(map[string]SharedNetworkStats)
+		}
+		counters := value.(*sharedNetworkCounters)
+		stats[key.(string)] = SharedNetworkStats{
+			Pooled:  counters.pooled.Load(),
+			Users:   counters.users.Load(),
+			Limited: counters.limited.Load(),
+			Spoofed: counters.spoofed.Load(),
+		}
+		return true
+	})
+	return stats
+}
+
+// compileSharedNetworks 编译共享网络配置，按名称排序使网段重叠时的匹配结果稳定，配置已由validateConfig校验
+func compileSharedNetworks(cfg *config.AppConfig) *sharedNetworks {
+	networks := &sharedNetworks{trusted: utils.ParseIPNets(cfg.Server.TrustedProxies)}
+	for name, network := range cfg.RateLimit.SharedNetworks {
+		networks.list = append(networks.list, &sharedNetwork{
+			name:       name,
+			cidrs:      utils.ParseIPNets(network.CIDRs),
+			multiplier: network.Multiplier,
+			userHeader: strings.TrimSpace(network.UserHeader),
+		})
+	}
+	sort.Slice(networks.list, func(i, j int) bool {
+		return networks.list[i].name < networks.list[j].name
+	})
+	return networks
+}
+
+// match 返回IP所属的共享网络，不属于任何共享网络时返回nil
+func (n *sharedNetworks) match(ip string) *sharedNetwork {
+	for _, network := range n.list {
+		if utils.IPInCIDRList(ip, network.cidrs) {
+			return network
+		}
+	}
+	return nil
+}
+
+// verifiedClientIP 按受信任代理列表确定可信的客户端IP：对端不是受信任代理时即为对端地址，
+// 否则从X-Forwarded-For末尾向前跳过受信任代理，取第一个不受信任的地址。
+// 客户端自行填写的X-Forwarded-For位于最前面，无法借此冒充共享网络
+func (n *sharedNetworks) verifiedClientIP(r *http.Request) string {
+	ip := utils.ExtractIPFromAddress(r.RemoteAddr)
+	if !utils.IPInCIDRList(ip, n.trusted) {
+		return ip
+	}
+	var hops []string
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops = strings.Split(strings.Join(values, ","), ",")
+	} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		hops = []string{realIP}
+	}
+	for k := len(hops) - 1; k >= 0; k-- {
+		hop := utils.ExtractIPFromAddress(strings.TrimSpace(hops[k]))
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !utils.IPInCIDRList(hop, n.trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// reloadSharedNetworks 按cfg重新编译共享网络
+func (i *Limiter) reloadSharedNetworks(cfg *config.AppConfig) {
+	i.sharedNetworks.Store(compileSharedNetworks(cfg))
+}
+
+// sharedCaller 判断请求是否来自共享网络并返回其限流桶。只有按受信任代理列表验证过的客户端IP
+// 属于共享网络时才使用共享桶并采信用户头；转发头声称来自共享网络但验证不通过时记为伪造，按普通IP限流
+func (i *Limiter) sharedCaller(c *gin.Context, cleanIP string) *sharedCaller {
+	networks := i.sharedNetworks.Load()
+	if networks == nil || len(networks.list) == 0 {
+		return nil
+	}
+	network := networks.match(networks.verifiedClientIP(c.Request))
+	if network == nil {
+		if claimed := networks.match(cleanIP); claimed != nil {
+			i.sharedCounters(claimed.name).spoofed.Add(1)
+			utils.Logf(utils.LogWarn, "shared-spoof "+claimed.name, "共享网络 %s: 请求声称来自 %s，但转发链不可信，按普通IP限流",
+				claimed.name, i.identity.Identify(cleanIP, false))
+		}
+		return nil
+	}
+
+	caller := &sharedCaller{network: network.name, key: sharedKeyPrefix + network.name, scale: network.multiplier}
+	if network.userHeader != "" {
+		if user := strings.TrimSpace(c.GetHeader(network.userHeader)); user != "" && len(user) <= maxSharedUserLength {
+			sum := sha256.Sum256([]byte(user))
+			caller.key += ":user:" + hex.EncodeToString(sum[:8])
+			caller.scale = 1
+			caller.user = true
+		}
+	}
+	return caller
+}
+
+// countSharedRequest 记录共享网络按共享桶或用户子key放行前的请求数
+func (i *Limiter) countSharedRequest(s *sharedCaller) {
+	if s.user {
+		i.sharedCounters(s.network).users.Add(1)
+	} else {
+		i.sharedCounters(s.network).pooled.Add(1)
+	}
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSharedNetworkRateLimit(t *testing.T) {
+	loadTestConfig(t, `
+[server]
+trustedProxies = ["10.0.0.0/8"]
+
+[rateLimit]
+requestLimit = 1
+periodHours = 1
+
+[rateLimit.sharedNetworks.campus]
+cidrs = ["203.0.113.0/24"]
+multiplier = 3
+userHeader = "X-Campus-User"
+`)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := New(testStore(), nil, nil, nil)
+	router.Use(Middleware(limiter))
+	router.GET("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(remoteAddr, forwarded, user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		if user != "" {
+			req.Header.Set("X-Campus-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	before := limiter.SharedNetworkStats()["campus"]
+
+	// 缺少用户头时网段内的所有地址共用放大3倍的共享桶
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		user       string
+		want       int
+	}{
+		{"pooled first", "203.0.113.5:40000", "", "", http.StatusOK},
+		{"pooled other host", "203.0.113.6:40000", "", "", http.StatusOK},
+		{"pooled via trusted proxy", "10.0.0.2:40000", "203.0.113.7", "", http.StatusOK},
+		{"pooled exhausted", "203.0.113.8:40000", "", "", http.StatusTooManyRequests},
+		// 带用户头时每个用户按普通IP的限额单独计数，不受共享桶耗尽影响
+		{"user alice", "203.0.113.5:40000", "", "alice", http.StatusOK},
+		{"user alice exhausted", "203.0.113.6:40000", "", "alice", http.StatusTooManyRequests},
+		{"user bob via trusted proxy", "10.0.0.2:40000", "203.0.113.9", "bob", http.StatusOK},
+		// 不受信任的对端伪造转发头和用户头时按声称的IP单独限流，不使用共享桶或用户子key
+		{"spoofed direct", "198.51.100.9:40000", "203.0.113.50", "mallory", http.StatusOK},
+		{"spoofed direct exhausted", "198.51.100.9:40000", "203.0.113.50", "eve", http.StatusTooManyRequests},
+		// 经受信任代理转发时，客户端自行填写的X-Forwarded-For前缀同样不被采信
+		{"spoofed via trusted proxy", "10.0.0.2:40000", "203.0.113.51, 198.51.100.10", "trent", http.StatusOK},
+		{"spoofed via trusted proxy exhausted", "10.0.0.2:40000", "203.0.113.51, 198.51.100.10", "trent", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := request(tt.remoteAddr, tt.forwarded, tt.user); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	after := limiter.SharedNetworkStats()["campus"]
+	if pooled := after.Pooled - before.Pooled; pooled != 4 {
+		t.Errorf("pooled = %d, want 4", pooled)
+	}
+	if users := after.Users - before.Users; users != 3 {
+		t.Errorf("users = %d, want 3", users)
+	}
+	if limited := after.Limited - before.Limited; limited != 2 {
+		t.Errorf("limited = %d, want 2", limited)
+	}
+	if spoofed := after.Spoofed - before.Spoofed; spoofed != 4 {
+		t.Errorf("spoofed = %d, want 4", spoofed)
+	}
+}
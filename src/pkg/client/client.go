@@ -0,0 +1,484 @@
+// Package client 是hubproxy JSON接口的Go客户端，接口说明见服务端的 /api/openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client hubproxy接口客户端，可并发使用
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	adminToken string
+}
+
+// Option 客户端选项
+type Option func(*Client)
+
+// WithHTTPClient 使用自定义的HTTP客户端，默认为http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken 私有实例的访问令牌，以Bearer方式发送
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithAdminToken 管理令牌，复制任务等管理接口使用
+func WithAdminToken(token string) Option {
+	return func(c *Client) { c.adminToken = token }
+}
+
+// New 创建客户端，baseURL为实例地址，带basePath时一并写上，如 https://proxy.example.com/hub
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error 接口返回的错误，Code为服务端的错误码，部分接口没有错误码
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("hubproxy: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("hubproxy: %d: %s", e.StatusCode, e.Message)
+}
+
+// do 发送请求并检查状态码，非2xx时解析为*Error，调用方负责关闭返回的响应体
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any, admin bool) (*http.Response, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	token := c.token
+	if admin {
+		token = c.adminToken
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return nil, apiErr
+}
+
+// getJSON 发送请求并将JSON响应解码到out
+func (c *Client) getJSON(ctx context.Context, method, path string, query url.Values, body, out any, admin bool) error {
+	resp, err := c.do(ctx, method, path, query, body, admin)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 访问检查的目标类别
+const (
+	AccessKindImage  = "image"
+	AccessKindGitHub = "github"
+	AccessKindURL    = "url"
+)
+
+// AccessResult 访问检查结果，Reason为拒绝时的错误码；
+// 检查代理链接时Pattern为匹配的链接规则，Upstream为实际请求的上游地址
+type AccessResult struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Allowed  bool   `json:"allowed"`
+	Reason   string `json:"reason,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// CheckAccess 按实例的黑白名单检查镜像(AccessKindImage)、GitHub仓库owner/repo(AccessKindGitHub)
+// 或代理链接(AccessKindURL)是否允许代理，不请求上游
+func (c *Client) CheckAccess(ctx context.Context, kind, target string) (*AccessResult, error) {
+	if kind != AccessKindImage && kind != AccessKindGitHub && kind != AccessKindURL {
+		return nil, fmt.Errorf("hubproxy: 未知的访问检查类别 %q", kind)
+	}
+	var result AccessResult
+	if err := c.getJSON(ctx, http.MethodGet, "/api/access", url.Values{kind: {target}}, nil, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TarRequest 镜像tar包下载请求
+type TarRequest struct {
+	Images   []string `json:"images"`
+	Platform string   `json:"platform,omitempty"`
+	// UseCompressedLayers 为nil时使用服务端默认值(true)
+	UseCompressedLayers *bool `json:"useCompressedLayers,omitempty"`
+	// Compression 整个tar包的压缩方式：none、gzip或zstd，为空表示none
+	Compression string `json:"-"`
+}
+
+// TarJob 已准备好的下载任务，DownloadURL为相对实例地址的一次性链接
+type TarJob struct {
+	DownloadURL string `json:"download_url"`
+}
+
+// StartTarJob 准备镜像tar包下载，单个镜像同样使用批量接口，避免镜像名中的_被当作/
+func (c *Client) StartTarJob(ctx context.Context, req TarRequest) (*TarJob, error) {
+	query := url.Values{"mode": {"prepare"}}
+	if req.Compression != "" {
+		query.Set("compress", req.Compression)
+	}
+	var job TarJob
+	if err := c.getJSON(ctx, http.MethodPost, "/api/image/batch", query, req, &job, false); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// DownloadTar 下载已准备的tar包，调用方负责关闭返回的响应体。下载链接只能使用一次
+func (c *Client) DownloadTar(ctx context.Context, job *TarJob) (io.ReadCloser, error) {
+	path, rawQuery, _ := strings.Cut(job.DownloadURL, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// 复制任务状态
+const (
+	CopyStatusRunning   = "running"
+	CopyStatusSucceeded = "succeeded"
+	CopyStatusFailed    = "failed"
+	CopyStatusCancelled = "cancelled"
+)
+
+// CopyRequest 镜像复制请求，TargetAuth为空时使用实例为目标仓库配置的凭据
+type CopyRequest struct {
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	TargetAuth *CopyAuth `json:"targetAuth,omitempty"`
+	Platform   string    `json:"platform,omitempty"`
+}
+
+// CopyAuth 目标仓库凭据
+type CopyAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token,omitempty"`
+}
+
+// CopyJob 镜像复制任务状态
+type CopyJob struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Platform   string    `json:"platform,omitempty"`
+	Status     string    `json:"status"`
+	Total      int64     `json:"total"`
+	Complete   int64     `json:"complete"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// StartCopyJob 创建镜像复制任务并返回任务ID，需要管理令牌
+func (c *Client) StartCopyJob(ctx context.Context, req CopyRequest) (string, error) {
+	var created struct {
+		JobID string `json:"job_id"`
+	}
+	if err := c.getJSON(ctx, http.MethodPost, "/api/copy", nil, req, &created, true); err != nil {
+		return "", err
+	}
+	return created.JobID, nil
+}
+
+// CopyJob 查询复制任务状态，需要管理令牌
+func (c *Client) CopyJob(ctx context.Context, id string) (*CopyJob, error) {
+	var job CopyJob
+	if err := c.getJSON(ctx, http.MethodGet, "/api/copy/"+url.PathEscape(id), nil, nil, &job, true); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelCopyJob 取消复制任务，需要管理令牌
+func (c *Client) CancelCopyJob(ctx context.Context, id string) error {
+	return c.getJSON(ctx, http.MethodDelete, "/api/copy/"+url.PathEscape(id), nil, nil, nil, true)
+}
+
+// JobEvents 订阅复制任务的SSE进度，每次进度变化时调用onProgress(可为nil)，任务结束后返回最终状态
+func (c *Client) JobEvents(ctx context.Context, id string, onProgress func(CopyJob)) (*CopyJob, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/copy/"+url.PathEscape(id)+"/events", nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var final *CopyJob
+	err = readEvents(resp.Body, func(event, data string) (bool, error) {
+		var job CopyJob
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return false, err
+		}
+		switch event {
+		case "progress":
+			if onProgress != nil {
+				onProgress(job)
+			}
+		case "done":
+			final = &job
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if final == nil {
+		return nil, errors.New("hubproxy: 事件流在任务结束前中断")
+	}
+	return final, nil
+}
+
+// readEvents 逐个解析SSE事件，handle返回false时停止读取
+func readEvents(r io.Reader, handle func(event, data string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				more, err := handle(event, strings.Join(data, "\n"))
+				if err != nil || !more {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// 心跳注释
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return scanner.Err()
+}
+
+// Percentiles 分位数
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// SeriesStats 一个维度的请求数、耗时和吞吐量
+type SeriesStats struct {
+	Count             uint64      `json:"count"`
+	DurationMs        Percentiles `json:"duration_ms"`
+	ThroughputSamples uint64      `json:"throughput_samples"`
+	ThroughputMBps    Percentiles `json:"throughput_mbps"`
+}
+
+// TrafficStats 一个路由类别和缓存结果的累计流量
+type TrafficStats struct {
+	Requests        uint64 `json:"requests"`
+	DownstreamBytes uint64 `json:"downstream_bytes"`
+	UpstreamBytes   uint64 `json:"upstream_bytes"`
+}
+
+// Stats /api/stats 的响应，Extra保留客户端未单独建模的字段
+type Stats struct {
+	Time      string                             `json:"time"`
+	TimeUnix  int64                              `json:"time_unix"`
+	Window    string                             `json:"window"`
+	Summary   SeriesStats                        `json:"summary"`
+	Routes    map[string]SeriesStats             `json:"routes"`
+	Upstreams map[string]SeriesStats             `json:"upstreams"`
+	Traffic   map[string]map[string]TrafficStats `json:"traffic"`
+	Redirects map[string]uint64                  `json:"redirects"`
+	Extra     map[string]json.RawMessage         `json:"-"`
+}
+
+// Stats 查询统计数据，window如"1h"，为空表示启动以来
+func (c *Client) Stats(ctx context.Context, window string) (*Stats, error) {
+	var query url.Values
+	if window != "" {
+		query = url.Values{"window": {window}}
+	}
+	var data json.RawMessage
+	if err := c.getJSON(ctx, http.MethodGet, "/api/stats", query, nil, &data, false); err != nil {
+		return nil, err
+	}
+	var stats Stats
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"time", "time_unix", "window", "summary", "routes", "upstreams", "traffic", "redirects"} {
+		delete(raw, key)
+	}
+	stats.Extra = raw
+	return &stats, nil
+}
+
+// Capabilities /api/capabilities 的响应
+type Capabilities struct {
+	Sensitive  bool   `json:"sensitive"`
+	BasePath   string `json:"base_path"`
+	Registries []struct {
+		Domain   string `json:"domain"`
+		Upstream string `json:"upstream,omitempty"`
+	} `json:"registries"`
+	Features struct {
+		TarDownload   bool `json:"tar_download"`
+		BatchDownload bool `json:"batch_download"`
+		MultiArch     bool `json:"multi_arch"`
+		Copy          bool `json:"copy"`
+		Push          bool `json:"push"`
+		Search        bool `json:"search"`
+		GitHubTree    bool `json:"github_tree"`
+		Signing       bool `json:"signing"`
+		History       bool `json:"history"`
+		ActivityFeed  bool `json:"activity_feed"`
+		AuthRequired  bool `json:"auth_required"`
+	} `json:"features"`
+	URLPatterns []struct {
+		Kind    string `json:"kind"`
+		Pattern string `json:"pattern"`
+	} `json:"url_patterns"`
+	Limits struct {
+		FileSize    int64 `json:"file_size"`
+		GitHub      int64 `json:"github"`
+		HuggingFace int64 `json:"huggingface"`
+		DockerBlob  int64 `json:"docker_blob"`
+		Tar         int64 `json:"tar"`
+		MaxImages   int   `json:"max_images"`
+	} `json:"limits"`
+	RateLimit *struct {
+		Unlimited    bool `json:"unlimited"`
+		Limit        int  `json:"limit"`
+		Remaining    int  `json:"remaining"`
+		ResetSeconds int  `json:"reset_seconds"`
+	} `json:"rate_limit"`
+	// RateLimitHeaders 本次响应附带的限流头，与RateLimit一致，不限流时为nil
+	RateLimitHeaders *RateLimitHeaders `json:"-"`
+}
+
+// Capabilities 查询实例支持的功能、URL形式和调用方适用的限制
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/capabilities", nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, err
+	}
+	caps.RateLimitHeaders = parseRateLimitHeaders(resp.Header)
+	return &caps, nil
+}
+
+// RateLimitHeaders 响应附带的 RateLimit-Limit、RateLimit-Remaining 和 RateLimit-Reset 头，
+// 调用方所在限流桶不限流时实例不附加
+type RateLimitHeaders struct {
+	Limit        int `json:"limit"`
+	Remaining    int `json:"remaining"`
+	ResetSeconds int `json:"reset_seconds"`
+}
+
+// parseRateLimitHeaders 解析响应的限流头，没有RateLimit-Limit时返回nil
+func parseRateLimitHeaders(header http.Header) *RateLimitHeaders {
+	limit, err := strconv.Atoi(header.Get("RateLimit-Limit"))
+	if err != nil {
+		return nil
+	}
+	headers := &RateLimitHeaders{Limit: limit}
+	headers.Remaining, _ = strconv.Atoi(header.Get("RateLimit-Remaining"))
+	headers.ResetSeconds, _ = strconv.Atoi(header.Get("RateLimit-Reset"))
+	return headers
+}
+
+// HealthSummary /health/summary 的整体状态，Status为ok、degraded或down，Reasons为判定原因
+type HealthSummary struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Health 请求 /health 确认实例存活，再取 /health/summary 中按最近请求成功率和内存占用判定的整体状态
+func (c *Client) Health(ctx context.Context) (*HealthSummary, error) {
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := c.getJSON(ctx, http.MethodGet, "/health", nil, nil, &health, false); err != nil {
+		return nil, err
+	}
+	if health.Status != "ok" {
+		return nil, fmt.Errorf("hubproxy: /health 返回状态 %q", health.Status)
+	}
+	var summary HealthSummary
+	if err := c.getJSON(ctx, http.MethodGet, "/health/summary", nil, nil, &summary, false); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/handlers"
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newTestInstance 以给定配置启动只包含客户端所用接口的实例
+func newTestInstance(t *testing.T, configBody string) *httptest.Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(configBody), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Apply(config.DefaultConfig()) })
+	shared := utils.NewComponents(config.GetConfig())
+	cache, hot, access, clients := utils.NewUniversalCache(shared.Background.Context(), shared.Config), utils.NewHotCache(shared.Config), accesscontrol.New(shared.Config), utils.NewHTTPClients(shared.Config, shared.Stats)
+	docker, err := dockerproxy.New(cache, hot, access, clients, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handlers.New(handlers.Deps{
+		Components: shared,
+		Cache:      cache,
+		Hot:        hot,
+		Access:     access,
+		Clients:    clients,
+		Limiter:    ratelimit.New(shared.Config, shared.Adaptive, shared.IPs, shared.Stats),
+		Docker:     docker,
+		GitHub:     githubproxy.New(cache, hot, access, clients, shared),
+		Reloader:   config.NewReloader(shared.Config),
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	h.InitAccessCheckRoutes(router)
+	h.InitStatsRoutes(router)
+	h.InitCapabilitiesRoutes(router)
+	h.InitImageTarRoutes(router)
+	h.InitImageCopyRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientAgainstHandlers(t *testing.T) {
+	server := newTestInstance(t, `
+[admin]
+enabled = true
+token = "admin-token"
+requestsPerMinute = 0
+
+[access]
+blackList = ["blocked/repo"]
+`)
+	ctx := context.Background()
+	c := New(server.URL+"/", WithAdminToken("admin-token"))
+
+	result, err := c.CheckAccess(ctx, AccessKindGitHub, "blocked/repo")
+	if err != nil || result.Allowed || result.Reason != utils.ErrCodeGitHubBlacklisted {
+		t.Fatalf("CheckAccess = %+v, %v", result, err)
+	}
+	if result, err = c.CheckAccess(ctx, AccessKindImage, "nginx"); err != nil || !result.Allowed {
+		t.Fatalf("CheckAccess(image) = %+v, %v", result, err)
+	}
+	var apiErr *Error
+	if _, err = c.CheckAccess(ctx, AccessKindGitHub, "no-slash"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid target error = %v", err)
+	}
+
+	if result, err = c.CheckAccess(ctx, AccessKindURL, "https://github.com/blocked/repo/releases/download/v1/a.tgz"); err != nil ||
+		result.Allowed || result.Pattern != "github.com/{owner}/{repo}/{releases,archive}/..." {
+		t.Fatalf("CheckAccess(url) = %+v, %v", result, err)
+	}
+
+	caps, err := c.Capabilities(ctx)
+	if err != nil || !caps.Features.TarDownload || caps.Limits.MaxImages == 0 {
+		t.Fatalf("Capabilities = %+v, %v", caps, err)
+	}
+	stats, err := c.Stats(ctx, "1h")
+	if err != nil || stats.Window != "1h0m0s" || stats.Routes == nil || stats.Extra["tokens"] == nil {
+		t.Fatalf("Stats = %+v, %v", stats, err)
+	}
+	if _, err = c.Stats(ctx, "bogus"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid window error = %v", err)
+	}
+
+	job, err := c.StartTarJob(ctx, TarRequest{Images: []string{"library/app_name"}, Compression: "gzip"})
+	if err != nil || !strings.HasPrefix(job.DownloadURL, "/api/image/batch?token=") {
+		t.Fatalf("StartTarJob = %+v, %v", job, err)
+	}
+}
+
+func TestClientCopyJobEvents(t *testing.T) {
+	server := newTestInstance(t, `
+[admin]
+enabled = true
+token = "admin-token"
+requestsPerMinute = 0
+`)
+	quiet := registry.Logger(log.New(io.Discard, "", 0))
+	source := httptest.NewServer(registry.New(quiet))
+	defer source.Close()
+	target := httptest.NewServer(registry.New(quiet))
+	defer target.Close()
+
+	sourceRef := strings.TrimPrefix(source.URL, "http://") + "/org/app:v1"
+	targetRef := strings.TrimPrefix(target.URL, "http://") + "/org/app:v1"
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(sourceRef)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := New(server.URL).StartCopyJob(ctx, CopyRequest{Source: sourceRef, Target: targetRef}); err == nil {
+		t.Fatal("copy job started without admin token")
+	}
+
+	c := New(server.URL, WithAdminToken("admin-token"))
+	id, err := c.StartCopyJob(ctx, CopyRequest{Source: sourceRef, Target: targetRef})
+	if err != nil {
+		t.Fatal(err)
+	}
+	final, err := c.JobEvents(ctx, id, nil)
+	if err != nil || final.Status != CopyStatusSucceeded || final.ID != id {
+		t.Fatalf("JobEvents = %+v, %v", final, err)
+	}
+	if status, err := c.CopyJob(ctx, id); err != nil || status.Status != CopyStatusSucceeded {
+		t.Fatalf("CopyJob = %+v, %v", status, err)
+	}
+
+	want, _ := image.Digest()
+	targetParsed, _ := name.ParseReference(targetRef)
+	if desc, err := remote.Get(targetParsed); err != nil || desc.Digest != want {
+		t.Fatalf("target digest = %v, %v", desc, err)
+	}
+}
+
+func TestProbeURL(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/https://github.com/o/r/blob/main/a.txt":
+			w.Header().Set("Location", "/https://github.com/o/r/raw/main/a.txt?"+r.URL.RawQuery)
+			w.WriteHeader(http.StatusFound)
+		case "/https://github.com/o/r/raw/main/a.txt":
+			if r.Header.Get("X-Admin-Token") == "admin-token" && r.URL.Query().Get("debug") == "1" {
+				w.Header().Set("X-Proxy-Redirect-Chain", `[{"method":"HEAD","url":"https://raw.githubusercontent.com/o/r/main/a.txt","status":200}]`)
+			}
+			w.Header().Set("X-Proxy-Hops", "1")
+			w.Header().Set("X-Cache", "HIT")
+			w.Header().Set("Content-Length", "42")
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer proxy.Close()
+	ctx := context.Background()
+
+	probe, err := New(proxy.URL, WithAdminToken("admin-token")).ProbeURL(ctx, "https://github.com/o/r/blob/main/a.txt")
+	if err != nil || probe.Status != http.StatusOK || probe.Size != 42 || probe.Cache != "HIT" || probe.Hops != 1 {
+		t.Fatalf("ProbeURL = %+v, %v", probe, err)
+	}
+	if len(probe.Redirects) != 1 || probe.Redirects[0].Status != http.StatusFound || len(probe.Upstream) != 1 {
+		t.Fatalf("redirects = %+v, upstream = %+v", probe.Redirects, probe.Upstream)
+	}
+
+	// 非2xx不视为错误，由调用方判断
+	if probe, err = New(proxy.URL).ProbeURL(ctx, "https://example.com/x"); err != nil || probe.Status != http.StatusForbidden {
+		t.Fatalf("forbidden probe = %+v, %v", probe, err)
+	}
+}
+
+func TestProbeImage(t *testing.T) {
+	quiet := registry.Logger(log.New(io.Discard, "", 0))
+	upstream := registry.New(quiet)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if !strings.HasPrefix(r.URL.Query().Get("scope"), "repository:org/") || r.URL.Query().Get("service") != "hub.example" {
+				t.Errorf("token query = %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"token":"pull-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="hub.example"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	index, err := random.Index(512, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 直接写入被代理的Registry，经实例读取时需要先取得令牌
+	direct := httptest.NewServer(upstream)
+	defer direct.Close()
+	ref, _ := name.ParseReference(strings.TrimPrefix(direct.URL, "http://") + "/org/app:v1")
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+
+	probe, err := New(server.URL).ProbeImage(context.Background(), "org/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := index.Digest()
+	if probe.Digest != want.String() || len(probe.Platforms) != 2 {
+		t.Fatalf("ProbeImage = %+v", probe)
+	}
+	for _, platform := range probe.Platforms {
+		if len(platform.Layers) != 2 || platform.Layers[0].Size <= 0 || platform.Size <= platform.Layers[0].Size+platform.Layers[1].Size || !strings.HasPrefix(platform.Digest, "sha256:") {
+			t.Errorf("platform = %+v", platform)
+		}
+	}
+
+	var apiErr *Error
+	if _, err = New(server.URL).ProbeImage(context.Background(), "org/missing:v1"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing image error = %v", err)
+	}
+}
+
+func TestSelftestEndpoints(t *testing.T) {
+	quiet := registry.Logger(log.New(io.Discard, "", 0))
+	upstream := registry.New(quiet)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Limit", "100")
+		w.Header().Set("RateLimit-Remaining", "99")
+		w.Header().Set("RateLimit-Reset", "36")
+		switch {
+		case r.URL.Path == "/health":
+			w.Write([]byte(`{"status":"ok"}`))
+		case r.URL.Path == "/health/summary":
+			w.Write([]byte(`{"status":"degraded","reasons":["docker success rate 80%"]}`))
+		case r.URL.Path == "/https://raw.githubusercontent.com/o/r/v1/VERSION":
+			w.Write([]byte("v1\n"))
+		case r.URL.Path == "/token":
+			if !strings.HasPrefix(r.URL.Query().Get("scope"), "repository:org/") || r.URL.Query().Get("service") != "hubproxy" {
+				t.Errorf("token query = %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"access_token":"pull-token"}`))
+		case r.Header.Get("Authorization") != "Bearer pull-token":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="hubproxy"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			upstream.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	image, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct := httptest.NewServer(upstream)
+	defer direct.Close()
+	ref, _ := name.ParseReference(strings.TrimPrefix(direct.URL, "http://") + "/org/app:v1")
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	c := New(server.URL)
+
+	summary, err := c.Health(ctx)
+	if err != nil || summary.Status != "degraded" || len(summary.Reasons) != 1 {
+		t.Fatalf("Health = %+v, %v", summary, err)
+	}
+
+	fetch, err := c.FetchFile(ctx, "https://raw.githubusercontent.com/o/r/v1/VERSION", 1024)
+	if err != nil || fetch.Size != 3 || fetch.SHA256 != "2d27fbdf4e8ca207afbfa388ca9172fbcc6c70e534af2476b3b704f87debadcf" {
+		t.Fatalf("FetchFile = %+v, %v", fetch, err)
+	}
+	if fetch.RateLimit == nil || fetch.RateLimit.Limit != 100 || fetch.RateLimit.Remaining != 99 || fetch.RateLimit.ResetSeconds != 36 {
+		t.Fatalf("rate limit = %+v", fetch.RateLimit)
+	}
+	if _, err = c.FetchFile(ctx, "https://raw.githubusercontent.com/o/r/v1/VERSION", 2); err == nil {
+		t.Fatal("oversized file accepted")
+	}
+
+	token, err := c.RegistryToken(ctx, "org/app:v1")
+	if err != nil || token != "pull-token" {
+		t.Fatalf("RegistryToken = %q, %v", token, err)
+	}
+
+	head, err := c.HeadManifest(ctx, "org/app:v1")
+	want, _ := image.Digest()
+	if err != nil || head.Digest != want.String() || head.Size <= 0 || head.RateLimit == nil {
+		t.Fatalf("HeadManifest = %+v, %v", head, err)
+	}
+	if pinned, err := c.HeadManifest(ctx, "org/app@"+head.Digest); err != nil || pinned.Digest != head.Digest {
+		t.Fatalf("HeadManifest(digest) = %+v, %v", pinned, err)
+	}
+	var apiErr *Error
+	if _, err = c.HeadManifest(ctx, "org/missing:v1"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing manifest error = %v", err)
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": ping\n\nevent:progress\ndata:{\"a\":1}\n\nevent: done\ndata: line1\ndata: line2\n\nevent:progress\ndata:ignored\n\n"
+	var got []string
+	err := readEvents(strings.NewReader(stream), func(event, data string) (bool, error) {
+		got = append(got, event+"="+data)
+		return event != "done", nil
+	})
+	if err != nil || len(got) != 2 || got[0] != `progress={"a":1}` || got[1] != "done=line1\nline2" {
+		t.Fatalf("events = %q, %v", got, err)
+	}
+}
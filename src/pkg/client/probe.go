@@ -0,0 +1,470 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// RedirectHop 重定向链中的一跳
+type RedirectHop struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// URLProbe 经实例HEAD请求代理链接的结果。Size为-1表示响应没有给出大小；
+// Redirects为实例返回给客户端的重定向，Upstream为实例访问上游时经过的各跳，仅在使用管理令牌时返回
+type URLProbe struct {
+	URL         string        `json:"url"`
+	Status      int           `json:"status"`
+	Size        int64         `json:"size"`
+	ContentType string        `json:"content_type,omitempty"`
+	Cache       string        `json:"cache,omitempty"`
+	Hops        int           `json:"hops,omitempty"`
+	Redirects   []RedirectHop `json:"redirects,omitempty"`
+	Upstream    []RedirectHop `json:"upstream,omitempty"`
+}
+
+// ProbeURL 经实例对代理链接发送HEAD请求，非2xx状态不视为错误，由调用方检查Status。
+// 设置了管理令牌时附带debug=1，以取得实例访问上游的完整重定向链
+func (c *Client) ProbeURL(ctx context.Context, target string) (*URLProbe, error) {
+	proxyURL := c.baseURL + "/" + strings.TrimLeft(target, "/")
+	if c.adminToken != "" {
+		if strings.Contains(proxyURL, "?") {
+			proxyURL += "&debug=1"
+		} else {
+			proxyURL += "?debug=1"
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, proxyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.adminToken != "" {
+		req.Header.Set("X-Admin-Token", c.adminToken)
+	}
+
+	probe := &URLProbe{URL: target, Size: -1}
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("hubproxy: 重定向次数过多")
+		}
+		previous := via[len(via)-1]
+		probe.Redirects = append(probe.Redirects, RedirectHop{Method: previous.Method, URL: previous.URL.String(), Status: next.Response.StatusCode})
+		for _, key := range []string{"Authorization", "X-Admin-Token"} {
+			if value := previous.Header.Get(key); value != "" && next.URL.Host == req.URL.Host {
+				next.Header.Set(key, value)
+			}
+		}
+		return nil
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	probe.Status = resp.StatusCode
+	probe.Size = resp.ContentLength
+	probe.ContentType = resp.Header.Get("Content-Type")
+	probe.Cache = resp.Header.Get("X-Cache")
+	fmt.Sscan(resp.Header.Get("X-Proxy-Hops"), &probe.Hops)
+	if chain := resp.Header.Get("X-Proxy-Redirect-Chain"); chain != "" {
+		json.Unmarshal([]byte(chain), &probe.Upstream)
+	}
+	return probe, nil
+}
+
+// ImageLayer 镜像层
+type ImageLayer struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type"`
+}
+
+// ImagePlatform 单一平台的镜像，Size为配置和各层大小之和
+type ImagePlatform struct {
+	Platform  string       `json:"platform"`
+	Digest    string       `json:"digest"`
+	MediaType string       `json:"media_type"`
+	Size      int64        `json:"size"`
+	Layers    []ImageLayer `json:"layers"`
+}
+
+// ImageProbe 经实例解析的镜像manifest，单平台镜像的Platforms只有一项且Platform可能为空
+type ImageProbe struct {
+	Reference string          `json:"reference"`
+	Digest    string          `json:"digest"`
+	MediaType string          `json:"media_type"`
+	Platforms []ImagePlatform `json:"platforms"`
+}
+
+// manifestAccept 请求manifest时接受的类型
+var manifestAccept = strings.Join([]string{
+	string(types.OCIImageIndex), string(types.DockerManifestList),
+	string(types.OCIManifestSchema1), string(types.DockerManifestSchema2),
+}, ", ")
+
+// ProbeImage 经实例的 /v2/ 接口解析镜像manifest，多平台镜像逐个解析各平台的层
+func (c *Client) ProbeImage(ctx context.Context, image string) (*ImageProbe, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("hubproxy: 镜像引用格式错误: %w", err)
+	}
+	session := &registrySession{client: c, repo: registryRepo(ref)}
+	mediaType, digest, body, err := session.manifest(ctx, ref.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	probe := &ImageProbe{Reference: image, Digest: digest, MediaType: string(mediaType)}
+
+	if !mediaType.IsIndex() {
+		platform, err := parseImageManifest(body)
+		if err != nil {
+			return nil, err
+		}
+		platform.Digest, platform.MediaType = digest, string(mediaType)
+		probe.Platforms = []ImagePlatform{*platform}
+		return probe, nil
+	}
+
+	index, err := v1.ParseIndexManifest(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("hubproxy: 解析镜像索引失败: %w", err)
+	}
+	for _, desc := range index.Manifests {
+		// 构建证明等附件的平台为unknown/unknown，不是可运行的镜像
+		if desc.Platform != nil && desc.Platform.OS == "unknown" {
+			continue
+		}
+		_, _, body, err := session.manifest(ctx, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		platform, err := parseImageManifest(body)
+		if err != nil {
+			return nil, err
+		}
+		platform.Digest, platform.MediaType = desc.Digest.String(), string(desc.MediaType)
+		if desc.Platform != nil {
+			platform.Platform = desc.Platform.String()
+		}
+		probe.Platforms = append(probe.Platforms, *platform)
+	}
+	return probe, nil
+}
+
+// ManifestHead 经实例HEAD请求manifest的结果，RateLimit为响应附带的限流头
+type ManifestHead struct {
+	Reference string            `json:"reference"`
+	Digest    string            `json:"digest"`
+	MediaType string            `json:"media_type"`
+	Size      int64             `json:"size"`
+	RateLimit *RateLimitHeaders `json:"rate_limit,omitempty"`
+}
+
+// HeadManifest 经实例的 /v2/ 接口对镜像manifest发送HEAD请求，不下载manifest内容
+func (c *Client) HeadManifest(ctx context.Context, image string) (*ManifestHead, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("hubproxy: 镜像引用格式错误: %w", err)
+	}
+	session := &registrySession{client: c, repo: registryRepo(ref)}
+	resp, err := session.send(ctx, http.MethodHead, c.baseURL+"/v2/"+session.repo+"/manifests/"+ref.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &ManifestHead{
+		Reference: image,
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		MediaType: strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]),
+		Size:      resp.ContentLength,
+		RateLimit: parseRateLimitHeaders(resp.Header),
+	}, nil
+}
+
+// RegistryToken 按实例 /v2/ 的Bearer质询获取拉取镜像所在仓库的令牌；
+// 实例允许匿名访问 /v2/ 时直接向实例的 /token 请求Docker Hub的令牌
+func (c *Client) RegistryToken(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("hubproxy: 镜像引用格式错误: %w", err)
+	}
+	session := &registrySession{client: c, repo: registryRepo(ref)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v2/", nil)
+	if err != nil {
+		return "", err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(strings.ToLower(challenge), "bearer "):
+		resp.Body.Close()
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		resp.Body.Close()
+		challenge = `Bearer realm="` + c.baseURL + `/token",service="registry.docker.io"`
+	default:
+		return "", registryError(resp)
+	}
+	token, err := session.fetchToken(ctx, challenge)
+	if err == nil && token == "" {
+		err = fmt.Errorf("hubproxy: 令牌响应中没有token")
+	}
+	return token, err
+}
+
+// registryRepo 返回镜像在实例 /v2/ 下的仓库路径，非Docker Hub镜像带上Registry域名
+func registryRepo(ref name.Reference) string {
+	repo := ref.Context().RepositoryStr()
+	if registry := ref.Context().RegistryStr(); registry != name.DefaultRegistry {
+		repo = registry + "/" + repo
+	}
+	return repo
+}
+
+// FileFetch 经实例下载代理链接的结果，SHA256为实际收到内容的摘要
+type FileFetch struct {
+	URL         string            `json:"url"`
+	Status      int               `json:"status"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	ContentType string            `json:"content_type,omitempty"`
+	Cache       string            `json:"cache,omitempty"`
+	RateLimit   *RateLimitHeaders `json:"rate_limit,omitempty"`
+}
+
+// FetchFile 经实例下载代理链接并计算内容摘要，最多读取maxSize字节，超出时返回错误；非2xx时返回*Error
+func (c *Client) FetchFile(ctx context.Context, target string, maxSize int64) (*FileFetch, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/"+strings.TrimLeft(target, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, registryError(resp)
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("hubproxy: 文件超过 %d 字节", maxSize)
+	}
+	if resp.ContentLength >= 0 && size != resp.ContentLength {
+		return nil, fmt.Errorf("hubproxy: 收到 %d 字节，Content-Length为 %d", size, resp.ContentLength)
+	}
+	return &FileFetch{
+		URL:         target,
+		Status:      resp.StatusCode,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		ContentType: resp.Header.Get("Content-Type"),
+		Cache:       resp.Header.Get("X-Cache"),
+		RateLimit:   parseRateLimitHeaders(resp.Header),
+	}, nil
+}
+
+// parseImageManifest 解析单平台manifest的各层大小
+func parseImageManifest(body []byte) (*ImagePlatform, error) {
+	manifest, err := v1.ParseManifest(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("hubproxy: 解析镜像manifest失败: %w", err)
+	}
+	platform := &ImagePlatform{Size: manifest.Config.Size}
+	for _, layer := range manifest.Layers {
+		platform.Layers = append(platform.Layers, ImageLayer{Digest: layer.Digest.String(), Size: layer.Size, MediaType: string(layer.MediaType)})
+		platform.Size += layer.Size
+	}
+	return platform, nil
+}
+
+// registrySession 访问实例 /v2/ 接口的会话，收到Bearer质询时按质询获取令牌并在后续请求中复用
+type registrySession struct {
+	client *Client
+	repo   string
+	bearer string
+}
+
+// manifest 获取manifest，返回媒体类型、摘要和内容。响应没有Docker-Content-Digest时按内容计算摘要
+func (s *registrySession) manifest(ctx context.Context, reference string) (types.MediaType, string, []byte, error) {
+	resp, err := s.get(ctx, s.client.baseURL+"/v2/"+s.repo+"/manifests/"+reference)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	mediaType := types.MediaType(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if mediaType == "" || mediaType == "application/json" {
+		var probe struct {
+			MediaType types.MediaType `json:"mediaType"`
+		}
+		json.Unmarshal(body, &probe)
+		mediaType = probe.MediaType
+	}
+	return mediaType, digest, body, nil
+}
+
+// get 发送GET请求，401且带Bearer质询时获取令牌后重试一次，非2xx时返回*Error
+func (s *registrySession) get(ctx context.Context, target string) (*http.Response, error) {
+	return s.send(ctx, http.MethodGet, target)
+}
+
+// send 发送请求，401且带Bearer质询时获取令牌后重试一次，非2xx时返回*Error
+func (s *registrySession) send(ctx context.Context, method, target string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		switch {
+		case s.bearer != "":
+			req.Header.Set("Authorization", "Bearer "+s.bearer)
+		case s.client.token != "":
+			req.Header.Set("Authorization", "Bearer "+s.client.token)
+		}
+
+		resp, err := s.client.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			resp.Body.Close()
+			if s.bearer, err = s.fetchToken(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, registryError(resp)
+	}
+}
+
+// fetchToken 按Bearer质询的realm和service获取拉取当前仓库的令牌
+func (s *registrySession) fetchToken(ctx context.Context, challenge string) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("hubproxy: 认证质询缺少realm: %s", challenge)
+	}
+	query := url.Values{"scope": {"repository:" + s.repo + ":pull"}}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if s.client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.client.token)
+	}
+	resp, err := s.client.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", registryError(resp)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return token.Token, nil
+}
+
+// parseChallenge 解析 Bearer realm="...",service="..." 形式的质询参数
+func parseChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	_, rest, _ := strings.Cut(challenge, " ")
+	for _, part := range strings.Split(rest, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+// registryError 将Registry的错误响应转换为*Error，兼容 {"errors":[...]} 和实例自身的 {"error":...} 格式
+func registryError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Code    string `json:"code"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &body)
+	switch {
+	case len(body.Errors) > 0:
+		apiErr.Code, apiErr.Message = body.Errors[0].Code, body.Errors[0].Message
+	case body.Error != "":
+		apiErr.Code, apiErr.Message = body.Code, body.Error
+	case body.Message != "":
+		apiErr.Code, apiErr.Message = body.Code, body.Message
+	default:
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	// HEAD请求的错误响应没有响应体
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/pkg/client"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// 自检项的结果
+const (
+	selftestPass = "pass"
+	selftestFail = "fail"
+	selftestSkip = "skip"
+)
+
+// selftestFileLimit 自检下载文件的大小上限
+const selftestFileLimit = 1 << 20
+
+// selftestOptions 自检使用的目标，离线环境可改为内网的固定文件和镜像
+type selftestOptions struct {
+	GitHubFile   string
+	GitHubSHA256 string
+	Image        string
+	Skip         map[string]bool
+}
+
+// selftestResult 单个自检项的结果
+type selftestResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// selftestReport selftest命令的输出，--json时原样输出
+type selftestReport struct {
+	Target  string           `json:"target"`
+	Results []selftestResult `json:"results"`
+	OK      bool             `json:"ok"`
+}
+
+// runSelftest 实现 hubproxy selftest：对运行中的实例依次检查健康状态、访问检查演练、GitHub文件下载、
+// 令牌获取、manifest解析和限流头，输出结果表，有失败项时返回非0退出码
+func runSelftest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	target := flags.String("target", "", "实例地址，如 https://proxy.example.com/hub，默认按配置文件的监听端口和basePath")
+	token := flags.String("token", "", "私有实例的访问令牌")
+	githubFile := flags.String("github-file", "https://raw.githubusercontent.com/golang/go/go1.22.0/VERSION", "经代理下载的小文件")
+	githubSHA256 := flags.String("github-sha256", "", "下载文件的SHA-256期望值，为空时只校验内容长度")
+	image := flags.String("image", "library/hello-world:latest", "经 /v2/ 解析的镜像")
+	skip := flags.String("skip", "", "跳过的检查项，逗号分隔: health,access,github,token,manifest,ratelimit")
+	asJSON := flags.Bool("json", false, "以JSON输出")
+	timeout := flags.Duration("timeout", 60*time.Second, "整个自检的超时")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "用法: hubproxy selftest [选项]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return checkExitUsage
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return checkExitUsage
+	}
+
+	if *target == "" {
+		if err := config.LoadConfig(); err != nil {
+			fmt.Fprintf(stderr, "配置加载失败，使用默认配置: %v\n", err)
+		}
+		*target = defaultCheckAddr(config.GetConfig())
+	}
+	options := selftestOptions{
+		GitHubFile:   *githubFile,
+		GitHubSHA256: strings.ToLower(strings.TrimSpace(*githubSHA256)),
+		Image:        *image,
+		Skip:         make(map[string]bool),
+	}
+	for _, item := range strings.Split(*skip, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			options.Skip[item] = true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report := runSelftestSuite(ctx, client.New(*target, client.WithToken(*token)), options)
+	report.Target = *target
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printSelftestReport(stdout, report)
+	}
+	if !report.OK {
+		return checkExitFailed
+	}
+	return checkExitOK
+}
+
+// runSelftestSuite 按顺序执行各检查项，单项失败不影响后续检查
+func runSelftestSuite(ctx context.Context, c *client.Client, options selftestOptions) *selftestReport {
+	report := &selftestReport{OK: true}
+	var rateLimit *client.RateLimitHeaders
+	run := func(name string, check func() (string, string)) {
+		if options.Skip[name] {
+			report.Results = append(report.Results, selftestResult{Name: name, Status: selftestSkip, Detail: "--skip"})
+			return
+		}
+		start := time.Now()
+		status, detail := check()
+		report.Results = append(report.Results, selftestResult{Name: name, Status: status, Detail: detail, DurationMs: time.Since(start).Milliseconds()})
+		if status == selftestFail {
+			report.OK = false
+		}
+	}
+
+	run("health", func() (string, string) {
+		summary, err := c.Health(ctx)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		detail := "状态 " + summary.Status
+		if len(summary.Reasons) > 0 {
+			detail += ": " + strings.Join(summary.Reasons, "; ")
+		}
+		if summary.Status == "down" {
+			return selftestFail, detail
+		}
+		return selftestPass, detail
+	})
+
+	run("access", func() (string, string) {
+		var denied []string
+		for _, check := range []struct{ kind, target string }{
+			{client.AccessKindURL, options.GitHubFile},
+			{client.AccessKindImage, options.Image},
+		} {
+			result, err := c.CheckAccess(ctx, check.kind, check.target)
+			if err != nil {
+				return selftestFail, err.Error()
+			}
+			if !result.Allowed {
+				denied = append(denied, check.target+" ("+result.Reason+")")
+			}
+		}
+		if len(denied) > 0 {
+			return selftestFail, "访问被拒绝: " + strings.Join(denied, ", ")
+		}
+		return selftestPass, "文件和镜像均允许代理"
+	})
+
+	run("github", func() (string, string) {
+		fetch, err := c.FetchFile(ctx, options.GitHubFile, selftestFileLimit)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		rateLimit = fetch.RateLimit
+		detail := fmt.Sprintf("%s, sha256 %s", formatCheckSize(fetch.Size), fetch.SHA256)
+		if options.GitHubSHA256 == "" {
+			return selftestPass, detail + " (未指定期望值)"
+		}
+		if fetch.SHA256 != options.GitHubSHA256 {
+			return selftestFail, fmt.Sprintf("sha256 %s，期望 %s", fetch.SHA256, options.GitHubSHA256)
+		}
+		return selftestPass, detail
+	})
+
+	run("token", func() (string, string) {
+		token, err := c.RegistryToken(ctx, options.Image)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		return selftestPass, fmt.Sprintf("取得令牌 (%d 字节)", len(token))
+	})
+
+	run("manifest", func() (string, string) {
+		head, err := c.HeadManifest(ctx, options.Image)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		if rateLimit == nil {
+			rateLimit = head.RateLimit
+		}
+		if head.Digest == "" {
+			return selftestFail, "响应缺少Docker-Content-Digest"
+		}
+		// 按tag解析出的digest再次HEAD，确认按digest访问同样可用
+		ref, err := name.ParseReference(options.Image)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		pinned, err := c.HeadManifest(ctx, ref.Context().Digest(head.Digest).String())
+		if err != nil {
+			return selftestFail, "按digest访问失败: " + err.Error()
+		}
+		if pinned.Digest != head.Digest {
+			return selftestFail, fmt.Sprintf("按digest访问得到 %s，期望 %s", pinned.Digest, head.Digest)
+		}
+		return selftestPass, fmt.Sprintf("%s %s", head.Digest, head.MediaType)
+	})
+
+	run("ratelimit", func() (string, string) {
+		if rateLimit != nil {
+			return selftestPass, formatSelftestRateLimit(rateLimit)
+		}
+		// 前面的请求失败时改用能力探测接口的响应检查；白名单等不限流的调用方不附加限流头
+		caps, err := c.Capabilities(ctx)
+		if err != nil {
+			return selftestFail, err.Error()
+		}
+		if caps.RateLimitHeaders != nil {
+			return selftestPass, formatSelftestRateLimit(caps.RateLimitHeaders)
+		}
+		if caps.RateLimit == nil || caps.RateLimit.Unlimited {
+			return selftestSkip, "实例对本机不限流"
+		}
+		return selftestFail, "响应没有RateLimit-*头"
+	})
+
+	return report
+}
+
+// formatSelftestRateLimit 描述响应附带的限流额度
+func formatSelftestRateLimit(headers *client.RateLimitHeaders) string {
+	return fmt.Sprintf("剩余 %d/%d，%d 秒后回满", headers.Remaining, headers.Limit, headers.ResetSeconds)
+}
+
+// printSelftestReport 以表格输出自检结果
+func printSelftestReport(w io.Writer, report *selftestReport) {
+	fmt.Fprintf(w, "实例: %s\n", report.Target)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "检查项\t结果\t耗时\t说明")
+	for _, result := range report.Results {
+		fmt.Fprintf(table, "%s\t%s\t%dms\t%s\n", result.Name, strings.ToUpper(result.Status), result.DurationMs, result.Detail)
+	}
+	table.Flush()
+	if report.OK {
+		fmt.Fprintln(w, "自检通过")
+	} else {
+		fmt.Fprintln(w, "自检失败")
+	}
+}
@@ -0,0 +1,505 @@
+// Package server 组装HubProxy的中间件、路由和HTTP服务，main只负责加载配置和处理信号，
+// 其他Go程序也可以通过 New 和 Run 嵌入代理服务
+package server
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/handlers"
+	"github.com/7alva7/hubproxy/src/internal/accesscontrol"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/7alva7/hubproxy/src/internal/dockerproxy"
+	"github.com/7alva7/hubproxy/src/internal/githubproxy"
+	"github.com/7alva7/hubproxy/src/internal/ratelimit"
+	"github.com/7alva7/hubproxy/src/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+//go:embed public/* admin/*
+var staticFiles embed.FS
+
+// shutdownTimeout 停止服务时等待进行中请求完成的最长时间
+const shutdownTimeout = 30 * time.Second
+
+// Server HubProxy服务实例
+type Server struct {
+	// Version 在启动日志和 /ready 中显示的版本号
+	Version string
+
+	// shared 统计、内存保护、调度、审计等由代理、限流器和处理器共用的状态
+	shared   *utils.Components
+	clients  *utils.HTTPClients
+	limiter  *ratelimit.Limiter
+	docker   *dockerproxy.Proxy
+	github   *githubproxy.Proxy
+	handlers *handlers.Handlers
+	// reloader 热重载时按注册顺序通知上述组件
+	reloader *config.Reloader
+
+	httpServer *http.Server
+	// adminServer 配置了admin.listen时单独提供管理接口、指标和pprof，否则为nil
+	adminServer *http.Server
+	startTime   time.Time
+	// requests 两个端口上请求上下文的父上下文，停止服务时等待超时后取消，仍在进行的上游请求随之结束
+	requests       context.Context
+	cancelRequests context.CancelFunc
+}
+
+// New 使用cfg创建上游客户端、缓存、限流器和各代理组件，注入处理器并注册路由，cfg为nil时使用已加载的配置。
+// 配置和组件只属于返回的Server，同一进程中的多个Server互不影响，热重载经 Reload 通知它们
+func New(cfg *config.AppConfig) (*Server, error) {
+	if cfg == nil {
+		cfg = config.GetConfig()
+	}
+	if cfg.Server.Port < 0 || cfg.Server.Port > 65535 {
+		return nil, fmt.Errorf("监听端口无效: %d", cfg.Server.Port)
+	}
+
+	// 内存软上限和日志合并属于整个进程
+	utils.InitMemoryGuard(cfg)
+	utils.ConfigureLogDedup(cfg)
+	shared := utils.NewComponents(cfg)
+	if err := shared.Signing.Load(); err != nil {
+		return nil, fmt.Errorf("加载签名密钥失败: %w", err)
+	}
+	s := &Server{
+		Version:   "dev",
+		shared:    shared,
+		clients:   utils.NewHTTPClients(shared.Config, shared.Stats),
+		limiter:   ratelimit.New(shared.Config, shared.Adaptive, shared.IPs, shared.Stats),
+		reloader:  config.NewReloader(shared.Config),
+		startTime: time.Now(),
+	}
+	s.reloader.Register(s.clients, s.limiter, utils.MemoryLimitReloader(), utils.LogDedupReloader(), shared.Signing, shared.Screener.FeedReloader())
+	shared.Adaptive.Start(shared.Stats)
+
+	cache := utils.NewUniversalCache(shared.Background.Context(), shared.Config)
+	hot := utils.NewHotCache(shared.Config)
+	shared.Memory.TrackHotCache(hot)
+	access := accesscontrol.New(shared.Config)
+	docker, err := dockerproxy.New(cache, hot, access, s.clients, shared)
+	if err != nil {
+		return nil, fmt.Errorf("初始化Docker代理失败: %w", err)
+	}
+	s.docker = docker
+	s.github = githubproxy.New(cache, hot, access, s.clients, shared)
+	s.handlers = handlers.New(handlers.Deps{
+		Components: shared,
+		Cache:      cache,
+		Hot:        hot,
+		Access:     access,
+		Clients:    s.clients,
+		Limiter:    s.limiter,
+		Docker:     s.docker,
+		GitHub:     s.github,
+		Reloader:   s.reloader,
+	})
+	s.docker.AuthToken = s.handlers.HandleAuthToken
+	s.reloader.Register(s.docker)
+	s.reloader.Register(s.handlers.Reloadables()...)
+
+	s.requests, s.cancelRequests = context.WithCancel(context.Background())
+	baseContext := func(net.Listener) context.Context { return s.requests }
+	s.httpServer = newHTTPServer(cfg, s.buildRouter(cfg))
+	s.httpServer.BaseContext = baseContext
+	if cfg.Admin.Listen != "" {
+		s.adminServer = newHTTPServer(cfg, s.buildAdminRouter())
+		s.adminServer.Addr = cfg.Admin.Listen
+		s.adminServer.BaseContext = baseContext
+	}
+	return s, nil
+}
+
+// Reload 重新加载配置文件并通知各组件，配置无效时保留原配置并返回错误
+func (s *Server) Reload() error {
+	return s.reloader.Reload()
+}
+
+// Handler 返回服务的HTTP处理器，可挂载到调用方自己的http.Server
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// AdminHandler 返回管理端口的HTTP处理器，未配置admin.listen时为nil
+func (s *Server) AdminHandler() http.Handler {
+	if s.adminServer == nil {
+		return nil
+	}
+	return s.adminServer.Handler
+}
+
+// Run 监听配置的地址并提供服务，直到ctx取消后优雅停止，或监听失败时返回错误
+func (s *Server) Run(ctx context.Context) error {
+	cfg := s.shared.Config.Get()
+	fmt.Printf("HubProxy 启动成功\n")
+	fmt.Printf("监听地址: %s\n", listenAddr(cfg))
+	if s.adminServer != nil {
+		fmt.Printf("管理端口: %s\n", s.adminServer.Addr)
+	}
+	fmt.Printf("限流配置: %d请求/%g小时\n", cfg.RateLimit.RequestLimit, cfg.RateLimit.PeriodHours)
+	if cfg.Server.EnableH2C {
+		fmt.Printf("H2c: 已启用\n")
+	}
+	fmt.Printf("版本号: %s\n", s.Version)
+	fmt.Printf("项目地址: https://github.com/sky22333/hubproxy\n")
+
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("启动服务失败: %w", err)
+	}
+	var adminListener net.Listener
+	if s.adminServer != nil {
+		if adminListener, err = net.Listen("tcp", s.adminServer.Addr); err != nil {
+			listener.Close()
+			return fmt.Errorf("启动管理端口失败: %w", err)
+		}
+	}
+	s.docker.StartTokenWarmup()
+	s.shared.Screener.StartFeed(s.shared.Background.Context(), s.clients)
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- s.httpServer.Serve(listener)
+	}()
+	if adminListener != nil {
+		go func() {
+			serveErr <- s.adminServer.Serve(adminListener)
+		}()
+	}
+
+	// 配置、HTTP客户端、限流器、Docker代理和监听器均已就绪
+	utils.NotifyReady()
+
+	watchdogCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go utils.RunWatchdog(watchdogCtx, utils.WatchdogInterval(), func(ctx context.Context) bool {
+		return checkLocalHealth(ctx, listener.Addr())
+	})
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		// 任一端口停止服务时一并关闭另一个
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+		s.Shutdown(shutdownCtx)
+		return fmt.Errorf("启动服务失败: %w", err)
+	case <-ctx.Done():
+		utils.NotifyStopping()
+		cancel()
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+		return s.Shutdown(shutdownCtx)
+	}
+}
+
+// Shutdown 停止接受新请求并取消复制、预热等后台任务，等待两个端口上进行中的请求完成后保存令牌用量；
+// ctx到期时取消仍未完成的请求
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shared.Background.Stop()
+	defer s.cancelRequests()
+
+	adminErr := make(chan error, 1)
+	if s.adminServer != nil {
+		go func() { adminErr <- s.adminServer.Shutdown(ctx) }()
+	} else {
+		adminErr <- nil
+	}
+	err := errors.Join(s.httpServer.Shutdown(ctx), <-adminErr)
+	s.shared.Tokens.Flush()
+	if err != nil {
+		return fmt.Errorf("停止服务失败: %w", err)
+	}
+	return nil
+}
+
+// readMethods 健康检查和静态页面同时响应HEAD，负载均衡和监控常用HEAD探测
+var readMethods = []string{http.MethodGet, http.MethodHead}
+
+// serveEmbedFile 返回内嵌的前端文件，支持HEAD和Range请求
+func serveEmbedFile(c *gin.Context, filename string) {
+	data, err := staticFiles.ReadFile(filename)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	contentType := "text/html; charset=utf-8"
+	if strings.HasSuffix(filename, ".ico") {
+		contentType = "image/x-icon"
+	}
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, filename, time.Time{}, bytes.NewReader(data))
+}
+
+// frontendEnabled 判断是否提供内嵌页面，server.enableFrontend 和 ui.enabled 任一关闭时都不提供
+func frontendEnabled(cfg *config.AppConfig) bool {
+	return cfg.Server.EnableFrontend && cfg.UI.Enabled
+}
+
+// servePage 返回内嵌页面或静态文件，每次请求时读取配置，热重载关闭前端后立即返回404
+func (s *Server) servePage(c *gin.Context, filename string) {
+	if !frontendEnabled(s.shared.Config.Get()) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	serveEmbedFile(c, filename)
+}
+
+// serveRoot 返回首页；无界面模式下配置了ui.headlessMessage时返回简短的JSON说明，否则返回404
+func (s *Server) serveRoot(c *gin.Context) {
+	cfg := s.shared.Config.Get()
+	if !cfg.UI.Enabled && cfg.UI.HeadlessMessage != "" {
+		c.JSON(http.StatusOK, gin.H{"service": "hubproxy", "message": cfg.UI.HeadlessMessage})
+		return
+	}
+	s.servePage(c, "public/index.html")
+}
+
+// accessLogFormatter 与gin默认格式相同的访问日志，时间按server.timezone显示，
+// 客户端IP按privacy.noIPLogging替换为摘要
+func (s *Server) accessLogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %s | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.In(utils.DisplayLocation(s.shared.Config.Get())).Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		s.shared.IPs.Identify(param.ClientIP, false),
+		param.Method,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
+
+// buildRouter 注册中间件和全部路由
+func (s *Server) buildRouter(cfg *config.AppConfig) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(utils.RequestConfigMiddleware(s.shared.Config))
+	router.Use(gin.LoggerWithFormatter(s.accessLogFormatter), gin.Recovery())
+
+	router.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		log.Printf("Panic 已恢复: %v", recovered)
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+	}))
+
+	router.Use(s.handlers.ActivityMiddleware())
+	router.Use(utils.CORSMiddleware(s.shared.Config))
+	router.Use(s.handlers.ProxyAuthMiddleware())
+	router.Use(ratelimit.Middleware(s.limiter))
+	router.Use(handlers.BodyLimitMiddleware(s.shared.Config))
+
+	s.initHealthRoutes(router)
+	s.initRobotsRoute(router)
+	s.handlers.InitActivityRoutes(router)
+	s.handlers.InitStatsRoutes(router)
+	s.handlers.InitHealthSummaryRoutes(router)
+	s.handlers.InitAuthRoutes(router)
+	s.handlers.InitCapabilitiesRoutes(router)
+	s.handlers.InitAccessCheckRoutes(router)
+	s.handlers.InitOpenAPIRoutes(router, s.Version)
+	s.handlers.InitImageTarRoutes(router)
+	if cfg.Admin.Listen == "" {
+		s.initAdminRoutes(router)
+	} else {
+		// 管理接口只在admin.listen上提供，公共端口明确返回404，不落到GitHub代理
+		for _, path := range []string{"/admin", "/admin/*path", "/metrics", "/debug/pprof/*path"} {
+			router.Any(path, func(c *gin.Context) { c.Status(http.StatusNotFound) })
+		}
+	}
+	s.handlers.InitImageCopyRoutes(router)
+	s.handlers.InitGitHubTreeRoutes(router)
+	s.handlers.InitVerifyRoutes(router)
+	s.handlers.InitBootstrapRoutes(router)
+	s.handlers.InitHistoryRoutes(router)
+	s.handlers.InitSigningRoutes(router)
+
+	router.Match(readMethods, "/", s.serveRoot)
+	router.Match(readMethods, "/public/*filepath", func(c *gin.Context) {
+		filepath := strings.TrimPrefix(c.Param("filepath"), "/")
+		s.servePage(c, "public/"+filepath)
+	})
+	for _, page := range []string{"images.html", "search.html", "browse.html", "favicon.ico"} {
+		filename := "public/" + page
+		router.Match(readMethods, "/"+page, func(c *gin.Context) { s.servePage(c, filename) })
+	}
+
+	s.handlers.RegisterSearchRoute(router)
+
+	router.Any("/token", s.docker.AuthHandler)
+	router.Any("/token/*path", s.docker.AuthHandler)
+	router.Any("/v2/*path", s.docker.RegistryHandler)
+	router.NoRoute(utils.RefererMiddleware(s.shared.Config), s.github.Handler)
+	s.handlers.InitRouteTableRoutes(router)
+
+	return router
+}
+
+// initAdminRoutes 注册管理接口、管理页面和指标路由，单端口时挂载在公共路由上
+func (s *Server) initAdminRoutes(router *gin.Engine) {
+	s.handlers.InitAdminRoutes(router)
+	router.Match(readMethods, "/admin/", s.handlers.AdminAuthMiddleware(), func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		serveEmbedFile(c, "admin/index.html")
+	})
+	s.handlers.InitMetricsRoutes(router)
+}
+
+// buildAdminRouter 管理端口的路由，与公共路由共用同一份组件状态和IP黑名单，另外提供需要管理令牌的pprof
+func (s *Server) buildAdminRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(utils.RequestConfigMiddleware(s.shared.Config))
+	router.Use(gin.LoggerWithFormatter(s.accessLogFormatter))
+	router.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		log.Printf("Panic 已恢复: %v", recovered)
+		utils.RespondError(c, http.StatusInternalServerError, utils.ErrCodeInternal)
+	}))
+	router.Use(ratelimit.BlacklistMiddleware(s.limiter))
+	router.Use(s.handlers.ProxyAuthMiddleware())
+
+	s.initAdminRoutes(router)
+	router.Any("/debug/pprof/*name", s.handlers.AdminAuthMiddleware(), func(c *gin.Context) {
+		switch c.Param("name") {
+		case "/cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "/profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "/symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "/trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			pprof.Index(c.Writer, c.Request)
+		}
+	})
+	return router
+}
+
+// listenAddr 返回监听地址，IPv6地址加方括号
+func listenAddr(cfg *config.AppConfig) string {
+	return net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+}
+
+// newHTTPServer 创建HTTP服务，ReadHeaderTimeout和ReadTimeout限制慢速发送请求头和请求体的连接
+func newHTTPServer(cfg *config.AppConfig, router http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              listenAddr(cfg),
+		ReadHeaderTimeout: utils.ParseTimeout(cfg.Server.ReadHeaderTimeout, 10*time.Second),
+		ReadTimeout:       utils.ParseTimeout(cfg.Server.ReadTimeout, 60*time.Second),
+		WriteTimeout:      30 * time.Minute,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	if cfg.Server.EnableH2C {
+		server.Handler = h2c.NewHandler(router, &http2.Server{
+			MaxConcurrentStreams:         250,
+			IdleTimeout:                  300 * time.Second,
+			MaxReadFrameSize:             4 << 20,
+			MaxUploadBufferPerConnection: 8 << 20,
+			MaxUploadBufferPerStream:     2 << 20,
+		})
+	} else {
+		server.Handler = router
+	}
+	return server
+}
+
+// initRobotsRoute 注册robots.txt，默认只允许收录首页
+func (s *Server) initRobotsRoute(router *gin.Engine) {
+	router.GET("/robots.txt", func(c *gin.Context) {
+		cfg := s.shared.Config.Get()
+		if !cfg.Robots.Enabled {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(cfg.Robots.Content))
+	})
+}
+
+// initHealthRoutes 注册健康检查和就绪状态路由
+func (s *Server) initHealthRoutes(router *gin.Engine) {
+	router.Match(readMethods, "/health", func(c *gin.Context) {
+		now := time.Now()
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "ok",
+			"time":      utils.FormatDisplayTime(s.shared.Config.Get(), now),
+			"time_unix": now.Unix(),
+		})
+	})
+	router.Match(readMethods, "/ready", func(c *gin.Context) {
+		cfg := s.shared.Config.Get()
+		uptime := time.Since(s.startTime)
+		c.JSON(http.StatusOK, gin.H{
+			"ready":           true,
+			"service":         "hubproxy",
+			"version":         s.Version,
+			"start_time":      utils.FormatDisplayTime(cfg, s.startTime),
+			"start_time_unix": s.startTime.Unix(),
+			"timezone":        utils.DisplayLocation(cfg).String(),
+			"uptime_sec":      uptime.Seconds(),
+			"uptime_human":    formatDuration(uptime),
+		})
+	})
+}
+
+// checkLocalHealth 通过回环地址请求 /health，确认HTTP服务仍能正常响应
+func checkLocalHealth(ctx context.Context, addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host := tcpAddr.IP
+	if host == nil || host.IsUnspecified() {
+		host = net.IPv4(127, 0, 0, 1)
+		if tcpAddr.IP != nil && tcpAddr.IP.To4() == nil {
+			host = net.IPv6loopback
+		}
+	}
+
+	target := "http://" + net.JoinHostPort(host.String(), strconv.Itoa(tcpAddr.Port)) + "/health"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// formatDuration 以中文格式显示运行时长
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d秒", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%d分钟%d秒", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%d小时%d分钟", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	return fmt.Sprintf("%d天%d小时", days, hours)
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// accelChunkAttempts 单个分块的最大尝试次数
+const accelChunkAttempts = 3
+
+// chunkResult 分块下载结果
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// ParallelRangeReader 将已知长度的下载拆分为多个Range请求并发拉取，按顺序输出。
+// 已下载未输出的分块与进行中的请求合计不超过2倍连接数，分块失败时改为从当前位置单连接续传
+type ParallelRangeReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	client    *http.Client
+	template  *http.Request
+	first     io.Closer
+	validator string
+	size      int64
+	chunkSize int64
+
+	chunks   []chan chunkResult
+	window   chan struct{}
+	next     int
+	current  []byte
+	offset   int64
+	fallback io.ReadCloser
+}
+
+// AccelEligible 判断上游响应是否可以使用并发分块下载
+func AccelEligible(req *http.Request, resp *http.Response, minSize int64) bool {
+	return req.Method == http.MethodGet &&
+		req.Header.Get("Range") == "" &&
+		resp.StatusCode == http.StatusOK &&
+		resp.ContentLength >= minSize && resp.ContentLength > 0 &&
+		strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") &&
+		resp.Header.Get("Content-Encoding") == ""
+}
+
+// NewParallelRangeReader 创建并发分块读取器，第一个分块直接复用first的响应体
+func NewParallelRangeReader(client *http.Client, req *http.Request, first *http.Response, connections int, chunkSize int64) *ParallelRangeReader {
+	ctx, cancel := context.WithCancel(req.Context())
+	size := first.ContentLength
+	count := int((size + chunkSize - 1) / chunkSize)
+
+	r := &ParallelRangeReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		client:    client,
+		template:  req,
+		first:     first.Body,
+		validator: responseValidator(first.Header),
+		size:      size,
+		chunkSize: chunkSize,
+		chunks:    make([]chan chunkResult, count),
+		window:    make(chan struct{}, 2*connections),
+	}
+	for i := range r.chunks {
+		r.chunks[i] = make(chan chunkResult, 1)
+	}
+
+	go r.schedule(first.Body, connections)
+	return r
+}
+
+// responseValidator 返回用于If-Range的校验值，优先使用ETag
+func responseValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// chunkBounds 返回分块的起止位置(含)
+func (r *ParallelRangeReader) chunkBounds(index int) (int64, int64) {
+	start := int64(index) * r.chunkSize
+	return start, min(start+r.chunkSize, r.size) - 1
+}
+
+// schedule 按顺序启动分块下载，窗口已满时等待读取方消费
+func (r *ParallelRangeReader) schedule(firstBody io.ReadCloser, connections int) {
+	workers := make(chan struct{}, connections)
+	for i := range r.chunks {
+		select {
+		case r.window <- struct{}{}:
+		case <-r.ctx.Done():
+			return
+		}
+		select {
+		case workers <- struct{}{}:
+		case <-r.ctx.Done():
+			return
+		}
+
+		go func(index int) {
+			defer func() { <-workers }()
+			if index == 0 {
+				r.chunks[0] <- r.readFirstChunk(firstBody)
+				return
+			}
+			r.chunks[index] <- r.fetchChunk(index)
+		}(i)
+	}
+}
+
+// readFirstChunk 从原始响应体读取第一个分块，读取后关闭原始响应
+func (r *ParallelRangeReader) readFirstChunk(body io.ReadCloser) chunkResult {
+	defer body.Close()
+	_, end := r.chunkBounds(0)
+	data := make([]byte, end+1)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return chunkResult{err: err}
+	}
+	return chunkResult{data: data}
+}
+
+// rangeRequest 构建带Range和If-Range的请求，If-Range保证内容变化时不会拼接出错误数据
+func (r *ParallelRangeReader) rangeRequest(ctx context.Context, rangeValue string) *http.Request {
+	req := r.template.Clone(ctx)
+	req.Body = nil
+	req.ContentLength = 0
+	req.Header.Set("Accept-Encoding", "identity")
+	for _, header := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		req.Header.Del(header)
+	}
+	if rangeValue != "" {
+		req.Header.Set("Range", rangeValue)
+		if r.validator != "" {
+			req.Header.Set("If-Range", r.validator)
+		}
+	}
+	return req
+}
+
+// fetchChunk 下载单个分块，失败时重试
+func (r *ParallelRangeReader) fetchChunk(index int) chunkResult {
+	start, end := r.chunkBounds(index)
+	var err error
+	for attempt := 0; attempt < accelChunkAttempts; attempt++ {
+		var data []byte
+		if data, err = r.fetchRange(start, end); err == nil {
+			return chunkResult{data: data}
+		}
+		if r.ctx.Err() != nil {
+			break
+		}
+	}
+	return chunkResult{err: fmt.Errorf("分块 %d-%d 下载失败: %w", start, end, err)}
+}
+
+func (r *ParallelRangeReader) fetchRange(start, end int64) ([]byte, error) {
+	resp, err := r.client.Do(r.rangeRequest(r.ctx, fmt.Sprintf("bytes=%d-%d", start, end)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("Range请求失败，状态码 %d", resp.StatusCode)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", start, end, r.size); resp.Header.Get("Content-Range") != want {
+		return nil, fmt.Errorf("Content-Range不匹配: %s", resp.Header.Get("Content-Range"))
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// startFallback 停止并发下载，从当前位置改用单个请求续传
+// 上游不接受Range时重新完整下载并跳过已输出的部分
+func (r *ParallelRangeReader) startFallback() error {
+	r.cancel()
+
+	rangeValue := ""
+	if r.offset > 0 {
+		rangeValue = fmt.Sprintf("bytes=%d-", r.offset)
+	}
+	resp, err := r.client.Do(r.rangeRequest(r.template.Context(), rangeValue))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && rangeValue != "":
+		if want := fmt.Sprintf("bytes %d-%d/%d", r.offset, r.size-1, r.size); resp.Header.Get("Content-Range") != want {
+			resp.Body.Close()
+			return fmt.Errorf("Content-Range不匹配: %s", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK && resp.ContentLength == r.size:
+		// 整个文件重新下载时必须确认内容未变化，否则跳过已输出部分会拼接出错误数据
+		if r.validator != "" && responseValidator(resp.Header) != r.validator {
+			resp.Body.Close()
+			return errors.New("上游文件已变化")
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("续传请求失败，状态码 %d", resp.StatusCode)
+	}
+
+	r.fallback = resp.Body
+	return nil
+}
+
+// Read 按顺序输出数据，保证输出与原始文件逐字节一致
+func (r *ParallelRangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.fallback != nil {
+		n, err := r.fallback.Read(p[:min(int64(len(p)), r.size-r.offset)])
+		r.offset += int64(n)
+		if errors.Is(err, io.EOF) && r.offset < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+
+	if len(r.current) == 0 {
+		var result chunkResult
+		select {
+		case result = <-r.chunks[r.next]:
+		case <-r.template.Context().Done():
+			return 0, r.template.Context().Err()
+		}
+		<-r.window
+		r.next++
+
+		if result.err != nil {
+			fmt.Printf("并发下载分块失败，改为单连接续传: %v\n", result.err)
+			if err := r.startFallback(); err != nil {
+				return 0, fmt.Errorf("%v，续传失败: %w", result.err, err)
+			}
+			return r.Read(p)
+		}
+		r.current = result.data
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+// Close 取消所有进行中的分块请求
+func (r *ParallelRangeReader) Close() error {
+	r.cancel()
+	r.first.Close()
+	if r.fallback != nil {
+		return r.fallback.Close()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func accelPayload(size int) []byte {
+	payload := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(payload)
+	return payload
+}
+
+// serveAccelPayload 支持Range的文件服务，rangeHook返回true时表示已自行处理该Range请求
+func serveAccelPayload(payload []byte, rangeHook func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	modified := time.Unix(1700000000, 0)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" && rangeHook != nil && rangeHook(w, r) {
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.bin", modified, bytes.NewReader(payload))
+	}))
+}
+
+func readAccelerated(t *testing.T, url string, connections int, chunkSize int64) ([]byte, error) {
+	t.Helper()
+	client := &http.Client{}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AccelEligible(req, resp, 1) {
+		resp.Body.Close()
+		t.Fatalf("response not eligible: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	reader := NewParallelRangeReader(client, req, resp, connections, chunkSize)
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestParallelRangeReaderMatchesPlainDownload(t *testing.T) {
+	payload := accelPayload(1<<20 + 12345)
+	var ranges atomic.Int32
+	server := serveAccelPayload(payload, func(w http.ResponseWriter, r *http.Request) bool {
+		ranges.Add(1)
+		return false
+	})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	accelerated, err := readAccelerated(t, server.URL, 4, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(accelerated) != sha256.Sum256(plain) {
+		t.Fatal("accelerated download differs from plain download")
+	}
+	if ranges.Load() == 0 {
+		t.Fatal("no range requests were made")
+	}
+}
+
+func TestParallelRangeReaderFallsBackOnChunkFailure(t *testing.T) {
+	payload := accelPayload(512*1024 + 7)
+	var resumed atomic.Bool
+	server := serveAccelPayload(payload, func(w http.ResponseWriter, r *http.Request) bool {
+		// 第三个分块始终失败，续传请求为 bytes=N- 形式
+		if r.Header.Get("Range") == "bytes=131072-196607" {
+			http.Error(w, "boom", http.StatusBadGateway)
+			return true
+		}
+		if strings.HasSuffix(r.Header.Get("Range"), "-") {
+			resumed.Store(true)
+		}
+		return false
+	})
+	defer server.Close()
+
+	accelerated, err := readAccelerated(t, server.URL, 3, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(accelerated) != sha256.Sum256(payload) {
+		t.Fatal("fallback download differs from original")
+	}
+	if !resumed.Load() {
+		t.Fatal("fallback did not resume from current offset")
+	}
+}
+
+func TestParallelRangeReaderFallsBackWhenRangesRejected(t *testing.T) {
+	payload := accelPayload(300*1024 + 1)
+	server := serveAccelPayload(payload, func(w http.ResponseWriter, r *http.Request) bool {
+		// 声明支持Range但实际返回完整内容
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Write(payload)
+		return true
+	})
+	defer server.Close()
+
+	accelerated, err := readAccelerated(t, server.URL, 4, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(accelerated, payload) {
+		t.Fatal("download differs when upstream rejects ranges")
+	}
+}
+
+func TestParallelRangeReaderRejectsChangedContent(t *testing.T) {
+	payload := accelPayload(256 * 1024)
+	changed := accelPayload(256 * 1024)
+	changed[len(changed)-1] ^= 0xff
+	server := serveAccelPayload(payload, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("ETag", `"v2"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Write(changed)
+		return true
+	})
+	defer server.Close()
+
+	accelerated, err := readAccelerated(t, server.URL, 2, 64*1024)
+	if err == nil {
+		t.Fatal("changed upstream content was spliced into the download")
+	}
+	if !bytes.Equal(accelerated, payload[:len(accelerated)]) {
+		t.Fatal("delivered bytes differ from the original prefix")
+	}
+}
+
+func TestAccelEligible(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/file", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, ContentLength: 100, Header: http.Header{"Accept-Ranges": {"bytes"}}}
+	if !AccelEligible(req, resp, 100) {
+		t.Fatal("eligible response rejected")
+	}
+	if AccelEligible(req, resp, 101) {
+		t.Fatal("small file accepted")
+	}
+
+	resp.Header.Set("Content-Encoding", "gzip")
+	if AccelEligible(req, resp, 1) {
+		t.Fatal("encoded response accepted")
+	}
+	resp.Header.Del("Content-Encoding")
+
+	req.Header.Set("Range", "bytes=0-10")
+	if AccelEligible(req, resp, 1) {
+		t.Fatal("client range request accepted")
+	}
+}
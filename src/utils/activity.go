@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ActivityEvent 一次已完成请求的动态记录
+type ActivityEvent struct {
+	Time       time.Time `json:"time"`
+	ClientIP   string    `json:"client_ip"`
+	Class      string    `json:"class"`
+	Target     string    `json:"target"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// ActivityBroadcaster 请求动态广播器，订阅者消费过慢时直接断开，不阻塞代理请求
+type ActivityBroadcaster struct {
+	mu             sync.Mutex
+	subscribers    map[chan ActivityEvent]struct{}
+	maxSubscribers int
+}
+
+// NewActivityBroadcaster 创建请求动态广播器
+func NewActivityBroadcaster(maxSubscribers int) *ActivityBroadcaster {
+	return &ActivityBroadcaster{
+		subscribers:    make(map[chan ActivityEvent]struct{}),
+		maxSubscribers: maxSubscribers,
+	}
+}
+
+// Subscribe 订阅请求动态，订阅数已满时返回false。被断开的订阅者会收到关闭的channel
+func (b *ActivityBroadcaster) Subscribe(buffer int) (<-chan ActivityEvent, func(), bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxSubscribers > 0 && len(b.subscribers) >= b.maxSubscribers {
+		return nil, nil, false
+	}
+
+	ch := make(chan ActivityEvent, buffer)
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, exists := b.subscribers[ch]; exists {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, true
+}
+
+// HasSubscribers 是否存在订阅者
+func (b *ActivityBroadcaster) HasSubscribers() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Publish 向所有订阅者发送动态，缓冲区已满的订阅者会被断开
+func (b *ActivityBroadcaster) Publish(event ActivityEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// AnonymizeIP IP脱敏，IPv4保留前三段，IPv6保留前48位
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,55 @@
+package utils
+
+import "testing"
+
+func TestActivityBroadcasterDropsSlowSubscriber(t *testing.T) {
+	b := NewActivityBroadcaster(0)
+
+	fast, unsubscribeFast, _ := b.Subscribe(4)
+	defer unsubscribeFast()
+	slow, unsubscribeSlow, _ := b.Subscribe(1)
+	defer unsubscribeSlow()
+
+	b.Publish(ActivityEvent{Target: "a"})
+	<-fast
+	b.Publish(ActivityEvent{Target: "b"})
+
+	if got := <-fast; got.Target != "b" {
+		t.Fatalf("fast subscriber got %q", got.Target)
+	}
+	<-slow
+	if _, ok := <-slow; ok {
+		t.Fatal("slow subscriber was not dropped")
+	}
+}
+
+func TestActivityBroadcasterMaxSubscribers(t *testing.T) {
+	b := NewActivityBroadcaster(1)
+
+	_, unsubscribe, ok := b.Subscribe(1)
+	if !ok {
+		t.Fatal("first subscribe rejected")
+	}
+	if _, _, ok := b.Subscribe(1); ok {
+		t.Fatal("subscribe beyond limit accepted")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if b.HasSubscribers() {
+		t.Fatal("subscriber still registered after unsubscribe")
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.45":          "203.0.113.0",
+		"2001:db8:1234:5678::1": "2001:db8:1234::",
+		"not-an-ip":             "",
+	}
+	for in, want := range tests {
+		if got := AnonymizeIP(in); got != want {
+			t.Fatalf("AnonymizeIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// AuthUserKey 认证通过后写入上下文的用户名，限流中间件据此识别已认证请求
+const AuthUserKey = "auth_user"
+
+// adaptiveSampleInterval 采样出站流量并调整限流倍数的间隔
+const adaptiveSampleInterval = 15 * time.Second
+
+// egressSample 某一时刻的累计出站字节数
+type egressSample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// AdaptiveLimitStats 自适应限流状态，factor为当前作用于普通IP限额的倍数
+type AdaptiveLimitStats struct {
+	Engaged       bool    `json:"engaged"`
+	Factor        float64 `json:"factor"`
+	LoadMBps      float64 `json:"load_mbps"`
+	ThresholdMBps float64 `json:"threshold_mbps"`
+	Window        string  `json:"window"`
+}
+
+// AdaptiveLimit 根据最近一段时间的出站流量调整普通IP的限流倍数：
+// 负载超过阈值时收紧，回落到阈值的recoverRatio倍以下才恢复，两个阈值之间保持原状态
+type AdaptiveLimit struct {
+	config   *config.Store
+	mu       sync.Mutex
+	samples  []egressSample
+	engaged  bool
+	loadMBps float64
+
+	factor atomic.Uint64 // math.Float64bits编码的当前倍数
+	once   sync.Once
+}
+
+// NewAdaptiveLimit 创建倍数为1、按store中的配置调整的自适应限流控制器
+func NewAdaptiveLimit(store *config.Store) *AdaptiveLimit {
+	a := &AdaptiveLimit{config: store}
+	a.factor.Store(math.Float64bits(1))
+	return a
+}
+
+// Factor 返回当前作用于普通IP限额的倍数，未收紧时为1
+func (a *AdaptiveLimit) Factor() float64 {
+	return math.Float64frombits(a.factor.Load())
+}
+
+// adaptiveWindow 返回计算平均负载的时间窗口，无效时使用5分钟
+func adaptiveWindow(value string) time.Duration {
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 5 * time.Minute
+	}
+	return window
+}
+
+// Start 按stats的累计出站字节数启动后台采样，重复调用只启动一次；未启用时倍数保持为1
+func (a *AdaptiveLimit) Start(stats *StatsRegistry) {
+	a.once.Do(func() {
+		go func() {
+			ticker := time.NewTicker(adaptiveSampleInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				a.observe(now, stats.DownstreamBytes())
+			}
+		}()
+	})
+}
+
+// observe 记录一次累计出站字节数的采样，按窗口内的平均速率更新状态
+func (a *AdaptiveLimit) observe(now time.Time, total uint64) {
+	cfg := a.config.Get().RateLimit
+	window := adaptiveWindow(cfg.AdaptiveWindow)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.samples = append(a.samples, egressSample{at: now, bytes: total})
+	drop := 0
+	for drop < len(a.samples)-1 && now.Sub(a.samples[drop].at) > window {
+		drop++
+	}
+	a.samples = a.samples[drop:]
+
+	load := 0.0
+	if first := a.samples[0]; now.After(first.at) && total >= first.bytes {
+		load = float64(total-first.bytes) / (1024 * 1024) / now.Sub(first.at).Seconds()
+	}
+	a.stepLocked(load)
+}
+
+// step 按给定的出站负载(MB/s)更新状态
+func (a *AdaptiveLimit) step(loadMBps float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stepLocked(loadMBps)
+}
+
+func (a *AdaptiveLimit) stepLocked(loadMBps float64) {
+	cfg := a.config.Get().RateLimit
+	a.loadMBps = loadMBps
+
+	engaged := a.engaged
+	switch {
+	case !cfg.AdaptiveEnabled || cfg.AdaptiveThresholdMBps <= 0:
+		engaged = false
+	case !engaged && loadMBps > cfg.AdaptiveThresholdMBps:
+		engaged = true
+	case engaged && loadMBps < cfg.AdaptiveThresholdMBps*cfg.AdaptiveRecoverRatio:
+		engaged = false
+	}
+
+	factor := 1.0
+	if engaged && cfg.AdaptiveFactor > 0 && cfg.AdaptiveFactor < 1 {
+		factor = cfg.AdaptiveFactor
+	}
+	if engaged != a.engaged {
+		if engaged {
+			fmt.Printf("出站流量 %.1f MB/s 超过阈值 %.1f MB/s，普通IP限额调整为 %.2f 倍\n", loadMBps, cfg.AdaptiveThresholdMBps, factor)
+		} else {
+			fmt.Printf("出站流量回落到 %.1f MB/s，普通IP限额已恢复\n", loadMBps)
+		}
+	}
+	a.engaged = engaged
+	a.factor.Store(math.Float64bits(factor))
+}
+
+// Snapshot 返回自适应限流状态，未启用时返回nil
+func (a *AdaptiveLimit) Snapshot() *AdaptiveLimitStats {
+	cfg := a.config.Get().RateLimit
+	if !cfg.AdaptiveEnabled {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &AdaptiveLimitStats{
+		Engaged:       a.engaged,
+		Factor:        a.Factor(),
+		LoadMBps:      roundStat(a.loadMBps),
+		ThresholdMBps: cfg.AdaptiveThresholdMBps,
+		Window:        adaptiveWindow(cfg.AdaptiveWindow).String(),
+	}
+}
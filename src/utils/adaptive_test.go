@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+const adaptiveTestConfig = `
+[rateLimit]
+requestLimit = 4
+periodHours = 1
+adaptiveEnabled = true
+adaptiveWindow = "1m"
+adaptiveThresholdMBps = 100
+adaptiveFactor = 0.5
+adaptiveRecoverRatio = 0.8
+`
+
+func TestAdaptiveLimitHysteresis(t *testing.T) {
+	loadTestConfig(t, adaptiveTestConfig)
+	store := testStore()
+	a := NewAdaptiveLimit(store)
+
+	steps := []struct {
+		load float64
+		want float64
+	}{
+		{50, 1},
+		{120, 0.5},
+		// 阈值和恢复线之间保持收紧
+		{90, 0.5},
+		{81, 0.5},
+		{79, 1},
+		// 恢复后未再次超过阈值前不收紧
+		{90, 1},
+		{101, 0.5},
+	}
+	for n, step := range steps {
+		a.step(step.load)
+		if got := a.Factor(); got != step.want {
+			t.Fatalf("step %d load %.0f: factor %g, want %g", n, step.load, got, step.want)
+		}
+	}
+	if stats := a.Snapshot(); stats == nil || !stats.Engaged || stats.LoadMBps != 101 || stats.Window != "1m0s" {
+		t.Fatalf("snapshot = %+v", stats)
+	}
+
+	loadTestConfig(t, "")
+	store.Set(config.GetConfig())
+	a.step(500)
+	if a.Factor() != 1 || a.Snapshot() != nil {
+		t.Fatalf("disabled: factor %g, snapshot %+v", a.Factor(), a.Snapshot())
+	}
+}
+
+func TestAdaptiveLimitEgressWindow(t *testing.T) {
+	loadTestConfig(t, adaptiveTestConfig)
+	a := NewAdaptiveLimit(testStore())
+	start := time.Unix(1700000000, 0)
+	const mb = 1024 * 1024
+
+	// 模拟30秒内以200MB/s输出
+	a.observe(start, 0)
+	a.observe(start.Add(15*time.Second), 15*200*mb)
+	a.observe(start.Add(30*time.Second), 30*200*mb)
+	if a.Factor() != 0.5 {
+		t.Fatalf("factor under load = %g", a.Factor())
+	}
+
+	// 流量停止后，窗口内的平均值逐渐回落到恢复线以下
+	total := uint64(30 * 200 * mb)
+	now := start.Add(30 * time.Second)
+	for a.Factor() != 1 {
+		now = now.Add(15 * time.Second)
+		if now.Sub(start) > 5*time.Minute {
+			t.Fatalf("factor not restored, load %.1f MB/s", a.Snapshot().LoadMBps)
+		}
+		a.observe(now, total)
+	}
+	if len(a.samples) > 5 {
+		t.Fatalf("samples outside window kept: %d", len(a.samples))
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ArchiveConvertResult 归档格式转换的结果
+type ArchiveConvertResult struct {
+	// Entries 写入zip的条目数，含目录
+	Entries int
+	// Links 以普通文件写入的符号链接和硬链接，文件内容为链接目标
+	Links []string
+	// Skipped 设备文件、FIFO或路径不安全等zip无法表示而跳过的条目
+	Skipped []string
+}
+
+// zipEntryName 规范化tar中的路径，去掉开头的/和越出归档根目录的..，无法表示的路径返回空串
+func zipEntryName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || name == "." || strings.Contains(name, "\\") {
+		return ""
+	}
+	return name
+}
+
+// ConvertTarGzToZip 将tar.gz流式转换为zip写入w，逐个条目读取和写入，不缓存整个归档。
+// zip条目的大小和校验和写在数据之后的数据描述符中，输出无需回写；
+// limit限制解压后的tar流大小，超出时返回ErrSizeLimitExceeded，小于等于0时不限制。
+// 出错时不写入zip的中央目录，客户端收到的是无法解压的不完整文件
+func ConvertTarGzToZip(w io.Writer, r io.Reader, limit int64) (*ArchiveConvertResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取gzip失败: %w", err)
+	}
+	defer gz.Close()
+
+	result := &ArchiveConvertResult{}
+	tr := tar.NewReader(NewSizeLimitReader(gz, limit))
+	zw := zip.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		// GitHub生成的源码包在全局头中记录提交ID，与GitHub自身的zip一样写入归档注释
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			if comment := hdr.PAXRecords["comment"]; comment != "" {
+				if err := zw.SetComment(comment); err != nil {
+					return result, err
+				}
+			}
+			continue
+		}
+
+		name := zipEntryName(hdr.Name)
+		if name == "" {
+			result.Skipped = append(result.Skipped, hdr.Name)
+			continue
+		}
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: hdr.ModTime}
+		var content io.Reader = tr
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			header.Name += "/"
+			header.Method = zip.Store
+			header.SetMode(fs.ModeDir | fs.FileMode(hdr.Mode).Perm())
+			content = nil
+		case tar.TypeReg:
+			header.SetMode(fs.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink, tar.TypeLink:
+			// 链接在Windows上通常无法还原，按普通文件写入链接目标，并在条目注释中说明
+			header.SetMode(0644)
+			header.Comment = "link -> " + hdr.Linkname
+			content = strings.NewReader(hdr.Linkname)
+			result.Links = append(result.Links, name)
+		default:
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return result, err
+		}
+		if content != nil {
+			if _, err := io.Copy(entry, content); err != nil {
+				return result, err
+			}
+		}
+		result.Entries++
+	}
+	return result, zw.Close()
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTarGzEntries 读取tar.gz中的条目，返回名称到内容的映射和按顺序的名称列表，链接的内容为链接目标
+func readTarGzEntries(t *testing.T, data []byte) ([]string, map[string]string, map[string]fs.FileMode) {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	contents := make(map[string]string)
+	modes := make(map[string]fs.FileMode)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeSymlink:
+			contents[hdr.Name] = hdr.Linkname
+			modes[hdr.Name] = 0644
+		default:
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[hdr.Name] = string(body)
+			modes[hdr.Name] = hdr.FileInfo().Mode()
+		}
+		names = append(names, hdr.Name)
+	}
+	return names, contents, modes
+}
+
+func TestConvertTarGzToZipRoundTrip(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/repo-main.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames, wantContents, wantModes := readTarGzEntries(t, fixture)
+
+	var out bytes.Buffer
+	result, err := ConvertTarGzToZip(&out, bytes.NewReader(fixture), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != len(wantNames) || !reflect.DeepEqual(result.Links, []string{"repo-main/install.sh"}) || len(result.Skipped) != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Comment != "3f5e1c0d9b8a7f6e5d4c3b2a1908f7e6d5c4b3a2" {
+		t.Fatalf("zip comment = %q", zr.Comment)
+	}
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+		// 流式写入的条目大小记录在数据描述符中
+		if !file.Mode().IsDir() && file.Flags&0x8 == 0 {
+			t.Errorf("%s: no data descriptor", file.Name)
+		}
+		if file.Mode() != wantModes[file.Name] {
+			t.Errorf("%s: mode = %v, want %v", file.Name, file.Mode(), wantModes[file.Name])
+		}
+		if !file.Modified.Equal(zr.File[0].Modified) {
+			t.Errorf("%s: modified = %v", file.Name, file.Modified)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != wantContents[file.Name] {
+			t.Errorf("%s: content = %q, want %q", file.Name, body, wantContents[file.Name])
+		}
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("names = %v, want %v", names, wantNames)
+	}
+	if zr.File[4].Comment != "link -> scripts/install.sh" {
+		t.Fatalf("link comment = %q", zr.File[4].Comment)
+	}
+}
+
+func TestConvertTarGzToZipLimitsAndUnsafePaths(t *testing.T) {
+	build := func(entries ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range entries {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(bytes.Repeat([]byte("a"), int(hdr.Size)))
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	// 解压后的大小超出上限时中断，不写入中央目录
+	archive := build(&tar.Header{Typeflag: tar.TypeReg, Name: "big.bin", Mode: 0644, Size: 64 * 1024})
+	var out bytes.Buffer
+	if _, err := ConvertTarGzToZip(&out, bytes.NewReader(archive), 16*1024); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Fatalf("err = %v, want size limit", err)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len())); err == nil {
+		t.Fatal("truncated conversion produced a readable zip")
+	}
+
+	archive = build(
+		&tar.Header{Typeflag: tar.TypeReg, Name: "../escape.txt", Mode: 0644, Size: 1},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "/abs/file.txt", Mode: 0644, Size: 1},
+		&tar.Header{Typeflag: tar.TypeFifo, Name: "pipe", Mode: 0644},
+	)
+	out.Reset()
+	result, err := ConvertTarGzToZip(&out, bytes.NewReader(archive), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, ",") != "escape.txt,abs/file.txt" || !reflect.DeepEqual(result.Skipped, []string{"pipe"}) {
+		t.Fatalf("names = %v, result = %+v", names, result)
+	}
+
+	if _, err := ConvertTarGzToZip(io.Discard, strings.NewReader("not gzip"), 0); err == nil {
+		t.Fatal("invalid gzip accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+const (
+	// 可用性统计使用两级时间桶：60个1分钟桶覆盖5分钟和1小时，24个1小时桶覆盖24小时
+	availabilityMinuteSlots = 60
+	availabilityHourSlots   = 24
+	// availabilityHistogramRatio 可用性耗时直方图的桶比例，p95相对误差约12%，换取较小的内存占用
+	availabilityHistogramRatio = 1.25
+
+	// healthSummaryTTL 可用性汇总的缓存时间，期间的请求直接返回上次的结果
+	healthSummaryTTL = 10 * time.Second
+
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// availabilityWindows 可用性汇总的统计窗口，按从短到长排列
+var availabilityWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+// availabilitySlot 单个时间桶内的请求数、失败数和耗时直方图
+type availabilitySlot struct {
+	epoch    atomic.Int64
+	total    atomic.Uint64
+	failed   atomic.Uint64
+	duration *logHistogram
+}
+
+// availabilityRing 固定宽度的轮转时间桶
+type availabilityRing struct {
+	width    time.Duration
+	rotateMu sync.Mutex
+	slots    []*availabilitySlot
+}
+
+func newAvailabilityRing(width time.Duration, count int) *availabilityRing {
+	ring := &availabilityRing{width: width, slots: make([]*availabilitySlot, count)}
+	for i := range ring.slots {
+		slot := &availabilitySlot{duration: newLogHistogramRatio(1, float64(time.Hour/time.Millisecond), availabilityHistogramRatio)}
+		slot.epoch.Store(-1)
+		ring.slots[i] = slot
+	}
+	return ring
+}
+
+// slot 返回时间对应的桶，桶过期时在锁内清零后复用
+func (r *availabilityRing) slot(now time.Time) *availabilitySlot {
+	epoch := now.UnixNano() / int64(r.width)
+	slot := r.slots[epoch%int64(len(r.slots))]
+	if slot.epoch.Load() == epoch {
+		return slot
+	}
+
+	r.rotateMu.Lock()
+	if slot.epoch.Load() != epoch {
+		slot.total.Store(0)
+		slot.failed.Store(0)
+		slot.duration.reset()
+		slot.epoch.Store(epoch)
+	}
+	r.rotateMu.Unlock()
+	return slot
+}
+
+func (r *availabilityRing) record(now time.Time, duration time.Duration, failed bool) {
+	slot := r.slot(now)
+	slot.total.Add(1)
+	if failed {
+		slot.failed.Add(1)
+	}
+	slot.duration.record(float64(duration) / float64(time.Millisecond))
+}
+
+// stats 汇总window内的时间桶，window按桶宽向上取整
+func (r *availabilityRing) stats(now time.Time, window time.Duration) AvailabilityStats {
+	current := now.UnixNano() / int64(r.width)
+	slots := min(int64((window+r.width-1)/r.width), int64(len(r.slots)))
+	durations := make([]uint64, len(r.slots[0].duration.counts))
+
+	var stats AvailabilityStats
+	for _, slot := range r.slots {
+		if epoch := slot.epoch.Load(); epoch > current-slots && epoch <= current {
+			stats.Requests += slot.total.Load()
+			stats.Failed += slot.failed.Load()
+			slot.duration.addTo(durations)
+		}
+	}
+
+	stats.SuccessRate = 1
+	if stats.Requests > 0 {
+		stats.SuccessRate = roundStat(float64(stats.Requests-min(stats.Failed, stats.Requests)) / float64(stats.Requests))
+	}
+	histogram := r.slots[0].duration
+	p95, _ := histogramPercentiles(durations, histogram.min, histogram.ratio, 0.95)
+	stats.P95Ms = roundStat(p95[0])
+	return stats
+}
+
+// availabilitySeries 单个维度的可用性统计
+type availabilitySeries struct {
+	minutes *availabilityRing
+	hours   *availabilityRing
+}
+
+func newAvailabilitySeries() *availabilitySeries {
+	return &availabilitySeries{
+		minutes: newAvailabilityRing(time.Minute, availabilityMinuteSlots),
+		hours:   newAvailabilityRing(time.Hour, availabilityHourSlots),
+	}
+}
+
+func (s *availabilitySeries) record(now time.Time, duration time.Duration, failed bool) {
+	s.minutes.record(now, duration, failed)
+	s.hours.record(now, duration, failed)
+}
+
+// windows 返回各统计窗口的可用性，1小时以内使用分钟桶
+func (s *availabilitySeries) windows(now time.Time) map[string]AvailabilityStats {
+	result := make(map[string]AvailabilityStats, len(availabilityWindows))
+	for _, w := range availabilityWindows {
+		ring := s.hours
+		if w.window <= s.minutes.width*time.Duration(len(s.minutes.slots)) {
+			ring = s.minutes
+		}
+		result[w.name] = ring.stats(now, w.window)
+	}
+	return result
+}
+
+// AvailabilityStats 一个统计窗口内的请求数、失败数(5xx)、成功率和p95耗时
+type AvailabilityStats struct {
+	Requests    uint64  `json:"requests"`
+	Failed      uint64  `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+	P95Ms       float64 `json:"p95_ms"`
+}
+
+// HealthSummary 按路由类别和上游主机汇总的可用性，Status由最近5分钟各路由类别的数据和内存占用判定
+type HealthSummary struct {
+	Status    string                                  `json:"status"`
+	Reasons   []string                                `json:"reasons,omitempty"`
+	Time      string                                  `json:"time"`
+	TimeUnix  int64                                   `json:"time_unix"`
+	Overall   map[string]AvailabilityStats            `json:"overall"`
+	Routes    map[string]map[string]AvailabilityStats `json:"routes"`
+	Upstreams map[string]map[string]AvailabilityStats `json:"upstreams"`
+}
+
+// classifyHealth 按阈值判定整体状态，样本不足的路由类别不参与判定，返回状态和原因
+func classifyHealth(routes map[string]map[string]AvailabilityStats, cfg *config.AppConfig) (string, []string) {
+	thresholds := cfg.Health
+	status := HealthOK
+	var reasons []string
+	worsen := func(level string) {
+		if level == HealthDown || status == HealthOK {
+			status = level
+		}
+	}
+
+	names := make([]string, 0, len(routes))
+	for route := range routes {
+		names = append(names, route)
+	}
+	sort.Strings(names)
+	for _, route := range names {
+		stats := routes[route][availabilityWindows[0].name]
+		if stats.Requests == 0 || stats.Requests < uint64(max(thresholds.MinSamples, 0)) {
+			continue
+		}
+		switch {
+		case stats.SuccessRate < thresholds.DownSuccessRate:
+			worsen(HealthDown)
+			reasons = append(reasons, fmt.Sprintf("%s: 成功率 %.1f%%", route, stats.SuccessRate*100))
+		case stats.SuccessRate < thresholds.DegradedSuccessRate:
+			worsen(HealthDegraded)
+			reasons = append(reasons, fmt.Sprintf("%s: 成功率 %.1f%%", route, stats.SuccessRate*100))
+		case thresholds.DegradedP95Ms > 0 && stats.P95Ms > thresholds.DegradedP95Ms:
+			worsen(HealthDegraded)
+			reasons = append(reasons, fmt.Sprintf("%s: p95耗时 %.0fms", route, stats.P95Ms))
+		}
+	}
+	return status, reasons
+}
+
+// healthSummaryAt 汇总所有维度的可用性并结合内存保护的状态判定整体状态
+func (r *StatsRegistry) healthSummaryAt(now time.Time, cfg *config.AppConfig, memory MemoryStats) *HealthSummary {
+	summary := &HealthSummary{
+		Time:      FormatDisplayTime(cfg, now),
+		TimeUnix:  now.Unix(),
+		Overall:   r.summary.availability.windows(now),
+		Routes:    make(map[string]map[string]AvailabilityStats),
+		Upstreams: make(map[string]map[string]AvailabilityStats),
+	}
+	r.routes.Range(func(key, value interface{}) bool {
+		summary.Routes[key.(string)] = value.(*statsSeries).availability.windows(now)
+		return true
+	})
+	r.upstreams.Range(func(key, value interface{}) bool {
+		summary.Upstreams[key.(string)] = value.(*statsSeries).availability.windows(now)
+		return true
+	})
+	summary.Status, summary.Reasons = classifyHealth(summary.Routes, cfg)
+	if memory.UnderPressure {
+		if summary.Status == HealthOK {
+			summary.Status = HealthDegraded
+		}
+		summary.Reasons = append(summary.Reasons, fmt.Sprintf("内存紧张: 缓冲 %d MB / 上限 %d MB", (memory.Buffered+memory.HotCache)/1024/1024, memory.Ceiling/1024/1024))
+	}
+	return summary
+}
+
+// HealthSummary 按cfg中的阈值返回可用性汇总，内存紧张时降级，结果缓存healthSummaryTTL，频繁访问状态页不会重复汇总
+func (r *StatsRegistry) HealthSummary(cfg *config.AppConfig, memory *MemoryGuard) *HealthSummary {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	now := time.Now()
+	if r.health == nil || now.Sub(r.healthAt) >= healthSummaryTTL {
+		r.health = r.healthSummaryAt(now, cfg, memory.Stats())
+		r.healthAt = now
+	}
+	return r.health
+}
+
+// InvalidateHealthSummary 清除缓存的可用性汇总，配置重载后阈值立即生效
+func (r *StatsRegistry) InvalidateHealthSummary() {
+	r.healthMu.Lock()
+	r.health = nil
+	r.healthMu.Unlock()
+}
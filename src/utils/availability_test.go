@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestHealthSummaryWindows(t *testing.T) {
+	loadTestConfig(t, "")
+	registry := NewStatsRegistry()
+	now := time.Now()
+
+	// 两小时前全部失败，只计入24小时窗口；最近一分钟内90%成功
+	for i := 0; i < 50; i++ {
+		registry.recordAt(now.Add(-2*time.Hour), "github", "github.com", 502, 10*time.Millisecond, 0)
+	}
+	for i := 0; i < 100; i++ {
+		status := 200
+		if i%10 == 0 {
+			status = 500
+		}
+		registry.recordAt(now.Add(-30*time.Second), "github", "github.com", status, time.Duration(i+1)*time.Millisecond, 0)
+	}
+
+	summary := registry.healthSummaryAt(now, config.GetConfig(), MemoryStats{})
+	windows := summary.Routes["github"]
+	if windows["5m"].Requests != 100 || windows["5m"].Failed != 10 || windows["5m"].SuccessRate != 0.9 {
+		t.Fatalf("5m = %+v", windows["5m"])
+	}
+	if windows["1h"].Requests != 100 || windows["24h"].Requests != 150 || windows["24h"].Failed != 60 {
+		t.Fatalf("1h = %+v, 24h = %+v", windows["1h"], windows["24h"])
+	}
+	// 1ms到100ms均匀分布，p95约95ms，桶比例1.25
+	if p95 := windows["5m"].P95Ms; !withinTolerance(p95, 95, 0.15) {
+		t.Fatalf("p95 = %v, want ~95", p95)
+	}
+	if summary.Upstreams["github.com"]["24h"].Requests != 150 || summary.Overall["24h"].Requests != 150 {
+		t.Fatalf("upstream/overall = %+v / %+v", summary.Upstreams["github.com"], summary.Overall)
+	}
+}
+
+func TestHealthSummaryClassification(t *testing.T) {
+	now := time.Now()
+	// feed 记录ok个成功请求和failed个状态码为status的请求
+	feed := func(route string, ok, failed, status int, duration time.Duration) *StatsRegistry {
+		registry := NewStatsRegistry()
+		for i := 0; i < ok; i++ {
+			registry.recordAt(now, route, "", 200, duration, 0)
+		}
+		for i := 0; i < failed; i++ {
+			registry.recordAt(now, route, "", status, duration, 0)
+		}
+		return registry
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		registry *StatsRegistry
+		want     string
+	}{
+		{"no traffic", "", NewStatsRegistry(), HealthOK},
+		{"healthy", "", feed("docker", 99, 1, 503, time.Millisecond), HealthOK},
+		{"degraded", "", feed("docker", 95, 5, 503, time.Millisecond), HealthDegraded},
+		{"down", "", feed("docker", 50, 50, 503, time.Millisecond), HealthDown},
+		// 样本不足时不参与判定
+		{"too few samples", "", feed("docker", 1, 9, 503, time.Millisecond), HealthOK},
+		{"min samples configured", "[health]\nminSamples = 5\n", feed("docker", 1, 9, 503, time.Millisecond), HealthDown},
+		// 4xx不计为失败
+		{"client errors", "", feed("docker", 50, 50, 429, time.Millisecond), HealthOK},
+		{"slow", "[health]\ndegradedP95Ms = 1000\n", feed("github", 100, 0, 503, 5*time.Second), HealthDegraded},
+		{"latency disabled", "", feed("github", 100, 0, 503, 5*time.Second), HealthOK},
+		{"custom thresholds", "[health]\ndegradedSuccessRate = 0.9\ndownSuccessRate = 0.5\n", feed("docker", 95, 5, 503, time.Millisecond), HealthOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, tt.config)
+			summary := tt.registry.healthSummaryAt(now, config.GetConfig(), MemoryStats{})
+			if summary.Status != tt.want {
+				t.Fatalf("status = %s, want %s (reasons %v)", summary.Status, tt.want, summary.Reasons)
+			}
+			if (tt.want == HealthOK) != (len(summary.Reasons) == 0) {
+				t.Fatalf("reasons = %v", summary.Reasons)
+			}
+		})
+	}
+
+	// 任一路由类别不可用时整体为down
+	loadTestConfig(t, "")
+	registry := feed("docker", 95, 5, 503, time.Millisecond)
+	for i := 0; i < 30; i++ {
+		registry.recordAt(now, "github", "", 502, time.Millisecond, 0)
+	}
+	if summary := registry.healthSummaryAt(now, config.GetConfig(), MemoryStats{}); summary.Status != HealthDown || len(summary.Reasons) != 2 {
+		t.Fatalf("mixed = %s %v", summary.Status, summary.Reasons)
+	}
+}
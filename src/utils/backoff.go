@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// defaultRetryAfter 无法从限流状态推算等待时长时建议的重试间隔
+const defaultRetryAfter = 30 * time.Second
+
+// retryAfterKey 上下文中记录本次限流响应建议等待时长的key
+const retryAfterKey = "retry_after"
+
+// SetRetryAfter 记录本次请求被限流时建议的等待时长，随后返回的429/503据此设置Retry-After
+func SetRetryAfter(c *gin.Context, d time.Duration) {
+	c.Set(retryAfterKey, d)
+}
+
+// RetryAdvice 为429和503响应设置Retry-After头，返回建议等待的秒数和重试时应叠加的随机抖动上限(秒)，
+// 避免大量客户端在同一时刻重试。已设置Retry-After时沿用，其次取SetRetryAfter记录的时长，都没有时使用默认值；
+// 其他状态码返回0
+func RetryAdvice(c *gin.Context, status int) (retryAfter, jitter int) {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return 0, 0
+	}
+	wait := defaultRetryAfter
+	if value := c.Writer.Header().Get("Retry-After"); value != "" {
+		wait = parseRetryAfter(value)
+	} else if d, ok := c.Get(retryAfterKey); ok {
+		wait = d.(time.Duration)
+	}
+	retryAfter = max(int(math.Ceil(wait.Seconds())), 1)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	return retryAfter, RetryJitter(retryAfter)
+}
+
+// RetryJitter 建议的随机抖动上限，取等待时长的20%，至少1秒
+func RetryJitter(retryAfter int) int {
+	return max(retryAfter/5, 1)
+}
+
+// WithRetryAdvice 状态码为429或503时设置Retry-After，并在JSON响应体中加入retry_after和retry_jitter
+func WithRetryAdvice(c *gin.Context, status int, body gin.H) gin.H {
+	if retryAfter, jitter := RetryAdvice(c, status); retryAfter > 0 {
+		body["retry_after"] = retryAfter
+		body["retry_jitter"] = jitter
+	}
+	return body
+}
+
+// BucketRetryAfter 返回令牌桶攒够n个令牌还需等待的时长，不限速时为0
+func BucketRetryAfter(limiter *rate.Limiter, n int) time.Duration {
+	limit := limiter.Limit()
+	if limit == rate.Inf {
+		return 0
+	}
+	if limit <= 0 {
+		return defaultRetryAfter
+	}
+	missing := float64(n) - limiter.Tokens()
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(limit) * float64(time.Second))
+}
+
+// recordThrottle 记录上游限流响应的Retry-After，GitHub限额耗尽时取X-RateLimit-Reset
+func (p *upstreamPolicies) recordThrottle(host string, resp *http.Response) {
+	if !UpstreamThrottled(resp) {
+		return
+	}
+	if wait, ok := UpstreamRetryAfter(resp); ok {
+		p.throttledUntil.Store(strings.ToLower(host), time.Now().Add(wait))
+	}
+}
+
+// UpstreamRetryAfter 返回上游限流响应要求的等待时长，依次取Retry-After和X-RateLimit-Reset，都没有时返回false
+func UpstreamRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		return parseRetryAfter(value), true
+	}
+	if value := resp.Header.Get("X-RateLimit-Reset"); value != "" {
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil && epoch > 0 {
+			return max(time.Until(time.Unix(epoch, 0)), 0), true
+		}
+	}
+	return 0, false
+}
+
+// UpstreamThrottleRemaining 返回上游主机最近一次限流要求的剩余等待时长，未被限流或已过期时返回false
+func (h *HTTPClients) UpstreamThrottleRemaining(host string) (time.Duration, bool) {
+	value, ok := h.policies.throttledUntil.Load(strings.ToLower(host))
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(value.(time.Time))
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// PropagateUpstreamRetryAfter 转发上游限流响应时确保带有Retry-After：上游未提供时按X-RateLimit-Reset推算，
+// 仍无法确定时使用默认值
+func PropagateUpstreamRetryAfter(c *gin.Context, resp *http.Response) {
+	if !UpstreamThrottled(resp) {
+		return
+	}
+	wait, ok := UpstreamRetryAfter(resp)
+	if !ok {
+		wait = defaultRetryAfter
+	}
+	c.Header("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestRespondErrorRetryAdvice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	respond := func(status int, wait time.Duration) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		if wait > 0 {
+			SetRetryAfter(c, wait)
+		}
+		RespondError(c, status, ErrCodeRateLimited)
+		return w
+	}
+
+	w := respond(http.StatusTooManyRequests, 90*time.Second+time.Millisecond)
+	var body struct {
+		RetryAfter  int `json:"retry_after"`
+		RetryJitter int `json:"retry_jitter"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Header().Get("Retry-After") != "91" || body.RetryAfter != 91 || body.RetryJitter != 18 {
+		t.Fatalf("429: Retry-After %q, body %+v", w.Header().Get("Retry-After"), body)
+	}
+
+	// 没有记录等待时长时使用默认值
+	if got := respond(http.StatusServiceUnavailable, 0).Header().Get("Retry-After"); got != strconv.Itoa(int(defaultRetryAfter.Seconds())) {
+		t.Fatalf("503 default Retry-After = %q", got)
+	}
+	if got := respond(http.StatusForbidden, time.Minute).Header().Get("Retry-After"); got != "" {
+		t.Fatalf("403 Retry-After = %q", got)
+	}
+}
+
+func TestBucketRetryAfter(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Limit(1.0/60), 2)
+	limiter.AllowN(time.Now(), 2)
+	if got := BucketRetryAfter(limiter, 1); got < 59*time.Second || got > time.Minute {
+		t.Fatalf("empty bucket: %v", got)
+	}
+	if got := BucketRetryAfter(limiter, 2); got < 119*time.Second || got > 2*time.Minute {
+		t.Fatalf("cost 2: %v", got)
+	}
+	if got := BucketRetryAfter(rate.NewLimiter(rate.Inf, 1), 5); got != 0 {
+		t.Fatalf("unlimited: %v", got)
+	}
+}
+
+func TestUpstreamRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"120"}}}
+	if wait, ok := UpstreamRetryAfter(resp); !ok || wait != 2*time.Minute {
+		t.Fatalf("Retry-After: %v %v", wait, ok)
+	}
+
+	reset := time.Now().Add(10 * time.Minute)
+	resp = &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+	}}
+	if wait, ok := UpstreamRetryAfter(resp); !ok || wait < 9*time.Minute || wait > 10*time.Minute {
+		t.Fatalf("X-RateLimit-Reset: %v %v", wait, ok)
+	}
+
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+	clients.policies.recordThrottle("Ghcr.IO", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}})
+	if wait, ok := clients.UpstreamThrottleRemaining("ghcr.io"); !ok || wait < 29*time.Second || wait > 30*time.Second {
+		t.Fatalf("remaining: %v %v", wait, ok)
+	}
+	if _, ok := clients.UpstreamThrottleRemaining("quay.io"); ok {
+		t.Fatal("unthrottled host reported as throttled")
+	}
+
+	// 转发的上游429没有Retry-After时按默认值补上
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	PropagateUpstreamRetryAfter(c, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	if got := w.Header().Get("Retry-After"); got != strconv.Itoa(int(defaultRetryAfter.Seconds())) {
+		t.Fatalf("propagated Retry-After = %q", got)
+	}
+}
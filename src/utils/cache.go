@@ -1,15 +1,21 @@
 package utils
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/7alva7/hubproxy/src/internal/config"
 	"github.com/gin-gonic/gin"
-	"hubproxy/config"
 )
 
 // CachedItem 通用缓存项
@@ -17,34 +23,321 @@ type CachedItem struct {
 	Data        []byte
 	ContentType string
 	Headers     map[string]string
+	StoredAt    time.Time
 	ExpiresAt   time.Time
+	StaleUntil  time.Time
+	RetainUntil time.Time // 过期后保留用于条件请求重新验证的截止时间，不早于StaleUntil
+}
+
+// CacheValidators 上游响应的缓存验证器，缓存过期后用于条件请求
+type CacheValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// ValidatorsFromHeader 从上游响应头提取ETag和Last-Modified
+func ValidatorsFromHeader(header http.Header) CacheValidators {
+	return CacheValidators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+}
+
+// Empty 判断是否没有可用的验证器
+func (v CacheValidators) Empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Apply 为上游请求设置If-None-Match和If-Modified-Since
+func (v CacheValidators) Apply(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Validators 返回缓存项保存的验证器，manifest没有ETag时以Docker-Content-Digest代替
+func (item *CachedItem) Validators() CacheValidators {
+	validators := CacheValidators{ETag: item.Headers["ETag"], LastModified: item.Headers["Last-Modified"]}
+	if digest := item.Headers["Docker-Content-Digest"]; validators.ETag == "" && digest != "" {
+		validators.ETag = `"` + digest + `"`
+	}
+	return validators
+}
+
+// cacheCounters 单个缓存类别的命中和重新验证统计
+type cacheCounters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	revalidated atomic.Uint64
+	savedBytes  atomic.Uint64
 }
 
 // UniversalCache 通用缓存
 type UniversalCache struct {
-	cache sync.Map
+	config   *config.Store
+	cache    sync.Map
+	counters sync.Map
+	// refreshing 正在后台刷新的缓存key
+	refreshing sync.Map
+}
+
+// cacheCategory 返回缓存key所属类别，即第一个冒号前的部分
+func cacheCategory(key string) string {
+	if idx := strings.Index(key, ":"); idx > 0 {
+		return key[:idx]
+	}
+	return "other"
+}
+
+func (c *UniversalCache) countersFor(key string) *cacheCounters {
+	category := cacheCategory(key)
+	if v, ok := c.counters.Load(category); ok {
+		return v.(*cacheCounters)
+	}
+	v, _ := c.counters.LoadOrStore(category, &cacheCounters{})
+	return v.(*cacheCounters)
+}
+
+// NewUniversalCache 创建通用缓存，保留时间从store读取，并在后台定期清理超过保留期限的缓存项，ctx取消时退出
+func NewUniversalCache(ctx context.Context, store *config.Store) *UniversalCache {
+	c := &UniversalCache{config: store}
+	go func() {
+		ticker := time.NewTicker(20 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				c.removeExpired(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
 }
 
-var GlobalCache = &UniversalCache{}
+// removeExpired 删除超过保留期限的缓存项
+func (c *UniversalCache) removeExpired(now time.Time) {
+	expiredKeys := make([]string, 0)
+	c.cache.Range(func(key, value interface{}) bool {
+		if cached := value.(*CachedItem); !now.Before(cached.RetainUntil) {
+			expiredKeys = append(expiredKeys, key.(string))
+		}
+		return true
+	})
+	for _, key := range expiredKeys {
+		c.cache.Delete(key)
+	}
+}
 
 // Get 获取缓存项
 func (c *UniversalCache) Get(key string) *CachedItem {
+	if cached, stale := c.GetStale(key); !stale {
+		return cached
+	}
+	return nil
+}
+
+// GetStale 获取缓存项，已过期但仍在保留期内的项也会返回，stale表示是否已过期
+func (c *UniversalCache) GetStale(key string) (item *CachedItem, stale bool) {
+	counters := c.countersFor(key)
 	if v, ok := c.cache.Load(key); ok {
-		if cached := v.(*CachedItem); time.Now().Before(cached.ExpiresAt) {
-			return cached
+		cached := v.(*CachedItem)
+		now := time.Now()
+		if now.Before(cached.ExpiresAt) {
+			counters.hits.Add(1)
+			return cached, false
+		}
+		if now.Before(cached.StaleUntil) {
+			counters.misses.Add(1)
+			return cached, true
+		}
+		if !now.Before(cached.RetainUntil) {
+			c.cache.CompareAndDelete(key, v)
 		}
-		c.cache.Delete(key)
 	}
-	return nil
+	counters.misses.Add(1)
+	return nil, false
 }
 
 func (c *UniversalCache) Set(key string, data []byte, contentType string, headers map[string]string, ttl time.Duration) {
-	c.cache.Store(key, &CachedItem{
+	c.SetWithStale(key, data, contentType, headers, ttl, 0)
+}
+
+// SetWithStale 写入缓存项，过期后再保留staleFor供GetStale使用；
+// 带验证器的项按revalidateFor配置继续保留，供GetForRevalidation使用
+func (c *UniversalCache) SetWithStale(key string, data []byte, contentType string, headers map[string]string, ttl, staleFor time.Duration) {
+	c.cache.Store(key, c.newCachedItem(data, contentType, headers, ttl, staleFor))
+}
+
+func (c *UniversalCache) newCachedItem(data []byte, contentType string, headers map[string]string, ttl, staleFor time.Duration) *CachedItem {
+	now := time.Now()
+	item := &CachedItem{
 		Data:        data,
 		ContentType: contentType,
 		Headers:     headers,
-		ExpiresAt:   time.Now().Add(ttl),
+		StoredAt:    now,
+		ExpiresAt:   now.Add(ttl),
+		StaleUntil:  now.Add(ttl + staleFor),
+	}
+	item.RetainUntil = item.StaleUntil
+	if revalidateFor := GetRevalidateFor(c.config.Get()); revalidateFor > staleFor && !item.Validators().Empty() {
+		item.RetainUntil = item.ExpiresAt.Add(revalidateFor)
+	}
+	return item
+}
+
+// GetForRevalidation 返回仍保留用于条件请求的缓存项，没有验证器或已超过保留时间时返回nil，不计入命中统计
+func (c *UniversalCache) GetForRevalidation(key string) *CachedItem {
+	v, ok := c.cache.Load(key)
+	if !ok {
+		return nil
+	}
+	cached := v.(*CachedItem)
+	if !time.Now().Before(cached.RetainUntil) || cached.Validators().Empty() {
+		return nil
+	}
+	return cached
+}
+
+// Renew 上游确认内容未变化(304)时续期缓存项：保留原数据和stale时长，
+// updated中的响应头覆盖原有值，并记录省下的传输字节。返回续期后的新缓存项
+func (c *UniversalCache) Renew(key string, item *CachedItem, updated map[string]string, ttl time.Duration) *CachedItem {
+	headers := make(map[string]string, len(item.Headers)+len(updated))
+	for name, value := range item.Headers {
+		headers[name] = value
+	}
+	for name, value := range updated {
+		headers[name] = value
+	}
+	renewed := c.newCachedItem(item.Data, item.ContentType, headers, ttl, max(item.StaleUntil.Sub(item.ExpiresAt), 0))
+	c.cache.Store(key, renewed)
+	c.RecordRevalidation(key, len(item.Data))
+	return renewed
+}
+
+// RecordRevalidation 记录一次上游返回304的重新验证，savedBytes为免于重新传输的内容大小；
+// 不使用本缓存保存数据的元数据缓存也通过它按key类别计入统计
+func (c *UniversalCache) RecordRevalidation(key string, savedBytes int) {
+	counters := c.countersFor(key)
+	counters.revalidated.Add(1)
+	counters.savedBytes.Add(uint64(max(savedBytes, 0)))
+}
+
+// RefreshInBackground 在后台执行刷新，同一key同时只会有一个刷新，返回是否启动了新的刷新
+func (c *UniversalCache) RefreshInBackground(key string, refresh func()) bool {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return false
+	}
+	go func() {
+		defer c.refreshing.Delete(key)
+		refresh()
+	}()
+	return true
+}
+
+// CacheCategoryStats 单个缓存类别的统计
+type CacheCategoryStats struct {
+	Entries int     `json:"entries"`
+	Bytes   int64   `json:"bytes"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+
+	Revalidated            uint64 `json:"revalidated"`
+	RevalidationSavedBytes uint64 `json:"revalidation_saved_bytes"`
+}
+
+// Stats 按类别返回缓存项数量、占用字节和命中率
+func (c *UniversalCache) Stats() map[string]CacheCategoryStats {
+	stats := make(map[string]CacheCategoryStats)
+	now := time.Now()
+	c.cache.Range(func(key, value interface{}) bool {
+		item := value.(*CachedItem)
+		if !now.Before(item.ExpiresAt) {
+			return true
+		}
+		category := cacheCategory(key.(string))
+		entry := stats[category]
+		entry.Entries++
+		entry.Bytes += int64(len(item.Data))
+		stats[category] = entry
+		return true
+	})
+	c.counters.Range(func(key, value interface{}) bool {
+		counters := value.(*cacheCounters)
+		entry := stats[key.(string)]
+		entry.Hits = counters.hits.Load()
+		entry.Misses = counters.misses.Load()
+		entry.Revalidated = counters.revalidated.Load()
+		entry.RevalidationSavedBytes = counters.savedBytes.Load()
+		if total := entry.Hits + entry.Misses; total > 0 {
+			entry.HitRate = float64(entry.Hits) / float64(total)
+		}
+		stats[key.(string)] = entry
+		return true
+	})
+	return stats
+}
+
+// CachePurgeFilter 批量清除条件，各条件同时满足才清除
+type CachePurgeFilter struct {
+	Prefix    string        // key前缀，为空时不限
+	Pattern   string        // path.Match风格的key通配符，为空时不限
+	OlderThan time.Duration // 写入时间早于该时长，为0时不限
+}
+
+// CachePurgeResult 批量清除结果
+type CachePurgeResult struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Purge 按条件清除缓存项。缓存项写入后不再修改，正在输出的请求持有的数据不受影响，
+// 仅在key仍指向同一项时才删除，避免误删并发写入的新数据
+func (c *UniversalCache) Purge(filter CachePurgeFilter) (CachePurgeResult, error) {
+	if filter.Pattern != "" {
+		if _, err := path.Match(filter.Pattern, ""); err != nil {
+			return CachePurgeResult{}, fmt.Errorf("无效的匹配模式: %w", err)
+		}
+	}
+
+	var result CachePurgeResult
+	cutoff := time.Now().Add(-filter.OlderThan)
+	c.cache.Range(func(key, value interface{}) bool {
+		k := key.(string)
+		item := value.(*CachedItem)
+		if !strings.HasPrefix(k, filter.Prefix) {
+			return true
+		}
+		if filter.Pattern != "" {
+			if matched, _ := path.Match(filter.Pattern, k); !matched {
+				return true
+			}
+		}
+		if filter.OlderThan > 0 && item.StoredAt.After(cutoff) {
+			return true
+		}
+		if c.cache.CompareAndDelete(key, value) {
+			result.Entries++
+			result.Bytes += int64(len(item.Data))
+		}
+		return true
+	})
+	return result, nil
+}
+
+// Flush 清除指定前缀的缓存项，prefix为空时清空全部，返回清除数量
+func (c *UniversalCache) Flush(prefix string) int {
+	removed := 0
+	c.cache.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.cache.Delete(key)
+			removed++
+		}
+		return true
 	})
+	return removed
 }
 
 func (c *UniversalCache) GetToken(key string) string {
@@ -63,17 +356,77 @@ func BuildCacheKey(prefix, query string) string {
 	return fmt.Sprintf("%s:%x", prefix, md5.Sum([]byte(query)))
 }
 
+// BuildTokenCacheKey 构建token缓存key，参数顺序和编码不同的相同请求使用同一key
 func BuildTokenCacheKey(query string) string {
+	if values, err := url.ParseQuery(query); err == nil {
+		query = values.Encode()
+	}
 	return BuildCacheKey("token", query)
 }
 
-func BuildManifestCacheKey(imageRef, reference string) string {
+// BuildManifestCacheKey 构建manifest缓存key，accept为ManifestAcceptKey规范化后的客户端Accept，
+// 为空时对应未指定Accept的请求和预热写入的缓存项
+func BuildManifestCacheKey(imageRef, reference, accept string) string {
 	key := fmt.Sprintf("%s:%s", imageRef, reference)
+	if accept != "" {
+		key += "|" + accept
+	}
 	return BuildCacheKey("manifest", key)
 }
 
-func GetManifestTTL(reference string) time.Duration {
-	cfg := config.GetConfig()
+// 缓存key前缀，用于按类别清除
+const (
+	TokenCachePrefix            = "token:"
+	ManifestCachePrefix         = "manifest:"
+	NegativeManifestCachePrefix = "negmanifest:"
+	GitHubAPICachePrefix        = "githubapi:"
+)
+
+// BuildNegativeManifestCacheKey 构建manifest不存在结果的缓存key
+func BuildNegativeManifestCacheKey(imageRef, reference string) string {
+	key := fmt.Sprintf("%s:%s", imageRef, reference)
+	return BuildCacheKey("negmanifest", key)
+}
+
+// GetNegativeManifestTTL 获取manifest不存在结果的缓存时间，digest引用内容不可变可缓存更久
+func GetNegativeManifestTTL(cfg *config.AppConfig, reference string) time.Duration {
+	value := cfg.DockerCache.NegativeTTL
+	if strings.HasPrefix(reference, "sha256:") {
+		value = cfg.DockerCache.NegativeDigestTTL
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// parseStaleWindow 解析过期数据可用时间，无效或为0时关闭
+func parseStaleWindow(value string) time.Duration {
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0
+	}
+	return window
+}
+
+// GetStaleWhileRevalidate manifest过期后可直接返回并后台刷新的时间
+func GetStaleWhileRevalidate(cfg *config.AppConfig) time.Duration {
+	return parseStaleWindow(cfg.DockerCache.StaleWhileRevalidate)
+}
+
+// GetRevalidateFor 带验证器的元数据缓存过期后保留用于条件请求的时间
+func GetRevalidateFor(cfg *config.AppConfig) time.Duration {
+	return parseStaleWindow(cfg.TokenCache.RevalidateFor)
+}
+
+// GetStaleIfError 上游不可用时可返回过期manifest的时间
+func GetStaleIfError(cfg *config.AppConfig) time.Duration {
+	return parseStaleWindow(cfg.DockerCache.StaleIfError)
+}
+
+func GetManifestTTL(cfg *config.AppConfig, reference string) time.Duration {
 	defaultTTL := 30 * time.Minute
 	if cfg.TokenCache.DefaultTTL != "" {
 		if parsed, err := time.ParseDuration(cfg.TokenCache.DefaultTTL); err == nil {
@@ -128,37 +481,21 @@ func WriteCachedResponse(c *gin.Context, item *CachedItem) {
 	c.Data(200, item.ContentType, item.Data)
 }
 
+// WriteStaleResponse 返回过期的缓存数据，附带Age和Warning响应头
+func WriteStaleResponse(c *gin.Context, item *CachedItem, warning string) {
+	c.Header("Age", strconv.Itoa(int(time.Since(item.StoredAt).Seconds())))
+	c.Header("Warning", warning)
+	c.Header("X-Cache", "STALE")
+	SetCacheOutcome(c, CacheStale)
+	WriteCachedResponse(c, item)
+}
+
 // IsCacheEnabled 检查缓存是否启用
-func IsCacheEnabled() bool {
-	cfg := config.GetConfig()
+func IsCacheEnabled(cfg *config.AppConfig) bool {
 	return cfg.TokenCache.Enabled
 }
 
 // IsTokenCacheEnabled 检查token缓存是否启用
-func IsTokenCacheEnabled() bool {
-	return IsCacheEnabled()
-}
-
-// 定期清理过期缓存
-func init() {
-	go func() {
-		ticker := time.NewTicker(20 * time.Minute)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			now := time.Now()
-			expiredKeys := make([]string, 0)
-
-			GlobalCache.cache.Range(func(key, value interface{}) bool {
-				if cached := value.(*CachedItem); now.After(cached.ExpiresAt) {
-					expiredKeys = append(expiredKeys, key.(string))
-				}
-				return true
-			})
-
-			for _, key := range expiredKeys {
-				GlobalCache.cache.Delete(key)
-			}
-		}
-	}()
+func IsTokenCacheEnabled(cfg *config.AppConfig) bool {
+	return IsCacheEnabled(cfg)
 }
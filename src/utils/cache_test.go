@@ -1,12 +1,18 @@
 package utils
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
 )
 
 func TestUniversalCacheSetGetAndExpire(t *testing.T) {
-	cache := &UniversalCache{}
+	cache := &UniversalCache{config: testStore()}
 
 	cache.Set("k", []byte("v"), "text/plain", map[string]string{"X-Test": "1"}, time.Minute)
 	if got := cache.Get("k"); got == nil || string(got.Data) != "v" || got.Headers["X-Test"] != "1" {
@@ -20,7 +26,7 @@ func TestUniversalCacheSetGetAndExpire(t *testing.T) {
 }
 
 func TestTokenCacheHelpers(t *testing.T) {
-	cache := &UniversalCache{}
+	cache := &UniversalCache{config: testStore()}
 	cache.SetToken("token", `{"token":"abc"}`, time.Minute)
 
 	if got := cache.GetToken("token"); got != `{"token":"abc"}` {
@@ -47,3 +53,197 @@ func TestBuildCacheKeyStable(t *testing.T) {
 		t.Fatalf("unexpected keys: %q %q %q", a, b, c)
 	}
 }
+
+func TestUniversalCacheFlushByPrefix(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	cache.Set(BuildNegativeManifestCacheKey("a/b", "latest"), []byte("x"), "", nil, time.Minute)
+	cache.Set(BuildManifestCacheKey("a/b", "latest", ""), []byte("y"), "", nil, time.Minute)
+
+	if removed := cache.Flush(NegativeManifestCachePrefix); removed != 1 {
+		t.Fatalf("removed = %d, want 1", removed)
+	}
+	if cache.Get(BuildManifestCacheKey("a/b", "latest", "")) == nil {
+		t.Fatal("manifest entry flushed with negative prefix")
+	}
+	if removed := cache.Flush(""); removed != 1 {
+		t.Fatalf("flush all removed = %d, want 1", removed)
+	}
+}
+
+func TestUniversalCacheStats(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	key := BuildManifestCacheKey("a/b", "latest", "")
+	cache.Set(key, []byte("12345"), "", nil, time.Minute)
+	cache.Get(key)
+	cache.Get(BuildManifestCacheKey("a/b", "missing", ""))
+
+	stats := cache.Stats()["manifest"]
+	if stats.Entries != 1 || stats.Bytes != 5 || stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 {
+		t.Fatalf("manifest stats = %+v", stats)
+	}
+}
+
+func TestUniversalCachePurgeFilters(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	cache.Set("manifest:old", []byte("aa"), "", nil, time.Hour)
+	cache.Set("manifest:new", []byte("bbb"), "", nil, time.Hour)
+	cache.Set("token:old", []byte("c"), "", nil, time.Hour)
+
+	old, _ := cache.cache.Load("manifest:old")
+	old.(*CachedItem).StoredAt = time.Now().Add(-2 * time.Hour)
+
+	result, err := cache.Purge(CachePurgeFilter{Prefix: ManifestCachePrefix, OlderThan: time.Hour})
+	if err != nil || result.Entries != 1 || result.Bytes != 2 {
+		t.Fatalf("age purge = %+v, %v", result, err)
+	}
+	if cache.Get("manifest:new") == nil {
+		t.Fatal("fresh entry purged")
+	}
+
+	result, err = cache.Purge(CachePurgeFilter{Pattern: "*:old"})
+	if err != nil || result.Entries != 1 || cache.Get("token:old") != nil {
+		t.Fatalf("pattern purge = %+v, %v", result, err)
+	}
+
+	if _, err := cache.Purge(CachePurgeFilter{Pattern: "["}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+}
+
+func TestUniversalCachePurgeDuringRead(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	payload := bytes.Repeat([]byte("hubproxy"), 1024)
+	cache.Set("manifest:busy", payload, "", nil, time.Minute)
+
+	// 模拟正在从缓存输出的请求：先读一半，清除后再读完
+	item := cache.Get("manifest:busy")
+	reader := bytes.NewReader(item.Data)
+	var out bytes.Buffer
+	if _, err := io.CopyN(&out, reader, int64(len(payload)/2)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cache.Purge(CachePurgeFilter{Prefix: ManifestCachePrefix})
+	if err != nil || result.Entries != 1 || result.Bytes != int64(len(payload)) {
+		t.Fatalf("purge = %+v, %v", result, err)
+	}
+	if cache.Get("manifest:busy") != nil {
+		t.Fatal("entry still cached after purge")
+	}
+
+	if _, err := io.Copy(&out, reader); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), payload) {
+		t.Fatal("in-flight read corrupted by purge")
+	}
+}
+
+func TestUniversalCacheGetStale(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	cache.SetWithStale("manifest:a", []byte("v"), "", nil, -time.Second, time.Minute)
+	cache.Set("manifest:b", []byte("v"), "", nil, -time.Second)
+
+	if cache.Get("manifest:a") != nil {
+		t.Fatal("expired item returned by Get")
+	}
+	if item, stale := cache.GetStale("manifest:a"); item == nil || !stale {
+		t.Fatalf("GetStale = %v, %v", item, stale)
+	}
+	if item, _ := cache.GetStale("manifest:b"); item != nil {
+		t.Fatal("item without stale window returned")
+	}
+}
+
+func TestRefreshInBackgroundSingleFlight(t *testing.T) {
+	cache := &UniversalCache{config: testStore()}
+	release := make(chan struct{})
+	done := make(chan struct{})
+	if !cache.RefreshInBackground("k", func() {
+		<-release
+		close(done)
+	}) {
+		t.Fatal("first refresh not started")
+	}
+	if cache.RefreshInBackground("k", func() { t.Error("duplicate refresh ran") }) {
+		t.Fatal("duplicate refresh started")
+	}
+	close(release)
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for !cache.RefreshInBackground("k", func() {}) {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh not released after completion")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetNegativeManifestTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[dockerCache]
+negativeTTL = "15s"
+negativeDigestTTL = "2m"
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := GetNegativeManifestTTL(config.GetConfig(), "latest"); ttl != 15*time.Second {
+		t.Fatalf("tag TTL = %s", ttl)
+	}
+	if ttl := GetNegativeManifestTTL(config.GetConfig(), "sha256:abc"); ttl != 2*time.Minute {
+		t.Fatalf("digest TTL = %s", ttl)
+	}
+}
+
+func TestUniversalCacheRevalidation(t *testing.T) {
+	loadTestConfig(t, "[tokenCache]\nrevalidateFor = \"1h\"\n")
+	store := testStore()
+	cache := &UniversalCache{config: store}
+	key := BuildCacheKey("githubapi", "latest")
+
+	cache.SetWithStale(key, []byte("body"), "application/json", map[string]string{"ETag": `"v1"`, "Link": "a"}, -time.Second, 0)
+	if item, stale := cache.GetStale(key); item != nil || stale {
+		t.Fatal("expired item beyond stale window returned")
+	}
+	item := cache.GetForRevalidation(key)
+	if item == nil || item.Validators().ETag != `"v1"` {
+		t.Fatalf("item not retained for revalidation: %#v", item)
+	}
+
+	renewed := cache.Renew(key, item, map[string]string{"Link": "b"}, time.Minute)
+	if got := cache.Get(key); got != renewed || string(got.Data) != "body" || got.Headers["ETag"] != `"v1"` || got.Headers["Link"] != "b" {
+		t.Fatalf("renewed item = %#v", got)
+	}
+	if item.Headers["Link"] != "a" {
+		t.Fatal("renew modified the previous item")
+	}
+	if stats := cache.Stats()["githubapi"]; stats.Revalidated != 1 || stats.RevalidationSavedBytes != 4 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// 没有验证器的项过期后不保留
+	cache.SetWithStale("manifest:plain", []byte("x"), "", nil, -time.Second, 0)
+	if cache.GetForRevalidation("manifest:plain") != nil {
+		t.Fatal("item without validators retained")
+	}
+	// manifest以digest作为验证器
+	cache.SetWithStale("manifest:digest", []byte("x"), "", map[string]string{"Docker-Content-Digest": "sha256:abc"}, -time.Second, 0)
+	if item := cache.GetForRevalidation("manifest:digest"); item == nil || item.Validators().ETag != `"sha256:abc"` {
+		t.Fatalf("digest validator = %#v", item)
+	}
+
+	loadTestConfig(t, "[tokenCache]\nrevalidateFor = \"0\"\n")
+	store.Set(config.GetConfig())
+	cache.SetWithStale(key, []byte("body"), "", map[string]string{"ETag": `"v1"`}, -time.Second, 0)
+	if cache.GetForRevalidation(key) != nil {
+		t.Fatal("item retained with revalidation disabled")
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// CacheBypassCost 要求绕过缓存的请求计入限流的次数，避免借此反复穿透到上游
+const CacheBypassCost = 2
+
+// clientCacheDirectives 解析请求的Cache-Control和Pragma，cfg未启用cache.honorClientDirectives时均为false
+func clientCacheDirectives(cfg *config.AppConfig, r *http.Request) (noCache, noStore bool) {
+	if !cfg.Cache.HonorClientDirectives {
+		return false, false
+	}
+	for _, value := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+			switch name {
+			case "no-cache":
+				noCache = true
+			case "no-store":
+				noStore = true
+			}
+		}
+	}
+	// 同时带Cache-Control时以Cache-Control为准
+	if r.Header.Get("Cache-Control") == "" {
+		for _, value := range r.Header.Values("Pragma") {
+			if strings.EqualFold(strings.TrimSpace(value), "no-cache") {
+				noCache = true
+			}
+		}
+	}
+	return noCache, noStore
+}
+
+// CacheBypassRequested 按cfg判断请求是否要求绕过代理缓存
+func CacheBypassRequested(cfg *config.AppConfig, r *http.Request) bool {
+	noCache, noStore := clientCacheDirectives(cfg, r)
+	return noCache || noStore
+}
+
+// CacheReadAllowed 按cfg判断当前请求能否使用代理缓存中的响应(包括过期数据和重新验证)，
+// 客户端要求绕过时写入 X-Cache-Bypass: 1 并返回false
+func CacheReadAllowed(cfg *config.AppConfig, c *gin.Context) bool {
+	if !CacheBypassRequested(cfg, c.Request) {
+		return true
+	}
+	c.Header("X-Cache-Bypass", "1")
+	return false
+}
+
+// CacheWriteAllowed 按cfg判断当前请求的响应能否写入代理缓存，客户端带no-store时返回false
+func CacheWriteAllowed(cfg *config.AppConfig, c *gin.Context) bool {
+	_, noStore := clientCacheDirectives(cfg, c.Request)
+	if noStore {
+		c.Header("X-Cache-Bypass", "1")
+	}
+	return !noStore
+}
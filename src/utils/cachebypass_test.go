@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestClientCacheDirectives(t *testing.T) {
+	loadTestConfig(t, "")
+	tests := []struct {
+		header           http.Header
+		noCache, noStore bool
+	}{
+		{http.Header{}, false, false},
+		{http.Header{"Cache-Control": {"no-cache"}}, true, false},
+		{http.Header{"Cache-Control": {"max-age=0, No-Store"}}, false, true},
+		{http.Header{"Cache-Control": {"max-age=0", "no-cache"}}, true, false},
+		{http.Header{"Pragma": {"no-cache"}}, true, false},
+		// Cache-Control优先于Pragma
+		{http.Header{"Cache-Control": {"max-age=60"}, "Pragma": {"no-cache"}}, false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = tt.header
+		if noCache, noStore := clientCacheDirectives(config.GetConfig(), req); noCache != tt.noCache || noStore != tt.noStore {
+			t.Errorf("%v: no-cache %v, no-store %v", tt.header, noCache, noStore)
+		}
+	}
+
+	loadTestConfig(t, "[cache]\nhonorClientDirectives = false\n")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cache-Control", "no-cache, no-store")
+	if CacheBypassRequested(config.GetConfig(), req) {
+		t.Fatal("directives honored while disabled")
+	}
+}
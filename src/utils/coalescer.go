@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// 可合并的上游请求类别
+const (
+	CoalesceClassToken        = "token"
+	CoalesceClassManifestHead = "manifestHead"
+	CoalesceClassTags         = "tags"
+)
+
+// coalescedCall 一次被合并的上游调用
+type coalescedCall struct {
+	done    chan struct{}
+	result  interface{}
+	err     error
+	waiters int
+}
+
+// RequestCoalescer 合并窗口期内相同的上游请求，只发起一次调用并将结果分发给所有等待者
+type RequestCoalescer struct {
+	mu       sync.Mutex
+	calls    map[string]*coalescedCall
+	upstream atomic.Int64
+	merged   atomic.Int64
+	bypassed atomic.Int64
+}
+
+// CoalescerStats 合并统计
+type CoalescerStats struct {
+	Upstream int64 `json:"upstream"`
+	Merged   int64 `json:"merged"`
+	Bypassed int64 `json:"bypassed"`
+	InFlight int   `json:"in_flight"`
+}
+
+// NewRequestCoalescer 创建请求合并器
+func NewRequestCoalescer() *RequestCoalescer {
+	return &RequestCoalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// Do 执行或加入相同key的上游调用，window为调用完成后结果的复用时间，
+// maxWaiters限制单个调用的等待者数量，超出后直接独立请求。返回值shared表示结果来自合并。
+func (rc *RequestCoalescer) Do(key string, window time.Duration, maxWaiters int, fn func() (interface{}, error)) (interface{}, bool, error) {
+	rc.mu.Lock()
+	if call, exists := rc.calls[key]; exists {
+		if maxWaiters > 0 && call.waiters >= maxWaiters {
+			rc.mu.Unlock()
+			rc.bypassed.Add(1)
+			result, err := fn()
+			return result, false, err
+		}
+		call.waiters++
+		rc.mu.Unlock()
+		rc.merged.Add(1)
+
+		<-call.done
+		return call.result, true, call.err
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	rc.calls[key] = call
+	rc.mu.Unlock()
+	rc.upstream.Add(1)
+
+	// fn异常时等待者收到错误后返回，key随即释放，异常继续抛给发起调用的请求
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("合并的上游调用异常: %v", r)
+			close(call.done)
+			rc.forget(key, call)
+			panic(r)
+		}
+	}()
+	call.result, call.err = fn()
+	close(call.done)
+
+	if call.err != nil || window <= 0 {
+		rc.forget(key, call)
+	} else {
+		time.AfterFunc(window, func() { rc.forget(key, call) })
+	}
+
+	return call.result, false, call.err
+}
+
+func (rc *RequestCoalescer) forget(key string, call *coalescedCall) {
+	rc.mu.Lock()
+	if rc.calls[key] == call {
+		delete(rc.calls, key)
+	}
+	rc.mu.Unlock()
+}
+
+// Stats 返回合并统计
+func (rc *RequestCoalescer) Stats() CoalescerStats {
+	rc.mu.Lock()
+	inFlight := len(rc.calls)
+	rc.mu.Unlock()
+
+	return CoalescerStats{
+		Upstream: rc.upstream.Load(),
+		Merged:   rc.merged.Load(),
+		Bypassed: rc.bypassed.Load(),
+		InFlight: inFlight,
+	}
+}
+
+// Coalescers 按类别划分的上游请求合并器
+type Coalescers map[string]*RequestCoalescer
+
+// NewCoalescers 为token、manifestHead和tags类别各创建一个合并器
+func NewCoalescers() Coalescers {
+	return Coalescers{
+		CoalesceClassToken:        NewRequestCoalescer(),
+		CoalesceClassManifestHead: NewRequestCoalescer(),
+		CoalesceClassTags:         NewRequestCoalescer(),
+	}
+}
+
+// isCoalesceClassEnabled 检查指定类别是否启用合并
+func isCoalesceClassEnabled(cfg *config.AppConfig, class string) bool {
+	if !cfg.Debounce.Enabled {
+		return false
+	}
+	for _, c := range cfg.Debounce.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Coalesce 按cfg对指定类别的上游请求进行合并，未启用时直接调用fn
+func (cs Coalescers) Coalesce(cfg *config.AppConfig, class, key string, fn func() (interface{}, error)) (interface{}, bool, error) {
+	rc, exists := cs[class]
+	if !exists || !isCoalesceClassEnabled(cfg, class) {
+		result, err := fn()
+		return result, false, err
+	}
+
+	window, err := time.ParseDuration(cfg.Debounce.Window)
+	if err != nil {
+		window = 0
+	}
+	return rc.Do(key, window, cfg.Debounce.MaxWaiters, fn)
+}
+
+// Stats 返回各类别的合并统计
+func (cs Coalescers) Stats() map[string]CoalescerStats {
+	stats := make(map[string]CoalescerStats, len(cs))
+	for class, rc := range cs {
+		stats[class] = rc.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestCoalescerMergesConcurrentCalls(t *testing.T) {
+	rc := NewRequestCoalescer()
+	const n = 20
+
+	var hits atomic.Int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		hits.Add(1)
+		<-release
+		return `{"token":"abc"}`, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := rc.Do("token:key", time.Second, 0, fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for rc.Stats().Merged < n-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("upstream hits = %d, want 1", got)
+	}
+	for result := range results {
+		if result != `{"token":"abc"}` {
+			t.Fatalf("unexpected fanned out result: %#v", result)
+		}
+	}
+	if stats := rc.Stats(); stats.Upstream != 1 || stats.Merged != n-1 {
+		t.Fatalf("stats = %#v", stats)
+	}
+}
+
+func TestRequestCoalescerReusesResultWithinWindow(t *testing.T) {
+	rc := NewRequestCoalescer()
+	var hits atomic.Int32
+	fn := func() (interface{}, error) {
+		hits.Add(1)
+		return "ok", nil
+	}
+
+	rc.Do("k", 50*time.Millisecond, 0, fn)
+	if _, shared, _ := rc.Do("k", 50*time.Millisecond, 0, fn); !shared {
+		t.Fatal("call inside window not merged")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	rc.Do("k", 50*time.Millisecond, 0, fn)
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestRequestCoalescerDoesNotKeepErrors(t *testing.T) {
+	rc := NewRequestCoalescer()
+	var hits atomic.Int32
+	fn := func() (interface{}, error) {
+		hits.Add(1)
+		return nil, errors.New("upstream down")
+	}
+
+	rc.Do("k", time.Minute, 0, fn)
+	rc.Do("k", time.Minute, 0, fn)
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("failed result reused: hits = %d", got)
+	}
+}
+
+func TestRequestCoalescerMaxWaiters(t *testing.T) {
+	rc := NewRequestCoalescer()
+	release := make(chan struct{})
+	go rc.Do("k", 0, 1, func() (interface{}, error) {
+		<-release
+		return "leader", nil
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for rc.Stats().InFlight == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	go rc.Do("k", 0, 1, func() (interface{}, error) { return "waiter", nil })
+	for rc.Stats().Merged == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	result, shared, _ := rc.Do("k", 0, 1, func() (interface{}, error) { return "bypass", nil })
+	close(release)
+	if shared || result != "bypass" || rc.Stats().Bypassed != 1 {
+		t.Fatalf("waiter beyond limit got %v shared=%v", result, shared)
+	}
+}
+
+func TestRequestCoalescerReleasesWaitersOnPanic(t *testing.T) {
+	rc := NewRequestCoalescer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	leaderPanicked := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanicked <- recover() }()
+		rc.Do("key", time.Minute, 0, func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, _, err := rc.Do("key", time.Minute, 0, func() (interface{}, error) { return "independent", nil })
+		waiterErr <- err
+	}()
+	for rc.Stats().Merged == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if r := <-leaderPanicked; r != "boom" {
+		t.Fatalf("leader recovered %v, want boom", r)
+	}
+	select {
+	case err := <-waiterErr:
+		if err == nil {
+			t.Fatal("waiter got no error from panicked call")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after leader panic")
+	}
+
+	// 异常的调用不保留在合并窗口内，后续请求重新调用上游
+	result, shared, err := rc.Do("key", time.Minute, 0, func() (interface{}, error) { return "fresh", nil })
+	if result != "fresh" || shared || err != nil {
+		t.Fatalf("after panic = %v, %v, %v", result, shared, err)
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// Components 一个服务实例的配置，以及共享的统计、调度、审计和隐私状态、后台任务、签名密钥、令牌库和下载历史。
+// 由server创建后注入代理、限流器和处理器，同一进程中的多个服务互不影响
+type Components struct {
+	// Config 该服务的当前配置，热重载时由服务的Reloader替换
+	Config       *config.Store
+	Background   *Background
+	Stats        *StatsRegistry
+	Memory       *MemoryGuard
+	Adaptive     *AdaptiveLimit
+	Schedule     *HeavySchedule
+	Activity     *ActivityBroadcaster
+	Usage        *UsageTracker
+	Screener     *Screener
+	SlowRequests *SlowRequestLog
+	Denials      *DenialLog
+	IPs          *IPIdentifier
+	Coalescers   Coalescers
+	Signing      *SigningKeys
+	Tokens       *TokenStores
+	History      *HistoryLog
+}
+
+// NewComponents 创建持有cfg的一组空的共享状态，cfg为nil时使用默认配置
+func NewComponents(cfg *config.AppConfig) *Components {
+	store := config.NewStore(cfg)
+	background := NewBackground()
+	return &Components{
+		Config:       store,
+		Background:   background,
+		Stats:        NewStatsRegistry(),
+		Memory:       NewMemoryGuard(store),
+		Adaptive:     NewAdaptiveLimit(store),
+		Schedule:     NewHeavySchedule(store),
+		Activity:     NewActivityBroadcaster(32),
+		Usage:        NewUsageTracker(),
+		Screener:     NewScreener(store),
+		SlowRequests: NewSlowRequestLog(),
+		Denials:      NewDenialLog(),
+		IPs:          NewIPIdentifier(store),
+		Coalescers:   NewCoalescers(),
+		Signing:      &SigningKeys{config: store},
+		Tokens:       NewTokenStores(store, background),
+		History:      NewHistoryLog(store, background),
+	}
+}
+
+// StatsSnapshot 返回统计快照，附带重任务调度和自适应限流的当前状态
+func (c *Components) StatsSnapshot(window time.Duration) StatsSnapshot {
+	snapshot := c.Stats.Snapshot(c.Config.Get(), window)
+	snapshot.Schedule = c.Schedule.Snapshot()
+	snapshot.AdaptiveLimit = c.Adaptive.Snapshot()
+	return snapshot
+}
+
+// requestConfigKey 请求上下文中所属服务当前配置的key
+const requestConfigKey = "request_config"
+
+// RequestConfigMiddleware 把store中的当前配置放入请求上下文，同一请求内读取到的配置保持一致
+func RequestConfigMiddleware(store *config.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(requestConfigKey, store.Get())
+		c.Next()
+	}
+}
+
+// RequestConfig 返回请求所属服务的配置，未经RequestConfigMiddleware的请求返回默认配置
+func RequestConfig(c *gin.Context) *config.AppConfig {
+	if c != nil {
+		if cfg, ok := c.Get(requestConfigKey); ok {
+			return cfg.(*config.AppConfig)
+		}
+	}
+	return config.DefaultConfig()
+}
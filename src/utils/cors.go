@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// matchOriginPattern Origin匹配，支持 * 和 https://*.example.com 形式的通配
+func matchOriginPattern(origin, pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "*" {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.HasPrefix(host, "*.") {
+		return strings.EqualFold(origin, pattern)
+	}
+	originScheme, originHost, ok := strings.Cut(origin, "://")
+	return ok && strings.EqualFold(originScheme, scheme) && matchHostPattern(originHost, host)
+}
+
+// corsApplies CORS仅作用于JSON接口、健康检查和搜索路由
+func corsApplies(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/ready" || path == "/health" ||
+		path == "/search" || strings.HasPrefix(path, "/tags/")
+}
+
+// CORSMiddleware 按store中的当前配置处理跨域的中间件，需注册在认证和限流之前以便预检请求直接返回
+func CORSMiddleware(store *config.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := store.Get().CORS
+		origin := c.GetHeader("Origin")
+		if !cfg.Enabled || origin == "" || !corsApplies(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+
+		allowed, wildcard := false, false
+		for _, pattern := range cfg.AllowedOrigins {
+			if matchOriginPattern(origin, pattern) {
+				allowed = true
+				wildcard = strings.TrimSpace(pattern) == "*"
+				break
+			}
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowed {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if wildcard {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		c.Header("Access-Control-Expose-Headers", "X-Error-Code")
+
+		if !preflight {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+		if len(cfg.AllowedHeaders) > 0 {
+			c.Header("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
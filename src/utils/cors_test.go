@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func newCORSTestRouter(store *config.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware(store))
+	router.GET("/api/install-script", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	router.GET("/v2/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	return router
+}
+
+func corsRequest(router http.Handler, method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	loadTestConfig(t, `
+[cors]
+enabled = true
+allowedOrigins = ["https://app.example.com", "https://*.trusted.dev"]
+allowedMethods = ["GET", "POST"]
+allowedHeaders = ["Content-Type"]
+maxAge = 300
+`)
+	router := newCORSTestRouter(testStore())
+
+	w := corsRequest(router, http.MethodGet, "/api/install-script", "https://app.example.com", nil)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("allow origin = %q", got)
+	}
+	if w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("Vary = %q", w.Header().Get("Vary"))
+	}
+
+	preflight := map[string]string{"Access-Control-Request-Method": "POST"}
+	w = corsRequest(router, http.MethodOptions, "/api/install-script", "https://ci.trusted.dev", preflight)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		w.Header().Get("Access-Control-Max-Age") != "300" {
+		t.Fatalf("unexpected preflight headers: %v", w.Header())
+	}
+
+	// 未注册OPTIONS的路径同样需要处理预检
+	w = corsRequest(router, http.MethodOptions, "/api/image/download/nginx", "https://app.example.com", preflight)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unrouted preflight status = %d, want 204", w.Code)
+	}
+
+	w = corsRequest(router, http.MethodOptions, "/api/install-script", "https://evil.example", preflight)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("disallowed preflight status = %d, want 403", w.Code)
+	}
+	w = corsRequest(router, http.MethodGet, "/api/install-script", "https://evil.example", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("disallowed origin got CORS headers: %v", w.Header())
+	}
+
+	w = corsRequest(router, http.MethodGet, "/v2/", "https://app.example.com", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS applied outside API routes")
+	}
+}
+
+func TestCORSMiddlewareWildcardAndDisabled(t *testing.T) {
+	loadTestConfig(t, `
+[cors]
+enabled = true
+allowedOrigins = ["*"]
+`)
+	store := testStore()
+	router := newCORSTestRouter(store)
+	w := corsRequest(router, http.MethodGet, "/api/install-script", "https://any.example", nil)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("allow origin = %q, want *", got)
+	}
+
+	loadTestConfig(t, `
+[cors]
+enabled = false
+allowedOrigins = ["*"]
+`)
+	store.Set(config.GetConfig())
+	w = corsRequest(router, http.MethodGet, "/api/install-script", "https://any.example", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS headers set while disabled")
+	}
+}
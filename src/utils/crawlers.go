@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 爬虫处理方式
+const (
+	CrawlerActionLimit = "limit"
+	CrawlerActionBlock = "block"
+)
+
+// CrawlerStats 爬虫拦截计数
+type CrawlerStats struct {
+	Blocked int64 `json:"blocked"`
+	Limited int64 `json:"limited"`
+}
+
+// CompileCrawlerPatterns 将UA规则合并为一个不区分大小写的正则，未配置任何有效规则时返回nil。
+// 普通规则按子串匹配，"re:" 前缀的规则按正则匹配，无效正则会被跳过
+func CompileCrawlerPatterns(patterns []string) *regexp.Regexp {
+	parts := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			if _, err := regexp.Compile(expr); err != nil {
+				fmt.Printf("警告: 无效的爬虫UA正则 %s: %v\n", expr, err)
+				continue
+			}
+			parts = append(parts, "(?:"+expr+")")
+			continue
+		}
+		parts = append(parts, regexp.QuoteMeta(pattern))
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(parts, "|"))
+}
+
+// RecordCrawlerBlocked 记录一次被直接拒绝的爬虫请求
+func (r *StatsRegistry) RecordCrawlerBlocked() {
+	r.crawlerBlocked.Add(1)
+}
+
+// RecordCrawlerLimited 记录一次超出爬虫限额的请求
+func (r *StatsRegistry) RecordCrawlerLimited() {
+	r.crawlerLimited.Add(1)
+}
+
+// CrawlerStats 获取爬虫拦截计数
+func (r *StatsRegistry) CrawlerStats() CrawlerStats {
+	return CrawlerStats{
+		Blocked: r.crawlerBlocked.Load(),
+		Limited: r.crawlerLimited.Load(),
+	}
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "testing"
+
+func TestCompileCrawlerPatterns(t *testing.T) {
+	pattern := CompileCrawlerPatterns([]string{"GPTBot", " ", "re:^curl/7\\.", "re:(", "a.b"})
+	tests := []struct {
+		ua   string
+		want bool
+	}{
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2)", true},
+		{"mozilla/5.0 (compatible; gptbot/1.0)", true},
+		{"curl/7.88.1", true},
+		{"curl/8.5.0", false},
+		{"axb", false},
+		{"a.b", true},
+		{"git/2.43.0", false},
+	}
+	for _, tt := range tests {
+		if got := pattern.MatchString(tt.ua); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.ua, got, tt.want)
+		}
+	}
+
+	if CompileCrawlerPatterns([]string{"", "re:("}) != nil {
+		t.Fatal("expected nil pattern without valid rules")
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// denialLogSize 保留的最近拒绝记录条数
+const denialLogSize = 100
+
+// DenialEvent 一次被拒绝的请求，ClientIP按noIPLogging配置脱敏，Path不含查询参数
+type DenialEvent struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Code     string    `json:"code,omitempty"`
+}
+
+// DenialLog 最近被拒绝(401/403/429)请求的环形缓冲，只保存在内存中
+type DenialLog struct {
+	mu     sync.Mutex
+	events []DenialEvent
+	next   int
+	full   bool
+}
+
+// NewDenialLog 创建拒绝记录缓冲
+func NewDenialLog() *DenialLog {
+	return &DenialLog{events: make([]DenialEvent, denialLogSize)}
+}
+
+// IsDenialStatus 判断响应状态码是否属于拒绝：未认证、禁止访问或被限流
+func IsDenialStatus(status int) bool {
+	return status == 401 || status == 403 || status == 429
+}
+
+// Record 写入一条拒绝记录，缓冲满时覆盖最旧的记录
+func (d *DenialLog) Record(event DenialEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events[d.next] = event
+	d.next = (d.next + 1) % len(d.events)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// Recent 按时间倒序返回最多limit条记录，limit小于等于0时返回全部
+func (d *DenialLog) Recent(limit int) []DenialEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count := d.next
+	if d.full {
+		count = len(d.events)
+	}
+	if limit <= 0 || limit > count {
+		limit = count
+	}
+	result := make([]DenialEvent, 0, limit)
+	for n := 1; n <= limit; n++ {
+		result = append(result, d.events[(d.next-n+len(d.events))%len(d.events)])
+	}
+	return result
+}
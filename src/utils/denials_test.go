@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDenialLogRecent(t *testing.T) {
+	d := NewDenialLog()
+	if got := d.Recent(10); len(got) != 0 {
+		t.Fatalf("empty log returned %d events", len(got))
+	}
+
+	for n := range denialLogSize + 5 {
+		d.Record(DenialEvent{Path: "/" + strconv.Itoa(n), Status: 403})
+	}
+	all := d.Recent(0)
+	if len(all) != denialLogSize {
+		t.Fatalf("kept %d events, want %d", len(all), denialLogSize)
+	}
+	if all[0].Path != "/"+strconv.Itoa(denialLogSize+4) || all[len(all)-1].Path != "/5" {
+		t.Fatalf("order: newest %s, oldest %s", all[0].Path, all[len(all)-1].Path)
+	}
+	if got := d.Recent(3); len(got) != 3 || got[2].Path != "/"+strconv.Itoa(denialLogSize+2) {
+		t.Fatalf("Recent(3) = %+v", got)
+	}
+}
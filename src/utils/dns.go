@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohTimeout 单次DoH查询的超时
+const dohTimeout = 5 * time.Second
+
+// maxDNSCacheEntries 解析缓存的条目上限，超出时先清理过期条目，仍超出则清空
+const maxDNSCacheEntries = 4096
+
+// errDoHNotFound DoH服务器确认域名没有地址，不回退到系统DNS
+var errDoHNotFound = errors.New("DoH查询无结果")
+
+// dnsCacheEntry 解析结果缓存，addrs为空表示否定缓存
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// upstreamResolver 上游连接使用的域名解析：静态hosts优先，其次DoH(带缓存)，DoH失败时回退系统DNS
+type upstreamResolver struct {
+	hosts       map[string][]string
+	dohURL      string
+	dohClient   *http.Client
+	negativeTTL time.Duration
+
+	// lookupSystem DoH失败时使用的系统解析，测试中可替换
+	lookupSystem func(ctx context.Context, host string) ([]string, error)
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+// newUpstreamResolver 按upstream.dns配置创建解析器，既没有hosts也没有DoH时返回nil
+func newUpstreamResolver(cfg *config.AppConfig) *upstreamResolver {
+	dns := cfg.Upstream.DNS
+	hosts := make(map[string][]string, len(dns.Hosts))
+	for host, value := range dns.Hosts {
+		var addrs []string
+		for _, addr := range strings.Split(value, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+				addrs = append(addrs, ip.String())
+			} else {
+				fmt.Printf("警告: upstream.dns.hosts 中 %s 的地址无效: %s\n", host, addr)
+			}
+		}
+		if len(addrs) > 0 {
+			hosts[normalizeDNSName(host)] = addrs
+		}
+	}
+
+	dohURL := strings.TrimSpace(dns.DoHURL)
+	if len(hosts) == 0 && dohURL == "" {
+		return nil
+	}
+
+	return &upstreamResolver{
+		hosts:        hosts,
+		dohURL:       dohURL,
+		dohClient:    newDoHClient(strings.TrimSpace(dns.Bootstrap)),
+		negativeTTL:  ParseTimeout(dns.NegativeTTL, 30*time.Second),
+		lookupSystem: net.DefaultResolver.LookupHost,
+		now:          time.Now,
+		cache:        make(map[string]dnsCacheEntry),
+	}
+}
+
+// newDoHClient 创建查询DoH的客户端，bootstrap为DoH服务器的IP，设置后连接DoH域名时不经过任何DNS
+func newDoHClient(bootstrap string) *http.Client {
+	dialer := &net.Dialer{Timeout: dohTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: dohTimeout,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	if ip := net.ParseIP(bootstrap); ip != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		}
+	} else if bootstrap != "" {
+		fmt.Printf("警告: upstream.dns.bootstrap 不是有效的IP: %s\n", bootstrap)
+	}
+	return &http.Client{Timeout: dohTimeout, Transport: transport}
+}
+
+// normalizeDNSName 统一为小写并去掉末尾的点
+func normalizeDNSName(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// lookup 解析host为IP列表
+func (r *upstreamResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	host = normalizeDNSName(host)
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	if r.dohURL == "" {
+		return r.lookupSystem(ctx, host)
+	}
+
+	now := r.now()
+	r.mu.Lock()
+	entry, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if len(entry.addrs) == 0 {
+			return nil, &net.DNSError{Err: errDoHNotFound.Error(), Name: host, IsNotFound: true}
+		}
+		return entry.addrs, nil
+	}
+
+	addrs, ttl, err := r.queryDoH(ctx, host)
+	switch {
+	case errors.Is(err, errDoHNotFound):
+		r.store(host, nil, r.negativeTTL, now)
+		return nil, &net.DNSError{Err: err.Error(), Name: host, IsNotFound: true}
+	case err != nil:
+		Logf(LogWarn, host, "警告: DoH解析 %s 失败，使用系统DNS: %v", host, err)
+		return r.lookupSystem(ctx, host)
+	}
+	r.store(host, addrs, ttl, now)
+	return addrs, nil
+}
+
+// store 写入缓存，ttl为0时不缓存
+func (r *upstreamResolver) store(host string, addrs []string, ttl time.Duration, now time.Time) {
+	if ttl <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= maxDNSCacheEntries {
+		for name, entry := range r.cache {
+			if !now.Before(entry.expires) {
+				delete(r.cache, name)
+			}
+		}
+		if len(r.cache) >= maxDNSCacheEntries {
+			clear(r.cache)
+		}
+	}
+	r.cache[host] = dnsCacheEntry{addrs: addrs, expires: now.Add(ttl)}
+}
+
+// queryDoH 按RFC 8484查询A和AAAA记录，返回地址和记录中最小的TTL
+func (r *upstreamResolver) queryDoH(ctx context.Context, host string) ([]string, time.Duration, error) {
+	var addrs []string
+	var minTTL uint32
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, ttl, err := r.exchange(ctx, host, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(answers) > 0 && (!found || ttl < minTTL) {
+			minTTL = ttl
+		}
+		found = found || len(answers) > 0
+		addrs = append(addrs, answers...)
+	}
+	if !found {
+		return nil, 0, errDoHNotFound
+	}
+	return addrs, time.Duration(minTTL) * time.Second, nil
+}
+
+// exchange 发送一次DoH查询，NXDOMAIN和没有对应记录都返回空结果
+func (r *upstreamResolver) exchange(ctx context.Context, host string, qtype dnsmessage.Type) ([]string, uint32, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dohTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.dohURL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.dohClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH服务器返回 %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("DoH响应无效: %w", err)
+	}
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DoH服务器返回 %s", answer.RCode)
+	}
+
+	var addrs []string
+	var minTTL uint32
+	for _, rr := range answer.Answers {
+		var ip net.IP
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue
+		}
+		if len(addrs) == 0 || rr.Header.TTL < minTTL {
+			minTTL = rr.Header.TTL
+		}
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, minTTL, nil
+}
+
+// resolvingDialContext 包装dial，目标为域名且配置了upstream.dns时先按上游解析规则得到IP，依次尝试连接
+func (p *upstreamPolicies) resolvingDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		resolver := p.resolver.Load()
+		host, port, err := net.SplitHostPort(addr)
+		if resolver == nil || err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := resolver.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, firstErr
+	}
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDoH 按records应答A/AAAA查询的DoH服务器，未列出的域名返回NXDOMAIN
+type fakeDoH struct {
+	records map[string][]string
+	ttl     uint32
+	queries atomic.Int32
+	fail    atomic.Bool
+}
+
+func (f *fakeDoH) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.queries.Add(1)
+	if f.fail.Load() || r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "unavailable", http.StatusBadGateway)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var query dnsmessage.Message
+	if err := query.Unpack(body); err != nil || len(query.Questions) != 1 {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	question := query.Questions[0]
+	answer := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeSuccess},
+		Questions: query.Questions,
+	}
+	addrs, ok := f.records[strings.TrimSuffix(question.Name.String(), ".")]
+	if !ok {
+		answer.RCode = dnsmessage.RCodeNameError
+	}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: f.ttl}
+		if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+			header.Type = dnsmessage.TypeA
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if ip.To4() == nil && question.Type == dnsmessage.TypeAAAA {
+			header.Type = dnsmessage.TypeAAAA
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip)}})
+		}
+	}
+	packed, _ := answer.Pack()
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(packed)
+}
+
+func newTestResolver(t *testing.T, doh *fakeDoH) (*upstreamResolver, *time.Time, *atomic.Int32) {
+	t.Helper()
+	server := httptest.NewServer(doh)
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	loadTestConfig(t, `
+[upstream.dns]
+dohURL = "http://doh.invalid:`+port+`/dns-query"
+bootstrap = "127.0.0.1"
+negativeTTL = "10s"
+
+[upstream.dns.hosts]
+"github.com" = "192.0.2.10, 2001:db8::10"
+`)
+	resolver := newUpstreamResolver(config.GetConfig())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	resolver.now = func() time.Time { return now }
+	var systemLookups atomic.Int32
+	resolver.lookupSystem = func(_ context.Context, host string) ([]string, error) {
+		systemLookups.Add(1)
+		return []string{"198.51.100.1"}, nil
+	}
+	return resolver, &now, &systemLookups
+}
+
+func TestUpstreamResolverPrecedence(t *testing.T) {
+	doh := &fakeDoH{ttl: 60, records: map[string][]string{
+		"github.com": {"203.0.113.99"},
+		"ghcr.io":    {"203.0.113.1", "2001:db8::1"},
+	}}
+	resolver, _, systemLookups := newTestResolver(t, doh)
+	ctx := context.Background()
+
+	// 静态hosts优先于DoH和系统DNS
+	if addrs, err := resolver.lookup(ctx, "GitHub.com."); err != nil || strings.Join(addrs, ",") != "192.0.2.10,2001:db8::10" {
+		t.Fatalf("hosts lookup = %v, %v", addrs, err)
+	}
+	if doh.queries.Load() != 0 {
+		t.Fatalf("hosts override queried DoH %d times", doh.queries.Load())
+	}
+
+	// DoH经bootstrap地址连接，doh.invalid本身不需要解析
+	if addrs, err := resolver.lookup(ctx, "ghcr.io"); err != nil || strings.Join(addrs, ",") != "203.0.113.1,2001:db8::1" {
+		t.Fatalf("DoH lookup = %v, %v", addrs, err)
+	}
+
+	// DoH不可用时回退系统DNS
+	doh.fail.Store(true)
+	if addrs, err := resolver.lookup(ctx, "quay.io"); err != nil || addrs[0] != "198.51.100.1" || systemLookups.Load() != 1 {
+		t.Fatalf("fallback lookup = %v, %v (system lookups %d)", addrs, err, systemLookups.Load())
+	}
+}
+
+func TestUpstreamResolverCache(t *testing.T) {
+	doh := &fakeDoH{ttl: 60, records: map[string][]string{"ghcr.io": {"203.0.113.1"}}}
+	resolver, now, systemLookups := newTestResolver(t, doh)
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := resolver.lookup(ctx, "ghcr.io"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A和AAAA各查询一次，之后命中缓存
+	if got := doh.queries.Load(); got != 2 {
+		t.Fatalf("DoH queries = %d, want 2", got)
+	}
+	*now = now.Add(61 * time.Second)
+	if _, err := resolver.lookup(ctx, "ghcr.io"); err != nil || doh.queries.Load() != 4 {
+		t.Fatalf("after TTL: queries = %d, err = %v", doh.queries.Load(), err)
+	}
+
+	// 不存在的域名按negativeTTL缓存，不回退系统DNS
+	var dnsErr *net.DNSError
+	for range 2 {
+		if _, err := resolver.lookup(ctx, "missing.example"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("missing lookup err = %v", err)
+		}
+	}
+	if got := doh.queries.Load(); got != 6 || systemLookups.Load() != 0 {
+		t.Fatalf("negative cache: queries = %d, system lookups = %d", got, systemLookups.Load())
+	}
+	*now = now.Add(11 * time.Second)
+	resolver.lookup(ctx, "missing.example")
+	if got := doh.queries.Load(); got != 8 {
+		t.Fatalf("after negativeTTL: queries = %d", got)
+	}
+}
+
+func TestResolvingDialContextUsesHosts(t *testing.T) {
+	loadTestConfig(t, `
+[upstream.dns.hosts]
+"registry.example" = "127.0.0.1"
+`)
+	policies := &upstreamPolicies{}
+	policies.loadUpstream(config.GetConfig())
+
+	var dialed []string
+	dial := policies.resolvingDialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, errors.New("refused")
+	})
+	dial(context.Background(), "tcp", "registry.example:443")
+	dial(context.Background(), "tcp", "10.0.0.1:443")
+	if strings.Join(dialed, " ") != "127.0.0.1:443 10.0.0.1:443" {
+		t.Fatalf("dialed = %v", dialed)
+	}
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"os"
+)
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名替换目标文件，
+// 磁盘写满或目录不可写导致失败时删除未完成的临时文件，目标文件保持原内容
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
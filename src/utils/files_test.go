@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicCleansUpOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := writeFileAtomic(path, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 目标路径是非空目录时重命名失败，临时文件应被删除
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "child"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(blocked, []byte("v2"), 0600); err == nil {
+		t.Fatal("rename over directory succeeded")
+	}
+	if _, err := os.Stat(blocked + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+
+	// 磁盘写满：/dev/full的写入总是返回ENOSPC
+	if _, err := os.Stat("/dev/full"); err == nil {
+		link := filepath.Join(dir, "full")
+		if err := os.Symlink("/dev/full", link+".tmp"); err != nil {
+			t.Skip(err)
+		}
+		if err := writeFileAtomic(link, []byte("v3"), 0600); err == nil {
+			t.Fatal("write to full device succeeded")
+		}
+		if _, err := os.Lstat(link + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("partial file left behind after ENOSPC: %v", err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "v1" {
+		t.Fatalf("original file = %q", data)
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// ParseIPNets 解析IP或CIDR列表，单个IP按主机地址处理，无效项忽略
+func ParseIPNets(items []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				continue
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		if _, ipnet, err := net.ParseCIDR(item); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets
+}
+
+// ExtractIPFromAddress 从地址中提取纯IP，支持带端口、方括号和zone标识的地址，
+// IPv4映射的IPv6地址转换为IPv4，无法解析时原样返回
+func ExtractIPFromAddress(address string) string {
+	if addrPort, err := netip.ParseAddrPort(address); err == nil {
+		return addrPort.Addr().WithZone("").Unmap().String()
+	}
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.WithZone("").Unmap().String()
+	}
+	return host
+}
+
+// IPInCIDRList 检查IP是否在CIDR列表中
+func IPInCIDRList(ip string, cidrList []*net.IPNet) bool {
+	cleanIP := ExtractIPFromAddress(ip)
+	parsedIP := net.ParseIP(cleanIP)
+	if parsedIP == nil {
+		return false
+	}
+
+	for _, cidr := range cidrList {
+		if cidr.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrustedProxy 检查对端地址是否在cfg的受信任反向代理列表中
+func IsTrustedProxy(cfg *config.AppConfig, remoteAddr string) bool {
+	return IPInCIDRList(remoteAddr, ParseIPNets(cfg.Server.TrustedProxies))
+}
+
+// firstForwardedValue 取逗号分隔的转发头中的第一个值
+func firstForwardedValue(value string) string {
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// normalizeHostPort 校验并规范化host[:port]：IPv6地址加方括号、zone标识编码为%25，
+// IPv4映射的IPv6地址转换为IPv4，无效时返回false
+func normalizeHostPort(hostport string) (string, bool) {
+	hostport = strings.TrimSpace(hostport)
+	var host, port string
+	switch {
+	case strings.HasPrefix(hostport, "["):
+		if h, p, err := net.SplitHostPort(hostport); err == nil {
+			host, port = h, p
+		} else if strings.HasSuffix(hostport, "]") {
+			host = hostport[1 : len(hostport)-1]
+		} else {
+			return "", false
+		}
+		host = strings.Replace(host, "%25", "%", 1)
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is6() {
+			return "", false
+		}
+	case strings.Count(hostport, ":") > 1:
+		// 不带方括号的IPv6地址，无法携带端口
+		host = hostport
+		if _, err := netip.ParseAddr(host); err != nil {
+			return "", false
+		}
+	case strings.Contains(hostport, ":"):
+		h, p, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return "", false
+		}
+		host, port = h, p
+	default:
+		host = hostport
+	}
+
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return "", false
+		}
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		host = addr.String()
+		if addr.Is6() {
+			host = "[" + strings.Replace(host, "%", "%25", 1) + "]"
+		}
+	} else {
+		if host == "" || len(host) > 253 {
+			return "", false
+		}
+		for _, r := range host {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+				return "", false
+			}
+		}
+	}
+
+	if port != "" {
+		return host + ":" + port, true
+	}
+	return host, true
+}
+
+// BasePath 返回cfg中规范化的server.basePath，以/开头且不以/结尾，未配置时为空
+func BasePath(cfg *config.AppConfig) string {
+	basePath := strings.Trim(strings.TrimSpace(cfg.Server.BasePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// ExternalBaseURL 返回客户端访问本服务时使用的地址(scheme://host[:port][/basePath])，
+// 仅在对端为cfg中的受信任代理时采用 X-Forwarded-Host/X-Forwarded-Proto
+func ExternalBaseURL(cfg *config.AppConfig, r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if normalized, ok := normalizeHostPort(host); ok {
+		host = normalized
+	}
+
+	if IsTrustedProxy(cfg, r.RemoteAddr) {
+		if forwardedHost, ok := normalizeHostPort(firstForwardedValue(r.Header.Get("X-Forwarded-Host"))); ok {
+			host = forwardedHost
+		}
+		switch proto := strings.ToLower(firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			scheme = proto
+		}
+	}
+
+	return scheme + "://" + host + BasePath(cfg)
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestExternalBaseURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[server]
+trustedProxies = ["10.0.0.0/8", "::1"]
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		host       string
+		tls        bool
+		headers    map[string]string
+		want       string
+	}{
+		{"direct http", "203.0.113.1:1234", "proxy.example:8080", false, nil, "http://proxy.example:8080"},
+		{"direct tls", "203.0.113.1:1234", "proxy.example", true, nil, "https://proxy.example"},
+		{"untrusted forwarded", "203.0.113.1:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "evil.example", "X-Forwarded-Proto": "https"}, "http://internal:5000"},
+		{"trusted with port", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "myhost:8080", "X-Forwarded-Proto": "http"}, "http://myhost:8080"},
+		{"trusted list", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "a.example, b.example", "X-Forwarded-Proto": "https, http"}, "https://a.example"},
+		{"trusted ipv6 peer", "[::1]:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "[2001:db8::1]:8443", "X-Forwarded-Proto": "https"}, "https://[2001:db8::1]:8443"},
+		{"invalid forwarded host", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "bad host/<script>"}, "http://internal:5000"},
+		{"invalid port", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "myhost:99999"}, "http://internal:5000"},
+		{"direct ipv6 host", "[2001:db8::9]:1234", "[2001:DB8::1]:8443", true, nil, "https://[2001:db8::1]:8443"},
+		{"bare ipv6 host header", "203.0.113.1:1234", "2001:db8::1", false, nil, "http://[2001:db8::1]"},
+		{"trusted bare ipv6", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "2001:db8::1"}, "http://[2001:db8::1]"},
+		{"trusted ipv6 zone", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "[fe80::1%25eth0]:8443"}, "http://[fe80::1%25eth0]:8443"},
+		{"trusted mapped ipv4", "[::ffff:10.1.2.3]:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "[::ffff:192.0.2.1]:8443"}, "http://192.0.2.1:8443"},
+		{"ipv4 in brackets", "10.1.2.3:1234", "internal:5000", false,
+			map[string]string{"X-Forwarded-Host": "[192.0.2.1]:8443"}, "http://internal:5000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Host = tt.host
+			req.TLS = nil
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := ExternalBaseURL(config.GetConfig(), req); got != tt.want {
+				t.Fatalf("ExternalBaseURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExternalBaseURLWithBasePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[server]
+basePath = "/hub/"
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "proxy.example"
+	if got := ExternalBaseURL(config.GetConfig(), req); got != "http://proxy.example/hub" {
+		t.Fatalf("ExternalBaseURL = %q, want http://proxy.example/hub", got)
+	}
+}
+
+func TestNormalizeHostPort(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"proxy.example", "proxy.example", true},
+		{"proxy.example:8080", "proxy.example:8080", true},
+		{"192.0.2.1:80", "192.0.2.1:80", true},
+		{"[2001:db8::1]", "[2001:db8::1]", true},
+		{"[2001:db8::1]:8443", "[2001:db8::1]:8443", true},
+		{"[2001:0db8:0000::0001]:8443", "[2001:db8::1]:8443", true},
+		{"2001:db8::1", "[2001:db8::1]", true},
+		{"[fe80::1%25eth0]:8443", "[fe80::1%25eth0]:8443", true},
+		{"fe80::1%eth0", "[fe80::1%25eth0]", true},
+		{"[::ffff:192.0.2.1]:8443", "192.0.2.1:8443", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", true},
+		{"", "", false},
+		{"[2001:db8::1", "", false},
+		{"[2001:db8::1]:0", "", false},
+		{"[192.0.2.1]", "", false},
+		{"[proxy.example]:80", "", false},
+		{"2001:db8::1:8443:x", "", false},
+		{"bad host/<script>", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeHostPort(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeHostPort(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExtractIPFromAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"127.0.0.1:5000", "127.0.0.1"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"[2001:db8::1]:5000", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"2001:DB8:0:0::1", "2001:db8::1"},
+		{"[fe80::1%eth0]:5000", "fe80::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[::ffff:192.0.2.1]:5000", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"localhost:5000", "localhost"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := ExtractIPFromAddress(tt.address); got != tt.want {
+			t.Errorf("ExtractIPFromAddress(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"golang.org/x/net/http/httpguts"
+)
+
+// hopByHopHeaders 逐跳头，只对单个连接有效，代理不应转发
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// singleValueHeaders 只允许出现一次的响应头
+var singleValueHeaders = map[string]bool{
+	"Content-Length": true,
+	"Content-Type":   true,
+}
+
+// priorityHeaders 响应头超出条数上限时优先保留的头，决定客户端如何解析响应内容
+var priorityHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Range",
+	"Content-Disposition",
+	"Location",
+	"Www-Authenticate",
+	"Etag",
+	"Last-Modified",
+}
+
+// CopyResponseHeaders 将上游host的响应头复制到dst，保留多值头的全部取值，
+// 跳过逐跳头，Content-Length/Content-Type只保留一个值并覆盖已有值。
+// 名称或取值含非法字符的头、超过cfg中upstream.responseHeaders.maxValueLength的取值以及超出maxCount的部分被丢弃，
+// 丢弃时记录带上游主机的警告日志
+func CopyResponseHeaders(cfg *config.AppConfig, dst, src http.Header, host string) {
+	skip := make(map[string]bool, len(hopByHopHeaders))
+	for _, key := range hopByHopHeaders {
+		skip[key] = true
+	}
+	for _, value := range src.Values("Connection") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				skip[http.CanonicalHeaderKey(field)] = true
+			}
+		}
+	}
+
+	limits := cfg.Upstream.ResponseHeaders
+	var invalid, oversized, overflow, count int
+	for _, key := range orderedHeaderKeys(src) {
+		values := src[key]
+		if !httpguts.ValidHeaderFieldName(key) {
+			invalid += len(values)
+			continue
+		}
+		key = http.CanonicalHeaderKey(key)
+		if skip[key] || len(values) == 0 {
+			continue
+		}
+
+		kept := make([]string, 0, len(values))
+		for _, value := range values {
+			switch {
+			case !httpguts.ValidHeaderFieldValue(value):
+				invalid++
+			case len(value) > limits.MaxValueLength:
+				oversized++
+			case count >= limits.MaxCount:
+				overflow++
+			default:
+				kept = append(kept, value)
+				count++
+			}
+			if singleValueHeaders[key] && len(kept) > 0 {
+				break
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		if singleValueHeaders[key] {
+			dst.Set(key, kept[0])
+			continue
+		}
+		dst[key] = kept
+	}
+
+	if invalid+oversized+overflow > 0 {
+		Logf(LogWarn, "headers "+host, "警告: 上游 %s 的响应头已清理，丢弃非法 %d 个、超长 %d 个、超出 %d 条上限 %d 个",
+			host, invalid, oversized, limits.MaxCount, overflow)
+	}
+}
+
+// orderedHeaderKeys 返回响应头名称，priorityHeaders在前，其余按名称排序，使超出条数上限时的取舍稳定
+func orderedHeaderKeys(header http.Header) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		if !slices.Contains(priorityHeaders, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	ordered := make([]string, 0, len(header))
+	for _, key := range priorityHeaders {
+		if _, exists := header[key]; exists {
+			ordered = append(ordered, key)
+		}
+	}
+	return append(ordered, keys...)
+}
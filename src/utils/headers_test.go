@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestCopyResponseHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Add("Set-Cookie", "a=1")
+	src.Add("Set-Cookie", "b=2")
+	src.Add("Link", `<https://example.com/1>; rel="next"`)
+	src.Add("Link", `<https://example.com/9>; rel="last"`)
+	src.Add("Content-Type", "application/gzip")
+	src.Add("Content-Type", "text/plain")
+	src.Set("Content-Length", "42")
+	src.Set("Connection", "keep-alive, X-Private")
+	src.Set("X-Private", "secret")
+	src.Set("Transfer-Encoding", "chunked")
+
+	dst := http.Header{}
+	dst.Set("Content-Type", "text/html")
+	CopyResponseHeaders(config.GetConfig(), dst, src, "example.com")
+
+	if got := dst.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+		t.Fatalf("Set-Cookie = %v", got)
+	}
+	if got := dst.Values("Link"); len(got) != 2 {
+		t.Fatalf("Link = %v", got)
+	}
+	if got := dst.Values("Content-Type"); !reflect.DeepEqual(got, []string{"application/gzip"}) {
+		t.Fatalf("Content-Type = %v", got)
+	}
+	if got := dst.Values("Content-Length"); !reflect.DeepEqual(got, []string{"42"}) {
+		t.Fatalf("Content-Length = %v", got)
+	}
+	for _, key := range []string{"Connection", "X-Private", "Transfer-Encoding"} {
+		if dst.Get(key) != "" {
+			t.Fatalf("hop-by-hop header %s copied", key)
+		}
+	}
+}
+
+func TestCopyResponseHeadersSanitizes(t *testing.T) {
+	loadTestConfig(t, `
+[upstream.responseHeaders]
+maxValueLength = 16
+maxCount = 5
+`)
+	src := http.Header{}
+	src["Bad Name"] = []string{"x"}
+	src.Set("X-Control", "a\x00b")
+	src.Set("X-Long", strings.Repeat("a", 17))
+	src.Add("X-Multi", "ok")
+	src.Add("X-Multi", "bad\r\nInjected: 1")
+	src.Set("Content-Type", "text/plain")
+	src.Set("X-A", "1")
+	src.Set("X-B", "2")
+	src.Set("X-C", "3")
+	src.Set("X-Z", "over limit")
+
+	dst := http.Header{}
+	CopyResponseHeaders(config.GetConfig(), dst, src, "example.com")
+
+	want := http.Header{
+		"Content-Type": {"text/plain"},
+		"X-A":          {"1"},
+		"X-B":          {"2"},
+		"X-C":          {"3"},
+		"X-Multi":      {"ok"},
+	}
+	if len(dst) != len(want) {
+		t.Fatalf("headers = %q, want %q", dst, want)
+	}
+	for key, values := range want {
+		if !reflect.DeepEqual(dst[key], values) {
+			t.Fatalf("%s = %q, want %q", key, dst[key], values)
+		}
+	}
+}
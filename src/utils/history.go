@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// 下载历史类型
+const (
+	HistoryTypeGitHub   = "github"
+	HistoryTypeImageTar = "image-tar"
+)
+
+// 下载历史状态
+const (
+	HistoryCompleted   = "completed"
+	HistoryFailed      = "failed"
+	HistoryInterrupted = "interrupted"
+)
+
+// ErrHistoryNotFound 历史记录不存在
+var ErrHistoryNotFound = errors.New("历史记录不存在")
+
+const (
+	// historyQueueSize 待写入记录的队列长度，队列满时丢弃新记录，不阻塞传输
+	historyQueueSize = 256
+	// historyPruneInterval 清理过期记录的间隔
+	historyPruneInterval = time.Hour
+	// defaultHistoryRetention 未配置retentionDays时的保留时间
+	defaultHistoryRetention = 30 * 24 * time.Hour
+)
+
+// HistoryEntry 一次已完成的下载，Initiator为命名令牌/用户名或脱敏后的IP
+type HistoryEntry struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Size      int64     `json:"size"`
+	Status    string    `json:"status"`
+	Initiator string    `json:"initiator"`
+}
+
+// HistoryStore 下载历史库，新记录以JSON Lines追加写入文件，删除和清理时原子重写整个文件
+type HistoryStore struct {
+	mu      sync.Mutex
+	path    string
+	entries []HistoryEntry
+}
+
+// OpenHistoryStore 从文件加载下载历史，文件不存在时返回空库，无法解析的行被跳过
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	store := &HistoryStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ID == "" {
+			continue
+		}
+		store.entries = append(store.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取下载历史失败: %w", err)
+	}
+	return store, nil
+}
+
+func newHistoryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Add 追加一条记录，未设置ID时自动生成
+func (s *HistoryStore) Add(entry HistoryEntry) (HistoryEntry, error) {
+	if entry.ID == "" {
+		entry.ID = newHistoryID()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return entry, err
+		}
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return entry, err
+	}
+	// 磁盘写满时可能只写入半行，截断回写入前的长度，避免重新打开时读到损坏的记录
+	var size int64
+	if info, statErr := file.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Truncate(size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return entry, err
+	}
+	s.entries = append(s.entries, entry)
+	return entry, nil
+}
+
+// List 按时间倒序分页返回记录，kind为空时返回所有类型，page从1开始
+func (s *HistoryStore) List(kind string, page, pageSize int) ([]HistoryEntry, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := make([]HistoryEntry, 0, len(s.entries))
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if kind == "" || s.entries[i].Type == kind {
+			matched = append(matched, s.entries[i])
+		}
+	}
+
+	start := (page - 1) * pageSize
+	if start >= len(matched) {
+		return []HistoryEntry{}, len(matched)
+	}
+	return matched[start:min(start+pageSize, len(matched))], len(matched)
+}
+
+// Delete 删除指定记录
+func (s *HistoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.ID != id {
+			continue
+		}
+		kept := append(append([]HistoryEntry(nil), s.entries[:i]...), s.entries[i+1:]...)
+		if err := s.rewriteLocked(kept); err != nil {
+			return err
+		}
+		return nil
+	}
+	return ErrHistoryNotFound
+}
+
+// Prune 删除早于before的记录，返回删除的条数
+func (s *HistoryStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]HistoryEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if !entry.Time.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(s.entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := s.rewriteLocked(kept); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// rewriteLocked 原子重写历史文件，成功后替换内存中的记录，调用方需持有锁
+func (s *HistoryStore) rewriteLocked(entries []HistoryEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeFileAtomic(s.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	s.entries = entries
+	return nil
+}
+
+// HistoryLog 一个服务实例按配置路径打开的下载历史库，记录经后台队列写入，路径变化时重新加载
+type HistoryLog struct {
+	config     *config.Store
+	background *Background
+
+	mu      sync.Mutex
+	store   *HistoryStore
+	queue   chan HistoryEntry
+	start   sync.Once
+	dropped atomic.Int64
+}
+
+// NewHistoryLog 创建下载历史，后台写入和定期清理随background停止
+func NewHistoryLog(store *config.Store, background *Background) *HistoryLog {
+	return &HistoryLog{config: store, background: background, queue: make(chan HistoryEntry, historyQueueSize)}
+}
+
+// Store 返回配置的下载历史库，路径变化时重新加载
+func (h *HistoryLog) Store() (*HistoryStore, error) {
+	path := h.config.Get().History.Path
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.store != nil && h.store.path == path {
+		return h.store, nil
+	}
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		return nil, err
+	}
+	h.store = store
+	return h.store, nil
+}
+
+// HistoryRetention 按cfg计算下载历史的保留时间，同时受privacy.retentionDays限制
+func HistoryRetention(cfg *config.AppConfig) time.Duration {
+	retention := defaultHistoryRetention
+	if days := cfg.History.RetentionDays; days > 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	return PerIPRetention(cfg, retention)
+}
+
+// Prune 删除超过保留时间的下载历史
+func (h *HistoryLog) Prune() {
+	cfg := h.config.Get()
+	if !cfg.History.Enabled {
+		return
+	}
+	store, err := h.Store()
+	if err != nil {
+		fmt.Printf("加载下载历史失败: %v\n", err)
+		return
+	}
+	removed, err := store.Prune(time.Now().Add(-HistoryRetention(cfg)))
+	if err != nil {
+		fmt.Printf("清理下载历史失败: %v\n", err)
+		return
+	}
+	if removed > 0 {
+		fmt.Printf("已清理 %d 条过期下载历史\n", removed)
+	}
+}
+
+// Record 将记录放入后台写入队列，队列已满时丢弃记录，不会阻塞调用方
+func (h *HistoryLog) Record(entry HistoryEntry) {
+	h.start.Do(h.startWriter)
+	select {
+	case h.queue <- entry:
+	default:
+		h.dropped.Add(1)
+	}
+}
+
+// Dropped 因写入队列已满被丢弃的记录数
+func (h *HistoryLog) Dropped() int64 {
+	return h.dropped.Load()
+}
+
+// startWriter 启动后台写入goroutine，同时定期清理过期记录
+func (h *HistoryLog) startWriter() {
+	ctx := h.background.Context()
+	go func() {
+		h.Prune()
+		ticker := time.NewTicker(historyPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case entry := <-h.queue:
+				store, err := h.Store()
+				if err != nil {
+					fmt.Printf("加载下载历史失败: %v\n", err)
+					continue
+				}
+				if _, err := store.Add(entry); err != nil {
+					fmt.Printf("写入下载历史失败: %v\n", err)
+				}
+			case <-ticker.C:
+				h.Prune()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreAddAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := store.Add(HistoryEntry{Time: time.Now(), Type: HistoryTypeGitHub, Target: "https://github.com/a/b/releases/download/v1/x.tar.gz", Size: 42, Status: HistoryCompleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID == "" {
+		t.Fatal("entry ID not assigned")
+	}
+
+	// 损坏的行不影响其余记录
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{broken\n")
+	file.Close()
+
+	reopened, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, total := reopened.List("", 1, 10)
+	if total != 1 || items[0].ID != entry.ID || items[0].Size != 42 {
+		t.Fatalf("reopened items = %+v, total %d", items, total)
+	}
+}
+
+func TestHistoryStoreListPagination(t *testing.T) {
+	store, err := OpenHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	for i := range 5 {
+		kind := HistoryTypeImageTar
+		if i%2 == 0 {
+			kind = HistoryTypeGitHub
+		}
+		if _, err := store.Add(HistoryEntry{Time: base.Add(time.Duration(i) * time.Second), Type: kind, Target: fmt.Sprintf("t%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, total := store.List("", 1, 2)
+	if total != 5 || len(items) != 2 || items[0].Target != "t4" || items[1].Target != "t3" {
+		t.Fatalf("page 1 = %+v, total %d", items, total)
+	}
+	items, _ = store.List("", 3, 2)
+	if len(items) != 1 || items[0].Target != "t0" {
+		t.Fatalf("page 3 = %+v", items)
+	}
+	if items, _ = store.List("", 4, 2); len(items) != 0 {
+		t.Fatalf("page past end = %+v", items)
+	}
+	items, total = store.List(HistoryTypeImageTar, 1, 10)
+	if total != 2 || items[0].Target != "t3" || items[1].Target != "t1" {
+		t.Fatalf("image-tar items = %+v, total %d", items, total)
+	}
+}
+
+func TestHistoryStoreDeleteAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old, _ := store.Add(HistoryEntry{Time: now.Add(-48 * time.Hour), Type: HistoryTypeGitHub, Target: "old"})
+	keep, _ := store.Add(HistoryEntry{Time: now, Type: HistoryTypeGitHub, Target: "keep"})
+	gone, _ := store.Add(HistoryEntry{Time: now, Type: HistoryTypeGitHub, Target: "gone"})
+
+	if err := store.Delete(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(gone.ID); err != ErrHistoryNotFound {
+		t.Fatalf("second delete err = %v, want ErrHistoryNotFound", err)
+	}
+	removed, err := store.Prune(now.Add(-24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("prune removed %d, err %v", removed, err)
+	}
+
+	reopened, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, total := reopened.List("", 1, 10)
+	if total != 1 || items[0].ID != keep.ID {
+		t.Fatalf("items after delete and prune = %+v (old %s)", items, old.ID)
+	}
+}
+
+func TestRecordHistoryAsync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	loadTestConfig(t, fmt.Sprintf(`
+[history]
+enabled = true
+path = %q
+retentionDays = 1
+`, path))
+
+	background := NewBackground()
+	defer background.Stop()
+	history := NewHistoryLog(testStore(), background)
+	history.Record(HistoryEntry{Time: time.Now().Add(-72 * time.Hour), Type: HistoryTypeImageTar, Target: "expired"})
+	history.Record(HistoryEntry{Time: time.Now(), Type: HistoryTypeImageTar, Target: "nginx:latest"})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		store, err := history.Store()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, total := store.List("", 1, 10); total == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entries not written in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	history.Prune()
+	store, _ := history.Store()
+	items, total := store.List("", 1, 10)
+	if total != 1 || items[0].Target != "nginx:latest" {
+		t.Fatalf("items after prune = %+v", items)
+	}
+}
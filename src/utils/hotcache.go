@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// BlobHotCachePrefix 热点镜像层在内存缓存中的key前缀，按digest寻址
+const BlobHotCachePrefix = "blob:"
+
+// GitHubAssetHotCachePrefix 固定到commit SHA的GitHub源码包和文件在内存缓存中的key前缀，按链接寻址
+const GitHubAssetHotCachePrefix = "ghasset:"
+
+// maxHotCandidates 记录访问次数的候选对象上限，超出时清空重新计数
+const maxHotCandidates = 16384
+
+// hotEntry 内存缓存项，data写入后不再修改，多个请求可同时读取
+type hotEntry struct {
+	key         string
+	data        []byte
+	contentType string
+	storedAt    time.Time
+}
+
+// HotCache 热点小对象的内存LRU缓存，对象被请求达到promoteAfter次后才写入
+type HotCache struct {
+	config     *config.Store
+	mu         sync.Mutex
+	lru        *list.List
+	items      map[string]*list.Element
+	candidates map[string]int
+	bytes      int64
+
+	memoryHits atomic.Uint64
+	misses     atomic.Uint64
+	promotions atomic.Uint64
+	evictions  atomic.Uint64
+}
+
+// NewHotCache 创建热点对象缓存，容量和阈值在每次操作时从store读取
+func NewHotCache(store *config.Store) *HotCache {
+	return &HotCache{
+		config:     store,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element),
+		candidates: make(map[string]int),
+	}
+}
+
+// BuildBlobHotKey 返回镜像层的热点缓存key
+func BuildBlobHotKey(digest string) string {
+	return BlobHotCachePrefix + digest
+}
+
+// BuildGitHubAssetHotKey 返回固定链接的热点缓存key
+func BuildGitHubAssetHotKey(u string) string {
+	return GitHubAssetHotCachePrefix + u
+}
+
+// Get 返回缓存的对象内容，返回的切片与其他读取者共享，调用方不得修改
+func (h *HotCache) Get(key string) ([]byte, string, bool) {
+	if !h.config.Get().HotCache.Enabled {
+		return nil, "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if elem, ok := h.items[key]; ok {
+		h.lru.MoveToFront(elem)
+		entry := elem.Value.(*hotEntry)
+		h.memoryHits.Add(1)
+		return entry.data, entry.contentType, true
+	}
+	h.misses.Add(1)
+	return nil, "", false
+}
+
+// Peek 返回缓存的对象内容，不调整LRU顺序也不计入命中统计，用于导出缓存
+func (h *HotCache) Peek(key string) ([]byte, string, bool) {
+	if !h.config.Get().HotCache.Enabled {
+		return nil, "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	elem, ok := h.items[key]
+	if !ok {
+		return nil, "", false
+	}
+	entry := elem.Value.(*hotEntry)
+	return entry.data, entry.contentType, true
+}
+
+// HotCacheEntry 缓存对象的key和大小
+type HotCacheEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// Entries 按最近使用的顺序列出指定前缀的对象
+func (h *HotCache) Entries(prefix string) []HotCacheEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]HotCacheEntry, 0, len(h.items))
+	for elem := h.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*hotEntry)
+		if strings.HasPrefix(entry.key, prefix) {
+			entries = append(entries, HotCacheEntry{Key: entry.key, Size: int64(len(entry.data))})
+		}
+	}
+	return entries
+}
+
+// NewReader 返回缓存对象的Reader，多个请求共享同一份数据而不复制
+func (h *HotCache) NewReader(key string) (*bytes.Reader, string, bool) {
+	data, contentType, ok := h.Get(key)
+	if !ok {
+		return nil, "", false
+	}
+	return bytes.NewReader(data), contentType, true
+}
+
+// Admit 记录一次未命中的请求，对象不超过大小阈值且请求次数达到promoteAfter时返回true，
+// 调用方应在传输完成后调用Store写入
+func (h *HotCache) Admit(key string, size int64) bool {
+	cfg := h.config.Get().HotCache
+	if !cfg.Enabled || size <= 0 || size > cfg.MaxObjectBytes || size > cfg.MaxBytes {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, cached := h.items[key]; cached {
+		return false
+	}
+	if len(h.candidates) >= maxHotCandidates {
+		h.candidates = make(map[string]int)
+	}
+	h.candidates[key]++
+	return h.candidates[key] >= max(cfg.PromoteAfter, 1)
+}
+
+// Store 写入对象并按容量淘汰最久未使用的项，data写入后由缓存持有，调用方不得再修改
+func (h *HotCache) Store(key string, data []byte, contentType string) {
+	cfg := h.config.Get().HotCache
+	if !cfg.Enabled || int64(len(data)) > cfg.MaxObjectBytes || int64(len(data)) > cfg.MaxBytes {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.candidates, key)
+	if elem, ok := h.items[key]; ok {
+		h.lru.MoveToFront(elem)
+		return
+	}
+	h.items[key] = h.lru.PushFront(&hotEntry{key: key, data: data, contentType: contentType, storedAt: time.Now()})
+	h.bytes += int64(len(data))
+	h.promotions.Add(1)
+	for h.bytes > cfg.MaxBytes {
+		h.removeLocked(h.lru.Back())
+		h.evictions.Add(1)
+	}
+}
+
+func (h *HotCache) removeLocked(elem *list.Element) {
+	entry := h.lru.Remove(elem).(*hotEntry)
+	delete(h.items, entry.key)
+	h.bytes -= int64(len(entry.data))
+}
+
+// Flush 清除指定前缀的对象，prefix为空时清空全部，返回清除数量
+func (h *HotCache) Flush(prefix string) int {
+	result, _ := h.Purge(CachePurgeFilter{Prefix: prefix})
+	return result.Entries
+}
+
+// Purge 按条件清除对象，正在输出的请求持有的数据不受影响
+func (h *HotCache) Purge(filter CachePurgeFilter) (CachePurgeResult, error) {
+	if filter.Pattern != "" {
+		if _, err := path.Match(filter.Pattern, ""); err != nil {
+			return CachePurgeResult{}, fmt.Errorf("无效的匹配模式: %w", err)
+		}
+	}
+
+	var result CachePurgeResult
+	cutoff := time.Now().Add(-filter.OlderThan)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, elem := range h.items {
+		if !strings.HasPrefix(key, filter.Prefix) {
+			continue
+		}
+		if filter.Pattern != "" {
+			if matched, _ := path.Match(filter.Pattern, key); !matched {
+				continue
+			}
+		}
+		entry := elem.Value.(*hotEntry)
+		if filter.OlderThan > 0 && entry.storedAt.After(cutoff) {
+			continue
+		}
+		result.Entries++
+		result.Bytes += int64(len(entry.data))
+		h.removeLocked(elem)
+	}
+	return result, nil
+}
+
+// HotCacheStats 热点对象缓存统计，memory_hits为内存命中，misses为需要回源的请求
+type HotCacheStats struct {
+	Entries    int     `json:"entries"`
+	Bytes      int64   `json:"bytes"`
+	MaxBytes   int64   `json:"max_bytes"`
+	MemoryHits uint64  `json:"memory_hits"`
+	Misses     uint64  `json:"misses"`
+	Promotions uint64  `json:"promotions"`
+	Evictions  uint64  `json:"evictions"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// Size 返回缓存对象占用的字节数
+func (h *HotCache) Size() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.bytes
+}
+
+// Stats 返回热点对象缓存的占用和命中统计
+func (h *HotCache) Stats() HotCacheStats {
+	h.mu.Lock()
+	stats := HotCacheStats{
+		Entries:  len(h.items),
+		Bytes:    h.bytes,
+		MaxBytes: h.config.Get().HotCache.MaxBytes,
+	}
+	h.mu.Unlock()
+	stats.MemoryHits = h.memoryHits.Load()
+	stats.Misses = h.misses.Load()
+	stats.Promotions = h.promotions.Load()
+	stats.Evictions = h.evictions.Load()
+	if total := stats.MemoryHits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.MemoryHits) / float64(total)
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestHotCachePromotion(t *testing.T) {
+	loadTestConfig(t, "[hotCache]\npromoteAfter = 2\nmaxObjectBytes = 8\n")
+	h := NewHotCache(testStore())
+
+	if h.Admit("blob:a", 4) {
+		t.Fatal("object promoted on first request")
+	}
+	if !h.Admit("blob:a", 4) {
+		t.Fatal("object not promoted on second request")
+	}
+	if h.Admit("blob:big", 9) || h.Admit("blob:big", 9) {
+		t.Fatal("object above maxObjectBytes promoted")
+	}
+	h.Store("blob:a", []byte("data"), "application/octet-stream")
+
+	data, contentType, ok := h.Get("blob:a")
+	if !ok || string(data) != "data" || contentType != "application/octet-stream" {
+		t.Fatalf("Get = %q %q %v", data, contentType, ok)
+	}
+	if h.Admit("blob:a", 4) {
+		t.Fatal("cached object admitted again")
+	}
+	if _, _, ok := h.Get("blob:b"); ok {
+		t.Fatal("unexpected hit")
+	}
+	if stats := h.Stats(); stats.MemoryHits != 1 || stats.Misses != 1 || stats.Promotions != 1 || stats.Bytes != 4 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHotCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	loadTestConfig(t, "[hotCache]\nmaxBytes = 10\nmaxObjectBytes = 10\n")
+	h := NewHotCache(testStore())
+	h.Store("blob:a", []byte("aaaa"), "")
+	h.Store("blob:b", []byte("bbbb"), "")
+	h.Get("blob:a")
+	h.Store("blob:c", []byte("cccc"), "")
+
+	if _, _, ok := h.Get("blob:b"); ok {
+		t.Fatal("least recently used object not evicted")
+	}
+	for _, key := range []string{"blob:a", "blob:c"} {
+		if _, _, ok := h.Get(key); !ok {
+			t.Fatalf("%s evicted", key)
+		}
+	}
+	if stats := h.Stats(); stats.Bytes != 8 || stats.Evictions != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHotCachePurge(t *testing.T) {
+	loadTestConfig(t, "")
+	h := NewHotCache(testStore())
+	h.Store("blob:sha256:aa", []byte("1"), "")
+	h.Store("blob:sha256:bb", []byte("22"), "")
+	h.Store("other:x", []byte("333"), "")
+
+	if result, err := h.Purge(CachePurgeFilter{Prefix: BlobHotCachePrefix, Pattern: "blob:sha256:a*"}); err != nil || result.Entries != 1 || result.Bytes != 1 {
+		t.Fatalf("Purge = %+v, %v", result, err)
+	}
+	if result, _ := h.Purge(CachePurgeFilter{Prefix: BlobHotCachePrefix, OlderThan: 1 << 40}); result.Entries != 0 {
+		t.Fatalf("recent object purged: %+v", result)
+	}
+	if removed := h.Flush(""); removed != 2 {
+		t.Fatalf("Flush removed %d", removed)
+	}
+	if stats := h.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("stats after flush = %+v", stats)
+	}
+}
+
+func TestHotCacheSharedReaders(t *testing.T) {
+	loadTestConfig(t, "")
+	h := NewHotCache(testStore())
+	want := bytes.Repeat([]byte("layer"), 1024)
+	h.Store("blob:shared", want, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader, _, ok := h.NewReader("blob:shared")
+			if !ok {
+				t.Error("miss")
+				return
+			}
+			if got, _ := io.ReadAll(reader); !bytes.Equal(got, want) {
+				t.Error("content mismatch")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 读取者拿到的是缓存持有的同一份数据
+	data, _, _ := h.Get("blob:shared")
+	if &data[0] != &want[0] {
+		t.Fatal("cached data copied")
+	}
+}
+
+func TestHotCacheDisabled(t *testing.T) {
+	loadTestConfig(t, "[hotCache]\nenabled = false\n")
+	h := NewHotCache(testStore())
+	h.Store("blob:a", []byte("data"), "")
+	if h.Admit("blob:b", 4) || h.Admit("blob:b", 4) {
+		t.Fatal("admitted while disabled")
+	}
+	if _, _, ok := h.Get("blob:a"); ok {
+		t.Fatal("hit while disabled")
+	}
+}
@@ -1,67 +1,341 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"hubproxy/config"
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"golang.org/x/net/http/httpproxy"
 )
 
-var (
-	globalHTTPClient *http.Client
-	searchHTTPClient *http.Client
-)
+// HTTPClients 共用上游连接池的一组HTTP客户端，由Server创建后注入各代理组件。
+// 热重载修改了连接相关配置时整体替换内部的客户端，持有者无需更新引用
+type HTTPClients struct {
+	config  *config.Store
+	current atomic.Pointer[httpClients]
+	mu      sync.Mutex
+	// stats 上游流量和重定向计入的统计注册表
+	stats *StatsRegistry
+	// policies 上游请求头规则、出站预算、DNS解析和响应头策略，重建客户端时沿用
+	policies *upstreamPolicies
+}
 
-// InitHTTPClients 初始化HTTP客户端
-func InitHTTPClients() {
-	cfg := config.GetConfig()
-
-	if p := cfg.Access.Proxy; p != "" {
-		os.Setenv("HTTP_PROXY", p)
-		os.Setenv("HTTPS_PROXY", p)
-	}
-
-	globalHTTPClient = &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			MaxIdleConns:          1000,
-			MaxIdleConnsPerHost:   1000,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			ResponseHeaderTimeout: 300 * time.Second,
-		},
+// upstreamPolicies 一组HTTPClients共用的上游规则，以及按上游主机记录的最近一次限流和TLS握手。
+// 热重载时规则整体替换，记录保留
+type upstreamPolicies struct {
+	headers   atomic.Pointer[UpstreamHeaderRules]
+	budgets   atomic.Pointer[upstreamBudgets]
+	resolver  atomic.Pointer[upstreamResolver]
+	responses atomic.Pointer[ResponseHeaderPolicy]
+
+	// throttledUntil 各上游主机最近一次限流响应要求等待到的时间，
+	// 用于上游错误已无法取得响应头时(如Registry客户端的错误)仍能向客户端转告Retry-After
+	throttledUntil sync.Map
+	// tls 按上游主机记录最近一次握手
+	tls sync.Map
+	// blobBackends 按上游主机和digest记录blob最近一次重定向到的后端地址
+	blobBackends blobBackendCache
+}
+
+// loadUpstream 按cfg重建上游请求头规则、出站预算和DNS解析，DNS缓存随之清空，
+// 正在进行的请求仍在原预算中释放名额
+func (p *upstreamPolicies) loadUpstream(cfg *config.AppConfig) {
+	p.headers.Store(CompileUpstreamHeaderRules(cfg))
+	p.budgets.Store(newUpstreamBudgets(cfg))
+	p.resolver.Store(newUpstreamResolver(cfg))
+}
+
+// httpClients 同一份连接配置创建的客户端和连接池
+type httpClients struct {
+	global     *http.Client
+	metadata   *http.Client
+	search     *http.Client
+	transports []*http.Transport
+	settings   transportSettings
+}
+
+// transportSettings 决定上游连接行为的配置，任一项变化时需要重建连接池。
+// upstream.dns由resolvingDialContext按当前规则解析，修改后无需重建
+type transportSettings struct {
+	proxy          string
+	metadata       string
+	connect        string
+	tlsHandshake   string
+	responseHeader string
+	idleProgress   string
+	caBundle       string
+	maxHeaderBytes int64
+}
+
+// ErrUpstreamIdle 上游在空闲时间内没有返回任何数据
+var ErrUpstreamIdle = errors.New("上游长时间未返回数据，已中止")
+
+// ParseTimeout 解析超时配置，无效值使用默认值
+func ParseTimeout(value string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d
+	}
+	return fallback
+}
+
+// ParseDurationOr 解析正的时长，为空、无效或不大于0时返回fallback
+func ParseDurationOr(value string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// transportSettingsFrom 提取配置中决定上游连接行为的部分
+func transportSettingsFrom(cfg *config.AppConfig) transportSettings {
+	timeouts := cfg.Upstream.Timeouts
+	return transportSettings{
+		proxy:          strings.TrimSpace(cfg.Access.Proxy),
+		metadata:       timeouts.Metadata,
+		connect:        timeouts.Connect,
+		tlsHandshake:   timeouts.TLSHandshake,
+		responseHeader: timeouts.ResponseHeader,
+		idleProgress:   timeouts.IdleProgress,
+		caBundle:       cfg.Upstream.TLS.CABundle,
+		maxHeaderBytes: cfg.Upstream.ResponseHeaders.MaxBytes,
+	}
+}
+
+// upstreamProxy 返回上游连接选择代理的函数：配置了access.proxy时HTTP和HTTPS请求都经该代理，
+// NO_PROXY和本机地址仍然直连；未配置时按环境变量选择
+func upstreamProxy(proxy string) func(*http.Request) (*url.URL, error) {
+	if proxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	proxyConfig.HTTPProxy = proxy
+	proxyConfig.HTTPSProxy = proxy
+	proxyFunc := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// NewHTTPClients 按store中的配置创建HTTP客户端，上游流量和重定向计入stats。元数据类请求使用较短的总超时；
+// 流式请求不设总超时，依靠连接/响应头超时和空闲进度看门狗
+func NewHTTPClients(store *config.Store, stats *StatsRegistry) *HTTPClients {
+	cfg := store.Get()
+	h := &HTTPClients{config: store, stats: stats, policies: &upstreamPolicies{}}
+	h.policies.loadUpstream(cfg)
+	h.policies.responses.Store(CompileResponseHeaderPolicy(cfg))
+	h.current.Store(newHTTPClients(store, stats, h.policies))
+	return h
+}
+
+// ReloadSections 连接相关的配置和上游规则位于upstream和access段，响应头策略位于proxy段
+func (h *HTTPClients) ReloadSections() []string {
+	return []string{"upstream", "access", "proxy"}
+}
+
+// Reload upstream段变化时重建上游规则，proxy段变化时替换响应头策略；
+// 代理、超时、CA证书或响应头上限变化时按新配置重建HTTP客户端并原子替换。
+// 进行中的请求继续使用旧连接完成，旧连接池的空闲连接立即关闭，其余连接归还后按空闲超时关闭
+func (h *HTTPClients) Reload(old, updated *config.AppConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed := config.ChangedSections(old, updated)
+	if slices.Contains(changed, "upstream") {
+		h.policies.loadUpstream(updated)
+	}
+	if slices.Contains(changed, "proxy") {
+		h.policies.responses.Store(CompileResponseHeaderPolicy(updated))
+	}
+
+	current := h.current.Load()
+	if current.settings == transportSettingsFrom(updated) {
+		return
+	}
+	h.current.Store(newHTTPClients(h.config, h.stats, h.policies))
+	for _, transport := range current.transports {
+		transport.CloseIdleConnections()
+	}
+	fmt.Printf("上游连接配置已变化，已重建HTTP客户端\n")
+}
+
+// newHTTPClients 按store中的配置创建一组HTTP客户端，请求按policies中的当前规则改写、限速和解析
+func newHTTPClients(store *config.Store, stats *StatsRegistry, policies *upstreamPolicies) *httpClients {
+	settings := transportSettingsFrom(store.Get())
+	metadataTimeout := ParseTimeout(settings.metadata, 15*time.Second)
+	connectTimeout := ParseTimeout(settings.connect, 10*time.Second)
+	tlsTimeout := ParseTimeout(settings.tlsHandshake, 10*time.Second)
+	responseHeaderTimeout := ParseTimeout(settings.responseHeader, 60*time.Second)
+	idleProgress := ParseTimeout(settings.idleProgress, 60*time.Second)
+	proxy := upstreamProxy(settings.proxy)
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: policies.resolvingDialContext((&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext),
+		MaxIdleConns:          1000,
+		MaxIdleConnsPerHost:   1000,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   tlsTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		// 不自动解压，Accept-Encoding由客户端决定并原样转发，避免Content-Encoding与内容不一致
+		DisableCompression:     true,
+		MaxResponseHeaderBytes: settings.maxHeaderBytes,
 	}
+	tlsConfig := upstreamTLSConfig(settings.caBundle)
+	transport.TLSClientConfig = tlsConfig
+
+	searchTransport := &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		DialContext: policies.resolvingDialContext((&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext),
+		MaxIdleConns:           100,
+		MaxIdleConnsPerHost:    10,
+		IdleConnTimeout:        90 * time.Second,
+		TLSHandshakeTimeout:    5 * time.Second,
+		DisableCompression:     false,
+		MaxResponseHeaderBytes: settings.maxHeaderBytes,
+	}
+
+	// 按实际读取的响应体字节数统计上游流量，所有客户端共用按上游主机的出站预算；
+	// 跟随到预签名存储地址的重定向不携带Authorization，配置了redirectHosts的Registry只跟随到列出的后端；
+	// 每次上游TLS握手的结果记录到 /admin/upstreams/tls，启用慢请求日志时记录每次上游请求的阶段耗时；
+	// manifest请求转发客户端的Accept头，再应用上游请求头规则
+	upstream := &trafficTransport{stats: stats, base: &upstreamBudgetTransport{policies: policies, base: &manifestAcceptTransport{base: &upstreamHeaderTransport{policies: policies, base: &signedRedirectTransport{
+		base: &registryRedirectTransport{config: store, policies: policies, base: &phaseTimingTransport{base: &tlsTraceTransport{policies: policies, base: transport}}},
+	}}}}}
 
-	searchHTTPClient = &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   5 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			TLSHandshakeTimeout: 5 * time.Second,
-			DisableCompression:  false,
+	return &httpClients{
+		global: &http.Client{
+			Transport:     &idleTimeoutTransport{base: upstream, idle: idleProgress},
+			CheckRedirect: redirectPolicy(store, stats),
 		},
+		metadata: &http.Client{
+			Timeout:       metadataTimeout,
+			Transport:     upstream,
+			CheckRedirect: redirectPolicy(store, stats),
+		},
+		search: &http.Client{
+			Timeout:   metadataTimeout,
+			Transport: &trafficTransport{stats: stats, base: &upstreamBudgetTransport{policies: policies, base: &upstreamHeaderTransport{policies: policies, base: &phaseTimingTransport{base: &tlsTraceTransport{policies: policies, base: searchTransport}}}}},
+		},
+		transports: []*http.Transport{transport, searchTransport},
+		settings:   settings,
 	}
 }
 
-// GetGlobalHTTPClient 获取全局HTTP客户端
-func GetGlobalHTTPClient() *http.Client {
-	return globalHTTPClient
+// Global 返回流式请求客户端，用于blob、release文件等。热重载可能替换客户端，长期持有时应改用Transport
+func (h *HTTPClients) Global() *http.Client {
+	return h.current.Load().global
+}
+
+// Metadata 返回元数据请求客户端，用于token、API等短请求
+func (h *HTTPClients) Metadata() *http.Client {
+	return h.current.Load().metadata
+}
+
+// Search 返回搜索请求客户端
+func (h *HTTPClients) Search() *http.Client {
+	return h.current.Load().search
+}
+
+// upstreamTransport 把每次请求交给当前流式客户端的Transport，热重载替换客户端后立即生效
+type upstreamTransport struct {
+	clients *HTTPClients
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.clients.Global().Transport.RoundTrip(req)
+}
+
+// Transport 返回始终使用当前流式客户端的Transport，供Docker代理、镜像下载等长期保存选项的组件使用
+func (h *HTTPClients) Transport() http.RoundTripper {
+	return upstreamTransport{clients: h}
+}
+
+// MetadataContext 创建与元数据请求客户端相同超时的上下文
+func (h *HTTPClients) MetadataContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return metadataContext(parent, h.Metadata())
+}
+
+// metadataContext 创建超时与client相同的上下文，client未设超时时只可取消
+func metadataContext(parent context.Context, client *http.Client) (context.Context, context.CancelFunc) {
+	if client.Timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, client.Timeout)
+}
+
+// idleTimeoutTransport 为响应体增加空闲进度看门狗
+type idleTimeoutTransport struct {
+	base http.RoundTripper
+	idle time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.idle <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, t.idle, cancel)
+	return resp, nil
+}
+
+// idleTimeoutBody 单次读取等待上游超过idle时间仍未返回时取消请求，读取返回ErrUpstreamIdle。
+// 看门狗只在读取进行中计时，下游客户端接收较慢导致暂停读取时不会被判定为空闲
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	idle    time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, idle: idle, cancel: cancel}
+	b.timer = time.AfterFunc(idle, func() {
+		b.expired.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if !b.expired.Load() {
+		b.timer.Reset(b.idle)
+	}
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if err != nil && err != io.EOF && b.expired.Load() {
+		err = ErrUpstreamIdle
+	}
+	return n, err
 }
 
-// GetSearchHTTPClient 获取搜索HTTP客户端
-func GetSearchHTTPClient() *http.Client {
-	return searchHTTPClient
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.body.Close()
 }
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// loadTimeoutConfig 加载配置并创建HTTP客户端，上游请求头、出站预算等规则同时按该配置加载
+func loadTimeoutConfig(t *testing.T, body string) *HTTPClients {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return NewHTTPClients(testStore(), NewStatsRegistry())
+}
+
+func TestStreamingClientAbortsStalledBody(t *testing.T) {
+	clients := loadTimeoutConfig(t, `
+[upstream.timeouts]
+idleProgress = "200ms"
+`)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	resp, err := clients.Global().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	data, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrUpstreamIdle) {
+		t.Fatalf("read err = %v, want ErrUpstreamIdle", err)
+	}
+	if string(data) != "first chunk" {
+		t.Fatalf("data = %q", data)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("stalled stream aborted after %s", elapsed)
+	}
+}
+
+func TestStreamingClientKeepsSlowButProgressingBody(t *testing.T) {
+	clients := loadTimeoutConfig(t, `
+[upstream.timeouts]
+idleProgress = "300ms"
+`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := clients.Global().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || string(data) != "xxxxx" {
+		t.Fatalf("data = %q, err = %v", data, err)
+	}
+}
+
+func TestStreamingClientToleratesSlowDownstream(t *testing.T) {
+	clients := loadTimeoutConfig(t, `
+[upstream.timeouts]
+idleProgress = "200ms"
+`)
+
+	resume := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-resume
+		w.Write([]byte("second"))
+	}))
+	defer server.Close()
+
+	resp, err := clients.Global().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// 下游接收慢时暂停读取，暂停期间不计入上游空闲时间
+	buf := make([]byte, len("first"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	close(resume)
+	rest, err := io.ReadAll(resp.Body)
+	data := append(buf, rest...)
+	if err != nil || string(data) != "firstsecond" {
+		t.Fatalf("data = %q, err = %v", data, err)
+	}
+}
+
+func TestMetadataClientUsesOverallTimeout(t *testing.T) {
+	clients := loadTimeoutConfig(t, `
+[upstream.timeouts]
+metadata = "3s"
+`)
+	if clients.Metadata().Timeout != 3*time.Second {
+		t.Fatalf("metadata timeout = %s", clients.Metadata().Timeout)
+	}
+	if clients.Global().Timeout != 0 {
+		t.Fatalf("streaming client has overall timeout %s", clients.Global().Timeout)
+	}
+}
+
+func TestReloadRebuildsHTTPClients(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	clients := loadTimeoutConfig(t, "[upstream.timeouts]\nmetadata = \"3s\"\n")
+	reloader := config.NewReloader(clients.config)
+	reloader.Register(clients)
+	// 长期保存Transport的组件(Docker代理、镜像下载)应跟随替换
+	held := &http.Client{Transport: clients.Transport()}
+	before := clients.Global()
+
+	reload := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(os.Getenv("CONFIG_PATH"), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloader.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 与连接无关的配置变化不重建连接池
+	reload("[upstream.timeouts]\nmetadata = \"3s\"\n[upstream.headers.set]\nX-Test = \"1\"\n")
+	if clients.Global() != before {
+		t.Fatal("clients rebuilt for unrelated upstream change")
+	}
+
+	reload("[upstream.timeouts]\nmetadata = \"7s\"\n[access]\nproxy = \"" + proxy.URL + "\"\n")
+	if clients.Global() == before || clients.Metadata().Timeout != 7*time.Second {
+		t.Fatalf("clients not rebuilt: metadata timeout %s", clients.Metadata().Timeout)
+	}
+	for _, client := range []*http.Client{clients.Metadata(), clients.Global(), held} {
+		resp, err := client.Get("http://upstream.invalid/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "via proxy" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	if len(proxied) != 3 || proxied[0] != "upstream.invalid" {
+		t.Fatalf("proxied = %v", proxied)
+	}
+}
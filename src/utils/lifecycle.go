@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Background 一个服务实例后台任务的根上下文，服务停止时取消
+type Background struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewBackground 创建后台任务的根上下文
+func NewBackground() *Background {
+	return &Background{}
+}
+
+// Context 返回后台任务的根上下文。复制、预热、缓存拉取等任务以及token预取、
+// 屏蔽列表刷新、通知发送都从它派生，服务停止时一并取消，不再继续占用上游连接和带宽
+func (b *Background) Context() context.Context {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx == nil {
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+	}
+	return b.ctx
+}
+
+// Stop 取消当前所有后台任务，之后再调用Context得到新的上下文
+func (b *Background) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.ctx, b.cancel = nil, nil
+}
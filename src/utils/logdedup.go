@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// LogLevel 日志级别，参与重复日志的合并判断
+type LogLevel string
+
+const (
+	LogDebug    LogLevel = "debug"
+	LogInfo     LogLevel = "info"
+	LogWarn     LogLevel = "warn"
+	LogError    LogLevel = "error"
+	LogCritical LogLevel = "critical"
+)
+
+// logFlushInterval 检查合并窗口是否结束的间隔
+const logFlushInterval = time.Second
+
+// logKey 合并重复日志的依据：级别、消息模板和关键字段(如上游主机、客户端IP)
+type logKey struct {
+	level    LogLevel
+	template string
+	key      string
+}
+
+// logEntry 一个合并窗口内的计数，last为最近一条被合并的日志
+type logEntry struct {
+	start      time.Time
+	count      int
+	suppressed int
+	last       string
+}
+
+// logDeduper 合并窗口内重复的日志：每个窗口内前threshold条照常输出，
+// 其余只计数，窗口结束时以一行附带repeated=N的日志输出
+type logDeduper struct {
+	mu      sync.Mutex
+	out     io.Writer
+	entries map[logKey]*logEntry
+}
+
+func newLogDeduper(out io.Writer) *logDeduper {
+	return &logDeduper{out: out, entries: make(map[logKey]*logEntry)}
+}
+
+// log 记录一条日志，window为0或级别为critical时不合并
+func (d *logDeduper) log(now time.Time, window time.Duration, threshold int, level LogLevel, key, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if window <= 0 || level == LogCritical {
+		fmt.Fprintln(d.out, line)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	k := logKey{level: level, template: format, key: key}
+	entry := d.entries[k]
+	if entry != nil && now.Sub(entry.start) >= window {
+		d.emit(entry)
+		entry = nil
+	}
+	if entry == nil {
+		entry = &logEntry{start: now}
+		d.entries[k] = entry
+	}
+
+	entry.count++
+	if entry.count <= max(threshold, 1) {
+		fmt.Fprintln(d.out, line)
+		return
+	}
+	entry.suppressed++
+	entry.last = line
+}
+
+// flush 输出并清理窗口已结束的计数
+func (d *logDeduper) flush(now time.Time, window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, entry := range d.entries {
+		if now.Sub(entry.start) >= window {
+			d.emit(entry)
+			delete(d.entries, k)
+		}
+	}
+}
+
+// emit 窗口内有被合并的日志时输出一行汇总
+func (d *logDeduper) emit(entry *logEntry) {
+	if entry.suppressed > 0 {
+		fmt.Fprintf(d.out, "%s repeated=%d\n", entry.last, entry.suppressed)
+	}
+}
+
+// logDedupSettings 日志合并窗口和阈值
+type logDedupSettings struct {
+	window    time.Duration
+	threshold int
+}
+
+func logDedupSettingsFrom(cfg *config.AppConfig) *logDedupSettings {
+	return &logDedupSettings{window: ParseTimeout(cfg.Log.DedupWindow, time.Minute), threshold: cfg.Log.DedupThreshold}
+}
+
+var (
+	defaultLogDeduper = newLogDeduper(os.Stdout)
+	logFlusherOnce    sync.Once
+	// logSettings 日志输出到整个进程共用的标准输出，合并设置同样属于进程，未设置时使用默认配置
+	logSettings atomic.Pointer[logDedupSettings]
+)
+
+// ConfigureLogDedup 按cfg中的log段设置日志合并窗口和阈值，同一进程中有多个服务时以最后设置的为准
+func ConfigureLogDedup(cfg *config.AppConfig) {
+	logSettings.Store(logDedupSettingsFrom(cfg))
+}
+
+// LogDedupReloader 热重载修改了log段时更新日志合并设置
+func LogDedupReloader() config.Reloadable {
+	return config.ReloadFunc{Sections: []string{"log"}, Fn: func(_, updated *config.AppConfig) {
+		ConfigureLogDedup(updated)
+	}}
+}
+
+// currentLogDedupSettings 返回当前的合并窗口和阈值
+func currentLogDedupSettings() (time.Duration, int) {
+	settings := logSettings.Load()
+	if settings == nil {
+		settings = logDedupSettingsFrom(config.DefaultConfig())
+	}
+	return settings.window, settings.threshold
+}
+
+// Logf 输出一条日志。同一级别、消息模板和key的日志在log.dedupWindow内超过log.dedupThreshold条后合并，
+// 窗口结束时输出附带repeated=N的汇总；key用于区分不应合并的来源，如不同的上游主机
+func Logf(level LogLevel, key, format string, args ...interface{}) {
+	window, threshold := currentLogDedupSettings()
+	if window > 0 {
+		logFlusherOnce.Do(func() { go runLogFlusher() })
+	}
+	defaultLogDeduper.log(time.Now(), window, threshold, level, key, format, args...)
+}
+
+// runLogFlusher 定期输出窗口已结束的汇总，避免上游恢复后最后一批计数一直不输出
+func runLogFlusher() {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		window, _ := currentLogDedupSettings()
+		defaultLogDeduper.flush(now, window)
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogDeduperCountsAndFlushes(t *testing.T) {
+	var out bytes.Buffer
+	d := newLogDeduper(&out)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const format = "GET请求失败: %v"
+
+	for i := range 5 {
+		d.log(start.Add(time.Duration(i)*time.Second), time.Minute, 2, LogError, "ghcr.io", format, "connection refused")
+	}
+	if got := strings.Count(out.String(), "\n"); got != 2 {
+		t.Fatalf("lines before window end = %d:\n%s", got, out.String())
+	}
+
+	d.flush(start.Add(30*time.Second), time.Minute)
+	if strings.Contains(out.String(), "repeated=") {
+		t.Fatalf("flushed before window end:\n%s", out.String())
+	}
+	d.flush(start.Add(time.Minute), time.Minute)
+	if !strings.HasSuffix(out.String(), "GET请求失败: connection refused repeated=3\n") {
+		t.Fatalf("summary missing:\n%s", out.String())
+	}
+
+	// 汇总后开始新窗口，不再重复输出汇总
+	out.Reset()
+	d.flush(start.Add(2*time.Minute), time.Minute)
+	d.log(start.Add(2*time.Minute), time.Minute, 2, LogError, "ghcr.io", format, "connection refused")
+	if out.String() != "GET请求失败: connection refused\n" {
+		t.Fatalf("new window output = %q", out.String())
+	}
+}
+
+func TestLogDeduperKeepsHostsApart(t *testing.T) {
+	var out bytes.Buffer
+	d := newLogDeduper(&out)
+	now := time.Now()
+	const format = "获取layer失败: %v"
+
+	for range 3 {
+		d.log(now, time.Minute, 1, LogError, "ghcr.io", format, "ghcr down")
+		d.log(now, time.Minute, 1, LogError, "quay.io", format, "quay down")
+		d.log(now, time.Minute, 1, LogCritical, "quay.io", format, "critical")
+	}
+	d.log(now, time.Minute, 1, LogWarn, "ghcr.io", format, "other level")
+	d.flush(now.Add(time.Minute), time.Minute)
+
+	got := out.String()
+	for _, want := range []string{
+		"获取layer失败: ghcr down\n", "获取layer失败: quay down\n", "获取layer失败: other level\n",
+		"获取layer失败: ghcr down repeated=2\n", "获取layer失败: quay down repeated=2\n",
+	} {
+		if strings.Count(got, want) != 1 {
+			t.Errorf("want %q once in:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "获取layer失败: critical\n") != 3 {
+		t.Errorf("critical events suppressed:\n%s", got)
+	}
+}
+
+func TestLogDeduperDisabled(t *testing.T) {
+	var out bytes.Buffer
+	d := newLogDeduper(&out)
+	for range 3 {
+		d.log(time.Now(), 0, 1, LogDebug, "10.0.0.1", "请求IP: %s", "10.0.0.1")
+	}
+	if strings.Count(out.String(), "\n") != 3 || len(d.entries) != 0 {
+		t.Fatalf("output with dedup disabled:\n%s", out.String())
+	}
+}
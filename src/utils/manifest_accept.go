@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// manifestAcceptKey 客户端manifest请求Accept头的上下文key
+type manifestAcceptKey struct{}
+
+// WithManifestAccept 记录客户端manifest请求的Accept头，经此上下文发出的上游manifest请求原样转发，
+// values为空时不修改上下文，上游请求使用go-containerregistry默认的Accept
+func WithManifestAccept(ctx context.Context, values []string) context.Context {
+	if len(values) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, manifestAcceptKey{}, append([]string(nil), values...))
+}
+
+// ManifestAccept 返回上下文中记录的客户端Accept头
+func ManifestAccept(ctx context.Context) []string {
+	values, _ := ctx.Value(manifestAcceptKey{}).([]string)
+	return values
+}
+
+// acceptMediaTypes 拆分Accept头中的媒体类型，去掉参数和空白并转为小写
+func acceptMediaTypes(values []string) []string {
+	var types []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			mediaType := strings.ToLower(strings.TrimSpace(part))
+			if parsed, _, err := mime.ParseMediaType(part); err == nil {
+				mediaType = parsed
+			} else if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+				mediaType = strings.TrimSpace(mediaType[:i])
+			}
+			if mediaType != "" {
+				types = append(types, mediaType)
+			}
+		}
+	}
+	return types
+}
+
+// ManifestAcceptKey 返回上下文中Accept头规范化后的形式，媒体类型去重排序后以逗号连接，
+// 用于manifest缓存key，使Accept集合不同的客户端不会共用缓存项。未记录Accept时返回空字符串
+func ManifestAcceptKey(ctx context.Context) string {
+	types := acceptMediaTypes(ManifestAccept(ctx))
+	sort.Strings(types)
+	unique := types[:0]
+	for i, mediaType := range types {
+		if i == 0 || mediaType != types[i-1] {
+			unique = append(unique, mediaType)
+		}
+	}
+	return strings.Join(unique, ",")
+}
+
+// ManifestTypeAccepted 判断上游返回的媒体类型是否在客户端Accept范围内，
+// 未记录Accept或包含*/*时接受任何类型，支持type/*形式的通配
+func ManifestTypeAccepted(ctx context.Context, mediaType string) bool {
+	accept := ManifestAccept(ctx)
+	if len(accept) == 0 {
+		return true
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	}
+	for _, accepted := range acceptMediaTypes(accept) {
+		if accepted == "*/*" || accepted == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(accepted, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestAcceptTransport 把上下文中记录的客户端Accept头原样设置到上游manifest请求上，
+// 替换go-containerregistry按自身支持的类型生成的Accept，使上游按客户端的能力协商manifest格式
+type manifestAcceptTransport struct {
+	base http.RoundTripper
+}
+
+func (t *manifestAcceptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	accept := ManifestAccept(req.Context())
+	if len(accept) == 0 || !strings.Contains(req.URL.Path, "/manifests/") {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header["Accept"] = append([]string(nil), accept...)
+	return t.base.RoundTrip(req)
+}
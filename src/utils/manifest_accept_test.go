@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"testing"
+)
+
+func TestManifestAcceptKey(t *testing.T) {
+	a := WithManifestAccept(context.Background(), []string{
+		"application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.v2+json;q=0.5",
+	})
+	b := WithManifestAccept(context.Background(), []string{
+		"Application/Vnd.Docker.Distribution.Manifest.V2+json",
+		" application/vnd.oci.image.index.v1+json ; q=1",
+		"application/vnd.oci.image.index.v1+json",
+	})
+	want := "application/vnd.docker.distribution.manifest.v2+json,application/vnd.oci.image.index.v1+json"
+	if got := ManifestAcceptKey(a); got != want {
+		t.Fatalf("key = %q, want %q", got, want)
+	}
+	if ManifestAcceptKey(b) != ManifestAcceptKey(a) {
+		t.Fatalf("equivalent Accept sets differ: %q", ManifestAcceptKey(b))
+	}
+	if key := ManifestAcceptKey(context.Background()); key != "" {
+		t.Fatalf("key without Accept = %q", key)
+	}
+}
+
+func TestManifestTypeAccepted(t *testing.T) {
+	tests := []struct {
+		accept    []string
+		mediaType string
+		want      bool
+	}{
+		{nil, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"*/*"}, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"application/*"}, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"application/vnd.oci.image.index.v1+json"}, "application/vnd.oci.image.index.v1+json; charset=utf-8", true},
+		{[]string{"application/vnd.docker.distribution.manifest.v2+json"}, "application/vnd.docker.distribution.manifest.list.v2+json", false},
+		{[]string{"text/*"}, "application/json", false},
+	}
+	for _, tt := range tests {
+		ctx := WithManifestAccept(context.Background(), tt.accept)
+		if got := ManifestTypeAccepted(ctx, tt.mediaType); got != tt.want {
+			t.Errorf("ManifestTypeAccepted(%q, %q) = %v", tt.accept, tt.mediaType, got)
+		}
+	}
+}
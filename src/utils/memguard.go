@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"math"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// memoryReserveFree 不超过此大小的缓冲预留总是成功，避免小脚本也因内存压力退化
+const memoryReserveFree = 256 * 1024
+
+// memoryPressurePercent 缓冲字节数达到上限的该百分比时视为内存紧张，新的重任务排队
+const memoryPressurePercent = 90
+
+// runtimeMemoryLimit 启动时的内存软上限，来自GOMEMLIMIT环境变量，未设置时为math.MaxInt64
+var runtimeMemoryLimit = debug.SetMemoryLimit(-1)
+
+// MemoryGuard 估算在途缓冲占用的内存(脚本改写、多连接加速的分块和热点缓存)，
+// 接近上限时让重缓冲操作退化为流式转发或排队，而不是等到OOM
+type MemoryGuard struct {
+	config   *config.Store
+	buffered atomic.Int64
+	degraded atomic.Uint64
+	hot      atomic.Pointer[HotCache]
+}
+
+// MemoryStats 内存保护的当前状态，Ceiling为0表示未设置内存上限、不做限制
+type MemoryStats struct {
+	Buffered      int64  `json:"buffered_bytes"`
+	HotCache      int64  `json:"hot_cache_bytes"`
+	Ceiling       int64  `json:"ceiling_bytes"`
+	Limit         int64  `json:"limit_bytes"`
+	Degraded      uint64 `json:"degraded"`
+	UnderPressure bool   `json:"under_pressure"`
+}
+
+// NewMemoryGuard 创建按store中的配置计算缓冲上限的内存保护
+func NewMemoryGuard(store *config.Store) *MemoryGuard {
+	return &MemoryGuard{config: store}
+}
+
+// InitMemoryGuard 按cfg中的memory.limitBytes设置运行时内存软上限，该上限属于整个进程
+func InitMemoryGuard(cfg *config.AppConfig) {
+	applyMemoryLimit(cfg)
+}
+
+// MemoryLimitReloader 热重载修改了memory段时重新设置内存软上限
+func MemoryLimitReloader() config.Reloadable {
+	return config.ReloadFunc{Sections: []string{"memory"}, Fn: func(_, updated *config.AppConfig) {
+		applyMemoryLimit(updated)
+	}}
+}
+
+func applyMemoryLimit(cfg *config.AppConfig) {
+	limit := memoryLimit(cfg)
+	if previous := debug.SetMemoryLimit(limit); previous != limit && limit != math.MaxInt64 {
+		Logf(LogInfo, "memory", "内存软上限: %d MB", limit/1024/1024)
+	}
+}
+
+// memoryLimit 返回配置的内存上限，未配置时沿用GOMEMLIMIT
+func memoryLimit(cfg *config.AppConfig) int64 {
+	if cfg.Memory.LimitBytes > 0 {
+		return cfg.Memory.LimitBytes
+	}
+	return runtimeMemoryLimit
+}
+
+// MemoryCeiling 返回cfg允许缓冲的字节数上限，为内存上限乘以memory.bufferRatio，未设置内存上限时返回0
+func MemoryCeiling(cfg *config.AppConfig) int64 {
+	limit := memoryLimit(cfg)
+	if limit <= 0 || limit == math.MaxInt64 {
+		return 0
+	}
+	return int64(float64(limit) * cfg.Memory.BufferRatio)
+}
+
+// Buffered 返回在途缓冲和热点缓存合计占用的字节数
+func (g *MemoryGuard) Buffered() int64 {
+	return g.buffered.Load() + g.hotCacheSize()
+}
+
+// TrackHotCache 登记Server创建的热点缓存，内存上限同时计入其占用
+func (g *MemoryGuard) TrackHotCache(h *HotCache) {
+	g.hot.Store(h)
+}
+
+// hotCacheSize 返回已登记的热点缓存占用的字节数
+func (g *MemoryGuard) hotCacheSize() int64 {
+	if h := g.hot.Load(); h != nil {
+		return h.Size()
+	}
+	return 0
+}
+
+// Reserve 为即将缓冲的n字节申请额度，超过上限时返回false，调用方应退化为流式转发；
+// 成功时返回的release必须在缓冲释放后调用
+func (g *MemoryGuard) Reserve(n int64) (func(), bool) {
+	if ceiling := MemoryCeiling(g.config.Get()); n > memoryReserveFree && ceiling > 0 && g.Buffered()+n > ceiling {
+		g.degraded.Add(1)
+		return nil, false
+	}
+	g.buffered.Add(n)
+	var once sync.Once
+	return func() {
+		once.Do(func() { g.buffered.Add(-n) })
+	}, true
+}
+
+// UnderPressure 缓冲占用接近上限时返回true
+func (g *MemoryGuard) UnderPressure() bool {
+	ceiling := MemoryCeiling(g.config.Get())
+	return ceiling > 0 && g.Buffered()*100 >= ceiling*memoryPressurePercent
+}
+
+// RecordDegraded 记录一次因内存紧张而排队或退化的操作
+func (g *MemoryGuard) RecordDegraded() {
+	g.degraded.Add(1)
+}
+
+// Stats 返回内存保护的当前状态
+func (g *MemoryGuard) Stats() MemoryStats {
+	cfg := g.config.Get()
+	stats := MemoryStats{
+		Buffered: g.buffered.Load(),
+		HotCache: g.hotCacheSize(),
+		Ceiling:  MemoryCeiling(cfg),
+		Degraded: g.degraded.Load(),
+	}
+	if limit := memoryLimit(cfg); limit != math.MaxInt64 {
+		stats.Limit = limit
+	}
+	stats.UnderPressure = stats.Ceiling > 0 && (stats.Buffered+stats.HotCache)*100 >= stats.Ceiling*memoryPressurePercent
+	return stats
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestMemoryGuardDegradesNearCeiling(t *testing.T) {
+	loadTestConfig(t, `
+[memory]
+limitBytes = 4194304
+bufferRatio = 0.5
+`)
+	guard := NewMemoryGuard(testStore())
+	if ceiling := MemoryCeiling(config.GetConfig()); ceiling != 2<<20 {
+		t.Fatalf("ceiling = %d", ceiling)
+	}
+
+	release, ok := guard.Reserve(1 << 20)
+	if !ok {
+		t.Fatal("reservation below the ceiling was rejected")
+	}
+	if guard.UnderPressure() {
+		t.Fatal("under pressure at 50% of the ceiling")
+	}
+	if _, ok := guard.Reserve(1536 << 10); ok {
+		t.Fatal("reservation above the ceiling was accepted")
+	}
+	// 小缓冲不受上限影响
+	small, ok := guard.Reserve(memoryReserveFree)
+	if !ok {
+		t.Fatal("small reservation was rejected")
+	}
+	small()
+
+	more, ok := guard.Reserve(900 << 10)
+	if !ok {
+		t.Fatal("reservation up to the ceiling was rejected")
+	}
+	stats := guard.Stats()
+	if !guard.UnderPressure() || !stats.UnderPressure || stats.Degraded != 1 || stats.Buffered != 1924<<10 || stats.Limit != 4<<20 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	more()
+	more()
+	release()
+	if guard.UnderPressure() || guard.Buffered() != 0 {
+		t.Fatalf("buffered after release = %d", guard.Buffered())
+	}
+}
+
+func TestMemoryGuardDisabledWithoutLimit(t *testing.T) {
+	loadTestConfig(t, "")
+	saved := runtimeMemoryLimit
+	runtimeMemoryLimit = math.MaxInt64
+	t.Cleanup(func() { runtimeMemoryLimit = saved })
+
+	guard := NewMemoryGuard(testStore())
+	if _, ok := guard.Reserve(1 << 40); !ok || guard.UnderPressure() {
+		t.Fatal("guard limited buffering without a memory limit")
+	}
+	if stats := guard.Stats(); stats.Ceiling != 0 || stats.Limit != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,456 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 错误码，各语言保持不变，客户端应根据错误码而非提示文字判断错误类型
+const (
+	ErrCodeInternal              = "INTERNAL_ERROR"
+	ErrCodeIPBlocked             = "IP_BLOCKED"
+	ErrCodeRateLimited           = "RATE_LIMITED"
+	ErrCodeDockerNotWhitelisted  = "DOCKER_NOT_WHITELISTED"
+	ErrCodeDockerBlacklisted     = "DOCKER_BLACKLISTED"
+	ErrCodeGitHubInvalidRepo     = "GITHUB_INVALID_REPO"
+	ErrCodeGitHubNotWhitelisted  = "GITHUB_NOT_WHITELISTED"
+	ErrCodeGitHubBlacklisted     = "GITHUB_BLACKLISTED"
+	ErrCodeInvalidInput          = "INVALID_INPUT"
+	ErrCodeTooManyRedirects      = "TOO_MANY_REDIRECTS"
+	ErrCodeRedirectLoop          = "REDIRECT_LOOP"
+	ErrCodeUpstream              = "UPSTREAM_ERROR"
+	ErrCodeContentTypeBlocked    = "CONTENT_TYPE_BLOCKED"
+	ErrCodeFileTooLarge          = "FILE_TOO_LARGE"
+	ErrCodeScriptProcessing      = "SCRIPT_PROCESSING_FAILED"
+	ErrCodeRegistryV2Only        = "REGISTRY_V2_ONLY"
+	ErrCodeInvalidPath           = "INVALID_PATH"
+	ErrCodeImageAccessDenied     = "IMAGE_ACCESS_DENIED"
+	ErrCodeEndpointNotFound      = "ENDPOINT_NOT_FOUND"
+	ErrCodeManifestNotFound      = "MANIFEST_NOT_FOUND"
+	ErrCodeInvalidReference      = "INVALID_REFERENCE"
+	ErrCodeInvalidDigest         = "INVALID_DIGEST"
+	ErrCodeLayerNotFound         = "LAYER_NOT_FOUND"
+	ErrCodeLayerReadFailed       = "LAYER_READ_FAILED"
+	ErrCodeInvalidRepository     = "INVALID_REPOSITORY"
+	ErrCodeTagsNotFound          = "TAGS_NOT_FOUND"
+	ErrCodeAuthFailed            = "AUTH_FAILED"
+	ErrCodeRegistryNotConfigured = "REGISTRY_NOT_CONFIGURED"
+	ErrCodeRegistryDisabled      = "REGISTRY_DISABLED"
+	ErrCodeUnauthorized          = "UNAUTHORIZED"
+	ErrCodeRefererBlocked        = "REFERER_BLOCKED"
+	ErrCodeCrawlerBlocked        = "CRAWLER_BLOCKED"
+	ErrCodeQuotaExceeded         = "QUOTA_EXCEEDED"
+	ErrCodeBodyTooLarge          = "REQUEST_BODY_TOO_LARGE"
+	ErrCodeGitHubNotFound        = "GITHUB_NOT_FOUND"
+	ErrCodeGitHubForbidden       = "GITHUB_FORBIDDEN"
+	ErrCodeOutsideSchedule       = "OUTSIDE_SCHEDULE"
+	ErrCodeBudgetExhausted       = "BUDGET_EXHAUSTED"
+	ErrCodeUpstreamBudget        = "UPSTREAM_BUDGET_EXCEEDED"
+	ErrCodeGitHubHTMLPage        = "GITHUB_HTML_PAGE"
+	ErrCodeGitHubRateLimit       = "GITHUB_RATE_LIMITED"
+	ErrCodeRequestTimeout        = "REQUEST_TIMEOUT"
+	ErrCodeContentScreened       = "CONTENT_SCREENED"
+	ErrCodeNewRepoBudget         = "NEW_REPO_BUDGET_EXCEEDED"
+	ErrCodeUpstreamThrottled     = "UPSTREAM_THROTTLED"
+	ErrCodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	ErrCodeRepositoryUppercase   = "REPOSITORY_NAME_UPPERCASE"
+	ErrCodeAdminTokenInvalid     = "ADMIN_TOKEN_INVALID"
+	ErrCodeInvalidRequestBody    = "INVALID_REQUEST_BODY"
+	ErrCodeInvalidParameter      = "INVALID_PARAMETER"
+	ErrCodeMissingParameter      = "MISSING_PARAMETER"
+	ErrCodeFeatureDisabled       = "FEATURE_DISABLED"
+	ErrCodeNotFound              = "NOT_FOUND"
+	ErrCodeConflict              = "CONFLICT"
+	ErrCodeJobNotFound           = "JOB_NOT_FOUND"
+	ErrCodeJobLimitExceeded      = "JOB_LIMIT_EXCEEDED"
+	ErrCodeDownloadTokenMissing  = "DOWNLOAD_TOKEN_MISSING"
+	ErrCodeDownloadTokenInvalid  = "DOWNLOAD_TOKEN_INVALID"
+	ErrCodeDownloadTokenMismatch = "DOWNLOAD_TOKEN_MISMATCH"
+	ErrCodeEmptyImageList        = "EMPTY_IMAGE_LIST"
+	ErrCodeTooManyImages         = "TOO_MANY_IMAGES"
+	ErrCodeTooManySubscribers    = "TOO_MANY_SUBSCRIBERS"
+)
+
+// 支持的语言
+const (
+	LangZh = "zh"
+	LangEn = "en"
+)
+
+// messageCatalog 错误提示文案，格式参数按fmt.Sprintf处理
+var messageCatalog = map[string]map[string]string{
+	LangZh: {
+		ErrCodeInternal:              "服务器内部错误",
+		ErrCodeIPBlocked:             "您已被限制访问",
+		ErrCodeRateLimited:           "请求频率过快，暂时限制访问",
+		ErrCodeDockerNotWhitelisted:  "不在Docker镜像白名单内",
+		ErrCodeDockerBlacklisted:     "Docker镜像在黑名单内",
+		ErrCodeGitHubInvalidRepo:     "无效的GitHub仓库格式",
+		ErrCodeGitHubNotWhitelisted:  "不在GitHub仓库白名单内",
+		ErrCodeGitHubBlacklisted:     "GitHub仓库在黑名单内",
+		ErrCodeInvalidInput:          "无效输入",
+		ErrCodeTooManyRedirects:      "重定向次数过多，可能存在循环重定向",
+		ErrCodeRedirectLoop:          "检测到循环重定向: %s",
+		ErrCodeUpstream:              "上游请求失败: %v",
+		ErrCodeContentTypeBlocked:    "检测到网页类型，本服务不支持加速网页，请检查您的链接是否正确。",
+		ErrCodeFileTooLarge:          "文件过大，限制大小: %d MB",
+		ErrCodeScriptProcessing:      "脚本处理失败: %v",
+		ErrCodeRegistryV2Only:        "仅支持Docker Registry API v2",
+		ErrCodeInvalidPath:           "路径格式错误",
+		ErrCodeImageAccessDenied:     "镜像访问被限制",
+		ErrCodeEndpointNotFound:      "接口不存在",
+		ErrCodeManifestNotFound:      "Manifest不存在",
+		ErrCodeInvalidReference:      "镜像引用格式错误",
+		ErrCodeInvalidDigest:         "digest引用格式错误",
+		ErrCodeLayerNotFound:         "镜像层不存在",
+		ErrCodeLayerReadFailed:       "读取镜像层失败",
+		ErrCodeInvalidRepository:     "仓库名格式错误",
+		ErrCodeTagsNotFound:          "标签列表不存在",
+		ErrCodeAuthFailed:            "认证请求失败",
+		ErrCodeRegistryNotConfigured: "Registry未配置",
+		ErrCodeRegistryDisabled:      "Registry %s 已停用",
+		ErrCodeUnauthorized:          "需要认证",
+		ErrCodeRefererBlocked:        "不允许从该网站引用本服务的资源",
+		ErrCodeCrawlerBlocked:        "不允许爬虫访问",
+		ErrCodeQuotaExceeded:         "本月流量配额已用完",
+		ErrCodeBodyTooLarge:          "请求体过大，限制大小: %d 字节",
+		ErrCodeGitHubNotFound:        "GitHub仓库或路径不存在",
+		ErrCodeGitHubForbidden:       "GitHub API拒绝访问或已触发限流，请稍后再试",
+		ErrCodeOutsideSchedule:       "当前不在允许执行下载任务的时段，下次允许时间: %s",
+		ErrCodeBudgetExhausted:       "今日下载任务流量预算已用完，下次允许时间: %s",
+		ErrCodeUpstreamBudget:        "上游 %s 的出站请求预算已用尽，请稍后重试",
+		ErrCodeGitHubHTMLPage:        "上游返回了HTML网页(HTTP %d)而不是文件，请检查文件路径和分支/标签是否正确",
+		ErrCodeGitHubRateLimit:       "GitHub API请求次数已达上限，将于 %s 重置，请稍后重试或携带GitHub令牌访问",
+		ErrCodeRequestTimeout:        "请求处理超过 %s 未完成，已取消，请稍后重试",
+		ErrCodeContentScreened:       "该资源已被本站的滥用内容筛查屏蔽",
+		ErrCodeNewRepoBudget:         "该仓库为新出现的仓库，下载量已超出限制，请稍后再试",
+		ErrCodeUpstreamThrottled:     "上游 %s 正在限流，请按Retry-After稍后重试",
+		ErrCodeMethodNotAllowed:      "该链接不允许 %s 请求",
+		ErrCodeRepositoryUppercase:   "镜像仓库名称必须为小写: %s，请改用 %s",
+		ErrCodeAdminTokenInvalid:     "管理令牌无效",
+		ErrCodeInvalidRequestBody:    "请求格式错误: %v",
+		ErrCodeInvalidParameter:      "请求参数无效",
+		ErrCodeMissingParameter:      "缺少必需的参数",
+		ErrCodeFeatureDisabled:       "该功能未启用",
+		ErrCodeNotFound:              "资源不存在",
+		ErrCodeConflict:              "资源已存在",
+		ErrCodeJobNotFound:           "任务不存在",
+		ErrCodeJobLimitExceeded:      "下载任务数量已达上限，请稍后再试",
+		ErrCodeDownloadTokenMissing:  "缺少下载令牌",
+		ErrCodeDownloadTokenInvalid:  "无效或过期的下载令牌",
+		ErrCodeDownloadTokenMismatch: "下载令牌与镜像不匹配",
+		ErrCodeEmptyImageList:        "镜像列表不能为空",
+		ErrCodeTooManyImages:         "镜像数量超过限制，最大允许: %d",
+		ErrCodeTooManySubscribers:    "订阅者过多，请稍后再试",
+	},
+	LangEn: {
+		ErrCodeInternal:              "Internal server error",
+		ErrCodeIPBlocked:             "Your IP address has been blocked",
+		ErrCodeRateLimited:           "Too many requests, please slow down",
+		ErrCodeDockerNotWhitelisted:  "Image is not in the Docker whitelist",
+		ErrCodeDockerBlacklisted:     "Image is blacklisted",
+		ErrCodeGitHubInvalidRepo:     "Invalid GitHub repository format",
+		ErrCodeGitHubNotWhitelisted:  "Repository is not in the GitHub whitelist",
+		ErrCodeGitHubBlacklisted:     "Repository is blacklisted",
+		ErrCodeInvalidInput:          "Invalid input",
+		ErrCodeTooManyRedirects:      "Too many redirects, possible redirect loop",
+		ErrCodeRedirectLoop:          "Redirect loop detected: %s",
+		ErrCodeUpstream:              "Upstream request failed: %v",
+		ErrCodeContentTypeBlocked:    "Web pages cannot be proxied, please check that the link is correct.",
+		ErrCodeFileTooLarge:          "File too large, limit: %d MB",
+		ErrCodeScriptProcessing:      "Script processing failed: %v",
+		ErrCodeRegistryV2Only:        "Docker Registry API v2 only",
+		ErrCodeInvalidPath:           "Invalid path format",
+		ErrCodeImageAccessDenied:     "Access to this image is restricted",
+		ErrCodeEndpointNotFound:      "API endpoint not found",
+		ErrCodeManifestNotFound:      "Manifest not found",
+		ErrCodeInvalidReference:      "Invalid reference",
+		ErrCodeInvalidDigest:         "Invalid digest reference",
+		ErrCodeLayerNotFound:         "Layer not found",
+		ErrCodeLayerReadFailed:       "Failed to read layer",
+		ErrCodeInvalidRepository:     "Invalid repository",
+		ErrCodeTagsNotFound:          "Tags not found",
+		ErrCodeAuthFailed:            "Auth request failed",
+		ErrCodeRegistryNotConfigured: "Registry not configured",
+		ErrCodeRegistryDisabled:      "Registry %s is disabled",
+		ErrCodeUnauthorized:          "Authentication required",
+		ErrCodeRefererBlocked:        "Hotlinking from this site is not allowed",
+		ErrCodeCrawlerBlocked:        "Crawlers are not allowed",
+		ErrCodeQuotaExceeded:         "Monthly traffic quota exhausted",
+		ErrCodeBodyTooLarge:          "Request body too large, limit: %d bytes",
+		ErrCodeGitHubNotFound:        "GitHub repository or path not found",
+		ErrCodeGitHubForbidden:       "GitHub API denied the request or is rate limiting, please try again later",
+		ErrCodeOutsideSchedule:       "Heavy jobs are not allowed at this time, next allowed at: %s",
+		ErrCodeBudgetExhausted:       "Daily traffic budget for heavy jobs is exhausted, next allowed at: %s",
+		ErrCodeUpstreamBudget:        "Outbound request budget for upstream %s is exhausted, please retry later",
+		ErrCodeGitHubHTMLPage:        "Upstream returned an HTML page (HTTP %d) instead of a file, check that the file path and branch/tag are correct",
+		ErrCodeGitHubRateLimit:       "GitHub API rate limit exceeded, resets at %s; retry later or authenticate with a GitHub token",
+		ErrCodeRequestTimeout:        "Request did not complete within %s and was cancelled, please retry later",
+		ErrCodeContentScreened:       "This resource has been blocked by abuse screening",
+		ErrCodeNewRepoBudget:         "Download budget for this newly seen repository is exhausted, please retry later",
+		ErrCodeUpstreamThrottled:     "Upstream %s is rate limiting requests, please retry after the Retry-After interval",
+		ErrCodeMethodNotAllowed:      "Method %s is not allowed for this URL",
+		ErrCodeRepositoryUppercase:   "Repository names must be lowercase: %s, use %s instead",
+		ErrCodeAdminTokenInvalid:     "Invalid admin token",
+		ErrCodeInvalidRequestBody:    "Malformed request body: %v",
+		ErrCodeInvalidParameter:      "Invalid request parameter",
+		ErrCodeMissingParameter:      "Missing required parameter",
+		ErrCodeFeatureDisabled:       "This feature is not enabled",
+		ErrCodeNotFound:              "Resource not found",
+		ErrCodeConflict:              "Resource already exists",
+		ErrCodeJobNotFound:           "Job not found",
+		ErrCodeJobLimitExceeded:      "Download job limit reached, please retry later",
+		ErrCodeDownloadTokenMissing:  "Missing download token",
+		ErrCodeDownloadTokenInvalid:  "Invalid or expired download token",
+		ErrCodeDownloadTokenMismatch: "Download token does not match the image",
+		ErrCodeEmptyImageList:        "Image list must not be empty",
+		ErrCodeTooManyImages:         "Too many images, maximum allowed: %d",
+		ErrCodeTooManySubscribers:    "Too many subscribers, please retry later",
+	},
+}
+
+// normalizeLanguage 将语言标签归一化为支持的语言，不支持时返回空
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if _, exists := messageCatalog[tag]; exists {
+		return tag
+	}
+	return ""
+}
+
+// negotiateLanguage 按Accept-Language的权重选择支持的语言
+func negotiateLanguage(header string) string {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := normalizeLanguage(fields[0])
+		if lang == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{lang, quality})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0].lang
+}
+
+// RequestLanguage 按请求所属服务的配置返回当前请求使用的提示语言
+func RequestLanguage(c *gin.Context) string {
+	cfg := RequestConfig(c)
+	if cfg.Server.NegotiateLanguage && c != nil && c.Request != nil {
+		if lang := negotiateLanguage(c.GetHeader("Accept-Language")); lang != "" {
+			return lang
+		}
+	}
+	if lang := normalizeLanguage(cfg.Server.Language); lang != "" {
+		return lang
+	}
+	return LangZh
+}
+
+// Message 返回指定语言的错误提示，未知错误码原样返回
+func Message(lang, code string, args ...interface{}) string {
+	text, exists := messageCatalog[lang][code]
+	if !exists {
+		if text, exists = messageCatalog[LangZh][code]; !exists {
+			return code
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// Localize 按请求语言返回错误提示
+func Localize(c *gin.Context, code string, args ...interface{}) string {
+	return Message(RequestLanguage(c), code, args...)
+}
+
+// errorCodeKey 已返回的错误码在上下文中的key
+const errorCodeKey = "error_code"
+
+// ErrorCode 返回本次请求已响应的错误码，未返回错误时为空
+func ErrorCode(c *gin.Context) string {
+	return c.GetString(errorCodeKey)
+}
+
+// RespondError 返回JSON错误，包含本地化提示和稳定的错误码，429/503附带重试建议
+func RespondError(c *gin.Context, status int, code string, args ...interface{}) {
+	RespondErrorMessage(c, status, code, Localize(c, code, args...))
+}
+
+// RespondErrorMessage 与RespondError相同，提示由调用方给出，用于包含校验失败原因等具体信息的错误
+func RespondErrorMessage(c *gin.Context, status int, code, message string) {
+	RespondErrorFields(c, status, code, message, nil)
+}
+
+// RespondErrorFields 与RespondErrorMessage相同，并在错误中附带额外字段，如排队长度
+func RespondErrorFields(c *gin.Context, status int, code, message string, fields gin.H) {
+	body := gin.H{}
+	for k, v := range fields {
+		body[k] = v
+	}
+	body["error"] = message
+	body["code"] = code
+	c.Set(errorCodeKey, code)
+	c.AbortWithStatusJSON(status, WithRetryAdvice(c, status, body))
+}
+
+// RespondErrorText 返回纯文本错误，错误码通过X-Error-Code响应头提供
+func RespondErrorText(c *gin.Context, status int, code string, args ...interface{}) {
+	c.Set(errorCodeKey, code)
+	c.Header("X-Error-Code", code)
+	RetryAdvice(c, status)
+	c.String(status, Localize(c, code, args...))
+	c.Abort()
+}
+
+// RegistryErrorBody 构建Registry API规范的错误响应体
+func RegistryErrorBody(code, message string) []byte {
+	body, _ := json.Marshal(gin.H{
+		"errors": []gin.H{{"code": code, "message": message}},
+	})
+	return body
+}
+
+// RespondRegistryError 按Registry API规范返回本地化错误，message取自错误码对应的提示；
+// 429/503同时设置Retry-After，并在错误的detail中附带retry_after和retry_jitter
+func RespondRegistryError(c *gin.Context, status int, registryCode, messageCode string, args ...interface{}) {
+	c.Header("X-Error-Code", messageCode)
+	message := Localize(c, messageCode, args...)
+	if retryAfter, jitter := RetryAdvice(c, status); retryAfter > 0 {
+		body, _ := json.Marshal(gin.H{
+			"errors": []gin.H{{"code": registryCode, "message": message, "detail": gin.H{"retry_after": retryAfter, "retry_jitter": jitter}}},
+		})
+		c.Data(status, "application/json", body)
+		return
+	}
+	c.Data(status, "application/json", RegistryErrorBody(registryCode, message))
+}
+
+// BodyLimitKey 请求上下文中记录请求体上限的key
+const BodyLimitKey = "request_body_limit"
+
+// BodyTooLarge 判断上游请求失败是否因为请求体超出上限
+func BodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// RequestIDHeader 请求ID响应头，客户端传入合法的ID时沿用，便于与日志对照
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// validRequestID 客户端传入的请求ID只接受较短的字母、数字、'-'和'_'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID 返回当前请求的ID并写入响应头，客户端未提供时生成随机ID
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// ProxyError 代理路径的结构化错误，upstreamStatus仅在错误由上游响应引起时提供，
+// retryAfter和retryJitter仅在429/503时提供，建议等待retryAfter秒后再随机等待不超过retryJitter秒
+type ProxyError struct {
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+	RequestID      string `json:"requestId"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+	RetryAfter     int    `json:"retryAfter,omitempty"`
+	RetryJitter    int    `json:"retryJitter,omitempty"`
+}
+
+// acceptsPlainText 判断客户端是否明确要求纯文本：Accept列出text/plain且未列出application/json
+func acceptsPlainText(accept string) bool {
+	plain := false
+	for _, part := range strings.Split(accept, ",") {
+		switch strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0])) {
+		case "text/plain":
+			plain = true
+		case "application/json":
+			return false
+		}
+	}
+	return plain
+}
+
+// RespondProxyError 代理路径返回结构化JSON错误，错误码同时写入X-Error-Code响应头，
+// 与代理成功时透传的文件内容可通过Content-Type区分；客户端明确要求text/plain时返回纯文本
+func RespondProxyError(c *gin.Context, status int, code string, args ...interface{}) {
+	RespondUpstreamError(c, status, 0, code, args...)
+}
+
+// RespondUpstreamError 与RespondProxyError相同，并在错误中附带上游的状态码
+func RespondUpstreamError(c *gin.Context, status, upstreamStatus int, code string, args ...interface{}) {
+	requestID := RequestID(c)
+	if acceptsPlainText(c.GetHeader("Accept")) {
+		RespondErrorText(c, status, code, args...)
+		return
+	}
+	c.Set(errorCodeKey, code)
+	c.Header("X-Error-Code", code)
+	retryAfter, jitter := RetryAdvice(c, status)
+	c.AbortWithStatusJSON(status, ProxyError{
+		Code:           code,
+		Message:        Localize(c, code, args...),
+		RequestID:      requestID,
+		UpstreamStatus: upstreamStatus,
+		RetryAfter:     retryAfter,
+		RetryJitter:    jitter,
+	})
+}
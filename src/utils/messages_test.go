@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func loadTestConfig(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStore 返回持有当前全局配置的store，供测试构造组件
+func testStore() *config.Store {
+	return config.NewStore(config.GetConfig())
+}
+
+func TestMessageCatalogComplete(t *testing.T) {
+	for code := range messageCatalog[LangZh] {
+		if _, exists := messageCatalog[LangEn][code]; !exists {
+			t.Errorf("code %s missing en message", code)
+		}
+	}
+	for code := range messageCatalog[LangEn] {
+		if _, exists := messageCatalog[LangZh][code]; !exists {
+			t.Errorf("code %s missing zh message", code)
+		}
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"en-US,en;q=0.9", LangEn},
+		{"zh-CN,zh;q=0.9,en;q=0.8", LangZh},
+		{"fr;q=1.0,en;q=0.5,zh;q=0.7", LangZh},
+		{"en;q=0,zh;q=0.1", LangZh},
+		{"fr,de", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func respondErrorBody(t *testing.T, acceptLanguage string) map[string]interface{} {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Set(requestConfigKey, config.GetConfig())
+	if acceptLanguage != "" {
+		c.Request.Header.Set("Accept-Language", acceptLanguage)
+	}
+	RespondError(c, http.StatusTooManyRequests, ErrCodeRateLimited)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestRespondErrorLanguage(t *testing.T) {
+	loadTestConfig(t, "[server]\nlanguage = \"zh\"\n")
+	zh := respondErrorBody(t, "en")
+	if zh["error"] != messageCatalog[LangZh][ErrCodeRateLimited] {
+		t.Fatalf("zh error = %q", zh["error"])
+	}
+
+	loadTestConfig(t, "[server]\nlanguage = \"en\"\n")
+	en := respondErrorBody(t, "")
+	if en["error"] != messageCatalog[LangEn][ErrCodeRateLimited] {
+		t.Fatalf("en error = %q", en["error"])
+	}
+	if zh["code"] != ErrCodeRateLimited || en["code"] != ErrCodeRateLimited {
+		t.Fatalf("codes differ: %q %q", zh["code"], en["code"])
+	}
+
+	loadTestConfig(t, "[server]\nlanguage = \"zh\"\nnegotiateLanguage = true\n")
+	negotiated := respondErrorBody(t, "en-GB,en;q=0.9")
+	if negotiated["error"] != messageCatalog[LangEn][ErrCodeRateLimited] {
+		t.Fatalf("negotiated error = %q", negotiated["error"])
+	}
+}
+
+func TestMessageFormatting(t *testing.T) {
+	if got := Message(LangEn, ErrCodeFileTooLarge, 10); got != "File too large, limit: 10 MB" {
+		t.Fatalf("Message = %q", got)
+	}
+	if got := Message(LangEn, "UNKNOWN_CODE"); got != "UNKNOWN_CODE" {
+		t.Fatalf("unknown code = %q", got)
+	}
+}
@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcClockSkew 校验exp/nbf时允许的时钟偏差
+const oidcClockSkew = time.Minute
+
+// oidcRefreshInterval 遇到未知kid时刷新JWKS的最小间隔，避免伪造kid触发频繁请求
+const oidcRefreshInterval = time.Minute
+
+var (
+	ErrTokenMalformed = errors.New("令牌格式错误")
+	ErrTokenSignature = errors.New("令牌签名无效")
+	ErrTokenExpired   = errors.New("令牌已过期")
+	ErrTokenClaims    = errors.New("令牌签发者或受众不匹配")
+)
+
+// TokenClaims 已验证令牌中使用到的声明
+type TokenClaims struct {
+	Subject   string
+	ExpiresAt time.Time
+}
+
+// OIDCVerifier 校验OIDC签发的JWT，签名公钥从JWKS获取并缓存
+type OIDCVerifier struct {
+	issuer   string
+	audience string
+	jwksURL  string
+	cacheTTL time.Duration
+	clients  *HTTPClients
+	// ctx 下载JWKS的父上下文，服务停止时取消
+	ctx context.Context
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// NewOIDCVerifier 创建经clients获取公钥的OIDC令牌校验器，jwksURL为空时通过issuer的discovery文档获取，
+// 下载在ctx取消后中止
+func NewOIDCVerifier(ctx context.Context, clients *HTTPClients, issuer, audience, jwksURL string, cacheTTL time.Duration) *OIDCVerifier {
+	return &OIDCVerifier{
+		ctx:      ctx,
+		clients:  clients,
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		jwksURL:  jwksURL,
+		cacheTTL: cacheTTL,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// hasAudience aud可以是字符串或字符串数组
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return single == audience
+	}
+	var multiple []string
+	if err := json.Unmarshal(c.Audience, &multiple); err == nil {
+		for _, aud := range multiple {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify 校验令牌签名、签发者、受众和有效期
+func (v *OIDCVerifier) Verify(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != v.issuer || !claims.hasAudience(v.audience) {
+		return nil, ErrTokenClaims
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return nil, ErrTokenExpired
+	}
+	expiresAt := time.Unix(int64(*claims.ExpiresAt), 0)
+	if now.After(expiresAt.Add(oidcClockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(oidcClockSkew).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, ErrTokenExpired
+	}
+
+	return &TokenClaims{Subject: claims.Subject, ExpiresAt: expiresAt}, nil
+}
+
+// key 返回kid对应的公钥，缓存过期或kid未知时刷新JWKS
+func (v *OIDCVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stale := v.keys == nil || (v.cacheTTL > 0 && time.Since(v.fetchedAt) > v.cacheTTL)
+	if key, exists := v.lookup(kid); exists && !stale {
+		return key, nil
+	}
+
+	if stale || time.Since(v.lastAttempt) >= oidcRefreshInterval {
+		v.lastAttempt = time.Now()
+		keys, err := v.fetchKeys()
+		if err != nil {
+			fmt.Printf("获取JWKS失败: %v\n", err)
+		} else {
+			v.keys = keys
+			v.fetchedAt = time.Now()
+		}
+	}
+
+	if key, exists := v.lookup(kid); exists {
+		return key, nil
+	}
+	return nil, ErrTokenSignature
+}
+
+// lookup kid为空且只有一个公钥时直接使用该公钥
+func (v *OIDCVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, exists := v.keys[kid]; exists {
+		return key, true
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys 下载并解析JWKS
+func (v *OIDCVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	// 下载期间持有锁，不随触发下载的请求取消，服务停止时取消
+	ctx, cancel := v.clients.MetadataContext(v.ctx)
+	defer cancel()
+
+	jwksURL := v.jwksURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := fetchJSON(ctx, v.clients.Metadata(), v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("discovery文档缺少jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(ctx, v.clients.Metadata(), jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			fmt.Printf("跳过无法解析的JWK %s: %v\n", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// fetchJSON 使用元数据客户端获取JSON
+func fetchJSON(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求 %s 返回状态码 %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+// jsonWebKey JWKS中的单个公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线 %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("不支持的密钥类型 %s", k.Kty)
+	}
+}
+
+// verifyJWTSignature 校验RS*/PS*/ES*签名
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return ErrTokenSignature
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		if pub, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil {
+			return nil
+		}
+	case strings.HasPrefix(alg, "PS"):
+		if pub, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPSS(pub, hash, digest, signature, nil) == nil {
+			return nil
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			break
+		}
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+		if ecdsa.Verify(pub, digest, r, s) {
+			return nil
+		}
+	}
+	return ErrTokenSignature
+}
+
+func decodeJWTPart(part string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jwksRequests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": server.URL + "/keys"})
+		case "/keys":
+			jwksRequests.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+				{
+					"kty": "RSA", "kid": "rsa", "use": "sig",
+					"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+					"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "EC", "kid": "ec", "crv": "P-256",
+					"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+					"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+				},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	verifier := NewOIDCVerifier(t.Context(), NewHTTPClients(testStore(), NewStatsRegistry()), server.URL, "hubproxy", "", time.Hour)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	valid := map[string]interface{}{"iss": server.URL, "sub": "alice", "aud": "hubproxy", "exp": exp}
+
+	claims, err := verifier.Verify(signTestJWT(t, "RS256", "rsa", rsaKey, valid))
+	if err != nil {
+		t.Fatalf("RS256 token rejected: %v", err)
+	}
+	if claims.Subject != "alice" {
+		t.Fatalf("subject = %q", claims.Subject)
+	}
+
+	multiAud := map[string]interface{}{"iss": server.URL, "sub": "bob", "aud": []string{"other", "hubproxy"}, "exp": exp}
+	if _, err := verifier.Verify(signTestJWT(t, "ES256", "ec", ecKey, multiAud)); err != nil {
+		t.Fatalf("ES256 token rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		expect error
+	}{
+		{"malformed", "not-a-jwt", ErrTokenMalformed},
+		{"wrong audience", signTestJWT(t, "RS256", "rsa", rsaKey,
+			map[string]interface{}{"iss": server.URL, "aud": "other", "exp": exp}), ErrTokenClaims},
+		{"wrong issuer", signTestJWT(t, "RS256", "rsa", rsaKey,
+			map[string]interface{}{"iss": "https://evil.example", "aud": "hubproxy", "exp": exp}), ErrTokenClaims},
+		{"expired", signTestJWT(t, "RS256", "rsa", rsaKey,
+			map[string]interface{}{"iss": server.URL, "aud": "hubproxy", "exp": float64(time.Now().Add(-time.Hour).Unix())}), ErrTokenExpired},
+		{"alg mismatch", signTestJWT(t, "ES256", "rsa", ecKey, valid), ErrTokenSignature},
+		{"unknown kid", signTestJWT(t, "RS256", "missing", rsaKey, valid), ErrTokenSignature},
+	}
+	for _, tt := range tests {
+		if _, err := verifier.Verify(tt.token); err != tt.expect {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.expect)
+		}
+	}
+
+	if got := jwksRequests.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1 (cached, unknown kid refresh rate limited)", got)
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// ipHashPrefix IP摘要前缀，便于在日志中区分摘要与原始IP
+const ipHashPrefix = "anon-"
+
+// limiterKeyPrefix 限流表中IP摘要的前缀
+const limiterKeyPrefix = "rl-"
+
+// dailySalt 按天轮换的随机盐值，只保存当天的盐值，旧摘要无法再与新摘要关联
+type dailySalt struct {
+	mu  sync.Mutex
+	day string
+	key []byte
+}
+
+// current 返回指定时间所在天(UTC)的盐值，跨天时重新生成
+func (s *dailySalt) current(now time.Time) []byte {
+	day := now.UTC().Format("2006-01-02")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.day != day {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		s.day, s.key = day, key
+	}
+	return s.key
+}
+
+// IPIdentifier 生成写入日志、统计、活动动态和限流表时使用的客户端标识，IP隐私策略只在这里实现。
+// 同一服务的组件共用一个实例，同一天内同一IP的摘要才能相互关联
+type IPIdentifier struct {
+	config *config.Store
+	salt   dailySalt
+	// limiterSecret 限流表key使用的进程内密钥，不随日期轮换，不写入日志和存储
+	limiterSecret []byte
+}
+
+// NewIPIdentifier 创建按store中的隐私配置生成标识的客户端标识生成器，记录用的盐值在首次使用时生成
+func NewIPIdentifier(store *config.Store) *IPIdentifier {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &IPIdentifier{config: store, limiterSecret: secret}
+}
+
+// LoggingDisabled 是否启用了privacy.noIPLogging
+func (p *IPIdentifier) LoggingDisabled() bool {
+	return p.config.Get().Privacy.NoIPLogging
+}
+
+// hash 计算IP在当天盐值下的摘要
+func (p *IPIdentifier) hash(ip string, now time.Time) string {
+	mac := hmac.New(sha256.New, p.salt.current(now))
+	mac.Write([]byte(ip))
+	return ipHashPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Identify 返回ip的客户端标识。启用noIPLogging时始终返回加盐摘要；否则anonymize为true时脱敏，为false时原样返回
+func (p *IPIdentifier) Identify(ip string, anonymize bool) string {
+	if ip == "" {
+		return ip
+	}
+	if p.LoggingDisabled() {
+		return p.hash(ip, time.Now())
+	}
+	if anonymize {
+		return AnonymizeIP(ip)
+	}
+	return ip
+}
+
+// LimiterKey 返回限流表中ip的key。启用noIPLogging时为进程内密钥下的摘要，不随日期变化，
+// 计数不会在UTC零点重置；该摘要只用于内存中的限流表，日志和存储仍使用 Identify 的按天轮换摘要
+func (p *IPIdentifier) LimiterKey(ip string) string {
+	if ip == "" || !p.LoggingDisabled() {
+		return ip
+	}
+	mac := hmac.New(sha256.New, p.limiterSecret)
+	mac.Write([]byte(ip))
+	return limiterKeyPrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Client 返回请求客户端的标识，用于审计日志和按IP计数的状态
+func (p *IPIdentifier) Client(c *gin.Context) string {
+	return p.Identify(c.ClientIP(), false)
+}
+
+// DoNotTrack 客户端是否通过 DNT 或 Sec-GPC 请求头拒绝被记录
+func DoNotTrack(c *gin.Context) bool {
+	return c.GetHeader("DNT") == "1" || c.GetHeader("Sec-GPC") == "1"
+}
+
+// PerIPRetention 按cfg返回按IP保存的状态的最长保留时间，未配置时返回defaultTTL
+func PerIPRetention(cfg *config.AppConfig, defaultTTL time.Duration) time.Duration {
+	days := cfg.Privacy.RetentionDays
+	if days <= 0 {
+		return defaultTTL
+	}
+	return min(defaultTTL, time.Duration(days)*24*time.Hour)
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestIdentifyIPWithoutPrivacy(t *testing.T) {
+	loadTestConfig(t, "")
+	ips := NewIPIdentifier(testStore())
+
+	if got := ips.Identify("203.0.113.7", false); got != "203.0.113.7" {
+		t.Fatalf("Identify = %q, want raw IP", got)
+	}
+	if got := ips.Identify("203.0.113.7", true); got != AnonymizeIP("203.0.113.7") {
+		t.Fatalf("Identify anonymized = %q", got)
+	}
+	if got := PerIPRetention(config.GetConfig(), 2*time.Hour); got != 2*time.Hour {
+		t.Fatalf("PerIPRetention = %v, want default", got)
+	}
+}
+
+func TestIdentifyIPHashed(t *testing.T) {
+	loadTestConfig(t, `
+[privacy]
+noIPLogging = true
+`)
+	ips := NewIPIdentifier(testStore())
+
+	first := ips.Identify("203.0.113.7", false)
+	if !strings.HasPrefix(first, ipHashPrefix) || strings.Contains(first, "203.0.113") {
+		t.Fatalf("Identify = %q, want salted hash", first)
+	}
+	if got := ips.Identify("203.0.113.7", true); got != first {
+		t.Fatalf("anonymize should not change hashed identity: %q != %q", got, first)
+	}
+	if other := ips.Identify("203.0.113.8", false); other == first {
+		t.Fatal("different IPs share a hash")
+	}
+	if other := NewIPIdentifier(testStore()).Identify("203.0.113.7", false); other == first {
+		t.Fatal("separate identifiers share a salt")
+	}
+
+	now := time.Now()
+	today := ips.hash("203.0.113.7", now)
+	if ips.hash("203.0.113.7", now) != today {
+		t.Fatal("hash not stable within a day")
+	}
+	if ips.hash("203.0.113.7", now.Add(24*time.Hour)) == today {
+		t.Fatal("hash not rotated on the next day")
+	}
+}
+
+func TestLimiterKeySurvivesSaltRotation(t *testing.T) {
+	loadTestConfig(t, `
+[privacy]
+noIPLogging = true
+`)
+	store := testStore()
+	ips := NewIPIdentifier(store)
+
+	key := ips.LimiterKey("203.0.113.7")
+	if strings.Contains(key, "203.0.113") || key == ips.Identify("203.0.113.7", false) {
+		t.Fatalf("LimiterKey = %q, want a hash separate from the logged identity", key)
+	}
+	// 记录用的盐值跨天轮换后，限流key保持不变
+	ips.hash("203.0.113.7", time.Now().Add(24*time.Hour))
+	if got := ips.LimiterKey("203.0.113.7"); got != key {
+		t.Fatalf("LimiterKey changed after salt rotation: %q != %q", got, key)
+	}
+	if ips.LimiterKey("203.0.113.8") == key {
+		t.Fatal("different IPs share a limiter key")
+	}
+
+	loadTestConfig(t, "")
+	store.Set(config.GetConfig())
+	if got := ips.LimiterKey("203.0.113.7"); got != "203.0.113.7" {
+		t.Fatalf("LimiterKey without noIPLogging = %q, want raw IP", got)
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 // GitHub URL正则表达式
 var githubRegex = regexp.MustCompile(`(?:^|[\s'"(=,\[{;|&<>])https?://(?:github\.com|raw\.githubusercontent\.com|raw\.github\.com|gist\.githubusercontent\.com|gist\.github\.com|api\.github\.com)[^\s'")]*`)
 
+// Docker软件源URL正则表达式，用于改写apt/yum源定义
+var dockerRepoRegex = regexp.MustCompile(`(?:^|[\s'"(=,\[{;|&<>])https?://download\.docker\.com[^\s'")]*`)
+
 // MaxShellSize 限制最大处理大小为 10MB
 const MaxShellSize = 10 * 1024 * 1024
 
@@ -26,15 +30,59 @@ func ProcessSmart(input io.Reader, isCompressed bool, host string) (io.Reader, i
 		return strings.NewReader(""), 0, nil
 	}
 
-	if !bytes.Contains(content, []byte("github.com")) && !bytes.Contains(content, []byte("githubusercontent.com")) {
+	hasGitHub := bytes.Contains(content, []byte("github.com")) || bytes.Contains(content, []byte("githubusercontent.com"))
+	hasDockerRepo := bytes.Contains(content, []byte("download.docker.com"))
+	if !hasGitHub && !hasDockerRepo {
 		return bytes.NewReader(content), int64(len(content)), nil
 	}
 
-	processed := processGitHubURLs(string(content), host)
+	processed := string(content)
+	if hasGitHub {
+		processed = processGitHubURLs(processed, host)
+	}
+	if hasDockerRepo {
+		processed = processDockerRepoURLs(processed, host)
+	}
 
 	return strings.NewReader(processed), int64(len(processed)), nil
 }
 
+// ShellResult 脚本处理结果。Rewritten为true时Body为解压并改写后的内容，Size为其长度；
+// 否则Body为上游原始字节流，保持上游的Content-Encoding，Size为-1
+type ShellResult struct {
+	Body      io.Reader
+	Size      int64
+	Rewritten bool
+}
+
+// ProcessShellResponse 处理上游脚本响应，超过大小限制或无法解压时回退为原样转发已读取的原始字节和剩余内容
+func ProcessShellResponse(input io.Reader, isCompressed bool, host string) ShellResult {
+	var raw bytes.Buffer
+	body, size, err := ProcessSmart(io.TeeReader(input, &raw), isCompressed, host)
+	if err != nil {
+		fmt.Printf("脚本无法改写，原样转发: %v\n", err)
+		return ShellResult{Body: io.MultiReader(&raw, input), Size: -1}
+	}
+	return ShellResult{Body: body, Size: size, Rewritten: true}
+}
+
+// AcceptsEncoding 判断Accept-Encoding是否接受指定编码，q=0视为拒绝
+func AcceptsEncoding(acceptEncoding, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		if q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func readShellContent(input io.Reader, isCompressed bool) ([]byte, error) {
 	var reader io.Reader = input
 
@@ -75,16 +123,34 @@ func readShellContent(input io.Reader, isCompressed bool) ([]byte, error) {
 
 func processGitHubURLs(content, host string) string {
 	return githubRegex.ReplaceAllStringFunc(content, func(match string) string {
-		// 如果匹配包含前缀分隔符，保留它，防止出现重复转换
-		if len(match) > 0 && match[0] != 'h' {
-			prefix := match[0:1]
-			url := match[1:]
-			return prefix + transformURL(url, host)
-		}
-		return transformURL(match, host)
+		return rewriteMatch(match, host)
 	})
 }
 
+// processDockerRepoURLs 改写Docker软件源地址，使安装脚本生成的apt/yum源和变量默认值经过代理；
+// 注释行以及keyserver、指纹相关的行保持不变
+func processDockerRepoURLs(content, host string) string {
+	lines := strings.SplitAfter(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") || strings.Contains(trimmed, "keyserver") || strings.Contains(strings.ToLower(trimmed), "fingerprint") {
+			continue
+		}
+		lines[i] = dockerRepoRegex.ReplaceAllStringFunc(line, func(match string) string {
+			return rewriteMatch(match, host)
+		})
+	}
+	return strings.Join(lines, "")
+}
+
+// rewriteMatch 转换正则匹配到的URL，如果匹配包含前缀分隔符，保留它，防止出现重复转换
+func rewriteMatch(match, host string) string {
+	if len(match) > 0 && match[0] != 'h' {
+		return match[0:1] + transformURL(match[1:], host)
+	}
+	return transformURL(match, host)
+}
+
 // transformURL URL转换函数
 func transformURL(url, host string) string {
 	if strings.Contains(url, host) {
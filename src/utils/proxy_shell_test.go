@@ -2,11 +2,16 @@ package utils
 
 import (
 	"compress/gzip"
+	"flag"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
+var updateGolden = flag.Bool("update", false, "更新testdata中的golden文件")
+
 func TestProcessSmartRewritesGitHubURLs(t *testing.T) {
 	input := `curl -L https://github.com/user/repo/releases/download/v1/file.sh`
 	reader, size, err := ProcessSmart(strings.NewReader(input), false, "proxy.example.com")
@@ -67,3 +72,99 @@ func TestReadShellContentGzip(t *testing.T) {
 		t.Fatalf("gzip content not rewritten: %q", buf.String())
 	}
 }
+
+func TestProcessShellResponseFallsBackToOriginalStream(t *testing.T) {
+	input := strings.Repeat("a", MaxShellSize+10)
+	result := ProcessShellResponse(strings.NewReader(input), false, "proxy.example.com")
+	if result.Rewritten || result.Size != -1 {
+		t.Fatalf("result = %+v, want fallback", result)
+	}
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != input {
+		t.Fatalf("fallback body length = %d, want %d", len(data), len(input))
+	}
+
+	corrupt := "\x1f\x8bnot really gzip"
+	result = ProcessShellResponse(strings.NewReader(corrupt), true, "proxy.example.com")
+	if data, _ := io.ReadAll(result.Body); result.Rewritten || string(data) != corrupt {
+		t.Fatalf("corrupt gzip result = %+v, body = %q", result, data)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br, *", true},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := AcceptsEncoding(tt.header, "gzip"); got != tt.want {
+			t.Errorf("AcceptsEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestProcessSmartDockerInstallScripts(t *testing.T) {
+	for _, name := range []string{"get-docker.sh", "docker-ce-repo.sh"} {
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, _, err := ProcessSmart(strings.NewReader(string(input)), false, "proxy.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Fatalf("processed %s does not match %s, run go test -update to regenerate", name, golden)
+			}
+		})
+	}
+}
+
+func TestProcessDockerRepoURLs(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"variable", `DOWNLOAD_URL="https://download.docker.com"`, `DOWNLOAD_URL="https://proxy.example.com/https://download.docker.com"`},
+		{"deb", "deb [arch=amd64] https://download.docker.com/linux/debian bookworm stable", "deb [arch=amd64] https://proxy.example.com/https://download.docker.com/linux/debian bookworm stable"},
+		{"baseurl", "baseurl=https://download.docker.com/linux/centos/9/x86_64/stable", "baseurl=https://proxy.example.com/https://download.docker.com/linux/centos/9/x86_64/stable"},
+		{"keyserver", "apt-key adv --keyserver https://download.docker.com/keys --recv-keys 0EBFCD88", "apt-key adv --keyserver https://download.docker.com/keys --recv-keys 0EBFCD88"},
+		{"comment", "# see https://download.docker.com/linux/", "# see https://download.docker.com/linux/"},
+		{"proxied", "deb https://proxy.example.com/https://download.docker.com/linux/ubuntu noble stable", "deb https://proxy.example.com/https://download.docker.com/linux/ubuntu noble stable"},
+		{"other host", "deb https://mirrors.aliyun.com/docker-ce/linux/ubuntu noble stable", "deb https://mirrors.aliyun.com/docker-ce/linux/ubuntu noble stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := processDockerRepoURLs(tt.input, "proxy.example.com"); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// QuotaWarningHeader 用量达到预警阈值时附加在成功响应上的提示头，可能出现多次
+const QuotaWarningHeader = "X-Quota-Warning"
+
+// 令牌配额种类，用于预警通知
+const (
+	QuotaHourlyRequests = "hourly_requests"
+	QuotaMonthlyBytes   = "monthly_bytes"
+)
+
+// QuotaWarningEvent 命名令牌越过预警阈值时POST到rateLimit.quotaWarnWebhook的事件
+type QuotaWarningEvent struct {
+	Event   string    `json:"event"`
+	Token   string    `json:"token"`
+	Quota   string    `json:"quota"`
+	Used    int64     `json:"used"`
+	Limit   int64     `json:"limit"`
+	Percent int       `json:"percent"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// quotaWarning 用量达到percent时返回提示文本，percent为0或未达到时返回空
+func quotaWarning(used, limit int64, percent int, label string, reset time.Duration) string {
+	if percent <= 0 || limit <= 0 {
+		return ""
+	}
+	usedPercent := int(used * 100 / limit)
+	if usedPercent < percent {
+		return ""
+	}
+	return fmt.Sprintf("%d%% of %s quota used, resets in %s", min(usedPercent, 100), label, formatQuotaReset(reset))
+}
+
+// formatQuotaReset 按分钟向上取整格式化剩余时间，如 12m、2h5m、3d4h
+func formatQuotaReset(d time.Duration) string {
+	minutes := int64(math.Ceil(d.Minutes()))
+	switch {
+	case minutes <= 0:
+		return "0m"
+	case minutes < 60:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes < 48*60:
+		if minutes%60 == 0 {
+			return fmt.Sprintf("%dh", minutes/60)
+		}
+		return fmt.Sprintf("%dh%dm", minutes/60, minutes%60)
+	}
+	hours := (minutes + 59) / 60
+	if hours%24 == 0 {
+		return fmt.Sprintf("%dd", hours/24)
+	}
+	return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+}
+
+// bucketPeriodLabel 按限流桶的容量和速率推算周期，返回如 hourly request、3-hour request
+func bucketPeriodLabel(limiter *rate.Limiter) string {
+	hours := math.Round(float64(limiter.Burst())/float64(limiter.Limit())/36) / 100
+	switch hours {
+	case 1:
+		return "hourly request"
+	case 24:
+		return "daily request"
+	}
+	return strconv.FormatFloat(hours, 'f', -1, 64) + "-hour request"
+}
+
+// bucketQuotaWarning 按限流桶在now时的令牌数计算已用额度，与 ratelimit.CallerStatus 的剩余额度一致
+func bucketQuotaWarning(limiter *rate.Limiter, now time.Time, percent int) string {
+	if percent <= 0 || limiter.Limit() == rate.Inf || limiter.Limit() <= 0 || limiter.Burst() <= 0 {
+		return ""
+	}
+	burst := float64(limiter.Burst())
+	tokens := limiter.TokensAt(now)
+	used := int64(math.Ceil(burst - max(tokens, 0)))
+	reset := time.Duration((burst - tokens) / float64(limiter.Limit()) * float64(time.Second))
+	return quotaWarning(used, int64(limiter.Burst()), percent, bucketPeriodLabel(limiter), reset)
+}
+
+// AddBucketQuotaWarning 限流中间件放行后按所在限流桶附加预警头
+func AddBucketQuotaWarning(c *gin.Context, limiter *rate.Limiter) {
+	if warning := bucketQuotaWarning(limiter, time.Now(), RequestConfig(c).RateLimit.QuotaWarnPercent); warning != "" {
+		c.Writer.Header().Add(QuotaWarningHeader, warning)
+	}
+}
+
+// QuotaWarnings 返回命名令牌每小时请求数和当月流量达到预警阈值的提示，
+// 每个周期首次越过阈值时经client向rateLimit.quotaWarnWebhook发送通知
+func (t *TokenStores) QuotaWarnings(client *http.Client, view TokenView, now time.Time) []string {
+	cfg := t.config.Get().RateLimit
+	percent := cfg.QuotaWarnPercent
+	if percent <= 0 {
+		return nil
+	}
+
+	var warnings []string
+	if view.HourlyRequests > 0 {
+		hour := now.Unix() / 3600
+		resetAt := time.Unix((hour+1)*3600, 0)
+		used, limit := int64(view.HourlyUsed), int64(view.HourlyRequests)
+		if warning := quotaWarning(used, limit, percent, "hourly request", resetAt.Sub(now)); warning != "" {
+			warnings = append(warnings, warning)
+			t.notifyQuotaWarning(client, cfg.QuotaWarnWebhook, strconv.FormatInt(hour, 10), QuotaWarningEvent{
+				Token: view.Name, Quota: QuotaHourlyRequests, Used: used, Limit: limit, ResetAt: resetAt,
+			})
+		}
+	}
+	if view.MonthlyBytes > 0 {
+		utc := now.UTC()
+		resetAt := time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if warning := quotaWarning(view.Usage.Bytes, view.MonthlyBytes, percent, "monthly byte", resetAt.Sub(now)); warning != "" {
+			warnings = append(warnings, warning)
+			t.notifyQuotaWarning(client, cfg.QuotaWarnWebhook, currentMonth(now), QuotaWarningEvent{
+				Token: view.Name, Quota: QuotaMonthlyBytes, Used: view.Usage.Bytes, Limit: view.MonthlyBytes, ResetAt: resetAt,
+			})
+		}
+	}
+	return warnings
+}
+
+// notifyQuotaWarning 同一令牌和配额种类在period内只通知一次，发送在后台进行，失败时只记录日志
+func (t *TokenStores) notifyQuotaWarning(client *http.Client, webhook, period string, event QuotaWarningEvent) {
+	if webhook == "" {
+		return
+	}
+	key := event.Token + "|" + event.Quota
+	if previous, loaded := t.notified.Swap(key, period); loaded && previous == period {
+		return
+	}
+
+	event.Event = "quota_warning"
+	event.Percent = int(event.Used * 100 / event.Limit)
+	go func() {
+		ctx, cancel := metadataContext(t.background.Context(), client)
+		defer cancel()
+		body, _ := json.Marshal(event)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+		if err != nil {
+			Logf(LogWarn, webhook, "额度预警通知发送失败: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusBadRequest {
+				err = fmt.Errorf("返回 %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			Logf(LogWarn, webhook, "额度预警通知发送失败: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestBucketQuotaWarningThreshold(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	limiter := rate.NewLimiter(rate.Limit(10.0/3600), 10)
+
+	limiter.AllowN(start, 7)
+	if got := bucketQuotaWarning(limiter, start, 80); got != "" {
+		t.Fatalf("70%% used: warning = %q", got)
+	}
+	limiter.AllowN(start, 1)
+	if got := bucketQuotaWarning(limiter, start, 80); got != "80% of hourly request quota used, resets in 48m" {
+		t.Fatalf("80%% used: warning = %q", got)
+	}
+	if got := bucketQuotaWarning(limiter, start, 0); got != "" {
+		t.Fatalf("disabled: warning = %q", got)
+	}
+
+	// 每6分钟回填一个请求，回填前仍按已用8个计算
+	if got := bucketQuotaWarning(limiter, start.Add(6*time.Minute-time.Second), 80); got == "" {
+		t.Fatal("warning cleared before a request was refilled")
+	}
+	if got := bucketQuotaWarning(limiter, start.Add(6*time.Minute), 80); got != "" {
+		t.Fatalf("after refill: warning = %q", got)
+	}
+
+	if got := bucketQuotaWarning(rate.NewLimiter(rate.Inf, 10), start, 1); got != "" {
+		t.Fatalf("unlimited: warning = %q", got)
+	}
+	crawler := rate.NewLimiter(rate.Limit(10.0/(3*3600)), 10)
+	crawler.AllowN(start, 10)
+	if got := bucketQuotaWarning(crawler, start, 80); got != "100% of 3-hour request quota used, resets in 3h" {
+		t.Fatalf("3-hour bucket: warning = %q", got)
+	}
+}
+
+func TestFormatQuotaReset(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                             "0m",
+		30 * time.Second:              "1m",
+		12 * time.Minute:              "12m",
+		2*time.Hour + 5*time.Minute:   "2h5m",
+		47 * time.Hour:                "47h",
+		3*24*time.Hour + 4*time.Hour:  "3d4h",
+		3*24*time.Hour + time.Minute:  "3d1h",
+		10*24*time.Hour - time.Minute: "10d",
+	}
+	for d, want := range tests {
+		if got := formatQuotaReset(d); got != want {
+			t.Errorf("formatQuotaReset(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestQuotaWarningsNotifyOncePerPeriod(t *testing.T) {
+	events := make(chan QuotaWarningEvent, 8)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event QuotaWarningEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+	loadTestConfig(t, `
+[rateLimit]
+quotaWarnPercent = 80
+quotaWarnWebhook = "`+webhook.URL+`"
+`)
+	tokens := NewTokenStores(testStore(), NewBackground())
+	client := NewHTTPClients(testStore(), NewStatsRegistry()).Metadata()
+
+	view := TokenView{Name: "ci", HourlyRequests: 10, HourlyUsed: 7, MonthlyBytes: 1000}
+	view.Usage.Bytes = 500
+	now := time.Date(2026, 1, 31, 23, 59, 30, 0, time.UTC)
+	if got := tokens.QuotaWarnings(client, view, now); len(got) != 0 {
+		t.Fatalf("below threshold: %q", got)
+	}
+
+	view.HourlyUsed = 9
+	view.Usage.Bytes = 850
+	got := tokens.QuotaWarnings(client, view, now)
+	if len(got) != 2 || got[0] != "90% of hourly request quota used, resets in 1m" || got[1] != "85% of monthly byte quota used, resets in 1m" {
+		t.Fatalf("warnings = %q", got)
+	}
+	tokens.QuotaWarnings(client, view, now.Add(10*time.Second))
+
+	received := map[string]QuotaWarningEvent{}
+	for range 2 {
+		select {
+		case event := <-events:
+			received[event.Quota] = event
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook not called")
+		}
+	}
+	if e := received[QuotaHourlyRequests]; e.Event != "quota_warning" || e.Token != "ci" || e.Used != 9 || e.Percent != 90 ||
+		!e.ResetAt.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("hourly event = %+v", e)
+	}
+	if e := received[QuotaMonthlyBytes]; e.Used != 850 || e.Limit != 1000 || e.Percent != 85 {
+		t.Errorf("monthly event = %+v", e)
+	}
+
+	// 跨过周期边界后用量仍超过阈值时再次通知
+	tokens.QuotaWarnings(client, view, now.Add(time.Minute))
+	for range 2 {
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification after reset boundary")
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected extra event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// RedactURL 返回主机和路径，查询参数只保留名称，避免泄露签名URL中的凭据
+func RedactURL(u *url.URL) string {
+	redacted := u.Host + u.EscapedPath()
+	if u.RawQuery == "" {
+		return redacted
+	}
+	names := make([]string, 0)
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if name, _, _ := strings.Cut(part, "="); name != "" {
+			names = append(names, name)
+		}
+	}
+	return redacted + "?" + strings.Join(names, "&")
+}
+
+// RedirectError 重定向超出次数上限或出现循环，URL已去除查询参数的取值
+type RedirectError struct {
+	URL   string
+	Loop  bool
+	Limit int
+}
+
+func (e *RedirectError) Error() string {
+	if e.Loop {
+		return fmt.Sprintf("检测到循环重定向: %s", e.URL)
+	}
+	return fmt.Sprintf("重定向次数超过%d次: %s", e.Limit, e.URL)
+}
+
+// MaxRedirects 返回cfg中单个请求允许跟随的重定向次数
+func MaxRedirects(cfg *config.AppConfig) int {
+	if limit := cfg.Proxy.MaxRedirects; limit > 0 {
+		return limit
+	}
+	return 10
+}
+
+// RedirectTracker 记录一条请求链上访问过的地址，HTTP客户端内部跟随和代理自行跟随的重定向共用一个计数
+type RedirectTracker struct {
+	mu      sync.Mutex
+	limit   int
+	count   int
+	visited map[string]bool
+}
+
+// NewRedirectTracker 从start开始跟踪重定向
+func NewRedirectTracker(start *url.URL, limit int) *RedirectTracker {
+	return &RedirectTracker{limit: limit, visited: map[string]bool{start.String(): true}}
+}
+
+// Visit 记录一次重定向到u，地址已访问过或次数超过上限时返回*RedirectError
+func (t *RedirectTracker) Visit(u *url.URL) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := u.String()
+	if t.visited[key] {
+		return &RedirectError{URL: RedactURL(u), Loop: true, Limit: t.limit}
+	}
+	t.count++
+	if t.count > t.limit {
+		return &RedirectError{URL: RedactURL(u), Limit: t.limit}
+	}
+	t.visited[key] = true
+	return nil
+}
+
+type redirectTrackerKey struct{}
+
+// WithRedirectTracker 返回携带重定向跟踪器的context，HTTP客户端跟随重定向时使用它检查
+func WithRedirectTracker(ctx context.Context, tracker *RedirectTracker) context.Context {
+	return context.WithValue(ctx, redirectTrackerKey{}, tracker)
+}
+
+// redirectPolicy 返回HTTP客户端的重定向策略：按来源主机计入stats，请求携带跟踪器时检查循环，
+// 否则按store中的配置限制次数
+func redirectPolicy(store *config.Store, stats *StatsRegistry) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		return checkRedirect(store.Get(), stats, req, via)
+	}
+}
+
+func checkRedirect(cfg *config.AppConfig, stats *StatsRegistry, req *http.Request, via []*http.Request) error {
+	stats.RecordRedirect(via[len(via)-1].URL.Host)
+	if tracker, ok := req.Context().Value(redirectTrackerKey{}).(*RedirectTracker); ok {
+		return tracker.Visit(req.URL)
+	}
+	if limit := MaxRedirects(cfg); len(via) > limit {
+		return &RedirectError{URL: RedactURL(req.URL), Limit: limit}
+	}
+	return nil
+}
+
+// signedURLParams 对象存储预签名URL的签名参数(小写)：S3、GCS、CloudFront和Azure SAS
+var signedURLParams = []string{"x-amz-signature", "x-goog-signature", "signature", "sig"}
+
+// HasURLSignature 判断URL是否自带签名参数，此类地址自身已包含授权
+func HasURLSignature(u *url.URL) bool {
+	for name := range u.Query() {
+		for _, param := range signedURLParams {
+			if strings.EqualFold(name, param) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redirectOrigin 返回重定向链上第一个请求的主机，req不是重定向产生的请求时返回空串
+func redirectOrigin(req *http.Request) string {
+	origin := ""
+	for resp := req.Response; resp != nil && resp.Request != nil; resp = resp.Request.Response {
+		origin = resp.Request.URL.Hostname()
+	}
+	return origin
+}
+
+// IsSignedRedirect 判断req是否为跨主机重定向到预签名地址，如ghcr.io的blob重定向到
+// pkg-containers.githubusercontent.com或Azure/S3存储
+func IsSignedRedirect(req *http.Request) bool {
+	origin := redirectOrigin(req)
+	return origin != "" && !strings.EqualFold(origin, req.URL.Hostname()) && HasURLSignature(req.URL)
+}
+
+// signedRedirectTransport 重定向到其他主机的预签名地址时去掉Authorization，
+// registry的令牌不发给存储服务(部分存储前端收到多余的令牌会返回400)，Range等其他请求头保持不变。
+// 位于请求头改写规则之后，按配置添加的凭据同样不会发往存储服务
+type signedRedirectTransport struct {
+	base http.RoundTripper
+}
+
+func (t *signedRedirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" || !IsSignedRedirect(req) {
+		return t.base.RoundTrip(req)
+	}
+	clean := req.Clone(req.Context())
+	clean.Header.Del("Authorization")
+	return t.base.RoundTrip(clean)
+}
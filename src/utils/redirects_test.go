@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirectTracker(t *testing.T) {
+	parse := func(raw string) *url.URL {
+		u, _ := url.Parse(raw)
+		return u
+	}
+	tracker := NewRedirectTracker(parse("https://github.com/o/r/releases/download/v1/a"), 2)
+
+	if err := tracker.Visit(parse("https://objects.githubusercontent.com/x?sig=1")); err != nil {
+		t.Fatal(err)
+	}
+	var redirectErr *RedirectError
+	err := tracker.Visit(parse("https://github.com/o/r/releases/download/v1/a"))
+	if !errors.As(err, &redirectErr) || !redirectErr.Loop || redirectErr.URL != "github.com/o/r/releases/download/v1/a" {
+		t.Fatalf("revisit = %v", err)
+	}
+	if err := tracker.Visit(parse("https://cdn.example.com/2")); err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.Visit(parse("https://cdn.example.com/3?token=secret"))
+	if !errors.As(err, &redirectErr) || redirectErr.Loop || redirectErr.URL != "cdn.example.com/3?token" {
+		t.Fatalf("over limit = %v", err)
+	}
+}
+
+func TestHasURLSignature(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://pkg-containers.githubusercontent.com/ghcr1/blobs/sha256:ab?se=2024&sig=abc&sp=r&sv=2019-12-12": true,
+		"https://bucket.s3.amazonaws.com/blob?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc":             true,
+		"https://storage.googleapis.com/bucket/blob?X-Goog-Signature=abc":                                       true,
+		"https://d111111abcdef8.cloudfront.net/blob?Expires=1&Signature=abc&Key-Pair-Id=K":                      true,
+		"https://objects.example.com/blob?token=abc":                                                            false,
+		"https://ghcr.io/v2/owner/app/blobs/sha256:ab":                                                          false,
+	} {
+		u, _ := url.Parse(raw)
+		if got := HasURLSignature(u); got != want {
+			t.Errorf("HasURLSignature(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestSignedRedirectDropsAuthorizationKeepsRange(t *testing.T) {
+	// 通配规则为所有上游添加凭据，只有重定向到其他主机的预签名地址时去掉
+	loadTestConfig(t, "[upstream.headers.hosts.\"*\"]\nallowSensitive = true\nset = { Authorization = \"Bearer configured\" }\n")
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+
+	type seen struct{ auth, rng string }
+	var storage []seen
+	storageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storage = append(storage, seen{r.Header.Get("Authorization"), r.Header.Get("Range")})
+		w.Write([]byte("ok"))
+	}))
+	defer storageServer.Close()
+	// 通过localhost访问源站、127.0.0.1访问存储服务，二者主机名不同
+	storageURL := storageServer.URL
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, storageURL+"/blob?"+r.URL.RawQuery, http.StatusTemporaryRedirect)
+	}))
+	defer origin.Close()
+	originURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1)
+
+	for _, query := range []string{"se=2024&sig=abc", "token=abc"} {
+		req, _ := http.NewRequest(http.MethodGet, originURL+"/v2/blob?"+query, nil)
+		req.Header.Set("Range", "bytes=100-")
+		resp, err := clients.Global().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(storage) != 2 {
+		t.Fatalf("storage requests = %+v", storage)
+	}
+	if storage[0] != (seen{"", "bytes=100-"}) {
+		t.Fatalf("signed redirect headers = %+v", storage[0])
+	}
+	if storage[1] != (seen{"Bearer configured", "bytes=100-"}) {
+		t.Fatalf("unsigned redirect headers = %+v", storage[1])
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// matchHostPattern 主机名匹配，支持 *.example.com 形式的子域名通配
+func matchHostPattern(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// RefererAllowed 按cfg检查Referer是否允许，本站页面始终允许
+func RefererAllowed(appCfg *config.AppConfig, referer, selfHost string) bool {
+	cfg := appCfg.Security.Referer
+	if !cfg.Enabled || len(cfg.Allowed) == 0 {
+		return true
+	}
+	if referer == "" {
+		return cfg.AllowEmpty
+	}
+
+	parsed, err := url.Parse(referer)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	host := parsed.Hostname()
+	if selfHostname, _, err := net.SplitHostPort(selfHost); err == nil {
+		selfHost = selfHostname
+	}
+	if strings.EqualFold(host, selfHost) {
+		return true
+	}
+
+	for _, pattern := range cfg.Allowed {
+		if matchHostPattern(host, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// RefererMiddleware 按store中的当前配置检查防盗链的中间件，用于GitHub文件代理路由
+func RefererMiddleware(store *config.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		referer := c.GetHeader("Referer")
+		if !RefererAllowed(store.Get(), referer, c.Request.Host) {
+			fmt.Printf("来源 %s 不在防盗链白名单内\n", referer)
+			RespondError(c, http.StatusForbidden, ErrCodeRefererBlocked)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefererMiddleware(t *testing.T) {
+	loadTestConfig(t, `
+[security.referer]
+enabled = true
+allowed = ["blog.example.com", "*.docs.example.org"]
+allowEmpty = true
+`)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.NoRoute(RefererMiddleware(testStore()), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	tests := []struct {
+		referer string
+		want    int
+	}{
+		{"", http.StatusOK},
+		{"https://blog.example.com/post/1", http.StatusOK},
+		{"https://v2.docs.example.org/guide", http.StatusOK},
+		{"http://proxy.local:5000/", http.StatusOK},
+		{"https://docs.example.org/", http.StatusForbidden},
+		{"https://cdn-abuser.example/page", http.StatusForbidden},
+		{"not a url", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://proxy.local:5000/https://github.com/o/r/releases/download/v1/a.tar.gz", nil)
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("referer %q: status = %d, want %d", tt.referer, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRefererAllowedToggles(t *testing.T) {
+	loadTestConfig(t, `
+[security.referer]
+enabled = false
+allowed = ["blog.example.com"]
+`)
+	if !RefererAllowed(config.GetConfig(), "https://other.example/", "proxy.local") {
+		t.Fatal("referer check applied while disabled")
+	}
+
+	loadTestConfig(t, `
+[security.referer]
+enabled = true
+allowEmpty = false
+`)
+	if !RefererAllowed(config.GetConfig(), "https://other.example/", "proxy.local") {
+		t.Fatal("empty allow list should allow all referers")
+	}
+
+	loadTestConfig(t, `
+[security.referer]
+enabled = true
+allowed = ["blog.example.com"]
+allowEmpty = false
+`)
+	if RefererAllowed(config.GetConfig(), "", "proxy.local") {
+		t.Fatal("empty referer allowed with allowEmpty = false")
+	}
+}
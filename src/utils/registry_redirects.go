@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// maxBlobBackends 缓存的blob后端地址条目上限，超出时先清理过期条目，仍超出则清空
+const maxBlobBackends = 4096
+
+// RedirectHostError Registry的重定向指向了registries.*.redirectHosts之外的主机
+type RedirectHostError struct {
+	Registry string
+	Host     string
+}
+
+func (e *RedirectHostError) Error() string {
+	return fmt.Sprintf("%s 重定向到未允许的主机: %s", e.Registry, e.Host)
+}
+
+// MatchRedirectHost 判断主机是否匹配redirectHosts中的某一项，支持*通配，不区分大小写
+func MatchRedirectHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// registryForUpstreamHost 返回cfg中上游主机对应的Registry配置，host可带端口
+func registryForUpstreamHost(cfg *config.AppConfig, host string) (config.RegistryMapping, bool) {
+	for _, mapping := range cfg.Registries {
+		upstream, _, _ := strings.Cut(mapping.Upstream, "/")
+		if mapping.Enabled && strings.EqualFold(upstream, host) {
+			return mapping, true
+		}
+	}
+	return config.RegistryMapping{}, false
+}
+
+// blobBackend 已解析的blob后端地址
+type blobBackend struct {
+	url     string
+	expires time.Time
+}
+
+// blobBackendCache 按上游主机和digest缓存的blob后端地址
+type blobBackendCache struct {
+	mu      sync.Mutex
+	entries map[string]blobBackend
+}
+
+// RegistryBlobBackend 返回上游主机的blob最近一次重定向到的后端地址，未缓存或已过期时返回false
+func (h *HTTPClients) RegistryBlobBackend(host, digest string) (string, bool) {
+	cache := &h.policies.blobBackends
+	key := strings.ToLower(host) + "@" + digest
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(cache.entries, key)
+		return "", false
+	}
+	return entry.url, true
+}
+
+// ForgetRegistryBlobBackend 删除缓存的blob后端地址，后端请求失败或内容校验不通过时调用
+func (h *HTTPClients) ForgetRegistryBlobBackend(host, digest string) {
+	cache := &h.policies.blobBackends
+	cache.mu.Lock()
+	delete(cache.entries, strings.ToLower(host)+"@"+digest)
+	cache.mu.Unlock()
+}
+
+// remember 缓存blob的后端地址ttl时长
+func (c *blobBackendCache) remember(host, digest, url string, ttl time.Duration) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxBlobBackends {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if c.entries == nil || len(c.entries) >= maxBlobBackends {
+		c.entries = make(map[string]blobBackend)
+	}
+	c.entries[strings.ToLower(host)+"@"+digest] = blobBackend{url: url, expires: now.Add(ttl)}
+}
+
+// firstRedirectRequest 返回重定向链上的第一个请求
+func firstRedirectRequest(req *http.Request) *http.Request {
+	first := req
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+	return first
+}
+
+// redirectBlobDigest 返回重定向链第一个请求的blob digest，不是blob请求时返回空串
+func redirectBlobDigest(req *http.Request) string {
+	_, digest, found := strings.Cut(firstRedirectRequest(req).URL.Path, "/blobs/")
+	if !found || !strings.HasPrefix(digest, "sha256:") {
+		return ""
+	}
+	return digest
+}
+
+// registryRedirectTransport 按registries.*.redirectHosts跟随Registry的重定向，如registry.k8s.io到各地区存储后端：
+// 目标不在列表中时拒绝，发往后端的请求去掉Authorization，Range等其他请求头保持不变；
+// 配置了backendCacheTTL时记住blob最终所在的后端地址
+type registryRedirectTransport struct {
+	base     http.RoundTripper
+	config   *config.Store
+	policies *upstreamPolicies
+}
+
+func (t *registryRedirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	first := firstRedirectRequest(req)
+	if first == req || strings.EqualFold(first.URL.Hostname(), req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	origin := first.URL.Host
+	mapping, ok := registryForUpstreamHost(t.config.Get(), origin)
+	if !ok || len(mapping.RedirectHosts) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	if !MatchRedirectHost(mapping.RedirectHosts, req.URL.Hostname()) {
+		Logf(LogWarn, "redirect "+origin, "警告: %s 重定向到未允许的主机 %s，已拒绝", origin, RedactURL(req.URL))
+		return nil, &RedirectHostError{Registry: origin, Host: req.URL.Hostname()}
+	}
+	if req.Header.Get("Authorization") != "" {
+		req = req.Clone(req.Context())
+		req.Header.Del("Authorization")
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return resp, err
+	}
+	if ttl := ParseTimeout(mapping.BackendCacheTTL, 0); ttl > 0 {
+		if digest := redirectBlobDigest(req); digest != "" {
+			t.policies.blobBackends.remember(origin, digest, req.URL.String(), ttl)
+		}
+	}
+	return resp, nil
+}
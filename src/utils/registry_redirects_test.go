@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchRedirectHost(t *testing.T) {
+	patterns := []string{"*.s3.dualstack.*.amazonaws.com", "*.pkg.dev", "storage.googleapis.com"}
+	tests := map[string]bool{
+		"prod-registry-k8s-io-us-east-2.s3.dualstack.us-east-2.amazonaws.com": true,
+		"us-central1-docker.pkg.dev":                                          true,
+		"STORAGE.googleapis.com":                                              true,
+		"pkg.dev":                                                             false,
+		"evil.example":                                                        false,
+		"bucket.s3.amazonaws.com":                                             false,
+	}
+	for host, want := range tests {
+		if got := MatchRedirectHost(patterns, host); got != want {
+			t.Errorf("MatchRedirectHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestRegistryRedirectAllowlist(t *testing.T) {
+	type seen struct{ auth, rng string }
+	var backend []seen
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend = append(backend, seen{r.Header.Get("Authorization"), r.Header.Get("Range")})
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+	// 通过127.0.0.1访问Registry、localhost访问后端，二者主机名不同
+	backendURL := strings.Replace(backendServer.URL, "127.0.0.1", "localhost", 1)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "untrusted") {
+			http.Redirect(w, r, "http://untrusted.example/blob", http.StatusTemporaryRedirect)
+			return
+		}
+		http.Redirect(w, r, backendURL+"/containers/images/x", http.StatusTemporaryRedirect)
+	}))
+	defer registry.Close()
+	upstream := strings.TrimPrefix(registry.URL, "http://")
+
+	// 通配规则为所有上游添加凭据，发往后端的请求去掉
+	loadTestConfig(t, `
+[upstream.headers.hosts."*"]
+allowSensitive = true
+set = { Authorization = "Bearer configured" }
+
+[registries."registry.k8s.io"]
+upstream = "`+upstream+`"
+enabled = true
+redirectHosts = ["localhost"]
+backendCacheTTL = "1m"
+`)
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	req, _ := http.NewRequest(http.MethodGet, registry.URL+"/v2/pause/blobs/"+digest, nil)
+	req.Header.Set("Range", "bytes=100-")
+	resp, err := clients.Global().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(backend) != 1 || backend[0] != (seen{"", "bytes=100-"}) {
+		t.Fatalf("backend requests = %+v", backend)
+	}
+	if got, ok := clients.RegistryBlobBackend(upstream, digest); !ok || got != backendURL+"/containers/images/x" {
+		t.Fatalf("remembered backend = %q, %v", got, ok)
+	}
+
+	_, err = clients.Global().Get(registry.URL + "/v2/pause/blobs/untrusted")
+	var hostErr *RedirectHostError
+	if !errors.As(err, &hostErr) || hostErr.Host != "untrusted.example" {
+		t.Fatalf("untrusted redirect error = %v", err)
+	}
+}
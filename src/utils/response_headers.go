@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// ResponseHeaderPolicy 转发给客户端的上游响应头处理规则，keep中的头不会被strip删除
+type ResponseHeaderPolicy struct {
+	strip []string
+	set   map[string]string
+}
+
+// CompileResponseHeaderPolicy 根据[proxy.responseHeaders]生成响应头处理规则，头名称不区分大小写
+func CompileResponseHeaderPolicy(cfg *config.AppConfig) *ResponseHeaderPolicy {
+	rules := cfg.Proxy.ResponseHeaders
+	keep := make(map[string]bool, len(rules.Keep))
+	for _, key := range rules.Keep {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" {
+			keep[key] = true
+		}
+	}
+
+	policy := &ResponseHeaderPolicy{set: make(map[string]string, len(rules.Set))}
+	for _, key := range rules.Strip {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" && !keep[key] {
+			policy.strip = append(policy.strip, key)
+		}
+	}
+	for key, value := range rules.Set {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" {
+			policy.set[key] = value
+		}
+	}
+	return policy
+}
+
+// Apply 删除strip中未被keep保留的头，再写入set中的固定值
+func (p *ResponseHeaderPolicy) Apply(header http.Header) {
+	for _, key := range p.strip {
+		header.Del(key)
+	}
+	for key, value := range p.set {
+		header.Set(key, value)
+	}
+}
+
+// ApplyResponseHeaderPolicy 对转发给客户端的上游响应头应用当前规则
+func (h *HTTPClients) ApplyResponseHeaderPolicy(header http.Header) {
+	h.policies.responses.Load().Apply(header)
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestResponseHeaderPolicy(t *testing.T) {
+	upstream := func() http.Header {
+		return http.Header{
+			"Content-Security-Policy":   {"default-src 'self'"},
+			"Referrer-Policy":           {"no-referrer"},
+			"Strict-Transport-Security": {"max-age=31536000"},
+			"Set-Cookie":                {"_gh_sess=abc", "logged_in=no"},
+			"Content-Type":              {"text/plain"},
+		}
+	}
+
+	// 默认规则与原先固定删除的三个安全头一致
+	loadTestConfig(t, "")
+	header := upstream()
+	CompileResponseHeaderPolicy(config.GetConfig()).Apply(header)
+	if len(header) != 2 || len(header.Values("Set-Cookie")) != 2 || header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("default policy = %v", header)
+	}
+
+	// 名称不区分大小写，keep优先于strip，set覆盖上游的值
+	loadTestConfig(t, `
+[proxy.responseHeaders]
+strip = ["set-cookie", "CONTENT-SECURITY-POLICY", " referrer-policy "]
+keep = ["content-security-policy"]
+
+[proxy.responseHeaders.set]
+x-proxied-by = "hubproxy"
+content-type = "application/octet-stream"
+`)
+	header = upstream()
+	CompileResponseHeaderPolicy(config.GetConfig()).Apply(header)
+	if header.Get("Set-Cookie") != "" || header.Get("Referrer-Policy") != "" {
+		t.Fatalf("stripped headers kept: %v", header)
+	}
+	if header.Get("Content-Security-Policy") == "" || header.Get("Strict-Transport-Security") == "" {
+		t.Fatalf("kept headers removed: %v", header)
+	}
+	if header.Get("X-Proxied-By") != "hubproxy" || header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("set headers = %v", header)
+	}
+}
@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduleLocation 返回cfg中重任务调度使用的时区，未单独配置时与server.timezone相同
+func ScheduleLocation(cfg *config.AppConfig) *time.Location {
+	if strings.TrimSpace(cfg.Schedule.Timezone) == "" {
+		return DisplayLocation(cfg)
+	}
+	return cachedLocation(cfg.Schedule.Timezone)
+}
+
+// FormatScheduleTime 按cfg的调度时区格式化时间
+func FormatScheduleTime(cfg *config.AppConfig, t time.Time) string {
+	return t.In(ScheduleLocation(cfg)).Format(DisplayTimeLayout)
+}
+
+// timeWindow 一天内的时段，单位为分钟，end小于start时表示跨过零点
+type timeWindow struct {
+	start int
+	end   int
+}
+
+// parseTimeWindow 解析 "HH:MM-HH:MM" 格式的时段
+func parseTimeWindow(value string) (timeWindow, error) {
+	startText, endText, found := strings.Cut(strings.TrimSpace(value), "-")
+	if !found {
+		return timeWindow{}, fmt.Errorf("时段格式应为 HH:MM-HH:MM: %s", value)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startText))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("时段起点无效: %s", value)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endText))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("时段终点无效: %s", value)
+	}
+	return timeWindow{start: start.Hour()*60 + start.Minute(), end: end.Hour()*60 + end.Minute()}, nil
+}
+
+func (w timeWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// scheduleWindows 解析配置的时段，无效的时段被忽略
+func scheduleWindows(values []string) []timeWindow {
+	windows := make([]timeWindow, 0, len(values))
+	for _, value := range values {
+		window, err := parseTimeWindow(value)
+		if err != nil {
+			fmt.Printf("忽略无效的任务时段: %v\n", err)
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// nextWindowTime 返回不早于t且处于任一时段内的最早时间，没有时段时不限制
+func nextWindowTime(t time.Time, windows []timeWindow) time.Time {
+	if len(windows) == 0 {
+		return t
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range windows {
+		if window.contains(minute) {
+			return t
+		}
+	}
+
+	var next time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, window := range windows {
+		start := midnight.Add(time.Duration(window.start) * time.Minute)
+		if !start.After(t) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// budgetPeriodStart 返回t所在预算周期的起点，周期在每天resetHour点重置
+func budgetPeriodStart(t time.Time, resetHour int) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), resetHour, 0, 0, 0, t.Location())
+	if t.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// ScheduleError 重任务被时段或预算拒绝，NextAllowed为下次允许执行的时间
+type ScheduleError struct {
+	Code        string
+	NextAllowed time.Time
+}
+
+func (e *ScheduleError) Error() string {
+	return fmt.Sprintf("%s，下次允许时间 %s", e.Code, e.NextAllowed.Format(time.RFC3339))
+}
+
+// ScheduleStats 重任务调度状态
+type ScheduleStats struct {
+	Timezone          string    `json:"timezone"`
+	QuietHours        []string  `json:"quiet_hours"`
+	InWindow          bool      `json:"in_window"`
+	BudgetBytes       int64     `json:"budget_bytes"`
+	UsedBytes         int64     `json:"used_bytes"`
+	RemainingBytes    int64     `json:"remaining_bytes"`
+	ResetAt           time.Time `json:"reset_at"`
+	ResetAtUnix       int64     `json:"reset_at_unix"`
+	NextAllowedAt     time.Time `json:"next_allowed_at"`
+	NextAllowedAtUnix int64     `json:"next_allowed_at_unix"`
+}
+
+// HeavySchedule 重任务(离线镜像下载、缓存预热、多连接加速下载)的执行时段和每日流量预算
+// 预算用量只保存在内存中，重启后从零开始
+type HeavySchedule struct {
+	config *config.Store
+	mu     sync.Mutex
+	period time.Time
+	used   int64
+}
+
+// NewHeavySchedule 创建按store中的配置检查时段和预算的重任务调度
+func NewHeavySchedule(store *config.Store) *HeavySchedule {
+	return &HeavySchedule{config: store}
+}
+
+// rollLocked 进入新的预算周期时清零用量，调用方需持有锁
+func (s *HeavySchedule) rollLocked(period time.Time) {
+	if !s.period.Equal(period) {
+		s.period = period
+		s.used = 0
+	}
+}
+
+// AddBytes 将重任务从上游读取的字节数计入当前周期的预算
+func (s *HeavySchedule) AddBytes(n int64) {
+	cfg := s.config.Get()
+	if !cfg.Schedule.Enabled || n <= 0 {
+		return
+	}
+	now := time.Now().In(ScheduleLocation(cfg))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollLocked(budgetPeriodStart(now, cfg.Schedule.ResetHour))
+	s.used += n
+}
+
+// Check 检查当前是否允许开始新的重任务，不允许时返回*ScheduleError
+func (s *HeavySchedule) Check() error {
+	return s.checkAt(time.Now())
+}
+
+// heavyOperationKey 请求上下文中标记重任务的key，重任务的上游流量计入每日预算
+const heavyOperationKey = "heavy_operation"
+
+// Allow 检查当前是否允许开始重任务，允许时标记请求
+func (s *HeavySchedule) Allow(c *gin.Context) error {
+	if err := s.Check(); err != nil {
+		return err
+	}
+	c.Set(heavyOperationKey, true)
+	return nil
+}
+
+// RecordRequest 将重任务请求的上游流量计入每日预算
+func (s *HeavySchedule) RecordRequest(c *gin.Context, upstream int64) {
+	if c.GetBool(heavyOperationKey) {
+		s.AddBytes(upstream)
+	}
+}
+
+func (s *HeavySchedule) checkAt(now time.Time) error {
+	cfg := s.config.Get()
+	if !cfg.Schedule.Enabled {
+		return nil
+	}
+	stats := s.snapshotAt(now, cfg)
+	if !stats.NextAllowedAt.After(now) {
+		return nil
+	}
+	code := ErrCodeOutsideSchedule
+	if stats.BudgetBytes > 0 && stats.RemainingBytes == 0 {
+		code = ErrCodeBudgetExhausted
+	}
+	return &ScheduleError{Code: code, NextAllowed: stats.NextAllowedAt}
+}
+
+// Snapshot 返回当前调度状态，未启用时返回nil
+func (s *HeavySchedule) Snapshot() *ScheduleStats {
+	cfg := s.config.Get()
+	if !cfg.Schedule.Enabled {
+		return nil
+	}
+	stats := s.snapshotAt(time.Now(), cfg)
+	return &stats
+}
+
+func (s *HeavySchedule) snapshotAt(now time.Time, cfg *config.AppConfig) ScheduleStats {
+	now = now.In(ScheduleLocation(cfg))
+	windows := scheduleWindows(cfg.Schedule.QuietHours)
+	period := budgetPeriodStart(now, cfg.Schedule.ResetHour)
+
+	s.mu.Lock()
+	s.rollLocked(period)
+	used := s.used
+	s.mu.Unlock()
+
+	stats := ScheduleStats{
+		Timezone:      now.Location().String(),
+		QuietHours:    append([]string(nil), cfg.Schedule.QuietHours...),
+		InWindow:      nextWindowTime(now, windows).Equal(now),
+		BudgetBytes:   cfg.Schedule.DailyBudgetBytes,
+		UsedBytes:     used,
+		ResetAt:       period.AddDate(0, 0, 1),
+		NextAllowedAt: nextWindowTime(now, windows),
+	}
+	if stats.BudgetBytes > 0 {
+		stats.RemainingBytes = max(stats.BudgetBytes-used, 0)
+		if stats.RemainingBytes == 0 {
+			stats.NextAllowedAt = nextWindowTime(stats.ResetAt, windows)
+		}
+	}
+	stats.ResetAtUnix = stats.ResetAt.Unix()
+	stats.NextAllowedAtUnix = stats.NextAllowedAt.Unix()
+	return stats
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestNextWindowTime(t *testing.T) {
+	loc := cachedLocation("Asia/Shanghai")
+	windows := scheduleWindows([]string{"23:00-06:00", "12:00-13:00", "bad"})
+	if len(windows) != 2 {
+		t.Fatalf("windows = %d, want 2", len(windows))
+	}
+
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, loc)
+	}
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{at(16, 2, 30), at(16, 2, 30)},
+		{at(16, 23, 0), at(16, 23, 0)},
+		{at(16, 6, 0), at(16, 12, 0)},
+		{at(16, 12, 59), at(16, 12, 59)},
+		{at(16, 13, 0), at(16, 23, 0)},
+	}
+	for _, tt := range tests {
+		if got := nextWindowTime(tt.now, windows); !got.Equal(tt.want) {
+			t.Errorf("nextWindowTime(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+	if now := at(16, 9, 0); !nextWindowTime(now, nil).Equal(now) {
+		t.Error("no windows should allow any time")
+	}
+}
+
+func TestBudgetPeriodStart(t *testing.T) {
+	loc := time.UTC
+	if got := budgetPeriodStart(time.Date(2026, 10, 16, 3, 0, 0, 0, loc), 4); !got.Equal(time.Date(2026, 10, 15, 4, 0, 0, 0, loc)) {
+		t.Fatalf("before reset hour: %s", got)
+	}
+	if got := budgetPeriodStart(time.Date(2026, 10, 16, 4, 0, 0, 0, loc), 4); !got.Equal(time.Date(2026, 10, 16, 4, 0, 0, 0, loc)) {
+		t.Fatalf("at reset hour: %s", got)
+	}
+}
+
+func TestScheduleLocationFallback(t *testing.T) {
+	loadTestConfig(t, "[server]\ntimezone = \"America/Sao_Paulo\"\n")
+	cfg := *config.GetConfig()
+	if got := ScheduleLocation(&cfg); got.String() != "America/Sao_Paulo" {
+		t.Fatalf("empty zone = %s, want server.timezone", got)
+	}
+	cfg.Schedule.Timezone = "Not/AZone"
+	if got := ScheduleLocation(&cfg); got != time.UTC {
+		t.Fatalf("invalid zone = %s, want UTC", got)
+	}
+	cfg.Schedule.Timezone = "America/New_York"
+	if got := ScheduleLocation(&cfg); got.String() != "America/New_York" {
+		t.Fatalf("zone = %s", got)
+	}
+}
+
+func TestHeavyScheduleBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[schedule]
+enabled = true
+timezone = "UTC"
+quietHours = ["00:00-06:00"]
+dailyBudgetBytes = 100
+resetHour = 2
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := NewHeavySchedule(config.NewStore(config.GetConfig()))
+	now := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	if err := schedule.checkAt(now); err != nil {
+		t.Fatalf("inside window: %v", err)
+	}
+
+	var scheduleErr *ScheduleError
+	err := schedule.checkAt(now.Add(4 * time.Hour))
+	if !errors.As(err, &scheduleErr) || scheduleErr.Code != ErrCodeOutsideSchedule ||
+		!scheduleErr.NextAllowed.Equal(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("outside window: %v", err)
+	}
+
+	// 预算用完后，下次允许时间为重置时间
+	schedule.period = budgetPeriodStart(now, 2)
+	schedule.used = 100
+	err = schedule.checkAt(now)
+	if !errors.As(err, &scheduleErr) || scheduleErr.Code != ErrCodeBudgetExhausted ||
+		!scheduleErr.NextAllowed.Equal(time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("budget exhausted: %v", err)
+	}
+
+	// 进入新周期后用量清零
+	if err := schedule.checkAt(time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("next period: %v", err)
+	}
+	if schedule.used != 0 {
+		t.Fatalf("used = %d after reset", schedule.used)
+	}
+}
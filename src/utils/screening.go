@@ -0,0 +1,484 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// 筛查判定，写入审计日志
+const (
+	ScreeningActionBlock    = "block"
+	ScreeningActionThrottle = "throttle"
+	ScreeningActionFlag     = "flag"
+	ScreeningActionUnflag   = "unflag"
+	ScreeningActionHashHit  = "hash-match"
+)
+
+const (
+	// screeningAuditSize 保留的最近判定条数
+	screeningAuditSize = 200
+	// maxScreeningTracked 记录首次出现时间的仓库和命中哈希的链接上限，超出时清理
+	maxScreeningTracked = 100000
+	// maxScreeningFeedBytes 屏蔽列表的大小上限
+	maxScreeningFeedBytes = 16 << 20
+	// minScreeningRefresh 屏蔽列表的最短刷新间隔
+	minScreeningRefresh = time.Minute
+)
+
+// ScreeningFeed 屏蔽列表，Repos为小写的owner/repo，Hashes为小写的sha256:<hex>
+type ScreeningFeed struct {
+	Repos   map[string]bool
+	Hashes  map[string]bool
+	Invalid int
+}
+
+// NormalizeScreeningTarget 规范化筛查条目：仓库为小写的owner/repo(去掉.git)，
+// 文件哈希为小写的sha256:<hex>，也接受不带前缀的64位十六进制；无法识别时返回空串
+func NormalizeScreeningTarget(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	digest := strings.TrimPrefix(entry, "sha256:")
+	if _, err := hex.DecodeString(digest); err == nil && len(digest) == 64 {
+		return "sha256:" + digest
+	}
+	if strings.HasPrefix(entry, "sha256:") {
+		return ""
+	}
+
+	owner, repo, found := strings.Cut(entry, "/")
+	repo = strings.TrimSuffix(repo, ".git")
+	if !found || !isRepoNameSegment(owner) || !isRepoNameSegment(repo) {
+		return ""
+	}
+	return owner + "/" + repo
+}
+
+// PurgeScreenedTarget 清除被屏蔽条目的缓存，返回清除数量。
+// GitHub API缓存的key是URL的摘要，无法按仓库筛选，屏蔽仓库时整体清除
+func PurgeScreenedTarget(cache *UniversalCache, hot *HotCache, target string) int {
+	target = NormalizeScreeningTarget(target)
+	switch {
+	case target == "":
+		return 0
+	case strings.HasPrefix(target, "sha256:"):
+		return hot.Flush(BuildBlobHotKey(target))
+	default:
+		return cache.Flush(GitHubAPICachePrefix)
+	}
+}
+
+// isRepoNameSegment 判断是否为GitHub用户名或仓库名，只允许字母、数字、'.'、'-'和'_'
+func isRepoNameSegment(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isScreeningHash 判断规范化后的条目是否为文件哈希
+func isScreeningHash(target string) bool {
+	return strings.HasPrefix(target, "sha256:")
+}
+
+// ParseScreeningFeed 解析屏蔽列表，每行一个条目，行内第一个字段之后的内容和#之后的注释忽略。
+// 无法识别的行计入Invalid；没有任何有效条目时返回错误，避免上游返回空页面时清空列表
+func ParseScreeningFeed(r io.Reader) (*ScreeningFeed, error) {
+	feed := &ScreeningFeed{Repos: make(map[string]bool), Hashes: make(map[string]bool)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch target := NormalizeScreeningTarget(fields[0]); {
+		case target == "":
+			feed.Invalid++
+		case isScreeningHash(target):
+			feed.Hashes[target] = true
+		default:
+			feed.Repos[target] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取屏蔽列表失败: %w", err)
+	}
+	if len(feed.Repos)+len(feed.Hashes) == 0 {
+		return nil, errors.New("屏蔽列表中没有有效条目")
+	}
+	return feed, nil
+}
+
+// ScreeningEvent 一次筛查判定，Rule为触发的规则，如 feed:owner/repo、flag:sha256:...、newRepoMaxBytes
+type ScreeningEvent struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target"`
+	Rule     string    `json:"rule"`
+	ClientIP string    `json:"client_ip,omitempty"`
+}
+
+// ScreeningFlag 管理员手动标记或文件命中哈希后自动标记的条目
+type ScreeningFlag struct {
+	Target string    `json:"target"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+// repoUsage 仓库首次出现的时间和之后经代理下载的字节数
+type repoUsage struct {
+	firstSeen time.Time
+	bytes     int64
+}
+
+// Screener 滥用内容筛查：屏蔽列表、手动标记、命中哈希的链接和新仓库的下载预算
+type Screener struct {
+	config       *config.Store
+	mu           sync.Mutex
+	feed         *ScreeningFeed
+	feedLoadedAt time.Time
+	feedError    string
+	feedFailures uint64
+	flags        map[string]ScreeningFlag
+	blockedURLs  map[string]string
+	repos        map[string]*repoUsage
+	audit        []ScreeningEvent
+
+	feedOnce sync.Once
+	// refreshNow 配置变化时唤醒刷新循环
+	refreshNow chan struct{}
+}
+
+// NewScreener 创建按store中的配置筛查的筛查器，屏蔽列表为空
+func NewScreener(store *config.Store) *Screener {
+	return &Screener{
+		config:      store,
+		flags:       make(map[string]ScreeningFlag),
+		blockedURLs: make(map[string]string),
+		repos:       make(map[string]*repoUsage),
+		refreshNow:  make(chan struct{}, 1),
+	}
+}
+
+// Enabled 是否启用滥用内容筛查
+func (s *Screener) Enabled() bool {
+	return s.config.Get().Security.Screening.Enabled
+}
+
+// Audit 记录一次判定到审计日志：打印到服务日志，并保留最近的记录供 /admin/screening 查看
+func (s *Screener) Audit(event ScreeningEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	fmt.Printf("内容筛查审计: action=%s target=%s rule=%s client=%s\n", event.Action, event.Target, event.Rule, event.ClientIP)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, event)
+	if len(s.audit) > screeningAuditSize {
+		s.audit = append([]ScreeningEvent(nil), s.audit[len(s.audit)-screeningAuditSize:]...)
+	}
+}
+
+// matchLocked 返回条目命中的规则，未命中时返回空串
+func (s *Screener) matchLocked(target string) string {
+	if _, flagged := s.flags[target]; flagged {
+		return "flag:" + target
+	}
+	if s.feed != nil && (s.feed.Repos[target] || s.feed.Hashes[target]) {
+		return "feed:" + target
+	}
+	return ""
+}
+
+// Check 检查仓库(owner/repo)或文件哈希是否被屏蔽，返回命中的规则
+func (s *Screener) Check(target string) string {
+	if target = NormalizeScreeningTarget(target); target == "" {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.matchLocked(target)
+}
+
+// CheckURL 检查链接的内容此前是否命中过屏蔽的文件哈希，返回命中的规则
+func (s *Screener) CheckURL(u string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash, blocked := s.blockedURLs[u]; blocked {
+		return "url:" + hash
+	}
+	return ""
+}
+
+// HasHashRules 是否存在需要对下载内容计算哈希的规则
+func (s *Screener) HasHashRules() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.feed != nil && len(s.feed.Hashes) > 0 {
+		return true
+	}
+	for target := range s.flags {
+		if isScreeningHash(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordContentHash 下载完成后检查内容的sha256，命中时记住该链接并标记所属仓库，
+// 之后的请求在转发前即被拒绝。返回命中的规则
+func (s *Screener) RecordContentHash(u, repo, sha256Hex string) string {
+	target := "sha256:" + strings.ToLower(sha256Hex)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule := s.matchLocked(target)
+	if rule == "" {
+		return ""
+	}
+	if len(s.blockedURLs) >= maxScreeningTracked {
+		s.blockedURLs = make(map[string]string)
+	}
+	s.blockedURLs[u] = target
+	if repo = NormalizeScreeningTarget(repo); repo != "" && !isScreeningHash(repo) {
+		if _, flagged := s.flags[repo]; !flagged {
+			s.flags[repo] = ScreeningFlag{Target: repo, Reason: "文件命中 " + rule, By: "screening", At: time.Now()}
+		}
+	}
+	return rule
+}
+
+// CheckNewRepo 记录仓库首次出现的时间，新仓库在窗口内的下载量达到newRepoMaxBytes时返回规则名
+func (s *Screener) CheckNewRepo(repo string, now time.Time) string {
+	cfg := s.config.Get().Security.Screening
+	if cfg.NewRepoMaxBytes <= 0 {
+		return ""
+	}
+	if repo = NormalizeScreeningTarget(repo); repo == "" {
+		return ""
+	}
+	window := ParseTimeout(cfg.NewRepoWindow, 24*time.Hour)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage, exists := s.repos[repo]
+	if !exists {
+		if len(s.repos) >= maxScreeningTracked {
+			s.pruneReposLocked(now, window)
+		}
+		s.repos[repo] = &repoUsage{firstSeen: now}
+		return ""
+	}
+	if now.Sub(usage.firstSeen) < window && usage.bytes >= cfg.NewRepoMaxBytes {
+		return "newRepoMaxBytes"
+	}
+	return ""
+}
+
+// NewRepoRetryAfter 返回新仓库距离窗口结束、不再受newRepoMaxBytes限制的剩余时长，仓库未记录时返回0
+func (s *Screener) NewRepoRetryAfter(repo string, now time.Time) time.Duration {
+	window := ParseTimeout(s.config.Get().Security.Screening.NewRepoWindow, 24*time.Hour)
+	repo = NormalizeScreeningTarget(repo)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage, exists := s.repos[repo]
+	if !exists {
+		return 0
+	}
+	return max(usage.firstSeen.Add(window).Sub(now), 0)
+}
+
+// pruneReposLocked 清理已超出新仓库窗口的记录，仍然超出上限时全部清空
+func (s *Screener) pruneReposLocked(now time.Time, window time.Duration) {
+	for repo, usage := range s.repos {
+		if now.Sub(usage.firstSeen) >= window {
+			delete(s.repos, repo)
+		}
+	}
+	if len(s.repos) >= maxScreeningTracked {
+		s.repos = make(map[string]*repoUsage)
+	}
+}
+
+// AddRepoBytes 累计仓库经代理下载的字节数，只统计已记录首次出现时间的仓库
+func (s *Screener) AddRepoBytes(repo string, n int64) {
+	if repo = NormalizeScreeningTarget(repo); repo == "" || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if usage, exists := s.repos[repo]; exists {
+		usage.bytes += n
+	}
+}
+
+// Flag 手动标记仓库或文件哈希，立即生效，返回规范化后的条目
+func (s *Screener) Flag(target, reason, by string) (string, error) {
+	normalized := NormalizeScreeningTarget(target)
+	if normalized == "" {
+		return "", fmt.Errorf("无法识别的条目: %s，应为 owner/repo 或 sha256:<hex>", target)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[normalized] = ScreeningFlag{Target: normalized, Reason: reason, By: by, At: time.Now()}
+	return normalized, nil
+}
+
+// Unflag 取消标记，同时解除因该哈希被屏蔽的链接，条目不存在时返回false
+func (s *Screener) Unflag(target string) bool {
+	target = NormalizeScreeningTarget(target)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, flagged := s.flags[target]; !flagged {
+		return false
+	}
+	delete(s.flags, target)
+	for u, hash := range s.blockedURLs {
+		if hash == target {
+			delete(s.blockedURLs, u)
+		}
+	}
+	return true
+}
+
+// SetFeed 替换屏蔽列表，feed为nil时清空
+func (s *Screener) SetFeed(feed *ScreeningFeed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed = feed
+	s.feedLoadedAt = time.Now()
+	s.feedError = ""
+}
+
+// RefreshFeed 经client下载并替换屏蔽列表，失败时保留上一次成功加载的列表并记录错误
+func (s *Screener) RefreshFeed(ctx context.Context, client *http.Client, feedURL string) error {
+	feed, err := fetchScreeningFeed(ctx, client, feedURL)
+	if err != nil {
+		s.mu.Lock()
+		s.feedError = err.Error()
+		s.feedFailures++
+		s.mu.Unlock()
+		return err
+	}
+	s.SetFeed(feed)
+	return nil
+}
+
+// fetchScreeningFeed 下载并解析屏蔽列表
+func fetchScreeningFeed(ctx context.Context, client *http.Client, feedURL string) (*ScreeningFeed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("屏蔽列表地址无效: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载屏蔽列表失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载屏蔽列表失败: 返回 %d", resp.StatusCode)
+	}
+	return ParseScreeningFeed(io.LimitReader(resp.Body, maxScreeningFeedBytes))
+}
+
+// ScreeningStatus 筛查状态，供管理接口查看
+type ScreeningStatus struct {
+	Enabled      bool             `json:"enabled"`
+	FeedRepos    int              `json:"feed_repos"`
+	FeedHashes   int              `json:"feed_hashes"`
+	FeedInvalid  int              `json:"feed_invalid"`
+	FeedLoadedAt time.Time        `json:"feed_loaded_at,omitempty"`
+	FeedError    string           `json:"feed_error,omitempty"`
+	FeedFailures uint64           `json:"feed_failures"`
+	Flags        []ScreeningFlag  `json:"flags"`
+	BlockedURLs  int              `json:"blocked_urls"`
+	TrackedRepos int              `json:"tracked_repos"`
+	Recent       []ScreeningEvent `json:"recent"`
+}
+
+// Status 返回屏蔽列表、标记和最近的判定，判定按时间倒序
+func (s *Screener) Status() ScreeningStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := ScreeningStatus{
+		Enabled:      s.Enabled(),
+		FeedLoadedAt: s.feedLoadedAt,
+		FeedError:    s.feedError,
+		FeedFailures: s.feedFailures,
+		Flags:        make([]ScreeningFlag, 0, len(s.flags)),
+		BlockedURLs:  len(s.blockedURLs),
+		TrackedRepos: len(s.repos),
+		Recent:       make([]ScreeningEvent, 0, len(s.audit)),
+	}
+	if s.feed != nil {
+		status.FeedRepos, status.FeedHashes, status.FeedInvalid = len(s.feed.Repos), len(s.feed.Hashes), s.feed.Invalid
+	}
+	for _, flag := range s.flags {
+		status.Flags = append(status.Flags, flag)
+	}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		status.Recent = append(status.Recent, s.audit[i])
+	}
+	return status
+}
+
+// refreshFeed 按当前配置刷新一次屏蔽列表，未启用或未配置地址时清空列表
+func (s *Screener) refreshFeed(ctx context.Context, clients *HTTPClients) {
+	cfg := s.config.Get().Security.Screening
+	if !cfg.Enabled || cfg.FeedURL == "" {
+		s.SetFeed(nil)
+		return
+	}
+	ctx, cancel := clients.MetadataContext(ctx)
+	defer cancel()
+	if err := s.RefreshFeed(ctx, clients.Metadata(), cfg.FeedURL); err != nil {
+		fmt.Printf("刷新屏蔽列表失败，继续使用上一次的列表: %v\n", err)
+		return
+	}
+	status := s.Status()
+	fmt.Printf("屏蔽列表已加载: 仓库 %d 个，文件哈希 %d 个，无法识别 %d 行\n", status.FeedRepos, status.FeedHashes, status.FeedInvalid)
+}
+
+// FeedReloader 热重载修改了筛查配置时唤醒刷新循环，立即按新配置刷新屏蔽列表
+func (s *Screener) FeedReloader() config.Reloadable {
+	return config.ReloadFunc{Sections: []string{"security"}, Fn: func(old, updated *config.AppConfig) {
+		if old.Security.Screening != updated.Security.Screening {
+			select {
+			case s.refreshNow <- struct{}{}:
+			default:
+			}
+		}
+	}}
+}
+
+// StartFeed 经clients启动屏蔽列表的定时刷新，重复调用只启动一次，ctx取消时退出
+func (s *Screener) StartFeed(ctx context.Context, clients *HTTPClients) {
+	s.feedOnce.Do(func() {
+		go func() {
+			for {
+				s.refreshFeed(ctx, clients)
+				interval := max(ParseTimeout(s.config.Get().Security.Screening.FeedRefresh, time.Hour), minScreeningRefresh)
+				select {
+				case <-time.After(interval):
+				case <-s.refreshNow:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	})
+}
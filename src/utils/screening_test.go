@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+const screeningTestHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestParseScreeningFeed(t *testing.T) {
+	feed, err := ParseScreeningFeed(strings.NewReader(`
+# 恶意软件仓库
+Evil/Dropper.git
+bad/repo   2026-10-01 reported
+sha256:` + strings.ToUpper(screeningTestHash) + `
+` + screeningTestHash + ` # 重复条目
+not-a-repo
+sha256:abc
+a/b/c
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feed.Repos["evil/dropper"] || !feed.Repos["bad/repo"] || len(feed.Repos) != 2 {
+		t.Fatalf("repos = %v", feed.Repos)
+	}
+	if !feed.Hashes["sha256:"+screeningTestHash] || len(feed.Hashes) != 1 {
+		t.Fatalf("hashes = %v", feed.Hashes)
+	}
+	if feed.Invalid != 3 {
+		t.Fatalf("invalid = %d", feed.Invalid)
+	}
+
+	for _, body := range []string{"", "# only comments\n\n", "garbage\n"} {
+		if _, err := ParseScreeningFeed(strings.NewReader(body)); err == nil {
+			t.Errorf("feed %q accepted", body)
+		}
+	}
+}
+
+func TestScreeningFeedRefreshFailureKeepsPrevious(t *testing.T) {
+	loadTestConfig(t, "[security.screening]\nenabled = true\n")
+
+	var mode atomic.Value
+	mode.Store("ok")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch mode.Load() {
+		case "ok":
+			w.Write([]byte("evil/dropper\n"))
+		case "empty":
+			w.Write([]byte("<html></html>\n"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPClients(testStore(), NewStatsRegistry()).Metadata()
+	screener := NewScreener(testStore())
+	if err := screener.RefreshFeed(context.Background(), client, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if rule := screener.Check("Evil/Dropper"); rule != "feed:evil/dropper" {
+		t.Fatalf("rule = %q", rule)
+	}
+
+	// 上游出错或返回没有有效条目的内容时保留上一次的列表
+	for i, failure := range []string{"error", "empty"} {
+		mode.Store(failure)
+		if err := screener.RefreshFeed(context.Background(), client, server.URL); err == nil {
+			t.Fatalf("%s refresh succeeded", failure)
+		}
+		status := screener.Status()
+		if status.FeedRepos != 1 || status.FeedError == "" || status.FeedFailures != uint64(i+1) {
+			t.Fatalf("%s status = %+v", failure, status)
+		}
+		if screener.Check("evil/dropper") == "" {
+			t.Fatalf("%s refresh dropped the previous feed", failure)
+		}
+	}
+
+	mode.Store("ok")
+	if err := screener.RefreshFeed(context.Background(), client, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if status := screener.Status(); status.FeedError != "" || status.FeedFailures != 2 {
+		t.Fatalf("status after recovery = %+v", status)
+	}
+}
+
+func TestScreeningNewRepoThrottle(t *testing.T) {
+	loadTestConfig(t, "[security.screening]\nenabled = true\nnewRepoWindow = \"1h\"\nnewRepoMaxBytes = 1000\n")
+	store := testStore()
+	screener := NewScreener(store)
+	now := time.Now()
+
+	if rule := screener.CheckNewRepo("fresh/repo", now); rule != "" {
+		t.Fatalf("first request rule = %q", rule)
+	}
+	screener.AddRepoBytes("fresh/repo", 600)
+	if rule := screener.CheckNewRepo("Fresh/Repo", now.Add(time.Minute)); rule != "" {
+		t.Fatalf("under budget rule = %q", rule)
+	}
+	screener.AddRepoBytes("fresh/repo", 600)
+	if rule := screener.CheckNewRepo("fresh/repo", now.Add(2*time.Minute)); rule != "newRepoMaxBytes" {
+		t.Fatalf("over budget rule = %q", rule)
+	}
+	// 超出新仓库窗口后不再限制
+	if rule := screener.CheckNewRepo("fresh/repo", now.Add(time.Hour)); rule != "" {
+		t.Fatalf("after window rule = %q", rule)
+	}
+
+	loadTestConfig(t, "[security.screening]\nenabled = true\n")
+	store.Set(config.GetConfig())
+	screener.AddRepoBytes("fresh/repo", 1<<20)
+	if rule := screener.CheckNewRepo("fresh/repo", now.Add(3*time.Minute)); rule != "" {
+		t.Fatalf("budget disabled rule = %q", rule)
+	}
+}
+
+func TestScreeningContentHashFlagsRepo(t *testing.T) {
+	screener := NewScreener(testStore())
+	screener.SetFeed(&ScreeningFeed{Repos: map[string]bool{}, Hashes: map[string]bool{"sha256:" + screeningTestHash: true}})
+
+	if rule := screener.RecordContentHash("https://github.com/a/b/releases/x.exe", "a/b", strings.Repeat("0", 64)); rule != "" {
+		t.Fatalf("clean file rule = %q", rule)
+	}
+	if rule := screener.RecordContentHash("https://github.com/a/b/releases/y.exe", "a/b", screeningTestHash); rule != "feed:sha256:"+screeningTestHash {
+		t.Fatalf("rule = %q", rule)
+	}
+	if screener.CheckURL("https://github.com/a/b/releases/y.exe") == "" || screener.Check("a/b") != "flag:a/b" {
+		t.Fatal("matched url or repo not blocked")
+	}
+	if !screener.Unflag("a/b") || screener.Check("a/b") != "" {
+		t.Fatal("unflag failed")
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd通知状态
+const (
+	SdNotifyReady     = "READY=1"
+	SdNotifyReloading = "RELOADING=1"
+	SdNotifyStopping  = "STOPPING=1"
+	SdNotifyWatchdog  = "WATCHDOG=1"
+)
+
+// SdNotify 向systemd发送状态通知，未设置NOTIFY_SOCKET时不做任何事并返回false
+func SdNotify(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+	// @开头表示Linux抽象命名空间套接字
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// notify 发送通知，失败时仅记录日志
+func notify(state string) {
+	if _, err := SdNotify(state); err != nil {
+		fmt.Printf("systemd通知失败(%s): %v\n", state, err)
+	}
+}
+
+// NotifyReady 通知systemd服务已就绪
+func NotifyReady() {
+	notify(SdNotifyReady)
+}
+
+// NotifyStopping 通知systemd服务正在停止
+func NotifyStopping() {
+	notify(SdNotifyStopping)
+}
+
+// ReloadWithNotify 调用reload重载配置，并在前后向systemd发送RELOADING/READY通知
+func ReloadWithNotify(reload func() error) error {
+	notify(SdNotifyReloading)
+	defer notify(SdNotifyReady)
+	return reload()
+}
+
+// WatchdogInterval 返回systemd要求的看门狗间隔，未启用或不属于当前进程时返回0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog 以看门狗间隔的一半定期检查服务，检查通过才发送WATCHDOG=1
+// 服务卡死时不再发送心跳，由systemd负责重启
+func RunWatchdog(ctx context.Context, interval time.Duration, healthy func(context.Context) bool) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval/4)
+			ok := healthy(checkCtx)
+			cancel()
+			if !ok {
+				fmt.Printf("健康检查失败，跳过本次看门狗通知\n")
+				continue
+			}
+			notify(SdNotifyWatchdog)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// listenNotifySocket 创建模拟的systemd通知套接字
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) (string, bool) {
+	t.Helper()
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", false
+	}
+	return string(buf[:n]), true
+}
+
+func TestSdNotifyNoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := SdNotify(SdNotifyReady)
+	if sent || err != nil {
+		t.Fatalf("SdNotify outside systemd = %v, %v", sent, err)
+	}
+}
+
+func TestSdNotifySendsState(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	NotifyReady()
+	if msg, ok := readNotification(t, conn, time.Second); !ok || msg != SdNotifyReady {
+		t.Fatalf("got %q, want %q", msg, SdNotifyReady)
+	}
+
+	loadTestConfig(t, "")
+	if err := ReloadWithNotify(config.ReloadConfig); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{SdNotifyReloading, SdNotifyReady} {
+		if msg, ok := readNotification(t, conn, time.Second); !ok || msg != want {
+			t.Fatalf("got %q, want %q", msg, want)
+		}
+	}
+
+	NotifyStopping()
+	if msg, ok := readNotification(t, conn, time.Second); !ok || msg != SdNotifyStopping {
+		t.Fatalf("got %q, want %q", msg, SdNotifyStopping)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Fatalf("interval without env = %v", got)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Fatalf("interval = %v, want 30s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := WatchdogInterval(); got != 0 {
+		t.Fatalf("interval for other pid = %v, want 0", got)
+	}
+}
+
+func TestRunWatchdogRequiresHealthyServer(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	healthy := make(chan bool, 1)
+	healthy <- false
+	go RunWatchdog(ctx, 100*time.Millisecond, func(context.Context) bool {
+		select {
+		case ok := <-healthy:
+			return ok
+		default:
+			return true
+		}
+	})
+
+	// 第一次检查失败，不应发送心跳
+	if msg, ok := readNotification(t, conn, 80*time.Millisecond); ok {
+		t.Fatalf("unexpected notification while unhealthy: %q", msg)
+	}
+	if msg, ok := readNotification(t, conn, time.Second); !ok || msg != SdNotifyWatchdog {
+		t.Fatalf("got %q, want %q", msg, SdNotifyWatchdog)
+	}
+}
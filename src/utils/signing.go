@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrSigningDisabled 未启用制品签名
+var ErrSigningDisabled = errors.New("未启用制品签名")
+
+// ArtifactSigner 使用Ed25519私钥对制品的sha256摘要签名
+type ArtifactSigner struct {
+	key ed25519.PrivateKey
+}
+
+// SigningKeys 按配置加载的制品签名私钥，属于一个服务实例
+type SigningKeys struct {
+	config *config.Store
+	signer atomic.Pointer[ArtifactSigner]
+}
+
+// ParseSigningKey 解析PKCS#8 PEM格式的Ed25519私钥，可由 openssl genpkey -algorithm ed25519 生成
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("签名密钥不是PEM格式")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析签名密钥失败: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("签名密钥类型为%T，只支持Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// Load 按配置加载签名私钥，启用签名但密钥缺失或无效时返回错误
+func (k *SigningKeys) Load() error {
+	cfg := k.config.Get()
+	if !cfg.Signing.Enabled {
+		k.signer.Store(nil)
+		return nil
+	}
+
+	data := []byte(strings.TrimSpace(cfg.Signing.Key))
+	if len(data) == 0 {
+		if cfg.Signing.KeyFile == "" {
+			return errors.New("已启用制品签名，但未配置key或keyFile")
+		}
+		fileData, err := os.ReadFile(cfg.Signing.KeyFile)
+		if err != nil {
+			return fmt.Errorf("读取签名密钥文件失败: %w", err)
+		}
+		data = fileData
+	}
+
+	key, err := ParseSigningKey(data)
+	if err != nil {
+		return err
+	}
+	k.signer.Store(&ArtifactSigner{key: key})
+	return nil
+}
+
+// ReloadSections 签名配置位于signing段
+func (k *SigningKeys) ReloadSections() []string {
+	return []string{"signing"}
+}
+
+// Reload 热重载修改了signing段时重新加载签名私钥，失败时继续使用原密钥
+func (k *SigningKeys) Reload(_, _ *config.AppConfig) {
+	if err := k.Load(); err != nil {
+		fmt.Printf("重新加载签名密钥失败，继续使用原密钥: %v\n", err)
+	}
+}
+
+// Signer 返回已加载的签名器，未启用签名时返回ErrSigningDisabled
+func (k *SigningKeys) Signer() (*ArtifactSigner, error) {
+	signer := k.signer.Load()
+	if signer == nil || !k.config.Get().Signing.Enabled {
+		return nil, ErrSigningDisabled
+	}
+	return signer, nil
+}
+
+// SignDigest 对小写十六进制的sha256摘要字符串签名，返回分离签名
+func (s *ArtifactSigner) SignDigest(sha256Hex string) []byte {
+	return ed25519.Sign(s.key, []byte(strings.ToLower(sha256Hex)))
+}
+
+// PublicKey 返回签名公钥
+func (s *ArtifactSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// PublicKeyPEM 返回PKIX PEM格式的签名公钥
+func (s *ArtifactSigner) PublicKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// HashingResponseWriter 在写出响应的同时计算sha256
+type HashingResponseWriter struct {
+	gin.ResponseWriter
+	hash hash.Hash
+}
+
+// NewHashingResponseWriter 包装w，之后写出的内容计入sha256
+func NewHashingResponseWriter(w gin.ResponseWriter) *HashingResponseWriter {
+	return &HashingResponseWriter{ResponseWriter: w, hash: sha256.New()}
+}
+
+func (w *HashingResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.hash.Write(data[:n])
+	return n, err
+}
+
+func (w *HashingResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Sum 返回已写出内容的sha256十六进制摘要
+func (w *HashingResponseWriter) Sum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
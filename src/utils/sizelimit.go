@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// 文件大小限制的路由类别
+const (
+	SizeClassGitHub      = "github"
+	SizeClassDockerBlob  = "dockerBlob"
+	SizeClassTar         = "tar"
+	SizeClassHuggingFace = "huggingface"
+)
+
+// sizeClasses 覆盖规则可用作前缀的类别
+var sizeClasses = map[string]bool{
+	SizeClassGitHub:      true,
+	SizeClassDockerBlob:  true,
+	SizeClassTar:         true,
+	SizeClassHuggingFace: true,
+}
+
+// ErrSizeLimitExceeded 传输过程中超出文件大小限制
+var ErrSizeLimitExceeded = errors.New("超出文件大小限制")
+
+// classSizeLimit 返回类别的默认上限，0继承server.fileSize，负数为不限制
+func classSizeLimit(cfg *config.AppConfig, class string) int64 {
+	var limit int64
+	switch class {
+	case SizeClassGitHub:
+		limit = cfg.Limits.GitHub
+	case SizeClassDockerBlob:
+		limit = cfg.Limits.DockerBlob
+	case SizeClassTar:
+		limit = cfg.Limits.Tar
+	case SizeClassHuggingFace:
+		limit = cfg.Limits.HuggingFace
+	}
+	if limit == 0 {
+		return cfg.Server.FileSize
+	}
+	return limit
+}
+
+// overrideSizeLimit 查找目标的覆盖上限：精确匹配优先于通配符，通配符取最长的模式，
+// 同等条件下带类别前缀（如 "github:owner/*"）的条目优先
+func overrideSizeLimit(cfg *config.AppConfig, class, target string) (int64, bool) {
+	if target == "" {
+		return 0, false
+	}
+	var (
+		best      int64
+		bestScore = -1
+	)
+	for pattern, limit := range cfg.Limits.Overrides {
+		scoped := 0
+		if prefix, rest, ok := strings.Cut(pattern, ":"); ok && sizeClasses[prefix] {
+			if prefix != class {
+				continue
+			}
+			pattern, scoped = rest, 1
+		}
+
+		var score int
+		switch {
+		case pattern == target:
+			score = 1<<20 + scoped
+		case strings.ContainsAny(pattern, "*?["):
+			if matched, _ := path.Match(pattern, target); !matched {
+				continue
+			}
+			score = len(pattern)*2 + scoped
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = limit, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// sizeLimitMultiplier 返回身份的上限倍数，未单独配置时已认证身份使用authenticatedMultiplier
+func sizeLimitMultiplier(cfg *config.AppConfig, identity string) float64 {
+	if identity == "" {
+		return 1
+	}
+	if multiplier, exists := cfg.Limits.Multipliers[identity]; exists && multiplier > 0 {
+		return multiplier
+	}
+	if cfg.Limits.AuthenticatedMultiplier > 0 {
+		return cfg.Limits.AuthenticatedMultiplier
+	}
+	return 1
+}
+
+// SizeLimit 返回类别下目标的文件大小上限，target为owner/repo或镜像名，
+// identity为已认证的用户名或令牌名；返回值小于等于0表示不限制
+func SizeLimit(cfg *config.AppConfig, class, target, identity string) int64 {
+	limit, ok := overrideSizeLimit(cfg, class, target)
+	if !ok {
+		limit = classSizeLimit(cfg, class)
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return int64(float64(limit) * sizeLimitMultiplier(cfg, identity))
+}
+
+// SizeLimitMB 返回用于错误提示的MB数
+func SizeLimitMB(limit int64) int64 {
+	return limit / (1024 * 1024)
+}
+
+// sizeLimitReader 超过上限后返回ErrSizeLimitExceeded
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrSizeLimitExceeded
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrSizeLimitExceeded
+	}
+	return n, err
+}
+
+// NewSizeLimitReader 包装响应体，读取超过limit字节时返回ErrSizeLimitExceeded；limit小于等于0时原样返回
+func NewSizeLimitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &sizeLimitReader{r: r, remaining: limit}
+}
+
+// sizeLimitWriter 写入超过上限后返回ErrSizeLimitExceeded
+type sizeLimitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *sizeLimitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		n, err := l.w.Write(p[:max(l.remaining, 0)])
+		l.remaining -= int64(n)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrSizeLimitExceeded
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// NewSizeLimitWriter 包装输出，写入超过limit字节时返回ErrSizeLimitExceeded；limit小于等于0时原样返回
+func NewSizeLimitWriter(w io.Writer, limit int64) io.Writer {
+	if limit <= 0 {
+		return w
+	}
+	return &sizeLimitWriter{w: w, remaining: limit}
+}
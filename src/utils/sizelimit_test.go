@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestSizeLimitClassDefaults(t *testing.T) {
+	loadTestConfig(t, `
+[server]
+fileSize = 1000
+
+[limits]
+tar = 5000
+`)
+	tests := []struct {
+		class string
+		want  int64
+	}{
+		{SizeClassGitHub, 1000},
+		{SizeClassHuggingFace, 1000},
+		{SizeClassDockerBlob, 0},
+		{SizeClassTar, 5000},
+	}
+	for _, tt := range tests {
+		if got := SizeLimit(config.GetConfig(), tt.class, "owner/repo", ""); got != tt.want {
+			t.Errorf("SizeLimit(config.GetConfig(), %s) = %d, want %d", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestSizeLimitOverridePrecedence(t *testing.T) {
+	loadTestConfig(t, `
+[server]
+fileSize = 1000
+
+[limits.overrides]
+"torvalds/*" = 2000
+"torvalds/linux*" = 3000
+"torvalds/linux" = 4000
+"github:torvalds/linux" = 5000
+"huggingface:meta-llama/*" = -1
+"dockerBlob:library/*" = 6000
+`)
+	tests := []struct {
+		class, target string
+		want          int64
+	}{
+		{SizeClassGitHub, "torvalds/linux", 5000},
+		{SizeClassTar, "torvalds/linux", 4000},
+		{SizeClassGitHub, "torvalds/linux-firmware", 3000},
+		{SizeClassGitHub, "torvalds/subsurface", 2000},
+		{SizeClassGitHub, "other/repo", 1000},
+		{SizeClassHuggingFace, "meta-llama/Llama-3", 0},
+		{SizeClassGitHub, "meta-llama/Llama-3", 1000},
+		{SizeClassDockerBlob, "library/nginx", 6000},
+		{SizeClassDockerBlob, "ghcr.io/org/app", 0},
+	}
+	for _, tt := range tests {
+		if got := SizeLimit(config.GetConfig(), tt.class, tt.target, ""); got != tt.want {
+			t.Errorf("SizeLimit(config.GetConfig(), %s, %s) = %d, want %d", tt.class, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestSizeLimitMultiplier(t *testing.T) {
+	loadTestConfig(t, `
+[server]
+fileSize = 1000
+
+[limits]
+authenticatedMultiplier = 2
+
+[limits.multipliers]
+ci = 10
+`)
+	tests := []struct {
+		identity string
+		want     int64
+	}{
+		{"", 1000},
+		{"alice", 2000},
+		{"ci", 10000},
+	}
+	for _, tt := range tests {
+		if got := SizeLimit(config.GetConfig(), SizeClassGitHub, "owner/repo", tt.identity); got != tt.want {
+			t.Errorf("SizeLimit(config.GetConfig(), identity %q) = %d, want %d", tt.identity, got, tt.want)
+		}
+	}
+}
+
+func TestSizeLimitReader(t *testing.T) {
+	data, err := io.ReadAll(NewSizeLimitReader(strings.NewReader("12345"), 5))
+	if err != nil || string(data) != "12345" {
+		t.Fatalf("at limit: %q, %v", data, err)
+	}
+	data, err = io.ReadAll(NewSizeLimitReader(strings.NewReader("123456"), 5))
+	if !errors.Is(err, ErrSizeLimitExceeded) || string(data) != "12345" {
+		t.Fatalf("over limit: %q, %v", data, err)
+	}
+	data, err = io.ReadAll(NewSizeLimitReader(strings.NewReader("123456"), 0))
+	if err != nil || len(data) != 6 {
+		t.Fatalf("unlimited: %q, %v", data, err)
+	}
+}
+
+func TestSizeLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewSizeLimitWriter(&buf, 5)
+	if _, err := w.Write([]byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write([]byte("456"))
+	if !errors.Is(err, ErrSizeLimitExceeded) || n != 2 || buf.String() != "12345" {
+		t.Fatalf("over limit: n=%d, %v, %q", n, err, buf.String())
+	}
+}
@@ -0,0 +1,332 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+const (
+	// slowRequestLogSize 保留的最近慢请求条数
+	slowRequestLogSize = 50
+	// slowSampleDecay 普通请求样本降低旧样本权重的周期
+	slowSampleDecay = time.Hour
+)
+
+// 上游请求阶段，用于慢请求记录和耗时直方图
+const (
+	PhaseDNS      = "dns"
+	PhaseConnect  = "connect"
+	PhaseTLS      = "tls"
+	PhaseTTFB     = "ttfb"
+	PhaseTransfer = "transfer"
+)
+
+// SlowRequestSettings 返回cfg中的慢请求阈值和普通请求样本数，阈值为0表示不记录慢请求和阶段耗时
+func SlowRequestSettings(cfg *config.AppConfig) (time.Duration, int) {
+	return ParseTimeout(cfg.Log.SlowRequestThreshold, 0), cfg.Log.SlowRequestSamples
+}
+
+// RequestPhases 一个客户端请求访问上游各阶段的耗时(毫秒)，有多次上游请求时累加；
+// Transfer为总耗时减去其余阶段，包括响应体传输和本地处理
+type RequestPhases struct {
+	DNS      float64 `json:"dns_ms"`
+	Connect  float64 `json:"connect_ms"`
+	TLS      float64 `json:"tls_ms"`
+	TTFB     float64 `json:"ttfb_ms"`
+	Transfer float64 `json:"transfer_ms"`
+}
+
+type requestTimingKey struct{}
+
+// RequestTiming 单个客户端请求的上游阶段计时，通过请求上下文传递给上游transport
+type RequestTiming struct {
+	mu        sync.Mutex
+	phases    [4]time.Duration
+	upstreams int
+	target    string
+}
+
+// WithRequestTiming 返回附带上游阶段计时的上下文
+func WithRequestTiming(ctx context.Context) (context.Context, *RequestTiming) {
+	timing := &RequestTiming{}
+	return context.WithValue(ctx, requestTimingKey{}, timing), timing
+}
+
+func requestTimingFrom(ctx context.Context) *RequestTiming {
+	timing, _ := ctx.Value(requestTimingKey{}).(*RequestTiming)
+	return timing
+}
+
+// add 累加一次上游请求的阶段耗时，target取第一次上游请求的地址
+func (t *RequestTiming) add(req *http.Request, phases [4]time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, d := range phases {
+		t.phases[i] += d
+	}
+	if t.upstreams == 0 {
+		t.target = req.URL.Scheme + "://" + req.URL.Host + req.URL.EscapedPath()
+	}
+	t.upstreams++
+}
+
+// Phases 返回总耗时为total的请求的各阶段耗时、上游请求次数和第一次上游请求的地址(不含查询参数)
+func (t *RequestTiming) Phases(total time.Duration) (RequestPhases, int, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	upstream := t.phases[0] + t.phases[1] + t.phases[2] + t.phases[3]
+	return RequestPhases{
+		DNS:      milliseconds(t.phases[0]),
+		Connect:  milliseconds(t.phases[1]),
+		TLS:      milliseconds(t.phases[2]),
+		TTFB:     milliseconds(t.phases[3]),
+		Transfer: milliseconds(max(total-upstream, 0)),
+	}, t.upstreams, t.target
+}
+
+func milliseconds(d time.Duration) float64 {
+	return roundStat(float64(d) / float64(time.Millisecond))
+}
+
+// phaseTrace 一次上游请求的httptrace计时，拨号可能在单独的goroutine中进行
+type phaseTrace struct {
+	mu                               sync.Mutex
+	dnsStart, connectStart, tlsStart time.Time
+	wrote                            time.Time
+	phases                           [4]time.Duration
+	seen                             [4]bool
+}
+
+func (p *phaseTrace) start(at *time.Time) {
+	p.mu.Lock()
+	if at.IsZero() {
+		*at = time.Now()
+	}
+	p.mu.Unlock()
+}
+
+func (p *phaseTrace) done(phase int, since *time.Time) {
+	p.mu.Lock()
+	if !since.IsZero() {
+		p.phases[phase] = time.Since(*since)
+		p.seen[phase] = true
+	}
+	p.mu.Unlock()
+}
+
+// phaseTimingTransport 对带有RequestTiming的请求记录DNS、连接、TLS握手和上游首字节耗时，
+// 并计入阶段耗时直方图；其他请求直接转发
+type phaseTimingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *phaseTimingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timing := requestTimingFrom(req.Context())
+	if timing == nil {
+		return t.base.RoundTrip(req)
+	}
+	p := &phaseTrace{}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.start(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.done(0, &p.dnsStart) },
+		ConnectStart:         func(string, string) { p.start(&p.connectStart) },
+		ConnectDone:          func(string, string, error) { p.done(1, &p.connectStart) },
+		TLSHandshakeStart:    func() { p.start(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.done(2, &p.tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.start(&p.wrote) },
+		GotFirstResponseByte: func() { p.done(3, &p.wrote) },
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+
+	p.mu.Lock()
+	phases, seen := p.phases, p.seen
+	p.mu.Unlock()
+	timing.add(req, phases)
+	for i, name := range []string{PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB} {
+		if seen[i] {
+			upstreamPhases[name].record(phases[i])
+		}
+	}
+	return resp, err
+}
+
+// phaseBuckets 阶段耗时直方图的桶上界(秒)
+var phaseBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// phaseHistogram 固定分桶的耗时直方图，记录只做原子加法
+type phaseHistogram struct {
+	counts [14]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *phaseHistogram) record(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(phaseBuckets) && seconds > phaseBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// upstreamPhases 各阶段的耗时直方图
+var upstreamPhases = map[string]*phaseHistogram{
+	PhaseDNS:      {},
+	PhaseConnect:  {},
+	PhaseTLS:      {},
+	PhaseTTFB:     {},
+	PhaseTransfer: {},
+}
+
+// PhaseHistogram 阶段耗时直方图快照，Buckets为各上界的累计计数，最后一个对应+Inf
+type PhaseHistogram struct {
+	Phase   string
+	Bounds  []float64
+	Buckets []uint64
+	Sum     float64
+	Count   uint64
+}
+
+// UpstreamPhaseHistograms 按dns、connect、tls、ttfb、transfer的顺序返回阶段耗时直方图。
+// 前四个阶段按每次上游请求记录，未新建连接时没有dns、connect、tls；transfer按客户端请求记录
+func UpstreamPhaseHistograms() []PhaseHistogram {
+	result := make([]PhaseHistogram, 0, len(upstreamPhases))
+	for _, name := range []string{PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB, PhaseTransfer} {
+		h := upstreamPhases[name]
+		snapshot := PhaseHistogram{Phase: name, Bounds: phaseBuckets, Sum: time.Duration(h.sum.Load()).Seconds()}
+		for i := range h.counts {
+			snapshot.Count += h.counts[i].Load()
+			snapshot.Buckets = append(snapshot.Buckets, snapshot.Count)
+		}
+		result = append(result, snapshot)
+	}
+	return result
+}
+
+// SlowRequestEntry 一条慢请求或普通请求样本，Path不含查询参数，Upstream为第一次上游请求的地址
+type SlowRequestEntry struct {
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	Path          string        `json:"path"`
+	Route         string        `json:"route"`
+	Target        string        `json:"target,omitempty"`
+	Status        int           `json:"status"`
+	DurationMs    float64       `json:"duration_ms"`
+	Phases        RequestPhases `json:"phases"`
+	Upstream      string        `json:"upstream,omitempty"`
+	Upstreams     int           `json:"upstream_requests"`
+	Bytes         int64         `json:"bytes"`
+	UpstreamBytes int64         `json:"upstream_bytes"`
+	Cache         string        `json:"cache,omitempty"`
+	ClientIP      string        `json:"client_ip,omitempty"`
+	UserAgent     string        `json:"user_agent,omitempty"`
+}
+
+// SlowRequestLog 最近的慢请求和按蓄水池抽样保留的普通请求样本，只保存在内存中
+type SlowRequestLog struct {
+	mu      sync.Mutex
+	slow    []SlowRequestEntry
+	next    int
+	full    bool
+	samples []SlowRequestEntry
+	seen    int
+	decayed time.Time
+}
+
+// NewSlowRequestLog 创建慢请求记录
+func NewSlowRequestLog() *SlowRequestLog {
+	return &SlowRequestLog{slow: make([]SlowRequestEntry, slowRequestLogSize), decayed: time.Now()}
+}
+
+// Observe 记录一个耗时为duration的请求，transfer阶段计入直方图。超过threshold时输出慢请求日志并保存，
+// 否则按蓄水池抽样决定是否作为普通请求样本保留；fill补充客户端和响应信息，只在需要保存时调用，
+// 低于阈值且未被抽中的请求不产生额外开销。每隔一小时降低旧样本的权重，使样本偏向最近的请求
+func (l *SlowRequestLog) Observe(timing *RequestTiming, duration, threshold time.Duration, sampleSize int, fill func(*SlowRequestEntry)) {
+	if timing == nil || threshold <= 0 {
+		return
+	}
+	phases, upstreams, target := timing.Phases(duration)
+	upstreamPhases[PhaseTransfer].record(time.Duration(phases.Transfer * float64(time.Millisecond)))
+	entry := func() SlowRequestEntry {
+		record := SlowRequestEntry{
+			Time:       time.Now().Add(-duration),
+			DurationMs: milliseconds(duration),
+			Phases:     phases,
+			Upstream:   target,
+			Upstreams:  upstreams,
+		}
+		fill(&record)
+		return record
+	}
+
+	if duration >= threshold {
+		record := entry()
+		if data, err := json.Marshal(record); err == nil {
+			fmt.Printf("慢请求: %s\n", data)
+		}
+		l.mu.Lock()
+		l.slow[l.next] = record
+		l.next = (l.next + 1) % len(l.slow)
+		if l.next == 0 {
+			l.full = true
+		}
+		l.mu.Unlock()
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.decayed) >= slowSampleDecay {
+		l.seen = min(l.seen, sampleSize)
+		l.decayed = time.Now()
+	}
+	if len(l.samples) > sampleSize {
+		l.samples = l.samples[:sampleSize]
+	}
+	l.seen++
+	switch {
+	case len(l.samples) < sampleSize:
+		l.samples = append(l.samples, entry())
+	case sampleSize > 0:
+		if i := rand.Intn(l.seen); i < sampleSize {
+			l.samples[i] = entry()
+		}
+	}
+}
+
+// SlowRequestSnapshot 慢请求记录的快照，Slow按时间倒序
+type SlowRequestSnapshot struct {
+	Threshold string             `json:"threshold"`
+	Slow      []SlowRequestEntry `json:"slow"`
+	Samples   []SlowRequestEntry `json:"samples"`
+}
+
+// Snapshot 返回最近的慢请求和普通请求样本，阈值取自cfg
+func (l *SlowRequestLog) Snapshot(cfg *config.AppConfig) SlowRequestSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.slow)
+	}
+	threshold, _ := SlowRequestSettings(cfg)
+	snapshot := SlowRequestSnapshot{
+		Threshold: threshold.String(),
+		Slow:      make([]SlowRequestEntry, 0, count),
+		Samples:   append([]SlowRequestEntry{}, l.samples...),
+	}
+	for n := 1; n <= count; n++ {
+		snapshot.Slow = append(snapshot.Slow, l.slow[(l.next-n+len(l.slow))%len(l.slow)])
+	}
+	return snapshot
+}
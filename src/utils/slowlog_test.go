@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestPhaseTimingTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &phaseTimingTransport{base: &http.Transport{}}}
+
+	before := UpstreamPhaseHistograms()
+	ctx, timing := WithRequestTiming(context.Background())
+	for range 2 {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/a?x=1", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	phases, upstreams, target := timing.Phases(time.Second)
+	if upstreams != 2 || target != upstream.URL+"/a" {
+		t.Fatalf("upstreams = %d, target = %q", upstreams, target)
+	}
+	if phases.TTFB < 200 || phases.DNS != 0 || phases.TLS != 0 || phases.Transfer > 800 {
+		t.Fatalf("phases = %+v", phases)
+	}
+
+	// 第二次请求复用连接，只有一次connect
+	after := UpstreamPhaseHistograms()
+	if ttfb := after[3].Count - before[3].Count; ttfb != 2 {
+		t.Fatalf("ttfb observations = %d", ttfb)
+	}
+	if connect := after[1].Count - before[1].Count; connect != 1 {
+		t.Fatalf("connect observations = %d", connect)
+	}
+
+	// 没有计时的请求不经过httptrace
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if UpstreamPhaseHistograms()[3].Count != after[3].Count {
+		t.Fatal("untimed request recorded")
+	}
+}
+
+func TestSlowRequestLogSamples(t *testing.T) {
+	log := NewSlowRequestLog()
+	fills := 0
+	fill := func(entry *SlowRequestEntry) {
+		fills++
+		entry.Path = "/fast"
+	}
+	for range 1000 {
+		_, timing := WithRequestTiming(context.Background())
+		log.Observe(timing, time.Millisecond, time.Second, 5, fill)
+	}
+	// 蓄水池抽样只为少数请求生成记录
+	snapshot := log.Snapshot(config.GetConfig())
+	if len(snapshot.Samples) != 5 || len(snapshot.Slow) != 0 || fills > 100 {
+		t.Fatalf("samples = %d, slow = %d, fills = %d", len(snapshot.Samples), len(snapshot.Slow), fills)
+	}
+
+	for i := range slowRequestLogSize + 3 {
+		_, timing := WithRequestTiming(context.Background())
+		log.Observe(timing, time.Duration(i+1)*time.Second, time.Second, 5, func(entry *SlowRequestEntry) {})
+	}
+	slow := log.Snapshot(config.GetConfig()).Slow
+	if len(slow) != slowRequestLogSize || slow[0].DurationMs != float64((slowRequestLogSize+3)*1000) {
+		t.Fatalf("slow = %d, newest = %v", len(slow), slow[0].DurationMs)
+	}
+
+	// 阈值为0时不记录
+	log.Observe(&RequestTiming{}, time.Hour, 0, 5, func(*SlowRequestEntry) { t.Fatal("recorded with threshold 0") })
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// StateVersion 运行时状态快照格式版本，格式不兼容时递增
+const StateVersion = 1
+
+// 状态导入方式
+const (
+	StateImportMerge   = "merge"
+	StateImportReplace = "replace"
+)
+
+// ErrStateVersion 快照版本与当前程序不兼容
+var ErrStateVersion = errors.New("状态快照版本不兼容")
+
+// HistogramState 直方图的稀疏桶计数
+type HistogramState map[int]uint64
+
+// SlotState 时间桶状态，Epoch为-1表示累计数据
+type SlotState struct {
+	Epoch      int64          `json:"epoch"`
+	Duration   HistogramState `json:"duration,omitempty"`
+	Throughput HistogramState `json:"throughput,omitempty"`
+}
+
+// SeriesState 单个统计序列的状态
+type SeriesState struct {
+	Total SlotState   `json:"total"`
+	Slots []SlotState `json:"slots,omitempty"`
+}
+
+// StatsState 统计注册表状态
+type StatsState struct {
+	Summary   SeriesState            `json:"summary"`
+	Routes    map[string]SeriesState `json:"routes"`
+	Upstreams map[string]SeriesState `json:"upstreams"`
+}
+
+// CacheEntryState 缓存项状态
+type CacheEntryState struct {
+	Key         string    `json:"key"`
+	Data        string    `json:"data"`
+	ContentType string    `json:"content_type,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// RuntimeState 运行时状态快照，用于迁移实例时保留统计和缓存
+type RuntimeState struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Stats      *StatsState       `json:"stats,omitempty"`
+	Crawlers   *CrawlerStats     `json:"crawlers,omitempty"`
+	TokenCache []CacheEntryState `json:"token_cache,omitempty"`
+}
+
+func exportHistogram(h *logHistogram) HistogramState {
+	state := make(HistogramState)
+	for i := range h.counts {
+		if count := h.counts[i].Load(); count > 0 {
+			state[i] = count
+		}
+	}
+	return state
+}
+
+func exportSlot(slot *statsSlot, epoch int64) SlotState {
+	return SlotState{
+		Epoch:      epoch,
+		Duration:   exportHistogram(slot.duration),
+		Throughput: exportHistogram(slot.throughput),
+	}
+}
+
+func (s *statsSeries) export() SeriesState {
+	state := SeriesState{Total: exportSlot(s.total, -1)}
+	for _, slot := range s.slots {
+		if epoch := slot.epoch.Load(); epoch >= 0 {
+			state.Slots = append(state.Slots, exportSlot(slot, epoch))
+		}
+	}
+	return state
+}
+
+// ExportState 导出统计注册表状态
+func (r *StatsRegistry) ExportState() *StatsState {
+	state := &StatsState{
+		Summary:   r.summary.export(),
+		Routes:    make(map[string]SeriesState),
+		Upstreams: make(map[string]SeriesState),
+	}
+	r.routes.Range(func(key, value interface{}) bool {
+		state.Routes[key.(string)] = value.(*statsSeries).export()
+		return true
+	})
+	r.upstreams.Range(func(key, value interface{}) bool {
+		state.Upstreams[key.(string)] = value.(*statsSeries).export()
+		return true
+	})
+	return state
+}
+
+func validateHistogram(state HistogramState, h *logHistogram) error {
+	for bucket := range state {
+		if bucket < 0 || bucket >= len(h.counts) {
+			return fmt.Errorf("直方图桶序号越界: %d", bucket)
+		}
+	}
+	return nil
+}
+
+func (s SeriesState) validate() error {
+	reference := newStatsSlot()
+	if len(s.Slots) > statsSlotCount {
+		return fmt.Errorf("时间桶数量过多: %d", len(s.Slots))
+	}
+	for _, slot := range append([]SlotState{s.Total}, s.Slots...) {
+		if err := validateHistogram(slot.Duration, reference.duration); err != nil {
+			return err
+		}
+		if err := validateHistogram(slot.Throughput, reference.throughput); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate 校验统计状态，保证导入前发现问题，不会只导入一半
+func (s *StatsState) validate() error {
+	if err := s.Summary.validate(); err != nil {
+		return err
+	}
+	for _, series := range s.Routes {
+		if err := series.validate(); err != nil {
+			return err
+		}
+	}
+	for _, series := range s.Upstreams {
+		if err := series.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importHistogram(h *logHistogram, state HistogramState) {
+	for bucket, count := range state {
+		h.counts[bucket].Add(count)
+	}
+}
+
+// importState 合并序列状态。时间桶只在对应位置为同一时段或更旧时导入
+func (s *statsSeries) importState(state SeriesState, replace bool) {
+	if replace {
+		s.total.duration.reset()
+		s.total.throughput.reset()
+	}
+	importHistogram(s.total.duration, state.Total.Duration)
+	importHistogram(s.total.throughput, state.Total.Throughput)
+
+	s.rotateMu.Lock()
+	defer s.rotateMu.Unlock()
+
+	if replace {
+		for _, slot := range s.slots {
+			slot.duration.reset()
+			slot.throughput.reset()
+			slot.epoch.Store(-1)
+		}
+	}
+	for _, imported := range state.Slots {
+		if imported.Epoch < 0 {
+			continue
+		}
+		slot := s.slots[imported.Epoch%statsSlotCount]
+		current := slot.epoch.Load()
+		if current > imported.Epoch {
+			continue
+		}
+		if current < imported.Epoch {
+			slot.duration.reset()
+			slot.throughput.reset()
+			slot.epoch.Store(imported.Epoch)
+		}
+		importHistogram(slot.duration, imported.Duration)
+		importHistogram(slot.throughput, imported.Throughput)
+	}
+}
+
+// ImportState 导入统计状态，replace为true时先清空现有统计
+func (r *StatsRegistry) ImportState(state *StatsState, replace bool) {
+	if replace {
+		r.routes.Range(func(key, _ interface{}) bool {
+			r.routes.Delete(key)
+			return true
+		})
+		r.upstreams.Range(func(key, _ interface{}) bool {
+			r.upstreams.Delete(key)
+			return true
+		})
+		r.upstreamCount.Store(0)
+	}
+
+	r.summary.importState(state.Summary, replace)
+	for route, series := range state.Routes {
+		loadSeries(&r.routes, route).importState(series, false)
+	}
+	for host, series := range state.Upstreams {
+		r.upstreamSeries(host).importState(series, false)
+	}
+}
+
+// WriteRuntimeState 以流式JSON写出stats等运行时状态，includeTokens时附带cache中的token缓存，
+// 缓存项逐条编码，不在内存中拼接完整快照
+func WriteRuntimeState(w io.Writer, cache *UniversalCache, stats *StatsRegistry, includeTokens bool) error {
+	stats.stateMu.Lock()
+	defer stats.stateMu.Unlock()
+
+	crawlers := stats.CrawlerStats()
+	header := RuntimeState{
+		Version:    StateVersion,
+		ExportedAt: time.Now().UTC(),
+		Stats:      stats.ExportState(),
+		Crawlers:   &crawlers,
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if !includeTokens {
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+
+	// 去掉结尾的 }，在其后追加 token_cache 数组
+	if _, err := w.Write(data[:len(data)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"token_cache":[`); err != nil {
+		return err
+	}
+
+	first := true
+	now := time.Now()
+	cache.cache.Range(func(key, value interface{}) bool {
+		item := value.(*CachedItem)
+		if !strings.HasPrefix(key.(string), TokenCachePrefix) || !now.Before(item.ExpiresAt) {
+			return true
+		}
+		entry, marshalErr := json.Marshal(CacheEntryState{
+			Key:         key.(string),
+			Data:        string(item.Data),
+			ContentType: item.ContentType,
+			ExpiresAt:   item.ExpiresAt.UTC(),
+		})
+		if marshalErr != nil {
+			err = marshalErr
+			return false
+		}
+		if !first {
+			if _, err = io.WriteString(w, ","); err != nil {
+				return false
+			}
+		}
+		first = false
+		_, err = w.Write(entry)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// ReadRuntimeState 读取并校验运行时状态快照
+func ReadRuntimeState(r io.Reader) (*RuntimeState, error) {
+	var state RuntimeState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, fmt.Errorf("解析状态快照失败: %w", err)
+	}
+	if state.Version != StateVersion {
+		return nil, fmt.Errorf("%w: %d，当前版本 %d", ErrStateVersion, state.Version, StateVersion)
+	}
+	if state.Stats != nil {
+		if err := state.Stats.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range state.TokenCache {
+		if !strings.HasPrefix(entry.Key, TokenCachePrefix) {
+			return nil, fmt.Errorf("无效的缓存key: %s", entry.Key)
+		}
+	}
+	return &state, nil
+}
+
+// ApplyRuntimeState 应用已校验的快照，统计写入stats，token缓存写入cache，mode为merge时累加计数，replace时替换现有状态
+func ApplyRuntimeState(cache *UniversalCache, stats *StatsRegistry, state *RuntimeState, mode string) {
+	stats.stateMu.Lock()
+	defer stats.stateMu.Unlock()
+
+	replace := mode == StateImportReplace
+	if state.Stats != nil {
+		stats.ImportState(state.Stats, replace)
+	}
+	if state.Crawlers != nil {
+		if replace {
+			stats.crawlerBlocked.Store(state.Crawlers.Blocked)
+			stats.crawlerLimited.Store(state.Crawlers.Limited)
+		} else {
+			stats.crawlerBlocked.Add(state.Crawlers.Blocked)
+			stats.crawlerLimited.Add(state.Crawlers.Limited)
+		}
+	}
+
+	if replace && state.TokenCache != nil {
+		cache.Flush(TokenCachePrefix)
+	}
+	now := time.Now()
+	for _, entry := range state.TokenCache {
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if !replace && cache.Get(entry.Key) != nil {
+			continue
+		}
+		cache.Set(entry.Key, []byte(entry.Data), entry.ContentType, nil, entry.ExpiresAt.Sub(now))
+	}
+}
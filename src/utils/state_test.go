@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func resetRuntimeState(t *testing.T) {
+	t.Helper()
+}
+
+func TestRuntimeStateRoundTrip(t *testing.T) {
+	resetRuntimeState(t)
+	stats := NewStatsRegistry()
+
+	for i := 1; i <= 50; i++ {
+		stats.Record("docker", "index.docker.io", 200, time.Duration(i)*time.Millisecond, 0)
+		stats.Record("github", "github.com", 200, time.Duration(i)*10*time.Millisecond, 5_000_000)
+	}
+	stats.crawlerBlocked.Store(7)
+	stats.crawlerLimited.Store(3)
+	tokenKey := BuildTokenCacheKey("scope=repository:library/nginx:pull")
+	cache := NewUniversalCache(t.Context(), testStore())
+	cache.SetToken(tokenKey, `{"token":"abc"}`, time.Hour)
+	cache.Set(BuildManifestCacheKey("nginx", "latest", ""), []byte("{}"), "application/json", nil, time.Hour)
+
+	wantAll := stats.Snapshot(config.GetConfig(), 0)
+	wantWindow := stats.Snapshot(config.GetConfig(), time.Hour)
+
+	var buf bytes.Buffer
+	if err := WriteRuntimeState(&buf, cache, stats, true); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "manifest:") {
+		t.Fatal("manifest cache must not be exported")
+	}
+
+	// 模拟新进程
+	stats = NewStatsRegistry()
+	restored := NewUniversalCache(t.Context(), testStore())
+
+	state, err := ReadRuntimeState(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyRuntimeState(restored, stats, state, StateImportMerge)
+
+	if got := stats.Snapshot(config.GetConfig(), 0); !reflect.DeepEqual(got.Routes, wantAll.Routes) ||
+		!reflect.DeepEqual(got.Upstreams, wantAll.Upstreams) || got.Summary != wantAll.Summary {
+		t.Fatalf("cumulative stats differ after import:\n got %+v\nwant %+v", got, wantAll)
+	}
+	if got := stats.Snapshot(config.GetConfig(), time.Hour); !reflect.DeepEqual(got.Routes, wantWindow.Routes) {
+		t.Fatalf("window stats differ after import:\n got %+v\nwant %+v", got.Routes, wantWindow.Routes)
+	}
+	if stats := stats.CrawlerStats(); stats.Blocked != 7 || stats.Limited != 3 {
+		t.Fatalf("crawler counters = %+v", stats)
+	}
+	if restored.GetToken(tokenKey) != `{"token":"abc"}` {
+		t.Fatal("token cache not restored")
+	}
+
+	// 再次合并计数翻倍，替换则恢复原值
+	ApplyRuntimeState(restored, stats, state, StateImportMerge)
+	if got := stats.Snapshot(config.GetConfig(), 0).Summary.Count; got != 2*wantAll.Summary.Count {
+		t.Fatalf("merged count = %d, want %d", got, 2*wantAll.Summary.Count)
+	}
+	ApplyRuntimeState(restored, stats, state, StateImportReplace)
+	if got := stats.Snapshot(config.GetConfig(), 0).Summary.Count; got != wantAll.Summary.Count {
+		t.Fatalf("replaced count = %d, want %d", got, wantAll.Summary.Count)
+	}
+	if stats := stats.CrawlerStats(); stats.Blocked != 7 {
+		t.Fatalf("replaced crawler counters = %+v", stats)
+	}
+}
+
+func TestReadRuntimeStateValidation(t *testing.T) {
+	resetRuntimeState(t)
+
+	if _, err := ReadRuntimeState(strings.NewReader(`{"version":99}`)); !errors.Is(err, ErrStateVersion) {
+		t.Fatalf("version mismatch err = %v", err)
+	}
+	if _, err := ReadRuntimeState(strings.NewReader(`{"version":1,"stats":{"summary":{"total":{"epoch":-1,"duration":{"100000":1}}}}}`)); err == nil {
+		t.Fatal("out of range bucket accepted")
+	}
+	if _, err := ReadRuntimeState(strings.NewReader(`{"version":1,"token_cache":[{"key":"manifest:x","data":"{}"}]}`)); err == nil {
+		t.Fatal("non-token cache key accepted")
+	}
+	if _, err := ReadRuntimeState(strings.NewReader(`not json`)); err == nil {
+		t.Fatal("invalid json accepted")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRuntimeState(&buf, NewUniversalCache(t.Context(), testStore()), NewStatsRegistry(), false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "token_cache") {
+		t.Fatal("token cache exported without tokens=true")
+	}
+}
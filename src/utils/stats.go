@@ -0,0 +1,491 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+const (
+	// statsSlotDuration 滑动窗口的单个桶时长，statsSlotCount个桶轮转覆盖StatsMaxWindow
+	statsSlotDuration = 5 * time.Minute
+	statsSlotCount    = 12
+	StatsMaxWindow    = statsSlotDuration * statsSlotCount
+
+	// histogramRatio 相邻直方图桶的比例，分位数相对误差不超过约5%
+	histogramRatio = 1.1
+
+	// maxUpstreamSeries 上游主机维度的序列上限，超出后计入 other
+	maxUpstreamSeries = 64
+	// statsThroughputMinBytes 小于该大小的响应不计入吞吐量，避免小请求的噪声
+	statsThroughputMinBytes = 256 * 1024
+
+	StatsOtherUpstream = "other"
+)
+
+// logHistogram 对数分桶直方图，记录只做原子加法
+type logHistogram struct {
+	min      float64
+	ratio    float64
+	logRatio float64
+	counts   []atomic.Uint64
+}
+
+func newLogHistogram(min, max float64) *logHistogram {
+	return newLogHistogramRatio(min, max, histogramRatio)
+}
+
+// newLogHistogramRatio 按指定的相邻桶比例创建直方图，比例越大桶越少、分位数越粗略
+func newLogHistogramRatio(min, max, ratio float64) *logHistogram {
+	logRatio := math.Log(ratio)
+	n := int(math.Ceil(math.Log(max/min)/logRatio)) + 1
+	return &logHistogram{min: min, ratio: ratio, logRatio: logRatio, counts: make([]atomic.Uint64, n)}
+}
+
+// bucket 桶i覆盖[min*r^i, min*r^(i+1))，小于min的值计入首个桶，超出上限计入最后一个桶
+func (h *logHistogram) bucket(v float64) int {
+	if v <= h.min {
+		return 0
+	}
+	return min(int(math.Log(v/h.min)/h.logRatio), len(h.counts)-1)
+}
+
+func (h *logHistogram) record(v float64) {
+	h.counts[h.bucket(v)].Add(1)
+}
+
+func (h *logHistogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+}
+
+// addTo 将计数累加到dst
+func (h *logHistogram) addTo(dst []uint64) {
+	for i := range h.counts {
+		dst[i] += h.counts[i].Load()
+	}
+}
+
+// histogramPercentiles 按累计计数计算分位数，返回所在桶的几何中值
+func histogramPercentiles(counts []uint64, minValue, ratio float64, quantiles ...float64) ([]float64, uint64) {
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+	results := make([]float64, len(quantiles))
+	if total == 0 {
+		return results, 0
+	}
+
+	for qi, q := range quantiles {
+		rank := uint64(math.Ceil(q * float64(total)))
+		if rank == 0 {
+			rank = 1
+		}
+		var cumulative uint64
+		for i, count := range counts {
+			cumulative += count
+			if cumulative >= rank {
+				if i == 0 {
+					results[qi] = minValue
+				} else {
+					results[qi] = minValue * math.Pow(ratio, float64(i)+0.5)
+				}
+				break
+			}
+		}
+	}
+	return results, total
+}
+
+// statsSlot 单个时间桶内的耗时和吞吐量直方图
+type statsSlot struct {
+	epoch      atomic.Int64
+	duration   *logHistogram
+	throughput *logHistogram
+}
+
+func newStatsSlot() *statsSlot {
+	slot := &statsSlot{
+		duration:   newLogHistogram(0.1, float64(time.Hour/time.Millisecond)),
+		throughput: newLogHistogram(0.001, 100000),
+	}
+	slot.epoch.Store(-1)
+	return slot
+}
+
+func (s *statsSlot) record(duration time.Duration, bytes int64) {
+	s.duration.record(float64(duration) / float64(time.Millisecond))
+	if bytes >= statsThroughputMinBytes && duration > 0 {
+		s.throughput.record(float64(bytes) / 1e6 / duration.Seconds())
+	}
+}
+
+// statsSeries 单个维度的统计：累计直方图、轮转的时间桶和可用性
+type statsSeries struct {
+	rotateMu     sync.Mutex
+	total        *statsSlot
+	slots        [statsSlotCount]*statsSlot
+	availability *availabilitySeries
+}
+
+func newStatsSeries() *statsSeries {
+	series := &statsSeries{total: newStatsSlot(), availability: newAvailabilitySeries()}
+	for i := range series.slots {
+		series.slots[i] = newStatsSlot()
+	}
+	return series
+}
+
+// slot 返回epoch对应的时间桶，桶过期时在锁内清零后复用
+func (s *statsSeries) slot(epoch int64) *statsSlot {
+	slot := s.slots[epoch%statsSlotCount]
+	if slot.epoch.Load() == epoch {
+		return slot
+	}
+
+	s.rotateMu.Lock()
+	if slot.epoch.Load() != epoch {
+		slot.duration.reset()
+		slot.throughput.reset()
+		slot.epoch.Store(epoch)
+	}
+	s.rotateMu.Unlock()
+	return slot
+}
+
+func (s *statsSeries) record(now time.Time, duration time.Duration, bytes int64, failed bool) {
+	s.total.record(duration, bytes)
+	s.slot(statsEpoch(now)).record(duration, bytes)
+	s.availability.record(now, duration, failed)
+}
+
+func statsEpoch(t time.Time) int64 {
+	return t.UnixNano() / int64(statsSlotDuration)
+}
+
+// PercentileSummary 分位数统计
+type PercentileSummary struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// SeriesStats 单个维度的统计结果
+type SeriesStats struct {
+	Count             uint64            `json:"count"`
+	DurationMs        PercentileSummary `json:"duration_ms"`
+	ThroughputSamples uint64            `json:"throughput_samples"`
+	ThroughputMBps    PercentileSummary `json:"throughput_mbps"`
+}
+
+// snapshot 汇总window内的时间桶，window为0时使用累计数据
+func (s *statsSeries) snapshot(now time.Time, window time.Duration) SeriesStats {
+	durations := make([]uint64, len(s.total.duration.counts))
+	throughputs := make([]uint64, len(s.total.throughput.counts))
+
+	if window <= 0 {
+		s.total.duration.addTo(durations)
+		s.total.throughput.addTo(throughputs)
+	} else {
+		current := statsEpoch(now)
+		slots := int64(min((window+statsSlotDuration-1)/statsSlotDuration, statsSlotCount))
+		for _, slot := range s.slots {
+			if epoch := slot.epoch.Load(); epoch > current-slots && epoch <= current {
+				slot.duration.addTo(durations)
+				slot.throughput.addTo(throughputs)
+			}
+		}
+	}
+
+	var stats SeriesStats
+	d, count := histogramPercentiles(durations, s.total.duration.min, s.total.duration.ratio, 0.5, 0.9, 0.99)
+	stats.Count = count
+	stats.DurationMs = PercentileSummary{P50: roundStat(d[0]), P90: roundStat(d[1]), P99: roundStat(d[2])}
+	tp, samples := histogramPercentiles(throughputs, s.total.throughput.min, s.total.throughput.ratio, 0.5, 0.9, 0.99)
+	stats.ThroughputSamples = samples
+	stats.ThroughputMBps = PercentileSummary{P50: roundStat(tp[0]), P90: roundStat(tp[1]), P99: roundStat(tp[2])}
+	return stats
+}
+
+func roundStat(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// StatsRegistry 请求耗时和吞吐量统计，按路由类别和上游主机分别记录
+type StatsRegistry struct {
+	summary       *statsSeries
+	routes        sync.Map
+	upstreams     sync.Map
+	upstreamCount atomic.Int32
+	traffic       sync.Map
+	tokens        tokenCounters
+	redirects     sync.Map
+
+	healthMu sync.Mutex
+	health   *HealthSummary
+	healthAt time.Time
+
+	// crawlerBlocked、crawlerLimited 爬虫拦截计数
+	crawlerBlocked atomic.Int64
+	crawlerLimited atomic.Int64
+	// stateMu 保证运行时状态的导入与导出互斥，避免导出到一半的状态
+	stateMu sync.Mutex
+}
+
+// NewStatsRegistry 创建统计注册表
+func NewStatsRegistry() *StatsRegistry {
+	return &StatsRegistry{summary: newStatsSeries()}
+}
+
+// StatsSnapshot 统计快照
+type StatsSnapshot struct {
+	// Time 生成快照的时间，按server.timezone显示，TimeUnix为对应的Unix时间戳
+	Time      string                 `json:"time"`
+	TimeUnix  int64                  `json:"time_unix"`
+	Window    string                 `json:"window"`
+	Summary   SeriesStats            `json:"summary"`
+	Routes    map[string]SeriesStats `json:"routes"`
+	Upstreams map[string]SeriesStats `json:"upstreams"`
+	// Traffic 按路由类别和缓存结果统计的流量，始终为启动以来的累计数据
+	Traffic map[string]map[string]TrafficStats `json:"traffic"`
+	// Tokens 认证token的获取方式统计，始终为启动以来的累计数据
+	Tokens TokenStats `json:"tokens"`
+	// Redirects 按发出重定向的上游主机统计的重定向次数，始终为启动以来的累计数据
+	Redirects map[string]uint64 `json:"redirects"`
+	// Schedule 重任务的时段和当日预算用量，未启用调度时省略
+	Schedule *ScheduleStats `json:"schedule,omitempty"`
+	// AdaptiveLimit 自适应限流的当前倍数和出站负载，未启用时省略
+	AdaptiveLimit *AdaptiveLimitStats `json:"adaptive_limit,omitempty"`
+	// UpstreamBudgets 各上游主机的出站请求预算使用情况，未启用出站限制时省略
+	UpstreamBudgets []UpstreamBudgetStats `json:"upstream_budgets,omitempty"`
+}
+
+// token获取方式
+const (
+	TokenFetchSync         = "sync"
+	TokenFetchRefreshAhead = "refresh_ahead"
+	TokenFetchPrefetch     = "prefetch"
+)
+
+// TokenStats 客户端等待的同步获取次数，与后台提前刷新、预取的次数和节省的等待次数
+type TokenStats struct {
+	SyncFetches    uint64 `json:"sync_fetches"`
+	RefreshAhead   uint64 `json:"refresh_ahead"`
+	Prefetches     uint64 `json:"prefetches"`
+	RefreshFailed  uint64 `json:"refresh_failed"`
+	RefreshedSaves uint64 `json:"refreshed_saves"`
+}
+
+type tokenCounters struct {
+	sync          atomic.Uint64
+	refreshAhead  atomic.Uint64
+	prefetch      atomic.Uint64
+	refreshFailed atomic.Uint64
+	saves         atomic.Uint64
+}
+
+// RecordTokenFetch 记录一次从上游获取token，mode为TokenFetch*之一
+func (r *StatsRegistry) RecordTokenFetch(mode string) {
+	switch mode {
+	case TokenFetchSync:
+		r.tokens.sync.Add(1)
+	case TokenFetchRefreshAhead:
+		r.tokens.refreshAhead.Add(1)
+	case TokenFetchPrefetch:
+		r.tokens.prefetch.Add(1)
+	}
+}
+
+// RecordTokenRefreshFailed 记录一次后台刷新或预取失败
+func (r *StatsRegistry) RecordTokenRefreshFailed() {
+	r.tokens.refreshFailed.Add(1)
+}
+
+// RecordTokenRefreshedSave 记录一次命中后台刷新的token，没有后台刷新时该请求需要同步获取
+func (r *StatsRegistry) RecordTokenRefreshedSave() {
+	r.tokens.saves.Add(1)
+}
+
+// TokenSnapshot 返回token获取方式统计
+func (r *StatsRegistry) TokenSnapshot() TokenStats {
+	return TokenStats{
+		SyncFetches:    r.tokens.sync.Load(),
+		RefreshAhead:   r.tokens.refreshAhead.Load(),
+		Prefetches:     r.tokens.prefetch.Load(),
+		RefreshFailed:  r.tokens.refreshFailed.Load(),
+		RefreshedSaves: r.tokens.saves.Load(),
+	}
+}
+
+// RecordRedirect 记录一次由host发出的上游重定向
+func (r *StatsRegistry) RecordRedirect(host string) {
+	counter, ok := r.redirects.Load(host)
+	if !ok {
+		counter, _ = r.redirects.LoadOrStore(host, &atomic.Uint64{})
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// RedirectSnapshot 返回按上游主机统计的重定向次数
+func (r *StatsRegistry) RedirectSnapshot() map[string]uint64 {
+	snapshot := make(map[string]uint64)
+	r.redirects.Range(func(key, value interface{}) bool {
+		snapshot[key.(string)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+	return snapshot
+}
+
+// TrafficStats 返回给客户端的字节数和从上游读取的字节数
+type TrafficStats struct {
+	Requests        uint64 `json:"requests"`
+	DownstreamBytes uint64 `json:"downstream_bytes"`
+	UpstreamBytes   uint64 `json:"upstream_bytes"`
+}
+
+// trafficKey 流量计数的维度，路由类别和缓存结果的取值都是有限的
+type trafficKey struct {
+	route   string
+	outcome string
+}
+
+type trafficCounters struct {
+	requests   atomic.Uint64
+	downstream atomic.Uint64
+	upstream   atomic.Uint64
+}
+
+func (r *StatsRegistry) trafficCounters(route, outcome string) *trafficCounters {
+	key := trafficKey{route: route, outcome: outcome}
+	if counters, ok := r.traffic.Load(key); ok {
+		return counters.(*trafficCounters)
+	}
+	counters, _ := r.traffic.LoadOrStore(key, &trafficCounters{})
+	return counters.(*trafficCounters)
+}
+
+// RecordTraffic 记录一次已完成请求的下行和上游流量
+func (r *StatsRegistry) RecordTraffic(route, outcome string, downstream, upstream int64) {
+	counters := r.trafficCounters(route, outcome)
+	counters.requests.Add(1)
+	if downstream > 0 {
+		counters.downstream.Add(uint64(downstream))
+	}
+	if upstream > 0 {
+		counters.upstream.Add(uint64(upstream))
+	}
+}
+
+// AddUpstreamBytes 记录不计入请求数的上游流量，用于后台刷新等请求结束后才读取的数据
+func (r *StatsRegistry) AddUpstreamBytes(route, outcome string, n int64) {
+	if n > 0 {
+		r.trafficCounters(route, outcome).upstream.Add(uint64(n))
+	}
+}
+
+// TrafficSnapshot 返回按路由类别和缓存结果分组的累计流量
+func (r *StatsRegistry) TrafficSnapshot() map[string]map[string]TrafficStats {
+	traffic := make(map[string]map[string]TrafficStats)
+	r.traffic.Range(func(key, value interface{}) bool {
+		k, counters := key.(trafficKey), value.(*trafficCounters)
+		if traffic[k.route] == nil {
+			traffic[k.route] = make(map[string]TrafficStats)
+		}
+		traffic[k.route][k.outcome] = TrafficStats{
+			Requests:        counters.requests.Load(),
+			DownstreamBytes: counters.downstream.Load(),
+			UpstreamBytes:   counters.upstream.Load(),
+		}
+		return true
+	})
+	return traffic
+}
+
+// DownstreamBytes 返回启动以来返回给客户端的总字节数
+func (r *StatsRegistry) DownstreamBytes() uint64 {
+	var total uint64
+	r.traffic.Range(func(_, value interface{}) bool {
+		total += value.(*trafficCounters).downstream.Load()
+		return true
+	})
+	return total
+}
+
+func loadSeries(m *sync.Map, key string) *statsSeries {
+	if series, ok := m.Load(key); ok {
+		return series.(*statsSeries)
+	}
+	series, _ := m.LoadOrStore(key, newStatsSeries())
+	return series.(*statsSeries)
+}
+
+// upstreamSeries 上游主机序列数量有上限，保证内存占用与流量无关
+func (r *StatsRegistry) upstreamSeries(host string) *statsSeries {
+	if series, ok := r.upstreams.Load(host); ok {
+		return series.(*statsSeries)
+	}
+	if host != StatsOtherUpstream && r.upstreamCount.Add(1) > maxUpstreamSeries {
+		r.upstreamCount.Add(-1)
+		host = StatsOtherUpstream
+	}
+	series, loaded := r.upstreams.LoadOrStore(host, newStatsSeries())
+	if loaded && host != StatsOtherUpstream {
+		r.upstreamCount.Add(-1)
+	}
+	return series.(*statsSeries)
+}
+
+// Record 记录一次已完成的请求，upstream为空时只计入路由和汇总，5xx状态码计为失败
+func (r *StatsRegistry) Record(route, upstream string, status int, duration time.Duration, bytes int64) {
+	r.recordAt(time.Now(), route, upstream, status, duration, bytes)
+}
+
+func (r *StatsRegistry) recordAt(now time.Time, route, upstream string, status int, duration time.Duration, bytes int64) {
+	failed := status >= 500
+	r.summary.record(now, duration, bytes, failed)
+	if route != "" {
+		loadSeries(&r.routes, route).record(now, duration, bytes, failed)
+	}
+	if upstream != "" {
+		r.upstreamSeries(upstream).record(now, duration, bytes, failed)
+	}
+}
+
+// Snapshot 返回统计快照，时间按cfg的显示时区格式化，window为0时返回启动以来的累计数据，最大为StatsMaxWindow
+func (r *StatsRegistry) Snapshot(cfg *config.AppConfig, window time.Duration) StatsSnapshot {
+	now := time.Now()
+	snapshot := r.snapshotAt(now, window)
+	snapshot.Time = FormatDisplayTime(cfg, now)
+	return snapshot
+}
+
+func (r *StatsRegistry) snapshotAt(now time.Time, window time.Duration) StatsSnapshot {
+	snapshot := StatsSnapshot{
+		TimeUnix:  now.Unix(),
+		Window:    "all",
+		Summary:   r.summary.snapshot(now, window),
+		Routes:    make(map[string]SeriesStats),
+		Upstreams: make(map[string]SeriesStats),
+		Traffic:   r.TrafficSnapshot(),
+		Tokens:    r.TokenSnapshot(),
+		Redirects: r.RedirectSnapshot(),
+	}
+	if window > 0 {
+		snapshot.Window = window.String()
+	}
+
+	r.routes.Range(func(key, value interface{}) bool {
+		snapshot.Routes[key.(string)] = value.(*statsSeries).snapshot(now, window)
+		return true
+	})
+	r.upstreams.Range(func(key, value interface{}) bool {
+		snapshot.Upstreams[key.(string)] = value.(*statsSeries).snapshot(now, window)
+		return true
+	})
+	return snapshot
+}
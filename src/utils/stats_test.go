@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func withinTolerance(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= want*tolerance
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	registry := NewStatsRegistry()
+	now := time.Now()
+
+	// 1ms到1000ms均匀分布
+	for i := 1; i <= 1000; i++ {
+		registry.recordAt(now, "docker", "", 200, time.Duration(i)*time.Millisecond, 0)
+	}
+
+	stats := registry.snapshotAt(now, 0).Routes["docker"]
+	if stats.Count != 1000 {
+		t.Fatalf("count = %d, want 1000", stats.Count)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"p50", stats.DurationMs.P50, 500},
+		{"p90", stats.DurationMs.P90, 900},
+		{"p99", stats.DurationMs.P99, 990},
+	} {
+		if !withinTolerance(tt.got, tt.want, 0.06) {
+			t.Errorf("%s = %v, want %v ±6%%", tt.name, tt.got, tt.want)
+		}
+	}
+	if stats.ThroughputSamples != 0 {
+		t.Fatalf("small responses counted in throughput: %d", stats.ThroughputSamples)
+	}
+}
+
+func TestThroughputPercentiles(t *testing.T) {
+	registry := NewStatsRegistry()
+	now := time.Now()
+
+	// 10MB响应，耗时100ms到1s，对应10到100 MB/s
+	for i := 1; i <= 10; i++ {
+		for j := 0; j < 10; j++ {
+			registry.recordAt(now, "github", "github.com", 200, time.Duration(i)*100*time.Millisecond, 10_000_000)
+		}
+	}
+
+	stats := registry.snapshotAt(now, 0).Upstreams["github.com"]
+	if stats.ThroughputSamples != 100 {
+		t.Fatalf("throughput samples = %d, want 100", stats.ThroughputSamples)
+	}
+	// 排序后的吞吐量: 10, 11.1, 12.5, 14.3, 16.7, 20, 25, 33.3, 50, 100
+	if !withinTolerance(stats.ThroughputMBps.P50, 16.7, 0.06) {
+		t.Errorf("p50 = %v, want ~16.7", stats.ThroughputMBps.P50)
+	}
+	if !withinTolerance(stats.ThroughputMBps.P99, 100, 0.06) {
+		t.Errorf("p99 = %v, want ~100", stats.ThroughputMBps.P99)
+	}
+}
+
+func TestStatsWindowRotation(t *testing.T) {
+	registry := NewStatsRegistry()
+	base := time.Unix(0, 0).Add(1000 * statsSlotDuration)
+
+	registry.recordAt(base, "docker", "", 200, 10*time.Millisecond, 0)
+	registry.recordAt(base.Add(30*time.Minute), "docker", "", 200, 100*time.Millisecond, 0)
+	registry.recordAt(base.Add(59*time.Minute), "docker", "", 200, 1000*time.Millisecond, 0)
+
+	now := base.Add(59 * time.Minute)
+	if got := registry.snapshotAt(now, time.Hour).Routes["docker"].Count; got != 3 {
+		t.Fatalf("1h window count = %d, want 3", got)
+	}
+	if got := registry.snapshotAt(now, 5*time.Minute).Routes["docker"].Count; got != 1 {
+		t.Fatalf("5m window count = %d, want 1", got)
+	}
+
+	// 超过一小时后最早的桶被复用清零
+	later := base.Add(61 * time.Minute)
+	registry.recordAt(later, "docker", "", 200, 1000*time.Millisecond, 0)
+	if got := registry.snapshotAt(later, time.Hour).Routes["docker"].Count; got != 3 {
+		t.Fatalf("rotated 1h window count = %d, want 3", got)
+	}
+	if got := registry.snapshotAt(later, 0).Routes["docker"].Count; got != 4 {
+		t.Fatalf("cumulative count = %d, want 4", got)
+	}
+}
+
+func TestStatsUpstreamSeriesBounded(t *testing.T) {
+	registry := NewStatsRegistry()
+	now := time.Now()
+	for i := 0; i < maxUpstreamSeries+20; i++ {
+		registry.recordAt(now, "github", string(rune('a'+i%26))+time.Duration(i).String(), 200, time.Millisecond, 0)
+	}
+
+	snapshot := registry.snapshotAt(now, 0)
+	if len(snapshot.Upstreams) != maxUpstreamSeries+1 {
+		t.Fatalf("upstream series = %d, want %d", len(snapshot.Upstreams), maxUpstreamSeries+1)
+	}
+	if got := snapshot.Upstreams[StatsOtherUpstream].Count; got != 20 {
+		t.Fatalf("other count = %d, want 20", got)
+	}
+	if snapshot.Summary.Count != uint64(maxUpstreamSeries+20) {
+		t.Fatalf("summary count = %d", snapshot.Summary.Count)
+	}
+}
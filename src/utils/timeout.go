@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// JSON接口的超时分组，对应apiTimeouts配置中的各项
+const (
+	APITimeoutSearch = "search"
+	APITimeoutStats  = "stats"
+	APITimeoutGitHub = "github"
+	APITimeoutVerify = "verify"
+)
+
+// APITimeout 返回cfg中分组的处理时限，未配置或为0时不限制
+func APITimeout(cfg *config.AppConfig, group string) time.Duration {
+	timeouts := cfg.APITimeouts
+	var value string
+	switch group {
+	case APITimeoutSearch:
+		value = timeouts.Search
+	case APITimeoutStats:
+		value = timeouts.Stats
+	case APITimeoutGitHub:
+		value = timeouts.GitHub
+	case APITimeoutVerify:
+		value = timeouts.Verify
+	}
+	return ParseTimeout(value, 0)
+}
+
+// bufferedResponseWriter 暂存处理函数写出的状态码、响应头和响应体，
+// 超时后整体丢弃，未超时时由flush原样写出
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	header  http.Header
+	body    bytes.Buffer
+	status  int
+	written bool
+}
+
+func newBufferedResponseWriter(w gin.ResponseWriter) *bufferedResponseWriter {
+	return &bufferedResponseWriter{ResponseWriter: w, header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return w.written
+}
+
+// Flush 响应在处理结束后才整体写出，中途刷新没有意义
+func (w *bufferedResponseWriter) Flush() {}
+
+// flush 将暂存的响应写入底层ResponseWriter
+func (w *bufferedResponseWriter) flush() {
+	header := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() == 0 {
+		return
+	}
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		fmt.Printf("写出响应失败: %v\n", err)
+	}
+}
+
+// APITimeoutMiddleware 为JSON接口设置处理时限：请求上下文在时限到达时取消，处理函数基于请求上下文
+// 发起的上游请求随之中止；超时后丢弃处理函数已生成的响应，返回504。响应在内存中暂存，
+// 不能用于镜像、文件代理和事件流等流式接口。时限每次请求时从store读取
+func APITimeoutMiddleware(store *config.Store, group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := APITimeout(store.Get(), group)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		original := c.Writer
+		buffered := newBufferedResponseWriter(original)
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			fmt.Printf("请求 %s 超过处理时限 %s，已取消\n", c.Request.URL.Path, timeout)
+			RespondError(c, http.StatusGatewayTimeout, ErrCodeRequestTimeout, timeout.String())
+			return
+		}
+		buffered.flush()
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// waitForGoroutines 等待goroutine数量回落到baseline以内，超时返回当前数量
+func waitForGoroutines(baseline int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPITimeoutMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadTestConfig(t, "[apiTimeouts]\nsearch = \"50ms\"\nstats = \"0\"\n")
+
+	router := gin.New()
+	router.GET("/slow", APITimeoutMiddleware(testStore(), APITimeoutSearch), func(c *gin.Context) {
+		// 模拟基于请求上下文的上游调用，上下文取消后返回自己的错误响应
+		select {
+		case <-c.Request.Context().Done():
+			c.Header("X-Handler", "slow")
+			c.JSON(http.StatusBadGateway, gin.H{"error": c.Request.Context().Err().Error()})
+		case <-time.After(5 * time.Second):
+			c.String(http.StatusOK, "too late")
+		}
+	})
+	router.GET("/empty", APITimeoutMiddleware(testStore(), APITimeoutSearch), func(c *gin.Context) {
+		c.Status(http.StatusAccepted)
+	})
+	router.GET("/fast", APITimeoutMiddleware(testStore(), APITimeoutSearch), func(c *gin.Context) {
+		c.Header("X-Handler", "fast")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	router.GET("/unlimited", APITimeoutMiddleware(testStore(), APITimeoutStats), func(c *gin.Context) {
+		if _, ok := c.Request.Context().Deadline(); ok {
+			t.Error("deadline set with timeout disabled")
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	baseline := runtime.NumGoroutine()
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusGatewayTimeout || body.Code != ErrCodeRequestTimeout {
+		t.Fatalf("timeout response %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Handler") != "" {
+		t.Fatal("headers from the cancelled handler leaked into the 504 response")
+	}
+	if n := waitForGoroutines(baseline); n > baseline {
+		t.Fatalf("goroutines after timeout: %d, baseline %d", n, baseline)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusCreated || w.Header().Get("X-Handler") != "fast" || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("buffered response %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/empty", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Fatalf("status-only response %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unlimited", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unlimited status %d", w.Code)
+	}
+}
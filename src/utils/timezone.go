@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// DisplayTimeLayout 面向用户的时间格式，机器读取应使用同时返回的Unix时间戳
+const DisplayTimeLayout = "2006-01-02 15:04:05 MST"
+
+// loadedLocations 已加载的时区，按名称缓存，配置重载后新的名称在首次使用时加载
+var loadedLocations sync.Map
+
+// cachedLocation 返回名称对应的时区，为空时使用系统本地时区，无法加载时使用UTC
+func cachedLocation(name string) *time.Location {
+	if loc, ok := loadedLocations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := config.LoadTimezone(name)
+	if err != nil {
+		fmt.Printf("时区 %s 无效，使用UTC: %v\n", name, err)
+		loc = time.UTC
+	}
+	loadedLocations.Store(name, loc)
+	return loc
+}
+
+// DisplayLocation 返回cfg中server.timezone配置的显示时区，未配置时为系统本地时区
+func DisplayLocation(cfg *config.AppConfig) *time.Location {
+	return cachedLocation(cfg.Server.Timezone)
+}
+
+// FormatDisplayTime 按cfg的显示时区格式化时间
+func FormatDisplayTime(cfg *config.AppConfig, t time.Time) string {
+	return t.In(DisplayLocation(cfg)).Format(DisplayTimeLayout)
+}
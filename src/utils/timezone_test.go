@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestFormatDisplayTime(t *testing.T) {
+	instant := time.Date(2026, 1, 15, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"UTC", "2026-01-15 12:30:00 UTC"},
+		{"America/New_York", "2026-01-15 07:30:00 EST"},
+		{"Asia/Shanghai", "2026-01-15 20:30:00 CST"},
+	}
+	for _, tt := range tests {
+		loadTestConfig(t, "[server]\ntimezone = \""+tt.zone+"\"\n")
+		if got := FormatDisplayTime(config.GetConfig(), instant); got != tt.want {
+			t.Errorf("zone %s: %q, want %q", tt.zone, got, tt.want)
+		}
+	}
+
+	loadTestConfig(t, "")
+	if DisplayLocation(config.GetConfig()) != time.Local {
+		t.Fatalf("default zone = %s, want system local", DisplayLocation(config.GetConfig()))
+	}
+}
+
+func TestDisplayLocationFollowsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CONFIG_PATH", path)
+	write("[server]\ntimezone = \"UTC\"\n\n[schedule]\nquietHours = [\"00:00-06:00\"]\n")
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// UTC 03:00 在时段内
+	instant := time.Date(2026, 7, 1, 3, 0, 0, 0, time.UTC)
+	if DisplayLocation(config.GetConfig()) != time.UTC || !scheduleInWindow(instant) {
+		t.Fatalf("zone %s, in window %v", DisplayLocation(config.GetConfig()), scheduleInWindow(instant))
+	}
+
+	// 重载为负偏移时区后，同一时刻为前一天23:00，不在时段内
+	write("[server]\ntimezone = \"America/Los_Angeles\"\n\n[schedule]\nquietHours = [\"00:00-06:00\"]\n")
+	if err := config.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatDisplayTime(config.GetConfig(), instant); got != "2026-06-30 20:00:00 PDT" {
+		t.Fatalf("after reload: %s", got)
+	}
+	if scheduleInWindow(instant) {
+		t.Fatal("quiet hours not evaluated in reloaded zone")
+	}
+}
+
+// scheduleInWindow 判断给定时刻是否处于配置的重任务时段
+func scheduleInWindow(now time.Time) bool {
+	return NewHeavySchedule(testStore()).snapshotAt(now, config.GetConfig()).InWindow
+}
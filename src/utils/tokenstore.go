@@ -0,0 +1,399 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// 令牌校验与限额错误
+var (
+	ErrAPITokenInvalid      = errors.New("令牌无效")
+	ErrAPITokenDisabled     = errors.New("令牌已吊销")
+	ErrAPITokenExpired      = errors.New("令牌已过期")
+	ErrAPITokenExists       = errors.New("令牌名称已存在")
+	ErrAPITokenNotFound     = errors.New("令牌不存在")
+	ErrAPITokenQuota        = errors.New("本月流量配额已用完")
+	ErrAPITokenRequestLimit = errors.New("请求次数超过每小时限制")
+)
+
+// apiTokenPrefix 令牌前缀，便于在日志和配置中识别
+const apiTokenPrefix = "hp_"
+
+// tokenFlushInterval 用量写盘间隔
+const tokenFlushInterval = 30 * time.Second
+
+// TokenUsage 令牌当月用量
+type TokenUsage struct {
+	Month    string `json:"month"`
+	Bytes    int64  `json:"bytes"`
+	Requests int64  `json:"requests"`
+}
+
+// APIToken 命名令牌，只保存令牌的SHA-256摘要
+type APIToken struct {
+	Name           string     `json:"name"`
+	Hash           string     `json:"hash"`
+	MonthlyBytes   int64      `json:"monthly_bytes"`
+	HourlyRequests int        `json:"hourly_requests"`
+	ExpiresAt      time.Time  `json:"expires_at,omitempty"`
+	Enabled        bool       `json:"enabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Usage          TokenUsage `json:"usage"`
+}
+
+// TokenView 令牌信息，不含摘要，用于接口返回
+type TokenView struct {
+	Name           string     `json:"name"`
+	MonthlyBytes   int64      `json:"monthly_bytes"`
+	HourlyRequests int        `json:"hourly_requests"`
+	ExpiresAt      time.Time  `json:"expires_at,omitempty"`
+	Enabled        bool       `json:"enabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Usage          TokenUsage `json:"usage"`
+	RemainingBytes int64      `json:"remaining_bytes"`
+	HourlyUsed     int        `json:"hourly_used"`
+}
+
+// hourWindow 每小时请求计数，仅保存在内存中
+type hourWindow struct {
+	hour  int64
+	count int
+}
+
+// TokenStore 命名令牌库，保存在JSON文件中，用量定期写盘
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[string]*APIToken
+	hourly map[string]*hourWindow
+	dirty  bool
+}
+
+// HashAPIToken 计算令牌摘要，令牌为高熵随机值，使用SHA-256即可
+func HashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func currentMonth(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// LoadTokenStore 从文件加载令牌库，文件不存在时返回空库
+func LoadTokenStore(path string) (*TokenStore, error) {
+	store := &TokenStore{
+		path:   path,
+		tokens: make(map[string]*APIToken),
+		hourly: make(map[string]*hourWindow),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("解析令牌文件失败: %w", err)
+	}
+	for _, token := range tokens {
+		store.tokens[token.Hash] = token
+	}
+	return store, nil
+}
+
+// saveLocked 原子写入令牌文件，调用方需持有锁
+func (s *TokenStore) saveLocked() error {
+	tokens := make([]*APIToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Flush 将未保存的用量写盘
+func (s *TokenStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+func (s *TokenStore) findByName(name string) *APIToken {
+	for _, token := range s.tokens {
+		if token.Name == name {
+			return token
+		}
+	}
+	return nil
+}
+
+// Create 创建令牌，返回的原始令牌只在此时可见
+func (s *TokenStore) Create(name string, monthlyBytes int64, hourlyRequests int, expiresAt time.Time) (string, TokenView, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", TokenView{}, err
+	}
+	raw := apiTokenPrefix + hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findByName(name) != nil {
+		return "", TokenView{}, ErrAPITokenExists
+	}
+	token := &APIToken{
+		Name:           name,
+		Hash:           HashAPIToken(raw),
+		MonthlyBytes:   monthlyBytes,
+		HourlyRequests: hourlyRequests,
+		ExpiresAt:      expiresAt,
+		Enabled:        true,
+		CreatedAt:      time.Now().UTC(),
+		Usage:          TokenUsage{Month: currentMonth(time.Now())},
+	}
+	s.tokens[token.Hash] = token
+	if err := s.saveLocked(); err != nil {
+		delete(s.tokens, token.Hash)
+		return "", TokenView{}, err
+	}
+	return raw, token.view(time.Now()), nil
+}
+
+// Revoke 吊销令牌，保留用量记录
+func (s *TokenStore) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := s.findByName(name)
+	if token == nil {
+		return ErrAPITokenNotFound
+	}
+	token.Enabled = false
+	return s.saveLocked()
+}
+
+// rollMonth 跨月时清零用量
+func (t *APIToken) rollMonth(now time.Time) {
+	if month := currentMonth(now); t.Usage.Month != month {
+		t.Usage = TokenUsage{Month: month}
+	}
+}
+
+func (t *APIToken) view(now time.Time) TokenView {
+	t.rollMonth(now)
+	view := TokenView{
+		Name:           t.Name,
+		MonthlyBytes:   t.MonthlyBytes,
+		HourlyRequests: t.HourlyRequests,
+		ExpiresAt:      t.ExpiresAt,
+		Enabled:        t.Enabled,
+		CreatedAt:      t.CreatedAt,
+		Usage:          t.Usage,
+		RemainingBytes: -1,
+	}
+	if t.MonthlyBytes > 0 {
+		view.RemainingBytes = max(t.MonthlyBytes-t.Usage.Bytes, 0)
+	}
+	return view
+}
+
+// resolveLocked 查找并校验令牌，调用方需持有锁
+func (s *TokenStore) resolveLocked(raw string, now time.Time) (*APIToken, error) {
+	token, exists := s.tokens[HashAPIToken(raw)]
+	if !exists {
+		return nil, ErrAPITokenInvalid
+	}
+	if !token.Enabled {
+		return nil, ErrAPITokenDisabled
+	}
+	if !token.ExpiresAt.IsZero() && now.After(token.ExpiresAt) {
+		return nil, ErrAPITokenExpired
+	}
+	return token, nil
+}
+
+// Lookup 校验令牌并返回当前状态，不计入用量
+func (s *TokenStore) Lookup(raw string) (TokenView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	token, err := s.resolveLocked(raw, now)
+	if err != nil {
+		return TokenView{}, err
+	}
+	view := token.view(now)
+	if window := s.hourly[token.Hash]; window != nil && window.hour == now.Unix()/3600 {
+		view.HourlyUsed = window.count
+	}
+	return view, nil
+}
+
+// Admit 校验令牌并检查配额和每小时请求数，通过时计入一次请求
+func (s *TokenStore) Admit(raw string) (TokenView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	token, err := s.resolveLocked(raw, now)
+	if err != nil {
+		return TokenView{}, err
+	}
+	token.rollMonth(now)
+
+	if token.MonthlyBytes > 0 && token.Usage.Bytes >= token.MonthlyBytes {
+		return token.view(now), ErrAPITokenQuota
+	}
+	if token.HourlyRequests > 0 {
+		hour := now.Unix() / 3600
+		window := s.hourly[token.Hash]
+		if window == nil || window.hour != hour {
+			window = &hourWindow{hour: hour}
+			s.hourly[token.Hash] = window
+		}
+		if window.count >= token.HourlyRequests {
+			return token.view(now), ErrAPITokenRequestLimit
+		}
+		window.count++
+	}
+
+	token.Usage.Requests++
+	s.dirty = true
+	view := token.view(now)
+	if window := s.hourly[token.Hash]; window != nil {
+		view.HourlyUsed = window.count
+	}
+	return view, nil
+}
+
+// AddBytes 累加令牌当月流量
+func (s *TokenStore) AddBytes(raw string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, exists := s.tokens[HashAPIToken(raw)]; exists {
+		token.rollMonth(time.Now())
+		token.Usage.Bytes += bytes
+		s.dirty = true
+	}
+}
+
+// List 返回全部令牌及当月用量
+func (s *TokenStore) List() []TokenView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	views := make([]TokenView, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		views = append(views, token.view(now))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
+// TokenStores 一个服务实例按配置路径打开的令牌库，路径变化时保存旧库并重新加载，
+// 同时记录各令牌已发送过的额度预警
+type TokenStores struct {
+	config     *config.Store
+	background *Background
+
+	mu         sync.Mutex
+	store      *TokenStore
+	flushStart sync.Once
+	// notified 每个令牌和配额种类最近一次发送通知的周期，同一周期只通知一次
+	notified sync.Map
+}
+
+// NewTokenStores 创建令牌库，定期保存用量和发送额度预警的后台任务随background停止
+func NewTokenStores(store *config.Store, background *Background) *TokenStores {
+	return &TokenStores{config: store, background: background}
+}
+
+// Get 返回配置的令牌库，路径变化时保存旧库并重新加载
+func (t *TokenStores) Get() (*TokenStore, error) {
+	path := t.config.Get().Auth.TokenStore
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.store != nil && t.store.path == path {
+		return t.store, nil
+	}
+	if t.store != nil {
+		if err := t.store.Flush(); err != nil {
+			fmt.Printf("保存令牌用量失败: %v\n", err)
+		}
+	}
+
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	t.store = store
+
+	t.flushStart.Do(func() {
+		ctx := t.background.Context()
+		go func() {
+			ticker := time.NewTicker(tokenFlushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					t.Flush()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	})
+	return t.store, nil
+}
+
+// Flush 保存令牌用量，停止服务前调用
+func (t *TokenStores) Flush() {
+	t.mu.Lock()
+	store := t.store
+	t.mu.Unlock()
+
+	if store != nil {
+		if err := store.Flush(); err != nil {
+			fmt.Printf("保存令牌用量失败: %v\n", err)
+		}
+	}
+}
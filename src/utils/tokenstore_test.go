@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStoreLimits(t *testing.T) {
+	store, err := LoadTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, view, err := store.Create("alice", 100, 2, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, apiTokenPrefix) || view.RemainingBytes != 100 {
+		t.Fatalf("raw = %q, view = %+v", raw, view)
+	}
+	if _, _, err := store.Create("alice", 0, 0, time.Time{}); !errors.Is(err, ErrAPITokenExists) {
+		t.Fatalf("duplicate create err = %v", err)
+	}
+	if _, err := store.Lookup("hp_unknown"); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Fatalf("unknown lookup err = %v", err)
+	}
+
+	if _, err := store.Admit(raw); err != nil {
+		t.Fatal(err)
+	}
+	store.AddBytes(raw, 60)
+	if _, err := store.Admit(raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Admit(raw); !errors.Is(err, ErrAPITokenRequestLimit) {
+		t.Fatalf("third admit err = %v, want request limit", err)
+	}
+
+	store.hourly = make(map[string]*hourWindow)
+	store.AddBytes(raw, 60)
+	if _, err := store.Admit(raw); !errors.Is(err, ErrAPITokenQuota) {
+		t.Fatalf("admit over quota err = %v", err)
+	}
+	if view, _ := store.Lookup(raw); view.RemainingBytes != 0 || view.Usage.Requests != 2 {
+		t.Fatalf("view = %+v", view)
+	}
+
+	if err := store.Revoke("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(raw); !errors.Is(err, ErrAPITokenDisabled) {
+		t.Fatalf("revoked lookup err = %v", err)
+	}
+	if err := store.Revoke("bob"); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Fatalf("revoke unknown err = %v", err)
+	}
+}
+
+func TestTokenStoreExpiry(t *testing.T) {
+	store, err := LoadTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := store.Create("bob", 0, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Admit(raw); !errors.Is(err, ErrAPITokenExpired) {
+		t.Fatalf("expired admit err = %v", err)
+	}
+}
+
+func TestTokenStorePersistsUsageHashed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := store.Create("alice", 0, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Admit(raw); err != nil {
+		t.Fatal(err)
+	}
+	store.AddBytes(raw, 1234)
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), raw) {
+		t.Fatal("token file contains raw token")
+	}
+
+	reloaded, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	view, err := reloaded.Lookup(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Name != "alice" || view.Usage.Bytes != 1234 || view.Usage.Requests != 1 || view.RemainingBytes != -1 {
+		t.Fatalf("reloaded view = %+v", view)
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 缓存结果，用于区分返回给客户端的流量中有多少需要访问上游
+const (
+	CacheHit    = "hit"
+	CacheMiss   = "miss"
+	CacheStale  = "stale"
+	CacheBypass = "bypass"
+
+	// TrafficRouteBackground 不属于任何客户端请求的上游流量(预取、后台任务等)
+	TrafficRouteBackground = "background"
+	// TrafficRouteOther 未归类的请求(管理接口等)触发的上游流量
+	TrafficRouteOther = "other"
+)
+
+// cacheOutcomeKey 请求上下文中记录缓存结果的key
+const cacheOutcomeKey = "cache_outcome"
+
+// SetCacheOutcome 标记请求的缓存结果，未标记的请求视为未使用缓存
+func SetCacheOutcome(c *gin.Context, outcome string) {
+	c.Set(cacheOutcomeKey, outcome)
+}
+
+// CacheOutcome 返回请求的缓存结果
+func CacheOutcome(c *gin.Context) string {
+	if outcome := c.GetString(cacheOutcomeKey); outcome != "" {
+		return outcome
+	}
+	return CacheBypass
+}
+
+type trafficTagKey struct{}
+
+// TrafficTag 单个客户端请求的上游流量计数，通过请求上下文传递给上游transport
+// 请求结束后仍在读取的上游流量(后台刷新等)直接计入请求最终的路由类别和缓存结果
+type TrafficTag struct {
+	mu       sync.Mutex
+	upstream int64
+	route    string
+	outcome  string
+	done     bool
+}
+
+// WithTrafficTag 返回附带流量计数的上下文
+func WithTrafficTag(ctx context.Context) (context.Context, *TrafficTag) {
+	tag := &TrafficTag{}
+	return context.WithValue(ctx, trafficTagKey{}, tag), tag
+}
+
+func trafficTagFrom(ctx context.Context) *TrafficTag {
+	tag, _ := ctx.Value(trafficTagKey{}).(*TrafficTag)
+	return tag
+}
+
+// AddUpstream 累加从上游读取的字节数，请求已结束时直接计入stats
+func (t *TrafficTag) AddUpstream(stats *StatsRegistry, n int64) {
+	t.mu.Lock()
+	if !t.done {
+		t.upstream += n
+		t.mu.Unlock()
+		return
+	}
+	route, outcome := t.route, t.outcome
+	t.mu.Unlock()
+	stats.AddUpstreamBytes(route, outcome, n)
+}
+
+// Finish 确定请求的路由类别和缓存结果，返回此前累计的上游字节数
+func (t *TrafficTag) Finish(route, outcome string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	t.route, t.outcome = route, outcome
+	return t.upstream
+}
+
+// recordUpstream 将上游流量计入请求上下文中的计数，没有计数的请求作为后台流量计入stats
+func recordUpstream(stats *StatsRegistry, ctx context.Context, n int64) {
+	if n <= 0 {
+		return
+	}
+	if tag := trafficTagFrom(ctx); tag != nil {
+		tag.AddUpstream(stats, n)
+		return
+	}
+	stats.AddUpstreamBytes(TrafficRouteBackground, CacheBypass, n)
+}
+
+// trafficTransport 统计从上游读取的响应体字节数
+type trafficTransport struct {
+	base  http.RoundTripper
+	stats *StatsRegistry
+}
+
+func (t *trafficTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &trafficBody{body: resp.Body, ctx: req.Context(), stats: t.stats}
+	return resp, nil
+}
+
+// trafficBody 按实际读取的字节数计数，客户端中途断开时只计入已读取的部分
+type trafficBody struct {
+	body  io.ReadCloser
+	ctx   context.Context
+	stats *StatsRegistry
+}
+
+func (b *trafficBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	recordUpstream(b.stats, b.ctx, int64(n))
+	return n, err
+}
+
+func (b *trafficBody) Close() error {
+	return b.body.Close()
+}
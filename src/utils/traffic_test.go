@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrafficTransportCountsUpstreamBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer server.Close()
+	stats := NewStatsRegistry()
+	client := &http.Client{Transport: &trafficTransport{base: http.DefaultTransport, stats: stats}}
+
+	fetch := func(ctx context.Context) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	ctx, tag := WithTrafficTag(context.Background())
+	fetch(ctx)
+	if got := tag.Finish("github", CacheBypass); got != 1000 {
+		t.Fatalf("upstream bytes = %d, want 1000", got)
+	}
+
+	// 请求结束后读取的上游流量直接计入请求的类别
+	before := stats.TrafficSnapshot()["github"][CacheBypass]
+	fetch(ctx)
+	after := stats.TrafficSnapshot()["github"][CacheBypass]
+	if after.UpstreamBytes-before.UpstreamBytes != 1000 || after.Requests != before.Requests {
+		t.Fatalf("late traffic = %+v, before %+v", after, before)
+	}
+
+	// 没有计数的请求计入后台流量
+	before = stats.TrafficSnapshot()[TrafficRouteBackground][CacheBypass]
+	fetch(context.Background())
+	after = stats.TrafficSnapshot()[TrafficRouteBackground][CacheBypass]
+	if after.UpstreamBytes-before.UpstreamBytes != 1000 {
+		t.Fatalf("background traffic = %+v, before %+v", after, before)
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+// sensitiveHeaders 凭据类请求头，通配规则中不允许设置为固定值，除非显式开启allowSensitive
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+}
+
+// headerRuleSet 规范化后的请求头改写规则
+type headerRuleSet struct {
+	set    map[string]string
+	add    map[string]string
+	remove []string
+}
+
+// wildcardHeaderRule 通配主机规则，suffix为空时匹配所有主机
+type wildcardHeaderRule struct {
+	suffix string
+	rules  headerRuleSet
+}
+
+// UpstreamHeaderRules 上游请求头改写规则，先应用全局规则，再应用匹配度最高的主机规则
+type UpstreamHeaderRules struct {
+	global   headerRuleSet
+	exact    map[string]headerRuleSet
+	wildcard []wildcardHeaderRule
+}
+
+// compileHeaderRuleSet 规范化请求头名称，wildcard为true时丢弃未授权的凭据类请求头
+func compileHeaderRuleSet(scope string, rules config.HeaderRules, wildcard bool) headerRuleSet {
+	compiled := headerRuleSet{
+		set: make(map[string]string, len(rules.Set)),
+		add: make(map[string]string, len(rules.Add)),
+	}
+	allowed := func(key string) bool {
+		if wildcard && sensitiveHeaders[key] && !rules.AllowSensitive {
+			fmt.Printf("警告: 上游请求头规则 %s 不允许设置 %s，需开启allowSensitive\n", scope, key)
+			return false
+		}
+		return true
+	}
+
+	for key, value := range rules.Set {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" && allowed(key) {
+			compiled.set[key] = value
+		}
+	}
+	for key, value := range rules.Add {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" && allowed(key) {
+			compiled.add[key] = value
+		}
+	}
+	for _, key := range rules.Remove {
+		if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" {
+			compiled.remove = append(compiled.remove, key)
+		}
+	}
+	return compiled
+}
+
+// CompileUpstreamHeaderRules 根据配置生成上游请求头改写规则
+// hosts中的key为主机名，"*.example.com" 匹配所有子域名，"*" 匹配所有主机
+func CompileUpstreamHeaderRules(cfg *config.AppConfig) *UpstreamHeaderRules {
+	headers := cfg.Upstream.Headers
+	compiled := &UpstreamHeaderRules{
+		global: compileHeaderRuleSet("global", config.HeaderRules{
+			Set:            headers.Set,
+			Add:            headers.Add,
+			Remove:         headers.Remove,
+			AllowSensitive: headers.AllowSensitive,
+		}, true),
+		exact: make(map[string]headerRuleSet),
+	}
+
+	for host, rules := range headers.Hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		switch {
+		case host == "*":
+			compiled.wildcard = append(compiled.wildcard, wildcardHeaderRule{rules: compileHeaderRuleSet(host, rules, true)})
+		case strings.HasPrefix(host, "*."):
+			compiled.wildcard = append(compiled.wildcard, wildcardHeaderRule{suffix: host[1:], rules: compileHeaderRuleSet(host, rules, true)})
+		case host != "":
+			compiled.exact[host] = compileHeaderRuleSet(host, rules, false)
+		}
+	}
+	// 后缀越长匹配度越高
+	sort.Slice(compiled.wildcard, func(i, j int) bool {
+		return len(compiled.wildcard[i].suffix) > len(compiled.wildcard[j].suffix)
+	})
+	return compiled
+}
+
+// apply 依次执行remove、set、add
+func (r headerRuleSet) apply(header http.Header) {
+	for _, key := range r.remove {
+		header.Del(key)
+	}
+	for key, value := range r.set {
+		header.Set(key, value)
+	}
+	for key, value := range r.add {
+		header.Add(key, value)
+	}
+}
+
+func (r headerRuleSet) empty() bool {
+	return len(r.set) == 0 && len(r.add) == 0 && len(r.remove) == 0
+}
+
+// hostRules 返回主机匹配度最高的规则：精确匹配优先，其次是最长的通配后缀
+func (r *UpstreamHeaderRules) hostRules(host string) (headerRuleSet, bool) {
+	host = strings.ToLower(host)
+	if rules, exists := r.exact[host]; exists {
+		return rules, true
+	}
+	for _, rule := range r.wildcard {
+		if rule.suffix == "" || strings.HasSuffix(host, rule.suffix) {
+			return rule.rules, true
+		}
+	}
+	return headerRuleSet{}, false
+}
+
+// Apply 对发往host的请求头应用改写规则
+func (r *UpstreamHeaderRules) Apply(host string, header http.Header) {
+	r.global.apply(header)
+	if rules, exists := r.hostRules(host); exists {
+		rules.apply(header)
+	}
+}
+
+// Empty 是否没有任何改写规则
+func (r *UpstreamHeaderRules) Empty() bool {
+	return r.global.empty() && len(r.exact) == 0 && len(r.wildcard) == 0
+}
+
+// upstreamHeaderTransport 在发往上游前按配置改写请求头，未配置规则时原样透传客户端的请求头
+type upstreamHeaderTransport struct {
+	base     http.RoundTripper
+	policies *upstreamPolicies
+}
+
+func (t *upstreamHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rules := t.policies.headers.Load()
+	if rules == nil || rules.Empty() {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTripper不能修改调用方的请求，改写副本
+	rewritten := req.Clone(req.Context())
+	rules.Apply(req.URL.Hostname(), rewritten.Header)
+	return t.base.RoundTrip(rewritten)
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestUpstreamHeaderRulesPrecedence(t *testing.T) {
+	loadTestConfig(t, `
+[upstream.headers]
+remove = ["X-Forwarded-For"]
+
+[upstream.headers.set]
+User-Agent = "hubproxy/1.0 (+https://proxy.example)"
+
+[upstream.headers.hosts."github.com".set]
+User-Agent = "hubproxy-github"
+
+[upstream.headers.hosts."*.githubusercontent.com".add]
+X-Org = "wide"
+
+[upstream.headers.hosts."*.raw.githubusercontent.com".add]
+X-Org = "narrow"
+
+[upstream.headers.hosts."registry-1.docker.io"]
+remove = ["User-Agent"]
+`)
+	rules := CompileUpstreamHeaderRules(config.GetConfig())
+
+	tests := []struct {
+		host      string
+		userAgent string
+		org       []string
+	}{
+		{"github.com", "hubproxy-github", nil},
+		{"objects.githubusercontent.com", "hubproxy/1.0 (+https://proxy.example)", []string{"wide"}},
+		{"cdn.raw.githubusercontent.com", "hubproxy/1.0 (+https://proxy.example)", []string{"narrow"}},
+		{"registry-1.docker.io", "", nil},
+		{"example.com", "hubproxy/1.0 (+https://proxy.example)", nil},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("User-Agent", "curl/8.0")
+		header.Set("X-Forwarded-For", "203.0.113.7")
+		rules.Apply(tt.host, header)
+
+		if got := header.Get("User-Agent"); got != tt.userAgent {
+			t.Errorf("%s User-Agent = %q, want %q", tt.host, got, tt.userAgent)
+		}
+		if got := header.Values("X-Org"); len(got) != len(tt.org) || (len(got) > 0 && got[0] != tt.org[0]) {
+			t.Errorf("%s X-Org = %v, want %v", tt.host, got, tt.org)
+		}
+		if header.Get("X-Forwarded-For") != "" {
+			t.Errorf("%s X-Forwarded-For not removed", tt.host)
+		}
+	}
+}
+
+func TestUpstreamHeaderRulesSensitive(t *testing.T) {
+	loadTestConfig(t, `
+[upstream.headers.add]
+Authorization = "Bearer global"
+
+[upstream.headers.hosts."*.example.com".set]
+Cookie = "session=wild"
+
+[upstream.headers.hosts."*.trusted.example"]
+allowSensitive = true
+[upstream.headers.hosts."*.trusted.example".set]
+Authorization = "Bearer trusted"
+
+[upstream.headers.hosts."api.internal".set]
+Authorization = "Bearer exact"
+`)
+	rules := CompileUpstreamHeaderRules(config.GetConfig())
+
+	tests := []struct {
+		host, header, want string
+	}{
+		{"other.host", "Authorization", ""},
+		{"a.example.com", "Cookie", ""},
+		{"a.trusted.example", "Authorization", "Bearer trusted"},
+		{"api.internal", "Authorization", "Bearer exact"},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		rules.Apply(tt.host, header)
+		if got := header.Get(tt.header); got != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.host, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamHeaderTransport(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer server.Close()
+
+	var clients *HTTPClients
+	get := func() http.Header {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("User-Agent", "docker/27.0")
+		resp, err := clients.Global().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if req.Header.Get("User-Agent") != "docker/27.0" {
+			t.Fatal("transport modified the caller's request")
+		}
+		return <-received
+	}
+
+	clients = loadTimeoutConfig(t, "")
+	if got := get().Get("User-Agent"); got != "docker/27.0" {
+		t.Fatalf("default User-Agent = %q, want client UA passed through", got)
+	}
+
+	clients = loadTimeoutConfig(t, `
+[upstream.headers.hosts."127.0.0.1".set]
+User-Agent = "hubproxy/1.0"
+`)
+	if got := get().Get("User-Agent"); got != "hubproxy/1.0" {
+		t.Fatalf("overridden User-Agent = %q", got)
+	}
+}
@@ -0,0 +1,416 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// upstreamCooldownFactor 上游返回限流响应后，冷却期内的速率为配置速率的比例
+	upstreamCooldownFactor = 0.25
+	// upstreamCooldownRate 未限制速率的主机在冷却期内使用的速率
+	upstreamCooldownRate = 1.0
+)
+
+// UpstreamBudgetError 发往上游主机的请求超出出站预算，排队超时后被拒绝
+type UpstreamBudgetError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamBudgetError) Error() string {
+	return fmt.Sprintf("上游 %s 的出站请求预算已用尽", e.Host)
+}
+
+// UpstreamBudgetExceeded 判断错误是否由上游出站预算不足引起
+func UpstreamBudgetExceeded(err error) (*UpstreamBudgetError, bool) {
+	var budgetErr *UpstreamBudgetError
+	if errors.As(err, &budgetErr) {
+		return budgetErr, true
+	}
+	return nil, false
+}
+
+// RetryUpstreamBudget 错误由上游出站预算不足引起时设置Retry-After并返回该错误，否则返回nil
+func RetryUpstreamBudget(c *gin.Context, err error) *UpstreamBudgetError {
+	budgetErr, exceeded := UpstreamBudgetExceeded(err)
+	if !exceeded {
+		return nil
+	}
+	c.Header("Retry-After", strconv.Itoa(max(int(budgetErr.RetryAfter.Seconds()), 1)))
+	return budgetErr
+}
+
+// UpstreamBudgetStats 单个上游主机的出站预算使用情况
+type UpstreamBudgetStats struct {
+	Host string `json:"host"`
+	// RequestsPerSecond 当前生效的速率，冷却期内低于配置值，0表示不限制
+	RequestsPerSecond float64    `json:"requests_per_second"`
+	Burst             int        `json:"burst"`
+	MaxConcurrent     int        `json:"max_concurrent"`
+	InFlight          int64      `json:"in_flight"`
+	Utilization       float64    `json:"utilization"`
+	Allowed           uint64     `json:"allowed"`
+	Rejected          uint64     `json:"rejected"`
+	Throttled         uint64     `json:"throttled"`
+	CooldownUntil     *time.Time `json:"cooldown_until,omitempty"`
+}
+
+// hostLimit 合并默认值后的主机限制，0表示不限制
+type hostLimit struct {
+	rate          float64
+	burst         int
+	maxConcurrent int
+}
+
+// resolveUpstreamLimit 用主机配置覆盖默认值，0使用默认值，负数表示不限制
+func resolveUpstreamLimit(defaults, override config.UpstreamLimit) hostLimit {
+	pick := func(value, fallback float64) float64 {
+		if value == 0 {
+			value = fallback
+		}
+		return max(value, 0)
+	}
+	limit := hostLimit{
+		rate:          pick(override.RequestsPerSecond, defaults.RequestsPerSecond),
+		burst:         int(pick(float64(override.Burst), float64(defaults.Burst))),
+		maxConcurrent: int(pick(float64(override.MaxConcurrent), float64(defaults.MaxConcurrent))),
+	}
+	if limit.rate > 0 && limit.burst == 0 {
+		limit.burst = max(int(limit.rate), 1)
+	}
+	return limit
+}
+
+// upstreamBudget 单个上游主机的出站预算，速率用令牌桶限制，并发用信号量限制
+type upstreamBudget struct {
+	host    string
+	limit   hostLimit
+	limiter *rate.Limiter
+	slots   chan struct{}
+
+	mu            sync.Mutex
+	cooldownUntil time.Time
+	tightened     bool
+
+	inFlight  atomic.Int64
+	allowed   atomic.Uint64
+	rejected  atomic.Uint64
+	throttled atomic.Uint64
+}
+
+func newUpstreamBudget(host string, limit hostLimit) *upstreamBudget {
+	b := &upstreamBudget{host: host, limit: limit, limiter: rate.NewLimiter(rate.Inf, 1)}
+	if limit.rate > 0 {
+		b.limiter = rate.NewLimiter(rate.Limit(limit.rate), limit.burst)
+	}
+	if limit.maxConcurrent > 0 {
+		b.slots = make(chan struct{}, limit.maxConcurrent)
+	}
+	return b
+}
+
+// refreshCooldown 进入或离开冷却期时调整令牌桶速率
+func (b *upstreamBudget) refreshCooldown(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cooling := now.Before(b.cooldownUntil)
+	if cooling == b.tightened {
+		return
+	}
+	b.tightened = cooling
+	if cooling {
+		tightened := upstreamCooldownRate
+		if b.limit.rate > 0 {
+			tightened = b.limit.rate * upstreamCooldownFactor
+		}
+		b.limiter.SetLimitAt(now, rate.Limit(tightened))
+		b.limiter.SetBurstAt(now, 1)
+		return
+	}
+	if b.limit.rate > 0 {
+		b.limiter.SetLimitAt(now, rate.Limit(b.limit.rate))
+		b.limiter.SetBurstAt(now, b.limit.burst)
+	} else {
+		b.limiter.SetLimitAt(now, rate.Inf)
+	}
+}
+
+// reject 记录一次被拒绝的请求，冷却期内建议在冷却结束后重试
+func (b *upstreamBudget) reject(now time.Time) error {
+	b.rejected.Add(1)
+	retryAfter := time.Second
+	b.mu.Lock()
+	if wait := b.cooldownUntil.Sub(now); wait > retryAfter {
+		retryAfter = wait
+	}
+	b.mu.Unlock()
+	return &UpstreamBudgetError{Host: b.host, RetryAfter: retryAfter}
+}
+
+// acquire 在queueTimeout内等待速率令牌和并发名额，超时返回*UpstreamBudgetError
+func (b *upstreamBudget) acquire(ctx context.Context, queueTimeout time.Duration) error {
+	now := time.Now()
+	b.refreshCooldown(now)
+	deadline := now.Add(queueTimeout)
+
+	// 排队时间内拿不到令牌时直接拒绝，不占用令牌
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() || reservation.DelayFrom(now) > queueTimeout {
+		reservation.CancelAt(now)
+		return b.reject(now)
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			reservation.Cancel()
+			return ctx.Err()
+		}
+	}
+
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return b.reject(time.Now())
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case b.slots <- struct{}{}:
+			case <-timer.C:
+				return b.reject(time.Now())
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	b.inFlight.Add(1)
+	b.allowed.Add(1)
+	return nil
+}
+
+// release 释放并发名额
+func (b *upstreamBudget) release() {
+	b.inFlight.Add(-1)
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// observe 上游返回限流响应时进入冷却期，冷却时长取配置值和Retry-After中较大者
+func (b *upstreamBudget) observe(resp *http.Response, cooldown time.Duration) {
+	if !UpstreamThrottled(resp) {
+		return
+	}
+	b.throttled.Add(1)
+	if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > cooldown {
+		cooldown = retryAfter
+	}
+	until := time.Now().Add(cooldown)
+
+	b.mu.Lock()
+	if until.After(b.cooldownUntil) {
+		b.cooldownUntil = until
+	}
+	b.mu.Unlock()
+	fmt.Printf("上游 %s 返回限流响应 %d，%s 内降低请求速率\n", b.host, resp.StatusCode, cooldown)
+}
+
+// UpstreamThrottled 判断是否为上游的限流响应：429，或带Retry-After、
+// GitHub限额耗尽(X-RateLimit-Remaining: 0)的403
+func UpstreamThrottled(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// parseRetryAfter 解析秒数或HTTP日期格式的Retry-After
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// snapshot 返回当前使用情况，利用率取令牌桶和并发名额中较高者
+func (b *upstreamBudget) snapshot(now time.Time) UpstreamBudgetStats {
+	b.refreshCooldown(now)
+	stats := UpstreamBudgetStats{
+		Host:          b.host,
+		MaxConcurrent: b.limit.maxConcurrent,
+		InFlight:      b.inFlight.Load(),
+		Allowed:       b.allowed.Load(),
+		Rejected:      b.rejected.Load(),
+		Throttled:     b.throttled.Load(),
+	}
+	if limit := b.limiter.Limit(); limit != rate.Inf {
+		stats.RequestsPerSecond = float64(limit)
+		stats.Burst = b.limiter.Burst()
+		tokens := max(b.limiter.TokensAt(now), 0)
+		stats.Utilization = 1 - min(tokens/float64(stats.Burst), 1)
+	}
+	if stats.MaxConcurrent > 0 {
+		stats.Utilization = max(stats.Utilization, float64(stats.InFlight)/float64(stats.MaxConcurrent))
+	}
+
+	b.mu.Lock()
+	if now.Before(b.cooldownUntil) {
+		until := b.cooldownUntil
+		stats.CooldownUntil = &until
+	}
+	b.mu.Unlock()
+	return stats
+}
+
+// upstreamBudgets 所有上游主机的出站预算，配置重载后整体替换
+type upstreamBudgets struct {
+	enabled      bool
+	defaults     config.UpstreamLimit
+	hosts        map[string]config.UpstreamLimit
+	queueTimeout time.Duration
+	cooldown     time.Duration
+
+	mu      sync.Mutex
+	budgets map[string]*upstreamBudget
+}
+
+func newUpstreamBudgets(cfg *config.AppConfig) *upstreamBudgets {
+	limits := cfg.Upstream.Limits
+	budgets := &upstreamBudgets{
+		enabled: limits.Enabled,
+		defaults: config.UpstreamLimit{
+			RequestsPerSecond: limits.RequestsPerSecond,
+			Burst:             limits.Burst,
+			MaxConcurrent:     limits.MaxConcurrent,
+		},
+		hosts:        make(map[string]config.UpstreamLimit, len(limits.Hosts)),
+		queueTimeout: ParseTimeout(limits.QueueTimeout, 3*time.Second),
+		cooldown:     ParseTimeout(limits.Cooldown, time.Minute),
+		budgets:      make(map[string]*upstreamBudget),
+	}
+	for host, limit := range limits.Hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			budgets.hosts[host] = limit
+		}
+	}
+	return budgets
+}
+
+// budget 返回主机的出站预算，首次访问时创建
+func (u *upstreamBudgets) budget(host string) *upstreamBudget {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if budget, exists := u.budgets[host]; exists {
+		return budget
+	}
+	budget := newUpstreamBudget(host, resolveUpstreamLimit(u.defaults, u.hosts[host]))
+	u.budgets[host] = budget
+	return budget
+}
+
+// UpstreamBudgetSnapshot 返回已访问过的上游主机的出站预算使用情况，未启用时返回nil
+func (h *HTTPClients) UpstreamBudgetSnapshot() []UpstreamBudgetStats {
+	budgets := h.policies.budgets.Load()
+	if budgets == nil || !budgets.enabled {
+		return nil
+	}
+
+	budgets.mu.Lock()
+	list := make([]*upstreamBudget, 0, len(budgets.budgets))
+	for _, budget := range budgets.budgets {
+		list = append(list, budget)
+	}
+	budgets.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]UpstreamBudgetStats, 0, len(list))
+	for _, budget := range list {
+		stats = append(stats, budget.snapshot(now))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// upstreamBudgetTransport 按上游主机限制出站请求的速率和并发，并发名额在响应体关闭时释放
+type upstreamBudgetTransport struct {
+	base     http.RoundTripper
+	policies *upstreamPolicies
+}
+
+func (t *upstreamBudgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	budgets := t.policies.budgets.Load()
+	if budgets == nil || !budgets.enabled {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil {
+			t.policies.recordThrottle(req.URL.Hostname(), resp)
+		}
+		return resp, err
+	}
+
+	budget := budgets.budget(strings.ToLower(req.URL.Hostname()))
+	if err := budget.acquire(req.Context(), budgets.queueTimeout); err != nil {
+		// RoundTripper出错时需要关闭请求体
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		budget.release()
+		return nil, err
+	}
+	budget.observe(resp, budgets.cooldown)
+	t.policies.recordThrottle(req.URL.Hostname(), resp)
+	resp.Body = &budgetBody{body: resp.Body, release: budget.release}
+	return resp, nil
+}
+
+// budgetBody 响应体关闭时释放并发名额，多次关闭只释放一次
+type budgetBody struct {
+	body    io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *budgetBody) Read(p []byte) (int, error) {
+	return b.body.Read(p)
+}
+
+func (b *budgetBody) Close() error {
+	b.once.Do(b.release)
+	return b.body.Close()
+}
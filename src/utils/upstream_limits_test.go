@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/7alva7/hubproxy/src/internal/config"
+)
+
+func TestResolveUpstreamLimit(t *testing.T) {
+	defaults := config.UpstreamLimit{RequestsPerSecond: 20, Burst: 40, MaxConcurrent: 8}
+	tests := []struct {
+		override config.UpstreamLimit
+		want     hostLimit
+	}{
+		{config.UpstreamLimit{}, hostLimit{rate: 20, burst: 40, maxConcurrent: 8}},
+		{config.UpstreamLimit{RequestsPerSecond: 5}, hostLimit{rate: 5, burst: 40, maxConcurrent: 8}},
+		{config.UpstreamLimit{RequestsPerSecond: -1, MaxConcurrent: -1}, hostLimit{burst: 40}},
+	}
+	for _, tt := range tests {
+		if got := resolveUpstreamLimit(defaults, tt.override); got != tt.want {
+			t.Errorf("resolveUpstreamLimit(%+v) = %+v, want %+v", tt.override, got, tt.want)
+		}
+	}
+	if got := resolveUpstreamLimit(config.UpstreamLimit{RequestsPerSecond: 3}, config.UpstreamLimit{}); got.burst != 3 {
+		t.Errorf("burst without config = %d, want rate", got.burst)
+	}
+}
+
+func TestUpstreamBudgetConcurrency(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	loadTestConfig(t, `
+[upstream.limits]
+enabled = true
+requestsPerSecond = -1
+maxConcurrent = 1
+queueTimeout = "20ms"
+`)
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+
+	first, err := clients.Global().Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.Global().Get(upstream.URL); err == nil {
+		t.Fatal("second request allowed while first response body is open")
+	} else if _, exceeded := UpstreamBudgetExceeded(err); !exceeded {
+		t.Fatalf("err = %v, want budget error", err)
+	}
+
+	first.Body.Close()
+	first.Body.Close()
+	second, err := clients.Global().Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("request after release: %v", err)
+	}
+	second.Body.Close()
+	if stats := clients.UpstreamBudgetSnapshot(); stats[0].InFlight != 0 {
+		t.Fatalf("in flight = %d after close", stats[0].InFlight)
+	}
+}
+
+func TestUpstreamBudgetCooldown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	loadTestConfig(t, `
+[upstream.limits]
+enabled = true
+requestsPerSecond = 8
+burst = 8
+queueTimeout = "10ms"
+cooldown = "30s"
+`)
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+
+	resp, err := clients.Global().Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	stats := clients.UpstreamBudgetSnapshot()
+	if len(stats) != 1 || stats[0].Throttled != 1 || stats[0].RequestsPerSecond != 2 || stats[0].Burst != 1 {
+		t.Fatalf("stats after 429 = %+v", stats)
+	}
+	if stats[0].CooldownUntil == nil || time.Until(*stats[0].CooldownUntil) < 100*time.Second {
+		t.Fatalf("cooldown should follow Retry-After: %+v", stats[0].CooldownUntil)
+	}
+
+	// 冷却期内突发容量降为1，连续的第二个请求被拒绝并建议在冷却结束后重试
+	resp, err = clients.Global().Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, err = clients.Global().Get(upstream.URL)
+	budgetErr, exceeded := UpstreamBudgetExceeded(err)
+	if !exceeded || budgetErr.RetryAfter < 100*time.Second {
+		t.Fatalf("err = %v, want budget error with retry after cooldown", err)
+	}
+}
+
+func TestUpstreamBudgetDisabled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	loadTestConfig(t, `
+[upstream.limits]
+enabled = false
+requestsPerSecond = 0.01
+burst = 1
+`)
+	clients := NewHTTPClients(testStore(), NewStatsRegistry())
+	for range 3 {
+		resp, err := clients.Global().Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if stats := clients.UpstreamBudgetSnapshot(); stats != nil {
+		t.Fatalf("stats = %+v, want nil when disabled", stats)
+	}
+}
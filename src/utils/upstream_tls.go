@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UpstreamCertificate 上游出示的证书链中的一张证书
+type UpstreamCertificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Expired   bool      `json:"expired"`
+}
+
+// UpstreamTLSInfo 最近一次与上游主机TLS握手的结果，Certificates为上游出示的证书链，
+// 握手因证书校验失败时同样记录，便于识别网络中的TLS拦截
+type UpstreamTLSInfo struct {
+	Host         string                `json:"host"`
+	Time         time.Time             `json:"time"`
+	Version      string                `json:"version,omitempty"`
+	CipherSuite  string                `json:"cipher_suite,omitempty"`
+	ServerName   string                `json:"server_name,omitempty"`
+	ALPN         string                `json:"alpn,omitempty"`
+	Verified     bool                  `json:"verified"`
+	SkipVerify   bool                  `json:"skip_verify"`
+	Certificates []UpstreamCertificate `json:"certificates,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// UpstreamTLSSnapshot 返回各上游主机最近一次TLS握手的信息，按主机名排序
+func (h *HTTPClients) UpstreamTLSSnapshot() []UpstreamTLSInfo {
+	var result []UpstreamTLSInfo
+	h.policies.tls.Range(func(_, value interface{}) bool {
+		result = append(result, *value.(*UpstreamTLSInfo))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+	return result
+}
+
+// upstreamTLSConfig 返回上游连接的TLS配置，配置了upstream.tls.caBundle时在系统根证书之外信任其中的CA
+func upstreamTLSConfig(bundle string) *tls.Config {
+	if bundle == "" {
+		return nil
+	}
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		fmt.Printf("警告: 无法读取 upstream.tls.caBundle，只使用系统根证书: %v\n", err)
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		fmt.Printf("警告: upstream.tls.caBundle 中没有有效的PEM证书，只使用系统根证书: %s\n", bundle)
+		return nil
+	}
+	return &tls.Config{RootCAs: pool}
+}
+
+// tlsTraceTransport 通过httptrace记录每次上游TLS握手的版本、套件和证书链，握手失败时记录调试日志
+type tlsTraceTransport struct {
+	base     *http.Transport
+	policies *upstreamPolicies
+}
+
+func (t *tlsTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.base.RoundTrip(req)
+	}
+	host := req.URL.Hostname()
+	skipVerify := t.base.TLSClientConfig != nil && t.base.TLSClientConfig.InsecureSkipVerify
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.policies.recordTLS(host, state, err, skipVerify)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			// 复用的连接不再握手，没有记录过时补记一次，例如启动前已建立的连接
+			if !info.Reused {
+				return
+			}
+			if conn, ok := info.Conn.(*tls.Conn); ok {
+				if _, exists := t.policies.tls.Load(host); !exists {
+					t.policies.recordTLS(host, conn.ConnectionState(), nil, skipVerify)
+				}
+			}
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// recordTLS 保存一次握手结果，证书校验失败时从错误中取出上游出示的证书链
+func (p *upstreamPolicies) recordTLS(host string, state tls.ConnectionState, err error, skipVerify bool) {
+	info := &UpstreamTLSInfo{
+		Host:       host,
+		Time:       time.Now(),
+		ServerName: state.ServerName,
+		ALPN:       state.NegotiatedProtocol,
+		Verified:   err == nil && len(state.VerifiedChains) > 0,
+		SkipVerify: skipVerify,
+	}
+	if state.Version != 0 {
+		info.Version = tls.VersionName(state.Version)
+		info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	}
+	certs := state.PeerCertificates
+	if err != nil {
+		info.Error = err.Error()
+		var verifyErr *tls.CertificateVerificationError
+		if len(certs) == 0 && errors.As(err, &verifyErr) {
+			certs = verifyErr.UnverifiedCertificates
+		}
+	}
+	for _, cert := range certs {
+		info.Certificates = append(info.Certificates, UpstreamCertificate{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Expired:   info.Time.After(cert.NotAfter),
+		})
+	}
+	p.tls.Store(host, info)
+
+	if err != nil {
+		Logf(LogDebug, "tls "+host, "上游TLS握手失败: %s: %v (%s)", host, err, info.summary())
+	}
+}
+
+// summary 返回握手信息的单行摘要，用于日志
+func (info *UpstreamTLSInfo) summary() string {
+	parts := []string{}
+	if info.Version != "" {
+		parts = append(parts, info.Version+" "+info.CipherSuite)
+	}
+	if info.SkipVerify {
+		parts = append(parts, "跳过证书校验")
+	}
+	for _, cert := range info.Certificates {
+		parts = append(parts, fmt.Sprintf("证书 %s, 签发者 %s, 到期 %s", cert.Subject, cert.Issuer, cert.NotAfter.Format(time.DateOnly)))
+	}
+	if len(parts) == 0 {
+		return "上游未出示证书"
+	}
+	return strings.Join(parts, "; ")
+}
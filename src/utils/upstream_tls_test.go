@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamTLSRecordsHandshakes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	lookup := func(clients *HTTPClients) UpstreamTLSInfo {
+		t.Helper()
+		for _, info := range clients.UpstreamTLSSnapshot() {
+			if info.Host == "127.0.0.1" {
+				return info
+			}
+		}
+		t.Fatal("handshake not recorded")
+		return UpstreamTLSInfo{}
+	}
+
+	// 服务端证书不受系统信任，握手失败时仍记录上游出示的证书
+	clients := loadTimeoutConfig(t, "")
+	if resp, err := clients.Metadata().Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("untrusted certificate was accepted")
+	}
+	info := lookup(clients)
+	if info.Verified || info.Error == "" || len(info.Certificates) == 0 || !strings.Contains(info.Certificates[0].Subject, "Acme Co") {
+		t.Fatalf("failed handshake = %+v", info)
+	}
+
+	// 通过upstream.tls.caBundle信任自定义CA
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients = loadTimeoutConfig(t, "[upstream.tls]\ncaBundle = '"+bundle+"'\n")
+	for _, client := range []*http.Client{clients.Metadata(), clients.Search()} {
+		clients.policies.tls.Delete("127.0.0.1")
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		info = lookup(clients)
+		if !info.Verified || info.Error != "" || info.SkipVerify || info.Version == "" || info.CipherSuite == "" ||
+			len(info.Certificates) != 1 || info.Certificates[0].Expired {
+			t.Fatalf("verified handshake = %+v", info)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 用量统计维度
+const (
+	UsageByIP    = "ip"
+	UsageByRepo  = "repo"
+	UsageByImage = "image"
+)
+
+// 用量排序方式
+const (
+	UsageSortBytes    = "bytes"
+	UsageSortRequests = "requests"
+)
+
+// usageCapacity 每个时间桶每个维度最多跟踪的key数量，超出后按Space-Saving算法替换最小项
+const usageCapacity = 256
+
+// usageEntry 跟踪项，被替换时新项继承旧计数，因此计数可能偏高但不会遗漏真正的高频项
+type usageEntry struct {
+	key      string
+	bytes    int64
+	requests int64
+	index    int
+}
+
+// spaceSaving 有界的高频项统计，map定位加最小堆淘汰
+type spaceSaving struct {
+	byBytes bool
+	entries map[string]*usageEntry
+	heap    []*usageEntry
+}
+
+func newSpaceSaving(byBytes bool) *spaceSaving {
+	return &spaceSaving{byBytes: byBytes, entries: make(map[string]*usageEntry)}
+}
+
+func (s *spaceSaving) weight(e *usageEntry) int64 {
+	if s.byBytes {
+		return e.bytes
+	}
+	return e.requests
+}
+
+func (s *spaceSaving) Len() int           { return len(s.heap) }
+func (s *spaceSaving) Less(i, j int) bool { return s.weight(s.heap[i]) < s.weight(s.heap[j]) }
+func (s *spaceSaving) Swap(i, j int) {
+	s.heap[i], s.heap[j] = s.heap[j], s.heap[i]
+	s.heap[i].index = i
+	s.heap[j].index = j
+}
+func (s *spaceSaving) Push(x interface{}) {
+	entry := x.(*usageEntry)
+	entry.index = len(s.heap)
+	s.heap = append(s.heap, entry)
+}
+func (s *spaceSaving) Pop() interface{} {
+	last := s.heap[len(s.heap)-1]
+	s.heap = s.heap[:len(s.heap)-1]
+	return last
+}
+
+func (s *spaceSaving) add(key string, bytes int64) {
+	if entry, exists := s.entries[key]; exists {
+		entry.bytes += bytes
+		entry.requests++
+		heap.Fix(s, entry.index)
+		return
+	}
+
+	if len(s.heap) < usageCapacity {
+		entry := &usageEntry{key: key, bytes: bytes, requests: 1}
+		s.entries[key] = entry
+		heap.Push(s, entry)
+		return
+	}
+
+	// 替换计数最小的项，新项继承其计数
+	smallest := s.heap[0]
+	delete(s.entries, smallest.key)
+	smallest.key = key
+	smallest.bytes += bytes
+	smallest.requests++
+	s.entries[key] = smallest
+	heap.Fix(s, 0)
+}
+
+func (s *spaceSaving) reset() {
+	s.entries = make(map[string]*usageEntry)
+	s.heap = s.heap[:0]
+}
+
+// usageSlot 单个时间桶，分别按字节数和请求数维护高频项
+type usageSlot struct {
+	mu         sync.Mutex
+	epoch      int64
+	byBytes    *spaceSaving
+	byRequests *spaceSaving
+}
+
+// usageDimension 单个维度的轮转时间桶
+type usageDimension struct {
+	slots [statsSlotCount]*usageSlot
+}
+
+func newUsageDimension() *usageDimension {
+	d := &usageDimension{}
+	for i := range d.slots {
+		d.slots[i] = &usageSlot{epoch: -1, byBytes: newSpaceSaving(true), byRequests: newSpaceSaving(false)}
+	}
+	return d
+}
+
+func (d *usageDimension) record(now time.Time, key string, bytes int64) {
+	epoch := statsEpoch(now)
+	slot := d.slots[epoch%statsSlotCount]
+
+	slot.mu.Lock()
+	if slot.epoch != epoch {
+		slot.byBytes.reset()
+		slot.byRequests.reset()
+		slot.epoch = epoch
+	}
+	slot.byBytes.add(key, bytes)
+	slot.byRequests.add(key, bytes)
+	slot.mu.Unlock()
+}
+
+// UsageEntry 用量排行项
+type UsageEntry struct {
+	Key      string `json:"key"`
+	Bytes    int64  `json:"bytes"`
+	Requests int64  `json:"requests"`
+}
+
+func (d *usageDimension) top(now time.Time, window time.Duration, sortBy string, limit int) []UsageEntry {
+	current := statsEpoch(now)
+	slots := int64(min((window+statsSlotDuration-1)/statsSlotDuration, statsSlotCount))
+
+	totals := make(map[string]*UsageEntry)
+	for _, slot := range d.slots {
+		slot.mu.Lock()
+		if slot.epoch > current-slots && slot.epoch <= current {
+			summary := slot.byBytes
+			if sortBy == UsageSortRequests {
+				summary = slot.byRequests
+			}
+			for key, entry := range summary.entries {
+				total, exists := totals[key]
+				if !exists {
+					total = &UsageEntry{Key: key}
+					totals[key] = total
+				}
+				total.Bytes += entry.bytes
+				total.Requests += entry.requests
+			}
+		}
+		slot.mu.Unlock()
+	}
+
+	entries := make([]UsageEntry, 0, len(totals))
+	for _, total := range totals {
+		entries = append(entries, *total)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if sortBy == UsageSortRequests && entries[i].Requests != entries[j].Requests {
+			return entries[i].Requests > entries[j].Requests
+		}
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// UsageTracker 按客户端IP、GitHub仓库和Docker镜像统计流量排行，内存占用与流量无关
+type UsageTracker struct {
+	dimensions map[string]*usageDimension
+}
+
+// NewUsageTracker 创建用量排行统计
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{dimensions: map[string]*usageDimension{
+		UsageByIP:    newUsageDimension(),
+		UsageByRepo:  newUsageDimension(),
+		UsageByImage: newUsageDimension(),
+	}}
+}
+
+// IsDimension 是否为支持的统计维度
+func (t *UsageTracker) IsDimension(by string) bool {
+	_, exists := t.dimensions[by]
+	return exists
+}
+
+// Record 记录一次请求的用量，key为空时忽略
+func (t *UsageTracker) Record(by, key string, bytes int64) {
+	t.recordAt(time.Now(), by, key, bytes)
+}
+
+func (t *UsageTracker) recordAt(now time.Time, by, key string, bytes int64) {
+	if key == "" {
+		return
+	}
+	if dimension, exists := t.dimensions[by]; exists {
+		dimension.record(now, key, bytes)
+	}
+}
+
+// Top 返回window内用量最高的limit项
+func (t *UsageTracker) Top(by string, window time.Duration, sortBy string, limit int) []UsageEntry {
+	return t.topAt(time.Now(), by, window, sortBy, limit)
+}
+
+func (t *UsageTracker) topAt(now time.Time, by string, window time.Duration, sortBy string, limit int) []UsageEntry {
+	dimension, exists := t.dimensions[by]
+	if !exists {
+		return nil
+	}
+	return dimension.top(now, window, sortBy, limit)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsageHeavyHittersSurviveEviction(t *testing.T) {
+	tracker := NewUsageTracker()
+	now := time.Now()
+
+	// 大量只出现一次的key与少数高频key交错
+	for i := 0; i < 5000; i++ {
+		tracker.recordAt(now, UsageByIP, fmt.Sprintf("10.0.%d.%d", i/256, i%256), 1024)
+		if i%10 == 0 {
+			tracker.recordAt(now, UsageByIP, "192.168.1.0", 1<<20)
+		}
+		if i%25 == 0 {
+			tracker.recordAt(now, UsageByIP, "172.16.0.0", 1<<20)
+		}
+	}
+
+	top := tracker.topAt(now, UsageByIP, time.Hour, UsageSortBytes, 2)
+	if len(top) != 2 || top[0].Key != "192.168.1.0" || top[1].Key != "172.16.0.0" {
+		t.Fatalf("top by bytes = %+v", top)
+	}
+
+	top = tracker.topAt(now, UsageByIP, time.Hour, UsageSortRequests, 1)
+	if len(top) != 1 || top[0].Key != "192.168.1.0" || top[0].Requests < 500 {
+		t.Fatalf("top by requests = %+v", top)
+	}
+}
+
+func TestUsageWindow(t *testing.T) {
+	tracker := NewUsageTracker()
+	now := time.Now()
+
+	tracker.recordAt(now.Add(-30*time.Minute), UsageByRepo, "old/repo", 100)
+	tracker.recordAt(now, UsageByRepo, "new/repo", 10)
+
+	top := tracker.topAt(now, UsageByRepo, 10*time.Minute, UsageSortBytes, 10)
+	if len(top) != 1 || top[0].Key != "new/repo" {
+		t.Fatalf("10m window = %+v", top)
+	}
+
+	top = tracker.topAt(now, UsageByRepo, time.Hour, UsageSortBytes, 10)
+	if len(top) != 2 || top[0].Key != "old/repo" {
+		t.Fatalf("1h window = %+v", top)
+	}
+
+	// 超过一小时的数据被轮转覆盖
+	top = tracker.topAt(now.Add(2*time.Hour), UsageByRepo, time.Hour, UsageSortBytes, 10)
+	if len(top) != 0 {
+		t.Fatalf("expired window = %+v", top)
+	}
+}
+
+func TestUsageIgnoresEmptyKeyAndUnknownDimension(t *testing.T) {
+	tracker := NewUsageTracker()
+	tracker.Record(UsageByImage, "", 100)
+	tracker.Record("bogus", "key", 100)
+
+	if top := tracker.Top(UsageByImage, time.Hour, UsageSortBytes, 10); len(top) != 0 {
+		t.Fatalf("empty key recorded: %+v", top)
+	}
+	if tracker.IsDimension("bogus") || !tracker.IsDimension(UsageByImage) {
+		t.Fatal("IsDimension mismatch")
+	}
+}